@@ -0,0 +1,217 @@
+package main
+
+import (
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bandwidth sharing. P2P_BANDWIDTH_LIMIT caps what this peer's uploads and
+// downloads move in total, and the scheduler splits it between the
+// transfers wanting bandwidth at the same time, so three downloads under
+// 10MB/s get about 3.3MB/s each rather than racing for it.
+
+// bandwidthQuantum is how many bytes a transfer of weight 1 takes from
+// the scheduler per read or write, its turn in the round-robin.
+const bandwidthQuantum = 16 * 1024
+
+// bandwidthTick is how often the scheduler refills and serves waiting
+// transfers once the tokens have run out.
+const bandwidthTick = 10 * time.Millisecond
+
+// bandwidthLimit returns P2P_BANDWIDTH_LIMIT in bytes per second, such as
+// "10MB/s" or "512KB". 0 (the default, or if it can't be parsed) means
+// unlimited.
+func bandwidthLimit() int64 {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(os.Getenv("P2P_BANDWIDTH_LIMIT"))), "/s")
+	if s == "" {
+		return 0
+	}
+	n, err := parseByteSize(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// bandwidthRequest is one transfer waiting for tokens.
+type bandwidthRequest struct {
+	tag     float64 // the flow's virtual time when its turn starts
+	seq     uint64  // breaks ties in tag, first come first served
+	need    int64
+	granted int64
+	done    chan struct{} // closed once granted reaches need
+}
+
+// BandwidthFlow is one transfer's share of a scheduler.
+type BandwidthFlow struct {
+	s      *TokenBucketScheduler
+	weight int
+	finish float64 // virtual time its last request ends at
+}
+
+// TokenBucketScheduler hands out a byte rate to the transfers asking for
+// it with a weighted round-robin. Each request is tagged with a virtual
+// time: its flow's bytes so far divided by the flow's weight, caught up to
+// the request being served when the flow was idle. Waiting requests are
+// served lowest tag first, so flows that keep asking take turns, and each
+// gets a share of the rate proportional to its weight however the
+// goroutines happen to line up. A zero rate grants everything at once.
+type TokenBucketScheduler struct {
+	mu      sync.Mutex
+	rate    float64 // bytes per second
+	burst   float64 // most tokens kept while nobody is waiting
+	tokens  float64
+	last    time.Time // when tokens was last refilled
+	now     func() time.Time
+	vtime   float64 // tag of the request being served
+	seq     uint64
+	waiting []*bandwidthRequest
+
+	start sync.Once
+}
+
+// bandwidth is shared by every chunk upload and download of this peer.
+var bandwidth = NewTokenBucketScheduler(bandwidthLimit())
+
+// NewTokenBucketScheduler returns a scheduler for bytesPerSec; 0 leaves
+// transfers unlimited.
+func NewTokenBucketScheduler(bytesPerSec int64) *TokenBucketScheduler {
+	rate := float64(bytesPerSec)
+	return &TokenBucketScheduler{
+		rate:  rate,
+		burst: max(rate/10, bandwidthQuantum),
+		now:   time.Now,
+	}
+}
+
+// Flow starts a transfer of weight, 1 or more.
+func (s *TokenBucketScheduler) Flow(weight int) *BandwidthFlow {
+	return &BandwidthFlow{s: s, weight: max(weight, 1)}
+}
+
+// Acquire blocks until n bytes are granted to f. Flows must not acquire
+// from more than one goroutine at a time.
+func (f *BandwidthFlow) Acquire(n int) {
+	s := f.s
+	if s.rate <= 0 || n <= 0 {
+		return
+	}
+	s.start.Do(func() { go s.run() })
+
+	s.mu.Lock()
+	req := f.addLocked(n)
+	s.dispatchLocked()
+	s.mu.Unlock()
+	<-req.done
+}
+
+// Refund returns n bytes acquired but not used, such as a read that
+// returned less than was asked for.
+func (s *TokenBucketScheduler) Refund(n int) {
+	if s.rate <= 0 || n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = min(s.burst, s.tokens+float64(n))
+	s.dispatchLocked()
+}
+
+// addLocked queues a request of f for n bytes without serving it. Caller
+// must hold f.s.mu.
+func (f *BandwidthFlow) addLocked(n int) *bandwidthRequest {
+	s := f.s
+	s.seq++
+	req := &bandwidthRequest{tag: max(f.finish, s.vtime), seq: s.seq, need: int64(n), done: make(chan struct{})}
+	f.finish = req.tag + float64(n)/float64(f.weight)
+	s.waiting = append(s.waiting, req)
+	return req
+}
+
+// run serves waiting transfers as tokens refill, forever.
+func (s *TokenBucketScheduler) run() {
+	for range time.Tick(bandwidthTick) {
+		s.mu.Lock()
+		s.dispatchLocked()
+		s.mu.Unlock()
+	}
+}
+
+// dispatchLocked refills the bucket and grants its tokens to the waiting
+// requests, lowest tag first. A request cut short by running out of tokens
+// keeps the lowest tag, so it carries on at the next dispatch. Caller must
+// hold s.mu.
+func (s *TokenBucketScheduler) dispatchLocked() {
+	now := s.now()
+	if s.last.IsZero() {
+		s.tokens, s.last = s.burst, now
+	} else if now.After(s.last) {
+		s.tokens = min(s.burst, s.tokens+now.Sub(s.last).Seconds()*s.rate)
+		s.last = now
+	}
+
+	for len(s.waiting) > 0 && s.tokens >= 1 {
+		i := 0
+		for j, req := range s.waiting {
+			if req.tag < s.waiting[i].tag || req.tag == s.waiting[i].tag && req.seq < s.waiting[i].seq {
+				i = j
+			}
+		}
+		req := s.waiting[i]
+		s.vtime = req.tag
+		grant := min(req.need-req.granted, int64(s.tokens))
+		req.granted += grant
+		s.tokens -= float64(grant)
+		if req.granted == req.need {
+			close(req.done)
+			s.waiting = slices.Delete(s.waiting, i, i+1)
+		}
+	}
+}
+
+// Conn returns conn with its reads and writes taking bytes from s, as a
+// new transfer of weight. Chunk transfers all have weight 1. conn is
+// returned as is when s is unlimited.
+func (s *TokenBucketScheduler) Conn(conn net.Conn, weight int) net.Conn {
+	if s.rate <= 0 {
+		return conn
+	}
+	return &scheduledConn{Conn: conn, flow: s.Flow(weight)}
+}
+
+// scheduledConn is read by one goroutine and written by one, never both
+// at once, as every peer exchange is request then reply.
+type scheduledConn struct {
+	net.Conn
+	flow *BandwidthFlow
+}
+
+// Read acquires up to one turn's worth of bytes before reading, and gives
+// back what the read didn't use.
+func (c *scheduledConn) Read(p []byte) (int, error) {
+	n := min(len(p), c.flow.weight*bandwidthQuantum)
+	c.flow.Acquire(n)
+	r, err := c.Conn.Read(p[:n])
+	c.flow.s.Refund(n - r)
+	return r, err
+}
+
+// Write sends p a turn's worth at a time, acquiring each piece first.
+func (c *scheduledConn) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		n := min(len(p), c.flow.weight*bandwidthQuantum)
+		c.flow.Acquire(n)
+		w, err := c.Conn.Write(p[:n])
+		total += w
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
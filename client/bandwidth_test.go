@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// simulateBandwidth runs transfers of the given weights, each always
+// wanting one more turn's worth of bytes, through a scheduler of rate
+// bytes per second for one simulated second, and returns the bytes each
+// was granted.
+func simulateBandwidth(rate int64, weights ...int) []int64 {
+	now := time.Unix(1_700_000_000, 0)
+	s := NewTokenBucketScheduler(rate)
+	s.now = func() time.Time { return now }
+	s.last = now // start empty, so the burst doesn't count
+	order := rand.New(rand.NewSource(1))
+
+	got := make([]int64, len(weights))
+	flows := make([]*BandwidthFlow, len(weights))
+	reqs := make([]*bandwidthRequest, len(weights))
+	for i, w := range weights {
+		flows[i] = s.Flow(w)
+		reqs[i] = flows[i].addLocked(w * bandwidthQuantum)
+	}
+	for step := time.Duration(0); step < time.Second; step += bandwidthTick {
+		now = now.Add(bandwidthTick)
+		// Transfers served read what they were granted and ask for their
+		// next piece, in no particular order as goroutines would, and may
+		// be served from what is left
+		for served := true; served; {
+			s.dispatchLocked()
+			served = false
+			for _, i := range order.Perm(len(reqs)) {
+				select {
+				case <-reqs[i].done:
+					got[i] += reqs[i].granted
+					reqs[i] = flows[i].addLocked(weights[i] * bandwidthQuantum)
+					served = true
+				default:
+				}
+			}
+		}
+	}
+	return got
+}
+
+// within reports whether got is within 5% of want.
+func within(got, want float64) bool {
+	return math.Abs(got-want) <= want/20
+}
+
+func TestTokenBucketScheduler_FairShare(t *testing.T) {
+	const rate = 10 << 20
+	for n := 1; n <= 5; n++ {
+		weights := make([]int, n)
+		for i := range weights {
+			weights[i] = 1
+		}
+		got := simulateBandwidth(rate, weights...)
+		var total int64
+		for i, g := range got {
+			total += g
+			if !within(float64(g), float64(rate)/float64(n)) {
+				t.Errorf("%d transfers: transfer %d got %d bytes in a second, want about %d", n, i, g, rate/n)
+			}
+		}
+		if !within(float64(total), rate) {
+			t.Errorf("%d transfers got %d bytes in total, want about %d", n, total, rate)
+		}
+	}
+}
+
+func TestTokenBucketScheduler_Weighted(t *testing.T) {
+	const rate = 12 << 20
+	got := simulateBandwidth(rate, 1, 2, 3)
+	for i, g := range got {
+		if want := float64(rate) * float64(i+1) / 6; !within(float64(g), want) {
+			t.Errorf("weight %d got %d bytes, want about %.0f", i+1, g, want)
+		}
+	}
+}
+
+// TestTokenBucketScheduler_Acquire checks concurrent Acquire calls all
+// return, no sooner than the rate allows, and an unlimited scheduler
+// doesn't hold anyone up.
+func TestTokenBucketScheduler_Acquire(t *testing.T) {
+	s := NewTokenBucketScheduler(1 << 20)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f := s.Flow(1)
+			for j := 0; j < 4; j++ {
+				f.Acquire(bandwidthQuantum)
+			}
+		}()
+	}
+	wg.Wait()
+	// 192KB at 1MB/s, less the ~100KB burst: at least 80ms
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Errorf("acquired 192KB in %v", elapsed)
+	}
+	s.Refund(bandwidthQuantum)
+
+	unlimited := NewTokenBucketScheduler(0)
+	unlimited.Flow(1).Acquire(1 << 30)
+	if c := unlimited.Conn(nil, 1); c != nil {
+		t.Error("unlimited scheduler wrapped the conn")
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	for in, want := range map[string]int64{
+		"":        0,
+		"10MB/s":  10 << 20,
+		"512kb/s": 512 << 10,
+		"2048":    2048,
+		"fast":    0,
+	} {
+		t.Setenv("P2P_BANDWIDTH_LIMIT", in)
+		if got := bandwidthLimit(); got != want {
+			t.Errorf("P2P_BANDWIDTH_LIMIT=%q: %d, want %d", in, got, want)
+		}
+	}
+}
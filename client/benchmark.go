@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"p2p/common"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// benchmarkPayload is how many random bytes benchmark uploads to a tracker
+// and downloads back.
+const benchmarkPayload = 1 << 20
+
+// benchmarkTimeout bounds each of benchmark's requests, the payload's
+// included.
+const benchmarkTimeout = 60 * time.Second
+
+// TrackerSpeed is one tracker's benchmark result.
+type TrackerSpeed struct {
+	Tracker      string
+	RTT          time.Duration
+	UploadMBps   float64
+	DownloadMBps float64
+}
+
+// transferRate returns n bytes moved in d as MB/s.
+func transferRate(n int, d time.Duration) float64 {
+	if d <= 0 {
+		d = time.Nanosecond
+	}
+	return float64(n) / (1 << 20) / d.Seconds()
+}
+
+// timedTrackerRequest sends msg to the tracker at addr on a connection of
+// its own and returns the reply and how long it took to come, from
+// sending the request on; dialling and hello aren't counted.
+func timedTrackerRequest(addr string, msg Message) (Response, time.Duration, error) {
+	conn, _, format, err := dialTracker(addr)
+	if err != nil {
+		return Response{}, 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(benchmarkTimeout))
+
+	start := time.Now()
+	if err := common.SendFormat(conn, format, msg); err != nil {
+		return Response{}, 0, err
+	}
+	var resp Response
+	if err := common.Recv(conn, &resp); err != nil {
+		return Response{}, 0, err
+	}
+	return resp, time.Since(start), nil
+}
+
+// MeasureTracker times a hello round trip to the tracker at addr, then an
+// upload of benchmarkPayload random bytes with speed_test and their
+// download with speed_test_fetch, which must give the same bytes back.
+func MeasureTracker(addr string) (TrackerSpeed, error) {
+	res := TrackerSpeed{Tracker: addr}
+	hello := Message{Cmd: common.HelloCmd, Args: []string{common.FormatProtocolVersion(protocolVersion)}}
+	_, rtt, err := timedTrackerRequest(addr, hello)
+	if err != nil {
+		return res, fmt.Errorf("ping: %v", err)
+	}
+	res.RTT = rtt
+
+	payload := make([]byte, benchmarkPayload)
+	rand.Read(payload)
+	resp, took, err := timedTrackerRequest(addr, Message{Cmd: "speed_test",
+		Args: []string{strconv.Itoa(len(payload)), base64.StdEncoding.EncodeToString(payload)}})
+	if err != nil {
+		return res, fmt.Errorf("upload: %v", err)
+	}
+	id, _ := resp.Data.(string)
+	if resp.Status != "ok" || id == "" {
+		return res, fmt.Errorf("upload: %v", resp.Data)
+	}
+	res.UploadMBps = transferRate(len(payload), took)
+
+	resp, took, err = timedTrackerRequest(addr, Message{Cmd: "speed_test_fetch", Args: []string{id}})
+	if err != nil {
+		return res, fmt.Errorf("download: %v", err)
+	}
+	s, _ := resp.Data.(string)
+	if resp.Status != "ok" {
+		return res, fmt.Errorf("download: %v", resp.Data)
+	}
+	if got, err := base64.StdEncoding.DecodeString(s); err != nil || !bytes.Equal(got, payload) {
+		return res, errors.New("download: tracker sent back different bytes")
+	}
+	res.DownloadMBps = transferRate(len(payload), took)
+	return res, nil
+}
+
+// MeasureTrackers benchmarks each tracker in turn and returns the results
+// fastest round trip first, with the trackers that failed.
+func MeasureTrackers(addrs []string) (results []TrackerSpeed, failed map[string]error) {
+	failed = make(map[string]error)
+	for _, addr := range addrs {
+		r, err := MeasureTracker(addr)
+		if err != nil {
+			failed[addr] = err
+			continue
+		}
+		results = append(results, r)
+	}
+	sort.SliceStable(results, func(a, b int) bool { return results[a].RTT < results[b].RTT })
+	return results, failed
+}
+
+// printTrackerSpeeds prints benchmark results as a table.
+func printTrackerSpeeds(results []TrackerSpeed, failed map[string]error) {
+	if len(results) > 0 {
+		fmt.Printf("%-22s %10s %12s %14s\n", "TRACKER", "RTT", "UPLOAD", "DOWNLOAD")
+	}
+	for _, r := range results {
+		fmt.Printf("%-22s %8.1fms %7.2f MB/s %9.2f MB/s\n", r.Tracker,
+			float64(r.RTT.Microseconds())/1000, r.UploadMBps, r.DownloadMBps)
+	}
+	for addr, err := range failed {
+		fmt.Printf("✗ %s: %v\n", addr, err)
+	}
+}
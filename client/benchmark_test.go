@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/base64"
+	"net"
+	"p2p/common"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startBenchTracker is a tracker stand-in answering speed_test and
+// speed_test_fetch after delay. With corrupt set, fetched payloads come
+// back with their first byte changed.
+func startBenchTracker(t *testing.T, delay time.Duration, corrupt bool) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	held := make(map[string]string)
+	answer := func(msg Message) Response {
+		mu.Lock()
+		defer mu.Unlock()
+		switch msg.Cmd {
+		case common.HelloCmd:
+			return Response{"ok", "v2"}
+		case "speed_test":
+			time.Sleep(delay)
+			data, _ := base64.StdEncoding.DecodeString(msg.Args[1])
+			if strconv.Itoa(len(data)) != msg.Args[0] {
+				return Response{"error", "size mismatch"}
+			}
+			if corrupt {
+				data[0]++
+			}
+			id := strconv.Itoa(len(held))
+			held[id] = base64.StdEncoding.EncodeToString(data)
+			return Response{"ok", id}
+		case "speed_test_fetch":
+			time.Sleep(delay)
+			return Response{"ok", held[msg.Args[0]]}
+		}
+		return Response{"error", "unknown command"}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var msg Message
+				if recvRequest(c, &msg) == nil {
+					common.Send(c, answer(msg))
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTransferRate(t *testing.T) {
+	if got := transferRate(1<<20, 500*time.Millisecond); got != 2 {
+		t.Errorf("1 MB in 500ms = %v MB/s, want 2", got)
+	}
+	if got := transferRate(1<<20, 0); got <= 0 {
+		t.Errorf("no elapsed time gave %v", got)
+	}
+}
+
+// TestMeasureTracker checks the upload and download are each timed from
+// request to reply, so a tracker taking delay to answer them measures at
+// most 1 MB per delay.
+func TestMeasureTracker(t *testing.T) {
+	forgetTrackerConns(t)
+	delay := 100 * time.Millisecond
+	addr := startBenchTracker(t, delay, false)
+
+	r, err := MeasureTracker(addr)
+	if err != nil {
+		t.Fatalf("MeasureTracker: %v", err)
+	}
+	limit := transferRate(benchmarkPayload, delay)
+	if r.RTT <= 0 || r.RTT >= delay {
+		t.Errorf("RTT = %v", r.RTT)
+	}
+	for name, mbps := range map[string]float64{"upload": r.UploadMBps, "download": r.DownloadMBps} {
+		if mbps <= 0 || mbps > limit {
+			t.Errorf("%s = %.2f MB/s, want up to %.2f", name, mbps, limit)
+		}
+	}
+}
+
+// TestMeasureTrackers checks results come fastest first and a tracker
+// sending back different bytes, or none at all, is reported as failed.
+func TestMeasureTrackers(t *testing.T) {
+	forgetTrackerConns(t)
+	good := startBenchTracker(t, 0, false)
+	corrupt := startBenchTracker(t, 0, true)
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := ln.Addr().String()
+	ln.Close()
+
+	results, failed := MeasureTrackers([]string{corrupt, good, down})
+	if len(results) != 1 || results[0].Tracker != good {
+		t.Errorf("results = %+v", results)
+	}
+	if err := failed[corrupt]; err == nil || !strings.Contains(err.Error(), "different bytes") {
+		t.Errorf("corrupt tracker: %v", err)
+	}
+	if failed[down] == nil {
+		t.Error("unreachable tracker not reported")
+	}
+}
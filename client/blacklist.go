@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A peer that fails blacklistThreshold chunk requests in a row, by
+// connection errors or corrupt chunks, is skipped for blacklistDuration.
+const (
+	blacklistThreshold = 3
+	blacklistDuration  = 10 * time.Minute
+)
+
+var (
+	// errPeerBlacklisted is returned by requestChunk for a blacklisted peer.
+	errPeerBlacklisted = errors.New("peer is blacklisted")
+	// errChunkNotServed is returned by requestChunk when the peer answers
+	// but doesn't have the chunk.
+	errChunkNotServed = errors.New("chunk download failed")
+	// errPeerBusy is returned by requestChunk when the peer is at its
+	// connection limit; it is up, just full, so it isn't counted against it.
+	errPeerBusy = errors.New("peer is busy")
+)
+
+var (
+	peerFailuresMu sync.Mutex
+	peerFailures   = make(map[string]int) // consecutive failures per peer
+	blacklistNow   = time.Now             // tests move the clock
+)
+
+// recordPeerFailure counts a failed request to peer, blacklisting it on
+// the blacklistThreshold-th failure in a row. It reports whether it did.
+func recordPeerFailure(peer string) bool {
+	peerFailuresMu.Lock()
+	peerFailures[peer]++
+	banned := peerFailures[peer] >= blacklistThreshold
+	if banned {
+		delete(peerFailures, peer)
+	}
+	peerFailuresMu.Unlock()
+
+	if banned {
+		State.PeerBlacklist.Store(peer, blacklistNow().Add(blacklistDuration))
+		fmt.Printf("⚠ Blacklisted %s for %v after %d failures\n", peer, blacklistDuration, blacklistThreshold)
+		SaveSession()
+	}
+	return banned
+}
+
+// recordPeerSuccess resets peer's failure count after a good chunk.
+func recordPeerSuccess(peer string) {
+	peerFailuresMu.Lock()
+	delete(peerFailures, peer)
+	peerFailuresMu.Unlock()
+}
+
+// peerBlacklisted reports whether peer is blacklisted, forgetting the
+// entry once it has expired.
+func peerBlacklisted(peer string) bool {
+	v, ok := State.PeerBlacklist.Load(peer)
+	if !ok {
+		return false
+	}
+	if blacklistNow().Before(v.(time.Time)) {
+		return true
+	}
+	State.PeerBlacklist.CompareAndDelete(peer, v)
+	return false
+}
+
+// activeBlacklist returns the peers still blacklisted and when each ban ends.
+func activeBlacklist() map[string]time.Time {
+	now := blacklistNow()
+	active := make(map[string]time.Time)
+	State.PeerBlacklist.Range(func(k, v any) bool {
+		if until := v.(time.Time); now.Before(until) {
+			active[k.(string)] = until
+		}
+		return true
+	})
+	return active
+}
+
+// clearBlacklist lifts every ban and failure count, returning how many
+// peers were blacklisted.
+func clearBlacklist() int {
+	n := len(activeBlacklist())
+	State.PeerBlacklist.Clear()
+	peerFailuresMu.Lock()
+	clear(peerFailures)
+	peerFailuresMu.Unlock()
+	return n
+}
+
+// printBlacklist lists the blacklisted peers under the show_transfers table.
+func printBlacklist(w io.Writer, banned map[string]time.Time, now time.Time) {
+	if len(banned) == 0 {
+		return
+	}
+	peers := make([]string, 0, len(banned))
+	for p := range banned {
+		peers = append(peers, p)
+	}
+	sort.Strings(peers)
+	fmt.Fprintf(w, "\nBlacklisted peers (clear_blacklist to lift):\n")
+	for _, p := range peers {
+		fmt.Fprintf(w, "   %-22s %s left\n", p, banned[p].Sub(now).Round(time.Second))
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useBlacklistClock empties the blacklist and fixes its clock at *now.
+func useBlacklistClock(t *testing.T) *time.Time {
+	t.Helper()
+	clearBlacklist()
+	now := time.Now()
+	saved := blacklistNow
+	blacklistNow = func() time.Time { return now }
+	t.Cleanup(func() {
+		blacklistNow = saved
+		clearBlacklist()
+	})
+	return &now
+}
+
+// startDroppingPeer accepts connections and closes them straight away,
+// counting how many it got.
+func startDroppingPeer(t *testing.T) (string, *int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var dials int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&dials, 1)
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), &dials
+}
+
+// TestRequestChunk_Blacklists checks a peer is blacklisted on its third
+// failure in a row and isn't dialled again while it is.
+func TestRequestChunk_Blacklists(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useBlacklistClock(t)
+	peer, dials := startDroppingPeer(t)
+
+	for i := 1; i <= blacklistThreshold; i++ {
+		if peerBlacklisted(peer) {
+			t.Fatalf("blacklisted after %d failures", i-1)
+		}
+		if _, _, err := requestChunk(peer, "somehash", 0); err == nil || errors.Is(err, errPeerBlacklisted) {
+			t.Fatalf("request %d: err = %v", i, err)
+		}
+	}
+	if !peerBlacklisted(peer) {
+		t.Fatal("peer not blacklisted after 3 failures")
+	}
+	if _, _, err := requestChunk(peer, "somehash", 0); !errors.Is(err, errPeerBlacklisted) {
+		t.Errorf("request to blacklisted peer: err = %v", err)
+	}
+	if n := atomic.LoadInt32(dials); n != blacklistThreshold {
+		t.Errorf("peer dialled %d times, want %d", n, blacklistThreshold)
+	}
+}
+
+// TestRecordPeerFailure_NotInARow checks a good chunk resets the count,
+// and that a peer merely missing a chunk isn't counted as failing.
+func TestRecordPeerFailure_NotInARow(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useBlacklistClock(t)
+
+	recordPeerFailure("p1:1")
+	recordPeerFailure("p1:1")
+	recordPeerSuccess("p1:1")
+	if recordPeerFailure("p1:1") || peerBlacklisted("p1:1") {
+		t.Error("blacklisted though the failures weren't in a row")
+	}
+
+	meta, _ := chunkTestFile(t, 100)
+	peer := startTestPeer(t)
+	for i := 0; i < blacklistThreshold+1; i++ {
+		if _, _, err := requestChunk(peer, meta.FileHash, 99); !errors.Is(err, errChunkNotServed) {
+			t.Fatalf("missing chunk: err = %v", err)
+		}
+	}
+	if peerBlacklisted(peer) {
+		t.Error("peer blacklisted for not having a chunk")
+	}
+}
+
+// TestRequestChunk_HandshakeRefused checks a peer answering the handshake
+// with "error", not having the file, isn't counted as failing, nor one
+// turning us away as "busy" at its connection limit.
+func TestRequestChunk_HandshakeRefused(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useBlacklistClock(t)
+
+	peer := startTestPeer(t)
+	for i := 0; i < blacklistThreshold+1; i++ {
+		if _, _, err := requestChunk(peer, "nosuchhash", 0); !errors.Is(err, errChunkNotServed) {
+			t.Fatalf("unknown file: err = %v", err)
+		}
+	}
+	if peerBlacklisted(peer) {
+		t.Error("peer blacklisted for not having the file")
+	}
+
+	t.Setenv("P2P_MAX_CONNS", "1")
+	full := startLimitedPeer(t)
+	holdConn(t, full)
+	waitForStatus(t, full, "busy")
+	for i := 0; i < blacklistThreshold+1; i++ {
+		if _, _, err := requestChunk(full, "nosuchhash", 0); !errors.Is(err, errPeerBusy) {
+			t.Fatalf("full peer: err = %v", err)
+		}
+	}
+	if peerBlacklisted(full) {
+		t.Error("peer blacklisted for being busy")
+	}
+}
+
+// TestBlacklist_Expires checks a ban lasts 10 minutes, survives the
+// session being saved and reloaded, and is lifted when it runs out.
+func TestBlacklist_Expires(t *testing.T) {
+	t.Chdir(t.TempDir())
+	now := useBlacklistClock(t)
+	for i := 0; i < blacklistThreshold; i++ {
+		recordPeerFailure("p1:1")
+	}
+
+	State.PeerBlacklist.Clear()
+	if err := LoadSession(); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(blacklistDuration - time.Second)
+	if !peerBlacklisted("p1:1") {
+		t.Fatal("ban lost or lifted early")
+	}
+
+	*now = now.Add(2 * time.Second)
+	if peerBlacklisted("p1:1") || len(activeBlacklist()) != 0 {
+		t.Error("ban still in force after 10 minutes")
+	}
+}
+
+func TestClearBlacklist(t *testing.T) {
+	t.Chdir(t.TempDir())
+	now := useBlacklistClock(t)
+	State.PeerBlacklist.Store("p1:1", now.Add(time.Minute))
+	State.PeerBlacklist.Store("p2:1", now.Add(-time.Minute))
+
+	var buf bytes.Buffer
+	printBlacklist(&buf, activeBlacklist(), *now)
+	if out := buf.String(); !strings.Contains(out, "p1:1") || !strings.Contains(out, "1m0s left") || strings.Contains(out, "p2:1") {
+		t.Errorf("printBlacklist:\n%s", out)
+	}
+
+	if n := clearBlacklist(); n != 1 || peerBlacklisted("p1:1") {
+		t.Errorf("clearBlacklist = %d", n)
+	}
+}
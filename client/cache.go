@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheTTL is how long a cached tracker response is reused.
+const defaultCacheTTL = 30 * time.Second
+
+// trackerCacheFile, in ChunksDir, holds the response cache, so it carries
+// over between the client processes: every CLI command is one.
+const trackerCacheFile = "tracker_cache.json"
+
+// cachedCommands are the read-only tracker queries worth caching. For each,
+// args[0] is the group the answer belongs to.
+var cachedCommands = map[string]bool{
+	"list_files":    true,
+	"get_file_info": true,
+}
+
+// cacheTTL returns the response cache TTL from P2P_CACHE_TTL, given as a
+// duration ("45s") or whole seconds. 0 disables the cache.
+func cacheTTL() time.Duration {
+	v := os.Getenv("P2P_CACHE_TTL")
+	if v == "" {
+		return defaultCacheTTL
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return d
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second
+	}
+	return defaultCacheTTL
+}
+
+type cachedResponse struct {
+	Resp    Response  `json:"resp"`
+	GroupID string    `json:"group_id"`
+	Expires time.Time `json:"expires"`
+}
+
+// ResponseCache keeps successful answers to read-only tracker queries so
+// repeated calls don't dial the tracker. Keys are "cmd:args_hash".
+type ResponseCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	disabled bool // set by --no-cache
+	now      func() time.Time
+	entries  map[string]cachedResponse
+	path     string      // file the entries are kept in too; "" = memory only
+	loaded   os.FileInfo // path when last read or written
+}
+
+func newResponseCache(ttl time.Duration) *ResponseCache {
+	return &ResponseCache{ttl: ttl, now: time.Now, entries: make(map[string]cachedResponse)}
+}
+
+// newSharedResponseCache returns a cache kept in path too. Nothing is
+// written until path's directory exists.
+func newSharedResponseCache(ttl time.Duration, path string) *ResponseCache {
+	c := newResponseCache(ttl)
+	c.path = path
+	return c
+}
+
+var trackerCache = newSharedResponseCache(cacheTTL(), filepath.Join(ChunksDir, trackerCacheFile))
+
+func cacheKey(msg Message) string {
+	sum := sha256.Sum256([]byte(strings.Join(msg.Args, "\x00")))
+	return msg.Cmd + ":" + hex.EncodeToString(sum[:])
+}
+
+// Get returns a fresh cached response for msg.
+func (c *ResponseCache) Get(msg Message) (Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	e, ok := c.entries[cacheKey(msg)]
+	if !ok || c.now().After(e.Expires) {
+		return Response{}, false
+	}
+	return e.Resp, true
+}
+
+// Put stores resp as the answer to msg. Errors are never cached.
+func (c *ResponseCache) Put(msg Message, resp Response) {
+	if resp.Status != "ok" || len(msg.Args) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	c.entries[cacheKey(msg)] = cachedResponse{Resp: resp, GroupID: msg.Args[0], Expires: c.now().Add(c.ttl)}
+	c.save()
+}
+
+// InvalidateGroup drops every cached answer about groupID.
+func (c *ResponseCache) InvalidateGroup(groupID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	dropped := false
+	for key, e := range c.entries {
+		if e.GroupID == groupID {
+			delete(c.entries, key)
+			dropped = true
+		}
+	}
+	if dropped {
+		c.save()
+	}
+}
+
+// load replaces the entries with those of c.path if another process
+// changed it since it was last read. Every change is saved as it is made,
+// so the file holds ours too, and answers another process invalidated go.
+// Caller must hold c.mu.
+func (c *ResponseCache) load() {
+	if c.path == "" {
+		return
+	}
+	info, err := os.Stat(c.path)
+	if err != nil || c.unchanged(info) {
+		return
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return
+	}
+	var saved map[string]cachedResponse
+	if json.Unmarshal(data, &saved) != nil {
+		return
+	}
+	c.loaded = info
+	c.entries = saved
+}
+
+// unchanged reports whether info is of the file c last read or wrote. Each
+// save renames a new file into place, so one from another process differs
+// even when written within the same mtime tick.
+func (c *ResponseCache) unchanged(info os.FileInfo) bool {
+	return c.loaded != nil && os.SameFile(info, c.loaded) && info.ModTime().Equal(c.loaded.ModTime())
+}
+
+// save writes the fresh entries to c.path, replacing it whole so readers
+// never see it half-written. Caller must hold c.mu.
+func (c *ResponseCache) save() {
+	if c.path == "" {
+		return
+	}
+	now := c.now()
+	fresh := make(map[string]cachedResponse)
+	for key, e := range c.entries {
+		if !now.After(e.Expires) {
+			fresh[key] = e
+		}
+	}
+	data, err := json.Marshal(fresh)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), trackerCacheFile+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err != nil || cerr != nil || os.Rename(tmp.Name(), c.path) != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if info, err := os.Stat(c.path); err == nil {
+		c.loaded = info
+	}
+}
+
+// QueryTracker is SendToTracker for read-only queries: cacheable commands
+// are answered from trackerCache while fresh.
+func QueryTracker(msg Message) Response {
+	c := trackerCache
+	if c.disabled || c.ttl <= 0 || !cachedCommands[msg.Cmd] {
+		return SendToTracker(msg)
+	}
+	if resp, ok := c.Get(msg); ok {
+		return resp
+	}
+	resp := SendToTracker(msg)
+	c.Put(msg, resp)
+	return resp
+}
+
+// StreamQuery is StreamFromTracker for read-only queries. A fresh cached
+// answer is replayed through cb one item at a time; otherwise the items are
+// streamed from the tracker and, if none was an error, cached together.
+func StreamQuery(msg Message, cb func(Response)) error {
+	c := trackerCache
+	if c.disabled || c.ttl <= 0 || !cachedCommands[msg.Cmd] {
+		return StreamFromTracker(msg, cb)
+	}
+	if resp, ok := c.Get(msg); ok {
+		if items, isList := resp.Data.([]interface{}); isList {
+			for _, item := range items {
+				cb(Response{"ok", item})
+			}
+		} else {
+			cb(resp)
+		}
+		return nil
+	}
+
+	var items []interface{}
+	failed := false
+	err := StreamFromTracker(msg, func(r Response) {
+		if r.Status == "ok" {
+			items = append(items, r.Data)
+		} else {
+			failed = true
+		}
+		cb(r)
+	})
+	if err == nil && !failed && len(items) > 0 {
+		c.Put(msg, Response{"ok", items})
+	}
+	return err
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"net"
+	"p2p/common"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startCountingTracker runs a tracker stand-in that answers every request
+// with "ok" and counts the connections it accepts.
+func startCountingTracker(t *testing.T) (string, *int64) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var dials int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&dials, 1)
+			go func(c net.Conn) {
+				defer c.Close()
+				var msg Message
+				if err := recvRequest(c, &msg); err != nil {
+					return
+				}
+				common.Send(c, Response{"ok", msg.Cmd})
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), &dials
+}
+
+// useTestCache installs a fresh response cache with a controllable clock.
+func useTestCache(t *testing.T, ttl time.Duration) (*ResponseCache, *time.Time) {
+	t.Helper()
+	saved := trackerCache
+	clock := time.Now()
+	trackerCache = newResponseCache(ttl)
+	trackerCache.now = func() time.Time { return clock }
+	t.Cleanup(func() { trackerCache = saved })
+	return trackerCache, &clock
+}
+
+func TestQueryTracker_CacheHitDoesNotDial(t *testing.T) {
+	tracker, dials := startCountingTracker(t)
+	useTestNetwork(t, tracker, nil)
+	_, clock := useTestCache(t, 30*time.Second)
+
+	msg := Message{Cmd: "list_files", Args: []string{"g1", "alice"}}
+	for i := 0; i < 3; i++ {
+		if resp := QueryTracker(msg); resp.Status != "ok" {
+			t.Fatalf("query %d: %+v", i, resp)
+		}
+	}
+	if n := atomic.LoadInt64(dials); n != 1 {
+		t.Errorf("tracker dialled %d times, want 1", n)
+	}
+
+	// Different args are a different key
+	QueryTracker(Message{Cmd: "list_files", Args: []string{"g2", "alice"}})
+	if n := atomic.LoadInt64(dials); n != 2 {
+		t.Errorf("tracker dialled %d times, want 2", n)
+	}
+
+	// Expired entries go back to the tracker
+	*clock = clock.Add(31 * time.Second)
+	QueryTracker(msg)
+	if n := atomic.LoadInt64(dials); n != 3 {
+		t.Errorf("tracker dialled %d times after expiry, want 3", n)
+	}
+}
+
+func TestQueryTracker_WritesNotCached(t *testing.T) {
+	tracker, dials := startCountingTracker(t)
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 30*time.Second)
+
+	msg := Message{Cmd: "create_group", Args: []string{"g1", "alice"}}
+	QueryTracker(msg)
+	QueryTracker(msg)
+	if n := atomic.LoadInt64(dials); n != 2 {
+		t.Errorf("tracker dialled %d times, want 2", n)
+	}
+}
+
+func TestQueryTracker_NoCache(t *testing.T) {
+	tracker, dials := startCountingTracker(t)
+	useTestNetwork(t, tracker, nil)
+	c, _ := useTestCache(t, 30*time.Second)
+	c.disabled = true
+
+	msg := Message{Cmd: "get_file_info", Args: []string{"g1", "a.txt", "alice"}}
+	QueryTracker(msg)
+	QueryTracker(msg)
+	if n := atomic.LoadInt64(dials); n != 2 {
+		t.Errorf("tracker dialled %d times with --no-cache, want 2", n)
+	}
+}
+
+// TestInvalidateOnWrite verifies upload and stop_sharing drop the cached
+// answers for their own group and leave other groups cached.
+func TestInvalidateOnWrite(t *testing.T) {
+	tracker, dials := startCountingTracker(t)
+	useTestNetwork(t, tracker, nil)
+	c, _ := useTestCache(t, 30*time.Second)
+
+	g1Files := Message{Cmd: "list_files", Args: []string{"g1", "alice"}}
+	g1Info := Message{Cmd: "get_file_info", Args: []string{"g1", "a.txt", "alice"}}
+	g2Files := Message{Cmd: "list_files", Args: []string{"g2", "alice"}}
+	for _, m := range []Message{g1Files, g1Info, g2Files} {
+		QueryTracker(m)
+	}
+
+	registerUpload(&ChunkMetadata{FileName: "b.txt", FileHash: "h"}, "g1")
+	for _, m := range []Message{g1Files, g1Info} {
+		if _, ok := c.Get(m); ok {
+			t.Errorf("%s for g1 still cached after upload", m.Cmd)
+		}
+	}
+	if _, ok := c.Get(g2Files); !ok {
+		t.Error("g2 entry was invalidated by a write to g1")
+	}
+
+	before := atomic.LoadInt64(dials)
+	QueryTracker(g2Files)
+	if n := atomic.LoadInt64(dials); n != before {
+		t.Error("g2 query dialled the tracker despite a cached answer")
+	}
+
+	c.InvalidateGroup("g2")
+	if _, ok := c.Get(g2Files); ok {
+		t.Error("g2 entry still cached after InvalidateGroup")
+	}
+}
+
+// TestResponseCache_Shared checks answers cached by one client process are
+// found by the next, and that an invalidation by either reaches the other.
+func TestResponseCache_Shared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), trackerCacheFile)
+	first := newSharedResponseCache(30*time.Second, path)
+	msg := Message{Cmd: "get_file_info", Args: []string{"g1", "a.txt", "alice"}}
+	first.Put(msg, Response{"ok", map[string]interface{}{"file_hash": "h"}})
+
+	second := newSharedResponseCache(30*time.Second, path)
+	if resp, ok := second.Get(msg); !ok || !reflect.DeepEqual(resp, Response{"ok", map[string]interface{}{"file_hash": "h"}}) {
+		t.Fatalf("second process got %+v, %v", resp, ok)
+	}
+	second.InvalidateGroup("g1")
+	if _, ok := first.Get(msg); ok {
+		t.Error("answer invalidated by another process still cached")
+	}
+}
+
+func TestQueryTracker_ErrorsNotCached(t *testing.T) {
+	useTestNetwork(t, startEmptyTracker(t), nil)
+	c, _ := useTestCache(t, 30*time.Second)
+
+	msg := Message{Cmd: "get_file_info", Args: []string{"g1", "a.txt", "alice"}}
+	if resp := QueryTracker(msg); resp.Status != "error" {
+		t.Fatalf("got %+v", resp)
+	}
+	if _, ok := c.Get(msg); ok {
+		t.Error("error response was cached")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":     defaultCacheTTL,
+		"45s":  45 * time.Second,
+		"10":   10 * time.Second,
+		"0":    0,
+		"junk": defaultCacheTTL,
+	}
+	for v, want := range cases {
+		t.Setenv("P2P_CACHE_TTL", v)
+		if got := cacheTTL(); got != want {
+			t.Errorf("P2P_CACHE_TTL=%q: got %v, want %v", v, got, want)
+		}
+	}
+}
+
+func TestStripFlag(t *testing.T) {
+	args, found := stripFlag([]string{"list_files", "--no-cache", "g1"}, "--no-cache")
+	if !found || len(args) != 2 || args[0] != "list_files" || args[1] != "g1" {
+		t.Errorf("got %v %v", args, found)
+	}
+	if _, found := stripFlag([]string{"list_files", "g1"}, "--no-cache"); found {
+		t.Error("found --no-cache where there was none")
+	}
+}
+
+// startStreamingTracker answers every request by streaming items, one per
+// frame, and counts the connections it accepts.
+func startStreamingTracker(t *testing.T, items ...string) (string, *int64) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var dials int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&dials, 1)
+			go func(c net.Conn) {
+				defer c.Close()
+				var msg Message
+				if err := common.Recv(c, &msg); err != nil || !msg.Stream {
+					return
+				}
+				for _, item := range items {
+					common.Send(c, Response{"ok", item})
+				}
+				common.Send(c, Response{common.StreamDone, len(items)})
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), &dials
+}
+
+// TestStreamQuery_ReplaysFromCache verifies a streamed list is cached and a
+// repeat query replays the same items without dialling the tracker.
+func TestStreamQuery_ReplaysFromCache(t *testing.T) {
+	tracker, dials := startStreamingTracker(t, "a.txt", "b.txt")
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 30*time.Second)
+
+	msg := Message{Cmd: "list_files", Args: []string{"g1", "alice"}}
+	for round := 0; round < 2; round++ {
+		var got []interface{}
+		if err := StreamQuery(msg, func(r Response) { got = append(got, r.Data) }); err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if len(got) != 2 || got[0] != "a.txt" || got[1] != "b.txt" {
+			t.Errorf("round %d: got %v", round, got)
+		}
+	}
+	if n := atomic.LoadInt64(dials); n != 1 {
+		t.Errorf("tracker dialled %d times, want 1", n)
+	}
+}
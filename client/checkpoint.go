@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// CheckpointFile records an interrupted download's progress in its chunk
+// directory, so resuming doesn't have to stat every chunk file.
+const CheckpointFile = "download.checkpoint"
+
+// checkpointEvery is how many chunks are downloaded between checkpoints.
+const checkpointEvery = 100
+
+// DownloadCheckpoint is the content of CheckpointFile.
+type DownloadCheckpoint struct {
+	FileHash    string `json:"file_hash"`
+	TotalChunks int    `json:"total_chunks"`
+	// LastCompletedChunk is the highest index with every chunk up to it
+	// on disk, -1 if chunk 0 isn't. Chunks are fetched out of order, so
+	// later ones may be on disk too.
+	LastCompletedChunk int      `json:"last_completed_chunk"`
+	DownloadedCount    int      `json:"downloaded_count"` // chunks on disk
+	PeersUsed          []string `json:"peers_used"`
+}
+
+// loadCheckpoint reads chunkDir's checkpoint for fileInfo. It returns nil
+// if there is none or it is stale: for another file, or claiming chunks
+// that aren't on disk. A stale checkpoint is removed.
+func loadCheckpoint(chunkDir string, fileInfo *FileInfo) *DownloadCheckpoint {
+	path := filepath.Join(chunkDir, CheckpointFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cp DownloadCheckpoint
+	if err = json.Unmarshal(data, &cp); err == nil {
+		err = cp.validate(chunkDir, fileInfo)
+	}
+	if err != nil {
+		fmt.Printf("Ignoring stale checkpoint: %v\n", err)
+		os.Remove(path)
+		return nil
+	}
+	return &cp
+}
+
+// validate checks cp is for fileInfo and agrees with the chunks in
+// chunkDir: the first and last chunk it says are complete are there at
+// their sizes, and there are at least as many chunk files as it counted.
+func (cp *DownloadCheckpoint) validate(chunkDir string, fileInfo *FileInfo) error {
+	if cp.FileHash != fileInfo.FileHash || cp.TotalChunks != fileInfo.TotalChunks {
+		return fmt.Errorf("checkpoint is for another file")
+	}
+	last := cp.LastCompletedChunk
+	if last < -1 || last >= cp.TotalChunks || cp.DownloadedCount < last+1 || cp.DownloadedCount > cp.TotalChunks {
+		return fmt.Errorf("checkpoint counts are inconsistent")
+	}
+	for _, i := range []int{0, last} {
+		if i < 0 || i > last {
+			continue
+		}
+		st, err := os.Stat(filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i)))
+		if err != nil {
+			return fmt.Errorf("chunk %d is missing", i)
+		}
+		if i < len(fileInfo.Chunks) && fileInfo.Chunks[i].Size > 0 && st.Size() != fileInfo.Chunks[i].Size {
+			return fmt.Errorf("chunk %d is %d bytes, want %d", i, st.Size(), fileInfo.Chunks[i].Size)
+		}
+	}
+	chunks, err := filepath.Glob(filepath.Join(chunkDir, "chunk_*.dat"))
+	if err != nil || len(chunks) < cp.DownloadedCount {
+		return fmt.Errorf("%d chunk files on disk, checkpoint counted %d", len(chunks), cp.DownloadedCount)
+	}
+	return nil
+}
+
+// checkpointer tracks which chunks of a download are on disk and writes a
+// checkpoint every checkpointEvery new ones. It is safe for concurrent use.
+type checkpointer struct {
+	mu        sync.Mutex
+	path      string
+	fileHash  string
+	done      []bool
+	prefix    int // chunks 0..prefix-1 are all done
+	count     int
+	sinceSave int
+	peers     map[string]bool
+}
+
+// newCheckpointer starts tracking a download whose done chunks are on disk.
+func newCheckpointer(chunkDir string, fileInfo *FileInfo, done []bool) *checkpointer {
+	c := &checkpointer{
+		path:     filepath.Join(chunkDir, CheckpointFile),
+		fileHash: fileInfo.FileHash,
+		done:     done,
+		peers:    make(map[string]bool),
+	}
+	for _, d := range done {
+		if d {
+			c.count++
+		}
+	}
+	c.advance()
+	return c
+}
+
+func (c *checkpointer) advance() {
+	for c.prefix < len(c.done) && c.done[c.prefix] {
+		c.prefix++
+	}
+}
+
+// complete records chunk i on disk, fetched from peer ("" if another
+// worker got it). It writes the checkpoint every checkpointEvery chunks.
+func (c *checkpointer) complete(i int, peer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.done[i] {
+		c.done[i] = true
+		c.count++
+		c.advance()
+	}
+	if peer == "" {
+		return
+	}
+	c.peers[peer] = true
+	if c.sinceSave++; c.sinceSave >= checkpointEvery {
+		if err := c.saveLocked(); err != nil {
+			fmt.Printf("Warning: Failed to write checkpoint: %v\n", err)
+		}
+	}
+}
+
+// save writes the checkpoint now.
+func (c *checkpointer) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saveLocked()
+}
+
+func (c *checkpointer) saveLocked() error {
+	cp := DownloadCheckpoint{
+		FileHash:           c.fileHash,
+		TotalChunks:        len(c.done),
+		LastCompletedChunk: c.prefix - 1,
+		DownloadedCount:    c.count,
+		PeersUsed:          make([]string, 0, len(c.peers)),
+	}
+	for p := range c.peers {
+		cp.PeersUsed = append(cp.PeersUsed, p)
+	}
+	sort.Strings(cp.PeersUsed)
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	c.sinceSave = 0
+	return os.Rename(tmp, c.path)
+}
+
+// remove deletes the checkpoint once the download is complete.
+func (c *checkpointer) remove() {
+	os.Remove(c.path)
+}
+
+// chunksOnDisk returns which of fileInfo's chunks are in chunkDir. With a
+// valid checkpoint, chunks up to its LastCompletedChunk aren't checked.
+func chunksOnDisk(chunkDir string, fileInfo *FileInfo, cp *DownloadCheckpoint) []bool {
+	done := make([]bool, fileInfo.TotalChunks)
+	start := 0
+	if cp != nil {
+		for i := 0; i <= cp.LastCompletedChunk; i++ {
+			done[i] = true
+		}
+		start = cp.LastCompletedChunk + 1
+	}
+	for i := start; i < fileInfo.TotalChunks; i++ {
+		if _, err := os.Stat(filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))); err == nil {
+			done[i] = true
+		}
+	}
+	return done
+}
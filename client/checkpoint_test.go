@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCheckpointer_WritesEveryN checks a checkpoint is written every
+// checkpointEvery fetched chunks, counting only the contiguous chunks from
+// 0 as complete.
+func TestCheckpointer_WritesEveryN(t *testing.T) {
+	dir := t.TempDir()
+	info := &FileInfo{FileHash: "fh", TotalChunks: 250}
+	c := newCheckpointer(dir, info, make([]bool, info.TotalChunks))
+	path := filepath.Join(dir, CheckpointFile)
+
+	// Chunk 0 comes last, as a rarest-first download might fetch it
+	for i := 1; i < checkpointEvery; i++ {
+		c.complete(i, "peerA")
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Fatalf("checkpoint written after %d chunks", checkpointEvery-1)
+	}
+	c.complete(checkpointEvery, "peerB")
+	cp := readCheckpoint(t, path)
+	if cp.LastCompletedChunk != -1 || cp.DownloadedCount != checkpointEvery || len(cp.PeersUsed) != 2 {
+		t.Errorf("first checkpoint = %+v", cp)
+	}
+
+	// Chunks another worker saved count, but don't trigger a checkpoint
+	c.complete(0, "")
+	for i := checkpointEvery + 1; i < 2*checkpointEvery; i++ {
+		c.complete(i, "peerA")
+	}
+	if cp := readCheckpoint(t, path); cp.DownloadedCount != checkpointEvery {
+		t.Errorf("checkpoint rewritten early: %+v", cp)
+	}
+	c.complete(2*checkpointEvery, "peerA")
+	cp = readCheckpoint(t, path)
+	if cp.FileHash != "fh" || cp.TotalChunks != 250 || cp.LastCompletedChunk != 2*checkpointEvery ||
+		cp.DownloadedCount != 2*checkpointEvery+1 {
+		t.Errorf("second checkpoint = %+v", cp)
+	}
+}
+
+func readCheckpoint(t *testing.T, path string) DownloadCheckpoint {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cp DownloadCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		t.Fatal(err)
+	}
+	return cp
+}
+
+// TestLoadCheckpoint_Stale checks a checkpoint that doesn't match the chunks
+// on disk, or is for another file, is discarded.
+func TestLoadCheckpoint_Stale(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 5*smallChunkSize+100)
+	info := streamTestInfo(meta)
+	chunkDir := filepath.Join(ChunksDir, meta.FileHash)
+	chunkPath := func(i int) string { return filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i)) }
+	write := func(cp DownloadCheckpoint) {
+		t.Helper()
+		c := newCheckpointer(chunkDir, info, make([]bool, info.TotalChunks))
+		c.peers["p"] = true
+		c.prefix, c.count = cp.LastCompletedChunk+1, cp.DownloadedCount
+		c.fileHash = cp.FileHash
+		if err := c.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	valid := DownloadCheckpoint{FileHash: meta.FileHash, LastCompletedChunk: 3, DownloadedCount: 4}
+
+	write(valid)
+	if cp := loadCheckpoint(chunkDir, info); cp == nil || cp.LastCompletedChunk != 3 || cp.PeersUsed[0] != "p" {
+		t.Fatalf("valid checkpoint loaded as %+v", cp)
+	}
+
+	stale := map[string]func(){
+		"other file":    func() { write(DownloadCheckpoint{FileHash: "other", LastCompletedChunk: 3, DownloadedCount: 4}) },
+		"count too big": func() { write(DownloadCheckpoint{FileHash: meta.FileHash, LastCompletedChunk: 3, DownloadedCount: 9}) },
+		"last chunk missing": func() {
+			write(valid)
+			os.Rename(chunkPath(3), chunkPath(3)+".bak")
+			t.Cleanup(func() { os.Rename(chunkPath(3)+".bak", chunkPath(3)) })
+		},
+		"first chunk truncated": func() {
+			write(valid)
+			data, _ := os.ReadFile(chunkPath(0))
+			os.WriteFile(chunkPath(0), data[:10], 0644)
+			t.Cleanup(func() { os.WriteFile(chunkPath(0), data, 0644) })
+		},
+	}
+	for name, setup := range stale {
+		t.Run(name, func(t *testing.T) {
+			setup()
+			if cp := loadCheckpoint(chunkDir, info); cp != nil {
+				t.Errorf("stale checkpoint loaded: %+v", cp)
+			}
+			if _, err := os.Stat(filepath.Join(chunkDir, CheckpointFile)); err == nil {
+				t.Error("stale checkpoint kept")
+			}
+		})
+	}
+}
+
+// TestDownload_ResumesFromCheckpoint interrupts a download, checks it left
+// a checkpoint, and that the next run trusts it, fetches only the rest and
+// removes it once the file is assembled.
+func TestDownload_ResumesFromCheckpoint(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 6*smallChunkSize+100)
+	chunks := memoryChunks(t, meta)
+
+	// A peer with only the first three chunks: the download stops at chunk 3
+	partial, _ := startCountingPeer(t, meta.FileHash, chunks[:3])
+	if err := downloadFromInfo(streamTestInfo(meta, partial), "downloaded.bin"); err == nil {
+		t.Fatal("download from a partial peer succeeded")
+	}
+	chunkDir := filepath.Join(ChunksDir, meta.FileHash)
+	cp := readCheckpoint(t, filepath.Join(chunkDir, CheckpointFile))
+	if cp.LastCompletedChunk != 2 || cp.DownloadedCount != 3 || len(cp.PeersUsed) != 1 || cp.PeersUsed[0] != partial {
+		t.Fatalf("checkpoint after interruption = %+v", cp)
+	}
+
+	// Chunks the checkpoint vouches for aren't looked at on disk
+	os.Rename(filepath.Join(chunkDir, "chunk_1.dat"), "chunk_1.bak")
+	if done := chunksOnDisk(chunkDir, streamTestInfo(meta), &cp); !done[1] || done[3] {
+		t.Errorf("chunksOnDisk with checkpoint = %v", done)
+	}
+	os.Rename("chunk_1.bak", filepath.Join(chunkDir, "chunk_1.dat"))
+
+	full, counts := startCountingPeer(t, meta.FileHash, chunks)
+	if err := downloadFromInfo(streamTestInfo(meta, full), "downloaded.bin"); err != nil {
+		t.Fatalf("resumed download: %v", err)
+	}
+	for i := 0; i < meta.TotalChunks; i++ {
+		want := 1
+		if i < 3 {
+			want = 0
+		}
+		if n := counts.requests(i); n != want {
+			t.Errorf("chunk %d requested %d times on resume, want %d", i, n, want)
+		}
+	}
+	if got, _ := os.ReadFile("downloaded.bin"); !bytes.Equal(got, content) {
+		t.Error("resumed download differs from original")
+	}
+	if _, err := os.Stat(filepath.Join(chunkDir, CheckpointFile)); err == nil {
+		t.Error("checkpoint kept after the download completed")
+	}
+}
@@ -2,45 +2,170 @@ package main
 
 import (
 	"crypto/sha256"
+	"crypto/sha3"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"os"
+	"p2p/common"
 	"path/filepath"
 )
 
 const (
-	ChunkSize = 512 * 1024 // 512KB
+	ChunkSize = 512 * 1024 // 512KB, the chunk size for mid-sized files
 	ChunksDir = ".chunks"
 )
 
+// Chunk size tiers: bigger files get bigger chunks to keep the chunk count
+// (and so the tracker's metadata) small.
+const (
+	smallChunkSize = 256 * 1024       // files under 50MB
+	largeChunkSize = 4 * 1024 * 1024  // files from 500MB up to 5GB
+	hugeChunkSize  = 16 * 1024 * 1024 // files over 5GB
+
+	smallFileLimit = 50 * 1024 * 1024
+	midFileLimit   = 500 * 1024 * 1024
+	largeFileLimit = 5 * 1024 * 1024 * 1024
+)
+
+// PickChunkSize returns the chunk size to split a file of fileSize bytes into.
+func PickChunkSize(fileSize int64) int64 {
+	switch {
+	case fileSize < smallFileLimit:
+		return smallChunkSize
+	case fileSize < midFileLimit:
+		return ChunkSize
+	case fileSize <= largeFileLimit:
+		return largeChunkSize
+	default:
+		return hugeChunkSize
+	}
+}
+
+// Hash algorithms for file and chunk hashes. Metadata that names none
+// was hashed with HashSHA256, the only one older clients know, so it is
+// left out of the metadata of SHA2 files.
+const (
+	HashSHA256  = "sha256"
+	HashSHA3256 = "sha3-256"
+)
+
+// ChunkOptions picks how CalculateFileHash and ChunkFile hash a file.
+type ChunkOptions struct {
+	HashAlgorithm string // "" means HashSHA256
+
+	// FileHash is the file's hash under HashAlgorithm if the caller has
+	// it already, saving ChunkFile and ChunkAndStore a pass over the file
+	FileHash string
+
+	// ChunkSize splits the file into chunks of this size; 0 leaves it to
+	// PickChunkSize
+	ChunkSize int64
+}
+
+// knownFileHash returns the file hash opts carries, "" if none.
+func knownFileHash(opts []ChunkOptions) string {
+	if len(opts) > 0 {
+		return opts[0].FileHash
+	}
+	return ""
+}
+
+// chunkSizeFor returns the chunk size opts picks for a file of fileSize bytes.
+func chunkSizeFor(fileSize int64, opts []ChunkOptions) int64 {
+	if len(opts) > 0 && opts[0].ChunkSize > 0 {
+		return opts[0].ChunkSize
+	}
+	return PickChunkSize(fileSize)
+}
+
+// uploadChunkOptions returns the options files are chunked with for
+// sharing: P2P_HASH_ALGO picks the hash algorithm, SHA256 by default.
+func uploadChunkOptions() ChunkOptions {
+	return ChunkOptions{HashAlgorithm: os.Getenv("P2P_HASH_ALGO")}
+}
+
+// hashAlgorithm returns the algorithm named by opts, checked, in the form
+// it is stored in metadata.
+func hashAlgorithm(opts []ChunkOptions) (string, error) {
+	algo := ""
+	if len(opts) > 0 {
+		algo = opts[0].HashAlgorithm
+	}
+	if _, err := newHasher(algo); err != nil {
+		return "", err
+	}
+	if algo == HashSHA256 {
+		algo = ""
+	}
+	return algo, nil
+}
+
+// newHasher returns a hash.Hash for algo; "" is HashSHA256.
+func newHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case "", HashSHA256:
+		return sha256.New(), nil
+	case HashSHA3256:
+		return sha3.New256(), nil
+	}
+	return nil, fmt.Errorf("unknown hash algorithm %q", algo)
+}
+
+// computeHash returns the hex hash of data under algo.
+func computeHash(algo string, data []byte) (string, error) {
+	switch algo {
+	case "", HashSHA256:
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	case HashSHA3256:
+		sum := sha3.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q", algo)
+}
+
 // ChunkInfo represents metadata for a single chunk
 type ChunkInfo struct {
-	Index int    `json:"index"`
-	Hash  string `json:"hash"` // SHA256 hex
-	Size  int64  `json:"size"` // Bytes
+	Index         int    `json:"index"`
+	Hash          string `json:"hash"`                     // hex, of HashAlgorithm
+	Size          int64  `json:"size"`                     // Bytes
+	HashAlgorithm string `json:"hash_algorithm,omitempty"` // "" means HashSHA256
 }
 
 // ChunkMetadata contains all metadata for a chunked file
 type ChunkMetadata struct {
 	FileName    string      `json:"file_name"`
 	FileSize    int64       `json:"file_size"`
-	FileHash    string      `json:"file_hash"`    // SHA256 of entire file
-	ChunkSize   int64       `json:"chunk_size"`   // 512KB
+	FileHash    string      `json:"file_hash"`  // of entire file, with HashAlgorithm
+	ChunkSize   int64       `json:"chunk_size"` // Picked by PickChunkSize
 	TotalChunks int         `json:"total_chunks"`
 	Chunks      []ChunkInfo `json:"chunks"`
+
+	// HashAlgorithm hashed the file and every chunk; "" means HashSHA256
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+
+	// MerkleRoot is the root of the Merkle tree over the chunk hashes. The
+	// header ChunkAndStore returns has none, having no chunk list.
+	MerkleRoot string `json:"merkle_root,omitempty"`
 }
 
-// CalculateFileHash calculates SHA256 hash of entire file
-func CalculateFileHash(filePath string) (string, error) {
+// CalculateFileHash calculates the hash of an entire file, with SHA256
+// unless opts picks another algorithm
+func CalculateFileHash(filePath string, opts ...ChunkOptions) (string, error) {
+	algo, err := hashAlgorithm(opts)
+	if err != nil {
+		return "", err
+	}
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
 
-	hash := sha256.New()
+	hash, _ := newHasher(algo)
 	if _, err := io.Copy(hash, file); err != nil {
 		return "", err
 	}
@@ -48,8 +173,15 @@ func CalculateFileHash(filePath string) (string, error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// ChunkFile splits a file into chunks and calculates hashes
-func ChunkFile(filePath string) (*ChunkMetadata, error) {
+// ChunkFile splits a file into chunks and calculates hashes, with SHA256
+// unless opts picks another algorithm, and PickChunkSize's chunk size
+// unless opts sets one
+func ChunkFile(filePath string, opts ...ChunkOptions) (*ChunkMetadata, error) {
+	algo, err := hashAlgorithm(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get file info
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
@@ -64,12 +196,15 @@ func ChunkFile(filePath string) (*ChunkMetadata, error) {
 		return nil, fmt.Errorf("cannot upload empty file (0 bytes)")
 	}
 
-	totalChunks := int((fileSize + ChunkSize - 1) / ChunkSize)
+	chunkSize := chunkSizeFor(fileSize, opts)
+	totalChunks := int((fileSize + chunkSize - 1) / chunkSize)
 
-	// Calculate file hash
-	fileHash, err := CalculateFileHash(filePath)
-	if err != nil {
-		return nil, err
+	// Calculate file hash, unless the caller did
+	fileHash := knownFileHash(opts)
+	if fileHash == "" {
+		if fileHash, err = CalculateFileHash(filePath, ChunkOptions{HashAlgorithm: algo}); err != nil {
+			return nil, err
+		}
 	}
 
 	// Open file for reading
@@ -81,16 +216,17 @@ func ChunkFile(filePath string) (*ChunkMetadata, error) {
 
 	// Create metadata
 	metadata := &ChunkMetadata{
-		FileName:    filepath.Base(filePath),
-		FileSize:    fileSize,
-		FileHash:    fileHash,
-		ChunkSize:   ChunkSize,
-		TotalChunks: totalChunks,
-		Chunks:      make([]ChunkInfo, 0, totalChunks),
+		FileName:      filepath.Base(filePath),
+		FileSize:      fileSize,
+		FileHash:      fileHash,
+		ChunkSize:     chunkSize,
+		TotalChunks:   totalChunks,
+		Chunks:        make([]ChunkInfo, 0, totalChunks),
+		HashAlgorithm: algo,
 	}
 
 	// Read and hash each chunk
-	buffer := make([]byte, ChunkSize)
+	buffer := make([]byte, chunkSize)
 	for i := 0; i < totalChunks; i++ {
 		n, err := file.Read(buffer)
 		if err != nil && err != io.EOF {
@@ -98,19 +234,40 @@ func ChunkFile(filePath string) (*ChunkMetadata, error) {
 		}
 
 		// Calculate chunk hash
-		chunkHash := sha256.Sum256(buffer[:n])
-		chunkHashHex := hex.EncodeToString(chunkHash[:])
+		chunkHashHex, _ := computeHash(algo, buffer[:n])
 
 		metadata.Chunks = append(metadata.Chunks, ChunkInfo{
-			Index: i,
-			Hash:  chunkHashHex,
-			Size:  int64(n),
+			Index:         i,
+			Hash:          chunkHashHex,
+			Size:          int64(n),
+			HashAlgorithm: algo,
 		})
 	}
+	metadata.MerkleRoot = common.MerkleRoot(chunkLeaves(metadata.Chunks, totalChunks))
 
 	return metadata, nil
 }
 
+// hasCachedChunks reports whether all totalChunks chunks of the file with
+// fileHash are in the local chunk store, so it can be assembled without
+// downloading anything.
+func hasCachedChunks(fileHash string, totalChunks int) bool {
+	if totalChunks <= 0 || !validFileHash(fileHash) {
+		return false
+	}
+	// Stored in a different layout, e.g. by compact_chunks
+	if meta, err := loadChunkMetadataHeader(fileHash); err == nil && meta.TotalChunks != totalChunks {
+		return false
+	}
+	chunkDir := filepath.Join(ChunksDir, fileHash)
+	for i := 0; i < totalChunks; i++ {
+		if _, err := os.Stat(filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
 // SaveChunks saves file chunks to local storage
 func SaveChunks(filePath string, metadata *ChunkMetadata) error {
 	// Create chunks directory
@@ -126,17 +283,21 @@ func SaveChunks(filePath string, metadata *ChunkMetadata) error {
 	}
 	defer file.Close()
 
-	// Write each chunk
-	buffer := make([]byte, ChunkSize)
+	// Write each chunk, split the same way ChunkFile hashed it
+	chunkSize := metadata.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = PickChunkSize(metadata.FileSize)
+	}
+	buffer := make([]byte, chunkSize)
 	for i := 0; i < metadata.TotalChunks; i++ {
 		n, err := file.Read(buffer)
 		if err != nil && err != io.EOF {
 			return err
 		}
 
-		// Write chunk file
+		// Write chunk file, sharing storage with identical chunks of other files
 		chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))
-		if err := os.WriteFile(chunkPath, buffer[:n], 0644); err != nil {
+		if err := storeChunk(chunkPath, metadata.Chunks[i].Hash, buffer[:n]); err != nil {
 			return err
 		}
 	}
@@ -147,6 +308,7 @@ func SaveChunks(filePath string, metadata *ChunkMetadata) error {
 		return err
 	}
 
+	resumeServing(chunkDir)
 	metadataPath := filepath.Join(chunkDir, "metadata.json")
 	return os.WriteFile(metadataPath, metadataJSON, 0644)
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPickChunkSize(t *testing.T) {
+	const (
+		KB = 1024
+		MB = 1024 * KB
+		GB = 1024 * MB
+	)
+	tests := []struct {
+		name     string
+		fileSize int64
+		want     int64
+	}{
+		{"one byte", 1, 256 * KB},
+		{"just under 50MB", 50*MB - 1, 256 * KB},
+		{"exactly 50MB", 50 * MB, 512 * KB},
+		{"just under 500MB", 500*MB - 1, 512 * KB},
+		{"exactly 500MB", 500 * MB, 4 * MB},
+		{"1GB", 1 * GB, 4 * MB},
+		{"exactly 5GB", 5 * GB, 4 * MB},
+		{"just over 5GB", 5*GB + 1, 16 * MB},
+		{"100GB", 100 * GB, 16 * MB},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PickChunkSize(tt.fileSize); got != tt.want {
+				t.Errorf("PickChunkSize(%d) = %d, want %d", tt.fileSize, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSaveChunks_UsesMetadataChunkSize verifies chunks are written at the size
+// recorded in the metadata and reassemble to the original file.
+func TestSaveChunks_UsesMetadataChunkSize(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 3*smallChunkSize+7)
+
+	if meta.ChunkSize != smallChunkSize || meta.TotalChunks != 4 {
+		t.Fatalf("got %d chunks of %d bytes, want 4 of %d", meta.TotalChunks, meta.ChunkSize, smallChunkSize)
+	}
+	var joined []byte
+	for i := 0; i < meta.TotalChunks; i++ {
+		data, err := os.ReadFile(filepath.Join(ChunksDir, meta.FileHash, fmt.Sprintf("chunk_%d.dat", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(data)) != meta.Chunks[i].Size {
+			t.Errorf("chunk %d on disk is %d bytes, metadata says %d", i, len(data), meta.Chunks[i].Size)
+		}
+		joined = append(joined, data...)
+	}
+	if !bytes.Equal(joined, content) {
+		t.Error("chunks don't reassemble to the original file")
+	}
+}
+
+// TestComputeHash checks each algorithm against a known digest of "abc".
+func TestComputeHash(t *testing.T) {
+	for algo, want := range map[string]string{
+		"":          "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		HashSHA256:  "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		HashSHA3256: "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532",
+	} {
+		if got, err := computeHash(algo, []byte("abc")); err != nil || got != want {
+			t.Errorf("computeHash(%q) = %s, %v; want %s", algo, got, err, want)
+		}
+	}
+	if _, err := computeHash("md5", []byte("abc")); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+	if _, err := ChunkFile("missing.bin", ChunkOptions{HashAlgorithm: "md5"}); err == nil {
+		t.Error("ChunkFile accepted an unknown algorithm")
+	}
+}
+
+// TestChunkFile_SHA3RoundTrip chunks a file with SHA3-256, checks the
+// algorithm survives metadata.json, and streams it back from a peer.
+func TestChunkFile_SHA3RoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	content := bytes.Repeat([]byte("sha3 "), smallChunkSize/2)
+	if err := os.WriteFile("orig.bin", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ChunkFile("orig.bin", ChunkOptions{HashAlgorithm: HashSHA3256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveChunks("orig.bin", meta); err != nil {
+		t.Fatal(err)
+	}
+	if sha2, _ := CalculateFileHash("orig.bin"); sha2 == meta.FileHash {
+		t.Error("file hash is SHA256")
+	}
+
+	saved, err := loadChunkMetadata(meta.FileHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.HashAlgorithm != HashSHA3256 {
+		t.Errorf("metadata.json hash algorithm = %q", saved.HashAlgorithm)
+	}
+	for _, c := range saved.Chunks {
+		if c.HashAlgorithm != HashSHA3256 {
+			t.Errorf("chunk %d hash algorithm = %q", c.Index, c.HashAlgorithm)
+		}
+	}
+
+	// The peer names the algorithm, so the chunks validate on the way down
+	var out bytes.Buffer
+	if err := streamChunks(context.Background(), streamTestInfo(saved, startTestPeer(t)), &out); err != nil {
+		t.Fatalf("streamChunks: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Error("streamed file differs")
+	}
+}
+
+// TestValidateChunk_MixedAlgorithms checks a chunk only validates when
+// the peer's algorithm and the metadata's agree.
+func TestValidateChunk_MixedAlgorithms(t *testing.T) {
+	data := []byte("chunk data")
+	sha2, _ := computeHash(HashSHA256, data)
+	sha3, _ := computeHash(HashSHA3256, data)
+	sha2Chunk := ChunkInfo{Hash: sha2}
+	sha3Chunk := ChunkInfo{Hash: sha3, HashAlgorithm: HashSHA3256}
+
+	for _, tt := range []struct {
+		name     string
+		peerAlgo string
+		c        ChunkInfo
+		want     bool
+	}{
+		{"sha256", "", sha2Chunk, true},
+		{"sha256 named", HashSHA256, sha2Chunk, true},
+		{"sha3-256", HashSHA3256, sha3Chunk, true},
+		{"sha3 peer, sha256 metadata", HashSHA3256, sha2Chunk, false},
+		{"sha256 peer, sha3 metadata", "", sha3Chunk, false},
+		{"sha3 hash listed as sha256", "", ChunkInfo{Hash: sha3}, false},
+		{"sha256 hash listed as sha3", HashSHA3256, ChunkInfo{Hash: sha2, HashAlgorithm: HashSHA3256}, false},
+	} {
+		if got := validateChunk(data, tt.peerAlgo, tt.c); got != tt.want {
+			t.Errorf("%s: validateChunk = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestHasCachedChunks checks a file counts as cached only with every chunk
+// stored, in the layout asked for.
+func TestHasCachedChunks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*smallChunkSize+100)
+
+	if !hasCachedChunks(meta.FileHash, meta.TotalChunks) {
+		t.Error("all chunks stored: not cached")
+	}
+	if hasCachedChunks(meta.FileHash, meta.TotalChunks-1) {
+		t.Error("stored in another layout: cached")
+	}
+	if err := os.Remove(filepath.Join(ChunksDir, meta.FileHash, "chunk_1.dat")); err != nil {
+		t.Fatal(err)
+	}
+	if hasCachedChunks(meta.FileHash, meta.TotalChunks) {
+		t.Error("chunk 1 missing: cached")
+	}
+	missing := "ab" + meta.FileHash[2:]
+	if hasCachedChunks(missing, meta.TotalChunks) {
+		t.Error("nothing stored: cached")
+	}
+	if hasCachedChunks("../"+meta.FileHash, meta.TotalChunks) {
+		t.Error("invalid hash: cached")
+	}
+}
+
+// TestDownloadFile_CachedChunks downloads a file stored already under
+// another name, with no peers to download from, and checks it is
+// assembled from the stored chunks.
+func TestDownloadFile_CachedChunks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*smallChunkSize+100)
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"get_file_info": {"ok", map[string]interface{}{
+			"file_name": "copy.bin", "file_hash": meta.FileHash, "file_size": meta.FileSize,
+			"chunk_size": meta.ChunkSize, "total_chunks": meta.TotalChunks, "peers": []string{},
+			"chunks": meta.Chunks,
+		}},
+	})
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 0)
+
+	if err := DownloadFile("g2", "copy.bin", "copy.bin"); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if got, _ := os.ReadFile("copy.bin"); !bytes.Equal(got, content) {
+		t.Error("assembled file differs")
+	}
+	if got := cmds(); len(got) != 1 || got[0] != "get_file_info" {
+		t.Errorf("tracker commands = %v", got)
+	}
+
+	// With a chunk missing the peers are needed, and there are none
+	os.Remove(filepath.Join(ChunksDir, meta.FileHash, "chunk_2.dat"))
+	if err := DownloadFile("g2", "copy.bin", "again.bin"); err == nil {
+		t.Error("downloaded a partly stored file without peers")
+	}
+}
+
+// TestDownloadFile_SwappedLayout checks a file whose chunks all match the
+// tracker's chunk list isn't accepted when it doesn't have the file hash:
+// the list may have been swapped for other content's.
+func TestDownloadFile_SwappedLayout(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*smallChunkSize+100)
+	other := bytes.Repeat([]byte("x"), int(meta.Chunks[1].Size))
+	if err := os.WriteFile(filepath.Join(ChunksDir, meta.FileHash, "chunk_1.dat"), other, 0644); err != nil {
+		t.Fatal(err)
+	}
+	chunks := append([]ChunkInfo(nil), meta.Chunks...)
+	chunks[1].Hash, _ = computeHash(chunks[1].HashAlgorithm, other)
+	tracker, _ := startRecordingTracker(t, map[string]Response{
+		"get_file_info": {"ok", map[string]interface{}{
+			"file_name": "copy.bin", "file_hash": meta.FileHash, "file_size": meta.FileSize,
+			"chunk_size": meta.ChunkSize, "total_chunks": meta.TotalChunks, "peers": []string{},
+			"chunks": chunks,
+		}},
+	})
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 0)
+
+	if err := DownloadFile("g2", "copy.bin", "copy.bin"); err == nil || !strings.Contains(err.Error(), "hash") {
+		t.Errorf("DownloadFile = %v, want a file hash mismatch", err)
+	}
+	if _, err := os.Stat("copy.bin"); !os.IsNotExist(err) {
+		t.Errorf("mismatching file left behind: %v", err)
+	}
+}
+
+// TestDownloadFile_CorruptCachedChunk checks a stored chunk that fails its
+// hash isn't assembled, but deleted to be fetched from peers again.
+func TestDownloadFile_CorruptCachedChunk(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*smallChunkSize+100)
+	tracker, _ := startRecordingTracker(t, map[string]Response{
+		"get_file_info": {"ok", map[string]interface{}{
+			"file_name": "copy.bin", "file_hash": meta.FileHash, "file_size": meta.FileSize,
+			"chunk_size": meta.ChunkSize, "total_chunks": meta.TotalChunks, "peers": []string{},
+			"chunks": meta.Chunks,
+		}},
+	})
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 0)
+
+	chunkPath := filepath.Join(ChunksDir, meta.FileHash, "chunk_1.dat")
+	if err := os.WriteFile(chunkPath, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := DownloadFile("g2", "copy.bin", "copy.bin"); err == nil {
+		t.Error("assembled a corrupt chunk without peers")
+	}
+	if _, err := os.Stat(chunkPath); !os.IsNotExist(err) {
+		t.Errorf("corrupt chunk kept: %v", err)
+	}
+	if _, err := os.Stat("copy.bin"); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
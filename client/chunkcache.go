@@ -0,0 +1,116 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultChunkCacheMB is the chunk cache size when P2P_CHUNK_CACHE_MB is unset.
+const defaultChunkCacheMB = 256
+
+// chunkCacheBytes returns the chunk cache size from P2P_CHUNK_CACHE_MB;
+// 0 turns the cache off.
+func chunkCacheBytes() int64 {
+	mb, err := strconv.ParseInt(os.Getenv("P2P_CHUNK_CACHE_MB"), 10, 64)
+	if err != nil || mb < 0 {
+		mb = defaultChunkCacheMB
+	}
+	return mb << 20
+}
+
+type cachedChunk struct {
+	key     string
+	data    []byte
+	modTime time.Time // of the chunk file the data was read from
+}
+
+// ChunkCache keeps recently served chunks in memory, keyed by
+// "fileHash:chunkIdx", evicting the least recently used once the total
+// size would pass maxBytes. The chunk file is still stat'ed on every
+// request, so chunks evicted or replaced on disk are never served from memory.
+type ChunkCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List // front = most recently used; values are *cachedChunk
+	items    map[string]*list.Element
+	hits     int64
+	misses   int64
+}
+
+func NewChunkCache(maxBytes int64) *ChunkCache {
+	return &ChunkCache{maxBytes: maxBytes, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+var chunkCache = NewChunkCache(chunkCacheBytes())
+
+// Get returns the cached chunk for key and counts the hit or miss. info
+// describes the chunk file now; a copy cached from a different version of
+// the file is dropped and counts as a miss.
+func (c *ChunkCache) Get(key string, info os.FileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		chunk := el.Value.(*cachedChunk)
+		if chunk.modTime.Equal(info.ModTime()) && int64(len(chunk.data)) == info.Size() {
+			c.hits++
+			c.order.MoveToFront(el)
+			return chunk.data, true
+		}
+		c.removeElement(el)
+	}
+	c.misses++
+	return nil, false
+}
+
+// Put caches data read from a chunk file described by info under key.
+// Chunks bigger than the whole cache aren't kept.
+func (c *ChunkCache) Put(key string, data []byte, info os.FileInfo) {
+	size := int64(len(data))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size > c.maxBytes {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.size += size - int64(len(el.Value.(*cachedChunk).data))
+		el.Value.(*cachedChunk).data = data
+		el.Value.(*cachedChunk).modTime = info.ModTime()
+		c.order.MoveToFront(el)
+	} else {
+		c.items[key] = c.order.PushFront(&cachedChunk{key, data, info.ModTime()})
+		c.size += size
+	}
+	for c.size > c.maxBytes {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *ChunkCache) removeElement(el *list.Element) {
+	chunk := c.order.Remove(el).(*cachedChunk)
+	delete(c.items, chunk.key)
+	c.size -= int64(len(chunk.data))
+}
+
+// ChunkCacheStats is the cache's part of /stats.
+type ChunkCacheStats struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+	Bytes    int64   `json:"bytes"`
+	Chunks   int     `json:"chunks"`
+}
+
+func (c *ChunkCache) Stats() ChunkCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := ChunkCacheStats{Hits: c.hits, Misses: c.misses, Bytes: c.size, Chunks: len(c.items)}
+	if total := c.hits + c.misses; total > 0 {
+		s.HitRatio = float64(c.hits) / float64(total)
+	}
+	return s
+}
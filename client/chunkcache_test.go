@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestChunk writes chunk idx of hash with data and returns its FileInfo.
+func writeTestChunk(t testing.TB, hash string, idx int, data []byte) os.FileInfo {
+	t.Helper()
+	dir := filepath.Join(ChunksDir, hash)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("chunk_%d.dat", idx))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// useChunkCache swaps in a fresh chunk cache of maxBytes for one test.
+func useChunkCache(t testing.TB, maxBytes int64) {
+	saved := chunkCache
+	chunkCache = NewChunkCache(maxBytes)
+	t.Cleanup(func() { chunkCache = saved })
+}
+
+func TestChunkCache_LRUEviction(t *testing.T) {
+	t.Chdir(t.TempDir())
+	c := NewChunkCache(30)
+	infos := make(map[string]os.FileInfo)
+	for i, key := range []string{"a", "b", "c"} {
+		infos[key] = writeTestChunk(t, "h", i, make([]byte, 10))
+		c.Put(key, make([]byte, 10), infos[key])
+	}
+	// Using "a" makes "b" the least recently used
+	if _, ok := c.Get("a", infos["a"]); !ok {
+		t.Fatal("a not cached")
+	}
+	infos["d"] = writeTestChunk(t, "h", 3, make([]byte, 10))
+	c.Put("d", make([]byte, 10), infos["d"])
+
+	if _, ok := c.Get("b", infos["b"]); ok {
+		t.Error("least recently used chunk b not evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(key, infos[key]); !ok {
+			t.Errorf("%s evicted", key)
+		}
+	}
+	if s := c.Stats(); s.Bytes != 30 || s.Chunks != 3 || s.Hits != 4 || s.Misses != 1 || s.HitRatio != 0.8 {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// A chunk bigger than the whole cache is not kept
+	c.Put("big", make([]byte, 31), infos["a"])
+	if s := c.Stats(); s.Chunks != 3 {
+		t.Errorf("oversized chunk cached: %+v", s)
+	}
+}
+
+// TestReadServedChunk_Cache serves a chunk twice from the cache, then
+// rewrites it on disk and checks the new contents are served.
+func TestReadServedChunk_Cache(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useChunkCache(t, 1<<20)
+	writeTestChunk(t, "cachehash", 0, []byte("first"))
+
+	for i := 0; i < 2; i++ {
+		if data, status := readServedChunk("cachehash", 0); status != "ok" || string(data) != "first" {
+			t.Fatalf("read %d = %q, %s", i, data, status)
+		}
+	}
+	if s := chunkCache.Stats(); s.Hits != 1 || s.Misses != 1 {
+		t.Fatalf("stats after two reads = %+v", s)
+	}
+
+	info := writeTestChunk(t, "cachehash", 0, []byte("second!"))
+	os.Chtimes(filepath.Join(ChunksDir, "cachehash", "chunk_0.dat"), time.Now(), info.ModTime().Add(time.Second))
+	if data, _ := readServedChunk("cachehash", 0); string(data) != "second!" {
+		t.Errorf("served %q after the chunk changed on disk", data)
+	}
+
+	os.RemoveAll(filepath.Join(ChunksDir, "cachehash"))
+	if _, status := readServedChunk("cachehash", 0); status != "error" {
+		t.Errorf("evicted chunk served from memory: %s", status)
+	}
+}
+
+func TestStatsHandler_ChunkCache(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useChunkCache(t, 1<<20)
+	writeTestChunk(t, "statshash", 0, []byte("data"))
+	readServedChunk("statshash", 0)
+	readServedChunk("statshash", 0)
+
+	rec := httptest.NewRecorder()
+	handleStats(rec, httptest.NewRequest("GET", "/stats", nil))
+	var stats struct {
+		ChunkCache ChunkCacheStats `json:"chunk_cache"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.ChunkCache.HitRatio != 0.5 {
+		t.Errorf("chunk_cache = %+v", stats.ChunkCache)
+	}
+}
+
+// benchmarkServeChunk serves the same 512 KB chunk 1000 times per
+// iteration with a cache of cacheBytes (0 reads from disk every time).
+func benchmarkServeChunk(b *testing.B, cacheBytes int64) {
+	b.Chdir(b.TempDir())
+	useChunkCache(b, cacheBytes)
+	writeTestChunk(b, "benchhash", 0, make([]byte, 512*1024))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000; j++ {
+			if _, status := readServedChunk("benchhash", 0); status != "ok" {
+				b.Fatal(status)
+			}
+		}
+	}
+}
+
+func BenchmarkServeChunk_Disk(b *testing.B)   { benchmarkServeChunk(b, 0) }
+func BenchmarkServeChunk_Cached(b *testing.B) { benchmarkServeChunk(b, 1<<20) }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// MetadataLinesFile holds a file's metadata as JSON lines: the header
+// (ChunkMetadata without its chunks) on the first line, then one ChunkInfo
+// per line. Huge files use it instead of metadata.json so neither writing
+// nor reading it needs every chunk entry in memory.
+const MetadataLinesFile = "metadata.jsonl"
+
+// ChunkMetadataWriter streams chunk entries to a metadata.jsonl file. The
+// file is written under a temporary name and only appears at its path
+// once Close has checked every chunk was added.
+type ChunkMetadataWriter struct {
+	path  string
+	file  *os.File
+	buf   *bufio.Writer
+	enc   *json.Encoder
+	total int
+	added int
+}
+
+// NewChunkMetadataWriter starts path with header's fields; header.Chunks
+// is ignored.
+func NewChunkMetadataWriter(path string, header *ChunkMetadata) (*ChunkMetadataWriter, error) {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	w := &ChunkMetadataWriter{path: path, file: file, buf: bufio.NewWriter(file), total: header.TotalChunks}
+	w.enc = json.NewEncoder(w.buf)
+	h := *header
+	h.Chunks = nil
+	if err := w.enc.Encode(&h); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Add appends the next chunk's entry. Chunks must be added in index order.
+func (w *ChunkMetadataWriter) Add(c ChunkInfo) error {
+	if c.Index != w.added || w.added >= w.total {
+		return fmt.Errorf("chunk %d added out of order (want %d of %d)", c.Index, w.added, w.total)
+	}
+	if err := w.enc.Encode(&c); err != nil {
+		return err
+	}
+	w.added++
+	return nil
+}
+
+// Close finishes the file, failing if any chunk is missing.
+func (w *ChunkMetadataWriter) Close() error {
+	if w.added != w.total {
+		w.Abort()
+		return fmt.Errorf("metadata has %d of %d chunks", w.added, w.total)
+	}
+	if err := w.buf.Flush(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+	return os.Rename(w.file.Name(), w.path)
+}
+
+// Abort discards the partly written file.
+func (w *ChunkMetadataWriter) Abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// ReadChunkMetadataLines reads a metadata.jsonl file, calling fn with each
+// chunk entry in turn, and returns the header. fn may be nil to read only
+// the header.
+func ReadChunkMetadataLines(path string, fn func(ChunkInfo) error) (*ChunkMetadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(file))
+	var header ChunkMetadata
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("bad metadata header: %v", err)
+	}
+	if fn == nil {
+		return &header, nil
+	}
+	for i := 0; ; i++ {
+		var c ChunkInfo
+		err := dec.Decode(&c)
+		if err == io.EOF {
+			if i != header.TotalChunks {
+				return nil, fmt.Errorf("metadata has %d of %d chunks", i, header.TotalChunks)
+			}
+			return &header, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad chunk entry %d: %v", i, err)
+		}
+		if c.Index != i {
+			return nil, fmt.Errorf("chunk entry %d has index %d", i, c.Index)
+		}
+		if err := fn(c); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ChunkAndStore chunks filePath into the local chunk store in one pass,
+// hashing, storing and recording each chunk as it is read, where ChunkFile
+// and SaveChunks read the file twice and keep every entry in memory. The
+// entries go to metadata.jsonl; the returned header has no Chunks. opts
+// picks the hash algorithm and chunk size as for ChunkFile.
+func ChunkAndStore(filePath string, opts ...ChunkOptions) (*ChunkMetadata, error) {
+	algo, err := hashAlgorithm(opts)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, errors.New("cannot upload empty file (0 bytes)")
+	}
+	// The chunk directory is named by the file hash, so it comes first
+	fileHash := knownFileHash(opts)
+	if fileHash == "" {
+		if fileHash, err = CalculateFileHash(filePath, ChunkOptions{HashAlgorithm: algo}); err != nil {
+			return nil, err
+		}
+	}
+	chunkSize := chunkSizeFor(info.Size(), opts)
+	header := &ChunkMetadata{
+		FileName:      filepath.Base(filePath),
+		FileSize:      info.Size(),
+		FileHash:      fileHash,
+		ChunkSize:     chunkSize,
+		TotalChunks:   int((info.Size() + chunkSize - 1) / chunkSize),
+		HashAlgorithm: algo,
+	}
+
+	chunkDir := filepath.Join(ChunksDir, fileHash)
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		return nil, err
+	}
+	resumeServing(chunkDir)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	w, err := NewChunkMetadataWriter(filepath.Join(chunkDir, MetadataLinesFile), header)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, chunkSize)
+	for i := 0; i < header.TotalChunks; i++ {
+		n, err := io.ReadFull(file, buffer)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			w.Abort()
+			return nil, err
+		}
+		hash, _ := computeHash(algo, buffer[:n])
+		c := ChunkInfo{Index: i, Hash: hash, Size: int64(n), HashAlgorithm: algo}
+		if err := storeChunk(filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i)), c.Hash, buffer[:n]); err != nil {
+			w.Abort()
+			return nil, err
+		}
+		if err := w.Add(c); err != nil {
+			w.Abort()
+			return nil, err
+		}
+	}
+	return header, w.Close()
+}
+
+// loadChunkMetadataHeader returns a stored file's metadata, leaving out
+// the chunk entries of a metadata.jsonl file.
+func loadChunkMetadataHeader(fileHash string) (*ChunkMetadata, error) {
+	metadata, err := ReadChunkMetadataLines(filepath.Join(ChunksDir, fileHash, MetadataLinesFile), nil)
+	if os.IsNotExist(err) {
+		return loadChunkMetadata(fileHash)
+	}
+	return metadata, err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestChunkMetadataWriter_RoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	header := &ChunkMetadata{FileName: "a.bin", FileSize: 30, FileHash: "h", ChunkSize: 10, TotalChunks: 3}
+	chunks := []ChunkInfo{{Index: 0, Hash: "h0", Size: 10}, {Index: 1, Hash: "h1", Size: 10}, {Index: 2, Hash: "h2", Size: 10}}
+
+	w, err := NewChunkMetadataWriter(MetadataLinesFile, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(chunks[1]); err == nil {
+		t.Error("chunk 1 accepted before chunk 0")
+	}
+	for _, c := range chunks {
+		if err := w.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []ChunkInfo
+	meta, err := ReadChunkMetadataLines(MetadataLinesFile, func(c ChunkInfo) error {
+		got = append(got, c)
+		return nil
+	})
+	if err != nil || meta.FileName != "a.bin" || meta.TotalChunks != 3 || !reflect.DeepEqual(got, chunks) {
+		t.Fatalf("read back %+v %+v: %v", meta, got, err)
+	}
+
+	// A writer closed short of its chunk count leaves nothing behind
+	w, err = NewChunkMetadataWriter("short.jsonl", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Add(chunks[0])
+	if err := w.Close(); err == nil {
+		t.Error("Close with a chunk missing succeeded")
+	}
+	if entries, _ := filepath.Glob("short.jsonl*"); len(entries) != 0 {
+		t.Errorf("left behind %v", entries)
+	}
+}
+
+// TestChunkAndStore_1GB chunks a 1GB (sparse) file and checks the result
+// matches ChunkFile's, that the chunks are stored, and that memory use
+// doesn't grow with the file.
+func TestChunkAndStore_1GB(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads 1GB")
+	}
+	t.Chdir(t.TempDir())
+	const size = 1 << 30
+	f, err := os.Create("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	// A few distinct chunks among the zeros
+	for _, off := range []int64{0, size / 2, size - 1} {
+		f.WriteAt([]byte{1}, off)
+	}
+	f.Close()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	header, err := ChunkAndStore("big.bin")
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+		t.Errorf("chunking allocated %d MB", alloc>>20)
+	}
+	if header.Chunks != nil || header.TotalChunks != size/largeChunkSize {
+		t.Errorf("header = %+v", header)
+	}
+	if _, err := os.Stat(filepath.Join(ChunksDir, header.FileHash, "metadata.json")); !os.IsNotExist(err) {
+		t.Error("metadata.json written as well")
+	}
+
+	want, err := ChunkFile("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadChunkMetadata(header.FileHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata differs from ChunkFile's: %d chunks, hash %s", len(got.Chunks), got.FileHash)
+	}
+	if h, err := loadChunkMetadataHeader(header.FileHash); err != nil || h.Chunks != nil || h.FileSize != size {
+		t.Errorf("loadChunkMetadataHeader = %+v, %v", h, err)
+	}
+	for _, i := range []int{0, want.TotalChunks / 2, want.TotalChunks - 1} {
+		info, err := os.Stat(filepath.Join(ChunksDir, header.FileHash, fmt.Sprintf("chunk_%d.dat", i)))
+		if err != nil || info.Size() != want.Chunks[i].Size {
+			t.Errorf("chunk %d: %v", i, err)
+		}
+	}
+}
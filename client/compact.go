@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CompactChunks re-chunks a stored file at newChunkSize: the chunks are
+// assembled into the file, which is chunked again into a fresh directory,
+// and the tracker is given the new layout for the file as we uploaded it
+// to groupIDs. The old chunks are removed once the tracker has it; if
+// anything fails first they are put back.
+func CompactChunks(fileHash string, newChunkSize int64, groupIDs []string) (*ChunkMetadata, Response, error) {
+	if !validFileHash(fileHash) {
+		return nil, Response{}, errors.New("invalid file hash")
+	}
+	if newChunkSize <= 0 {
+		return nil, Response{}, errors.New("chunk size must be positive")
+	}
+	if len(groupIDs) == 0 {
+		return nil, Response{}, errors.New("no group named")
+	}
+	old, err := loadChunkMetadata(fileHash)
+	if err != nil {
+		return nil, Response{}, fmt.Errorf("no local file with hash %s", fileHash)
+	}
+	if old.ChunkSize == newChunkSize {
+		return nil, Response{}, fmt.Errorf("already in chunks of %d bytes", newChunkSize)
+	}
+
+	chunkDir := filepath.Join(ChunksDir, fileHash)
+	oldDir := chunkDir + ".old"
+	unshared := isUnshared(fileHash)
+	if err := os.Rename(chunkDir, oldDir); err != nil {
+		return nil, Response{}, err
+	}
+	restore := func() {
+		os.RemoveAll(chunkDir)
+		os.Rename(oldDir, chunkDir)
+	}
+
+	metadata, err := rechunk(oldDir, old, newChunkSize)
+	if err != nil {
+		restore()
+		return nil, Response{}, err
+	}
+	if unshared {
+		StopServing(fileHash)
+	}
+
+	chunksJSON, err := json.Marshal(metadata.Chunks)
+	if err != nil {
+		restore()
+		return nil, Response{}, err
+	}
+	resp := SendToTracker(Message{
+		Cmd:  "update_file_chunks",
+		Args: append([]string{fileHash, State.UserID, fmt.Sprintf("%d", newChunkSize), string(chunksJSON)}, groupIDs...),
+	})
+	if resp.Status != "ok" {
+		restore()
+		return nil, resp, nil
+	}
+	if data, ok := resp.Data.(map[string]interface{}); ok {
+		updated, _ := data["updated"].([]interface{})
+		for _, key := range updated {
+			groupID, _, _ := strings.Cut(fmt.Sprint(key), ":")
+			trackerCache.InvalidateGroup(groupID)
+		}
+	}
+	os.RemoveAll(oldDir)
+	return metadata, resp, nil
+}
+
+// rechunk assembles old's chunks from oldDir and stores the file again in
+// chunks of chunkSize, checking it still has its hash. It returns the new
+// metadata with its chunk list.
+func rechunk(oldDir string, old *ChunkMetadata, chunkSize int64) (*ChunkMetadata, error) {
+	tmpDir, err := os.MkdirTemp(ChunksDir, "compact-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Named as the file was, which ChunkAndStore records
+	assembled := filepath.Join(tmpDir, filepath.Base(old.FileName))
+	if err := assembleFileFromDisk(oldDir, old.TotalChunks, assembled); err != nil {
+		return nil, err
+	}
+	opts := ChunkOptions{HashAlgorithm: old.HashAlgorithm, ChunkSize: chunkSize}
+	hash, err := CalculateFileHash(assembled, opts)
+	if err != nil {
+		return nil, err
+	}
+	if hash != old.FileHash {
+		return nil, fmt.Errorf("assembled file has hash %s, want %s", hash, old.FileHash)
+	}
+	opts.FileHash = hash
+	if _, err := ChunkAndStore(assembled, opts); err != nil {
+		return nil, err
+	}
+	return loadChunkMetadata(old.FileHash)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// assembledContent reassembles the stored file with hash from its
+// current metadata.
+func assembledContent(t *testing.T, hash string) []byte {
+	t.Helper()
+	meta, err := loadChunkMetadata(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assembleFileFromDisk(filepath.Join(ChunksDir, hash), meta.TotalChunks, "assembled.bin"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("assembled.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestCompactChunks re-chunks a 5-chunk file into chunks three times the
+// size and checks the content is unchanged, the chunk count follows the
+// new size and the old layout is gone.
+func TestCompactChunks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 4*smallChunkSize+100)
+	if meta.TotalChunks != 5 {
+		t.Fatalf("test file has %d chunks", meta.TotalChunks)
+	}
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"update_file_chunks": {"ok", map[string]interface{}{"updated": []string{"g1:orig.bin"}}},
+	})
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 0)
+
+	compacted, resp, err := CompactChunks(meta.FileHash, 3*smallChunkSize, []string{"g1"})
+	if err != nil || resp.Status != "ok" {
+		t.Fatalf("CompactChunks: %v, %+v", err, resp)
+	}
+	if compacted.TotalChunks != 2 || len(compacted.Chunks) != 2 || compacted.ChunkSize != 3*smallChunkSize {
+		t.Errorf("compacted into %d chunks (%d listed) of %d", compacted.TotalChunks, len(compacted.Chunks), compacted.ChunkSize)
+	}
+	if compacted.FileName != "orig.bin" || compacted.FileHash != meta.FileHash {
+		t.Errorf("compacted metadata names %s, hash %s", compacted.FileName, compacted.FileHash)
+	}
+	if got := cmds(); len(got) != 1 || got[0] != "update_file_chunks" {
+		t.Errorf("tracker got %v", got)
+	}
+	if !bytes.Equal(assembledContent(t, meta.FileHash), content) {
+		t.Error("content changed by compaction")
+	}
+
+	chunkDir := filepath.Join(ChunksDir, meta.FileHash)
+	for _, stale := range []string{filepath.Join(chunkDir, "chunk_2.dat"), filepath.Join(chunkDir, "metadata.json"), chunkDir + ".old"} {
+		if _, err := os.Stat(stale); err == nil {
+			t.Errorf("%s left behind", stale)
+		}
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(ChunksDir, "compact-*")); len(tmp) != 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+}
+
+// TestCompactChunks_TrackerRefuses checks the old chunks are put back
+// when the tracker doesn't take the new layout.
+func TestCompactChunks_TrackerRefuses(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*smallChunkSize+100)
+	tracker, _ := startRecordingTracker(t, map[string]Response{
+		"update_file_chunks": {"error", "file not found"},
+	})
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 0)
+
+	if _, resp, err := CompactChunks(meta.FileHash, 4*smallChunkSize, []string{"g1"}); err != nil || resp.Status != "error" {
+		t.Fatalf("CompactChunks: %v, %+v", err, resp)
+	}
+	restored, err := loadChunkMetadata(meta.FileHash)
+	if err != nil || restored.TotalChunks != meta.TotalChunks || restored.ChunkSize != meta.ChunkSize {
+		t.Fatalf("after refusal: %+v, %v", restored, err)
+	}
+	if !bytes.Equal(assembledContent(t, meta.FileHash), content) {
+		t.Error("content changed")
+	}
+
+	if _, _, err := CompactChunks(meta.FileHash, meta.ChunkSize, []string{"g1"}); err == nil {
+		t.Error("compacted to the size it has")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"os"
+	"p2p/common"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for P2P_MAX_CONNS and P2P_MAX_CONNS_PER_IP
+const (
+	defaultMaxConns      = 50
+	defaultMaxConnsPerIP = 10
+)
+
+// busyWriteTimeout bounds how long a rejected peer may hold up its "busy" reply.
+const busyWriteTimeout = time.Second
+
+// envLimit reads a positive integer limit from name, or returns def.
+func envLimit(name string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || n < 1 {
+		return def
+	}
+	return n
+}
+
+// connLimiter caps how many peer connections are served at once, in total
+// and from any one IP.
+type connLimiter struct {
+	sem      chan struct{} // one slot per connection being served
+	maxPerIP int
+
+	mu    sync.Mutex
+	perIP map[string]int
+}
+
+func newConnLimiter(maxConns, maxPerIP int) *connLimiter {
+	return &connLimiter{
+		sem:      make(chan struct{}, maxConns),
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+	}
+}
+
+// acquire takes a slot for a connection from ip, or reports false if either
+// limit is reached. A true result must be paired with release(ip).
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+	select {
+	case l.sem <- struct{}{}:
+	default:
+		return false
+	}
+	l.perIP[ip]++
+	return true
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	<-l.sem
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// remoteIP returns the IP part of conn's remote address.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// rejectBusy tells a peer we're at capacity and hangs up without reading
+// its request.
+func rejectBusy(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(busyWriteTimeout))
+	common.Send(conn, PeerResponse{Status: "busy"})
+}
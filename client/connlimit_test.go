@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"p2p/common"
+	"testing"
+	"time"
+)
+
+// startLimitedPeer serves peer connections through AcceptPeerConnections.
+func startLimitedPeer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go AcceptPeerConnections(ln)
+	return ln.Addr().String()
+}
+
+// holdConn opens a connection that keeps its server slot by never sending
+// a request.
+func holdConn(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// handshakeStatus sends a handshake for an unknown file and returns the
+// status the peer answers with: "error" when served, "busy" when refused.
+func handshakeStatus(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	common.Send(conn, PeerRequest{Cmd: "handshake", FileHash: "nosuchhash"})
+	var resp PeerResponse
+	if err := common.Recv(conn, &resp); err != nil {
+		t.Fatalf("no response: %v", err)
+	}
+	return resp.Status
+}
+
+// waitForStatus retries until the peer answers with want, since a closed
+// connection frees its slot only once the server notices.
+func waitForStatus(t *testing.T, addr, want string) {
+	t.Helper()
+	var got string
+	for i := 0; i < 100; i++ {
+		if got = handshakeStatus(t, addr); got == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("status = %q, want %q", got, want)
+}
+
+func TestConnLimit_Total(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("P2P_MAX_CONNS", "2")
+	addr := startLimitedPeer(t)
+
+	a := holdConn(t, addr)
+	holdConn(t, addr)
+	waitForStatus(t, addr, "busy")
+
+	// Freeing a slot lets the next peer in
+	a.Close()
+	waitForStatus(t, addr, "error")
+}
+
+func TestConnLimit_PerIP(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("P2P_MAX_CONNS_PER_IP", "3")
+	addr := startLimitedPeer(t)
+
+	var held []net.Conn
+	for i := 0; i < 3; i++ {
+		held = append(held, holdConn(t, addr))
+	}
+	waitForStatus(t, addr, "busy")
+
+	held[0].Close()
+	waitForStatus(t, addr, "error")
+}
+
+func TestConnLimiter_Release(t *testing.T) {
+	l := newConnLimiter(3, 2)
+	if !l.acquire("1.1.1.1") || !l.acquire("1.1.1.1") {
+		t.Fatal("first two connections from one IP refused")
+	}
+	if l.acquire("1.1.1.1") {
+		t.Fatal("third connection from one IP allowed")
+	}
+	if !l.acquire("2.2.2.2") {
+		t.Fatal("connection from another IP refused")
+	}
+	if l.acquire("3.3.3.3") {
+		t.Fatal("total limit not enforced")
+	}
+	l.release("1.1.1.1")
+	if !l.acquire("3.3.3.3") {
+		t.Fatal("released slot not reusable")
+	}
+	if _, ok := l.perIP["1.1.1.1"]; !ok || len(l.perIP) != 3 {
+		t.Errorf("perIP = %v", l.perIP)
+	}
+}
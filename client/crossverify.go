@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errNoConsensus is returned by crossVerifyChunk when no hash was reported
+// by more than half of the seeders that answered, so there is no telling
+// which copies are corrupt.
+var errNoConsensus = errors.New("seeders disagree with no majority")
+
+// crossVerifyTimeout bounds each seeder's cross_verify, which hashes a
+// chunk from disk. A variable so tests can shorten it.
+var crossVerifyTimeout = 5 * time.Second
+
+// handleCrossVerify hashes our copy of chunk req.PieceIdx, read from disk
+// rather than the chunk cache so corruption on disk shows, and returns the
+// hash for another peer to compare with the other seeders'.
+func handleCrossVerify(conn net.Conn, req PeerRequest) {
+	if !authorizePeer(req) {
+		common.Send(conn, PeerResponse{Status: "unauthorized"})
+		return
+	}
+	if !validFileHash(req.FileHash) || isUnshared(req.FileHash) {
+		common.Send(conn, PeerResponse{Status: "error"})
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(ChunksDir, req.FileHash, fmt.Sprintf("chunk_%d.dat", req.PieceIdx)))
+	if err != nil {
+		common.Send(conn, PeerResponse{Status: "error"})
+		return
+	}
+	algo := serveHashes.Algorithm(req.FileHash, req.PieceIdx)
+	hash, err := computeHash(algo, data)
+	if err != nil {
+		common.Send(conn, PeerResponse{Status: "error"})
+		return
+	}
+	common.Send(conn, PeerResponse{Status: "ok", Hash: hash, HashAlgorithm: algo})
+}
+
+// queryChunkHash asks the peer at peerAddr for the hash of its copy of a
+// chunk. Peers hashing with another algorithm can't be compared, so their
+// hash is prefixed with it.
+func queryChunkHash(peerAddr, fileHash string, chunkIdx int) (string, error) {
+	conn, err := net.DialTimeout("tcp", peerAddr, 2*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(crossVerifyTimeout))
+
+	err = common.Send(conn, PeerRequest{Cmd: "cross_verify", FileHash: fileHash, PieceIdx: chunkIdx, Token: peerToken(fileHash)})
+	if err != nil {
+		return "", err
+	}
+	var resp PeerResponse
+	if err := common.Recv(conn, &resp); err != nil {
+		return "", err
+	}
+	if resp.Status == "unauthorized" {
+		return "", errPeerUnauthorized
+	}
+	if resp.Status != "ok" || resp.Hash == "" {
+		return "", errChunkNotServed
+	}
+	if !sameHashAlgorithm(resp.HashAlgorithm, "") {
+		return resp.HashAlgorithm + ":" + resp.Hash, nil
+	}
+	return resp.Hash, nil
+}
+
+// CrossVerifyResult is what the seeders of a chunk said its hash is.
+type CrossVerifyResult struct {
+	Hashes    map[string]string // seeder address -> hash of its copy
+	Consensus string            // the hash most seeders agree on, "" if none
+	Divergent []string          // seeders whose hash isn't Consensus
+	Reported  []string          // of Divergent, those the tracker now marks suspicious
+	Failed    []string          // seeders that couldn't be asked
+}
+
+// crossVerifyChunk asks every seeder in peers for the hash of its copy of
+// chunk chunkIdx at once and compares them. A hash more than half of those
+// that answered agree on is the consensus, and the others are divergent.
+// Without one it returns errNoConsensus and reports none divergent.
+func crossVerifyChunk(peers []string, fileHash string, chunkIdx int) (CrossVerifyResult, error) {
+	res := CrossVerifyResult{Hashes: make(map[string]string)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			hash, err := queryChunkHash(p, fileHash, chunkIdx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				res.Failed = append(res.Failed, p)
+				return
+			}
+			res.Hashes[p] = hash
+		}(p)
+	}
+	wg.Wait()
+	sort.Strings(res.Failed)
+
+	if len(res.Hashes) < 2 {
+		return res, fmt.Errorf("%d of %d seeders answered; need two to compare", len(res.Hashes), len(peers))
+	}
+	votes := make(map[string]int)
+	for _, h := range res.Hashes {
+		votes[h]++
+	}
+	for h, n := range votes {
+		if 2*n > len(res.Hashes) {
+			res.Consensus = h
+		}
+	}
+	if res.Consensus == "" {
+		return res, errNoConsensus
+	}
+	for p, h := range res.Hashes {
+		if h != res.Consensus {
+			res.Divergent = append(res.Divergent, p)
+		}
+	}
+	sort.Strings(res.Divergent)
+	return res, nil
+}
+
+// CrossVerify compares the seeders' copies of chunk chunkIdx of a file
+// and reports each one disagreeing with the consensus to the tracker with
+// report_corrupt_seeder, which stops listing it.
+func CrossVerify(groupID, fileName string, chunkIdx int) (CrossVerifyResult, error) {
+	info, err := queryFileInfo(groupID, fileName)
+	if err != nil {
+		return CrossVerifyResult{}, err
+	}
+	if chunkIdx < 0 || chunkIdx >= info.TotalChunks {
+		return CrossVerifyResult{}, fmt.Errorf("chunk %d out of range; %s has %d chunks", chunkIdx, fileName, info.TotalChunks)
+	}
+	res, err := crossVerifyChunk(info.Peers, info.FileHash, chunkIdx)
+	if err != nil {
+		return res, err
+	}
+	for _, p := range res.Divergent {
+		resp := SendToTracker(Message{Cmd: "report_corrupt_seeder", Args: []string{groupID, fileName, State.UserID, p}})
+		if resp.Status == "ok" {
+			res.Reported = append(res.Reported, p)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// startHashPeer is a seeder answering cross_verify with hash.
+func startHashPeer(t *testing.T, hash string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req PeerRequest
+			if common.Recv(conn, &req) == nil && req.Cmd == "cross_verify" {
+				common.Send(conn, PeerResponse{Status: "ok", Hash: hash})
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestCrossVerifyChunk(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		hashes    []string
+		consensus string
+		divergent []int // indices into hashes
+		err       error
+	}{
+		{"all agree", []string{"aa", "aa", "aa"}, "aa", nil, nil},
+		{"one disagrees", []string{"aa", "bb", "aa"}, "aa", []int{1}, nil},
+		{"all disagree", []string{"aa", "bb", "cc"}, "", nil, errNoConsensus},
+		{"even split", []string{"aa", "bb", "aa", "bb"}, "", nil, errNoConsensus},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var peers []string
+			for _, h := range tc.hashes {
+				peers = append(peers, startHashPeer(t, h))
+			}
+			var want []string
+			for _, i := range tc.divergent {
+				want = append(want, peers[i])
+			}
+
+			res, err := crossVerifyChunk(peers, "f", 0)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("err = %v, want %v", err, tc.err)
+			}
+			if res.Consensus != tc.consensus || !reflect.DeepEqual(res.Divergent, want) || len(res.Hashes) != len(peers) {
+				t.Errorf("result = %+v, want consensus %q and divergent %v", res, tc.consensus, want)
+			}
+		})
+	}
+}
+
+// TestCrossVerifyChunk_Unreachable checks seeders that can't be asked are
+// left out of the vote, and one answer alone isn't compared.
+func TestCrossVerifyChunk_Unreachable(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := ln.Addr().String()
+	ln.Close()
+	a, b := startHashPeer(t, "aa"), startHashPeer(t, "bb")
+
+	res, err := crossVerifyChunk([]string{down, a, b, startHashPeer(t, "aa")}, "f", 0)
+	if err != nil || res.Consensus != "aa" || !reflect.DeepEqual(res.Divergent, []string{b}) || !reflect.DeepEqual(res.Failed, []string{down}) {
+		t.Errorf("result = %+v, %v", res, err)
+	}
+	if _, err := crossVerifyChunk([]string{down, a}, "f", 0); err == nil {
+		t.Error("compared a single answer")
+	}
+}
+
+// TestCrossVerify_ReportsDivergent checks only the seeder disagreeing with
+// the others is reported to the tracker.
+func TestCrossVerify_ReportsDivergent(t *testing.T) {
+	good1, bad, good2 := startHashPeer(t, "aa"), startHashPeer(t, "bb"), startHashPeer(t, "aa")
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"get_file_info": {"ok", map[string]interface{}{
+			"file_name": "a.txt", "file_hash": "f", "total_chunks": 2,
+			"peers": []string{good1, bad, good2},
+		}},
+		"report_corrupt_seeder": {"ok", "seeder marked suspicious"},
+	})
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 0)
+
+	res, err := CrossVerify("g1", "a.txt", 1)
+	if err != nil {
+		t.Fatalf("CrossVerify: %v", err)
+	}
+	if !reflect.DeepEqual(res.Reported, []string{bad}) {
+		t.Errorf("reported %v, want %v", res.Reported, []string{bad})
+	}
+	reports := 0
+	for _, c := range cmds() {
+		if c == "report_corrupt_seeder" {
+			reports++
+		}
+	}
+	if reports != 1 {
+		t.Errorf("%d reports sent: %v", reports, cmds())
+	}
+	if _, err := CrossVerify("g1", "a.txt", 2); err == nil {
+		t.Error("chunk past the end verified")
+	}
+}
+
+// TestHandleCrossVerify checks a peer hashes its chunk from disk, so a
+// copy corrupted after it was cached still shows.
+func TestHandleCrossVerify(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*smallChunkSize+100)
+	peer := startTestPeer(t)
+
+	hash, err := queryChunkHash(peer, meta.FileHash, 1)
+	if err != nil || hash != meta.Chunks[1].Hash {
+		t.Fatalf("hash = %q, %v; want %q", hash, err, meta.Chunks[1].Hash)
+	}
+	if _, status := readServedChunk(meta.FileHash, 1); status != "ok" {
+		t.Fatalf("readServedChunk = %s", status)
+	}
+	path := filepath.Join(ChunksDir, meta.FileHash, "chunk_1.dat")
+	if err := os.WriteFile(path, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := queryChunkHash(peer, meta.FileHash, 1); err != nil || hash == meta.Chunks[1].Hash {
+		t.Errorf("corrupt chunk hashed to %q, %v", hash, err)
+	}
+	if _, err := queryChunkHash(peer, meta.FileHash, 9); err == nil {
+		t.Error("missing chunk hashed")
+	}
+}
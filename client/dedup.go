@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// GlobalChunkDir is the content-addressable store shared by every file:
+// .chunks/global/<chunkHash>.dat holds one copy of each distinct chunk.
+const GlobalChunkDir = "global"
+
+// linkChunk creates a hard link; a variable so tests can simulate
+// filesystems without hard link support.
+var linkChunk = os.Link
+
+func globalChunkPath(chunkHash string) string {
+	return filepath.Join(ChunksDir, GlobalChunkDir, chunkHash+".dat")
+}
+
+// storeChunk saves data at chunkPath without duplicating chunks already on disk.
+// The bytes live once in the global store and chunkPath is a hard link to them;
+// where hard links aren't supported it falls back to a symlink, then a copy.
+func storeChunk(chunkPath, chunkHash string, data []byte) error {
+	global := globalChunkPath(chunkHash)
+	if _, err := os.Stat(global); err != nil {
+		if err := os.MkdirAll(filepath.Dir(global), 0755); err != nil {
+			return err
+		}
+		// Written via a temp file so a half-written chunk is never shared
+		tmp := global + ".part"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, global); err != nil {
+			return err
+		}
+	}
+
+	// Replace whatever an earlier run left behind
+	os.Remove(chunkPath)
+
+	if err := linkChunk(global, chunkPath); err == nil {
+		return nil
+	}
+	if target, err := filepath.Rel(filepath.Dir(chunkPath), global); err == nil {
+		if err := os.Symlink(target, chunkPath); err == nil {
+			if fi, err := os.Lstat(chunkPath); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
+			os.Remove(chunkPath)
+		}
+	}
+	return os.WriteFile(chunkPath, data, 0644)
+}
+
+// ChunkStats compares what the chunk store holds with what it costs on disk.
+type ChunkStats struct {
+	Files         int   // files with metadata in the store
+	Chunks        int   // chunk files across all of them
+	LogicalBytes  int64 // sum of every file's chunk sizes
+	PhysicalBytes int64 // bytes actually stored, counting shared chunks once
+}
+
+// GetChunkStats walks .chunks and counts shared storage once. A chunk file is
+// shared if it is a symlink or the same file as its global store entry.
+func GetChunkStats() (*ChunkStats, error) {
+	stats := &ChunkStats{}
+	entries, err := os.ReadDir(ChunksDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if entry.Name() == GlobalChunkDir {
+			globals, err := os.ReadDir(filepath.Join(ChunksDir, GlobalChunkDir))
+			if err != nil {
+				return nil, err
+			}
+			for _, g := range globals {
+				if info, err := g.Info(); err == nil && filepath.Ext(g.Name()) == ".dat" {
+					stats.PhysicalBytes += info.Size()
+				}
+			}
+			continue
+		}
+
+		metadata, err := loadChunkMetadata(entry.Name())
+		if err != nil {
+			continue
+		}
+		stats.Files++
+		for i, c := range metadata.Chunks {
+			chunkPath := filepath.Join(ChunksDir, entry.Name(), fmt.Sprintf("chunk_%d.dat", i))
+			linfo, err := os.Lstat(chunkPath)
+			if err != nil {
+				continue // not downloaded yet
+			}
+			stats.Chunks++
+			if linfo.Mode()&os.ModeSymlink != 0 {
+				if info, err := os.Stat(chunkPath); err == nil {
+					stats.LogicalBytes += info.Size()
+				}
+				continue
+			}
+			stats.LogicalBytes += linfo.Size()
+			if ginfo, err := os.Stat(globalChunkPath(c.Hash)); err == nil && os.SameFile(linfo, ginfo) {
+				continue // hard link: already counted in the global store
+			}
+			stats.PhysicalBytes += linfo.Size()
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeAndChunk writes content to name and saves its chunks.
+func writeAndChunk(t *testing.T, name string, content []byte) *ChunkMetadata {
+	t.Helper()
+	if err := os.WriteFile(name, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ChunkFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveChunks(name, meta); err != nil {
+		t.Fatal(err)
+	}
+	return meta
+}
+
+// sharedIntroFiles builds two files whose first two chunks are identical.
+func sharedIntroFiles() (a, b []byte) {
+	intro := append(bytes.Repeat([]byte{7}, smallChunkSize), bytes.Repeat([]byte{8}, smallChunkSize)...)
+	a = append(append([]byte(nil), intro...), bytes.Repeat([]byte{1}, 1000)...)
+	b = append(append([]byte(nil), intro...), bytes.Repeat([]byte{2}, 3000)...)
+	return a, b
+}
+
+func chunkPath(meta *ChunkMetadata, i int) string {
+	return filepath.Join(ChunksDir, meta.FileHash, fmt.Sprintf("chunk_%d.dat", i))
+}
+
+// TestSaveChunks_HardLinksSharedChunks verifies identical chunks of two files
+// are one file on disk, and the stats count them once.
+func TestSaveChunks_HardLinksSharedChunks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	a, b := sharedIntroFiles()
+	metaA := writeAndChunk(t, "a.bin", a)
+	metaB := writeAndChunk(t, "b.bin", b)
+
+	for i := 0; i < 2; i++ {
+		if metaA.Chunks[i].Hash != metaB.Chunks[i].Hash {
+			t.Fatalf("test files should share chunk %d", i)
+		}
+		infoA, errA := os.Stat(chunkPath(metaA, i))
+		infoB, errB := os.Stat(chunkPath(metaB, i))
+		if errA != nil || errB != nil || !os.SameFile(infoA, infoB) {
+			t.Errorf("chunk %d is stored twice", i)
+		}
+	}
+
+	stats, err := GetChunkStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := metaA.Chunks[0].Size + metaA.Chunks[1].Size
+	if want := int64(len(a) + len(b)); stats.LogicalBytes != want {
+		t.Errorf("logical = %d, want %d", stats.LogicalBytes, want)
+	}
+	if want := int64(len(a)+len(b)) - shared; stats.PhysicalBytes != want {
+		t.Errorf("physical = %d, want %d", stats.PhysicalBytes, want)
+	}
+	if stats.Files != 2 || stats.Chunks != metaA.TotalChunks+metaB.TotalChunks {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// TestSaveChunks_SymlinkFallback verifies chunks are symlinked to the global
+// store when hard links aren't available, and still read back correctly.
+func TestSaveChunks_SymlinkFallback(t *testing.T) {
+	t.Chdir(t.TempDir())
+	linkChunk = func(string, string) error { return errors.New("hard links not supported") }
+	t.Cleanup(func() { linkChunk = os.Link })
+
+	a, b := sharedIntroFiles()
+	writeAndChunk(t, "a.bin", a)
+	metaB := writeAndChunk(t, "b.bin", b)
+
+	info, err := os.Lstat(chunkPath(metaB, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("expected a symlink into the global store")
+	}
+	data, err := os.ReadFile(chunkPath(metaB, 0))
+	if err != nil || !validateChunkHash(data, "", metaB.Chunks[0].Hash) {
+		t.Errorf("symlinked chunk reads back wrong: %v", err)
+	}
+
+	if err := assembleFileFromDisk(filepath.Join(ChunksDir, metaB.FileHash), metaB.TotalChunks, "b.out"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile("b.out"); !bytes.Equal(got, b) {
+		t.Error("file assembled from symlinked chunks differs")
+	}
+
+	stats, err := GetChunkStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.PhysicalBytes >= stats.LogicalBytes {
+		t.Errorf("symlinked chunks should be counted once: %+v", stats)
+	}
+}
+
+// TestStoreChunk_ReusesGlobalCopy verifies an existing global chunk isn't rewritten.
+func TestStoreChunk_ReusesGlobalCopy(t *testing.T) {
+	t.Chdir(t.TempDir())
+	data := []byte("chunk data")
+	if err := os.MkdirAll("d1", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := storeChunk(filepath.Join("d1", "chunk_0.dat"), "h1", data); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.Stat(globalChunkPath("h1"))
+	if err := storeChunk(filepath.Join("d1", "chunk_1.dat"), "h1", data); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(globalChunkPath("h1"))
+	if before == nil || after == nil || !os.SameFile(before, after) {
+		t.Error("global chunk was replaced instead of reused")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DescriptorExt is the extension given to exported descriptors.
+const DescriptorExt = ".p2pdesc"
+
+// descriptorVersion is bumped if the descriptor format changes incompatibly.
+const descriptorVersion = 1
+
+// Descriptor holds everything needed to download a file without asking a
+// tracker, much like a torrent file: the chunk hashes to validate against
+// and the peers that were seeding it when it was exported.
+type Descriptor struct {
+	Version     int         `json:"version"`
+	GroupID     string      `json:"group_id"`
+	FileName    string      `json:"file_name"`
+	FileHash    string      `json:"file_hash"`
+	FileSize    int64       `json:"file_size"`
+	ChunkSize   int64       `json:"chunk_size"`
+	TotalChunks int         `json:"total_chunks"`
+	Chunks      []ChunkInfo `json:"chunks"`
+	Peers       []string    `json:"peers"`
+	Trackers    []string    `json:"trackers"` // for recipients who do get tracker access later
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// NewDescriptor builds a descriptor from the tracker's file info.
+func NewDescriptor(groupID string, info *FileInfo) *Descriptor {
+	return &Descriptor{
+		Version:     descriptorVersion,
+		GroupID:     groupID,
+		FileName:    info.FileName,
+		FileHash:    info.FileHash,
+		FileSize:    info.FileSize,
+		ChunkSize:   info.ChunkSize,
+		TotalChunks: info.TotalChunks,
+		Chunks:      info.Chunks,
+		Peers:       info.Peers,
+		Trackers:    append([]string(nil), State.TrackerAddrs...),
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+// ExportDescriptor writes a descriptor for groupID/fileName to path, or to
+// <fileName>.p2pdesc when path is empty, and returns where it was written.
+func ExportDescriptor(groupID, fileName, path string) (*Descriptor, string, error) {
+	info, err := queryFileInfo(groupID, fileName)
+	if err != nil {
+		return nil, "", err
+	}
+	addDHTPeers(info)
+	addGossipPeers(info)
+
+	if path == "" {
+		path = filepath.Base(fileName) + DescriptorExt
+	}
+	desc := NewDescriptor(groupID, info)
+	if err := WriteDescriptor(path, desc); err != nil {
+		return nil, "", err
+	}
+	return desc, path, nil
+}
+
+// WriteDescriptor saves desc to path as JSON.
+func WriteDescriptor(path string, desc *Descriptor) error {
+	data, err := json.MarshalIndent(desc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadDescriptor loads and sanity-checks a descriptor file.
+func ReadDescriptor(path string) (*Descriptor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var desc Descriptor
+	if err := json.Unmarshal(data, &desc); err != nil {
+		return nil, fmt.Errorf("invalid descriptor: %v", err)
+	}
+	if desc.Version != descriptorVersion {
+		return nil, fmt.Errorf("unsupported descriptor version %d", desc.Version)
+	}
+	if desc.FileName == "" || len(desc.FileHash) != 64 {
+		return nil, errors.New("invalid descriptor: missing file name or hash")
+	}
+	if desc.TotalChunks <= 0 || len(desc.Chunks) != desc.TotalChunks {
+		return nil, fmt.Errorf("invalid descriptor: %d chunk hashes for %d chunks", len(desc.Chunks), desc.TotalChunks)
+	}
+	return &desc, nil
+}
+
+// FileInfo converts the descriptor to the form the downloader works with.
+func (d *Descriptor) FileInfo() *FileInfo {
+	return &FileInfo{
+		FileName:    d.FileName,
+		FileHash:    d.FileHash,
+		FileSize:    d.FileSize,
+		ChunkSize:   d.ChunkSize,
+		TotalChunks: d.TotalChunks,
+		Chunks:      d.Chunks,
+		Peers:       append([]string(nil), d.Peers...),
+	}
+}
+
+// DownloadFromDescriptor downloads the file a descriptor describes straight
+// from its peers. The tracker is never contacted.
+func DownloadFromDescriptor(desc *Descriptor, destPath string) error {
+	info := desc.FileInfo()
+	addGossipPeers(info)
+	return downloadFromInfo(info, destPath)
+}
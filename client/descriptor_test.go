@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// TestDescriptorRoundTrip exports a descriptor, reads it back and downloads
+// the file from it with a tracker that must not be contacted.
+func TestDescriptorRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*ChunkSize+100)
+	peer := startMemoryPeer(t, meta.FileHash, memoryChunks(t, meta))
+	if err := os.RemoveAll(ChunksDir); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newFakeDHT()
+	d.files["g1:orig.bin"] = dhtMetadata(meta, "g1")
+	for i := 0; i < meta.TotalChunks; i++ {
+		d.AnnounceChunk(meta.FileHash, i, peer)
+	}
+	useTestNetwork(t, startEmptyTracker(t), d)
+
+	desc, path, err := ExportDescriptor("g1", "orig.bin", "")
+	if err != nil {
+		t.Fatalf("ExportDescriptor: %v", err)
+	}
+	if path != "orig.bin"+DescriptorExt {
+		t.Errorf("descriptor written to %q", path)
+	}
+
+	read, err := ReadDescriptor(path)
+	if err != nil {
+		t.Fatalf("ReadDescriptor: %v", err)
+	}
+	if !reflect.DeepEqual(read.Chunks, desc.Chunks) || read.FileHash != meta.FileHash ||
+		read.TotalChunks != meta.TotalChunks || read.FileSize != meta.FileSize {
+		t.Errorf("descriptor changed on the round trip:\n got %+v\nwant %+v", read, desc)
+	}
+	if len(read.Peers) != 1 || read.Peers[0] != peer {
+		t.Errorf("peers = %v, want [%s]", read.Peers, peer)
+	}
+
+	tracker, dials := startCountingTracker(t)
+	useTestNetwork(t, tracker, nil)
+	if err := DownloadFromDescriptor(read, "copy.bin"); err != nil {
+		t.Fatalf("DownloadFromDescriptor: %v", err)
+	}
+	if got, _ := os.ReadFile("copy.bin"); !bytes.Equal(got, content) {
+		t.Error("file downloaded from descriptor differs from original")
+	}
+	if n := atomic.LoadInt64(dials); n != 0 {
+		t.Errorf("tracker contacted %d times during descriptor download", n)
+	}
+}
+
+func TestReadDescriptor_Rejects(t *testing.T) {
+	dir := t.TempDir()
+	good := &Descriptor{
+		Version:     descriptorVersion,
+		FileName:    "a.bin",
+		FileHash:    string(bytes.Repeat([]byte("a"), 64)),
+		TotalChunks: 1,
+		Chunks:      []ChunkInfo{{Index: 0, Hash: "h", Size: 1}},
+	}
+	path := filepath.Join(dir, "good"+DescriptorExt)
+	if err := WriteDescriptor(path, good); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadDescriptor(path); err != nil {
+		t.Fatalf("valid descriptor rejected: %v", err)
+	}
+
+	bad := map[string]func(d *Descriptor){
+		"version":     func(d *Descriptor) { d.Version = 99 },
+		"no hash":     func(d *Descriptor) { d.FileHash = "" },
+		"chunk count": func(d *Descriptor) { d.TotalChunks = 2 },
+	}
+	for name, mutate := range bad {
+		d := *good
+		mutate(&d)
+		path := filepath.Join(dir, name+DescriptorExt)
+		if err := WriteDescriptor(path, &d); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadDescriptor(path); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	garbage := filepath.Join(dir, "garbage"+DescriptorExt)
+	os.WriteFile(garbage, []byte("not json"), 0644)
+	if _, err := ReadDescriptor(garbage); err == nil {
+		t.Error("garbage accepted")
+	}
+}
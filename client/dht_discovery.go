@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"p2p/dht"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ChunkDirectory is the part of the DHT the client uses to find files and
+// the peers holding their chunks. *dht.P2PClient satisfies it.
+type ChunkDirectory interface {
+	GetFileInfo(groupID, fileName string) (*dht.FileMetadata, error)
+	AnnounceChunk(fileHash string, chunkIndex int, peerAddr string) error
+	GetChunkPeers(fileHash string, chunkIndex int) ([]string, error)
+}
+
+// dhtLookupWorkers bounds the chunk lookups addDHTPeers runs at once.
+const dhtLookupWorkers = 8
+
+// trackerDHT is nil unless InitPeerDHT joined the trackers' DHT ring.
+// Use peerDHT and setPeerDHT: background announces read it concurrently.
+// stopDHT stops the node InitPeerDHT started; see ClosePeerDHT.
+var (
+	dhtMu      sync.RWMutex
+	trackerDHT ChunkDirectory
+	stopDHT    func()
+)
+
+func peerDHT() ChunkDirectory {
+	dhtMu.RLock()
+	defer dhtMu.RUnlock()
+	return trackerDHT
+}
+
+func setPeerDHT(d ChunkDirectory) {
+	dhtMu.Lock()
+	trackerDHT = d
+	dhtMu.Unlock()
+}
+
+// dhtOnly reports whether P2P_DHT_ONLY=1 asks downloads to find files and
+// peers through the DHT alone, never contacting a tracker.
+func dhtOnly() bool {
+	return os.Getenv("P2P_DHT_ONLY") == "1"
+}
+
+// InitPeerDHT joins the trackers' DHT ring as nodeID when P2P_DHT_PORT is set and
+// the client isn't offline.
+// Tracker DHT nodes listen on their tracker port + 1000, as in the tracker's adapter.
+func InitPeerDHT(nodeID string) error {
+	portStr := os.Getenv("P2P_DHT_PORT")
+	if portStr == "" || offline {
+		return nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid P2P_DHT_PORT %q", portStr)
+	}
+
+	peers := make([]dht.PeerConfig, 0, len(State.TrackerAddrs))
+	for i, entry := range State.TrackerAddrs {
+		addr := trackerEndpoint(entry).Addr
+		var trackerPort int
+		if idx := strings.LastIndex(addr, ":"); idx >= 0 {
+			trackerPort, _ = strconv.Atoi(addr[idx+1:])
+		}
+		peers = append(peers, dht.PeerConfig{
+			NodeID: fmt.Sprintf("tracker_%d", i+1),
+			Host:   "127.0.0.1",
+			Port:   trackerPort + 1000,
+		})
+	}
+
+	config := &dht.Config{
+		NodeID:            nodeID,
+		Host:              "127.0.0.1",
+		Port:              port,
+		Peers:             peers,
+		ReplicationFactor: 3,
+		ReadQuorum:        2,
+		WriteQuorum:       2,
+	}
+	client, err := dht.NewP2PClient(config, State.TrackerAddrs)
+	if err != nil {
+		return fmt.Errorf("failed to create DHT client: %v", err)
+	}
+	if err := client.Start(); err != nil {
+		return fmt.Errorf("failed to start DHT: %v", err)
+	}
+
+	setPeerDHT(client)
+	dhtMu.Lock()
+	stopDHT = func() { client.Stop() }
+	dhtMu.Unlock()
+	return nil
+}
+
+// InitDownloadDHT joins the DHT for one download command. Each process
+// gets a node of its own, as a node's store can only be open in one
+// process and downloads can run side by side; ClosePeerDHT removes it.
+func InitDownloadDHT() error {
+	nodeID := fmt.Sprintf("peer_%s_download_%d", State.UserID, os.Getpid())
+	if err := InitPeerDHT(nodeID); err != nil {
+		return err
+	}
+	dhtMu.Lock()
+	defer dhtMu.Unlock()
+	if stop := stopDHT; stop != nil {
+		stopDHT = func() {
+			stop()
+			os.RemoveAll(filepath.Join("data", nodeID)) // where the dht package keeps a node's store
+		}
+	}
+	return nil
+}
+
+// ClosePeerDHT leaves the DHT, if InitPeerDHT joined it.
+func ClosePeerDHT() {
+	dhtMu.Lock()
+	stop := stopDHT
+	trackerDHT, stopDHT = nil, nil
+	dhtMu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+// queryFileInfoDHT looks up file metadata in the DHT. Peers are left empty;
+// addDHTPeers fills them in from the chunk announcements.
+func queryFileInfoDHT(groupID, fileName string) (*FileInfo, error) {
+	meta, err := peerDHT().GetFileInfo(groupID, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if meta.FileHash == "" {
+		return nil, fmt.Errorf("file not found in DHT")
+	}
+
+	chunks := make([]ChunkInfo, len(meta.Chunks))
+	for i, c := range meta.Chunks {
+		chunks[i] = ChunkInfo{Index: c.Index, Hash: c.Hash, Size: c.Size, HashAlgorithm: c.HashAlgorithm}
+	}
+	return &FileInfo{
+		FileName:    meta.FileName,
+		FileHash:    meta.FileHash,
+		FileSize:    meta.FileSize,
+		ChunkSize:   meta.ChunkSize,
+		TotalChunks: meta.TotalChunks,
+		Chunks:      chunks,
+	}, nil
+}
+
+// addDHTPeers adds the peers the DHT knows hold chunks of fileInfo to the
+// ones the tracker listed, and records which chunks each announced in
+// fileInfo.ChunkPeers. The DHT has one entry per chunk, so the lookups run
+// dhtLookupWorkers at a time.
+func addDHTPeers(fileInfo *FileInfo) {
+	d := peerDHT()
+	if d == nil {
+		return
+	}
+
+	found := make([][]string, fileInfo.TotalChunks)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(dhtLookupWorkers, fileInfo.TotalChunks) {
+		wg.Go(func() {
+			for i := range next {
+				if peers, err := d.GetChunkPeers(fileInfo.FileHash, i); err == nil {
+					found[i] = peers
+				}
+			}
+		})
+	}
+	for i := range fileInfo.TotalChunks {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, p := range fileInfo.Peers {
+		seen[p] = true
+	}
+	for i, peers := range found {
+		if len(peers) == 0 {
+			continue
+		}
+		if fileInfo.ChunkPeers == nil {
+			fileInfo.ChunkPeers = make(map[int][]string)
+		}
+		fileInfo.ChunkPeers[i] = peers
+		for _, p := range peers {
+			if !seen[p] {
+				seen[p] = true
+				fileInfo.Peers = append(fileInfo.Peers, p)
+			}
+		}
+	}
+}
+
+// announceChunk tells the DHT this peer can serve a chunk. Best effort only.
+func announceChunk(fileHash string, chunkIdx int) {
+	d := peerDHT()
+	if d == nil || State.ListenAddr == "" {
+		return
+	}
+	d.AnnounceChunk(fileHash, chunkIdx, "127.0.0.1"+State.ListenAddr)
+}
+
+// announceFile announces every chunk of a finished download.
+func announceFile(fileInfo *FileInfo) {
+	for i := 0; i < fileInfo.TotalChunks; i++ {
+		announceChunk(fileInfo.FileHash, i)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"p2p/common"
+	"p2p/dht"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDHT is an in-memory ChunkDirectory.
+type fakeDHT struct {
+	mu     sync.Mutex
+	files  map[string]*dht.FileMetadata
+	chunks map[string][]string
+}
+
+func newFakeDHT() *fakeDHT {
+	return &fakeDHT{files: make(map[string]*dht.FileMetadata), chunks: make(map[string][]string)}
+}
+
+func (f *fakeDHT) GetFileInfo(groupID, fileName string) (*dht.FileMetadata, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	meta, ok := f.files[groupID+":"+fileName]
+	if !ok {
+		return nil, fmt.Errorf("not found")
+	}
+	return meta, nil
+}
+
+func (f *fakeDHT) AnnounceChunk(fileHash string, chunkIndex int, peerAddr string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := fmt.Sprintf("%s:%d", fileHash, chunkIndex)
+	for _, p := range f.chunks[key] {
+		if p == peerAddr {
+			return nil
+		}
+	}
+	f.chunks[key] = append(f.chunks[key], peerAddr)
+	return nil
+}
+
+func (f *fakeDHT) GetChunkPeers(fileHash string, chunkIndex int) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.chunks[fmt.Sprintf("%s:%d", fileHash, chunkIndex)]...), nil
+}
+
+// startEmptyTracker runs a tracker stand-in that knows no files at all.
+func startEmptyTracker(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var msg Message
+				if err := recvRequest(c, &msg); err != nil {
+					return
+				}
+				common.Send(c, Response{"error", "file not found"})
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startMemoryPeer serves the given chunks of fileHash from memory, so the
+// test's own chunk store can stay empty. A nil chunk is one the peer lacks.
+func startMemoryPeer(t *testing.T, fileHash string, chunks [][]byte) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var req PeerRequest
+				if err := common.Recv(c, &req); err != nil {
+					return
+				}
+				switch {
+				case req.FileHash != fileHash:
+					common.Send(c, PeerResponse{Status: "error"})
+				case req.Cmd == "handshake":
+					common.Send(c, PeerResponse{Status: "ok"})
+				case req.Cmd == "get_piece" && req.PieceIdx < len(chunks) && chunks[req.PieceIdx] != nil:
+					common.Send(c, PeerResponse{Status: "ok", Data: chunks[req.PieceIdx]})
+				default:
+					common.Send(c, PeerResponse{Status: "error"})
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// moveChunksToMemory reads meta's chunks out of our own store and empties
+// it, so the chunks can be served by an in-memory peer.
+func moveChunksToMemory(t *testing.T, meta *ChunkMetadata) [][]byte {
+	t.Helper()
+	chunks := make([][]byte, meta.TotalChunks)
+	for i := range chunks {
+		data, err := os.ReadFile(filepath.Join(ChunksDir, meta.FileHash, fmt.Sprintf("chunk_%d.dat", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks[i] = data
+	}
+	if err := os.RemoveAll(ChunksDir); err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+// dhtMetadata converts chunk metadata to the record the DHT stores for it.
+func dhtMetadata(meta *ChunkMetadata, groupID string) *dht.FileMetadata {
+	chunks := make([]dht.ChunkInfo, len(meta.Chunks))
+	for i, c := range meta.Chunks {
+		chunks[i] = dht.ChunkInfo{Index: c.Index, Hash: c.Hash, Size: c.Size}
+	}
+	return &dht.FileMetadata{
+		FileName:    meta.FileName,
+		GroupID:     groupID,
+		FileSize:    meta.FileSize,
+		FileHash:    meta.FileHash,
+		ChunkSize:   meta.ChunkSize,
+		TotalChunks: meta.TotalChunks,
+		Chunks:      chunks,
+	}
+}
+
+// useTestNetwork points the client at tracker and installs d as the DHT,
+// restoring the previous globals when the test ends.
+func useTestNetwork(t *testing.T, tracker string, d ChunkDirectory) {
+	t.Helper()
+	savedAddrs, savedActive, savedDHT := State.TrackerAddrs, State.ActiveTrackers, peerDHT()
+	State.TrackerAddrs = []string{tracker}
+	State.ActiveTrackers = []string{tracker}
+	setPeerDHT(d)
+	t.Cleanup(func() {
+		State.TrackerAddrs, State.ActiveTrackers = savedAddrs, savedActive
+		setPeerDHT(savedDHT)
+	})
+}
+
+// TestDownloadFile_FromDHTOnly has a tracker with no file metadata and a DHT
+// that holds the metadata and chunk locations, and checks the download succeeds.
+func TestDownloadFile_FromDHTOnly(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*ChunkSize+100)
+
+	chunks := moveChunksToMemory(t, meta)
+	peer := startMemoryPeer(t, meta.FileHash, chunks)
+
+	d := newFakeDHT()
+	d.files["g1:orig.bin"] = dhtMetadata(meta, "g1")
+	for i := 0; i < meta.TotalChunks; i++ {
+		d.AnnounceChunk(meta.FileHash, i, peer)
+	}
+	useTestNetwork(t, startEmptyTracker(t), d)
+
+	if err := DownloadFile("g1", "orig.bin", "downloaded.bin"); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	got, err := os.ReadFile("downloaded.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("downloaded file differs from original")
+	}
+}
+
+// TestQueryFileInfo_NoDHT verifies the tracker error is returned unchanged when no DHT is configured.
+func TestQueryFileInfo_NoDHT(t *testing.T) {
+	useTestNetwork(t, startEmptyTracker(t), nil)
+	if _, err := queryFileInfo("g1", "missing.bin"); err == nil {
+		t.Fatal("expected tracker error")
+	}
+}
+
+// TestAnnounceChunk_OnServe verifies serving a piece announces it to the DHT.
+func TestAnnounceChunk_OnServe(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 10)
+	peer := startTestPeer(t)
+
+	d := newFakeDHT()
+	useTestNetwork(t, "127.0.0.1:1", d)
+	savedListen := State.ListenAddr
+	State.ListenAddr = ":4242"
+	t.Cleanup(func() { State.ListenAddr = savedListen })
+
+	if _, _, err := requestChunk(peer, meta.FileHash, 0); err != nil {
+		t.Fatal(err)
+	}
+	// The announce runs in the background after the response is sent
+	for i := 0; i < 100; i++ {
+		if peers, _ := d.GetChunkPeers(meta.FileHash, 0); len(peers) == 1 {
+			if peers[0] != "127.0.0.1:4242" {
+				t.Errorf("announced %q", peers[0])
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("chunk was never announced")
+}
+
+// TestDownloadFile_DHTOnlyTwoPeers splits a file's chunks between two peers
+// known only to the DHT and downloads it with P2P_DHT_ONLY=1, checking the
+// tracker is never contacted and the downloader announces itself afterwards.
+func TestDownloadFile_DHTOnlyTwoPeers(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*ChunkSize+100)
+	chunks := moveChunksToMemory(t, meta)
+
+	// Peer A holds the first chunk, peer B the rest
+	first, rest := make([][]byte, len(chunks)), make([][]byte, len(chunks))
+	first[0] = chunks[0]
+	copy(rest[1:], chunks[1:])
+	peerA, peerB := startMemoryPeer(t, meta.FileHash, first), startMemoryPeer(t, meta.FileHash, rest)
+
+	d := newFakeDHT()
+	d.files["g1:orig.bin"] = dhtMetadata(meta, "g1")
+	d.AnnounceChunk(meta.FileHash, 0, peerA)
+	for i := 1; i < meta.TotalChunks; i++ {
+		d.AnnounceChunk(meta.FileHash, i, peerB)
+	}
+	tracker, cmds := startRecordingTracker(t, nil)
+	useTestNetwork(t, tracker, d)
+	t.Setenv("P2P_DHT_ONLY", "1")
+	savedListen := State.ListenAddr
+	State.ListenAddr = ":4243"
+	t.Cleanup(func() { State.ListenAddr = savedListen })
+
+	if err := DownloadFile("g1", "orig.bin", "downloaded.bin"); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	got, err := os.ReadFile("downloaded.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("downloaded file differs from original")
+	}
+	if sent := cmds(); len(sent) != 0 {
+		t.Errorf("DHT-only download contacted the tracker: %v", sent)
+	}
+	for i := 0; i < meta.TotalChunks; i++ {
+		peers, _ := d.GetChunkPeers(meta.FileHash, i)
+		if peers[len(peers)-1] != "127.0.0.1:4243" {
+			t.Errorf("chunk %d not announced after download: %v", i, peers)
+		}
+	}
+}
+
+func TestQueryFileInfo_DHTOnlyWithoutDHT(t *testing.T) {
+	tracker, cmds := startRecordingTracker(t, nil)
+	useTestNetwork(t, tracker, nil)
+	t.Setenv("P2P_DHT_ONLY", "1")
+	if _, err := queryFileInfo("g1", "orig.bin"); err == nil {
+		t.Fatal("expected an error with no DHT to ask")
+	}
+	if sent := cmds(); len(sent) != 0 {
+		t.Errorf("contacted the tracker: %v", sent)
+	}
+}
+
+// TestChunkCandidates_DHTHoldersFirst checks peers the DHT lists for a chunk
+// are tried before the others.
+func TestChunkCandidates_DHTHoldersFirst(t *testing.T) {
+	info := &FileInfo{
+		Peers:      []string{"a", "b", "c"},
+		ChunkPeers: map[int][]string{0: {"c"}},
+	}
+	if got := chunkCandidates(info, nil, 0); got[0] != "c" || len(got) != 3 {
+		t.Errorf("chunk 0 candidates = %v, want c first", got)
+	}
+	if got := chunkCandidates(info, nil, 1); got[0] != "b" {
+		t.Errorf("chunk 1 candidates = %v, want round-robin order", got)
+	}
+}
+
+// slowDHT is a fakeDHT whose chunk lookups take a while, counting how many
+// run at once and in all.
+type slowDHT struct {
+	*fakeDHT
+	running, most, calls atomic.Int32
+}
+
+func (s *slowDHT) GetChunkPeers(fileHash string, chunkIndex int) ([]string, error) {
+	s.calls.Add(1)
+	n := s.running.Add(1)
+	defer s.running.Add(-1)
+	for m := s.most.Load(); n > m && !s.most.CompareAndSwap(m, n); m = s.most.Load() {
+	}
+	time.Sleep(5 * time.Millisecond)
+	return s.fakeDHT.GetChunkPeers(fileHash, chunkIndex)
+}
+
+// TestAddDHTPeers_Lookups checks the per-chunk lookups run concurrently,
+// keep chunk order, and add to the peers the tracker listed.
+func TestAddDHTPeers_Lookups(t *testing.T) {
+	d := &slowDHT{fakeDHT: newFakeDHT()}
+	for i := 0; i < 16; i++ {
+		d.AnnounceChunk("h", i, fmt.Sprintf("peer%d", i%3))
+	}
+	useTestNetwork(t, startEmptyTracker(t), d)
+
+	info := &FileInfo{FileHash: "h", TotalChunks: 16}
+	addDHTPeers(info)
+	if want := []string{"peer0", "peer1", "peer2"}; !reflect.DeepEqual(info.Peers, want) {
+		t.Errorf("peers = %v, want %v", info.Peers, want)
+	}
+	if got := info.ChunkPeers[4]; !reflect.DeepEqual(got, []string{"peer1"}) {
+		t.Errorf("chunk 4 holders = %v", got)
+	}
+	if most := d.most.Load(); most < 2 || most > dhtLookupWorkers {
+		t.Errorf("%d lookups ran at once, want 2..%d", most, dhtLookupWorkers)
+	}
+
+	d.calls.Store(0)
+	known := &FileInfo{FileHash: "h", TotalChunks: 16, Peers: []string{"tracker-peer", "peer1"}}
+	addDHTPeers(known)
+	if want := []string{"tracker-peer", "peer1", "peer0", "peer2"}; !reflect.DeepEqual(known.Peers, want) {
+		t.Errorf("merged peers = %v, want %v", known.Peers, want)
+	}
+	if n := d.calls.Load(); n != 16 {
+		t.Errorf("%d lookups with peers known, want 16", n)
+	}
+}
+
+// TestInitDownloadDHT_ClosedAndRemoved checks a download's DHT node is
+// named for its process and its store is removed when it is closed.
+func TestInitDownloadDHT_ClosedAndRemoved(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("P2P_DHT_PORT", "0")
+	useTestNetwork(t, startEmptyTracker(t), nil)
+	State.UserID = "alice"
+	t.Cleanup(func() { State.UserID = "" })
+	store := filepath.Join("data", fmt.Sprintf("peer_alice_download_%d", os.Getpid()))
+	os.MkdirAll(store, 0755)
+
+	if err := InitDownloadDHT(); err != nil || peerDHT() == nil {
+		t.Fatalf("InitDownloadDHT = %v", err)
+	}
+	ClosePeerDHT()
+	if peerDHT() != nil {
+		t.Error("DHT still set after ClosePeerDHT")
+	}
+	if _, err := os.Stat(store); !os.IsNotExist(err) {
+		t.Errorf("store %s left behind: %v", store, err)
+	}
+}
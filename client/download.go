@@ -1,8 +1,6 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +9,7 @@ import (
 	"p2p/common"
 	"path/filepath"
 	"sort"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,17 +22,139 @@ type FileInfo struct {
 	TotalChunks int         `json:"total_chunks"`
 	Chunks      []ChunkInfo `json:"chunks"`
 	Peers       []string    `json:"peers"`
+	MerkleRoot  string      `json:"merkle_root,omitempty"`
+
+	// Token is the download token the tracker issued us for this file
+	Token string `json:"token,omitempty"`
+
+	// ChunkPeers lists, per chunk index, the peers the DHT says hold it
+	ChunkPeers map[int][]string `json:"-"`
+
+	// refresh, when set, asks for the file's peers again, so a chunk no
+	// peer could provide can be retried from peers that came online since
+	refresh func() (*FileInfo, error)
 }
 
 // DownloadFile downloads a file from peers using P2P chunk transfer.
 // Resumable: already-downloaded chunks are skipped on restart.
 func DownloadFile(groupID, fileName, destPath string) error {
-	// 1. Get file info from tracker
+	// 1. Get file info from tracker (or only the DHT with P2P_DHT_ONLY=1)
 	fileInfo, err := queryFileInfo(groupID, fileName)
 	if err != nil {
 		return fmt.Errorf("failed to get file info: %v", err)
 	}
 
+	// A file stored already, downloaded or shared under another name or in
+	// another group, is put together from its chunks without any peer.
+	// Chunks that fail their hash are fetched from peers again.
+	if hasCachedChunks(fileInfo.FileHash, fileInfo.TotalChunks) {
+		err = assembleCachedFile(fileInfo, destPath)
+		if errors.Is(err, errCachedChunksUnverified) {
+			fmt.Printf("%v; downloading from peers\n", err)
+			err = downloadFromPeers(groupID, fileName, fileInfo, destPath)
+		}
+	} else {
+		err = downloadFromPeers(groupID, fileName, fileInfo, destPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Without the tracker's add_seeder, the DHT is the only way others find us
+	if dhtOnly() {
+		announceFile(fileInfo)
+	}
+	return nil
+}
+
+// downloadFromPeers downloads the file fileInfo describes from its peers,
+// refreshing the peer list from the tracker as needed.
+func downloadFromPeers(groupID, fileName string, fileInfo *FileInfo, destPath string) error {
+	// Supplement the tracker's peer list with peers announced in the DHT or gossiped to us
+	addDHTPeers(fileInfo)
+	addGossipPeers(fileInfo)
+
+	refresh := func() (*FileInfo, error) {
+		trackerCache.InvalidateGroup(groupID)
+		info, err := queryFileInfo(groupID, fileName)
+		if err != nil {
+			return nil, err
+		}
+		addDHTPeers(info)
+		addGossipPeers(info)
+		return info, nil
+	}
+	if downloadConfig.checksSeeders() {
+		var err error
+		if fileInfo, err = awaitSeeders(fileInfo, downloadConfig, refresh); err != nil {
+			return err
+		}
+	}
+	fileInfo.refresh = refresh
+	return downloadFromInfo(fileInfo, destPath)
+}
+
+// errCachedChunksUnverified is returned by assembleCachedFile when the
+// stored chunks can't be shown to match the file's chunk hashes.
+var errCachedChunksUnverified = errors.New("cached chunks failed verification")
+
+// assembleCachedFile writes the file fileInfo describes to destPath from
+// the chunks stored under its hash, which hasCachedChunks found complete,
+// checking each against its hash. Chunks that fail are deleted, with the
+// checkpoint counting them done, so a download from peers fetches them
+// again, and errCachedChunksUnverified is returned.
+func assembleCachedFile(fileInfo *FileInfo, destPath string) error {
+	if len(fileInfo.Chunks) < fileInfo.TotalChunks {
+		return fmt.Errorf("%w: no chunk hashes to check them against", errCachedChunksUnverified)
+	}
+	chunkDir := filepath.Join(ChunksDir, fileInfo.FileHash)
+	out, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to assemble file: %v", err)
+	}
+	bad := 0
+	for i := 0; i < fileInfo.TotalChunks; i++ {
+		chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))
+		data, err := os.ReadFile(chunkPath)
+		if err != nil || !validateChunkHash(data, fileInfo.Chunks[i].HashAlgorithm, fileInfo.Chunks[i].Hash) {
+			os.Remove(chunkPath)
+			bad++
+			continue
+		}
+		if bad > 0 {
+			continue
+		}
+		if _, err := out.Write(data); err != nil {
+			out.Close()
+			return fmt.Errorf("failed to assemble file: %v", err)
+		}
+	}
+	if err := out.Close(); err != nil && bad == 0 {
+		return fmt.Errorf("failed to assemble file: %v", err)
+	}
+	if bad > 0 {
+		os.Remove(destPath)
+		os.Remove(filepath.Join(chunkDir, CheckpointFile))
+		return fmt.Errorf("%w: %d of %d chunks", errCachedChunksUnverified, bad, fileInfo.TotalChunks)
+	}
+	if err := verifyAssembledFile(destPath, fileInfo); err != nil {
+		return err
+	}
+	fmt.Printf("Using locally cached chunks (%d chunks reused)\n", fileInfo.TotalChunks)
+
+	// Chunks left by a download that stopped before it was assembled have
+	// no metadata to be served with yet
+	if _, err := loadChunkMetadataHeader(fileInfo.FileHash); err != nil {
+		saveDownloadMetadata(chunkDir, fileInfo)
+	}
+	resumeServing(chunkDir)
+	return nil
+}
+
+// downloadFromInfo downloads the file described by fileInfo from its peers
+// and assembles it at destPath. It doesn't talk to the tracker, but for
+// fileInfo's refresh when a chunk can't be had from any peer.
+func downloadFromInfo(fileInfo *FileInfo, destPath string) error {
 	if len(fileInfo.Peers) == 0 {
 		return errors.New("no peers available for download")
 	}
@@ -41,6 +162,19 @@ func DownloadFile(groupID, fileName, destPath string) error {
 	fmt.Printf("File hash: %s...\n", fileInfo.FileHash[:16])
 	fmt.Printf("Total chunks: %d\n", fileInfo.TotalChunks)
 	fmt.Printf("Available peers: %d\n", len(fileInfo.Peers))
+	transfers.NameFile(fileInfo.FileHash, fileInfo.FileName, fileInfo.TotalChunks)
+
+	// Measure peers we have no recent speed for, so the fastest are tried first
+	if !skipSpeedTest && len(fileInfo.Peers) > 1 {
+		if stale := unrankedPeers(fileInfo.Peers, time.Now()); len(stale) > 0 {
+			fmt.Printf("Speed testing %d peers (skip with --skip-speed-test)...\n", len(stale))
+			results, _ := SpeedTest(fileInfo, stale)
+			if len(results) > 0 {
+				fmt.Printf("Fastest peer: %s (%.2f MB/s)\n", results[0].Peer, results[0].MBps)
+				SaveSession()
+			}
+		}
+	}
 
 	// 2. Prepare local chunk directory (supports resume + final assembly)
 	chunkDir := filepath.Join(ChunksDir, fileInfo.FileHash)
@@ -48,68 +182,76 @@ func DownloadFile(groupID, fileName, destPath string) error {
 		return fmt.Errorf("failed to create chunk dir: %v", err)
 	}
 
-	// 3. Choose chunk download order: rarest-first or sequential (round-robin)
-	var order []int
-	var peerBitfields map[string][]bool // non-nil only in rarest-first mode
+	// 3. Choose chunk download order with the configured piece selector.
+	// Chunks already on disk from an earlier run aren't offered to it; those
+	// the checkpoint says are complete aren't even checked.
+	name, selector, err := currentSelector()
+	if err != nil {
+		return err
+	}
+	cp := loadCheckpoint(chunkDir, fileInfo)
+	if cp != nil {
+		fmt.Printf("Resuming from checkpoint: chunks 0-%d complete\n", cp.LastCompletedChunk)
+	}
+	done := chunksOnDisk(chunkDir, fileInfo, cp)
+	available := make([]int, 0, fileInfo.TotalChunks)
+	for i, d := range done {
+		if !d {
+			available = append(available, i)
+		}
+	}
+	checkpoint := newCheckpointer(chunkDir, fileInfo, done)
 
-	if os.Getenv("P2P_RAREST_FIRST") != "" {
+	var peerBitfields map[string][]bool // nil unless the selector wants bitfields
+	if needsBitfields(selector) {
 		peerBitfields = getBitfields(fileInfo.Peers, fileInfo.FileHash)
-		order = buildRarityOrder(peerBitfields, fileInfo.TotalChunks)
-		fmt.Printf("Piece selection: rarest-first (queried %d peers)\n", len(peerBitfields))
-	} else {
-		order = make([]int, fileInfo.TotalChunks)
-		for i := range order {
-			order[i] = i
-		}
+		fmt.Printf("Piece selection: %s (queried %d peers)\n", name, len(peerBitfields))
+	} else if name != SelectorSequential {
+		fmt.Printf("Piece selection: %s\n", name)
 	}
+	order := completeOrder(selector.Select(available, peerBitfields, fileInfo.TotalChunks), available)
 
 	// 4. Download missing chunks in chosen order — skip those already on disk
-	downloaded := 0
-	skipped := 0
-	for _, i := range order {
+	label := ""
+	if name != SelectorSequential {
+		label = " (" + name + ")"
+	}
+	var downloaded int64
+	skipped := int64(fileInfo.TotalChunks - len(available))
+	workers := parallelWorkers()
+
+	// A sequential download fetches the next chunk while this one downloads
+	var prefetch *PrefetchCache
+	if name == SelectorSequential && workers <= 1 {
+		prefetch = NewPrefetchCache(prefetchCapacity, prefetchMaxAge)
+	}
+	fetch := func(i int) error {
 		chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))
 
 		// Resume: chunk already downloaded in a previous run
 		if _, err := os.Stat(chunkPath); err == nil {
-			skipped++
-			continue
-		}
-
-		// Pick best peer for this chunk
-		var peer string
-		if peerBitfields != nil {
-			// Rarest-first: prefer peers known to have this specific chunk
-			qualified := make([]string, 0, len(peerBitfields))
-			for p, bf := range peerBitfields {
-				if bf == nil || (i < len(bf) && bf[i]) {
-					qualified = append(qualified, p)
-				}
-			}
-			if len(qualified) > 0 {
-				peer = qualified[i%len(qualified)]
-			} else {
-				peer = fileInfo.Peers[i%len(fileInfo.Peers)]
-			}
-			fmt.Printf("Downloading chunk %d/%d from %s (rarest-first)...\n", i+1, fileInfo.TotalChunks, peer)
-		} else {
-			peer = fileInfo.Peers[i%len(fileInfo.Peers)]
-			fmt.Printf("Downloading chunk %d/%d from %s...\n", i+1, fileInfo.TotalChunks, peer)
+			atomic.AddInt64(&skipped, 1)
+			checkpoint.complete(i, "")
+			return nil
 		}
 
-		chunkData, err := requestChunk(peer, fileInfo.FileHash, i)
+		// Write chunk immediately to disk (makes resume possible on interruption)
+		peer, err := takePrefetched(prefetch, fileInfo, i, chunkPath)
 		if err != nil {
-			return fmt.Errorf("failed to download chunk %d: %v", i, err)
+			peer, err = downloadChunk(chunkCandidates(fileInfo, peerBitfields, i), fileInfo, i, chunkPath, label)
 		}
-
-		if !validateChunkHash(chunkData, fileInfo.Chunks[i].Hash) {
-			return fmt.Errorf("chunk %d hash mismatch", i)
+		if err != nil && fileInfo.refresh != nil {
+			peer, err = recoverChunk(fileInfo, i, chunkPath, downloadConfig.ChunkTimeout)
 		}
-
-		// Write chunk immediately to disk (makes resume possible on interruption)
-		if err := os.WriteFile(chunkPath, chunkData, 0644); err != nil {
-			return fmt.Errorf("failed to save chunk %d: %v", i, err)
+		if err != nil {
+			return err
+		}
+		if peer != "" {
+			atomic.AddInt64(&downloaded, 1)
+		} else {
+			atomic.AddInt64(&skipped, 1)
 		}
-		downloaded++
+		checkpoint.complete(i, peer)
 
 		// Testing: P2P_CHUNK_DELAY=500ms slows download so interruption can be triggered
 		if d := os.Getenv("P2P_CHUNK_DELAY"); d != "" {
@@ -117,41 +259,88 @@ func DownloadFile(groupID, fileName, destPath string) error {
 				time.Sleep(delay)
 			}
 		}
-	} // end for-loop
+		return nil
+	}
+
+	var fetchErr error
+	if workers > 1 {
+		fmt.Printf("Parallel download: %d workers\n", workers)
+		fetchErr = runWorkers(order, workers, fetch)
+	} else {
+		for k, i := range order {
+			if prefetch != nil && k+1 < len(order) {
+				prefetchChunk(prefetch, fileInfo, peerBitfields, chunkDir, order[k+1])
+			}
+			if fetchErr = fetch(i); fetchErr != nil {
+				break
+			}
+		}
+	}
+	if fetchErr != nil {
+		// Keep what this run got for the next one
+		checkpoint.save()
+		return fetchErr
+	}
 
 	if skipped > 0 {
 		fmt.Printf("Resumed: skipped %d already-downloaded chunks\n", skipped)
 	}
 	fmt.Printf("Downloaded %d new chunks. All chunks validated ✓\n", downloaded)
+	if rate, lookups := prefetchHitRate(); prefetch != nil && lookups > 0 {
+		fmt.Printf("Prefetch hit rate: %.0f%% of %d chunks\n", rate*100, lookups)
+	}
 
 	// 4. Assemble file from disk chunks
-	if err := assembleFileFromDisk(chunkDir, fileInfo.TotalChunks, destPath); err != nil {
+	// A chunk missing here was wrongly checkpointed, so the checkpoint goes
+	// either way and the next run checks every chunk
+	err = assembleFileFromDisk(chunkDir, fileInfo.TotalChunks, destPath)
+	checkpoint.remove()
+	if err != nil {
 		return fmt.Errorf("failed to assemble file: %v", err)
 	}
+	if err := verifyAssembledFile(destPath, fileInfo); err != nil {
+		return err
+	}
 
 	// 5. Save metadata for peer serving
+	saveDownloadMetadata(chunkDir, fileInfo)
+	resumeServing(chunkDir)
+
+	return nil
+}
+
+// saveDownloadMetadata writes the metadata.json peers are served a
+// downloaded file's chunks with.
+func saveDownloadMetadata(chunkDir string, fileInfo *FileInfo) {
 	metadata := &ChunkMetadata{
-		FileName:    fileInfo.FileName,
-		FileSize:    fileInfo.FileSize,
-		FileHash:    fileInfo.FileHash,
-		ChunkSize:   fileInfo.ChunkSize,
-		TotalChunks: fileInfo.TotalChunks,
-		Chunks:      fileInfo.Chunks,
+		FileName:      fileInfo.FileName,
+		FileSize:      fileInfo.FileSize,
+		FileHash:      fileInfo.FileHash,
+		ChunkSize:     fileInfo.ChunkSize,
+		TotalChunks:   fileInfo.TotalChunks,
+		Chunks:        fileInfo.Chunks,
+		HashAlgorithm: fileInfo.hashAlgorithm(),
+		MerkleRoot:    fileInfo.MerkleRoot,
 	}
 	metadataJSON, _ := json.MarshalIndent(metadata, "", "  ")
 	os.WriteFile(filepath.Join(chunkDir, "metadata.json"), metadataJSON, 0644)
-
-	return nil
 }
 
 // getBitfields queries all peers for their bitfield (which chunks they have).
+// Bitfields already learned through gossip are used without asking the peer.
 // Returns map[peerAddr][]bool where index = chunk index.
 func getBitfields(peers []string, fileHash string) map[string][]bool {
 	result := make(map[string][]bool)
 	for _, peer := range peers {
+		if bf, ok := localGossip.cache.Get(fileHash, peer); ok {
+			result[peer] = bf
+			continue
+		}
 		bf := queryBitfield(peer, fileHash)
 		if bf != nil {
 			result[peer] = bf
+			localGossip.cache.Update(fileHash, peer, bf)
+			localGossip.recent.Add(peer)
 		}
 	}
 	// If no bitfields returned (old peers don't support get_bitfield), fall back
@@ -181,23 +370,29 @@ func queryBitfield(peerAddr, fileHash string) []bool {
 		return nil
 	}
 
-	// Convert []int index list to []bool indexed by chunk index
-	maxIdx := 0
-	for _, idx := range resp.Bitfield {
-		if idx > maxIdx {
-			maxIdx = idx
-		}
-	}
-	bf := make([]bool, maxIdx+1)
-	for _, idx := range resp.Bitfield {
-		bf[idx] = true
-	}
-	return bf
+	return indicesToBitfield(resp.Bitfield)
 }
 
 // buildRarityOrder returns chunk indices sorted by ascending peer availability (rarest first).
 func buildRarityOrder(peerBitfields map[string][]bool, totalChunks int) []int {
-	// Count how many peers have each chunk
+	count := chunkAvailability(peerBitfields, totalChunks)
+
+	// Sort chunk indices by count ascending (rarest first), then by index for stability
+	indices := make([]int, totalChunks)
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		if count[indices[a]] != count[indices[b]] {
+			return count[indices[a]] < count[indices[b]]
+		}
+		return indices[a] < indices[b]
+	})
+	return indices
+}
+
+// chunkAvailability counts how many peers have each chunk.
+func chunkAvailability(peerBitfields map[string][]bool, totalChunks int) []int {
 	count := make([]int, totalChunks)
 	for _, bf := range peerBitfields {
 		if bf == nil {
@@ -213,30 +408,32 @@ func buildRarityOrder(peerBitfields map[string][]bool, totalChunks int) []int {
 			}
 		}
 	}
-
-	// Sort chunk indices by count ascending (rarest first), then by index for stability
-	indices := make([]int, totalChunks)
-	for i := range indices {
-		indices[i] = i
-	}
-	sort.SliceStable(indices, func(a, b int) bool {
-		if count[indices[a]] != count[indices[b]] {
-			return count[indices[a]] < count[indices[b]]
-		}
-		return indices[a] < indices[b]
-	})
-	return indices
+	return count
 }
 
 // queryFileInfo requests file metadata from tracker.
 // State.UserID is included so the tracker can enforce group membership.
+// If the tracker doesn't know the file, the DHT is asked instead when available.
+// In DHT-only mode the tracker isn't asked at all.
 func queryFileInfo(groupID, fileName string) (*FileInfo, error) {
-	resp := SendToTracker(Message{
+	if dhtOnly() {
+		if peerDHT() == nil {
+			return nil, errors.New("P2P_DHT_ONLY=1 needs P2P_DHT_PORT set to join the DHT")
+		}
+		return queryFileInfoDHT(groupID, fileName)
+	}
+
+	resp := QueryTracker(Message{
 		Cmd:  "get_file_info",
 		Args: []string{groupID, fileName, State.UserID},
 	})
 
 	if resp.Status != "ok" {
+		if resp.Data == "file not found" && peerDHT() != nil {
+			if info, err := queryFileInfoDHT(groupID, fileName); err == nil {
+				return info, nil
+			}
+		}
 		return nil, fmt.Errorf("tracker error: %v", resp.Data)
 	}
 
@@ -255,72 +452,170 @@ func queryFileInfo(groupID, fileName string) (*FileInfo, error) {
 	if err := json.Unmarshal(jsonData, &fileInfo); err != nil {
 		return nil, err
 	}
+	rememberPeerToken(&fileInfo)
 
 	return &fileInfo, nil
 }
 
-// requestChunk requests a specific chunk from a peer
-func requestChunk(peerAddr, fileHash string, chunkIdx int) ([]byte, error) {
+// requestChunk requests a specific chunk from a peer, returning it with
+// the hash algorithm the peer names for it. Blacklisted peers aren't dialled. Connection errors and corrupt chunks
+// count towards blacklisting the peer; a chunk it doesn't have, or a
+// refused download token, doesn't. The tracker's token for the file is
+// presented with both requests.
+// The chunk is asked for in the P2P_ACCEPT_ENCODING encodings and
+// decoded before it is returned for validation.
+func requestChunk(peerAddr, fileHash string, chunkIdx int) ([]byte, string, error) {
+	data, algo, _, err := requestChunkProof(peerAddr, fileHash, chunkIdx, false)
+	return data, algo, err
+}
+
+// requestChunkProof is requestChunk also asking, with wantProof, for the
+// chunk's Merkle proof. The proof is nil if the peer sent none.
+func requestChunkProof(peerAddr, fileHash string, chunkIdx int, wantProof bool) (data []byte, algo string, proof []string, err error) {
+	if peerBlacklisted(peerAddr) {
+		return nil, "", nil, errPeerBlacklisted
+	}
+	defer func() {
+		if err != nil && !errors.Is(err, errChunkNotServed) && !errors.Is(err, errPeerUnauthorized) && !errors.Is(err, errPeerBusy) {
+			recordPeerFailure(peerAddr)
+		}
+	}()
+
 	// Connect to peer
 	conn, err := net.Dial("tcp", peerAddr)
 	if err != nil {
-		return nil, fmt.Errorf("connection failed: %v", err)
+		return nil, "", nil, fmt.Errorf("connection failed: %v", err)
 	}
 	defer conn.Close()
+	setKeepalive(conn)
+	localGossip.recent.Add(peerAddr)
 
 	// Send handshake
 	err = common.Send(conn, PeerRequest{
 		Cmd:      "handshake",
 		FileHash: fileHash,
+		Token:    peerToken(fileHash),
 	})
 	if err != nil {
-		return nil, err
+		return nil, "", nil, err
 	}
 
 	var handshakeResp PeerResponse
 	if err := common.Recv(conn, &handshakeResp); err != nil {
-		return nil, err
+		return nil, "", nil, err
 	}
 
-	if handshakeResp.Status != "ok" {
-		return nil, errors.New("handshake failed")
+	switch handshakeResp.Status {
+	case "ok":
+	case "unauthorized":
+		return nil, "", nil, errPeerUnauthorized
+	case "busy":
+		return nil, "", nil, errPeerBusy
+	case "error":
+		return nil, "", nil, errChunkNotServed
+	default:
+		return nil, "", nil, errors.New("handshake failed")
 	}
 
 	// Close and reconnect for get_piece
 	conn.Close()
 	conn, err = net.Dial("tcp", peerAddr)
 	if err != nil {
-		return nil, err
+		return nil, "", nil, err
 	}
 	defer conn.Close()
+	setKeepalive(conn)
 
 	// Request chunk
 	err = common.Send(conn, PeerRequest{
-		Cmd:      "get_piece",
-		FileHash: fileHash,
-		PieceIdx: chunkIdx,
+		Cmd:            "get_piece",
+		FileHash:       fileHash,
+		PieceIdx:       chunkIdx,
+		AcceptEncoding: acceptEncodings(),
+		Token:          peerToken(fileHash),
+		WantProof:      wantProof,
 	})
 	if err != nil {
-		return nil, err
+		return nil, "", nil, err
 	}
 
+	// The piece shares P2P_BANDWIDTH_LIMIT with every other transfer
 	var pieceResp PeerResponse
-	if err := common.Recv(conn, &pieceResp); err != nil {
-		return nil, err
+	if err := common.Recv(bandwidth.Conn(conn, 1), &pieceResp); err != nil {
+		return nil, "", nil, err
 	}
 
+	if pieceResp.Status == "corrupt" {
+		return nil, "", nil, errPeerCorrupt
+	}
+	if pieceResp.Status == "unauthorized" {
+		return nil, "", nil, errPeerUnauthorized
+	}
+	if pieceResp.Status == "busy" {
+		return nil, "", nil, errPeerBusy
+	}
 	if pieceResp.Status != "ok" {
-		return nil, errors.New("chunk download failed")
+		return nil, "", nil, errChunkNotServed
+	}
+
+	transfers.Record(DirectionDown, fileHash, peerAddr, int64(len(pieceResp.Data)), time.Now())
+	data, err = decodeChunk(pieceResp.ContentEncoding, pieceResp.Data)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("decode %s chunk: %v", pieceResp.ContentEncoding, err)
+	}
+	return data, pieceResp.HashAlgorithm, pieceResp.Proof, nil
+}
+
+// validateChunkHash verifies chunk data matches expected hash under algo
+// ("" for SHA256)
+func validateChunkHash(data []byte, algo, expectedHash string) bool {
+	actualHash, err := computeHash(algo, data)
+	return err == nil && actualHash == expectedHash
+}
+
+// validateChunk checks a chunk a peer sent against c. The peer names the
+// algorithm its copy was hashed with; one naming a different algorithm
+// than c's isn't serving the chunk c describes, and fails.
+func validateChunk(data []byte, peerAlgo string, c ChunkInfo) bool {
+	if !sameHashAlgorithm(peerAlgo, c.HashAlgorithm) {
+		return false
 	}
+	return validateChunkHash(data, c.HashAlgorithm, c.Hash)
+}
+
+// sameHashAlgorithm compares algorithm names, "" being HashSHA256.
+func sameHashAlgorithm(a, b string) bool {
+	if a == "" {
+		a = HashSHA256
+	}
+	if b == "" {
+		b = HashSHA256
+	}
+	return a == b
+}
 
-	return pieceResp.Data, nil
+// hashAlgorithm returns the algorithm the file's chunks were hashed with.
+func (f *FileInfo) hashAlgorithm() string {
+	if len(f.Chunks) == 0 {
+		return ""
+	}
+	return f.Chunks[0].HashAlgorithm
 }
 
-// validateChunkHash verifies chunk data matches expected SHA256 hash
-func validateChunkHash(data []byte, expectedHash string) bool {
-	hash := sha256.Sum256(data)
-	actualHash := hex.EncodeToString(hash[:])
-	return actualHash == expectedHash
+// verifyAssembledFile checks the file assembled at path has fileInfo's
+// FileHash, and removes it if not. Each chunk was checked against the
+// tracker's chunk list, but that came from whoever gave the tracker the
+// layout; the file hash is what was asked for.
+func verifyAssembledFile(path string, fileInfo *FileInfo) error {
+	hash, err := CalculateFileHash(path, ChunkOptions{HashAlgorithm: fileInfo.hashAlgorithm()})
+	if err == nil && hash != fileInfo.FileHash {
+		err = fmt.Errorf("assembled file has hash %s, want %s", hash, fileInfo.FileHash)
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to verify file: %v", err)
+	}
+	return nil
 }
 
 // assembleFile concatenates chunks and writes to destination (used by upload verification)
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// parseSince turns a --since value into an absolute time. It accepts an
+// RFC3339 timestamp or a duration such as "24h", meaning that long before now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid --since %q: use an RFC3339 time or a duration like 24h", s)
+	}
+	return now.Add(-d), nil
+}
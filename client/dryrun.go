@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// UploadManifest describes what upload_file would do, without doing it.
+type UploadManifest struct {
+	FileName    string
+	GroupID     string
+	FileSize    int64
+	FileHash    string
+	ChunkSize   int64
+	TotalChunks int
+
+	// EstimatedUpload is FileSize at the known upload speed; 0 if no speed is known.
+	EstimatedUpload time.Duration
+
+	// Exists is true when the group already has a file with this name.
+	Exists bool
+
+	// StorageUsed and StorageQuota are the group's usage; a quota of 0 means none.
+	StorageUsed  int64
+	StorageQuota int64
+
+	// QueryErr notes a failed tracker lookup, so the checks above are unknown.
+	QueryErr error
+}
+
+// OverQuota reports whether uploading would take the group past its quota.
+func (m *UploadManifest) OverQuota() bool {
+	return m.StorageQuota > 0 && m.StorageUsed+m.FileSize > m.StorageQuota
+}
+
+// PlanUpload chunks filePath in memory and asks the tracker whether groupID
+// would accept it. Nothing is written to disk and nothing is registered:
+// the only tracker requests are the read-only get_file_info and get_group_info.
+func PlanUpload(filePath, groupID string) (*UploadManifest, error) {
+	metadata, err := ChunkFile(filePath, uploadChunkOptions())
+	if err != nil {
+		return nil, err
+	}
+	m := &UploadManifest{
+		FileName:    metadata.FileName,
+		GroupID:     groupID,
+		FileSize:    metadata.FileSize,
+		FileHash:    metadata.FileHash,
+		ChunkSize:   metadata.ChunkSize,
+		TotalChunks: metadata.TotalChunks,
+	}
+	if bps := uploadSpeed(time.Now()); bps > 0 {
+		m.EstimatedUpload = time.Duration(float64(m.FileSize) / bps * float64(time.Second))
+	}
+
+	resp := QueryTracker(Message{
+		Cmd:  "get_file_info",
+		Args: []string{groupID, m.FileName, State.UserID},
+	})
+	switch {
+	case resp.Status == "ok":
+		m.Exists = true
+	case resp.Data != "file not found":
+		m.QueryErr = fmt.Errorf("%v", resp.Data)
+		return m, nil
+	}
+
+	resp = QueryTracker(Message{Cmd: "get_group_info", Args: []string{groupID}})
+	data, ok := resp.Data.(map[string]interface{})
+	if resp.Status != "ok" || !ok {
+		m.QueryErr = fmt.Errorf("%v", resp.Data)
+		return m, nil
+	}
+	used, _ := data["storage_used"].(float64)
+	quota, _ := data["storage_quota"].(float64)
+	m.StorageUsed, m.StorageQuota = int64(used), int64(quota)
+	return m, nil
+}
+
+// uploadSpeed estimates upload bandwidth in bytes per second: the
+// P2P_UPLOAD_RATE cap if set, otherwise the mean of fresh speed_test
+// results, otherwise 0.
+func uploadSpeed(now time.Time) float64 {
+	if r := uploadRate(); r > 0 {
+		return float64(r)
+	}
+	var total float64
+	n := 0
+	for peer := range State.PeerSpeeds {
+		if mbps, ok := peerSpeed(peer, now); ok {
+			total += mbps
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / float64(n) * (1 << 20)
+}
+
+// printUploadManifest prints a dry-run manifest for the user to review.
+func printUploadManifest(m *UploadManifest) {
+	fmt.Println("Dry run — nothing was uploaded")
+	fmt.Printf("  File: %s\n", m.FileName)
+	fmt.Printf("  Group: %s\n", m.GroupID)
+	fmt.Printf("  Size: %s (%d bytes)\n", formatByteSize(m.FileSize), m.FileSize)
+	fmt.Printf("  Hash: %s...\n", m.FileHash[:16])
+	fmt.Printf("  Chunks: %d of %s\n", m.TotalChunks, formatByteSize(m.ChunkSize))
+	if m.EstimatedUpload > 0 {
+		fmt.Printf("  Estimated upload time: %s\n", m.EstimatedUpload.Round(time.Second))
+	} else {
+		fmt.Println("  Estimated upload time: unknown (run speed_test or set P2P_UPLOAD_RATE)")
+	}
+
+	if m.QueryErr != nil {
+		fmt.Printf("✗ Could not check the group: %v\n", m.QueryErr)
+		return
+	}
+	if m.Exists {
+		fmt.Printf("✗ '%s' already exists in group '%s'\n", m.FileName, m.GroupID)
+	}
+	if m.StorageQuota > 0 {
+		fmt.Printf("  Storage: %s of %s used\n", formatByteSize(m.StorageUsed), formatByteSize(m.StorageQuota))
+	}
+	if m.OverQuota() {
+		fmt.Printf("✗ Upload would exceed the group's storage quota by %s\n",
+			formatByteSize(m.StorageUsed+m.FileSize-m.StorageQuota))
+	}
+	if !m.Exists && !m.OverQuota() {
+		fmt.Println("✓ Ready to upload")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"os"
+	"p2p/common"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startRecordingTracker runs a tracker stand-in that answers requests from
+// replies (by command) and records every command it receives.
+func startRecordingTracker(t *testing.T, replies map[string]Response) (string, func() []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	var cmds []string
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var msg Message
+				if err := recvRequest(c, &msg); err != nil {
+					return
+				}
+				mu.Lock()
+				cmds = append(cmds, msg.Cmd)
+				mu.Unlock()
+				common.Send(c, replies[msg.Cmd])
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), cmds...)
+	}
+}
+
+// writeDryRunFile creates a multi-chunk file in a fresh working directory.
+func writeDryRunFile(t *testing.T) string {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("report.bin", make([]byte, 2*ChunkSize+100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return "report.bin"
+}
+
+// TestPlanUpload_NoWrites checks a dry run leaves the chunk store untouched
+// and sends the tracker nothing but read-only queries.
+func TestPlanUpload_NoWrites(t *testing.T) {
+	path := writeDryRunFile(t)
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"get_file_info":  {"error", "file not found"},
+		"get_group_info": {"ok", map[string]interface{}{"storage_used": 0, "storage_quota": 0}},
+	})
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 0)
+
+	m, err := PlanUpload(path, "g1")
+	if err != nil {
+		t.Fatalf("PlanUpload: %v", err)
+	}
+	if m.FileName != "report.bin" || int64(m.TotalChunks) != (m.FileSize+m.ChunkSize-1)/m.ChunkSize || m.TotalChunks < 2 || m.Exists || m.OverQuota() || m.QueryErr != nil {
+		t.Fatalf("manifest = %+v", m)
+	}
+
+	entries, _ := os.ReadDir(".")
+	if len(entries) != 1 {
+		t.Fatalf("dry run wrote to disk: %v", entries)
+	}
+	for _, cmd := range cmds() {
+		if cmd != "get_file_info" && cmd != "get_group_info" {
+			t.Errorf("dry run sent %q to the tracker", cmd)
+		}
+	}
+}
+
+func TestPlanUpload_ExistingAndOverQuota(t *testing.T) {
+	path := writeDryRunFile(t)
+	tracker, _ := startRecordingTracker(t, map[string]Response{
+		"get_file_info":  {"ok", map[string]interface{}{"file_name": "report.bin"}},
+		"get_group_info": {"ok", map[string]interface{}{"storage_used": 1000, "storage_quota": 2 * ChunkSize}},
+	})
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 0)
+
+	m, err := PlanUpload(path, "g1")
+	if err != nil {
+		t.Fatalf("PlanUpload: %v", err)
+	}
+	if !m.Exists || !m.OverQuota() {
+		t.Fatalf("expected an existing file over quota, got %+v", m)
+	}
+}
+
+func TestUploadSpeed(t *testing.T) {
+	now := time.Now()
+	saved, savedAt := State.PeerSpeeds, State.PeerSpeedsAt
+	State.PeerSpeeds = map[string]float64{"a": 1, "b": 3, "stale": 100}
+	State.PeerSpeedsAt = map[string]time.Time{"a": now, "b": now, "stale": now.Add(-time.Hour)}
+	t.Cleanup(func() { State.PeerSpeeds, State.PeerSpeedsAt = saved, savedAt })
+
+	if got := uploadSpeed(now); got != 2*(1<<20) {
+		t.Errorf("mean of fresh speeds = %v, want %v", got, 2*(1<<20))
+	}
+	t.Setenv("P2P_UPLOAD_RATE", "5000")
+	if got := uploadSpeed(now); got != 5000 {
+		t.Errorf("with P2P_UPLOAD_RATE = %v, want 5000", got)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Chunk content encodings a downloader can ask for in
+// PeerRequest.AcceptEncoding. Peers pick the first of encodingPriority
+// the request accepts; raw is always acceptable, and is all older
+// peers send.
+const (
+	EncodingRaw  = "raw"
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+var encodingPriority = []string{EncodingZstd, EncodingGzip, EncodingRaw}
+
+// maxDecodedChunk bounds what a compressed chunk may expand to, so a
+// peer can't make us allocate without limit.
+const maxDecodedChunk = hugeChunkSize
+
+// acceptEncodings returns the encodings chunks are requested in: the
+// comma-separated P2P_ACCEPT_ENCODING, such as "zstd,gzip". Unset, chunks
+// come raw, which costs neither side any CPU.
+func acceptEncodings() []string {
+	var accept []string
+	for _, name := range strings.Split(os.Getenv("P2P_ACCEPT_ENCODING"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			accept = append(accept, name)
+		}
+	}
+	return accept
+}
+
+// negotiateEncoding picks the encoding to send a chunk in: the first of
+// encodingPriority that accept lists, raw if none is.
+func negotiateEncoding(accept []string) string {
+	for _, enc := range encodingPriority {
+		for _, a := range accept {
+			if a == enc {
+				return enc
+			}
+		}
+	}
+	return EncodingRaw
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns the shared zstd encoder and decoder. Both are safe for
+// concurrent EncodeAll and DecodeAll calls.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr == nil {
+			zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecodedChunk))
+		}
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// encodeChunk compresses data with enc.
+func encodeChunk(enc string, data []byte) ([]byte, error) {
+	switch enc {
+	case EncodingRaw, "":
+		return data, nil
+	case EncodingGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case EncodingZstd:
+		e, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return e.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown chunk encoding %q", enc)
+	}
+}
+
+// decodeChunk undoes encodeChunk. "" is raw, from peers that predate
+// content negotiation.
+func decodeChunk(enc string, data []byte) ([]byte, error) {
+	switch enc {
+	case EncodingRaw, "":
+		return data, nil
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		out, err := io.ReadAll(io.LimitReader(r, maxDecodedChunk+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > maxDecodedChunk {
+			return nil, fmt.Errorf("gzip chunk expands past %d bytes", maxDecodedChunk)
+		}
+		return out, nil
+	case EncodingZstd:
+		_, d, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return d.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unknown chunk encoding %q", enc)
+	}
+}
+
+// encodeForPeer encodes data in the best encoding accept allows. It falls
+// back to raw when compressing fails or doesn't make the chunk smaller.
+func encodeForPeer(accept []string, data []byte) ([]byte, string) {
+	enc := negotiateEncoding(accept)
+	if enc == EncodingRaw {
+		return data, EncodingRaw
+	}
+	out, err := encodeChunk(enc, data)
+	if err != nil || len(out) >= len(data) {
+		return data, EncodingRaw
+	}
+	return out, enc
+}
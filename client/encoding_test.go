@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"testing"
+)
+
+// TestNegotiateEncoding checks peers pick zstd over gzip over raw,
+// whatever order they are asked for in.
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   string
+	}{
+		{nil, EncodingRaw},
+		{[]string{"raw"}, EncodingRaw},
+		{[]string{"gzip"}, EncodingGzip},
+		{[]string{"zstd"}, EncodingZstd},
+		{[]string{"gzip", "zstd"}, EncodingZstd},
+		{[]string{"br", "gzip"}, EncodingGzip},
+		{[]string{"br"}, EncodingRaw},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.accept); got != tt.want {
+			t.Errorf("negotiateEncoding(%v) = %s, want %s", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptEncodings(t *testing.T) {
+	t.Setenv("P2P_ACCEPT_ENCODING", " ZSTD, gzip,,")
+	if got := acceptEncodings(); len(got) != 2 || got[0] != "zstd" || got[1] != "gzip" {
+		t.Errorf("acceptEncodings() = %v", got)
+	}
+	t.Setenv("P2P_ACCEPT_ENCODING", "")
+	if got := acceptEncodings(); got != nil {
+		t.Errorf("acceptEncodings() unset = %v", got)
+	}
+}
+
+// TestEncodeChunk_RoundTrip checks each encoding decodes back to the chunk.
+func TestEncodeChunk_RoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("chunk data "), 1000)
+	for _, enc := range []string{EncodingRaw, EncodingGzip, EncodingZstd} {
+		encoded, err := encodeChunk(enc, data)
+		if err != nil {
+			t.Fatalf("%s: encode: %v", enc, err)
+		}
+		if enc != EncodingRaw && len(encoded) >= len(data) {
+			t.Errorf("%s: %d bytes encoded to %d", enc, len(data), len(encoded))
+		}
+		decoded, err := decodeChunk(enc, encoded)
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("%s: decode = %d bytes, %v", enc, len(decoded), err)
+		}
+	}
+	// Peers that predate negotiation send no encoding
+	if got, err := decodeChunk("", data); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("decode without encoding: %v", err)
+	}
+	if _, err := decodeChunk("br", data); err == nil {
+		t.Error("unknown encoding decoded")
+	}
+	if _, err := decodeChunk(EncodingZstd, data); err == nil {
+		t.Error("garbage decoded as zstd")
+	}
+}
+
+// serveTestChunk writes data as chunk 0 of fileHash in the current
+// directory's chunk store and returns a peer serving it.
+func serveTestChunk(t *testing.T, fileHash string, data []byte) string {
+	t.Helper()
+	dir := filepath.Join(ChunksDir, fileHash)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "chunk_0.dat"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return startTestPeer(t)
+}
+
+// getPieceAccepting sends a get_piece for chunk 0 accepting accept, and returns the reply.
+func getPieceAccepting(t *testing.T, addr, fileHash string, accept []string) PeerResponse {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := common.Send(conn, PeerRequest{Cmd: "get_piece", FileHash: fileHash, AcceptEncoding: accept}); err != nil {
+		t.Fatal(err)
+	}
+	var resp PeerResponse
+	if err := common.Recv(conn, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// TestGetPiece_Encodings downloads a compressible chunk in each encoding
+// and checks it is sent encoded and comes back from requestChunk intact.
+func TestGetPiece_Encodings(t *testing.T) {
+	t.Chdir(t.TempDir())
+	data := bytes.Repeat([]byte("compressible chunk "), 5000)
+	peer := serveTestChunk(t, "fh", data)
+
+	for _, tt := range []struct{ accept, want string }{
+		{"", EncodingRaw},
+		{"gzip", EncodingGzip},
+		{"zstd", EncodingZstd},
+		{"gzip,zstd", EncodingZstd},
+	} {
+		t.Setenv("P2P_ACCEPT_ENCODING", tt.accept)
+		resp := getPieceAccepting(t, peer, "fh", acceptEncodings())
+		if resp.Status != "ok" || resp.ContentEncoding != tt.want {
+			t.Errorf("accept %q: sent %q, want %q", tt.accept, resp.ContentEncoding, tt.want)
+		}
+		if tt.want != EncodingRaw && len(resp.Data) >= len(data) {
+			t.Errorf("accept %q: sent %d bytes of %d", tt.accept, len(resp.Data), len(data))
+		}
+		got, _, err := requestChunk(peer, "fh", 0)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("accept %q: requestChunk = %d bytes, %v", tt.accept, len(got), err)
+		}
+	}
+}
+
+// TestGetPiece_FallsBackToRaw checks chunks are sent raw when the peer
+// supports none of the encodings asked for, or compressing doesn't help.
+func TestGetPiece_FallsBackToRaw(t *testing.T) {
+	t.Chdir(t.TempDir())
+	data := bytes.Repeat([]byte("compressible chunk "), 5000)
+	peer := serveTestChunk(t, "fh", data)
+
+	t.Setenv("P2P_ACCEPT_ENCODING", "br")
+	if resp := getPieceAccepting(t, peer, "fh", acceptEncodings()); resp.ContentEncoding != EncodingRaw || !bytes.Equal(resp.Data, data) {
+		t.Errorf("unsupported encoding: sent %q, %d bytes", resp.ContentEncoding, len(resp.Data))
+	}
+	if got, _, err := requestChunk(peer, "fh", 0); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("requestChunk = %d bytes, %v", len(got), err)
+	}
+
+	random := make([]byte, 64*1024)
+	rand.Read(random)
+	peer = serveTestChunk(t, "random", random)
+	resp := getPieceAccepting(t, peer, "random", []string{EncodingZstd, EncodingGzip})
+	if resp.ContentEncoding != EncodingRaw || !bytes.Equal(resp.Data, random) {
+		t.Errorf("incompressible chunk: sent %q, %d bytes", resp.ContentEncoding, len(resp.Data))
+	}
+}
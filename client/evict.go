@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// markChunkDirUsed bumps a chunk directory's modification time, which is
+// what EvictChunks treats as the file's last use.
+func markChunkDirUsed(fileHash string) {
+	now := time.Now()
+	os.Chtimes(filepath.Join(ChunksDir, fileHash), now, now)
+}
+
+// EvictChunks deletes whole files from the chunk store, least recently used
+// first, until it takes no more than maxBytes on disk. Pinned files and the
+// global store itself are never removed directly; global chunks are dropped
+// once no remaining file uses them. It returns the evicted file hashes.
+func EvictChunks(maxBytes int64) ([]string, error) {
+	stats, err := GetChunkStats()
+	if err != nil {
+		return nil, err
+	}
+	if stats.PhysicalBytes <= maxBytes {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(ChunksDir)
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		hash     string
+		lastUsed time.Time
+	}
+	var candidates []candidate
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == GlobalChunkDir || IsPinned(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{e.Name(), info.ModTime()})
+	}
+	sort.Slice(candidates, func(a, b int) bool { return candidates[a].lastUsed.Before(candidates[b].lastUsed) })
+
+	var evicted []string
+	for _, c := range candidates {
+		if err := os.RemoveAll(filepath.Join(ChunksDir, c.hash)); err != nil {
+			return evicted, err
+		}
+		evicted = append(evicted, c.hash)
+		if err := pruneGlobalChunks(); err != nil {
+			return evicted, err
+		}
+		if stats, err = GetChunkStats(); err != nil {
+			return evicted, err
+		}
+		if stats.PhysicalBytes <= maxBytes {
+			break
+		}
+	}
+	return evicted, nil
+}
+
+// pruneGlobalChunks removes global store entries no remaining file refers to.
+func pruneGlobalChunks() error {
+	entries, err := os.ReadDir(ChunksDir)
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == GlobalChunkDir {
+			continue
+		}
+		if metadata, err := loadChunkMetadata(e.Name()); err == nil {
+			for _, c := range metadata.Chunks {
+				used[c.Hash+".dat"] = true
+			}
+		}
+	}
+
+	globals, err := os.ReadDir(filepath.Join(ChunksDir, GlobalChunkDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, g := range globals {
+		if !used[g.Name()] && filepath.Ext(g.Name()) == ".dat" {
+			os.Remove(filepath.Join(ChunksDir, GlobalChunkDir, g.Name()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ageChunkDir sets a chunk directory's last-use time to d ago.
+func ageChunkDir(t *testing.T, meta *ChunkMetadata, d time.Duration) {
+	t.Helper()
+	at := time.Now().Add(-d)
+	if err := os.Chtimes(filepath.Join(ChunksDir, meta.FileHash), at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func chunkDirExists(meta *ChunkMetadata) bool {
+	_, err := os.Stat(filepath.Join(ChunksDir, meta.FileHash))
+	return err == nil
+}
+
+// TestEvictChunks_SkipsPinned fills the store over the limit and checks the
+// pinned file survives even though it is the least recently used.
+func TestEvictChunks_SkipsPinned(t *testing.T) {
+	t.Chdir(t.TempDir())
+	oldest := writeAndChunk(t, "oldest.bin", bytes.Repeat([]byte{1}, 3000))
+	middle := writeAndChunk(t, "middle.bin", bytes.Repeat([]byte{2}, 3000))
+	newest := writeAndChunk(t, "newest.bin", bytes.Repeat([]byte{3}, 3000))
+	ageChunkDir(t, oldest, 3*time.Hour)
+	ageChunkDir(t, middle, 2*time.Hour)
+	ageChunkDir(t, newest, time.Hour)
+
+	if _, err := PinFile(oldest.FileHash); err != nil {
+		t.Fatal(err)
+	}
+	if !IsPinned(oldest.FileHash) {
+		t.Fatal("oldest should be pinned")
+	}
+
+	evicted, err := EvictChunks(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 1 || evicted[0] != middle.FileHash {
+		t.Fatalf("evicted %v, want only the middle file", evicted)
+	}
+	if !chunkDirExists(oldest) || chunkDirExists(middle) || !chunkDirExists(newest) {
+		t.Fatal("wrong chunk directories left after eviction")
+	}
+
+	// Once nothing else is evictable the pinned file still stays
+	evicted, err = EvictChunks(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 1 || evicted[0] != newest.FileHash {
+		t.Fatalf("evicted %v, want only the newest file", evicted)
+	}
+	if !chunkDirExists(oldest) {
+		t.Fatal("pinned file was evicted")
+	}
+}
+
+func TestEvictChunks_UnderLimitIsNoop(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta := writeAndChunk(t, "a.bin", bytes.Repeat([]byte{1}, 3000))
+	evicted, err := EvictChunks(1 << 20)
+	if err != nil || len(evicted) != 0 {
+		t.Fatalf("evicted %v, err %v; want nothing", evicted, err)
+	}
+	if !chunkDirExists(meta) {
+		t.Fatal("file evicted while under the limit")
+	}
+}
+
+// TestEvictChunks_PrunesSharedChunks checks global chunks are kept while a
+// remaining file still uses them and dropped once none does.
+func TestEvictChunks_PrunesSharedChunks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	a, b := sharedIntroFiles()
+	metaA := writeAndChunk(t, "a.bin", a)
+	metaB := writeAndChunk(t, "b.bin", b)
+	ageChunkDir(t, metaA, 2*time.Hour)
+	ageChunkDir(t, metaB, time.Hour)
+
+	global := filepath.Join(ChunksDir, GlobalChunkDir, metaA.Chunks[0].Hash+".dat")
+	if _, err := EvictChunks(1); err != nil {
+		t.Fatal(err)
+	}
+	if chunkDirExists(metaA) || chunkDirExists(metaB) {
+		t.Fatal("expected both files evicted")
+	}
+	if _, err := os.Stat(global); !os.IsNotExist(err) {
+		t.Fatalf("shared chunk should be pruned with its last user, stat err %v", err)
+	}
+}
+
+func TestUnpinFile_NotPinned(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta := writeAndChunk(t, "a.bin", bytes.Repeat([]byte{1}, 3000))
+	if err := UnpinFile(meta.FileHash); err == nil {
+		t.Fatal("expected an error unpinning an unpinned file")
+	}
+	if _, err := PinFile(meta.FileHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnpinFile(meta.FileHash); err != nil {
+		t.Fatal(err)
+	}
+	if IsPinned(meta.FileHash) {
+		t.Fatal("still pinned after unpin")
+	}
+}
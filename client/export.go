@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"p2p/common"
+	"path/filepath"
+)
+
+const ManifestFile = "manifest.json"
+
+// loadChunkMetadata reads .chunks/<fileHash>/metadata.json
+func loadChunkMetadata(fileHash string) (*ChunkMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(ChunksDir, fileHash, "metadata.json"))
+	if os.IsNotExist(err) {
+		// Files chunked by ChunkAndStore keep their metadata as JSON lines
+		var chunks []ChunkInfo
+		metadata, lerr := ReadChunkMetadataLines(filepath.Join(ChunksDir, fileHash, MetadataLinesFile), func(c ChunkInfo) error {
+			chunks = append(chunks, c)
+			return nil
+		})
+		if os.IsNotExist(lerr) {
+			return nil, err
+		}
+		if lerr != nil {
+			return nil, lerr
+		}
+		metadata.Chunks = chunks
+		metadata.MerkleRoot = common.MerkleRoot(chunkLeaves(chunks, metadata.TotalChunks))
+		return metadata, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata ChunkMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// ExportChunks copies every chunk of a locally stored file to destDir/<index>.bin
+// and writes the chunk metadata alongside as manifest.json.
+func ExportChunks(fileHash, destDir string) (*ChunkMetadata, error) {
+	metadata, err := loadChunkMetadata(fileHash)
+	if err != nil {
+		return nil, fmt.Errorf("no local metadata for %s: %v", fileHash, err)
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, err
+	}
+
+	chunkDir := filepath.Join(ChunksDir, fileHash)
+	for i := 0; i < metadata.TotalChunks; i++ {
+		src := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))
+		dst := filepath.Join(destDir, fmt.Sprintf("%d.bin", i))
+		if err := copyFile(src, dst); err != nil {
+			return nil, fmt.Errorf("chunk %d: %v", i, err)
+		}
+	}
+
+	manifestJSON, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(destDir, ManifestFile), manifestJSON, 0644); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// ImportChunks reads srcDir/manifest.json, validates every <index>.bin against
+// its recorded hash and moves the chunks into .chunks/<fileHash>/.
+// Nothing is moved unless every chunk and the whole-file hash check out.
+func ImportChunks(srcDir string) (*ChunkMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(srcDir, ManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %v", err)
+	}
+
+	var metadata ChunkMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if metadata.TotalChunks != len(metadata.Chunks) || metadata.TotalChunks == 0 {
+		return nil, fmt.Errorf("manifest lists %d chunks but total_chunks is %d",
+			len(metadata.Chunks), metadata.TotalChunks)
+	}
+
+	// Validate each chunk and the assembled file hash before touching .chunks
+	fileHash, err := newHasher(metadata.HashAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	var totalSize int64
+	for i, c := range metadata.Chunks {
+		chunk, err := os.ReadFile(filepath.Join(srcDir, fmt.Sprintf("%d.bin", i)))
+		if err != nil {
+			return nil, fmt.Errorf("missing chunk %d: %v", i, err)
+		}
+		if !sameHashAlgorithm(c.HashAlgorithm, metadata.HashAlgorithm) {
+			return nil, fmt.Errorf("chunk %d hashed with %q, not the file's %q", i, c.HashAlgorithm, metadata.HashAlgorithm)
+		}
+		if !validateChunkHash(chunk, c.HashAlgorithm, c.Hash) {
+			return nil, fmt.Errorf("chunk %d hash mismatch", i)
+		}
+		fileHash.Write(chunk)
+		totalSize += int64(len(chunk))
+	}
+	if hex.EncodeToString(fileHash.Sum(nil)) != metadata.FileHash {
+		return nil, fmt.Errorf("file hash mismatch")
+	}
+	if totalSize != metadata.FileSize {
+		return nil, fmt.Errorf("file size mismatch: manifest %d, chunks %d", metadata.FileSize, totalSize)
+	}
+
+	chunkDir := filepath.Join(ChunksDir, metadata.FileHash)
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		return nil, err
+	}
+	for i := range metadata.Chunks {
+		src := filepath.Join(srcDir, fmt.Sprintf("%d.bin", i))
+		dst := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))
+		if err := moveFile(src, dst); err != nil {
+			return nil, fmt.Errorf("chunk %d: %v", i, err)
+		}
+	}
+
+	metadataJSON, err := json.MarshalIndent(&metadata, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(chunkDir, "metadata.json"), metadataJSON, 0644); err != nil {
+		return nil, err
+	}
+	resumeServing(chunkDir)
+	return &metadata, nil
+}
+
+// copyFile copies src to dst, overwriting dst if it exists.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// moveFile renames src to dst, falling back to copy+remove across filesystems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
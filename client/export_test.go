@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chunkTestFile chunks a generated file of the given size inside the current
+// (temporary) directory and returns its metadata and original content.
+func chunkTestFile(t *testing.T, size int) (*ChunkMetadata, []byte) {
+	t.Helper()
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	if err := os.WriteFile("orig.bin", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ChunkFile("orig.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveChunks("orig.bin", meta); err != nil {
+		t.Fatal(err)
+	}
+	return meta, content
+}
+
+// TestExportImportRoundTrip exports a 3-chunk file, wipes the local chunk store,
+// imports it back and checks the reassembled file is byte-identical.
+func TestExportImportRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*smallChunkSize+100)
+
+	exportDir := "exported"
+	if _, err := ExportChunks(meta.FileHash, exportDir); err != nil {
+		t.Fatalf("ExportChunks: %v", err)
+	}
+	for i := 0; i < meta.TotalChunks; i++ {
+		if _, err := os.Stat(filepath.Join(exportDir, fmt.Sprintf("%d.bin", i))); err != nil {
+			t.Errorf("chunk %d not exported: %v", i, err)
+		}
+	}
+
+	var manifest ChunkMetadata
+	data, err := os.ReadFile(filepath.Join(exportDir, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.FileHash != meta.FileHash || manifest.TotalChunks != 3 {
+		t.Errorf("manifest mismatch: %+v", manifest)
+	}
+
+	if err := os.RemoveAll(ChunksDir); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := ImportChunks(exportDir)
+	if err != nil {
+		t.Fatalf("ImportChunks: %v", err)
+	}
+	if imported.FileHash != meta.FileHash {
+		t.Errorf("imported hash: want %s got %s", meta.FileHash, imported.FileHash)
+	}
+
+	chunkDir := filepath.Join(ChunksDir, meta.FileHash)
+	if err := assembleFileFromDisk(chunkDir, meta.TotalChunks, "reassembled.bin"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile("reassembled.bin")
+	if !bytes.Equal(got, content) {
+		t.Error("reassembled file differs from original")
+	}
+	if _, err := loadChunkMetadata(meta.FileHash); err != nil {
+		t.Errorf("metadata.json not written on import: %v", err)
+	}
+}
+
+// TestImportChunks_RejectsCorruptChunk verifies a tampered chunk is detected and
+// nothing is moved into the chunk store.
+func TestImportChunks_RejectsCorruptChunk(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, ChunkSize+10)
+
+	exportDir := "exported"
+	if _, err := ExportChunks(meta.FileHash, exportDir); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(ChunksDir)
+
+	if err := os.WriteFile(filepath.Join(exportDir, "1.bin"), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportChunks(exportDir); err == nil {
+		t.Fatal("expected hash mismatch error")
+	}
+	if _, err := os.Stat(filepath.Join(exportDir, "0.bin")); err != nil {
+		t.Error("valid chunks must stay in place when import fails")
+	}
+	if _, err := os.Stat(filepath.Join(ChunksDir, meta.FileHash)); err == nil {
+		t.Error("chunk store must not be populated when import fails")
+	}
+}
+
+// TestExportChunks_UnknownHash verifies exporting a file we don't have fails.
+func TestExportChunks_UnknownHash(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if _, err := ExportChunks("deadbeef", "out"); err == nil {
+		t.Fatal("expected error for unknown file hash")
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// fuseTimeout is how long the kernel may cache names and attributes
+// before asking again, which for a directory means asking the tracker.
+const fuseTimeout = 10 * time.Second
+
+// chunkRange returns the first and last chunk holding bytes
+// [off, off+size) of a fileSize-byte file cut into chunkSize chunks. The
+// range is clipped to the end of the file; ok is false if none of it is
+// inside the file.
+func chunkRange(off, size, chunkSize, fileSize int64) (first, last int, ok bool) {
+	if off < 0 || size <= 0 || chunkSize <= 0 || off >= fileSize {
+		return 0, 0, false
+	}
+	end := off + size
+	if end > fileSize || end < off {
+		end = fileSize
+	}
+	return int(off / chunkSize), int((end - 1) / chunkSize), true
+}
+
+// RemoteFile reads a file from its peers on demand: a read fetches only
+// the chunks covering its byte range. Each fetched chunk is checked
+// against the tracker's hash. With a cache directory, chunks are kept
+// there under <fileHash>/chunk_N.dat and read back instead of fetched
+// again; without one only the last chunk read is kept, in memory.
+type RemoteFile struct {
+	info      *FileInfo
+	chunkSize int64
+	cacheDir  string
+
+	mu       sync.Mutex
+	lastIdx  int
+	lastData []byte
+}
+
+// NewRemoteFile prepares reads of info's file, caching chunks in cacheDir
+// ("" for none).
+func NewRemoteFile(info *FileInfo, cacheDir string) (*RemoteFile, error) {
+	if len(info.Peers) == 0 {
+		return nil, errors.New("no peers available for download")
+	}
+	if len(info.Chunks) < info.TotalChunks {
+		return nil, fmt.Errorf("tracker listed %d chunk hashes for %d chunks", len(info.Chunks), info.TotalChunks)
+	}
+	chunkSize := info.ChunkSize
+	if chunkSize <= 0 && len(info.Chunks) > 0 {
+		// Files shared before chunk size tiers all used one size
+		chunkSize = info.Chunks[0].Size
+	}
+	return &RemoteFile{info: info, chunkSize: chunkSize, cacheDir: cacheDir, lastIdx: -1}, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *RemoteFile) ReadAt(p []byte, off int64) (int, error) {
+	first, last, ok := chunkRange(off, int64(len(p)), f.chunkSize, f.info.FileSize)
+	if !ok {
+		return 0, io.EOF
+	}
+	n := 0
+	for i := first; i <= last; i++ {
+		data, err := f.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		start := off + int64(n) - int64(i)*f.chunkSize
+		if start > int64(len(data)) {
+			return n, fmt.Errorf("chunk %d is %d bytes, want at least %d", i, len(data), start)
+		}
+		n += copy(p[n:], data[start:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// chunk returns chunk i from memory, the cache directory or a peer.
+func (f *RemoteFile) chunk(i int) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i == f.lastIdx {
+		return f.lastData, nil
+	}
+
+	want := f.info.Chunks[i]
+	var cachePath string
+	if f.cacheDir != "" {
+		cachePath = filepath.Join(f.cacheDir, f.info.FileHash, fmt.Sprintf("chunk_%d.dat", i))
+		if data, err := os.ReadFile(cachePath); err == nil && validateChunkHash(data, want.HashAlgorithm, want.Hash) {
+			f.lastIdx, f.lastData = i, data
+			return data, nil
+		}
+	}
+
+	data, err := f.fetch(i)
+	if err != nil {
+		return nil, err
+	}
+	if cachePath != "" {
+		if err := cacheChunk(cachePath, data); err != nil {
+			fmt.Printf("Warning: failed to cache chunk %d: %v\n", i, err)
+		}
+	}
+	f.lastIdx, f.lastData = i, data
+	return data, nil
+}
+
+// fetch asks chunk i's candidate peers for it in turn until one sends a
+// copy matching the tracker's hash.
+func (f *RemoteFile) fetch(i int) ([]byte, error) {
+	lastErr := fmt.Errorf("no peers for chunk %d", i)
+	for _, peer := range chunkCandidates(f.info, nil, i) {
+		if peerBlacklisted(peer) {
+			continue
+		}
+		data, algo, err := requestChunk(peer, f.info.FileHash, i)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to download chunk %d: %v", i, err)
+			continue
+		}
+		if !validateChunk(data, algo, f.info.Chunks[i]) {
+			recordPeerFailure(peer)
+			lastErr = fmt.Errorf("chunk %d hash mismatch", i)
+			continue
+		}
+		recordPeerSuccess(peer)
+		return data, nil
+	}
+	return nil, lastErr
+}
+
+// cacheChunk writes data to path via a temp file, so a cache shared by
+// several mounts never holds a partial chunk.
+func cacheChunk(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".part")
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// groupMember reports whether the logged-in user belongs to groupID.
+func groupMember(groupID string) (bool, error) {
+	resp := SendToTracker(Message{Cmd: "get_group_info", Args: []string{groupID}})
+	data, ok := resp.Data.(map[string]interface{})
+	if resp.Status != "ok" || !ok {
+		return false, fmt.Errorf("%v", resp.Data)
+	}
+	members, _ := data["members"].([]interface{})
+	for _, m := range members {
+		if m == State.UserID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// memberGroups lists the groups the logged-in user belongs to.
+func memberGroups() ([]string, error) {
+	var all []string
+	err := StreamFromTracker(Message{Cmd: "list_groups"}, func(resp Response) {
+		if name, ok := resp.Data.(string); ok && resp.Status == "ok" {
+			all = append(all, name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	var mine []string
+	for _, groupID := range all {
+		if ok, err := groupMember(groupID); err == nil && ok {
+			mine = append(mine, groupID)
+		}
+	}
+	return mine, nil
+}
+
+// fuseName reports whether name can be a directory entry.
+func fuseName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// p2pRoot is the mount's root: a directory for each of the user's groups.
+type p2pRoot struct {
+	fs.Inode
+	cacheDir string
+}
+
+var (
+	_ fs.NodeReaddirer = (*p2pRoot)(nil)
+	_ fs.NodeLookuper  = (*p2pRoot)(nil)
+)
+
+func (r *p2pRoot) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	groupIDs, err := memberGroups()
+	if err != nil {
+		return nil, syscall.EIO
+	}
+	entries := make([]fuse.DirEntry, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		if fuseName(groupID) {
+			entries = append(entries, fuse.DirEntry{Name: groupID, Mode: fuse.S_IFDIR})
+		}
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (r *p2pRoot) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	member, err := groupMember(name)
+	if err != nil || !member {
+		return nil, syscall.ENOENT
+	}
+	out.Mode = fuse.S_IFDIR | 0555
+	return r.NewInode(ctx, &p2pGroup{groupID: name, cacheDir: r.cacheDir}, fs.StableAttr{Mode: fuse.S_IFDIR}), 0
+}
+
+// p2pGroup is a group's directory, listing the files shared in it.
+type p2pGroup struct {
+	fs.Inode
+	groupID  string
+	cacheDir string
+}
+
+var (
+	_ fs.NodeReaddirer = (*p2pGroup)(nil)
+	_ fs.NodeLookuper  = (*p2pGroup)(nil)
+)
+
+func (g *p2pGroup) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	var entries []fuse.DirEntry
+	_, err := ListFiles(g.groupID, defaultListPageSize, "", true, func(file map[string]interface{}) {
+		if name, _ := file["file_name"].(string); fuseName(name) {
+			entries = append(entries, fuse.DirEntry{Name: name, Mode: fuse.S_IFREG})
+		}
+	})
+	if err != nil {
+		return nil, syscall.EIO
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (g *p2pGroup) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	info, err := queryFileInfo(g.groupID, name)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+	node := &p2pFile{groupID: g.groupID, fileName: name, size: info.FileSize, cacheDir: g.cacheDir}
+	node.fill(&out.Attr)
+	return g.NewInode(ctx, node, fs.StableAttr{Mode: fuse.S_IFREG}), 0
+}
+
+// p2pFile is a shared file. Opening it looks up its current peers, and
+// reads fetch the chunks they cover.
+type p2pFile struct {
+	fs.Inode
+	groupID  string
+	fileName string
+	size     int64
+	cacheDir string
+}
+
+var (
+	_ fs.NodeGetattrer = (*p2pFile)(nil)
+	_ fs.NodeOpener    = (*p2pFile)(nil)
+	_ fs.NodeReader    = (*p2pFile)(nil)
+)
+
+func (f *p2pFile) fill(attr *fuse.Attr) {
+	attr.Mode = fuse.S_IFREG | 0444
+	attr.Size = uint64(f.size)
+}
+
+func (f *p2pFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	f.fill(&out.Attr)
+	return 0
+}
+
+func (f *p2pFile) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	info, err := queryFileInfo(f.groupID, f.fileName)
+	if err != nil {
+		return nil, 0, syscall.ENOENT
+	}
+	addDHTPeers(info)
+	rf, err := NewRemoteFile(info, f.cacheDir)
+	if err != nil {
+		fmt.Printf("✗ %s/%s: %v\n", f.groupID, f.fileName, err)
+		return nil, 0, syscall.EIO
+	}
+	transfers.NameFile(info.FileHash, info.FileName, info.TotalChunks)
+	// The content is fixed by its hash, so the page cache stays valid
+	return rf, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (f *p2pFile) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	rf, ok := fh.(*RemoteFile)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	n, err := rf.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		fmt.Printf("✗ %s/%s: %v\n", f.groupID, f.fileName, err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// MountP2PFS mounts the user's groups read-only at mountpoint and serves
+// them until the filesystem is unmounted or the process is interrupted.
+// Fetched chunks are kept in cacheDir if it isn't "".
+func MountP2PFS(mountpoint, cacheDir string) error {
+	timeout := fuseTimeout
+	server, err := fs.Mount(mountpoint, &p2pRoot{cacheDir: cacheDir}, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:  "p2p",
+			Name:    "p2p",
+			Options: []string{"ro"},
+		},
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Mounted at %s (read-only); unmount or Ctrl-C to stop\n", mountpoint)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		if _, ok := <-sigs; ok {
+			server.Unmount()
+		}
+	}()
+	server.Wait()
+	return nil
+}
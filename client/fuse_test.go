@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// TestChunkRange checks byte ranges map to the chunks holding them,
+// clipped to the end of the file.
+func TestChunkRange(t *testing.T) {
+	const cs = 100
+	tests := []struct {
+		name            string
+		off, size, file int64
+		first, last     int
+		ok              bool
+	}{
+		{"first byte", 0, 1, 1000, 0, 0, true},
+		{"whole first chunk", 0, cs, 1000, 0, 0, true},
+		{"one past first chunk", 0, cs + 1, 1000, 0, 1, true},
+		{"last byte of chunk", cs - 1, 1, 1000, 0, 0, true},
+		{"first byte of chunk", cs, 1, 1000, 1, 1, true},
+		{"across a boundary", 150, 100, 1000, 1, 2, true},
+		{"several chunks", 250, 500, 1000, 2, 7, true},
+		{"whole file", 0, 1000, 1000, 0, 9, true},
+		{"clipped at end", 950, 4096, 1000, 9, 9, true},
+		{"short last chunk", 1000, 10, 1050, 10, 10, true},
+		{"at end of file", 1000, 10, 1000, 0, 0, false},
+		{"past end of file", 5000, 10, 1000, 0, 0, false},
+		{"empty read", 10, 0, 1000, 0, 0, false},
+		{"negative offset", -1, 10, 1000, 0, 0, false},
+		{"empty file", 0, 10, 0, 0, 0, false},
+		{"overflowing size", 10, 1<<63 - 1, 1000, 0, 9, true},
+	}
+	for _, tt := range tests {
+		first, last, ok := chunkRange(tt.off, tt.size, cs, tt.file)
+		if ok != tt.ok || (ok && (first != tt.first || last != tt.last)) {
+			t.Errorf("%s: chunkRange(%d, %d, %d, %d) = %d, %d, %v; want %d, %d, %v",
+				tt.name, tt.off, tt.size, cs, tt.file, first, last, ok, tt.first, tt.last, tt.ok)
+		}
+	}
+}
+
+// cachedChunks lists the chunk files in a RemoteFile cache directory.
+func cachedChunks(t *testing.T, dir, fileHash string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dir, fileHash))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestRemoteFile_FetchesOnlyCoveringChunks reads a range across one chunk
+// boundary of a 4-chunk file and checks only those two chunks were fetched.
+func TestRemoteFile_FetchesOnlyCoveringChunks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 3*smallChunkSize+100)
+	peer := startTestPeer(t)
+	cacheDir := t.TempDir()
+
+	rf, err := NewRemoteFile(streamTestInfo(meta, peer), cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := meta.ChunkSize + meta.ChunkSize/2
+	buf := make([]byte, meta.ChunkSize)
+	n, err := rf.ReadAt(buf, off)
+	if err != nil || n != len(buf) {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+	if !bytes.Equal(buf, content[off:off+int64(len(buf))]) {
+		t.Error("ReadAt returned the wrong bytes")
+	}
+	if got := cachedChunks(t, cacheDir, meta.FileHash); len(got) != 2 || got[0] != "chunk_1.dat" || got[1] != "chunk_2.dat" {
+		t.Errorf("fetched %v, want chunks 1 and 2", got)
+	}
+
+	// A read running past the end is cut short
+	n, err = rf.ReadAt(buf, meta.FileSize-10)
+	if err != io.EOF || n != 10 || !bytes.Equal(buf[:n], content[len(content)-10:]) {
+		t.Errorf("ReadAt at end = %d, %v", n, err)
+	}
+	if n, err := rf.ReadAt(buf, meta.FileSize); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt past end = %d, %v", n, err)
+	}
+}
+
+// TestRemoteFile_ReadsFromCache checks cached chunks are read without any
+// peer, and a corrupt cached chunk isn't used.
+func TestRemoteFile_ReadsFromCache(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*smallChunkSize+100)
+	cacheDir := t.TempDir()
+
+	rf, err := NewRemoteFile(streamTestInfo(meta, startTestPeer(t)), cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := make([]byte, meta.FileSize)
+	if n, err := rf.ReadAt(all, 0); n != len(content) || err != nil {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+
+	// Nothing is listening on the peer address any more
+	t.Cleanup(func() { clearBlacklist() })
+	offline := streamTestInfo(meta, "127.0.0.1:1")
+	rf, err = NewRemoteFile(offline, cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, meta.FileSize)
+	if n, err := rf.ReadAt(got, 0); n != len(content) || err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("ReadAt from cache = %d, %v", n, err)
+	}
+
+	chunk0 := filepath.Join(cacheDir, meta.FileHash, "chunk_0.dat")
+	if err := os.WriteFile(chunk0, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err = NewRemoteFile(offline, cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.ReadAt(got[:10], 0); err == nil {
+		t.Error("corrupt cached chunk was read")
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	recentPeersMax   = 20              // connections remembered as gossip targets
+	bitfieldCacheTTL = 5 * time.Minute // how long a gossiped bitfield is trusted
+	gossipCacheFile  = "gossip.json"   // in ChunksDir, shared by the client processes
+)
+
+// RecentPeers remembers the last few peers we talked to, most recent first.
+type RecentPeers struct {
+	mu    sync.Mutex
+	max   int
+	addrs []string
+}
+
+func newRecentPeers(max int) *RecentPeers {
+	return &RecentPeers{max: max}
+}
+
+// Add moves addr to the front, dropping the oldest entry when full.
+func (r *RecentPeers) Add(addr string) {
+	if addr == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, a := range r.addrs {
+		if a == addr {
+			r.addrs = append(r.addrs[:i], r.addrs[i+1:]...)
+			break
+		}
+	}
+	r.addrs = append([]string{addr}, r.addrs...)
+	if len(r.addrs) > r.max {
+		r.addrs = r.addrs[:r.max]
+	}
+}
+
+// List returns a copy of the remembered peers.
+func (r *RecentPeers) List() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.addrs...)
+}
+
+type cachedBitfield struct {
+	Bitfield []bool    `json:"bitfield"`
+	Expires  time.Time `json:"expires"`
+}
+
+// PeerBitfieldCache holds bitfields learned from gossip or get_bitfield,
+// keyed by file hash and then peer address. Entries expire after ttl.
+//
+// Gossip arrives at the peer_daemon process, but downloads run in the CLI
+// process, so a cache with a path keeps its entries in that file as well:
+// each change is written to it, and entries other processes wrote are
+// merged in when it has changed since it was last read.
+type PeerBitfieldCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]map[string]cachedBitfield
+
+	path   string    // "" = this process only
+	loaded time.Time // modification time of path when last read or written
+}
+
+func newPeerBitfieldCache(ttl time.Duration) *PeerBitfieldCache {
+	return &PeerBitfieldCache{ttl: ttl, now: time.Now, entries: make(map[string]map[string]cachedBitfield)}
+}
+
+// newSharedPeerBitfieldCache returns a cache kept in path too. Nothing is
+// written until path's directory exists.
+func newSharedPeerBitfieldCache(ttl time.Duration, path string) *PeerBitfieldCache {
+	c := newPeerBitfieldCache(ttl)
+	c.path = path
+	return c
+}
+
+// Get returns peer's bitfield for fileHash if a fresh one is cached.
+func (c *PeerBitfieldCache) Get(fileHash, peer string) ([]bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	e, ok := c.entries[fileHash][peer]
+	if !ok || c.now().After(e.Expires) {
+		return nil, false
+	}
+	return e.Bitfield, true
+}
+
+// Peers returns every peer with a fresh bitfield for fileHash.
+func (c *PeerBitfieldCache) Peers(fileHash string) map[string][]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	now := c.now()
+	res := make(map[string][]bool)
+	for peer, e := range c.entries[fileHash] {
+		if !now.After(e.Expires) {
+			res[peer] = e.Bitfield
+		}
+	}
+	return res
+}
+
+// Update stores peer's bitfield and reports whether it told us anything new,
+// i.e. the entry was missing, expired or different.
+func (c *PeerBitfieldCache) Update(fileHash, peer string, bf []bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	now := c.now()
+	byPeer, ok := c.entries[fileHash]
+	if !ok {
+		byPeer = make(map[string]cachedBitfield)
+		c.entries[fileHash] = byPeer
+	}
+	old, had := byPeer[peer]
+	byPeer[peer] = cachedBitfield{Bitfield: bf, Expires: now.Add(c.ttl)}
+	news := !had || now.After(old.Expires) || !reflect.DeepEqual(old.Bitfield, bf)
+	// A repeat only needs saving once the saved entry is half expired
+	if news || old.Expires.Before(now.Add(c.ttl/2)) {
+		c.save()
+	}
+	return news
+}
+
+// load merges in the entries of c.path if another process changed it
+// since it was last read, keeping the fresher of two entries for a peer.
+// Caller must hold c.mu.
+func (c *PeerBitfieldCache) load() {
+	if c.path == "" {
+		return
+	}
+	info, err := os.Stat(c.path)
+	if err != nil || info.ModTime().Equal(c.loaded) {
+		return
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return
+	}
+	var saved map[string]map[string]cachedBitfield
+	if json.Unmarshal(data, &saved) != nil {
+		return
+	}
+	c.loaded = info.ModTime()
+	for fileHash, byPeer := range saved {
+		for peer, e := range byPeer {
+			if cur, ok := c.entries[fileHash][peer]; ok && !e.Expires.After(cur.Expires) {
+				continue
+			}
+			if c.entries[fileHash] == nil {
+				c.entries[fileHash] = make(map[string]cachedBitfield)
+			}
+			c.entries[fileHash][peer] = e
+		}
+	}
+}
+
+// save writes the fresh entries to c.path, replacing it whole so readers
+// never see it half-written. Caller must hold c.mu.
+func (c *PeerBitfieldCache) save() {
+	if c.path == "" {
+		return
+	}
+	now := c.now()
+	fresh := make(map[string]map[string]cachedBitfield)
+	for fileHash, byPeer := range c.entries {
+		for peer, e := range byPeer {
+			if now.After(e.Expires) {
+				continue
+			}
+			if fresh[fileHash] == nil {
+				fresh[fileHash] = make(map[string]cachedBitfield)
+			}
+			fresh[fileHash][peer] = e
+		}
+	}
+	data, err := json.Marshal(fresh)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), gossipCacheFile+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err != nil || cerr != nil || os.Rename(tmp.Name(), c.path) != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if info, err := os.Stat(c.path); err == nil {
+		c.loaded = info.ModTime()
+	}
+}
+
+// gossipNode spreads chunk availability between peers without the tracker.
+// A peer announces its own bitfield to its recent peers after each chunk it
+// downloads; a receiver caches it and, if it was news, passes it on.
+type gossipNode struct {
+	self   string // address other peers dial us on; "" = derive from State
+	recent *RecentPeers
+	cache  *PeerBitfieldCache
+}
+
+var localGossip = &gossipNode{
+	recent: newRecentPeers(recentPeersMax),
+	cache:  newSharedPeerBitfieldCache(bitfieldCacheTTL, filepath.Join(ChunksDir, gossipCacheFile)),
+}
+
+func (n *gossipNode) selfAddr() string {
+	if n.self != "" {
+		return n.self
+	}
+	if State.ListenAddr != "" {
+		return "127.0.0.1" + State.ListenAddr
+	}
+	return ""
+}
+
+// announce gossips our current bitfield for fileHash, as found on disk.
+// The disk scan and the sends run in the background; only our address,
+// which comes from State, is looked up on the caller's goroutine.
+func (n *gossipNode) announce(fileHash string) {
+	self := n.selfAddr()
+	if self == "" {
+		return
+	}
+	pinned := *n
+	pinned.self = self
+	go func() {
+		if bf := localBitfield(fileHash); bf != nil {
+			pinned.broadcast(fileHash, self, bf, "")
+		}
+	}()
+}
+
+// broadcast sends origin's bitfield to every recent peer except skip and origin.
+// Nothing is sent until we have an address the receivers could reach us on.
+func (n *gossipNode) broadcast(fileHash, origin string, bitfield []int, skip string) {
+	from := n.selfAddr()
+	if from == "" || origin == "" {
+		return
+	}
+	req := PeerRequest{Cmd: "gossip_chunks", FileHash: fileHash, Bitfield: bitfield, Origin: origin, From: from}
+	for _, peer := range n.recent.List() {
+		if peer != skip && peer != origin && peer != from {
+			go sendGossip(peer, req)
+		}
+	}
+}
+
+// handleGossip caches the bitfield in req and relays it if it was news.
+// Relaying only on change is what stops the gossip from looping.
+func (n *gossipNode) handleGossip(conn net.Conn, req PeerRequest) {
+	common.Send(conn, PeerResponse{Status: "ok"})
+
+	n.recent.Add(req.From)
+	origin := req.Origin
+	if origin == "" {
+		origin = req.From
+	}
+	if origin == "" || origin == n.selfAddr() {
+		return
+	}
+	if n.cache.Update(req.FileHash, origin, indicesToBitfield(req.Bitfield)) {
+		n.broadcast(req.FileHash, origin, req.Bitfield, req.From)
+	}
+}
+
+// sendGossip delivers one gossip message; failures are ignored.
+func sendGossip(peer string, req PeerRequest) {
+	if offline {
+		return
+	}
+	conn, err := net.DialTimeout("tcp", peer, 2*time.Second)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := common.Send(conn, req); err != nil {
+		return
+	}
+	var resp PeerResponse
+	common.Recv(conn, &resp)
+}
+
+// addGossipPeers adds peers known from gossip to hold chunks of the file.
+func addGossipPeers(fileInfo *FileInfo) {
+	self := localGossip.selfAddr()
+	known := make(map[string]bool, len(fileInfo.Peers))
+	for _, p := range fileInfo.Peers {
+		known[p] = true
+	}
+	for peer := range localGossip.cache.Peers(fileInfo.FileHash) {
+		if !known[peer] && peer != self {
+			fileInfo.Peers = append(fileInfo.Peers, peer)
+			known[peer] = true
+		}
+	}
+}
+
+// localBitfield lists the chunk indices we hold for fileHash, or nil if we have none.
+func localBitfield(fileHash string) []int {
+	entries, err := os.ReadDir(filepath.Join(ChunksDir, fileHash))
+	if err != nil {
+		return nil
+	}
+	var bf []int
+	for _, e := range entries {
+		var idx int
+		if _, err := fmt.Sscanf(e.Name(), "chunk_%d.dat", &idx); err == nil {
+			bf = append(bf, idx)
+		}
+	}
+	return bf
+}
+
+// indicesToBitfield converts a chunk index list to []bool indexed by chunk index.
+func indicesToBitfield(indices []int) []bool {
+	maxIdx := -1
+	for _, idx := range indices {
+		if idx > maxIdx {
+			maxIdx = idx
+		}
+	}
+	bf := make([]bool, maxIdx+1)
+	for _, idx := range indices {
+		if idx >= 0 {
+			bf[idx] = true
+		}
+	}
+	return bf
+}
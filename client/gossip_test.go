@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"p2p/common"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startGossipNode serves gossip_chunks for a fresh gossipNode on a random port
+// and counts every connection it accepts.
+func startGossipNode(t *testing.T) (*gossipNode, *int64) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	n := &gossipNode{
+		self:   ln.Addr().String(),
+		recent: newRecentPeers(recentPeersMax),
+		cache:  newPeerBitfieldCache(bitfieldCacheTTL),
+	}
+	var conns int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&conns, 1)
+			go func(c net.Conn) {
+				defer c.Close()
+				var req PeerRequest
+				if err := common.Recv(c, &req); err != nil {
+					return
+				}
+				if req.Cmd == "gossip_chunks" {
+					n.handleGossip(c, req)
+				} else {
+					common.Send(c, PeerResponse{Status: "error"})
+				}
+			}(conn)
+		}
+	}()
+	return n, &conns
+}
+
+// waitForBitfield polls n's cache until it holds peer's bitfield for fileHash.
+func waitForBitfield(t *testing.T, n *gossipNode, fileHash, peer string) []bool {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if bf, ok := n.cache.Get(fileHash, peer); ok {
+			return bf
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never learned %s's bitfield", n.self, peer)
+	return nil
+}
+
+// TestGossip_ThirdPartyRelay: B has talked to C, and C has talked to A, but A
+// and B have never met. When B gossips its chunks, A must learn them through C.
+func TestGossip_ThirdPartyRelay(t *testing.T) {
+	a, connsA := startGossipNode(t)
+	b, connsB := startGossipNode(t)
+	c, _ := startGossipNode(t)
+	b.recent.Add(c.self)
+	c.recent.Add(a.self)
+
+	b.broadcast("filehash", b.self, []int{0, 2}, "")
+
+	got := waitForBitfield(t, a, "filehash", b.self)
+	if want := []bool{true, false, true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("A has B's bitfield as %v, want %v", got, want)
+	}
+	if _, ok := c.cache.Get("filehash", b.self); !ok {
+		t.Error("C should have cached B's bitfield on the way through")
+	}
+	if n := atomic.LoadInt64(connsB); n != 0 {
+		t.Errorf("B received %d connections; A must not contact B directly", n)
+	}
+
+	// A repeat of the same news isn't relayed again
+	before := atomic.LoadInt64(connsA)
+	b.broadcast("filehash", b.self, []int{0, 2}, "")
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(connsA); n != before {
+		t.Errorf("unchanged bitfield was relayed to A again (%d new connections)", n-before)
+	}
+}
+
+// TestPeerBitfieldCache_Expiry verifies entries stop being served after the TTL.
+func TestPeerBitfieldCache_Expiry(t *testing.T) {
+	now := time.Now()
+	c := newPeerBitfieldCache(bitfieldCacheTTL)
+	c.now = func() time.Time { return now }
+
+	if !c.Update("h", "peer", []bool{true}) {
+		t.Error("first update should be news")
+	}
+	if c.Update("h", "peer", []bool{true}) {
+		t.Error("identical update should not be news")
+	}
+
+	now = now.Add(bitfieldCacheTTL - time.Second)
+	if _, ok := c.Get("h", "peer"); !ok {
+		t.Error("entry expired early")
+	}
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Get("h", "peer"); ok {
+		t.Error("entry should have expired")
+	}
+	if len(c.Peers("h")) != 0 {
+		t.Error("expired entry still listed")
+	}
+	if !c.Update("h", "peer", []bool{true}) {
+		t.Error("refreshing an expired entry should be news")
+	}
+}
+
+// TestRecentPeers_KeepsLastTwenty verifies the cache is bounded and most recent first.
+func TestRecentPeers_KeepsLastTwenty(t *testing.T) {
+	r := newRecentPeers(recentPeersMax)
+	for i := 0; i < 25; i++ {
+		r.Add(string(rune('a' + i)))
+	}
+	r.Add("f")
+
+	got := r.List()
+	if len(got) != recentPeersMax {
+		t.Fatalf("kept %d peers, want %d", len(got), recentPeersMax)
+	}
+	if got[0] != "f" || got[1] != "y" {
+		t.Errorf("order = %v, want f then y first", got[:2])
+	}
+	for _, p := range got {
+		if p == "a" {
+			t.Error("oldest peer should have been dropped")
+		}
+	}
+}
+
+// TestGetBitfields_UsesGossipCache verifies a cached bitfield is used without
+// dialing the peer.
+func TestGetBitfields_UsesGossipCache(t *testing.T) {
+	saved := localGossip.cache
+	localGossip.cache = newPeerBitfieldCache(bitfieldCacheTTL)
+	t.Cleanup(func() { localGossip.cache = saved })
+
+	// Nothing listens here, so only the cache can answer
+	const peer = "127.0.0.1:1"
+	localGossip.cache.Update("h", peer, []bool{false, true})
+
+	got := getBitfields([]string{peer}, "h")
+	if want := []bool{false, true}; !reflect.DeepEqual(got[peer], want) {
+		t.Errorf("bitfield = %v, want %v", got[peer], want)
+	}
+}
+
+// gossipDaemonDirEnv runs TestGossipDaemonProcess as the peer daemon in
+// the directory it names.
+const gossipDaemonDirEnv = "P2P_TEST_GOSSIP_DAEMON_DIR"
+
+// TestGossipDaemonProcess is the peer_daemon side of
+// TestGossipCache_SharedWithDaemon: it prints its address, takes one
+// gossip message into localGossip and exits.
+func TestGossipDaemonProcess(t *testing.T) {
+	dir := os.Getenv(gossipDaemonDirEnv)
+	if dir == "" {
+		t.Skip("run by TestGossipCache_SharedWithDaemon")
+	}
+	t.Chdir(dir)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	localGossip.self = ln.Addr().String()
+	fmt.Println(ln.Addr())
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var req PeerRequest
+	if err := common.Recv(conn, &req); err != nil {
+		t.Fatal(err)
+	}
+	localGossip.handleGossip(conn, req)
+}
+
+// TestGossipCache_SharedWithDaemon gossips a bitfield to a peer daemon in
+// another process and checks a download in this one, as the CLI runs it,
+// finds it without asking the peer.
+func TestGossipCache_SharedWithDaemon(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	os.MkdirAll(ChunksDir, 0755)
+
+	daemon := exec.Command(os.Args[0], "-test.run=^TestGossipDaemonProcess$")
+	daemon.Env = append(os.Environ(), gossipDaemonDirEnv+"="+dir)
+	out, err := daemon.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { daemon.Process.Kill() })
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("daemon address: %v", err)
+	}
+
+	// Nothing listens at the origin, so only the cache can answer
+	const origin = "127.0.0.1:1"
+	sendGossip(strings.TrimSpace(addr), PeerRequest{Cmd: "gossip_chunks", FileHash: "h", Bitfield: []int{1}, Origin: origin, From: origin})
+	if err := daemon.Wait(); err != nil {
+		t.Fatalf("daemon: %v", err)
+	}
+
+	saved := localGossip.cache
+	localGossip.cache = newSharedPeerBitfieldCache(bitfieldCacheTTL, filepath.Join(ChunksDir, gossipCacheFile))
+	t.Cleanup(func() { localGossip.cache = saved })
+	got := getBitfields([]string{origin}, "h")
+	if want := []bool{false, true}; !reflect.DeepEqual(got[origin], want) {
+		t.Errorf("bitfield = %v, want %v from the daemon's gossip", got[origin], want)
+	}
+}
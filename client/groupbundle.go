@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ExportGroup asks the tracker for a bundle of a group the user owns and
+// writes it to path, <groupID>.bundle.json if path is empty. It returns
+// the path written.
+func ExportGroup(groupID, path string) (string, error) {
+	resp := SendToTracker(Message{Cmd: "export_group", Args: []string{groupID, State.UserID}})
+	if resp.Status != "ok" {
+		return "", fmt.Errorf("%v", resp.Data)
+	}
+	data, err := json.MarshalIndent(resp.Data, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		path = groupID + ".bundle.json"
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// ImportGroup sends the bundle at path to the tracker, which merges it
+// into its state with the user as the group's owner.
+func ImportGroup(path string) (Response, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Response{}, err
+	}
+	if !json.Valid(data) {
+		return Response{}, fmt.Errorf("%s is not JSON", path)
+	}
+	return SendToTracker(Message{Cmd: "import_group", Args: []string{State.UserID, string(data)}}), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Availability levels a heatmap cell is drawn at, with its character and
+// ANSI color.
+var heatmapLevels = []struct {
+	char  byte
+	color string
+	label string
+}{
+	{'x', "\033[1;31m", "on no seeder"},
+	{'.', "\033[31m", "rare"},
+	{'o', "\033[33m", "on some seeders"},
+	{'#', "\033[32m", "on most seeders"},
+}
+
+const ansiReset = "\033[0m"
+
+// heatmapLevel returns the index in heatmapLevels for a chunk held by
+// count of peers: none, up to a third of them, up to two thirds, or more.
+func heatmapLevel(count, peers int) int {
+	switch {
+	case count <= 0:
+		return 0
+	case count*3 <= peers:
+		return 1
+	case count*3 <= 2*peers:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// bucketChunks groups per-chunk peer counts into at most width cells of
+// the same number of chunks, the last one possibly shorter. Each cell
+// holds the count of its rarest chunk, since that chunk decides whether
+// the file can be completed. It returns the cells and how many chunks
+// each covers.
+func bucketChunks(counts []int, width int) ([]int, int) {
+	if len(counts) == 0 || width <= 0 {
+		return nil, 1
+	}
+	per := (len(counts) + width - 1) / width
+	cells := make([]int, 0, (len(counts)+per-1)/per)
+	for start := 0; start < len(counts); start += per {
+		end := min(start+per, len(counts))
+		rarest := counts[start]
+		for _, c := range counts[start+1 : end] {
+			rarest = min(rarest, c)
+		}
+		cells = append(cells, rarest)
+	}
+	return cells, per
+}
+
+// terminalWidth returns $COLUMNS, or 80 when it isn't set.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// printHeatmap draws counts, the peers holding each chunk out of peers,
+// as one row of cells fitting in width columns, then a legend and the
+// number of chunks no peer holds. color adds ANSI colors to the cells.
+func printHeatmap(w io.Writer, counts []int, peers, width int, color bool) {
+	cells, per := bucketChunks(counts, max(width-2, 1))
+
+	var row strings.Builder
+	row.WriteByte('|')
+	for _, c := range cells {
+		lvl := heatmapLevels[heatmapLevel(c, peers)]
+		if color {
+			row.WriteString(lvl.color + string(lvl.char) + ansiReset)
+		} else {
+			row.WriteByte(lvl.char)
+		}
+	}
+	row.WriteByte('|')
+	fmt.Fprintln(w, row.String())
+
+	var legend []string
+	for _, lvl := range heatmapLevels {
+		legend = append(legend, fmt.Sprintf("%c %s", lvl.char, lvl.label))
+	}
+	if per > 1 {
+		legend = append(legend, fmt.Sprintf("(%d chunks per cell, shown at the rarest)", per))
+	}
+	fmt.Fprintln(w, strings.Join(legend, "  "))
+
+	missing := 0
+	for _, c := range counts {
+		if c == 0 {
+			missing++
+		}
+	}
+	if missing > 0 {
+		fmt.Fprintf(w, "%d of %d chunks are on no seeder: the file can't be completed\n", missing, len(counts))
+	}
+}
+
+// ChunkHeatmap asks every seeder of a file which chunks it holds and
+// prints how available each chunk is across the swarm, in color unless
+// NO_COLOR is set.
+func ChunkHeatmap(groupID, fileName string) error {
+	fileInfo, err := queryFileInfo(groupID, fileName)
+	if err != nil {
+		return err
+	}
+	if len(fileInfo.Peers) == 0 {
+		return fmt.Errorf("no seeders for %s", fileName)
+	}
+
+	bitfields := getBitfields(fileInfo.Peers, fileInfo.FileHash)
+	counts := chunkAvailability(bitfields, fileInfo.TotalChunks)
+	fmt.Printf("%s: %d chunks across %d seeders\n", fileName, fileInfo.TotalChunks, len(bitfields))
+	printHeatmap(os.Stdout, counts, len(bitfields), terminalWidth(), os.Getenv("NO_COLOR") == "")
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChunkAvailability(t *testing.T) {
+	got := chunkAvailability(map[string][]bool{
+		"a": {true, true, false, false, true},
+		"b": {false, true, false, true},                // shorter than the file
+		"c": nil,                                       // unknown: has everything
+		"d": {false, false, false, false, false, true}, // longer
+	}, 5)
+	if want := []int{2, 3, 1, 2, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chunkAvailability = %v, want %v", got, want)
+	}
+	if got := chunkAvailability(nil, 3); !reflect.DeepEqual(got, []int{0, 0, 0}) {
+		t.Errorf("no peers: %v", got)
+	}
+}
+
+func TestBucketChunks(t *testing.T) {
+	for _, tc := range []struct {
+		counts []int
+		width  int
+		cells  []int
+		per    int
+	}{
+		{[]int{3, 1, 2}, 10, []int{3, 1, 2}, 1},                        // fits: a cell per chunk
+		{[]int{3, 1, 2, 2}, 4, []int{3, 1, 2, 2}, 1},                   // exactly fits
+		{[]int{3, 1, 2, 2, 0, 4}, 3, []int{1, 2, 0}, 2},                // two per cell, rarest shown
+		{[]int{5, 4, 3, 2, 1, 0, 6, 7, 8, 9}, 4, []int{3, 0, 6, 9}, 3}, // short last cell
+		{[]int{2, 2, 2, 2, 2}, 1, []int{2}, 5},
+		{nil, 80, nil, 1},
+	} {
+		cells, per := bucketChunks(tc.counts, tc.width)
+		if !reflect.DeepEqual(cells, tc.cells) || per != tc.per {
+			t.Errorf("bucketChunks(%v, %d) = %v, %d; want %v, %d", tc.counts, tc.width, cells, per, tc.cells, tc.per)
+		}
+		if len(cells) > tc.width {
+			t.Errorf("bucketChunks(%v, %d): %d cells", tc.counts, tc.width, len(cells))
+		}
+	}
+
+	// A large file still fits the terminal
+	cells, per := bucketChunks(make([]int, 10_001), 78)
+	if len(cells) > 78 || per*len(cells) < 10_001 {
+		t.Errorf("10001 chunks in 78 columns: %d cells of %d", len(cells), per)
+	}
+}
+
+func TestHeatmapLevel(t *testing.T) {
+	for _, tc := range []struct{ count, peers, want int }{
+		{0, 3, 0}, {1, 3, 1}, {2, 3, 2}, {3, 3, 3},
+		{1, 1, 3}, {1, 2, 2}, {2, 9, 1}, {7, 9, 3},
+	} {
+		if got := heatmapLevel(tc.count, tc.peers); got != tc.want {
+			t.Errorf("heatmapLevel(%d, %d) = %d, want %d", tc.count, tc.peers, got, tc.want)
+		}
+	}
+}
+
+func TestPrintHeatmap(t *testing.T) {
+	var buf bytes.Buffer
+	printHeatmap(&buf, []int{3, 3, 1, 0, 2, 2}, 3, 5, false)
+	out := buf.String()
+	// 3 columns inside the bars: two chunks per cell
+	if row := strings.SplitN(out, "\n", 2)[0]; row != "|#xo|" {
+		t.Errorf("row = %q", row)
+	}
+	if !strings.Contains(out, "2 chunks per cell") || !strings.Contains(out, "1 of 6 chunks are on no seeder") {
+		t.Errorf("output:\n%s", out)
+	}
+
+	buf.Reset()
+	printHeatmap(&buf, []int{3}, 3, 80, true)
+	if row := strings.SplitN(buf.String(), "\n", 2)[0]; row != "|\033[32m#\033[0m|" {
+		t.Errorf("colored row = %q", row)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// printCommandTable prints the list_commands reply as a usage table, one
+// tracker command per line in name order. Commands that act as a user are
+// marked with "*".
+func printCommandTable(w io.Writer, data interface{}) error {
+	specs, ok := data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected reply: %v", data)
+	}
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Tracker commands (* needs a logged-in user):")
+	for _, name := range names {
+		spec, _ := specs[name].(map[string]interface{})
+		var args []string
+		list, _ := spec["args"].([]interface{})
+		for _, a := range list {
+			if s, ok := a.(string); ok {
+				args = append(args, "<"+s+">")
+			}
+		}
+		auth := " "
+		if requires, _ := spec["requires_auth"].(bool); requires {
+			auth = "*"
+		}
+		usage := strings.TrimSpace(name + " " + strings.Join(args, " "))
+		fmt.Fprintf(w, "%s %-60s %v\n", auth, usage, spec["description"])
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPrintCommandTable(t *testing.T) {
+	var data interface{}
+	json.Unmarshal([]byte(`{
+		"list_groups": {"description": "List groups", "args": ["public?"], "requires_auth": false},
+		"join_group": {"description": "Ask to join a group", "args": ["groupID", "userID"], "requires_auth": true},
+		"list_commands": {"description": "List the commands", "args": null, "requires_auth": false}
+	}`), &data)
+
+	var out bytes.Buffer
+	if err := printCommandTable(&out, data); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines:\n%s", len(lines), out.String())
+	}
+	// Sorted by name, with the auth marker first
+	if !strings.HasPrefix(lines[1], "* join_group <groupID> <userID> ") || !strings.HasSuffix(lines[1], "Ask to join a group") {
+		t.Errorf("join_group line = %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "  list_commands ") || !strings.HasPrefix(lines[3], "  list_groups <public?> ") {
+		t.Errorf("lines = %q", lines[2:])
+	}
+
+	if err := printCommandTable(&out, "no such command"); err == nil {
+		t.Error("expected an error for a non-map reply")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// inFlight holds a sentinel for every "peerAddr:fileHash:chunkIdx" request
+// currently open, so concurrent workers never ask one peer for the same chunk twice.
+var inFlight sync.Map
+
+// Backoff while every candidate peer is already serving this chunk to another worker
+const (
+	inFlightBaseDelay = 20 * time.Millisecond
+	inFlightMaxDelay  = 500 * time.Millisecond
+)
+
+func inFlightKey(peer, fileHash string, chunkIdx int) string {
+	return fmt.Sprintf("%s:%s:%d", peer, fileHash, chunkIdx)
+}
+
+// parallelWorkers returns the number of download workers from P2P_PARALLEL.
+// Anything below 2 means the plain sequential download.
+func parallelWorkers() int {
+	n, err := strconv.Atoi(os.Getenv("P2P_PARALLEL"))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// chunkCandidates lists the peers to try for chunk i, preferred peer first.
+// When bitfields were queried only peers known to hold the chunk are listed.
+// Peers with speed_test results come first, fastest first, and peers the
+// DHT says hold the chunk go ahead of those that may not.
+func chunkCandidates(fileInfo *FileInfo, peerBitfields map[string][]bool, i int) []string {
+	pool := fileInfo.Peers
+	if peerBitfields != nil {
+		qualified := make([]string, 0, len(peerBitfields))
+		for p, bf := range peerBitfields {
+			if bf == nil || (i < len(bf) && bf[i]) {
+				qualified = append(qualified, p)
+			}
+		}
+		if len(qualified) > 0 {
+			pool = qualified
+		}
+	}
+
+	// Rotate so chunks are spread round-robin across peers
+	start := i % len(pool)
+	rotated := append(append([]string(nil), pool[start:]...), pool[:start]...)
+	ranked := rankPeers(rotated, time.Now())
+	if holders := fileInfo.ChunkPeers[i]; len(holders) > 0 {
+		has := make(map[string]bool, len(holders))
+		for _, p := range holders {
+			has[p] = true
+		}
+		sort.SliceStable(ranked, func(a, b int) bool { return has[ranked[a]] && !has[ranked[b]] })
+	}
+	return ranked
+}
+
+// downloadChunk fetches, validates and saves chunk i to chunkPath. It claims
+// the first candidate peer that no other worker is using for this chunk, and
+// backs off while all of them are busy. A peer that fails (including one that
+// reports its copy corrupt) isn't tried again; the chunk fails once every
+// candidate has. It returns the peer the chunk came from, or "" without
+// fetching if the chunk reached disk in the meantime.
+func downloadChunk(candidates []string, fileInfo *FileInfo, i int, chunkPath, label string) (string, error) {
+	failed := make(map[string]bool)
+	lastErr := fmt.Errorf("no peers for chunk %d", i)
+	for attempt := 0; ; attempt++ {
+		remaining := 0
+		for _, peer := range candidates {
+			if failed[peer] || peerBlacklisted(peer) {
+				continue
+			}
+			key := inFlightKey(peer, fileInfo.FileHash, i)
+			if _, busy := inFlight.LoadOrStore(key, struct{}{}); busy {
+				remaining++
+				continue
+			}
+			fetched, err := fetchClaimedChunk(peer, fileInfo, i, chunkPath, label)
+			inFlight.Delete(key)
+			if err == nil {
+				if !fetched {
+					return "", nil
+				}
+				return peer, nil
+			}
+			failed[peer] = true
+			lastErr = err
+			fmt.Printf("✗ %s: %v\n", peer, err)
+		}
+		if remaining == 0 {
+			return "", lastErr
+		}
+
+		delay := inFlightBaseDelay << attempt
+		if delay > inFlightMaxDelay || delay <= 0 {
+			delay = inFlightMaxDelay
+		}
+		time.Sleep(delay)
+		if _, err := os.Stat(chunkPath); err == nil {
+			return "", nil
+		}
+	}
+}
+
+// fetchClaimedChunk does the actual transfer once the caller holds the in-flight slot.
+// The chunk is written via a temp file so other workers never see a partial one.
+func fetchClaimedChunk(peer string, fileInfo *FileInfo, i int, chunkPath, label string) (bool, error) {
+	if _, err := os.Stat(chunkPath); err == nil {
+		return false, nil // another worker finished it while we waited
+	}
+
+	fmt.Printf("Downloading chunk %d/%d from %s%s...\n", i+1, fileInfo.TotalChunks, peer, label)
+	chunkData, algo, proof, err := requestChunkProof(peer, fileInfo.FileHash, i, fileInfo.MerkleRoot != "")
+	if err != nil {
+		return false, fmt.Errorf("failed to download chunk %d: %v", i, err)
+	}
+	if err := saveChunk(peer, fileInfo, i, chunkPath, chunkData, algo, proof); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// saveChunk validates chunk i as received from peer and writes it to
+// chunkPath via a temp file.
+func saveChunk(peer string, fileInfo *FileInfo, i int, chunkPath string, chunkData []byte, algo string, proof []string) error {
+	if !verifyChunk(chunkData, algo, proof, fileInfo, i) {
+		recordPeerFailure(peer)
+		return fmt.Errorf("chunk %d hash mismatch", i)
+	}
+	recordPeerSuccess(peer)
+
+	// Dot-prefixed so get_bitfield never advertises a half-written chunk
+	tmpPath := filepath.Join(filepath.Dir(chunkPath), "."+filepath.Base(chunkPath)+".part")
+	if err := os.WriteFile(tmpPath, chunkData, 0644); err != nil {
+		return fmt.Errorf("failed to save chunk %d: %v", i, err)
+	}
+	if err := os.Rename(tmpPath, chunkPath); err != nil {
+		return fmt.Errorf("failed to save chunk %d: %v", i, err)
+	}
+
+	// Tell the peers we've been talking to that we now have one more chunk
+	localGossip.announce(fileInfo.FileHash)
+	return nil
+}
+
+// runWorkers calls fetch for every index in order using n goroutines and
+// returns the first error, after which remaining indices are skipped.
+func runWorkers(order []int, n int, fetch func(i int) error) error {
+	jobs := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		failed   = make(chan struct{})
+	)
+
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := fetch(i); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, i := range order {
+		select {
+		case jobs <- i:
+		case <-failed:
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingPeer serves chunks from memory and records how many times each one
+// was requested. Every get_piece is held briefly so overlapping requests show up.
+type countingPeer struct {
+	mu     sync.Mutex
+	counts map[int]int
+}
+
+func (p *countingPeer) requests(i int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.counts[i]
+}
+
+func startCountingPeer(t *testing.T, fileHash string, chunks [][]byte) (string, *countingPeer) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	cp := &countingPeer{counts: make(map[int]int)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var req PeerRequest
+				if err := common.Recv(c, &req); err != nil {
+					return
+				}
+				switch {
+				case req.FileHash != fileHash:
+					common.Send(c, PeerResponse{Status: "error"})
+				case req.Cmd == "handshake":
+					common.Send(c, PeerResponse{Status: "ok"})
+				case req.Cmd == "get_piece" && req.PieceIdx < len(chunks):
+					cp.mu.Lock()
+					cp.counts[req.PieceIdx]++
+					cp.mu.Unlock()
+					time.Sleep(30 * time.Millisecond)
+					common.Send(c, PeerResponse{Status: "ok", Data: chunks[req.PieceIdx]})
+				default:
+					common.Send(c, PeerResponse{Status: "error"})
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), cp
+}
+
+// memoryChunks moves meta's chunks out of the local store and returns them.
+func memoryChunks(t *testing.T, meta *ChunkMetadata) [][]byte {
+	t.Helper()
+	chunks := make([][]byte, meta.TotalChunks)
+	for i := range chunks {
+		data, err := os.ReadFile(filepath.Join(ChunksDir, meta.FileHash, fmt.Sprintf("chunk_%d.dat", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks[i] = data
+	}
+	if err := os.RemoveAll(ChunksDir); err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+// TestDownloadChunk_FetchedAtMostOnce hands every chunk to several workers at
+// once and checks the peer was asked for each chunk exactly once.
+func TestDownloadChunk_FetchedAtMostOnce(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 4*ChunkSize+100)
+	peer, cp := startCountingPeer(t, meta.FileHash, memoryChunks(t, meta))
+	info := streamTestInfo(meta, peer)
+
+	chunkDir := filepath.Join(ChunksDir, meta.FileHash)
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue each chunk three times so workers race for the same one
+	var order []int
+	for r := 0; r < 3; r++ {
+		for i := 0; i < meta.TotalChunks; i++ {
+			order = append(order, i)
+		}
+	}
+	err := runWorkers(order, 6, func(i int) error {
+		chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))
+		_, err := downloadChunk(chunkCandidates(info, nil, i), info, i, chunkPath, "")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("runWorkers: %v", err)
+	}
+
+	for i := 0; i < meta.TotalChunks; i++ {
+		if n := cp.requests(i); n != 1 {
+			t.Errorf("chunk %d requested %d times, want 1", i, n)
+		}
+		if _, err := os.Stat(filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))); err != nil {
+			t.Errorf("chunk %d not saved: %v", i, err)
+		}
+	}
+}
+
+// TestDownloadFile_Parallel runs a P2P_PARALLEL download end to end.
+func TestDownloadFile_Parallel(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("P2P_PARALLEL", "4")
+	meta, content := chunkTestFile(t, 5*ChunkSize+100)
+	peer, cp := startCountingPeer(t, meta.FileHash, memoryChunks(t, meta))
+
+	d := newFakeDHT()
+	d.files["g1:orig.bin"] = dhtMetadata(meta, "g1")
+	for i := 0; i < meta.TotalChunks; i++ {
+		d.AnnounceChunk(meta.FileHash, i, peer)
+	}
+	useTestNetwork(t, startEmptyTracker(t), d)
+
+	if err := DownloadFile("g1", "orig.bin", "downloaded.bin"); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	got, err := os.ReadFile("downloaded.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("downloaded file differs from original")
+	}
+	for i := 0; i < meta.TotalChunks; i++ {
+		if n := cp.requests(i); n != 1 {
+			t.Errorf("chunk %d requested %d times, want 1", i, n)
+		}
+	}
+}
+
+func TestParallelWorkers(t *testing.T) {
+	for env, want := range map[string]int{"": 1, "0": 1, "x": 1, "1": 1, "8": 8} {
+		t.Setenv("P2P_PARALLEL", env)
+		if got := parallelWorkers(); got != want {
+			t.Errorf("P2P_PARALLEL=%q: got %d, want %d", env, got, want)
+		}
+	}
+}
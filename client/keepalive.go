@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"time"
+)
+
+// The OS default waits two hours before probing an idle connection. We
+// probe after keepAliveInterval (P2P_KEEPALIVE, default 15s) and give up
+// after keepAliveCount unanswered probes, so a dead peer or tracker is
+// noticed in under a minute.
+const (
+	defaultKeepAliveInterval = 15 * time.Second
+	keepAliveCount           = 3
+)
+
+func keepAliveInterval() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("P2P_KEEPALIVE"))
+	if err != nil || d <= 0 {
+		return defaultKeepAliveInterval
+	}
+	return d
+}
+
+// setKeepalive turns on TCP keep-alive probes for conn. Connections that
+// aren't TCP (such as net.Pipe in tests) are left as they are.
+func setKeepalive(conn net.Conn) error {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	interval := keepAliveInterval()
+	return tcp.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     interval,
+		Interval: interval,
+		Count:    keepAliveCount,
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSetKeepalive_Pipe(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if err := setKeepalive(a); err != nil {
+		t.Fatalf("setKeepalive on a pipe: %v", err)
+	}
+}
+
+func TestSetKeepalive_TCP(t *testing.T) {
+	t.Setenv("P2P_KEEPALIVE", "5s")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := setKeepalive(conn); err != nil {
+		t.Errorf("dialled side: %v", err)
+	}
+	if server := <-accepted; server != nil {
+		defer server.Close()
+		if err := setKeepalive(server); err != nil {
+			t.Errorf("accepted side: %v", err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadSliceSize is the most a throttled upload writes at once: chunks
+// go out in slices this size, so the kernel starts sending before the
+// whole chunk has been let through.
+const uploadSliceSize = 64 * 1024
+
+// LeakyBucket holds writes to a byte rate. Each write pours its size into
+// the bucket, which drains at rate; a write waits until it fits. An empty
+// bucket lets one capacity-sized write through at once, so the rate holds
+// on average rather than for every write.
+type LeakyBucket struct {
+	mu       sync.Mutex
+	rate     float64 // bytes drained per second
+	capacity float64
+	level    float64
+	last     time.Time
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+func NewLeakyBucket(bytesPerSec, capacity int) *LeakyBucket {
+	return &LeakyBucket{rate: float64(bytesPerSec), capacity: float64(capacity), now: time.Now, sleep: time.Sleep}
+}
+
+// Wait blocks until n bytes fit in the bucket and pours them in. Writes
+// larger than the capacity wait for an empty bucket. Waiters go one at
+// a time, so connections sharing a bucket share its rate.
+func (b *LeakyBucket) Wait(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	need := math.Min(float64(n), b.capacity)
+	for {
+		now := b.now()
+		if !b.last.IsZero() {
+			b.level = math.Max(0, b.level-now.Sub(b.last).Seconds()*b.rate)
+		}
+		b.last = now
+		over := b.level + need - b.capacity
+		if over <= 0 {
+			break
+		}
+		b.sleep(time.Duration(math.Ceil(over / b.rate * float64(time.Second))))
+	}
+	b.level += float64(n)
+}
+
+var (
+	globalUploadMu     sync.Mutex
+	globalUploadBucket *LeakyBucket
+)
+
+// uploadBucket returns the bucket a new peer connection's uploads go
+// through at bytesPerSec, nil if that is 0. With P2P_UPLOAD_RATE_SCOPE=global
+// every connection shares one bucket, so P2P_UPLOAD_RATE caps the peer's
+// uploads together; otherwise each connection gets its own.
+func uploadBucket(bytesPerSec int) *LeakyBucket {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	capacity := min(bytesPerSec, uploadSliceSize)
+	if !strings.EqualFold(os.Getenv("P2P_UPLOAD_RATE_SCOPE"), "global") {
+		return NewLeakyBucket(bytesPerSec, capacity)
+	}
+	globalUploadMu.Lock()
+	defer globalUploadMu.Unlock()
+	if globalUploadBucket == nil || globalUploadBucket.rate != float64(bytesPerSec) {
+		globalUploadBucket = NewLeakyBucket(bytesPerSec, capacity)
+	}
+	return globalUploadBucket
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock stands in for the time a LeakyBucket sees: it moves when the
+// bucket sleeps or the test idles.
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func fakeClockBucket(bytesPerSec, capacity int) (*LeakyBucket, *fakeClock) {
+	b := NewLeakyBucket(bytesPerSec, capacity)
+	c := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	b.now = func() time.Time { return c.now }
+	b.sleep = func(d time.Duration) {
+		c.now = c.now.Add(d)
+		c.slept += d
+	}
+	return b, c
+}
+
+// TestLeakyBucket_Burst checks an empty bucket lets one capacity through
+// at once, a burst after it is held to the rate, and idle time drains
+// the bucket without saving up more than its capacity.
+func TestLeakyBucket_Burst(t *testing.T) {
+	b, clock := fakeClockBucket(1000, 500)
+
+	b.Wait(500)
+	if d := clock.slept; d != 0 {
+		t.Fatalf("first write into an empty bucket waited %v", d)
+	}
+	for i := 0; i < 10; i++ {
+		b.Wait(500)
+	}
+	if d := clock.slept; d < 4999*time.Millisecond || d > 5001*time.Millisecond {
+		t.Errorf("ten more writes of 500 bytes at 1000 B/s waited %v, want 5s", d)
+	}
+
+	// An hour idle empties the bucket, but only one capacity goes at once
+	clock.now = clock.now.Add(time.Hour)
+	start := clock.slept
+	b.Wait(500)
+	b.Wait(500)
+	if d := clock.slept - start; d < 499*time.Millisecond || d > 501*time.Millisecond {
+		t.Errorf("two writes after idling waited %v, want 500ms", d)
+	}
+}
+
+// TestLeakyBucket_LargeWrite checks a write bigger than the capacity
+// waits for an empty bucket and the next write waits for it to drain.
+func TestLeakyBucket_LargeWrite(t *testing.T) {
+	b, clock := fakeClockBucket(1000, 500)
+	b.Wait(100)
+	b.Wait(2000)
+	if d := clock.slept; d < 99*time.Millisecond || d > 101*time.Millisecond {
+		t.Errorf("large write waited %v, want the 100ms to empty the bucket", d)
+	}
+	b.Wait(1)
+	if d := clock.slept; d < 1600*time.Millisecond || d > 1602*time.Millisecond {
+		t.Errorf("write after a large one waited until %v, want 1.601s", d)
+	}
+}
+
+// TestUploadBucket_Scope checks connections get their own bucket unless
+// P2P_UPLOAD_RATE_SCOPE=global.
+func TestUploadBucket_Scope(t *testing.T) {
+	t.Setenv("P2P_UPLOAD_RATE_SCOPE", "")
+	if uploadBucket(0) != nil {
+		t.Error("bucket for an unlimited rate")
+	}
+	a, b := uploadBucket(1<<20), uploadBucket(1<<20)
+	if a == b {
+		t.Error("connections share a bucket by default")
+	}
+	if a.capacity != uploadSliceSize {
+		t.Errorf("capacity %v, want %d", a.capacity, uploadSliceSize)
+	}
+	if c := uploadBucket(1000); c.capacity != 1000 {
+		t.Errorf("capacity %v at 1000 B/s, want a second's worth", c.capacity)
+	}
+
+	t.Setenv("P2P_UPLOAD_RATE_SCOPE", "global")
+	if a, b := uploadBucket(1<<20), uploadBucket(1<<20); a != b {
+		t.Error("global scope gave connections their own buckets")
+	}
+}
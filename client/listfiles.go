@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// defaultListPageSize is how many files list_files asks the tracker for at a time.
+const defaultListPageSize = 50
+
+// ListFiles fetches groupID's files a page at a time, starting after token
+// ("" for the beginning), and calls show for each. With follow set it
+// fetches pages until the last one; otherwise it stops after one page and
+// returns the token for the next ("" if there is none).
+func ListFiles(groupID string, pageSize int, token string, follow bool, show func(file map[string]interface{})) (string, error) {
+	for {
+		resp := QueryTracker(Message{
+			Cmd:  "list_files",
+			Args: []string{groupID, State.UserID, strconv.Itoa(pageSize), token},
+		})
+		if resp.Status != "ok" {
+			return "", fmt.Errorf("%v", resp.Data)
+		}
+
+		var list []interface{}
+		next := ""
+		switch data := resp.Data.(type) {
+		case map[string]interface{}:
+			list, _ = data["files"].([]interface{})
+			next, _ = data["next_page_token"].(string)
+		case []interface{}:
+			// A tracker without pagination sends every file at once
+			list = data
+		case string:
+			// ...or a message for an empty group
+		default:
+			return "", errors.New("invalid response format")
+		}
+		for _, item := range list {
+			if file, ok := item.(map[string]interface{}); ok {
+				show(file)
+			}
+		}
+		if next == "" || !follow {
+			return next, nil
+		}
+		token = next
+	}
+}
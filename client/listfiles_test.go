@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"p2p/common"
+	"strconv"
+	"testing"
+)
+
+// startPagingTracker answers list_files with pages of names, using the
+// index of the next file as the page token. legacy answers with the whole
+// list in one response, as a tracker without pagination does.
+func startPagingTracker(t *testing.T, names []string, legacy bool) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var msg Message
+				if err := recvRequest(c, &msg); err != nil {
+					return
+				}
+				var files []map[string]interface{}
+				for _, name := range names {
+					files = append(files, map[string]interface{}{"file_name": name})
+				}
+				if legacy {
+					common.Send(c, Response{"ok", files})
+					return
+				}
+				size, _ := strconv.Atoi(msg.Args[2])
+				start, _ := strconv.Atoi(msg.Args[3])
+				end, next := start+size, strconv.Itoa(start+size)
+				if end >= len(files) {
+					end, next = len(files), ""
+				}
+				common.Send(c, Response{"ok", map[string]interface{}{"files": files[start:end], "next_page_token": next}})
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func listNames(t *testing.T, pageSize int, token string, follow bool) ([]string, string) {
+	t.Helper()
+	var got []string
+	next, err := ListFiles("g1", pageSize, token, follow, func(f map[string]interface{}) {
+		got = append(got, f["file_name"].(string))
+	})
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	return got, next
+}
+
+// TestListFiles_FollowsPages checks every page is fetched in turn, and that
+// without follow only one page is shown along with the next token.
+func TestListFiles_FollowsPages(t *testing.T) {
+	useTestCache(t, 0)
+	names := make([]string, 23)
+	for i := range names {
+		names[i] = fmt.Sprintf("f%02d", i)
+	}
+	useTestNetwork(t, startPagingTracker(t, names, false), nil)
+
+	got, next := listNames(t, 5, "", true)
+	if len(got) != 23 || got[0] != "f00" || got[22] != "f22" || next != "" {
+		t.Errorf("followed pages: %v, next %q", got, next)
+	}
+
+	got, next = listNames(t, 5, "10", false)
+	if len(got) != 5 || got[0] != "f10" || next != "15" {
+		t.Errorf("one page: %v, next %q", got, next)
+	}
+}
+
+// TestListFiles_LegacyTracker checks a tracker that ignores the page
+// arguments still has all its files listed.
+func TestListFiles_LegacyTracker(t *testing.T) {
+	useTestCache(t, 0)
+	useTestNetwork(t, startPagingTracker(t, []string{"a", "b", "c"}, true), nil)
+
+	if got, next := listNames(t, 2, "", true); len(got) != 3 || next != "" {
+		t.Errorf("legacy list: %v, next %q", got, next)
+	}
+}
@@ -1,288 +1,1520 @@
 package main
 
 import (
-	"encoding/json"
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"os/signal"
+	"p2p/common"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
 	// Load session at startup to restore login state
 	LoadSession()
 	
+	// Global flags, accepted anywhere on the command line:
+	// --offline contacts no tracker or peer (read first: loading the
+	// tracker configuration probes the trackers),
+	// --no-cache bypasses the tracker response cache,
+	// --skip-speed-test stops downloads from speed testing peers first,
+	// --protocol json|msgpack picks the wire format for trackers that speak it
+	var cliArgs []string
+	cliArgs, offline = stripFlag(os.Args[1:], "--offline")
+
 	// Load tracker configuration
 	LoadTrackerConfig("tracker_info.txt")
 	
-	cmd := os.Args[1]
-	args := os.Args[2:]
+	cliArgs, noCache := stripFlag(cliArgs, "--no-cache")
+	cliArgs, skipSpeedTest = stripFlag(cliArgs, "--skip-speed-test")
+	trackerCache.disabled = noCache
+	cliArgs, protocol, hasProtocol, err := stripValueFlag(cliArgs, "--protocol")
+	if err == nil && hasProtocol {
+		wireFormat, err = common.ParseWireFormat(protocol)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	cmd := cliArgs[0]
+	args := cliArgs[1:]
+
+	switch cmd {
+	case "create_user":
+		resp := SendToTracker(Message{
+			Cmd:  "create_user",
+			Args: args,
+		})
+
+		fmt.Println(resp)
+
+	case "login":
+		// args[0] = username, args[1] = password
+		State.UserID = args[0]
+		
+		resp := SendToTracker(Message{
+			Cmd:  "login",
+			Args: []string{args[0], args[1], ""},  // Address will be set by daemon
+		})
+
+		if resp.Status != "ok" {
+			fmt.Println(resp)
+			return
+		}
+		
+		// Spawn background peer server daemon
+		cmd, err := startPeerDaemon()
+		if err != nil {
+			fmt.Printf("Error starting peer server: %v\n", err)
+			return
+		}
+		
+		// Save session
+		if err := SaveSession(); err != nil {
+			fmt.Printf("Warning: Failed to save session: %v\n", err)
+		}
+		
+		fmt.Println(resp)
+		fmt.Printf("Peer server started in background (PID: %d)\n", cmd.Process.Pid)
+		fmt.Println("You can now run other commands.")
+
+	case "create_group":
+		// args: [groupID] [--quota size] [--invite-code code]
+		//   --invite-code makes the group private: join_group must supply the code
+		args, inviteCode, _, err := stripValueFlag(args, "--invite-code")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(args) < 1 {
+			fmt.Println("Usage: create_group <groupID> [--quota size] [--invite-code code]")
+			return
+		}
+		groupArgs := []string{args[0], State.UserID, ""}
+		if len(args) >= 3 && args[1] == "--quota" {
+			quota, err := parseByteSize(args[2])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			groupArgs[2] = strconv.FormatInt(quota, 10)
+		}
+		if inviteCode != "" {
+			groupArgs = append(groupArgs, inviteCode)
+		}
+
+		resp := SendToTracker(Message{
+			Cmd:  "create_group",
+			Args: groupArgs,
+		})
+		
+		if resp.Status == "ok" {
+			if data, ok := resp.Data.(map[string]interface{}); ok {
+				fmt.Printf("✓ Group '%s' created successfully\n", data["group_id"])
+				fmt.Printf("  Owner: %s\n", data["owner"])
+				if inviteCode != "" {
+					fmt.Println("  Private: members join with the invite code")
+				}
+			} else {
+				fmt.Println(resp)
+			}
+		} else {
+			fmt.Println(resp)
+		}
+
+	case "upload_file":
+		//args: [filePath, groupID]
+		//  --dry-run: print what would be uploaded without saving chunks or registering
+		//  --reservation T: upload into a slot held by reserve_slot
+		args, dryRun := stripFlag(args, "--dry-run")
+		args, reservation, _, err := stripValueFlag(args, "--reservation")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(args) < 2 {
+			fmt.Println("Usage: upload_file <filePath> <groupID> [--dry-run] [--reservation token]")
+			return
+		}
+		filePath := args[0]
+		groupID := args[1]
+
+		if dryRun {
+			manifest, err := PlanUpload(filePath, groupID)
+			if err != nil {
+				fmt.Printf("Error chunking file: %v\n", err)
+				return
+			}
+			printUploadManifest(manifest)
+			return
+		}
+
+		// Chunk the file, save the chunks locally and register with the
+		// tracker, skipping whatever an interrupted upload_file finished
+		fmt.Println("Chunking file...")
+		metadata, resp, registered, err := resumableUpload(filePath, groupID, reservation)
+		if err != nil {
+			fmt.Printf("Error chunking file: %v\n", err)
+			return
+		}
+		if registered {
+			fmt.Printf("✓ %s is already uploaded to %s\n", metadata.FileName, groupID)
+			return
+		}
+		printUploadResult(resp, metadata)
+
+	case "reserve_slot":
+		// args: [filePath, groupID] — holds the file's name and size in the group's quota
+		if len(args) < 2 {
+			fmt.Println("Usage: reserve_slot <filePath> <groupID>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		info, err := os.Stat(args[0])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		resp := SendToTracker(Message{
+			Cmd:  "reserve_slot",
+			Args: []string{args[1], filepath.Base(args[0]), strconv.FormatInt(info.Size(), 10), State.UserID},
+		})
+		data, ok := resp.Data.(map[string]interface{})
+		if resp.Status != "ok" || !ok {
+			fmt.Printf("✗ Reservation failed: %v\n", resp.Data)
+			return
+		}
+		fmt.Printf("✓ Reserved '%s' in group '%s' until %v\n", filepath.Base(args[0]), args[1], data["expires_at"])
+		fmt.Printf("  Upload it with: upload_file --reservation %v %s %s\n", data["reservation_token"], args[0], args[1])
+
+	case "cancel_reservation":
+		// args: [groupID, fileName]
+		if len(args) < 2 {
+			fmt.Println("Usage: cancel_reservation <groupID> <fileName>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		resp := SendToTracker(Message{Cmd: "cancel_reservation", Args: []string{args[0], args[1], State.UserID}})
+		if resp.Status == "ok" {
+			fmt.Printf("✓ Reservation of '%s' in group '%s' cancelled\n", args[1], args[0])
+		} else {
+			fmt.Printf("✗ Cancel failed: %v\n", resp.Data)
+		}
+
+	case "upload_all":
+		// args: [dirPath, groupID] — every file in the directory, registered in one request
+		if len(args) < 2 {
+			fmt.Println("Usage: upload_all <dirPath> <groupID>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+
+		fmt.Println("Chunking files...")
+		metas, resp, err := UploadDir(args[0], args[1])
+		if err != nil {
+			fmt.Printf("✗ Upload failed: %v\n", err)
+			return
+		}
+		if resp.Status != "ok" {
+			fmt.Printf("✗ Nothing uploaded: %v\n", resp.Data)
+			return
+		}
+		fmt.Printf("✓ Uploaded %d files to group '%s'\n", len(metas), args[1])
+		for _, m := range metas {
+			fmt.Printf("  %s (%d bytes, %d chunks)\n", m.FileName, m.FileSize, m.TotalChunks)
+		}
+
+	case "seed_file":
+		// args: [filePath, groupID]  — a complete file obtained outside the network
+		if len(args) < 2 {
+			fmt.Println("Usage: seed_file <filePath> <groupID>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+
+		fmt.Println("Chunking file...")
+		metadata, resp, err := SeedFile(args[0], args[1])
+		if err != nil {
+			fmt.Printf("✗ Seed failed: %v\n", err)
+			return
+		}
+		printUploadResult(resp, metadata)
+		if resp.Status == "ok" {
+			fmt.Printf("  Seeding from %s\n", State.ListenAddr)
+		}
+
+	case "list_files":
+		// args: [groupID]
+		//   --page-size N: files fetched per request (default 50)
+		//   --page-token T: show only the page starting at T
+		// Without --page-token every page is fetched and printed as it arrives
+		args, size, hasSize, err := stripValueFlag(args, "--page-size")
+		pageSize := defaultListPageSize
+		if err == nil && hasSize {
+			pageSize, err = strconv.Atoi(size)
+			if err == nil && pageSize < 1 {
+				err = fmt.Errorf("--page-size must be at least 1")
+			}
+		}
+		var token string
+		var hasToken bool
+		if err == nil {
+			args, token, hasToken, err = stripValueFlag(args, "--page-token")
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(args) < 1 {
+			fmt.Println("Usage: list_files [--page-size N] [--page-token T] <groupID>")
+			return
+		}
+
+		shown := 0
+		next, err := ListFiles(args[0], pageSize, token, !hasToken, func(file map[string]interface{}) {
+			if shown == 0 {
+				fmt.Printf("Files in group '%s':\n", args[0])
+				fmt.Println("──────────────────────────────────────────────────────")
+			} else {
+				fmt.Println()
+			}
+			shown++
+			fmt.Printf("%d. %s\n", shown, file["file_name"])
+			fmt.Printf("   Size: %v bytes\n", file["file_size"])
+			fmt.Printf("   Uploader: %s\n", file["uploader"])
+			if lost, _ := file["unavailable"].(bool); lost {
+				fmt.Println("   ⚠ Unavailable: none of its seeders can be reached")
+			}
+			if ref, _ := file["is_reference"].(bool); ref {
+				fmt.Println("   Shared from another group")
+			}
+		})
+		if shown > 0 {
+			fmt.Println("──────────────────────────────────────────────────────")
+		} else if err == nil {
+			fmt.Printf("No files in group '%s'\n", args[0])
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+		if next != "" {
+			fmt.Printf("More files: list_files --page-size %d --page-token %s %s\n", pageSize, next, args[0])
+		}
+
+	case "download_file":
+		// args: [groupID, fileName, destPath (optional)]
+		//   --min-seeders N: refuse to start unless N seeders are online (default 1)
+		//   --wait-for-seeders D: keep checking for up to D (e.g. 2m) for them to appear
+		//   --chunk-timeout D: keep retrying a chunk no peer has for up to D (default 60s)
+		//   --selector NAME: piece order, sequential|rarest_first|random (default P2P_SELECTOR)
+		//   --simulate: probe the seeders and report what would be fetched, without downloading
+		args, simulate := stripFlag(args, "--simulate")
+		args, minSeeders, hasMin, err := stripValueFlag(args, "--min-seeders")
+		if err == nil && hasMin {
+			downloadConfig.MinSeeders, err = strconv.Atoi(minSeeders)
+			if err == nil && downloadConfig.MinSeeders < 1 {
+				err = fmt.Errorf("--min-seeders must be at least 1")
+			}
+		}
+		var wait string
+		var hasWait bool
+		if err == nil {
+			args, wait, hasWait, err = stripValueFlag(args, "--wait-for-seeders")
+		}
+		if err == nil && hasWait {
+			downloadConfig.WaitForSeeders, err = time.ParseDuration(wait)
+		}
+		var chunkTimeout string
+		var hasChunkTimeout bool
+		if err == nil {
+			args, chunkTimeout, hasChunkTimeout, err = stripValueFlag(args, "--chunk-timeout")
+		}
+		if err == nil && hasChunkTimeout {
+			downloadConfig.ChunkTimeout, err = time.ParseDuration(chunkTimeout)
+		}
+		if err == nil {
+			args, selectorName, _, err = stripValueFlag(args, "--selector")
+		}
+		if err == nil {
+			_, _, err = currentSelector()
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(args) < 2 {
+			fmt.Println("Usage: download_file [--min-seeders N] [--wait-for-seeders duration] [--chunk-timeout duration] [--selector name] [--simulate] <groupID> <fileName> [destPath|-]")
+			return
+		}
+
+		groupID := args[0]
+		fileName := args[1]
+		destPath := fileName
+		if len(args) >= 3 {
+			destPath = args[2]
+		}
+
+		if err := InitDownloadDHT(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to join DHT: %v\n", err)
+		}
+		defer ClosePeerDHT()
+
+		if simulate {
+			report, err := SimulateDownload(groupID, fileName)
+			if err != nil {
+				fmt.Printf("✗ Simulation failed: %v\n", err)
+				return
+			}
+			printSimulationReport(report)
+			return
+		}
+		transfers.StartPublishing()
+
+		// "-" pipes the file to stdout; status messages go to stderr
+		// and we don't become a seeder since nothing is kept on disk
+		if destPath == StdoutPath {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			fmt.Fprintf(os.Stderr, "Streaming '%s' from group '%s' to stdout...\n", fileName, groupID)
+			err := StreamDownloadFile(ctx, groupID, fileName, os.Stdout)
+			stop()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "✗ Download failed: %v\n", err)
+				ClosePeerDHT()
+				os.Exit(1)
+			}
+			return
+		}
+
+		fmt.Printf("Downloading '%s' from group '%s'...\n", fileName, groupID)
+
+		// Downloads the user asks for go ahead of queued download_all work
+		downloadScheduler.StartPublishing()
+		err = <-downloadScheduler.Submit(DownloadJob{GroupID: groupID, FileName: fileName, DestPath: destPath, Priority: PriorityUser})
+		if err != nil {
+			fmt.Printf("✗ Download failed: %v\n", err)
+			return
+		}
+
+		fmt.Printf("✓ Download complete: %s\n", destPath)
+
+	case "download_all":
+		// args: [groupID...] — every file of each group is saved under <groupID>/.
+		// At most P2P_GLOBAL_WORKERS (default 4) downloads run at once across all groups.
+		if len(args) < 1 {
+			fmt.Println("Usage: download_all <groupID> [groupID...]")
+			return
+		}
+		if err := InitDownloadDHT(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to join DHT: %v\n", err)
+		}
+		defer ClosePeerDHT()
+		// Concurrent downloads would all speed test the same peers at once;
+		// they use the results of an earlier speed_test instead
+		skipSpeedTest = true
+		transfers.StartPublishing()
+		downloadScheduler.StartPublishing()
+
+		done, failed := DownloadAll(downloadScheduler, args)
+		fmt.Printf("Downloaded %d files, %d failed\n", done, failed)
+
+	case "scheduler_status":
+		// Queue depth and active downloads of every client process
+		all, err := readSchedulerStatus(time.Now())
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		printSchedulerStatus(os.Stdout, all)
+
+	case "status":
+		if State.UserID == "" {
+			fmt.Println("Status: Not logged in")
+			fmt.Println("Run './client_bin login <username> <password>' to login")
+		} else {
+			fmt.Println("Status: Logged in")
+			fmt.Printf("User: %s\n", State.UserID)
+			if State.ListenAddr != "" {
+				fmt.Printf("Peer server: 127.0.0.1%s\n", State.ListenAddr)
+			} else {
+				fmt.Println("Peer server: Starting...")
+			}
+		}
+
+	case "show_downloads":
+		// Display downloaded files from .chunks directory
+		entries, err := os.ReadDir(ChunksDir)
+		if err != nil || len(entries) == 0 {
+			fmt.Println("No downloaded files found")
+			return
+		}
+
+		fmt.Println("Downloaded files:")
+		fmt.Println("─────────────────────────────────────────────")
+		
+		count := 0
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			
+			// Only the header is needed, not every chunk entry
+			metadata, err := loadChunkMetadataHeader(entry.Name())
+			if err != nil {
+				continue
+			}
+			
+			count++
+			fmt.Printf("%d. %s\n", count, metadata.FileName)
+			fmt.Printf("   Size: %.2f MB\n", float64(metadata.FileSize)/(1024*1024))
+			fmt.Printf("   Hash: %s...\n", metadata.FileHash[:16])
+			fmt.Printf("   Chunks: %d\n", metadata.TotalChunks)
+			if count < len(entries)-1 {
+				fmt.Println()
+			}
+		}
+		fmt.Println("─────────────────────────────────────────────")
+
+	case "local_assemble":
+		// args: [fileHash, destPath] — from the chunk store alone, as offline
+		if len(args) < 2 {
+			fmt.Println("Usage: local_assemble <fileHash> <destPath>")
+			return
+		}
+		meta, err := localAssemble(args[0], args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Assembled %s (%d chunks) to %s\n", meta.FileName, meta.TotalChunks, args[1])
+
+	case "show_chunk_stats":
+		stats, err := GetChunkStats()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Println("Chunk store:")
+		fmt.Println("─────────────────────────────────────────────")
+		fmt.Printf("Files:          %d\n", stats.Files)
+		fmt.Printf("Chunks:         %d\n", stats.Chunks)
+		fmt.Printf("Logical size:   %s\n", formatByteSize(stats.LogicalBytes))
+		fmt.Printf("On disk:        %s\n", formatByteSize(stats.PhysicalBytes))
+		if saved := stats.LogicalBytes - stats.PhysicalBytes; saved > 0 {
+			fmt.Printf("Saved by dedup: %s\n", formatByteSize(saved))
+		}
+		fmt.Println("─────────────────────────────────────────────")
+
+	case "pin_file", "unpin_file":
+		// args: [fileHash]
+		if len(args) < 1 {
+			fmt.Printf("Usage: %s <fileHash>\n", cmd)
+			return
+		}
+		if cmd == "pin_file" {
+			metadata, err := PinFile(args[0])
+			if err != nil {
+				fmt.Printf("✗ Pin failed: %v\n", err)
+				return
+			}
+			fmt.Printf("✓ Pinned '%s'; it will not be evicted\n", metadata.FileName)
+		} else {
+			if err := UnpinFile(args[0]); err != nil {
+				fmt.Printf("✗ Unpin failed: %v\n", err)
+				return
+			}
+			fmt.Printf("✓ Unpinned %s\n", args[0])
+		}
+
+	case "list_pinned":
+		pinned, err := ListPinned()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(pinned) == 0 {
+			fmt.Println("No pinned files")
+			return
+		}
+		fmt.Println("Pinned files:")
+		fmt.Println("─────────────────────────────────────────────")
+		for _, m := range pinned {
+			fmt.Printf("%s  %s (%s)\n", m.FileHash[:16], m.FileName, formatByteSize(m.FileSize))
+		}
+		fmt.Println("─────────────────────────────────────────────")
+
+	case "evict_chunks":
+		// args: [maxSize]  — e.g. 2GB; least recently used unpinned files go first
+		if len(args) < 1 {
+			fmt.Println("Usage: evict_chunks <maxSize>")
+			return
+		}
+		limit, err := parseByteSize(args[0])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		evicted, err := EvictChunks(limit)
+		for _, hash := range evicted {
+			fmt.Printf("Evicted %s\n", hash)
+		}
+		if err != nil {
+			fmt.Printf("✗ Eviction failed: %v\n", err)
+			return
+		}
+		if stats, err := GetChunkStats(); err == nil {
+			fmt.Printf("✓ Chunk store now uses %s (limit %s)\n", formatByteSize(stats.PhysicalBytes), formatByteSize(limit))
+		}
+
+	case "export_chunks":
+		// args: [fileHash, destDir]
+		if len(args) < 2 {
+			fmt.Println("Usage: export_chunks <fileHash> <destDir>")
+			return
+		}
+
+		metadata, err := ExportChunks(args[0], args[1])
+		if err != nil {
+			fmt.Printf("✗ Export failed: %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Exported %d chunks of '%s' to %s\n", metadata.TotalChunks, metadata.FileName, args[1])
+
+	case "import_chunks":
+		// args: [srcDir, groupID]
+		if len(args) < 2 {
+			fmt.Println("Usage: import_chunks <srcDir> <groupID>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+
+		metadata, err := ImportChunks(args[0])
+		if err != nil {
+			fmt.Printf("✗ Import failed: %v\n", err)
+			return
+		}
+		resp := registerUpload(metadata, args[1])
+		printUploadResult(resp, metadata)
+
+	case "compact_chunks":
+		// args: [fileHash, newChunkSize, groupID...]
+		if len(args) < 3 {
+			fmt.Println("Usage: compact_chunks <fileHash> <newChunkSize> <groupID> [groupID...]")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		chunkSize, err := parseByteSize(args[1])
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			return
+		}
+
+		metadata, resp, err := CompactChunks(args[0], chunkSize, args[2:])
+		if err != nil {
+			fmt.Printf("✗ Compaction failed: %v\n", err)
+			return
+		}
+		if resp.Status != "ok" {
+			fmt.Printf("✗ Tracker refused the new chunks, old ones kept: %v\n", resp.Data)
+			return
+		}
+		fmt.Printf("✓ Re-chunked '%s' into %d chunks of %s\n", metadata.FileName, metadata.TotalChunks, formatByteSize(chunkSize))
+		if data, ok := resp.Data.(map[string]interface{}); ok {
+			if dropped, _ := data["seeders_dropped"].([]interface{}); len(dropped) > 0 {
+				fmt.Printf("  You are its only seeder now; no longer listed: %v\n", dropped)
+			}
+		}
+
+	case "export_descriptor":
+		// args: [groupID, fileName, outPath (optional, default <fileName>.p2pdesc)]
+		if len(args) < 2 {
+			fmt.Println("Usage: export_descriptor <groupID> <fileName> [outPath]")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		outPath := ""
+		if len(args) >= 3 {
+			outPath = args[2]
+		}
+
+		desc, path, err := ExportDescriptor(args[0], args[1], outPath)
+		if err != nil {
+			fmt.Printf("✗ Export failed: %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Wrote descriptor for '%s' to %s\n", desc.FileName, path)
+		fmt.Printf("  %d chunks, %d peers\n", desc.TotalChunks, len(desc.Peers))
+
+	case "import_descriptor":
+		// args: [descriptorPath, destPath (optional)]  — downloads without the tracker
+		if len(args) < 1 {
+			fmt.Println("Usage: import_descriptor <file.p2pdesc> [destPath]")
+			return
+		}
+		desc, err := ReadDescriptor(args[0])
+		if err != nil {
+			fmt.Printf("✗ Import failed: %v\n", err)
+			return
+		}
+		destPath := desc.FileName
+		if len(args) >= 2 {
+			destPath = args[1]
+		}
+
+		fmt.Printf("Downloading '%s' from %d peers in the descriptor...\n", desc.FileName, len(desc.Peers))
+		transfers.StartPublishing()
+		if err := DownloadFromDescriptor(desc, destPath); err != nil {
+			fmt.Printf("✗ Download failed: %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Download complete: %s\n", destPath)
+
+	case "list_groups":
+		// Groups are printed as the tracker streams them
+		//   --public: leave out private (invite-only) groups
+		listArgs := []string{}
+		if _, public := stripFlag(args, "--public"); public {
+			listArgs = append(listArgs, "public")
+		}
+		shown := 0
+		err := StreamFromTracker(Message{
+			Cmd:  "list_groups",
+			Args: listArgs,
+		}, func(resp Response) {
+			if resp.Status != "ok" {
+				fmt.Println(resp)
+				return
+			}
+			if shown == 0 {
+				fmt.Println("Groups in network:")
+				fmt.Println("─────────────────────────────────────")
+			}
+			shown++
+			fmt.Printf("%d. %s\n", shown, resp.Data)
+		})
+		if shown > 0 {
+			fmt.Println("─────────────────────────────────────")
+		} else if err == nil {
+			fmt.Println("no groups found")
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+
+	case "leaderboard":
+		// args: [topN (optional)]
+		resp := SendToTracker(Message{
+			Cmd:  "get_popular_files",
+			Args: args,
+		})
+
+		if resp.Status != "ok" {
+			fmt.Println(resp)
+			return
+		}
+		fileList, ok := resp.Data.([]interface{})
+		if !ok {
+			fmt.Println(resp)
+			return
+		}
+		if len(fileList) == 0 {
+			fmt.Println("No files shared yet")
+			return
+		}
+
+		fmt.Println("Most downloaded files:")
+		fmt.Println("──────────────────────────────────────────────────────")
+		for i, item := range fileList {
+			if file, ok := item.(map[string]interface{}); ok {
+				fmt.Printf("%d. %s (group '%s')\n", i+1, file["file_name"], file["group_id"])
+				fmt.Printf("   Size: %v bytes\n", file["file_size"])
+				fmt.Printf("   Downloads: %v\n", file["download_count"])
+			}
+		}
+		fmt.Println("──────────────────────────────────────────────────────")
+
+	case "check_update":
+		// Compares this build's version with the release the tracker announces
+		info, err := CheckUpdate()
+		if err != nil {
+			fmt.Printf("✗ Update check failed: %v\n", err)
+			return
+		}
+		fmt.Printf("Client version %s: %s\n", clientVersion, info.Message)
+		if info.Available && info.GroupID != "" {
+			fmt.Println("Run auto_update to install it")
+		}
+
+	case "auto_update":
+		// Downloads the announced release from its group and replaces this binary
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		if err := InitDownloadDHT(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to join DHT: %v\n", err)
+		}
+		defer ClosePeerDHT()
+		info, err := AutoUpdate()
+		if err != nil {
+			fmt.Printf("✗ Update failed: %v\n", err)
+			return
+		}
+		if info == nil {
+			fmt.Printf("✓ Client version %s is up to date\n", clientVersion)
+			return
+		}
+		fmt.Printf("✓ Updated to %s; restart the client to use it\n", info.Latest)
+
+	case "network_stats":
+		// Counts across every tracker, each user, group and file once
+		resp := SendToTracker(Message{Cmd: "global_stats"})
+		stats, ok := resp.Data.(map[string]interface{})
+		if resp.Status != "ok" || !ok {
+			fmt.Println(resp)
+			return
+		}
+		fmt.Println("Network statistics:")
+		fmt.Println("─────────────────────────────────────")
+		fmt.Printf("Users:        %v\n", stats["users"])
+		fmt.Printf("Groups:       %v\n", stats["groups"])
+		fmt.Printf("Files:        %v\n", stats["files"])
+		fmt.Printf("Total size:   %.0f bytes\n", stats["total_bytes"])
+		fmt.Printf("Trackers:     %v reached\n", stats["trackers_reached"])
+		if dead, _ := stats["trackers_unreachable"].([]interface{}); len(dead) > 0 {
+			fmt.Printf("Unreachable:  %v\n", dead)
+		}
+		fmt.Println("─────────────────────────────────────")
+
+	case "http_token":
+		// args: [groupID] — token group members pass to this peer's HTTP API
+		if len(args) < 1 {
+			fmt.Println("Usage: http_token <groupID>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		member, err := groupMember(args[0])
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			return
+		}
+		if !member {
+			fmt.Println("✗ You are not a member of this group")
+			return
+		}
+		secret, err := httpSecret()
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			return
+		}
+		fmt.Printf("✓ HTTP token for '%s': %s\n", args[0], groupToken(secret, args[0]))
+		fmt.Println("  Share it with group members; it only works on this peer")
+
+	case "p2p_fuse":
+		// args: [mountpoint] — read-only view of your groups and their files
+		//   --cache-dir D: keep fetched chunks in D for later reads
+		args, cacheDir, _, err := stripValueFlag(args, "--cache-dir")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if len(args) < 1 {
+			fmt.Println("Usage: p2p_fuse [--cache-dir D] <mountpoint>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		if err := MountP2PFS(args[0], cacheDir); err != nil {
+			fmt.Printf("✗ Mount failed: %v\n", err)
+			return
+		}
+		fmt.Println("✓ Unmounted")
+
+	case "download_log":
+		// args: [groupID, fileName] or [groupID, fileName, --since, time|duration]  — owner only
+		if len(args) < 2 {
+			fmt.Println("Usage: download_log <groupID> <fileName> [--since <RFC3339 time|duration>]")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		logArgs := []string{args[0], args[1], State.UserID}
+		if len(args) >= 4 && args[2] == "--since" {
+			since, err := parseSince(args[3], time.Now())
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			logArgs = append(logArgs, since.UTC().Format(time.RFC3339))
+		}
+
+		resp := SendToTracker(Message{
+			Cmd:  "get_download_log",
+			Args: logArgs,
+		})
+		if resp.Status != "ok" {
+			fmt.Println(resp)
+			return
+		}
+		events, ok := resp.Data.([]interface{})
+		if !ok {
+			fmt.Println(resp)
+			return
+		}
+		if len(events) == 0 {
+			fmt.Println("No downloads recorded")
+			return
+		}
+
+		fmt.Printf("Downloads of '%s' in group '%s':\n", args[1], args[0])
+		fmt.Println("──────────────────────────────────────────────────────")
+		for _, item := range events {
+			if e, ok := item.(map[string]interface{}); ok {
+				fmt.Printf("%v  %v  %v\n", e["timestamp"], e["user_id"], e["peer_addr"])
+			}
+		}
+		fmt.Println("──────────────────────────────────────────────────────")
+
+	case "speed_test":
+		// args: [groupID, fileName]  — times chunk 0 from every seeder
+		if len(args) < 2 {
+			fmt.Println("Usage: speed_test <groupID> <fileName>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		fileInfo, err := queryFileInfo(args[0], args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		addGossipPeers(fileInfo)
+		if len(fileInfo.Peers) == 0 {
+			fmt.Println("No seeders available")
+			return
+		}
+
+		fmt.Printf("Speed testing %d seeders of '%s'...\n", len(fileInfo.Peers), args[1])
+		results, failed := SpeedTest(fileInfo, fileInfo.Peers)
+		if err := SaveSession(); err != nil {
+			fmt.Printf("Warning: Failed to save results: %v\n", err)
+		}
+		printSpeedResults(results, failed)
+
+	case "benchmark":
+		// args: [trackerAddr]  — every known tracker without one
+		addrs := State.TrackerAddrs
+		if len(args) > 0 {
+			addrs = args[:1]
+		}
+		fmt.Printf("Benchmarking %d tracker(s) with a %s payload...\n", len(addrs), formatByteSize(benchmarkPayload))
+		results, failed := MeasureTrackers(addrs)
+		printTrackerSpeeds(results, failed)
+
+	case "seeder_health":
+		// args: [groupID, fileName]
+		if len(args) < 2 {
+			fmt.Println("Usage: seeder_health <groupID> <fileName>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+
+		resp := SendToTracker(Message{
+			Cmd:  "get_seeder_health",
+			Args: []string{args[0], args[1], State.UserID},
+		})
+		if resp.Status != "ok" {
+			fmt.Println(resp)
+			return
+		}
+		seeders, ok := resp.Data.([]interface{})
+		if !ok {
+			fmt.Println(resp)
+			return
+		}
+		if len(seeders) == 0 {
+			fmt.Printf("No seeders for '%s'\n", args[1])
+			return
+		}
+
+		reachable := 0
+		fmt.Printf("Seeders of '%s' in group '%s':\n", args[1], args[0])
+		fmt.Println("──────────────────────────────────────────────────────")
+		fmt.Printf("%-16s %-22s %-12s %s\n", "USER", "ADDRESS", "STATUS", "LATENCY")
+		for _, item := range seeders {
+			s, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			addr, _ := s["peer_addr"].(string)
+			if addr == "" {
+				addr = "(logged out)"
+			}
+			status, latency := "✗ down", "-"
+			if up, _ := s["reachable"].(bool); up {
+				reachable++
+				status = "✓ up"
+				latency = fmt.Sprintf("%vms", s["latency_ms"])
+			}
+			fmt.Printf("%-16v %-22s %-12s %s\n", s["user_id"], addr, status, latency)
+		}
+		fmt.Println("──────────────────────────────────────────────────────")
+		fmt.Printf("%d of %d seeders reachable\n", reachable, len(seeders))
+
+	case "find_similar":
+		// args: [groupID, fileName]
+		if len(args) < 2 {
+			fmt.Println("Usage: find_similar <groupID> <fileName>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+
+		resp := SendToTracker(Message{
+			Cmd:  "similar_files",
+			Args: []string{args[0], args[1], "", State.UserID},
+		})
+		if resp.Status != "ok" {
+			fmt.Println(resp)
+			return
+		}
+		similar, ok := resp.Data.([]interface{})
+		if !ok {
+			fmt.Println(resp)
+			return
+		}
+		if len(similar) == 0 {
+			fmt.Printf("No file in '%s' shares a chunk with '%s'\n", args[0], args[1])
+			return
+		}
+
+		fmt.Printf("Files in '%s' sharing chunks with '%s':\n", args[0], args[1])
+		fmt.Println("──────────────────────────────────────────────────────")
+		fmt.Printf("%-32s %8s %s\n", "FILE", "SCORE", "SHARED CHUNKS")
+		for _, item := range similar {
+			f, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			score, _ := f["score"].(float64)
+			fmt.Printf("%-32v %8.3f %v\n", f["file_name"], score, f["shared_chunks"])
+		}
+
+	case "cross_verify":
+		// args: [groupID, fileName, chunkIdx]
+		if len(args) < 3 {
+			fmt.Println("Usage: cross_verify <groupID> <fileName> <chunkIdx>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		idx, err := strconv.Atoi(args[2])
+		if err != nil {
+			fmt.Println("Error: chunkIdx must be a number")
+			return
+		}
+
+		res, err := CrossVerify(args[0], args[1], idx)
+		for _, p := range res.Failed {
+			fmt.Printf("  %-22s could not be asked\n", p)
+		}
+		if err != nil {
+			for p, h := range res.Hashes {
+				fmt.Printf("  %-22s %s\n", p, h)
+			}
+			fmt.Printf("✗ %v\n", err)
+			return
+		}
+		if len(res.Divergent) == 0 {
+			fmt.Printf("✓ %d seeders agree on chunk %d: %s\n", len(res.Hashes), idx, res.Consensus)
+			return
+		}
+		fmt.Printf("%d of %d seeders agree on chunk %d: %s\n", len(res.Hashes)-len(res.Divergent), len(res.Hashes), idx, res.Consensus)
+		for _, p := range res.Divergent {
+			reported := "report refused"
+			if slices.Contains(res.Reported, p) {
+				reported = "reported to the tracker"
+			}
+			fmt.Printf("  ✗ %-22s %s (%s)\n", p, res.Hashes[p], reported)
+		}
+
+	case "file_diff":
+		// args: [groupID, since]  — since is an RFC3339 time or a duration like 1h
+		if len(args) < 2 {
+			fmt.Println("Usage: file_diff <groupID> <RFC3339 time|duration>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		since, err := parseSince(args[1], time.Now())
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		resp := SendToTracker(Message{
+			Cmd:  "get_file_diff",
+			Args: []string{args[0], State.UserID, since.UTC().Format(time.RFC3339)},
+		})
+		if resp.Status != "ok" {
+			fmt.Println(resp)
+			return
+		}
+		changes, ok := resp.Data.([]interface{})
+		if !ok {
+			fmt.Println(resp)
+			return
+		}
+		if len(changes) == 0 {
+			fmt.Printf("No changes in group '%s' since %s\n", args[0], since.Format(time.RFC3339))
+			return
+		}
+
+		fmt.Printf("Changes in group '%s' since %s:\n", args[0], since.Format(time.RFC3339))
+		fmt.Println("──────────────────────────────────────────────────────")
+		for _, item := range changes {
+			if c, ok := item.(map[string]interface{}); ok {
+				fmt.Printf("%-9v %v  %v\n", c["action"], c["updated_at"], c["file_name"])
+			}
+		}
+		fmt.Println("──────────────────────────────────────────────────────")
+
+	case "audit_log":
+		// args: [N (optional)]  — admin only: the tracker answers localhost connections
+		resp := SendToTracker(Message{
+			Cmd:  "get_audit_log",
+			Args: args,
+		})
+		if resp.Status != "ok" {
+			fmt.Println(resp)
+			return
+		}
+		entries, ok := resp.Data.([]interface{})
+		if !ok {
+			fmt.Println(resp)
+			return
+		}
+		if len(entries) == 0 {
+			fmt.Println("Audit log is empty")
+			return
+		}
+		for _, item := range entries {
+			if e, ok := item.(map[string]interface{}); ok {
+				fmt.Printf("%v  %-15v %-6v %v  (tracker %v)\n", e["timestamp"], e["cmd"], e["status"], e["args"], e["tracker"])
+			}
+		}
+
+	case "set_group_quota":
+		// args: [groupID, size]  — only group owner can set; 0 = unlimited
+		if len(args) < 2 {
+			fmt.Println("Usage: set_group_quota <groupID> <size>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		quota, err := parseByteSize(args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		resp := SendToTracker(Message{
+			Cmd:  "set_group_quota",
+			Args: []string{args[0], State.UserID, strconv.FormatInt(quota, 10)},
+		})
+		if resp.Status == "ok" {
+			if quota == 0 {
+				fmt.Printf("✓ Removed storage quota for group '%s'\n", args[0])
+			} else {
+				fmt.Printf("✓ Storage quota for group '%s' set to %s\n", args[0], formatByteSize(quota))
+			}
+		} else {
+			fmt.Println(resp)
+		}
+
+	case "add_moderator", "remove_moderator":
+		// args: [groupID, userID]  — only group owner; moderators can accept join requests
+		if len(args) < 2 {
+			fmt.Printf("Usage: %s <groupID> <userID>\n", cmd)
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		resp := SendToTracker(Message{
+			Cmd:  cmd,
+			Args: []string{args[0], State.UserID, args[1]},
+		})
+		if resp.Status != "ok" {
+			fmt.Println(resp)
+		} else if cmd == "add_moderator" {
+			fmt.Printf("✓ %s can now accept join requests for '%s'\n", args[1], args[0])
+		} else {
+			fmt.Printf("✓ %s is no longer a moderator of '%s'\n", args[1], args[0])
+		}
+
+	case "list_moderators":
+		// args: [groupID]
+		if len(args) < 1 {
+			fmt.Println("Usage: list_moderators <groupID>")
+			return
+		}
+		resp := SendToTracker(Message{
+			Cmd:  "list_moderators",
+			Args: []string{args[0]},
+		})
+		mods, ok := resp.Data.([]interface{})
+		if resp.Status != "ok" || !ok {
+			fmt.Println(resp)
+			return
+		}
+		fmt.Printf("Moderators of '%s':\n", args[0])
+		for _, m := range mods {
+			fmt.Printf("  %s\n", m)
+		}
+
+	case "rename_group":
+		// args: [groupID, newGroupID]  — only group owner can rename
+		if len(args) < 2 {
+			fmt.Println("Usage: rename_group <groupID> <newGroupID>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		resp := SendToTracker(Message{
+			Cmd:  "rename_group",
+			Args: []string{args[0], args[1], State.UserID},
+		})
+		if resp.Status == "ok" {
+			fmt.Printf("✓ Renamed group '%s' to '%s'\n", args[0], args[1])
+		} else {
+			fmt.Println(resp)
+		}
+
+	case "delete_group":
+		// args: [groupID] [--yes]  — only group owner can delete; asks first unless --yes
+		args, yes := stripFlag(args, "--yes")
+		if len(args) < 1 {
+			fmt.Println("Usage: delete_group <groupID> [--yes]")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		if !yes && !confirm(os.Stdin, fmt.Sprintf("Delete group '%s' and every file shared in it?", args[0])) {
+			fmt.Println("Cancelled")
+			return
+		}
+		resp := SendToTracker(Message{
+			Cmd:  "delete_group",
+			Args: []string{args[0], State.UserID},
+		})
+		trackerCache.InvalidateGroup(args[0])
+		data, ok := resp.Data.(map[string]interface{})
+		if resp.Status != "ok" || !ok {
+			fmt.Println(resp)
+			return
+		}
+		fmt.Printf("✓ Deleted group '%s' and %v files\n", args[0], data["files_deleted"])
+		// Files no other group lists aren't served any more; their chunks stay on disk
+		var unused []string
+		list, _ := data["unused_hashes"].([]interface{})
+		for _, h := range list {
+			if hash, ok := h.(string); ok {
+				unused = append(unused, hash)
+			}
+		}
+		if stopped := stopSharingLocally(unused); len(stopped) > 0 {
+			fmt.Printf("Stopped sharing %d files (chunks are kept in .chunks/)\n", len(stopped))
+		}
+
+	case "group_info":
+		// args: [groupID]
+		if len(args) < 1 {
+			fmt.Println("Usage: group_info <groupID>")
+			return
+		}
+		resp := SendToTracker(Message{
+			Cmd:  "get_group_info",
+			Args: []string{args[0]},
+		})
+		data, ok := resp.Data.(map[string]interface{})
+		if resp.Status != "ok" || !ok {
+			fmt.Println(resp)
+			return
+		}
+		used, _ := data["storage_used"].(float64)
+		quota, _ := data["storage_quota"].(float64)
+		fmt.Printf("Group: %s\n", data["group_id"])
+		fmt.Printf("  Owner: %s\n", data["owner"])
+		if private, _ := data["private"].(bool); private {
+			fmt.Println("  Private: joining needs an invite code")
+		}
+		if members, ok := data["members"].([]interface{}); ok {
+			fmt.Printf("  Members: %d\n", len(members))
+		}
+		if quota > 0 {
+			fmt.Printf("  Storage: %s of %s\n", formatByteSize(int64(used)), formatByteSize(int64(quota)))
+		} else {
+			fmt.Printf("  Storage: %s (no quota)\n", formatByteSize(int64(used)))
+		}
+
+	case "stop_sharing":
+		// args: [groupID, fileName]
+		if len(args) < 2 {
+			fmt.Println("Usage: stop_sharing <groupID> <fileName>")
+			return
+		}
+
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+
+		groupID := args[0]
+		fileName := args[1]
 
-	switch cmd {
-	case "create_user":
 		resp := SendToTracker(Message{
-			Cmd:  "create_user",
-			Args: args,
+			Cmd:  "stop_sharing",
+			Args: []string{groupID, fileName, State.UserID},
 		})
+		trackerCache.InvalidateGroup(groupID)
 
-		fmt.Println(resp)
+		if resp.Status == "ok" {
+			fmt.Printf("✓ Stopped sharing '%s' in group '%s'\n", fileName, groupID)
+			fmt.Println("Note: Local chunks are preserved (delete .chunks/<hash>/ manually if needed)")
+		} else {
+			fmt.Println(resp)
+		}
 
-	case "login":
-		// args[0] = username, args[1] = password
-		State.UserID = args[0]
-		
+	case "bulk_add_members":
+		// args: [groupID, userIDs...]  — owner only; all are added or none
+		if len(args) < 2 {
+			fmt.Println("Usage: bulk_add_members <groupID> <userID>...")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
 		resp := SendToTracker(Message{
-			Cmd:  "login",
-			Args: []string{args[0], args[1], ""},  // Address will be set by daemon
+			Cmd:  "bulk_add_members",
+			Args: append([]string{args[0], State.UserID}, args[1:]...),
 		})
-
-		if resp.Status != "ok" {
-			fmt.Println(resp)
+		summary, ok := resp.Data.(map[string]interface{})
+		if !ok {
+			fmt.Printf("✗ Bulk add failed: %v\n", resp.Data)
 			return
 		}
-		
-		// Spawn background peer server daemon
-		cmd := exec.Command(os.Args[0], "peer_daemon")
-		cmd.Stdout = nil
-		cmd.Stderr = nil
-		
-		if err := cmd.Start(); err != nil {
-			fmt.Printf("Error starting peer server: %v\n", err)
+		if resp.Status != "ok" {
+			fmt.Printf("✗ No one was added to '%s'; not registered: %v\n", args[0], summary["not_found"])
 			return
 		}
-		
-		// Save session
-		if err := SaveSession(); err != nil {
-			fmt.Printf("Warning: Failed to save session: %v\n", err)
+		fmt.Printf("✓ Added to '%s': %v\n", args[0], summary["added"])
+		if already, _ := summary["already_members"].([]interface{}); len(already) > 0 {
+			fmt.Printf("  Already members: %v\n", already)
 		}
-		
-		fmt.Println(resp)
-		fmt.Printf("Peer server started in background (PID: %d)\n", cmd.Process.Pid)
-		fmt.Println("You can now run other commands.")
 
-	case "create_group":
+	case "set_mirrors":
+		// args: [groupID, mirrorGroupIDs...]  — owner only; no mirrors clears the list
+		if len(args) < 1 {
+			fmt.Println("Usage: set_mirrors <groupID> [mirrorGroupID...]")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
 		resp := SendToTracker(Message{
-			Cmd:  "create_group",
-			Args: []string{args[0], State.UserID},
+			Cmd:  "set_mirrors",
+			Args: append([]string{args[0], State.UserID}, args[1:]...),
 		})
-		
-		if resp.Status == "ok" {
-			if data, ok := resp.Data.(map[string]interface{}); ok {
-				fmt.Printf("✓ Group '%s' created successfully\n", data["group_id"])
-				fmt.Printf("  Owner: %s\n", data["owner"])
-			} else {
-				fmt.Println(resp)
-			}
-		} else {
+		if resp.Status != "ok" {
 			fmt.Println(resp)
+		} else if len(args) == 1 {
+			fmt.Printf("✓ Uploads to '%s' are no longer mirrored\n", args[0])
+		} else {
+			fmt.Printf("✓ Uploads to '%s' will be mirrored to: %s\n", args[0], strings.Join(args[1:], ", "))
 		}
 
-	case "upload_file":
-		//args: [filePath, groupID]
-		filePath := args[0]
-		groupID := args[1]
-
-		// 1. Chunk the file
-		fmt.Println("Chunking file...")
-		metadata, err := ChunkFile(filePath)
-		if err != nil {
-			fmt.Printf("Error chunking file: %v\n", err)
+	case "export_group":
+		// args: [groupID, path?]  — owner only
+		if len(args) < 1 {
+			fmt.Println("Usage: export_group <groupID> [file]")
 			return
 		}
-
-		// 2. Save chunks locally
-		fmt.Println("Saving chunks...")
-		err = SaveChunks(filePath, metadata)
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		path := ""
+		if len(args) > 1 {
+			path = args[1]
+		}
+		path, err := ExportGroup(args[0], path)
 		if err != nil {
-			fmt.Printf("Error saving chunks: %v\n", err)
+			fmt.Printf("✗ Export failed: %v\n", err)
 			return
 		}
+		fmt.Printf("✓ Exported group '%s' to %s\n", args[0], path)
 
-		// 3. Convert chunks to JSON
-		chunksJSON, err := json.Marshal(metadata.Chunks)
+	case "import_group":
+		// args: [path]  — the group is created, owned by you, if it doesn't exist
+		if len(args) < 1 {
+			fmt.Println("Usage: import_group <file>")
+			return
+		}
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
+		}
+		resp, err := ImportGroup(args[0])
 		if err != nil {
-			fmt.Printf("Error marshaling chunks: %v\n", err)
+			fmt.Printf("✗ Import failed: %v\n", err)
+			return
+		}
+		result, ok := resp.Data.(map[string]interface{})
+		if resp.Status != "ok" || !ok {
+			fmt.Printf("✗ Import failed: %v\n", resp.Data)
 			return
 		}
+		fmt.Printf("✓ Imported group '%v'\n", result["group_id"])
+		for _, k := range []string{"members_added", "members_skipped", "files_added", "files_merged", "files_skipped"} {
+			if list, _ := result[k].([]interface{}); len(list) > 0 {
+				fmt.Printf("  %s: %v\n", strings.ReplaceAll(k, "_", " "), list)
+			}
+		}
 
-		// 4. Send to tracker
-		resp := SendToTracker(Message{
-			Cmd: "upload_file",
-			Args: []string{
-				metadata.FileName,
-				groupID,
-				State.UserID,
-				fmt.Sprintf("%d", metadata.FileSize),
-				metadata.FileHash,
-				string(chunksJSON),
-			},
-		})
+	case "share_file":
+		// args: [srcGroupID, fileName, destGroupID]
+		if len(args) < 3 {
+			fmt.Println("Usage: share_file <srcGroupID> <fileName> <destGroupID>")
+			return
+		}
 
-		if resp.Status == "ok" {
-			if data, ok := resp.Data.(map[string]interface{}); ok {
-				fmt.Printf("✓ File chunked and uploaded successfully\n")
-				fmt.Printf("  File: %s\n", data["file_name"])
-				fmt.Printf("  Group: %s\n", data["group_id"])
-				fmt.Printf("  Size: %v bytes\n", data["file_size"])
-				if fileHash, ok := data["file_hash"].(string); ok {
-					fmt.Printf("  Hash: %s...\n", fileHash[:16])
-				}
-				if totalChunks, ok := data["total_chunks"].(float64); ok {
-					fmt.Printf("  Chunks: %.0f\n", totalChunks)
-				}
-				fmt.Printf("  Chunks stored in: .chunks/%s/\n", metadata.FileHash)
-			} else {
-				fmt.Println(resp)
-			}
-		} else {
-			fmt.Println(resp)
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
 		}
 
-	case "list_files":
+		srcGroupID, fileName, destGroupID := args[0], args[1], args[2]
 		resp := SendToTracker(Message{
-			Cmd:  "list_files",
-			Args: []string{args[0], State.UserID},
+			Cmd:  "share_file",
+			Args: []string{srcGroupID, fileName, destGroupID, State.UserID},
 		})
-		
+		trackerCache.InvalidateGroup(destGroupID)
+
 		if resp.Status == "ok" {
-			if fileList, ok := resp.Data.([]interface{}); ok {
-				if len(fileList) == 0 {
-					fmt.Printf("No files in group '%s'\n", args[0])
-				} else {
-					fmt.Printf("Files in group '%s':\n", args[0])
-					fmt.Println("──────────────────────────────────────────────────────")
-					for i, item := range fileList {
-						if file, ok := item.(map[string]interface{}); ok {
-							fmt.Printf("%d. %s\n", i+1, file["file_name"])
-							fmt.Printf("   Size: %v bytes\n", file["file_size"])
-							fmt.Printf("   Uploader: %s\n", file["uploader"])
-							if i < len(fileList)-1 {
-								fmt.Println()
-							}
-						}
-					}
-					fmt.Println("──────────────────────────────────────────────────────")
-				}
-			} else {
-				fmt.Println(resp)
-			}
+			fmt.Printf("✓ '%s' from group '%s' is now listed in group '%s'\n", fileName, srcGroupID, destGroupID)
 		} else {
-			fmt.Println(resp)
+			fmt.Printf("✗ Share failed: %v\n", resp.Data)
 		}
 
-	case "download_file":
-		// args: [groupID, fileName, destPath (optional)]
-		if len(args) < 2 {
-			fmt.Println("Usage: download_file <groupID> <fileName> [destPath]")
+	case "replicate_file":
+		// args: [groupID, fileName, targetUserID]
+		if len(args) < 3 {
+			fmt.Println("Usage: replicate_file <groupID> <fileName> <targetUserID>")
 			return
 		}
 
-		groupID := args[0]
-		fileName := args[1]
-		destPath := fileName
-		if len(args) >= 3 {
-			destPath = args[2]
+		if State.UserID == "" {
+			fmt.Println("Error: Not logged in")
+			return
 		}
 
-		fmt.Printf("Downloading '%s' from group '%s'...\n", fileName, groupID)
-
-		err := DownloadFile(groupID, fileName, destPath)
+		groupID, fileName, target := args[0], args[1], args[2]
+		n, err := ReplicateFile(groupID, fileName, target)
+		trackerCache.InvalidateGroup(groupID)
 		if err != nil {
-			fmt.Printf("✗ Download failed: %v\n", err)
+			fmt.Printf("✗ Replication failed after %d chunks: %v\n", n, err)
 			return
 		}
+		fmt.Printf("✓ Copied %d chunks of '%s' to %s, now a seeder\n", n, fileName, target)
 
-		fmt.Printf("✓ Download complete: %s\n", destPath)
-
-		// Register as seeder so other peers can download from us
-		if State.UserID != "" {
-			SendToTracker(Message{
-				Cmd:  "add_seeder",
-				Args: []string{groupID, fileName, State.UserID},
-			})
+	case "replicate_to_all":
+		// args: [groupID, fileName]
+		if len(args) < 2 {
+			fmt.Println("Usage: replicate_to_all <groupID> <fileName>")
+			return
 		}
 
-	case "status":
 		if State.UserID == "" {
-			fmt.Println("Status: Not logged in")
-			fmt.Println("Run './client_bin login <username> <password>' to login")
-		} else {
-			fmt.Println("Status: Logged in")
-			fmt.Printf("User: %s\n", State.UserID)
-			if State.ListenAddr != "" {
-				fmt.Printf("Peer server: 127.0.0.1%s\n", State.ListenAddr)
-			} else {
-				fmt.Println("Peer server: Starting...")
-			}
+			fmt.Println("Error: Not logged in")
+			return
 		}
 
-	case "show_downloads":
-		// Display downloaded files from .chunks directory
-		entries, err := os.ReadDir(ChunksDir)
-		if err != nil || len(entries) == 0 {
-			fmt.Println("No downloaded files found")
+		resp := SendToTracker(Message{Cmd: "replicate_to_all", Args: []string{args[0], args[1], State.UserID}})
+		statuses, ok := resp.Data.(map[string]interface{})
+		if resp.Status != "ok" || !ok {
+			fmt.Printf("✗ Replication failed: %v\n", resp.Data)
 			return
 		}
-
-		fmt.Println("Downloaded files:")
-		fmt.Println("─────────────────────────────────────────────")
-		
-		count := 0
-		for _, entry := range entries {
-			if !entry.IsDir() {
-				continue
-			}
-			
-			// Read metadata.json
-			metadataPath := filepath.Join(ChunksDir, entry.Name(), "metadata.json")
-			data, err := os.ReadFile(metadataPath)
-			if err != nil {
-				continue
-			}
-			
-			var metadata ChunkMetadata
-			if err := json.Unmarshal(data, &metadata); err != nil {
-				continue
-			}
-			
-			count++
-			fmt.Printf("%d. %s\n", count, metadata.FileName)
-			fmt.Printf("   Size: %.2f MB\n", float64(metadata.FileSize)/(1024*1024))
-			fmt.Printf("   Hash: %s...\n", metadata.FileHash[:16])
-			fmt.Printf("   Chunks: %d\n", metadata.TotalChunks)
-			if count < len(entries)-1 {
-				fmt.Println()
-			}
+		members := make([]string, 0, len(statuses))
+		for m := range statuses {
+			members = append(members, m)
 		}
-		fmt.Println("─────────────────────────────────────────────")
-
-	case "list_groups":
-		resp := SendToTracker(Message{
-			Cmd:  "list_groups",
-			Args: []string{},
-		})
-
-		if resp.Status == "ok" {
-			if msg, ok := resp.Data.(string); ok {
-				fmt.Println(msg)
-			} else if groupList, ok := resp.Data.([]interface{}); ok {
-				fmt.Println("Groups in network:")
-				fmt.Println("─────────────────────────────────────")
-				for i, group := range groupList {
-					if groupStr, ok := group.(string); ok {
-						fmt.Printf("%d. %s\n", i+1, groupStr)
-					}
-				}
-				fmt.Println("─────────────────────────────────────")
-			}
-		} else {
-			fmt.Println(resp)
+		sort.Strings(members)
+		fmt.Printf("✓ Asked the online members of '%s' to seed '%s':\n", args[0], args[1])
+		for _, m := range members {
+			fmt.Printf("  %-16s %v\n", m, statuses[m])
 		}
 
-	case "stop_sharing":
+	case "chunk_heatmap":
 		// args: [groupID, fileName]
 		if len(args) < 2 {
-			fmt.Println("Usage: stop_sharing <groupID> <fileName>")
+			fmt.Println("Usage: chunk_heatmap <groupID> <fileName>")
 			return
 		}
 
@@ -291,20 +1523,24 @@ func main() {
 			return
 		}
 
-		groupID := args[0]
-		fileName := args[1]
+		if err := ChunkHeatmap(args[0], args[1]); err != nil {
+			fmt.Printf("✗ %v\n", err)
+		}
 
-		resp := SendToTracker(Message{
-			Cmd:  "stop_sharing",
-			Args: []string{groupID, fileName, State.UserID},
-		})
+	case "show_transfers":
+		// --once prints one snapshot instead of redrawing every second
+		_, once := stripFlag(args, "--once")
+		if err := ShowTransfers(once); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
 
-		if resp.Status == "ok" {
-			fmt.Printf("✓ Stopped sharing '%s' in group '%s'\n", fileName, groupID)
-			fmt.Println("Note: Local chunks are preserved (delete .chunks/<hash>/ manually if needed)")
-		} else {
-			fmt.Println(resp)
+	case "clear_blacklist":
+		n := clearBlacklist()
+		if err := SaveSession(); err != nil {
+			fmt.Printf("✗ %v\n", err)
+			return
 		}
+		fmt.Printf("✓ Cleared %d blacklisted peers\n", n)
 
 	case "logout":
 		if err := ClearSession(); err != nil {
@@ -334,12 +1570,42 @@ func main() {
 		}
 		
 		State.ListenAddr = actualAddr
+
+		if err := InitPeerDHT("peer_" + State.UserID); err != nil {
+			fmt.Printf("Warning: Failed to join DHT: %v\n", err)
+		}
+
+		if verifyOnServe() {
+			if err := serveHashes.LoadAll(); err != nil {
+				fmt.Printf("Warning: Failed to load chunk hashes: %v\n", err)
+			}
+		}
+
+		// Uploads appear in show_transfers
+		transfers.StartPublishing()
+
+		// Upload counters on http://<P2P_STATS_ADDR>/stats
+		if addr := os.Getenv("P2P_STATS_ADDR"); addr != "" {
+			StartStatsServer(addr)
+		}
+
+		// Chunks, bitfields and peer info over HTTP on P2P_HTTP_PORT
+		if port := os.Getenv("P2P_HTTP_PORT"); port != "" {
+			if err := StartPeerHTTPServer(":" + port); err != nil {
+				fmt.Printf("Warning: HTTP API not started: %v\n", err)
+			} else {
+				fmt.Printf("✓ HTTP API on port %s\n", port)
+			}
+		}
 		
-		// Update tracker with actual address
-		SendToTracker(Message{
-			Cmd:  "update_address",
-			Args: []string{State.UserID, "127.0.0.1" + actualAddr},
-		})
+		// Update tracker with actual address; offline, peers are told it
+		// some other way
+		if !offline {
+			SendToTracker(Message{
+				Cmd:  "update_address",
+				Args: []string{State.UserID, "127.0.0.1" + actualAddr},
+			})
+		}
 		
 		// Save updated session with address
 		SaveSession()
@@ -349,18 +1615,22 @@ func main() {
 
 
 	case "join_group":
-		// args: [groupID]
+		// args: [groupID, inviteCode (private groups only)]
 		if len(args) < 1 {
-			fmt.Println("Usage: join_group <groupID>")
+			fmt.Println("Usage: join_group <groupID> [inviteCode]")
 			return
 		}
 		if State.UserID == "" {
 			fmt.Println("Error: Not logged in")
 			return
 		}
+		joinArgs := []string{args[0], State.UserID}
+		if len(args) >= 2 {
+			joinArgs = append(joinArgs, args[1])
+		}
 		resp := SendToTracker(Message{
 			Cmd:  "join_group",
-			Args: []string{args[0], State.UserID},
+			Args: joinArgs,
 		})
 		if resp.Status == "ok" {
 			fmt.Printf("✓ Join request sent to group '%s'\n", args[0])
@@ -442,8 +1712,65 @@ func main() {
 			fmt.Println(resp)
 		}
 
+	case "help":
+		// The tracker describes its own commands
+		resp := SendToTracker(Message{Cmd: "list_commands"})
+		if resp.Status != "ok" {
+			fmt.Println(resp)
+			return
+		}
+		if err := printCommandTable(os.Stdout, resp.Data); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+
 	default:
 		fmt.Printf("{error unknown command: %s}\n", cmd)
 	}
 
 }
+
+// stripValueFlag removes "flag value" or "flag=value" from args and returns the value.
+// It is an error for flag to be last with no value.
+func stripValueFlag(args []string, flag string) ([]string, string, bool, error) {
+	rest := make([]string, 0, len(args))
+	var value string
+	found := false
+	for i := 0; i < len(args); i++ {
+		name, v, hasValue := strings.Cut(args[i], "=")
+		if name != flag {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, "", false, fmt.Errorf("%s requires a value", flag)
+			}
+			i++
+			v = args[i]
+		}
+		value, found = v, true
+	}
+	return rest, value, found, nil
+}
+
+// confirm asks question and reports whether the answer read from in is yes.
+func confirm(in io.Reader, question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// stripFlag removes every occurrence of flag from args and reports whether there was one.
+func stripFlag(args []string, flag string) ([]string, bool) {
+	rest := make([]string, 0, len(args))
+	found := false
+	for _, a := range args {
+		if a == flag {
+			found = true
+			continue
+		}
+		rest = append(rest, a)
+	}
+	return rest, found
+}
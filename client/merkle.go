@@ -0,0 +1,51 @@
+package main
+
+import "p2p/common"
+
+// chunkLeaves returns the hashes of a file's total chunks in index order,
+// the leaves of its Merkle tree, or nil if one is missing.
+func chunkLeaves(chunks []ChunkInfo, total int) []string {
+	leaves := make([]string, total)
+	for _, c := range chunks {
+		if c.Index < 0 || c.Index >= total {
+			return nil
+		}
+		leaves[c.Index] = c.Hash
+	}
+	for _, l := range leaves {
+		if l == "" {
+			return nil
+		}
+	}
+	return leaves
+}
+
+// MerkleProof returns the sibling hashes linking chunk chunkIdx to
+// m.MerkleRoot, nil if m's chunk list is incomplete.
+func (m *ChunkMetadata) MerkleProof(chunkIdx int) []string {
+	return common.MerkleProof(chunkLeaves(m.Chunks, m.TotalChunks), chunkIdx)
+}
+
+// MerkleVerify reports whether data is chunk chunkIdx of the file whose
+// Merkle root is root, given the chunk's proof from MerkleProof. The
+// chunk is hashed with SHA256.
+func MerkleVerify(data []byte, chunkIdx int, proof []string, root string) bool {
+	return merkleVerifyChunk(data, "", chunkIdx, proof, root)
+}
+
+// merkleVerifyChunk is MerkleVerify for a chunk hashed with algo.
+func merkleVerifyChunk(data []byte, algo string, chunkIdx int, proof []string, root string) bool {
+	leaf, err := computeHash(algo, data)
+	return err == nil && root != "" && common.VerifyMerkleProof(leaf, chunkIdx, proof, root)
+}
+
+// verifyChunk checks chunk i of fileInfo as a peer sent it: against the
+// file's Merkle root when the peer sent a proof, otherwise against the
+// chunk list.
+func verifyChunk(data []byte, peerAlgo string, proof []string, fileInfo *FileInfo, i int) bool {
+	c := fileInfo.Chunks[i]
+	if proof == nil || fileInfo.MerkleRoot == "" {
+		return validateChunk(data, peerAlgo, c)
+	}
+	return sameHashAlgorithm(peerAlgo, c.HashAlgorithm) && merkleVerifyChunk(data, c.HashAlgorithm, i, proof, fileInfo.MerkleRoot)
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+// TestMerkleVerify checks each chunk of a chunked file verifies against the
+// file's Merkle root with its proof, and not with another chunk's.
+func TestMerkleVerify(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 4*smallChunkSize+100)
+	if meta.MerkleRoot == "" {
+		t.Fatal("ChunkFile set no Merkle root")
+	}
+	chunk := func(i int) []byte {
+		end := min(int64(i+1)*meta.ChunkSize, meta.FileSize)
+		return content[int64(i)*meta.ChunkSize : end]
+	}
+	for i := 0; i < meta.TotalChunks; i++ {
+		proof := meta.MerkleProof(i)
+		if len(proof) != 3 {
+			t.Errorf("chunk %d: %d hashes in proof, want 3", i, len(proof))
+		}
+		if !MerkleVerify(chunk(i), i, proof, meta.MerkleRoot) {
+			t.Errorf("chunk %d doesn't verify", i)
+		}
+	}
+	if MerkleVerify(chunk(1), 0, meta.MerkleProof(0), meta.MerkleRoot) {
+		t.Error("chunk 1 verified as chunk 0")
+	}
+	if (&ChunkMetadata{TotalChunks: 2, Chunks: meta.Chunks[:1]}).MerkleProof(0) != nil {
+		t.Error("proof from an incomplete chunk list")
+	}
+}
+
+// TestRequestChunkProof checks a peer sends a chunk's Merkle proof when
+// asked, and the downloader checks the chunk with it.
+func TestRequestChunkProof(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 3*smallChunkSize+100)
+	peer := startTestPeer(t)
+	info := streamTestInfo(meta, peer)
+	info.MerkleRoot = meta.MerkleRoot
+
+	data, algo, proof, err := requestChunkProof(peer, meta.FileHash, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof) != 2 || !verifyChunk(data, algo, proof, info, 2) {
+		t.Errorf("chunk 2 with proof %v doesn't verify", proof)
+	}
+	data[0] ^= 0xff
+	if verifyChunk(data, algo, proof, info, 2) {
+		t.Error("corrupted chunk verified")
+	}
+
+	if _, _, proof, err = requestChunkProof(peer, meta.FileHash, 2, false); err != nil || proof != nil {
+		t.Errorf("unasked proof %v, %v", proof, err)
+	}
+}
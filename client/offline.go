@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// offline is set by --offline: the client then contacts no tracker and
+// dials no peer. The peer server still serves the chunks already on disk,
+// to peers that find it on their own, on the same machine or LAN.
+var offline bool
+
+// errOffline is what requests for a tracker or peer fail with offline.
+var errOffline = errors.New("offline: trackers and peers are not contacted")
+
+// storedFileHash returns the hash of the one file in the chunk store whose
+// hash starts with prefix, as show_downloads abbreviates them.
+func storedFileHash(prefix string) (string, error) {
+	if validFileHash(prefix) {
+		return prefix, nil
+	}
+	entries, _ := os.ReadDir(ChunksDir)
+	var found []string
+	for _, e := range entries {
+		if e.IsDir() && prefix != "" && strings.HasPrefix(e.Name(), prefix) && validFileHash(e.Name()) {
+			found = append(found, e.Name())
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no downloaded file with hash %s", prefix)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("%d downloaded files have hashes starting %s", len(found), prefix)
+}
+
+// localAssemble writes the file stored under fileHash, or a prefix of it,
+// in the chunk store to destPath from the chunks on disk alone.
+func localAssemble(fileHash, destPath string) (*ChunkMetadata, error) {
+	fileHash, err := storedFileHash(fileHash)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := loadChunkMetadataHeader(fileHash)
+	if err != nil {
+		return nil, fmt.Errorf("no downloaded file %s: %v", fileHash, err)
+	}
+	if err := assembleFileFromDisk(filepath.Join(ChunksDir, fileHash), meta.TotalChunks, destPath); err != nil {
+		return nil, fmt.Errorf("failed to assemble file: %v", err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useOffline runs the test in offline mode.
+func useOffline(t *testing.T) {
+	t.Helper()
+	offline = true
+	t.Cleanup(func() { offline = false })
+}
+
+// countingListener accepts connections on a local address and counts them.
+func countingListener(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var n atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			n.Add(1)
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), &n
+}
+
+// TestOffline_NoConnections checks nothing that would reach a tracker, the
+// DHT or a peer connects to one offline.
+func TestOffline_NoConnections(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useOffline(t)
+	forgetTrackerConns(t)
+	useTestCache(t, 0)
+	addr, accepted := countingListener(t)
+	useTestNetwork(t, addr, nil)
+	t.Setenv("P2P_DHT_PORT", "0")
+
+	if resp := SendToTracker(Message{Cmd: "list_groups"}); resp.Status != "error" || resp.Data != errOffline.Error() {
+		t.Errorf("SendToTracker = %+v", resp)
+	}
+	if err := StreamFromTracker(Message{Cmd: "list_files"}, func(Response) {}); !errors.Is(err, errOffline) {
+		t.Errorf("StreamFromTracker = %v", err)
+	}
+	if _, err := MeasureTracker(addr); err == nil {
+		t.Error("MeasureTracker reached the tracker")
+	}
+	UpdateActiveTrackers()
+	if len(State.ActiveTrackers) != 0 {
+		t.Errorf("active trackers %v", State.ActiveTrackers)
+	}
+	if err := InitPeerDHT("peer_offline"); err != nil || peerDHT() != nil {
+		t.Errorf("InitPeerDHT = %v", err)
+	}
+	sendGossip(addr, PeerRequest{Cmd: "gossip", FileHash: "f"})
+	if err := DownloadFile("g", "f.txt", "out.txt"); err == nil {
+		t.Error("DownloadFile succeeded offline")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := accepted.Load(); n != 0 {
+		t.Errorf("%d connections made offline", n)
+	}
+}
+
+// TestOffline_ServesChunks checks the peer server still serves chunks on
+// disk offline.
+func TestOffline_ServesChunks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useOffline(t)
+	meta, _ := chunkTestFile(t, 2*smallChunkSize+100)
+	peer := startTestPeer(t)
+
+	for _, req := range []PeerRequest{
+		{Cmd: "handshake", FileHash: meta.FileHash},
+		{Cmd: "get_piece", FileHash: meta.FileHash, PieceIdx: 1},
+	} {
+		if status := peerRequest(t, peer, req); status != "ok" {
+			t.Errorf("%s = %s", req.Cmd, status)
+		}
+	}
+}
+
+func TestLocalAssemble(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*smallChunkSize+100)
+
+	got, err := localAssemble(meta.FileHash[:16], "out.bin")
+	if err != nil {
+		t.Fatalf("localAssemble: %v", err)
+	}
+	if got.FileHash != meta.FileHash {
+		t.Errorf("assembled %s, want %s", got.FileHash, meta.FileHash)
+	}
+	if data, _ := os.ReadFile("out.bin"); !bytes.Equal(data, content) {
+		t.Error("assembled file differs from the original")
+	}
+
+	if _, err := localAssemble("ffff", "none.bin"); err == nil || !strings.Contains(err.Error(), "no downloaded file") {
+		t.Errorf("unknown hash: %v", err)
+	}
+	if _, err := localAssemble("", "none.bin"); err == nil {
+		t.Error("empty hash assembled a file")
+	}
+}
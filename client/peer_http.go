@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// httpSecretFile keeps the key chunk tokens are signed with, so tokens
+// printed by http_token are accepted by the peer daemon. P2P_HTTP_SECRET
+// overrides it.
+const httpSecretFile = ".p2p_http_secret"
+
+// peerStarted is when this process started serving, for /info.
+var peerStarted = time.Now()
+
+// httpSecret returns the token key, creating httpSecretFile on first use.
+func httpSecret() ([]byte, error) {
+	if s := os.Getenv("P2P_HTTP_SECRET"); s != "" {
+		return []byte(s), nil
+	}
+	if data, err := os.ReadFile(httpSecretFile); err == nil && len(data) > 0 {
+		return data, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret := []byte(hex.EncodeToString(key))
+	return secret, os.WriteFile(httpSecretFile, secret, 0600)
+}
+
+// groupToken is the token that lets members of groupID fetch its chunks
+// over HTTP from this peer.
+func groupToken(secret []byte, groupID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(groupID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// fileInGroup reports whether the tracker lists the file with fileHash in
+// groupID, going by the name in its local metadata. A variable so tests
+// don't need a tracker.
+var fileInGroup = func(groupID, fileHash string) bool {
+	meta, err := loadChunkMetadata(fileHash)
+	if err != nil {
+		return false
+	}
+	info, err := queryFileInfo(groupID, meta.FileName)
+	return err == nil && info.FileHash == fileHash
+}
+
+// PeerHTTP serves chunks, bitfields and peer info over HTTP, for curl and
+// browsers, next to the TCP peer protocol:
+//
+//	GET /chunk/<fileHash>/<idx>?groupID=<g>&token=<t>  raw chunk bytes
+//	GET /bitfield/<fileHash>                            chunk indices held, as JSON
+//	GET /info                                           user ID, uptime and chunk count
+//
+// A chunk is served only with the token for a group the file is shared
+// in; members get it from the peer's owner, who prints it with http_token.
+type PeerHTTP struct {
+	secret []byte
+	mu     sync.Mutex
+	shared map[string]bool // groupID + ":" + fileHash confirmed with the tracker
+}
+
+func NewPeerHTTP(secret []byte) *PeerHTTP {
+	return &PeerHTTP{secret: secret, shared: make(map[string]bool)}
+}
+
+func (p *PeerHTTP) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /chunk/{hash}/{idx}", p.handleChunk)
+	mux.HandleFunc("GET /bitfield/{hash}", handleHTTPBitfield)
+	mux.HandleFunc("GET /info", handleHTTPInfo)
+	return mux
+}
+
+// StartPeerHTTPServer serves PeerHTTP on addr in the background.
+func StartPeerHTTPServer(addr string) error {
+	secret, err := httpSecret()
+	if err != nil {
+		return err
+	}
+	go http.ListenAndServe(addr, NewPeerHTTP(secret).Handler())
+	return nil
+}
+
+// validFileHash reports whether hash can name a directory under ChunksDir.
+func validFileHash(hash string) bool {
+	b, err := hex.DecodeString(hash)
+	return err == nil && len(b) == sha256.Size
+}
+
+func (p *PeerHTTP) handleChunk(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	idx, err := strconv.Atoi(r.PathValue("idx"))
+	if !validFileHash(hash) || err != nil || idx < 0 {
+		http.Error(w, "bad chunk path", http.StatusBadRequest)
+		return
+	}
+	groupID, token := r.URL.Query().Get("groupID"), r.URL.Query().Get("token")
+	if groupID == "" || !hmac.Equal([]byte(token), []byte(groupToken(p.secret, groupID))) {
+		http.Error(w, "invalid group token", http.StatusForbidden)
+		return
+	}
+	if !p.sharedIn(groupID, hash) {
+		http.Error(w, "file not shared in this group", http.StatusForbidden)
+		return
+	}
+
+	data, status := readServedChunk(hash, idx)
+	switch status {
+	case "ok":
+	case "corrupt":
+		http.Error(w, "chunk failed verification", http.StatusInternalServerError)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if _, err := w.Write(data); err == nil {
+		transfers.Record(DirectionUp, hash, httpPeerHost(r), int64(len(data)), time.Now())
+		markChunkDirUsed(hash)
+	}
+}
+
+// httpPeerHost is the requesting host, for the transfer log.
+func httpPeerHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sharedIn checks fileInGroup once per group and file.
+func (p *PeerHTTP) sharedIn(groupID, hash string) bool {
+	key := groupID + ":" + hash
+	p.mu.Lock()
+	ok := p.shared[key]
+	p.mu.Unlock()
+	if ok {
+		return true
+	}
+	if !fileInGroup(groupID, hash) {
+		return false
+	}
+	p.mu.Lock()
+	p.shared[key] = true
+	p.mu.Unlock()
+	return true
+}
+
+func handleHTTPBitfield(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	if !validFileHash(hash) {
+		http.Error(w, "bad file hash", http.StatusBadRequest)
+		return
+	}
+	bf, err := localChunkIndices(hash)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeHTTPJSON(w, map[string]interface{}{"file_hash": hash, "bitfield": bf})
+}
+
+func handleHTTPInfo(w http.ResponseWriter, r *http.Request) {
+	files, chunks := 0, 0
+	entries, _ := os.ReadDir(ChunksDir)
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == GlobalChunkDir {
+			continue
+		}
+		if bf, err := localChunkIndices(e.Name()); err == nil {
+			files++
+			chunks += len(bf)
+		}
+	}
+	writeHTTPJSON(w, map[string]interface{}{
+		"user_id":        State.UserID,
+		"uptime_seconds": int(time.Since(peerStarted).Seconds()),
+		"files":          files,
+		"chunk_count":    chunks,
+	})
+}
+
+// localChunkIndices lists the chunks of fileHash on disk.
+func localChunkIndices(fileHash string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(ChunksDir, fileHash))
+	if err != nil {
+		return nil, err
+	}
+	bf := make([]int, 0)
+	for _, e := range entries {
+		var idx int
+		if _, err := fmt.Sscanf(e.Name(), "chunk_%d.dat", &idx); err == nil && e.Name() == fmt.Sprintf("chunk_%d.dat", idx) {
+			bf = append(bf, idx)
+		}
+	}
+	return bf, nil
+}
+
+func writeHTTPJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// startPeerHTTP serves the HTTP API with a fixed secret, treating only g1
+// as sharing files. It returns the server URL and how often the tracker
+// would have been asked.
+func startPeerHTTP(t *testing.T) (string, *int) {
+	t.Helper()
+	lookups := 0
+	saved := fileInGroup
+	fileInGroup = func(groupID, fileHash string) bool {
+		lookups++
+		return groupID == "g1"
+	}
+	t.Cleanup(func() { fileInGroup = saved })
+
+	srv := httptest.NewServer(NewPeerHTTP([]byte("test-secret")).Handler())
+	t.Cleanup(srv.Close)
+	return srv.URL, &lookups
+}
+
+func httpGet(t *testing.T, url string) (int, []byte) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, body
+}
+
+// TestPeerHTTP_Chunk fetches a chunk with a valid group token and checks
+// requests with a wrong token, another group's token or a bad path fail.
+func TestPeerHTTP_Chunk(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, ChunkSize+100)
+	url, lookups := startPeerHTTP(t)
+	chunk1, err := os.ReadFile(filepath.Join(ChunksDir, meta.FileHash, "chunk_1.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := groupToken([]byte("test-secret"), "g1")
+	path := url + "/chunk/" + meta.FileHash + "/1?groupID=g1&token=" + token
+	for i := 0; i < 2; i++ {
+		code, body := httpGet(t, path)
+		if code != http.StatusOK || !bytes.Equal(body, chunk1) {
+			t.Fatalf("GET chunk 1 = %d, %d bytes", code, len(body))
+		}
+	}
+	if *lookups != 1 {
+		t.Errorf("tracker lookups = %d, want 1 (cached)", *lookups)
+	}
+
+	cases := map[string]struct {
+		path string
+		code int
+	}{
+		"wrong token":  {"/chunk/" + meta.FileHash + "/1?groupID=g1&token=" + token[1:], http.StatusForbidden},
+		"no token":     {"/chunk/" + meta.FileHash + "/1?groupID=g1", http.StatusForbidden},
+		"other group":  {"/chunk/" + meta.FileHash + "/1?groupID=g2&token=" + groupToken([]byte("test-secret"), "g2"), http.StatusForbidden},
+		"token for g1": {"/chunk/" + meta.FileHash + "/1?groupID=g2&token=" + token, http.StatusForbidden},
+		"missing":      {"/chunk/" + meta.FileHash + "/7?groupID=g1&token=" + token, http.StatusNotFound},
+		"bad index":    {"/chunk/" + meta.FileHash + "/x?groupID=g1&token=" + token, http.StatusBadRequest},
+		"bad hash":     {"/chunk/..%2f" + meta.FileHash + "/1?groupID=g1&token=" + token, http.StatusBadRequest},
+	}
+	for name, c := range cases {
+		if code, _ := httpGet(t, url+c.path); code != c.code {
+			t.Errorf("%s: status %d, want %d", name, code, c.code)
+		}
+	}
+}
+
+func TestPeerHTTP_Bitfield(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*ChunkSize+100)
+	url, _ := startPeerHTTP(t)
+
+	code, body := httpGet(t, url+"/bitfield/"+meta.FileHash)
+	var got struct {
+		FileHash string `json:"file_hash"`
+		Bitfield []int  `json:"bitfield"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil || code != http.StatusOK {
+		t.Fatalf("GET bitfield = %d %s: %v", code, body, err)
+	}
+	if got.FileHash != meta.FileHash || len(got.Bitfield) != meta.TotalChunks {
+		t.Errorf("bitfield = %+v", got)
+	}
+
+	missing := "ab" + meta.FileHash[2:]
+	if code, _ := httpGet(t, url+"/bitfield/"+missing); code != http.StatusNotFound {
+		t.Errorf("unknown file: status %d", code)
+	}
+}
+
+func TestPeerHTTP_Info(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*ChunkSize+100)
+	url, _ := startPeerHTTP(t)
+	saved := State.UserID
+	State.UserID = "alice"
+	t.Cleanup(func() { State.UserID = saved })
+
+	code, body := httpGet(t, url+"/info")
+	var info map[string]interface{}
+	if err := json.Unmarshal(body, &info); err != nil || code != http.StatusOK {
+		t.Fatalf("GET info = %d %s: %v", code, body, err)
+	}
+	if info["user_id"] != "alice" || info["files"] != float64(1) || info["chunk_count"] != float64(meta.TotalChunks) {
+		t.Errorf("info = %v", info)
+	}
+	if _, ok := info["uptime_seconds"].(float64); !ok {
+		t.Errorf("info has no uptime: %v", info)
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"p2p/common"
 	"path/filepath"
+	"time"
 )
 
 // StartPeerServerWithListener creates a listener and returns it along with the actual address
@@ -27,14 +29,29 @@ func StartPeerServerWithListener(addr string) (net.Listener, string) {
 	return ln, actualAddr
 }
 
-// AcceptPeerConnections accepts incoming peer connections (runs in goroutine)
+// AcceptPeerConnections accepts incoming peer connections (runs in goroutine).
+// At most P2P_MAX_CONNS connections (P2P_MAX_CONNS_PER_IP from one IP) are
+// served at once; past that a peer gets a "busy" response and is closed.
 func AcceptPeerConnections(ln net.Listener) {
+	limiter := newConnLimiter(envLimit("P2P_MAX_CONNS", defaultMaxConns),
+		envLimit("P2P_MAX_CONNS_PER_IP", defaultMaxConnsPerIP))
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			continue
 		}
-		go handlePeerConn(conn)
+		ip := remoteIP(conn)
+		if !limiter.acquire(ip) {
+			go rejectBusy(conn)
+			continue
+		}
+		go func() {
+			defer limiter.release(ip)
+			handlePeerConn(conn)
+		}()
 	}
 }
 
@@ -46,33 +63,65 @@ func StartPeerServer(addr string) {
 		return
 	}
 	
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			continue
-		}
-		go handlePeerConn(conn)
-	}
+	AcceptPeerConnections(ln)
 }
 
 type PeerRequest struct {
 	Cmd			string `json:"cmd"`
 	FileHash	string `json:"file_hash"`
 	PieceIdx	int `json:"piece_idx"`
+
+	// gossip_chunks: Origin holds the chunk indices in Bitfield; From relayed it
+	Bitfield []int  `json:"bitfield,omitempty"`
+	Origin   string `json:"origin,omitempty"`
+	From     string `json:"from,omitempty"`
+
+	// push_chunk: Data is chunk PieceIdx; the first push also carries Metadata
+	Data     []byte         `json:"data,omitempty"`
+	Metadata *ChunkMetadata `json:"metadata,omitempty"`
+
+	// get_piece: encodings the chunk may be sent in, besides raw
+	AcceptEncoding []string `json:"accept_encoding,omitempty"`
+
+	// handshake, get_piece, push_chunk: the download token the tracker issued with the file's info;
+	// replicate_file: the token the tracker signed the request with
+	Token string `json:"token,omitempty"`
+
+	// get_piece: also send the chunk's Merkle proof
+	WantProof bool `json:"want_proof,omitempty"`
+
+	// replicate_file: the tracker asks us to download and seed FileName of GroupID;
+	// push_chunk: where the tracker lists the pushed file
+	GroupID  string `json:"group_id,omitempty"`
+	FileName string `json:"file_name,omitempty"`
 }
 
 type PeerResponse struct {
 	Status  string `json:"status"`
 	Data    []byte `json:"data,omitempty"`
 	Bitfield []int `json:"bitfield,omitempty"` // Chunk indices this peer has
+	// HashAlgorithm is what a get_piece chunk was hashed with; "" means SHA256
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+	// ContentEncoding is how a get_piece chunk's Data is encoded; "" means raw
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// Proof links a get_piece chunk to its file's Merkle root, if asked for and known
+	Proof []string `json:"proof,omitempty"`
+	// Hash is what cross_verify hashed this peer's copy of a chunk to, with HashAlgorithm
+	Hash string `json:"hash,omitempty"`
 }
 
 func handleHandshake(conn net.Conn, req PeerRequest){
 	fileHash := req.FileHash
+
+	// With P2P_PEER_SECRET set, only group members the tracker vouched for get chunks
+	if !authorizePeer(req) {
+		common.Send(conn, PeerResponse{Status: "unauthorized"})
+		return
+	}
 	
 	// Check if we have this file
 	chunkDir := filepath.Join(ChunksDir, fileHash)
-	if _, err := os.Stat(chunkDir); os.IsNotExist(err) {
+	if _, err := os.Stat(chunkDir); os.IsNotExist(err) || isUnshared(fileHash) {
 		common.Send(conn, PeerResponse{
 			Status: "error",
 		})
@@ -88,22 +137,69 @@ func handleGetPiece(conn net.Conn, req PeerRequest){
 	fileHash := req.FileHash
 	chunkIdx := req.PieceIdx
 
-	// Read chunk file
+	// Checked again: get_piece comes on its own connection, after the handshake's
+	if !authorizePeer(req) {
+		common.Send(conn, PeerResponse{Status: "unauthorized"})
+		return
+	}
+
+	// With every serving slot busy, requests for rarer chunks go first
+	defer servingQueue.Acquire(fileHash, chunkIdx)()
+
+	data, status := readServedChunk(fileHash, chunkIdx)
+	if status != "ok" {
+		common.Send(conn, PeerResponse{Status: status})
+		return
+	}
+
+	payload, enc := encodeForPeer(req.AcceptEncoding, data)
+	resp := PeerResponse{Status: "ok", Data: payload, HashAlgorithm: serveHashes.Algorithm(fileHash, chunkIdx), ContentEncoding: enc}
+	if req.WantProof {
+		resp.Proof = serveHashes.Proof(fileHash, chunkIdx)
+	}
+	// The piece shares P2P_BANDWIDTH_LIMIT with every other transfer, and
+	// conn lets it out in 64 KB slices as P2P_UPLOAD_RATE allows
+	if err := common.Send(bandwidth.Conn(conn, 1), resp); err == nil {
+		transfers.Record(DirectionUp, fileHash, peerHost(conn.RemoteAddr()), int64(len(payload)), time.Now())
+		// Let the DHT learn which peers hold which chunks as they get served
+		go announceChunk(fileHash, chunkIdx)
+		markChunkDirUsed(fileHash)
+	}
+}
+
+// readServedChunk returns a chunk to serve from the chunk cache or, on a
+// miss, from disk, caching it for next time. The status is "error" if
+// the chunk can't be read and "corrupt" if it fails verification.
+func readServedChunk(fileHash string, chunkIdx int) ([]byte, string) {
 	chunkPath := filepath.Join(ChunksDir, fileHash, fmt.Sprintf("chunk_%d.dat", chunkIdx))
+	info, err := os.Stat(chunkPath)
+	if err != nil || isUnshared(fileHash) {
+		return nil, "error"
+	}
+	key := fmt.Sprintf("%s:%d", fileHash, chunkIdx)
+	if data, ok := chunkCache.Get(key, info); ok {
+		return data, "ok"
+	}
+
 	data, err := os.ReadFile(chunkPath)
 	if err != nil {
-		common.Send(conn, PeerResponse{Status: "error"})
-		return
+		return nil, "error"
 	}
-
-	common.Send(conn, PeerResponse{Status: "ok", Data: data})
+	if verifyOnServe() {
+		if err := serveHashes.Verify(fileHash, chunkIdx, data); err != nil {
+			fmt.Printf("Warning: not serving corrupt chunk: %v\n", err)
+			return nil, "corrupt"
+		}
+	}
+	chunkCache.Put(key, data, info)
+	return data, "ok"
 }
 
 // handleGetBitfield returns the set of chunk indices this peer has for a given file hash.
 func handleGetBitfield(conn net.Conn, req PeerRequest) {
 	chunkDir := filepath.Join(ChunksDir, req.FileHash)
 	entries, err := os.ReadDir(chunkDir)
-	if err != nil {
+	if err != nil || isUnshared(req.FileHash) {
 		common.Send(conn, PeerResponse{Status: "error"})
 		return
 	}
@@ -118,8 +214,13 @@ func handleGetBitfield(conn net.Conn, req PeerRequest) {
 	common.Send(conn, PeerResponse{Status: "ok", Bitfield: bf})
 }
 
-func handlePeerConn(conn net.Conn){
-	defer conn.Close()
+func handlePeerConn(rawConn net.Conn){
+	defer rawConn.Close()
+	setKeepalive(rawConn)
+
+	// Every reply goes through the throttled conn so uploads respect P2P_UPLOAD_RATE
+	conn := newThrottledConn(rawConn, uploadRate())
+	defer func() { uploadStats.record(rawConn.RemoteAddr(), conn.written) }()
 
 	var req PeerRequest
 	if err := common.Recv(conn, &req); err != nil {
@@ -133,6 +234,16 @@ func handlePeerConn(conn net.Conn){
 		handleGetPiece(conn, req)
 	case "get_bitfield":
 		handleGetBitfield(conn, req)
+	case "gossip_chunks":
+		localGossip.handleGossip(conn, req)
+	case "push_chunk":
+		handlePushChunk(conn, req)
+	case "replicate_file":
+		handleReplicateFile(conn, req)
+	case "stop_sharing":
+		handleStopSharing(conn, req)
+	case "cross_verify":
+		handleCrossVerify(conn, req)
 	default:
 		common.Send(conn, PeerResponse{Status: "error"})
 	}
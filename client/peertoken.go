@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"p2p/common"
+	"sync"
+	"time"
+)
+
+// errPeerUnauthorized is returned by requestChunk when the peer refuses our
+// download token. It says nothing about the peer, so it isn't held against it.
+var errPeerUnauthorized = errors.New("peer refused download token")
+
+// peerTokens holds the download token the tracker issued with each file's
+// info, by file hash, for requestChunk to present.
+var peerTokens sync.Map
+
+// rememberPeerToken keeps info's download token, if it came with one.
+func rememberPeerToken(info *FileInfo) {
+	if info.Token != "" {
+		peerTokens.Store(info.FileHash, info.Token)
+	}
+}
+
+// peerToken returns the download token for fileHash, "" if we have none.
+func peerToken(fileHash string) string {
+	token, _ := peerTokens.Load(fileHash)
+	s, _ := token.(string)
+	return s
+}
+
+// authorizePeer reports whether req carries a valid download token for its
+// file. Without P2P_PEER_SECRET no token is needed.
+func authorizePeer(req PeerRequest) bool {
+	secret := common.PeerSecret()
+	if secret == nil {
+		return true
+	}
+	_, err := common.VerifyPeerToken(secret, req.Token, req.FileHash, time.Now())
+	return err == nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"p2p/common"
+	"testing"
+	"time"
+)
+
+// TestPeerAuth_Handshake checks a peer with a secret serves only requests
+// carrying a valid, unexpired token for the file.
+func TestPeerAuth_Handshake(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*smallChunkSize+100)
+	peer := startTestPeer(t)
+	t.Setenv(common.PeerSecretEnv, "s3cret")
+	hash := meta.FileHash
+	valid := common.SignPeerToken([]byte("s3cret"), "bob", hash, time.Now().Add(time.Hour))
+
+	tampered := []byte(valid)
+	tampered[len(tampered)-1] = 'x' // issued to another user
+	for name, tt := range map[string]struct {
+		token string
+		want  string
+	}{
+		"valid":        {valid, "ok"},
+		"expired":      {common.SignPeerToken([]byte("s3cret"), "bob", hash, time.Now().Add(-time.Second)), "unauthorized"},
+		"tampered":     {string(tampered), "unauthorized"},
+		"missing":      {"", "unauthorized"},
+		"other secret": {common.SignPeerToken([]byte("guess"), "bob", hash, time.Now().Add(time.Hour)), "unauthorized"},
+	} {
+		for _, cmd := range []string{"handshake", "get_piece"} {
+			if got := peerRequest(t, peer, PeerRequest{Cmd: cmd, FileHash: hash, Token: tt.token}); got != tt.want {
+				t.Errorf("%s with %s token = %s, want %s", cmd, name, got, tt.want)
+			}
+		}
+	}
+}
+
+// TestRequestChunk_PresentsToken checks requestChunk sends the token the
+// tracker issued, and a refused token doesn't count against the peer.
+func TestRequestChunk_PresentsToken(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useBlacklistClock(t)
+	data := bytes.Repeat([]byte("chunk "), 100)
+	peer := serveTestChunk(t, "fh", data)
+	t.Setenv(common.PeerSecretEnv, "s3cret")
+
+	for i := 0; i < blacklistThreshold; i++ {
+		if _, _, err := requestChunk(peer, "fh", 0); !errors.Is(err, errPeerUnauthorized) {
+			t.Fatalf("without token: %v", err)
+		}
+	}
+	if peerBlacklisted(peer) {
+		t.Error("peer blacklisted for refusing our token")
+	}
+
+	rememberPeerToken(&FileInfo{FileHash: "fh", Token: common.SignPeerToken([]byte("s3cret"), "bob", "fh", time.Now().Add(time.Hour))})
+	t.Cleanup(func() { peerTokens.Delete("fh") })
+	if got, _, err := requestChunk(peer, "fh", 0); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("with token: %d bytes, %v", len(got), err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// defaultServeSlots is the default for P2P_SERVE_SLOTS.
+const defaultServeSlots = 8
+
+type queuedPiece struct {
+	holders int    // peers known to hold the chunk; fewer is served first
+	seq     uint64 // arrival order, to keep equal rarity first come first served
+	ready   chan struct{}
+}
+
+// pieceHeap is a heap of waiting get_piece requests, rarest chunk first.
+type pieceHeap []*queuedPiece
+
+func (h pieceHeap) Len() int { return len(h) }
+func (h pieceHeap) Less(i, j int) bool {
+	if h[i].holders != h[j].holders {
+		return h[i].holders < h[j].holders
+	}
+	return h[i].seq < h[j].seq
+}
+func (h pieceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *pieceHeap) Push(x interface{}) { *h = append(*h, x.(*queuedPiece)) }
+func (h *pieceHeap) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// PieceQueue lets at most slots get_piece requests be served at once. The
+// others wait, and as slots free up the chunk held by the fewest peers
+// goes next, so rare chunks spread through the swarm before common ones.
+// Requests for equally rare chunks go in the order they came.
+type PieceQueue struct {
+	mu      sync.Mutex
+	free    int
+	seq     uint64
+	waiting pieceHeap
+	holders func(fileHash string, chunkIdx int) int
+}
+
+// NewPieceQueue returns a queue serving slots requests at once, ranking
+// chunks by holders.
+func NewPieceQueue(slots int, holders func(fileHash string, chunkIdx int) int) *PieceQueue {
+	return &PieceQueue{free: slots, holders: holders}
+}
+
+// servingQueue orders the get_piece requests the peer server answers.
+var servingQueue = NewPieceQueue(envLimit("P2P_SERVE_SLOTS", defaultServeSlots), chunkHolders)
+
+// Acquire waits for a slot to serve chunk chunkIdx of fileHash and returns
+// the func that gives it back.
+func (q *PieceQueue) Acquire(fileHash string, chunkIdx int) (release func()) {
+	q.mu.Lock()
+	if q.free > 0 && len(q.waiting) == 0 {
+		q.free--
+		q.mu.Unlock()
+		return q.release
+	}
+	q.seq++
+	p := &queuedPiece{holders: q.holders(fileHash, chunkIdx), seq: q.seq, ready: make(chan struct{})}
+	heap.Push(&q.waiting, p)
+	q.mu.Unlock()
+	<-p.ready
+	return q.release
+}
+
+// release hands the slot to the rarest waiting request, if any.
+func (q *PieceQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) > 0 {
+		close(heap.Pop(&q.waiting).(*queuedPiece).ready)
+		return
+	}
+	q.free++
+}
+
+// Waiting returns how many requests are queued for a slot.
+func (q *PieceQueue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting)
+}
+
+// chunkHolders counts the peers whose bitfield for fileHash, as gossiped
+// or fetched, holds chunk chunkIdx.
+func chunkHolders(fileHash string, chunkIdx int) int {
+	n := 0
+	for _, bf := range localGossip.cache.Peers(fileHash) {
+		if chunkIdx < len(bf) && bf[chunkIdx] {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n requests are queued on q.
+func waitQueued(t *testing.T, q *PieceQueue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Waiting() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", q.Waiting(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestPieceQueue_RareFirst queues requests for common and rare chunks
+// behind a busy slot and checks the rare ones are served first, each
+// rarity in the order its requests came.
+func TestPieceQueue_RareFirst(t *testing.T) {
+	holders := map[int]int{0: 5, 1: 1, 2: 5, 3: 1, 4: 3}
+	q := NewPieceQueue(1, func(_ string, idx int) int { return holders[idx] })
+	release := q.Acquire("f", 9)
+
+	var mu sync.Mutex
+	var served []int
+	var wg sync.WaitGroup
+	for n, idx := range []int{0, 1, 2, 3, 4} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := q.Acquire("f", idx)
+			mu.Lock()
+			served = append(served, idx)
+			mu.Unlock()
+			done()
+		}()
+		waitQueued(t, q, n+1)
+	}
+	release()
+	wg.Wait()
+
+	if want := []int{1, 3, 4, 0, 2}; !reflect.DeepEqual(served, want) {
+		t.Errorf("served %v, want %v", served, want)
+	}
+	if q.free != 1 || q.Waiting() != 0 {
+		t.Errorf("%d free slots, %d waiting after all were served", q.free, q.Waiting())
+	}
+}
+
+// TestPieceQueue_FreeSlots checks requests aren't queued while slots are
+// free.
+func TestPieceQueue_FreeSlots(t *testing.T) {
+	q := NewPieceQueue(2, func(string, int) int { return 0 })
+	a, b := q.Acquire("f", 0), q.Acquire("f", 1)
+	if q.Waiting() != 0 {
+		t.Fatalf("%d waiting with two slots", q.Waiting())
+	}
+	got := make(chan struct{})
+	go func() {
+		q.Acquire("f", 2)()
+		close(got)
+	}()
+	waitQueued(t, q, 1)
+	a()
+	<-got
+	b()
+	if q.free != 2 {
+		t.Errorf("%d free slots, want 2", q.free)
+	}
+}
+
+func TestChunkHolders(t *testing.T) {
+	hash := "piecequeue-test"
+	localGossip.cache.Update(hash, "a", []bool{true, true, false})
+	localGossip.cache.Update(hash, "b", []bool{true, false})
+	localGossip.cache.Update(hash, "c", []bool{true, true, true, true})
+	for idx, want := range []int{3, 2, 1, 1, 0} {
+		if got := chunkHolders(hash, idx); got != want {
+			t.Errorf("chunk %d held by %d peers, want %d", idx, got, want)
+		}
+	}
+}
+
+// TestHandleGetPiece_Queued checks get_piece waits for a serving slot.
+func TestHandleGetPiece_Queued(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*smallChunkSize+100)
+	peer := startTestPeer(t)
+	saved := servingQueue
+	servingQueue = NewPieceQueue(1, chunkHolders)
+	t.Cleanup(func() { servingQueue = saved })
+
+	release := servingQueue.Acquire(meta.FileHash, 0)
+	got := make(chan string, 1)
+	go func() {
+		got <- peerRequest(t, peer, PeerRequest{Cmd: "get_piece", FileHash: meta.FileHash, PieceIdx: 1})
+	}()
+	waitQueued(t, servingQueue, 1)
+	select {
+	case status := <-got:
+		t.Fatalf("served with no free slot: %s", status)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	if status := <-got; status != "ok" {
+		t.Errorf("get_piece = %s", status)
+	}
+}
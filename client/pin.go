@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// PinnedMarker marks a chunk directory that EvictChunks must never remove.
+const PinnedMarker = ".pinned"
+
+func pinnedMarkerPath(fileHash string) string {
+	return filepath.Join(ChunksDir, fileHash, PinnedMarker)
+}
+
+// PinFile protects a locally stored file from eviction.
+func PinFile(fileHash string) (*ChunkMetadata, error) {
+	metadata, err := loadChunkMetadata(fileHash)
+	if err != nil {
+		return nil, fmt.Errorf("no local file with hash %s", fileHash)
+	}
+	if err := os.WriteFile(pinnedMarkerPath(fileHash), nil, 0644); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// UnpinFile makes a file evictable again. Unpinning a file that isn't pinned is an error.
+func UnpinFile(fileHash string) error {
+	err := os.Remove(pinnedMarkerPath(fileHash))
+	if os.IsNotExist(err) {
+		return errors.New("file is not pinned")
+	}
+	return err
+}
+
+// IsPinned reports whether fileHash's chunk directory holds a pin marker.
+func IsPinned(fileHash string) bool {
+	_, err := os.Stat(pinnedMarkerPath(fileHash))
+	return err == nil
+}
+
+// ListPinned returns the metadata of every pinned file, sorted by name.
+func ListPinned() ([]*ChunkMetadata, error) {
+	entries, err := os.ReadDir(ChunksDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pinned []*ChunkMetadata
+	for _, e := range entries {
+		if !e.IsDir() || !IsPinned(e.Name()) {
+			continue
+		}
+		metadata, err := loadChunkMetadata(e.Name())
+		if err != nil {
+			metadata = &ChunkMetadata{FileHash: e.Name()}
+		}
+		pinned = append(pinned, metadata)
+	}
+	sort.Slice(pinned, func(a, b int) bool { return pinned[a].FileName < pinned[b].FileName })
+	return pinned, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sequential downloads know which chunk comes next, so while one chunk
+// downloads the next is fetched in the background into a PrefetchCache.
+
+const (
+	prefetchCapacity = 2                // chunks held or being fetched at once
+	prefetchMaxAge   = 30 * time.Second // prefetched chunks older than this are discarded
+)
+
+// Prefetch hits and lookups across every download, for the hit rate.
+var prefetchHits, prefetchLookups atomic.Int64
+
+// prefetchedChunk is one chunk fetched ahead, or being fetched.
+type prefetchedChunk struct {
+	done  chan struct{} // closed once the fetch has finished
+	at    time.Time     // when it finished
+	peer  string
+	data  []byte
+	algo  string
+	proof []string
+	err   error
+}
+
+// PrefetchCache holds chunks of one download fetched ahead of it, keyed
+// by chunk index.
+type PrefetchCache struct {
+	mu       sync.Mutex
+	capacity int
+	maxAge   time.Duration
+	now      func() time.Time
+	chunks   map[int]*prefetchedChunk
+}
+
+func NewPrefetchCache(capacity int, maxAge time.Duration) *PrefetchCache {
+	return &PrefetchCache{capacity: capacity, maxAge: maxAge, now: time.Now, chunks: make(map[int]*prefetchedChunk)}
+}
+
+// Start fetches chunk i from peer in the background with fetch, unless it
+// is cached or being fetched already. A full cache makes room by
+// discarding its oldest fetched chunk; if every slot is still being
+// fetched, i isn't. It reports whether a fetch was started.
+func (c *PrefetchCache) Start(i int, peer string, fetch func() ([]byte, string, []string, error)) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.chunks[i]; ok {
+		return false
+	}
+	c.expireLocked()
+	if len(c.chunks) >= c.capacity {
+		oldest := -1
+		for j, pc := range c.chunks {
+			select {
+			case <-pc.done:
+				if oldest < 0 || pc.at.Before(c.chunks[oldest].at) {
+					oldest = j
+				}
+			default:
+			}
+		}
+		if oldest < 0 {
+			return false
+		}
+		delete(c.chunks, oldest)
+	}
+
+	pc := &prefetchedChunk{done: make(chan struct{}), peer: peer}
+	c.chunks[i] = pc
+	go func() {
+		data, algo, proof, err := fetch()
+		c.mu.Lock()
+		pc.data, pc.algo, pc.proof, pc.err, pc.at = data, algo, proof, err, c.now()
+		c.mu.Unlock()
+		close(pc.done)
+	}()
+	return true
+}
+
+// Take removes chunk i from the cache and returns it, waiting for its
+// fetch if it is still running. ok is false if i wasn't prefetched, its
+// fetch failed, or it is older than the cache's maxAge.
+func (c *PrefetchCache) Take(i int) (pc *prefetchedChunk, ok bool) {
+	prefetchLookups.Add(1)
+	c.mu.Lock()
+	pc, ok = c.chunks[i]
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	<-pc.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.chunks[i] == pc {
+		delete(c.chunks, i)
+	}
+	if pc.err != nil || c.now().Sub(pc.at) > c.maxAge {
+		return nil, false
+	}
+	prefetchHits.Add(1)
+	return pc, true
+}
+
+// expireLocked discards fetched chunks older than maxAge. Caller must
+// hold c.mu.
+func (c *PrefetchCache) expireLocked() {
+	for i, pc := range c.chunks {
+		select {
+		case <-pc.done:
+			if c.now().Sub(pc.at) > c.maxAge {
+				delete(c.chunks, i)
+			}
+		default:
+		}
+	}
+}
+
+// prefetchChunk starts fetching chunk i of fileInfo into c from its first
+// usable candidate peer, unless it is on disk already.
+func prefetchChunk(c *PrefetchCache, fileInfo *FileInfo, peerBitfields map[string][]bool, chunkDir string, i int) {
+	if _, err := os.Stat(filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))); err == nil {
+		return
+	}
+	for _, peer := range chunkCandidates(fileInfo, peerBitfields, i) {
+		if peerBlacklisted(peer) {
+			continue
+		}
+		c.Start(i, peer, func() ([]byte, string, []string, error) {
+			return requestChunkProof(peer, fileInfo.FileHash, i, fileInfo.MerkleRoot != "")
+		})
+		return
+	}
+}
+
+// errNotPrefetched is returned by takePrefetched for chunks not in the cache.
+var errNotPrefetched = errors.New("not prefetched")
+
+// takePrefetched saves chunk i to chunkPath from c, if it was prefetched
+// and is valid, and returns the peer it came from. c may be nil.
+func takePrefetched(c *PrefetchCache, fileInfo *FileInfo, i int, chunkPath string) (string, error) {
+	if c == nil {
+		return "", errNotPrefetched
+	}
+	pc, ok := c.Take(i)
+	if !ok {
+		return "", errNotPrefetched
+	}
+	if err := saveChunk(pc.peer, fileInfo, i, chunkPath, pc.data, pc.algo, pc.proof); err != nil {
+		return "", err
+	}
+	fmt.Printf("Chunk %d/%d prefetched from %s\n", i+1, fileInfo.TotalChunks, pc.peer)
+	return pc.peer, nil
+}
+
+// prefetchHitRate returns the share of prefetch lookups that found their
+// chunk, and the number of lookups.
+func prefetchHitRate() (float64, int64) {
+	lookups := prefetchLookups.Load()
+	if lookups == 0 {
+		return 0, 0
+	}
+	return float64(prefetchHits.Load()) / float64(lookups), lookups
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestPrefetchCache checks Take waits for a fetch in progress, a full
+// cache only makes room by discarding a finished chunk, and chunks past
+// the cache's age are discarded.
+func TestPrefetchCache(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	c := NewPrefetchCache(2, 30*time.Second)
+	c.now = func() time.Time { return now }
+	release := make(chan struct{})
+	fetchAfter := func(release chan struct{}, data string) func() ([]byte, string, []string, error) {
+		return func() ([]byte, string, []string, error) {
+			<-release
+			return []byte(data), "sha256", nil, nil
+		}
+	}
+	fetch := func(data string) func() ([]byte, string, []string, error) { return fetchAfter(release, data) }
+
+	if !c.Start(0, "p", fetch("zero")) || !c.Start(1, "p", fetch("one")) {
+		t.Fatal("prefetch not started")
+	}
+	if c.Start(1, "p", fetch("one")) {
+		t.Error("chunk 1 fetched twice")
+	}
+	if c.Start(2, "p", fetch("two")) {
+		t.Error("started a third fetch with both slots busy")
+	}
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+	if pc, ok := c.Take(0); !ok || string(pc.data) != "zero" || pc.peer != "p" {
+		t.Errorf("Take(0) = %+v, %v", pc, ok)
+	}
+	if _, ok := c.Take(0); ok {
+		t.Error("chunk 0 taken twice")
+	}
+
+	// Once chunk 1 is finished, chunk 3 may replace it while chunk 2 is
+	// still being fetched
+	c.mu.Lock()
+	one := c.chunks[1]
+	c.mu.Unlock()
+	<-one.done
+	releaseTwo := make(chan struct{})
+	if !c.Start(2, "p", fetchAfter(releaseTwo, "two")) || !c.Start(3, "p", fetch("three")) {
+		t.Fatal("prefetch not started with room")
+	}
+	if _, ok := c.Take(1); ok {
+		t.Error("chunk 1 kept past the capacity")
+	}
+	close(releaseTwo)
+	if pc, ok := c.Take(3); !ok || string(pc.data) != "three" {
+		t.Errorf("Take(3) = %+v, %v", pc, ok)
+	}
+
+	c.mu.Lock()
+	two := c.chunks[2]
+	c.mu.Unlock()
+	<-two.done
+	c.mu.Lock()
+	now = now.Add(31 * time.Second)
+	c.mu.Unlock()
+	if _, ok := c.Take(2); ok {
+		t.Error("expired chunk taken")
+	}
+
+	failing := func() ([]byte, string, []string, error) { return nil, "", nil, errors.New("refused") }
+	c.Start(4, "p", failing)
+	if _, ok := c.Take(4); ok {
+		t.Error("failed prefetch taken")
+	}
+	if _, ok := c.Take(5); ok {
+		t.Error("chunk never prefetched taken")
+	}
+}
+
+// TestDownload_Prefetch downloads a file sequentially and checks every
+// chunk after the first came from the prefetch cache, with the peer
+// asked for each chunk only once.
+func TestDownload_Prefetch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("P2P_SELECTOR", "")
+	t.Setenv("P2P_PARALLEL", "")
+	meta, content := chunkTestFile(t, 4*smallChunkSize+100)
+	peer, counts := startCountingPeer(t, meta.FileHash, memoryChunks(t, meta))
+
+	hits := prefetchHits.Load()
+	if err := downloadFromInfo(streamTestInfo(meta, peer), "downloaded.bin"); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if got, _ := os.ReadFile("downloaded.bin"); !bytes.Equal(got, content) {
+		t.Error("downloaded file differs")
+	}
+	if got := prefetchHits.Load() - hits; got != int64(meta.TotalChunks-1) {
+		t.Errorf("%d chunks from the prefetch cache, want %d", got, meta.TotalChunks-1)
+	}
+	for i := 0; i < meta.TotalChunks; i++ {
+		if n := counts.requests(i); n != 1 {
+			t.Errorf("chunk %d requested %d times", i, n)
+		}
+	}
+}
@@ -3,6 +3,13 @@ package main
 type Message struct{
 	Cmd 	  string  `json:"cmd"`
 	Args	[]string  `json:"args"`
+
+	// Stream asks for one response frame per item, ended by a "done" frame
+	Stream bool `json:"stream,omitempty"`
+
+	// Mux keeps the connection open after this request's response, after
+	// which requests and responses are multiplexed frames (common.Mux)
+	Mux bool `json:"mux,omitempty"`
 }
 
 type Response struct{
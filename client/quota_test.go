@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+// TestParseByteSize covers unit suffixes, decimals, case and bad input.
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]int64{
+		"0":      0,
+		"1024":   1024,
+		"512B":   512,
+		"1KB":    1024,
+		"10GB":   10 << 30,
+		"1.5MB":  3 << 19,
+		"2 tb":   2 << 40,
+		" 3mb  ": 3 << 20,
+	}
+	for in, want := range cases {
+		got, err := parseByteSize(in)
+		if err != nil {
+			t.Errorf("parseByteSize(%q): %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", in, got, want)
+		}
+	}
+
+	for _, bad := range []string{"", "GB", "ten", "-1KB", "5XB"} {
+		if _, err := parseByteSize(bad); err == nil {
+			t.Errorf("parseByteSize(%q) should fail", bad)
+		}
+	}
+}
+
+// TestFormatByteSize checks unit selection.
+func TestFormatByteSize(t *testing.T) {
+	cases := map[int64]string{
+		0:        "0 B",
+		512:      "512 B",
+		2048:     "2.00 KB",
+		10 << 30: "10.00 GB",
+	}
+	for in, want := range cases {
+		if got := formatByteSize(in); got != want {
+			t.Errorf("formatByteSize(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
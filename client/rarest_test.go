@@ -196,8 +196,9 @@ func TestChunkFile_MultiChunkFile(t *testing.T) {
 	tmp := t.TempDir()
 	filePath := filepath.Join(tmp, "twochunks.bin")
 
-	// Write 1.5× ChunkSize bytes to force exactly 2 chunks
-	size := ChunkSize + ChunkSize/2
+	// Write 1.5× the picked chunk size to force exactly 2 chunks
+	chunkSize := int(PickChunkSize(512 * 1024))
+	size := chunkSize + chunkSize/2
 	data := make([]byte, size)
 	for i := range data {
 		data[i] = byte(i % 256)
@@ -210,15 +211,18 @@ func TestChunkFile_MultiChunkFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if meta.ChunkSize != PickChunkSize(int64(size)) {
+		t.Fatalf("chunk size: want %d got %d", PickChunkSize(int64(size)), meta.ChunkSize)
+	}
 	if meta.TotalChunks != 2 {
 		t.Errorf("expected 2 chunks, got %d", meta.TotalChunks)
 	}
-	// First chunk should be full ChunkSize; second should be ChunkSize/2
-	if meta.Chunks[0].Size != ChunkSize {
-		t.Errorf("chunk 0 size: want %d got %d", ChunkSize, meta.Chunks[0].Size)
+	// First chunk should be a full chunk; second should be half of one
+	if meta.Chunks[0].Size != int64(chunkSize) {
+		t.Errorf("chunk 0 size: want %d got %d", chunkSize, meta.Chunks[0].Size)
 	}
-	if meta.Chunks[1].Size != int64(ChunkSize/2) {
-		t.Errorf("chunk 1 size: want %d got %d", ChunkSize/2, meta.Chunks[1].Size)
+	if meta.Chunks[1].Size != int64(chunkSize/2) {
+		t.Errorf("chunk 1 size: want %d got %d", chunkSize/2, meta.Chunks[1].Size)
 	}
 	t.Logf("✓ 2-chunk file: sizes %d + %d bytes", meta.Chunks[0].Size, meta.Chunks[1].Size)
 }
@@ -237,7 +241,7 @@ func TestValidateChunkHash_CorrectHash(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !validateChunkHash(data, meta.Chunks[0].Hash) {
+	if !validateChunkHash(data, "", meta.Chunks[0].Hash) {
 		t.Error("expected true for matching hash, got false")
 	}
 }
@@ -245,7 +249,7 @@ func TestValidateChunkHash_CorrectHash(t *testing.T) {
 // TestValidateChunkHash_WrongHash verifies mismatched hash returns false.
 func TestValidateChunkHash_WrongHash(t *testing.T) {
 	data := []byte("one thing")
-	if validateChunkHash(data, "0000000000000000000000000000000000000000000000000000000000000000") {
+	if validateChunkHash(data, "", "0000000000000000000000000000000000000000000000000000000000000000") {
 		t.Error("expected false for bad hash, got true")
 	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// uploadRate returns the upload limit in bytes per second from
+// P2P_UPLOAD_RATE. 0 (the default) means unlimited.
+func uploadRate() int {
+	r, err := strconv.Atoi(os.Getenv("P2P_UPLOAD_RATE"))
+	if err != nil || r < 0 {
+		return 0
+	}
+	return r
+}
+
+// throttledConn wraps a peer connection, holding writes to a byte rate and
+// counting what was sent.
+type throttledConn struct {
+	net.Conn
+	bucket  *LeakyBucket // nil = unlimited
+	written int64
+}
+
+// newThrottledConn limits conn to bytesPerSec, through its own bucket or
+// the shared one as P2P_UPLOAD_RATE_SCOPE says; 0 leaves it unlimited.
+func newThrottledConn(conn net.Conn, bytesPerSec int) *throttledConn {
+	return &throttledConn{Conn: conn, bucket: uploadBucket(bytesPerSec)}
+}
+
+// Write sends p in slices of up to uploadSliceSize, waiting on the bucket
+// before each one.
+func (c *throttledConn) Write(p []byte) (int, error) {
+	if c.bucket == nil {
+		n, err := c.Conn.Write(p)
+		c.written += int64(n)
+		return n, err
+	}
+
+	total := 0
+	for len(p) > 0 {
+		n := min(len(p), int(c.bucket.capacity))
+		c.bucket.Wait(n)
+		w, err := c.Conn.Write(p[:n])
+		total += w
+		c.written += int64(w)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// UploadStats counts what this peer has served to others.
+type UploadStats struct {
+	mu          sync.Mutex
+	TotalBytes  int64            `json:"total_bytes_uploaded"`
+	Connections int64            `json:"connections"`
+	BytesByPeer map[string]int64 `json:"bytes_by_peer"` // keyed by remote IP
+}
+
+var uploadStats = &UploadStats{BytesByPeer: make(map[string]int64)}
+
+// record adds one finished connection's byte count.
+func (s *UploadStats) record(remote net.Addr, bytes int64) {
+	host := remote.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.TotalBytes += bytes
+	s.Connections++
+	s.BytesByPeer[host] += bytes
+}
+
+// handleStats serves the upload counters and chunk cache counters as JSON on /stats.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	uploadStats.mu.Lock()
+	data, err := json.Marshal(struct {
+		*UploadStats
+		ChunkCache ChunkCacheStats `json:"chunk_cache"`
+	}{uploadStats, chunkCache.Stats()})
+	uploadStats.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// StartStatsServer serves /stats on addr in the background.
+func StartStatsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", handleStats)
+	go http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// uploadedSoFar returns the total bytes recorded in uploadStats.
+func uploadedSoFar() int64 {
+	uploadStats.mu.Lock()
+	defer uploadStats.mu.Unlock()
+	return uploadStats.TotalBytes
+}
+
+// TestUploadRate_Throughput serves a chunk with P2P_UPLOAD_RATE at 256 KB/s
+// and checks the measured throughput is close to the limit. The chunk is
+// several times the bucket's capacity, which goes out at once.
+func TestUploadRate_Throughput(t *testing.T) {
+	t.Chdir(t.TempDir())
+	const limit = 256 * 1024
+	t.Setenv("P2P_UPLOAD_RATE", "262144")
+
+	chunkDir := filepath.Join(ChunksDir, "ratehash")
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 384*1024)
+	if err := os.WriteFile(filepath.Join(chunkDir, "chunk_0.dat"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	peer := startTestPeer(t)
+
+	before := uploadedSoFar()
+	start := time.Now()
+	got, _, err := requestChunk(peer, "ratehash", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if len(got) != len(data) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(data))
+	}
+
+	// Stats are recorded once the peer's handler returns
+	var sent int64
+	for i := 0; i < 100; i++ {
+		if sent = uploadedSoFar() - before; sent > int64(len(data)) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sent <= int64(len(data)) {
+		t.Fatalf("upload stats recorded %d bytes, want more than the %d-byte payload", sent, len(data))
+	}
+
+	throughput := float64(sent) / elapsed.Seconds()
+	t.Logf("sent %d bytes in %v (%.1f KB/s)", sent, elapsed, throughput/1024)
+	if throughput > 1.3*limit {
+		t.Errorf("throughput %.1f KB/s exceeds the 256 KB/s limit", throughput/1024)
+	}
+	if throughput < 0.5*limit {
+		t.Errorf("throughput %.1f KB/s is far below the 256 KB/s limit", throughput/1024)
+	}
+}
+
+// TestUploadRate_Unlimited verifies no limiter is installed by default.
+func TestUploadRate_Unlimited(t *testing.T) {
+	t.Setenv("P2P_UPLOAD_RATE", "")
+	if r := uploadRate(); r != 0 {
+		t.Fatalf("uploadRate() = %d, want 0", r)
+	}
+	if c := newThrottledConn(nil, 0); c.bucket != nil {
+		t.Error("rate 0 should leave the connection unlimited")
+	}
+}
+
+// TestStatsHandler verifies /stats reports the recorded counters.
+func TestStatsHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleStats(rec, httptest.NewRequest("GET", "/stats", nil))
+
+	var stats struct {
+		TotalBytes  int64            `json:"total_bytes_uploaded"`
+		BytesByPeer map[string]int64 `json:"bytes_by_peer"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("bad /stats body %q: %v", rec.Body.String(), err)
+	}
+	if stats.TotalBytes != uploadedSoFar() {
+		t.Errorf("total = %d, want %d", stats.TotalBytes, uploadedSoFar())
+	}
+}
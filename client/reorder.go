@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ReorderBuffer takes chunks in whatever order parallel fetches finish
+// and writes them to an io.Writer in index order, holding back any that
+// arrive ahead of the next one due.
+type ReorderBuffer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	w       io.Writer
+	next    int
+	limit   int
+	pending map[int][]byte
+	err     error
+}
+
+// NewReorderBuffer returns a buffer writing to w from chunk 0. WriteChunk
+// blocks while its chunk is limit or more chunks ahead of the next one
+// due, which caps how much is held in memory; limit <= 0 means no cap.
+func NewReorderBuffer(w io.Writer, limit int) *ReorderBuffer {
+	b := &ReorderBuffer{w: w, limit: limit, pending: make(map[int][]byte)}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// WriteChunk adds chunk idx and writes out every chunk now in sequence.
+// It is safe to call from several goroutines. Once a write to w fails or
+// the buffer is aborted, every call returns that error.
+func (b *ReorderBuffer) WriteChunk(idx int, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.err == nil && b.limit > 0 && idx >= b.next+b.limit {
+		b.cond.Wait()
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if _, dup := b.pending[idx]; dup || idx < b.next {
+		return fmt.Errorf("chunk %d written twice", idx)
+	}
+
+	b.pending[idx] = data
+	for {
+		data, ok := b.pending[b.next]
+		if !ok {
+			break
+		}
+		delete(b.pending, b.next)
+		if _, err := b.w.Write(data); err != nil {
+			b.err = fmt.Errorf("failed to write chunk %d: %v", b.next, err)
+			b.cond.Broadcast()
+			return b.err
+		}
+		b.next++
+	}
+	b.cond.Broadcast()
+	return nil
+}
+
+// Abort fails the buffer with err, releasing any WriteChunk calls waiting
+// for room, e.g. once the chunk they wait behind can't be fetched.
+func (b *ReorderBuffer) Abort(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+}
+
+// Written returns how many chunks have been written to w.
+func (b *ReorderBuffer) Written() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.next
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestReorderBuffer_OutOfOrder writes chunks 3, 1, 2, 0 and checks nothing
+// reaches the writer until chunk 0 does, then all four in order.
+func TestReorderBuffer_OutOfOrder(t *testing.T) {
+	var out bytes.Buffer
+	b := NewReorderBuffer(&out, 0)
+
+	for _, i := range []int{3, 1, 2} {
+		if err := b.WriteChunk(i, []byte(fmt.Sprintf("<%d>", i))); err != nil {
+			t.Fatalf("WriteChunk(%d): %v", i, err)
+		}
+		if out.Len() != 0 {
+			t.Fatalf("wrote %q before chunk 0 arrived", out.String())
+		}
+	}
+	if err := b.WriteChunk(0, []byte("<0>")); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "<0><1><2><3>" || b.Written() != 4 {
+		t.Errorf("output = %q, written = %d", out.String(), b.Written())
+	}
+	if err := b.WriteChunk(2, []byte("<2>")); err == nil {
+		t.Error("rewriting chunk 2 succeeded")
+	}
+}
+
+// TestReorderBuffer_Concurrent writes chunks from many goroutines in
+// reverse and checks they come out in order.
+func TestReorderBuffer_Concurrent(t *testing.T) {
+	var out bytes.Buffer
+	b := NewReorderBuffer(&out, 0)
+	var want bytes.Buffer
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&want, "<%d>", i)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(50-i) * 100 * time.Microsecond)
+			b.WriteChunk(i, []byte(fmt.Sprintf("<%d>", i)))
+		}(i)
+	}
+	wg.Wait()
+	if out.String() != want.String() {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+// TestReorderBuffer_Limit checks a chunk too far ahead waits for the ones
+// before it, and that Abort releases it.
+func TestReorderBuffer_Limit(t *testing.T) {
+	var out bytes.Buffer
+	b := NewReorderBuffer(&out, 2)
+
+	done := make(chan error, 1)
+	go func() { done <- b.WriteChunk(3, []byte("<3>")) }()
+	select {
+	case err := <-done:
+		t.Fatalf("chunk 3 accepted with nothing written: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.WriteChunk(0, []byte("<0>"))
+	b.WriteChunk(1, []byte("<1>"))
+	if err := <-done; err != nil {
+		t.Fatalf("chunk 3 after 0 and 1: %v", err)
+	}
+
+	// Chunk 2 never comes, so chunk 5 waits until the buffer is aborted
+	go func() { done <- b.WriteChunk(5, []byte("<5>")) }()
+	lost := errors.New("peer went away")
+	time.Sleep(10 * time.Millisecond)
+	b.Abort(lost)
+	if err := <-done; err != lost {
+		t.Errorf("waiting write returned %v, want %v", err, lost)
+	}
+	if out.String() != "<0><1>" {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestReorderBuffer_WriteError(t *testing.T) {
+	b := NewReorderBuffer(failingWriter{}, 0)
+	if err := b.WriteChunk(1, []byte("x")); err != nil {
+		t.Fatalf("buffered chunk: %v", err)
+	}
+	if err := b.WriteChunk(0, []byte("x")); err == nil {
+		t.Fatal("write error not returned")
+	}
+	if err := b.WriteChunk(2, []byte("x")); err == nil {
+		t.Error("write after a failed write succeeded")
+	}
+}
+
+// TestStreamChunks_Parallel streams a file from two peers with several
+// workers and checks the output is byte-identical to the original.
+func TestStreamChunks_Parallel(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("P2P_PARALLEL", "3")
+	meta, content := chunkTestFile(t, 5*ChunkSize+100)
+	peers := []string{startTestPeer(t), startTestPeer(t)}
+
+	var out bytes.Buffer
+	if err := streamChunks(context.Background(), streamTestInfo(meta, peers...), &out); err != nil {
+		t.Fatalf("streamChunks: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Errorf("streamed %d bytes, want %d identical bytes", out.Len(), len(content))
+	}
+}
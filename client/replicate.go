@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"time"
+)
+
+// pushTimeout bounds one push_chunk exchange.
+const pushTimeout = 30 * time.Second
+
+// ReplicateFile copies every local chunk of fileName in groupID to
+// targetUserID's peer server with push_chunk, then registers the target as
+// a seeder, so the file survives us going offline. It returns the number
+// of chunks pushed.
+func ReplicateFile(groupID, fileName, targetUserID string) (int, error) {
+	info, err := queryFileInfo(groupID, fileName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get file info: %v", err)
+	}
+	meta, err := loadChunkMetadata(info.FileHash)
+	if err != nil {
+		return 0, fmt.Errorf("no local copy of '%s' to replicate", fileName)
+	}
+
+	resp := SendToTracker(Message{Cmd: "get_peer_address", Args: []string{groupID, State.UserID, targetUserID}})
+	data, ok := resp.Data.(map[string]interface{})
+	if resp.Status != "ok" || !ok {
+		return 0, fmt.Errorf("cannot reach %s: %v", targetUserID, resp.Data)
+	}
+	peerAddr, _ := data["peer_addr"].(string)
+
+	chunkDir := filepath.Join(ChunksDir, meta.FileHash)
+	for i := 0; i < meta.TotalChunks; i++ {
+		chunk, err := os.ReadFile(filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i)))
+		if err != nil {
+			return i, fmt.Errorf("chunk %d is missing locally: %v", i, err)
+		}
+		// The first chunk carries the metadata the target validates the rest against
+		var m *ChunkMetadata
+		if i == 0 {
+			m = meta
+		}
+		req := PeerRequest{Cmd: "push_chunk", FileHash: meta.FileHash, PieceIdx: i, Data: chunk, Metadata: m,
+			GroupID: groupID, FileName: fileName, Token: peerToken(meta.FileHash)}
+		if err := pushChunk(peerAddr, req); err != nil {
+			return i, fmt.Errorf("pushing chunk %d to %s: %v", i, targetUserID, err)
+		}
+		transfers.Record(DirectionUp, meta.FileHash, peerAddr, int64(len(chunk)), time.Now())
+	}
+
+	resp = SendToTracker(Message{Cmd: "add_seeder", Args: []string{groupID, fileName, targetUserID}})
+	if resp.Status != "ok" {
+		return meta.TotalChunks, fmt.Errorf("chunks copied, but registering %s as a seeder failed: %v", targetUserID, resp.Data)
+	}
+	return meta.TotalChunks, nil
+}
+
+// pushChunk sends req, a push_chunk request, to peerAddr.
+func pushChunk(peerAddr string, req PeerRequest) error {
+	conn, err := net.DialTimeout("tcp", peerAddr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	setKeepalive(conn)
+	conn.SetDeadline(time.Now().Add(pushTimeout))
+
+	if err := common.Send(conn, req); err != nil {
+		return err
+	}
+	var resp PeerResponse
+	if err := common.Recv(conn, &resp); err != nil {
+		return err
+	}
+	if resp.Status != "ok" {
+		return fmt.Errorf("peer answered %q", resp.Status)
+	}
+	return nil
+}
+
+// handlePushChunk stores a chunk another member pushed to us with
+// replicate_file. It writes to our disk and is served from there, so with
+// P2P_PEER_SECRET set the pusher must present its download token for the
+// file, and metadata it sends must be the tracker's for the file it names.
+func handlePushChunk(conn net.Conn, req PeerRequest) {
+	if !authorizePeer(req) {
+		common.Send(conn, PeerResponse{Status: "unauthorized"})
+		return
+	}
+	if err := storePushedChunk(ChunksDir, req, trackerListsPush); err != nil {
+		fmt.Printf("Warning: rejected pushed chunk %d of %.16s: %v\n", req.PieceIdx, req.FileHash, err)
+		common.Send(conn, PeerResponse{Status: "error"})
+		return
+	}
+	common.Send(conn, PeerResponse{Status: "ok"})
+	go announceChunk(req.FileHash, req.PieceIdx)
+}
+
+// storePushedChunk validates a pushed chunk against the file's metadata,
+// from root/<hash>/metadata.json or, if we don't have the file yet, sent
+// with the push and vouched for by listed, and writes it to root/<hash>/.
+func storePushedChunk(root string, req PeerRequest, listed func(PeerRequest) error) error {
+	if b, err := hex.DecodeString(req.FileHash); err != nil || len(b) != 32 {
+		return errors.New("invalid file hash")
+	}
+	chunkDir := filepath.Join(root, req.FileHash)
+	metaPath := filepath.Join(chunkDir, "metadata.json")
+
+	var meta ChunkMetadata
+	saveMeta := false
+	if data, err := os.ReadFile(metaPath); err == nil {
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return err
+		}
+	} else if req.Metadata != nil {
+		meta, saveMeta = *req.Metadata, true
+		if meta.FileHash != req.FileHash || meta.TotalChunks != len(meta.Chunks) {
+			return errors.New("metadata doesn't match the file")
+		}
+		if err := listed(req); err != nil {
+			return err
+		}
+	} else {
+		return errors.New("no metadata for the file")
+	}
+
+	if req.PieceIdx < 0 || req.PieceIdx >= len(meta.Chunks) {
+		return errors.New("chunk index out of range")
+	}
+	if c := meta.Chunks[req.PieceIdx]; !validateChunkHash(req.Data, c.HashAlgorithm, c.Hash) {
+		return errors.New("chunk hash mismatch")
+	}
+
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", req.PieceIdx))
+	// Dot-prefixed so get_bitfield never advertises a half-written chunk
+	tmp := filepath.Join(chunkDir, "."+filepath.Base(path)+".part")
+	if err := os.WriteFile(tmp, req.Data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if saveMeta {
+		resumeServing(chunkDir)
+		data, err := json.MarshalIndent(&meta, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(metaPath, data, 0644)
+	}
+	return nil
+}
+
+// trackerListsPush checks with the tracker that req's file is listed under
+// its name in its group, which we belong to, with the chunks of the
+// metadata pushed with it.
+func trackerListsPush(req PeerRequest) error {
+	if req.GroupID == "" || req.FileName == "" {
+		return errors.New("push names no group and file")
+	}
+	resp := QueryTracker(Message{Cmd: "get_file_info", Args: []string{req.GroupID, req.FileName, State.UserID}})
+	data, ok := resp.Data.(map[string]interface{})
+	if resp.Status != "ok" || !ok {
+		return fmt.Errorf("tracker doesn't list %s in %s: %v", req.FileName, req.GroupID, resp.Data)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var info FileInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return err
+	}
+	if info.FileHash != req.FileHash || len(info.Chunks) != len(req.Metadata.Chunks) {
+		return errors.New("pushed file isn't the one the tracker lists")
+	}
+	for i, c := range info.Chunks {
+		if c.Hash != req.Metadata.Chunks[i].Hash {
+			return fmt.Errorf("pushed metadata's chunk %d isn't the tracker's", i)
+		}
+	}
+	return nil
+}
+
+// handleReplicateFile takes the tracker's replicate_to_all request. The
+// file is downloaded into <groupID>/ and seeded in the background, and the
+// tracker told with replication_failed if that doesn't work out.
+func handleReplicateFile(conn net.Conn, req PeerRequest) {
+	hash, err := hex.DecodeString(req.FileHash)
+	if err != nil || len(hash) != 32 || State.UserID == "" ||
+		!filepath.IsLocal(req.GroupID) || filepath.Base(req.FileName) != req.FileName || !filepath.IsLocal(req.FileName) {
+		common.Send(conn, PeerResponse{Status: "error"})
+		return
+	}
+	// It writes to our disk, so only a tracker may ask
+	if !authorizeReplicate(conn, req) {
+		common.Send(conn, PeerResponse{Status: "unauthorized"})
+		return
+	}
+	common.Send(conn, PeerResponse{Status: "ok"})
+	go func() {
+		if err := replicateFromGroup(req.GroupID, req.FileName, req.FileHash); err != nil {
+			fmt.Printf("Warning: replicating '%s' from %s failed: %v\n", req.FileName, req.GroupID, err)
+			SendToTracker(Message{Cmd: "replication_failed", Args: []string{req.GroupID, req.FileName, State.UserID}})
+		}
+	}()
+}
+
+// authorizeReplicate reports whether a replicate_file request comes from a
+// tracker. With P2P_PEER_SECRET set it must carry a replicate token signed
+// for us and the file; without, it must come from a configured tracker's
+// host.
+func authorizeReplicate(conn net.Conn, req PeerRequest) bool {
+	if secret := common.PeerSecret(); secret != nil {
+		user, err := common.VerifyPeerToken(secret, req.Token, common.ReplicateTokenSubject(req.FileHash), time.Now())
+		return err == nil && user == State.UserID
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	remote := net.ParseIP(host)
+	for _, entry := range State.TrackerAddrs {
+		trackerHost, _, err := net.SplitHostPort(trackerEndpoint(entry).Addr)
+		if err != nil {
+			continue
+		}
+		ips, err := net.LookupIP(trackerHost)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Equal(remote) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replicateFromGroup downloads fileName of groupID, unless its chunks are
+// here already, and registers us as a seeder. fileHash is the content the
+// tracker asked us to seed; a file changed since isn't registered.
+func replicateFromGroup(groupID, fileName, fileHash string) error {
+	if isUnshared(fileHash) {
+		return errors.New("stop_sharing was run for the file here")
+	}
+	if !haveChunks(fileHash) {
+		if err := os.MkdirAll(groupID, 0755); err != nil {
+			return err
+		}
+		if err := DownloadFile(groupID, fileName, filepath.Join(groupID, fileName)); err != nil {
+			return err
+		}
+		if !haveChunks(fileHash) {
+			return errors.New("the file changed since replication was asked for")
+		}
+	}
+	resp := SendToTracker(Message{Cmd: "add_seeder", Args: []string{groupID, fileName, State.UserID}})
+	if resp.Status != "ok" {
+		return fmt.Errorf("add_seeder: %v", resp.Data)
+	}
+	return nil
+}
+
+// haveChunks reports whether the metadata and every chunk of fileHash are
+// stored here.
+func haveChunks(fileHash string) bool {
+	meta, err := loadChunkMetadata(fileHash)
+	return err == nil && chunksStored(filepath.Join(ChunksDir, fileHash), meta.TotalChunks)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startPushPeer runs a peer server storing pushed chunks under root.
+func startPushPeer(t *testing.T, root string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var req PeerRequest
+				if err := common.Recv(c, &req); err != nil {
+					return
+				}
+				switch req.Cmd {
+				case "push_chunk":
+					if err := storePushedChunk(root, req, trackerListsPush); err != nil {
+						common.Send(c, PeerResponse{Status: "error"})
+						return
+					}
+					common.Send(c, PeerResponse{Status: "ok"})
+				default:
+					common.Send(c, PeerResponse{Status: "error"})
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// TestReplicateFile_PushesChunks replicates a local file to a second peer
+// and checks the peer stored every chunk, and that it was registered as a
+// seeder.
+func TestReplicateFile_PushesChunks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useTestCache(t, 0)
+	meta, content := chunkTestFile(t, 2*ChunkSize+100)
+
+	targetRoot := t.TempDir()
+	target := startPushPeer(t, targetRoot)
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"get_file_info":    {"ok", map[string]interface{}{"file_name": "orig.bin", "file_hash": meta.FileHash, "chunks": meta.Chunks}},
+		"get_peer_address": {"ok", map[string]interface{}{"user_id": "bob", "peer_addr": target}},
+		"add_seeder":       {"ok", "now seeding"},
+	})
+	useTestNetwork(t, tracker, nil)
+
+	n, err := ReplicateFile("g1", "orig.bin", "bob")
+	if err != nil || n != meta.TotalChunks {
+		t.Fatalf("ReplicateFile = %d, %v", n, err)
+	}
+
+	var got []byte
+	for i := 0; i < meta.TotalChunks; i++ {
+		chunk, err := os.ReadFile(filepath.Join(targetRoot, meta.FileHash, fmt.Sprintf("chunk_%d.dat", i)))
+		if err != nil {
+			t.Fatalf("chunk %d on target: %v", i, err)
+		}
+		got = append(got, chunk...)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("replicated chunks don't reassemble the file")
+	}
+	if _, err := os.Stat(filepath.Join(targetRoot, meta.FileHash, "metadata.json")); err != nil {
+		t.Errorf("target has no metadata: %v", err)
+	}
+	// The target checks the first push's metadata with the tracker
+	if c := cmds(); len(c) != 4 || c[2] != "get_file_info" || c[3] != "add_seeder" {
+		t.Errorf("tracker commands = %v", c)
+	}
+}
+
+// TestStorePushedChunk_Rejects checks pushes that don't match the file's
+// metadata are refused and nothing is written for them.
+func TestStorePushedChunk_Rejects(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*ChunkSize+100)
+	chunk0, err := os.ReadFile(filepath.Join(ChunksDir, meta.FileHash, "chunk_0.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+
+	bad := map[string]PeerRequest{
+		"no metadata":   {FileHash: meta.FileHash, PieceIdx: 0, Data: chunk0},
+		"path in hash":  {FileHash: "../" + meta.FileHash, PieceIdx: 0, Data: chunk0, Metadata: meta},
+		"wrong data":    {FileHash: meta.FileHash, PieceIdx: 1, Data: chunk0, Metadata: meta},
+		"out of range":  {FileHash: meta.FileHash, PieceIdx: meta.TotalChunks, Data: chunk0, Metadata: meta},
+		"other file":    {FileHash: "ab" + meta.FileHash[2:], PieceIdx: 0, Data: chunk0, Metadata: meta},
+		"negative":      {FileHash: meta.FileHash, PieceIdx: -1, Data: chunk0, Metadata: meta},
+		"truncated":     {FileHash: meta.FileHash, PieceIdx: 0, Data: chunk0[:10], Metadata: meta},
+		"not hex":       {FileHash: "zz", PieceIdx: 0, Data: chunk0, Metadata: meta},
+		"empty request": {},
+	}
+	for name, req := range bad {
+		if err := storePushedChunk(root, req, listedAll); err == nil {
+			t.Errorf("%s: push accepted", name)
+		}
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("rejected pushes wrote %v", entries)
+	}
+
+	// Once the metadata is stored, later chunks are checked against it
+	if err := storePushedChunk(root, PeerRequest{FileHash: meta.FileHash, PieceIdx: 0, Data: chunk0, Metadata: meta}, listedAll); err != nil {
+		t.Fatalf("valid push: %v", err)
+	}
+	if err := storePushedChunk(root, PeerRequest{FileHash: meta.FileHash, PieceIdx: 1, Data: chunk0}, listedAll); err == nil {
+		t.Error("chunk 0's data accepted as chunk 1")
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, meta.FileHash))
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "chunk_") && !strings.HasSuffix(e.Name(), ".dat") {
+			t.Errorf("push left %s, which get_bitfield would count", e.Name())
+		}
+	}
+}
+
+// listedAll stands in for the tracker, vouching for any pushed metadata.
+func listedAll(PeerRequest) error { return nil }
+
+// TestStorePushedChunk_Unlisted checks a first push's metadata is refused
+// unless the tracker lists the file, with the same chunks, in the group
+// the push names.
+func TestStorePushedChunk_Unlisted(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useTestCache(t, 0)
+	meta, _ := chunkTestFile(t, 2*ChunkSize+100)
+	chunk0, err := os.ReadFile(filepath.Join(ChunksDir, meta.FileHash, "chunk_0.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := append([]ChunkInfo(nil), meta.Chunks...)
+	other[1].Hash = strings.Repeat("0", len(other[1].Hash))
+
+	cases := map[string]Response{
+		"not listed":   {"error", "file not found"},
+		"other hash":   {"ok", map[string]interface{}{"file_hash": "ab" + meta.FileHash[2:], "chunks": meta.Chunks}},
+		"other chunks": {"ok", map[string]interface{}{"file_hash": meta.FileHash, "chunks": other}},
+	}
+	for name, resp := range cases {
+		tracker, _ := startRecordingTracker(t, map[string]Response{"get_file_info": resp})
+		useTestNetwork(t, tracker, nil)
+		root := t.TempDir()
+		req := PeerRequest{FileHash: meta.FileHash, PieceIdx: 0, Data: chunk0, Metadata: meta, GroupID: "g1", FileName: "orig.bin"}
+		if err := storePushedChunk(root, req, trackerListsPush); err == nil {
+			t.Errorf("%s: push accepted", name)
+		}
+		if entries, _ := os.ReadDir(root); len(entries) != 0 {
+			t.Errorf("%s: refused push wrote %v", name, entries)
+		}
+	}
+}
+
+// TestHandlePushChunk_NeedsToken checks that with P2P_PEER_SECRET set a
+// push without a download token for the file is refused.
+func TestHandlePushChunk_NeedsToken(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv(common.PeerSecretEnv, "s3cret")
+	meta, _ := chunkTestFile(t, 100)
+	chunk0, err := os.ReadFile(filepath.Join(ChunksDir, meta.FileHash, "chunk_0.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		handlePushChunk(server, PeerRequest{Cmd: "push_chunk", FileHash: meta.FileHash, Data: chunk0, Metadata: meta})
+	}()
+	var resp PeerResponse
+	if err := common.Recv(client, &resp); err != nil || resp.Status != "unauthorized" {
+		t.Errorf("push without token = %q, %v", resp.Status, err)
+	}
+}
+
+// waitForCommand waits up to a second for cmds to include cmd.
+func waitForCommand(t *testing.T, cmds func() []string, cmd string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if slices.Contains(cmds(), cmd) {
+			return
+		}
+	}
+	t.Fatalf("tracker got %v, no %s", cmds(), cmd)
+}
+
+// TestHandleReplicateFile checks the tracker's replicate_file is refused
+// unless well-formed, and otherwise accepted and answered with add_seeder
+// for a file we hold, and with replication_failed for one that can't be
+// downloaded.
+func TestHandleReplicateFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*smallChunkSize+100)
+	peer := startTestPeer(t)
+	saved := State.UserID
+	State.UserID = "bob"
+	t.Cleanup(func() { State.UserID = saved })
+
+	for _, req := range []PeerRequest{
+		{Cmd: "replicate_file", FileHash: "nothex", GroupID: "g1", FileName: "orig.bin"},
+		{Cmd: "replicate_file", FileHash: meta.FileHash, GroupID: "../g1", FileName: "orig.bin"},
+		{Cmd: "replicate_file", FileHash: meta.FileHash, GroupID: "g1", FileName: "sub/orig.bin"},
+	} {
+		if status := peerRequest(t, peer, req); status != "error" {
+			t.Errorf("%+v: %s", req, status)
+		}
+	}
+
+	tracker, cmds := startRecordingTracker(t, map[string]Response{"add_seeder": {"ok", "seeding"}})
+	useTestNetwork(t, tracker, nil)
+	req := PeerRequest{Cmd: "replicate_file", FileHash: meta.FileHash, GroupID: "g1", FileName: "orig.bin"}
+	if status := peerRequest(t, peer, req); status != "ok" {
+		t.Fatalf("replicate_file: %s", status)
+	}
+	waitForCommand(t, cmds, "add_seeder")
+	if slices.Contains(cmds(), "get_file_info") {
+		t.Error("file held here downloaded again")
+	}
+
+	tracker, cmds = startRecordingTracker(t, map[string]Response{"get_file_info": {"error", "file not found"}})
+	useTestNetwork(t, tracker, nil)
+	req.FileHash = strings.Repeat("ab", 32)
+	if status := peerRequest(t, peer, req); status != "ok" {
+		t.Fatalf("replicate_file: %s", status)
+	}
+	waitForCommand(t, cmds, "replication_failed")
+}
+
+// TestHandleReplicateFile_Unauthorized checks replicate_file is refused
+// from a host that isn't a tracker's and, with P2P_PEER_SECRET set,
+// without a replicate token signed for us.
+func TestHandleReplicateFile_Unauthorized(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*smallChunkSize+100)
+	peer := startTestPeer(t)
+	saved := State.UserID
+	State.UserID = "bob"
+	t.Cleanup(func() { State.UserID = saved })
+	req := PeerRequest{Cmd: "replicate_file", FileHash: meta.FileHash, GroupID: "g1", FileName: "orig.bin"}
+
+	useTestNetwork(t, "192.0.2.1:9000", nil)
+	if status := peerRequest(t, peer, req); status != "unauthorized" {
+		t.Errorf("from a host with no tracker: %s", status)
+	}
+
+	tracker, cmds := startRecordingTracker(t, map[string]Response{"add_seeder": {"ok", "seeding"}})
+	useTestNetwork(t, tracker, nil)
+	secret := []byte("s3cret")
+	t.Setenv(common.PeerSecretEnv, string(secret))
+	expiry := time.Now().Add(common.PeerTokenTTL)
+	for name, token := range map[string]string{
+		"no token":       "",
+		"download token": common.SignPeerToken(secret, "bob", meta.FileHash, expiry),
+		"another user's": common.SignPeerToken(secret, "carol", common.ReplicateTokenSubject(meta.FileHash), expiry),
+	} {
+		req.Token = token
+		if status := peerRequest(t, peer, req); status != "unauthorized" {
+			t.Errorf("%s: %s", name, status)
+		}
+	}
+	req.Token = common.SignPeerToken(secret, "bob", common.ReplicateTokenSubject(meta.FileHash), expiry)
+	if status := peerRequest(t, peer, req); status != "ok" {
+		t.Fatalf("signed replicate_file: %s", status)
+	}
+	waitForCommand(t, cmds, "add_seeder")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy says how SendToTracker retries a failing tracker before
+// moving on to the next: up to MaxAttempts tries in all, waiting Backoff
+// before the first retry and twice as long before each one after.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+var (
+	// Reads are safe to repeat, so they retry harder than writes, which
+	// the tracker may already have applied when the connection broke
+	readRetryPolicy  = RetryPolicy{MaxAttempts: 3, Backoff: 200 * time.Millisecond}
+	writeRetryPolicy = RetryPolicy{MaxAttempts: 2, Backoff: 500 * time.Millisecond}
+
+	// retryPolicies overrides the policy for single commands
+	retryPolicies = map[string]RetryPolicy{}
+)
+
+// retryPolicyFor returns the policy for cmd. get_ and list_ commands are
+// reads; everything else is treated as a write.
+func retryPolicyFor(cmd string) RetryPolicy {
+	if p, ok := retryPolicies[cmd]; ok {
+		return p
+	}
+	if strings.HasPrefix(cmd, "get_") || strings.HasPrefix(cmd, "list_") {
+		return readRetryPolicy
+	}
+	return writeRetryPolicy
+}
+
+// tryTrackerWithRetry sends msg to addr, retrying per p. A tracker that
+// fails every attempt is marked down; one that answers is marked up.
+func tryTrackerWithRetry(addr string, msg Message, p RetryPolicy) (Response, bool) {
+	wait := p.Backoff
+	for attempt := 1; ; attempt++ {
+		if resp, ok := tryTracker(addr, msg); ok {
+			markTrackerUp(addr)
+			return resp, true
+		}
+		if attempt >= p.MaxAttempts {
+			markTrackerDown(addr, time.Now())
+			return Response{}, false
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// trackerDownFor is how long a tracker that ran out of retries is tried
+// only after every other one.
+const trackerDownFor = 30 * time.Second
+
+var (
+	downMu       sync.Mutex
+	downTrackers = make(map[string]time.Time) // addr -> down until
+)
+
+func markTrackerDown(addr string, now time.Time) {
+	downMu.Lock()
+	defer downMu.Unlock()
+	downTrackers[addr] = now.Add(trackerDownFor)
+}
+
+func markTrackerUp(addr string) {
+	downMu.Lock()
+	defer downMu.Unlock()
+	delete(downTrackers, addr)
+}
+
+func trackerDown(addr string, now time.Time) bool {
+	downMu.Lock()
+	defer downMu.Unlock()
+	until, ok := downTrackers[addr]
+	return ok && now.Before(until)
+}
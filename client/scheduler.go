@@ -0,0 +1,243 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SchedulerDir holds one scheduler snapshot per running client process,
+// for scheduler_status.
+const SchedulerDir = ".scheduler"
+
+// defaultGlobalWorkers is the default for P2P_GLOBAL_WORKERS.
+const defaultGlobalWorkers = 4
+
+// Download priorities: files the user asked for go ahead of queued
+// download_all work.
+const (
+	PriorityBackground = 0
+	PriorityUser       = 1
+)
+
+// DownloadJob is one file download waiting for, or holding, a worker.
+type DownloadJob struct {
+	GroupID  string `json:"group_id"`
+	FileName string `json:"file_name"`
+	DestPath string `json:"dest_path"`
+	Priority int    `json:"priority"`
+}
+
+type queuedJob struct {
+	job  DownloadJob
+	seq  uint64 // submission order, to keep equal priorities first come first served
+	done chan error
+}
+
+// jobQueue is a heap of queued jobs, highest priority first.
+type jobQueue []*queuedJob
+
+func (q jobQueue) Len() int { return len(q) }
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].job.Priority != q[j].job.Priority {
+		return q[i].job.Priority > q[j].job.Priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q jobQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *jobQueue) Push(x interface{}) { *q = append(*q, x.(*queuedJob)) }
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	j := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return j
+}
+
+// DownloadScheduler runs file downloads from every group through one
+// queue, at most workers at a time, so a download_all across many groups
+// doesn't open every download against the tracker at once.
+type DownloadScheduler struct {
+	mu      sync.Mutex
+	workers int
+	run     func(DownloadJob) error
+	queue   jobQueue
+	active  map[uint64]DownloadJob
+	seq     uint64
+
+	publishOnce sync.Once
+}
+
+// NewDownloadScheduler returns a scheduler running up to workers downloads
+// at once with run.
+func NewDownloadScheduler(workers int, run func(DownloadJob) error) *DownloadScheduler {
+	return &DownloadScheduler{workers: workers, run: run, active: make(map[uint64]DownloadJob)}
+}
+
+var downloadScheduler = NewDownloadScheduler(envLimit("P2P_GLOBAL_WORKERS", defaultGlobalWorkers), func(j DownloadJob) error {
+	return downloadAndSeed(j.GroupID, j.FileName, j.DestPath)
+})
+
+// Submit queues job and returns a channel that receives its result.
+func (s *DownloadScheduler) Submit(job DownloadJob) <-chan error {
+	done := make(chan error, 1)
+	s.mu.Lock()
+	s.seq++
+	heap.Push(&s.queue, &queuedJob{job: job, seq: s.seq, done: done})
+	s.dispatch()
+	s.mu.Unlock()
+	return done
+}
+
+// dispatch starts queued jobs while workers are free. Caller must hold mu.
+func (s *DownloadScheduler) dispatch() {
+	for len(s.active) < s.workers && s.queue.Len() > 0 {
+		q := heap.Pop(&s.queue).(*queuedJob)
+		s.active[q.seq] = q.job
+		go func() {
+			err := s.run(q.job)
+			s.mu.Lock()
+			delete(s.active, q.seq)
+			s.dispatch()
+			s.mu.Unlock()
+			q.done <- err
+		}()
+	}
+}
+
+// SchedulerStatus is what scheduler_status shows for one process.
+type SchedulerStatus struct {
+	PID     int           `json:"pid"`
+	Workers int           `json:"workers"`
+	Queued  int           `json:"queued"`
+	Active  []DownloadJob `json:"active"`
+}
+
+// Status reports the queue depth and the downloads running now.
+func (s *DownloadScheduler) Status() SchedulerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := SchedulerStatus{PID: os.Getpid(), Workers: s.workers, Queued: s.queue.Len()}
+	for _, j := range s.active {
+		st.Active = append(st.Active, j)
+	}
+	sort.Slice(st.Active, func(i, j int) bool {
+		a, b := st.Active[i], st.Active[j]
+		if a.GroupID != b.GroupID {
+			return a.GroupID < b.GroupID
+		}
+		return a.FileName < b.FileName
+	})
+	return st
+}
+
+// StartPublishing writes this process's status to SchedulerDir every
+// second while it has downloads queued or running.
+func (s *DownloadScheduler) StartPublishing() {
+	s.publishOnce.Do(func() {
+		publishSnapshots(SchedulerDir, func() (interface{}, bool) {
+			st := s.Status()
+			return st, st.Queued > 0 || len(st.Active) > 0
+		})
+	})
+}
+
+// readSchedulerStatus collects the status of every client process with
+// downloads queued or running.
+func readSchedulerStatus(now time.Time) ([]SchedulerStatus, error) {
+	var all []SchedulerStatus
+	err := readSnapshots(SchedulerDir, now, func(data []byte) {
+		var st SchedulerStatus
+		if json.Unmarshal(data, &st) == nil {
+			all = append(all, st)
+		}
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].PID < all[j].PID })
+	return all, err
+}
+
+// printSchedulerStatus writes the scheduler_status report.
+func printSchedulerStatus(w io.Writer, all []SchedulerStatus) {
+	if len(all) == 0 {
+		fmt.Fprintln(w, "No downloads queued or running")
+		return
+	}
+	for _, st := range all {
+		fmt.Fprintf(w, "Process %d: %d of %d workers busy, %d queued\n", st.PID, len(st.Active), st.Workers, st.Queued)
+		for _, j := range st.Active {
+			fmt.Fprintf(w, "  ↓ %s/%s\n", j.GroupID, j.FileName)
+		}
+	}
+}
+
+// DownloadAll queues every file in groupIDs as background work, saving
+// each under a directory named after its group, and waits for them all.
+// Files already there are skipped.
+func DownloadAll(s *DownloadScheduler, groupIDs []string) (done, failed int) {
+	type pending struct {
+		job    DownloadJob
+		result <-chan error
+	}
+	var queued []pending
+	for _, groupID := range groupIDs {
+		var names []string
+		_, err := ListFiles(groupID, defaultListPageSize, "", true, func(file map[string]interface{}) {
+			if name, ok := file["file_name"].(string); ok {
+				names = append(names, name)
+			}
+		})
+		if err != nil {
+			fmt.Printf("✗ Listing group '%s' failed: %v\n", groupID, err)
+			failed++
+			continue
+		}
+		for _, name := range names {
+			dest := filepath.Join(groupID, name)
+			if _, err := os.Stat(dest); err == nil {
+				continue
+			}
+			if err := os.MkdirAll(groupID, 0755); err != nil {
+				fmt.Printf("✗ %s: %v\n", dest, err)
+				failed++
+				continue
+			}
+			job := DownloadJob{GroupID: groupID, FileName: name, DestPath: dest, Priority: PriorityBackground}
+			queued = append(queued, pending{job, s.Submit(job)})
+		}
+	}
+	if len(queued) > 0 {
+		fmt.Printf("Queued %d downloads, %d at a time\n", len(queued), s.workers)
+	}
+
+	for _, p := range queued {
+		if err := <-p.result; err != nil {
+			fmt.Printf("✗ %s/%s: %v\n", p.job.GroupID, p.job.FileName, err)
+			failed++
+		} else {
+			fmt.Printf("✓ %s\n", p.job.DestPath)
+			done++
+		}
+	}
+	return done, failed
+}
+
+// downloadAndSeed downloads a file and registers us as one of its seeders.
+func downloadAndSeed(groupID, fileName, destPath string) error {
+	if err := DownloadFile(groupID, fileName, destPath); err != nil {
+		return err
+	}
+	// Register as seeder so other peers can download from us
+	// (in DHT-only mode DownloadFile announced the chunks instead)
+	if State.UserID != "" && !dhtOnly() {
+		SendToTracker(Message{
+			Cmd:  "add_seeder",
+			Args: []string{groupID, fileName, State.UserID},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedRunner is a run function whose jobs block until released, recording
+// the order they started in and the most that ran at once.
+type gatedRunner struct {
+	mu      sync.Mutex
+	started []string
+	running int
+	peak    int
+	release chan struct{}
+}
+
+func newGatedRunner() *gatedRunner {
+	return &gatedRunner{release: make(chan struct{})}
+}
+
+func (g *gatedRunner) run(j DownloadJob) error {
+	g.mu.Lock()
+	g.started = append(g.started, j.FileName)
+	g.running++
+	if g.running > g.peak {
+		g.peak = g.running
+	}
+	g.mu.Unlock()
+
+	<-g.release
+
+	g.mu.Lock()
+	g.running--
+	g.mu.Unlock()
+	if j.FileName == "bad" {
+		return errors.New("no peers")
+	}
+	return nil
+}
+
+// waitStarted waits until n jobs have started.
+func (g *gatedRunner) waitStarted(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	got := 0
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		got = len(g.started)
+		g.mu.Unlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("only %d of %d jobs started", got, n)
+}
+
+// TestDownloadScheduler_Cap queues more jobs than workers and checks no
+// more than the cap ever run at once, and that every job reports back.
+func TestDownloadScheduler_Cap(t *testing.T) {
+	g := newGatedRunner()
+	s := NewDownloadScheduler(3, g.run)
+
+	var results []<-chan error
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "bad"} {
+		results = append(results, s.Submit(DownloadJob{GroupID: "g1", FileName: name}))
+	}
+	g.waitStarted(t, 3)
+	if st := s.Status(); len(st.Active) != 3 || st.Queued != 5 || st.Workers != 3 {
+		t.Fatalf("status = %+v", st)
+	}
+
+	close(g.release)
+	for i, r := range results {
+		err := <-r
+		if (i == 7) != (err != nil) {
+			t.Errorf("job %d: err = %v", i, err)
+		}
+	}
+	if g.peak != 3 {
+		t.Errorf("peak concurrency = %d, want 3", g.peak)
+	}
+	if st := s.Status(); len(st.Active) != 0 || st.Queued != 0 {
+		t.Errorf("status after = %+v", st)
+	}
+}
+
+// TestDownloadScheduler_Priority fills the only worker, queues background
+// jobs and then a user request, and checks the user request runs next with
+// the background jobs following in the order they were queued.
+func TestDownloadScheduler_Priority(t *testing.T) {
+	g := newGatedRunner()
+	s := NewDownloadScheduler(1, g.run)
+
+	first := s.Submit(DownloadJob{FileName: "running", Priority: PriorityBackground})
+	g.waitStarted(t, 1)
+	var rest []<-chan error
+	for _, name := range []string{"bg1", "bg2", "bg3"} {
+		rest = append(rest, s.Submit(DownloadJob{FileName: name, Priority: PriorityBackground}))
+	}
+	rest = append(rest, s.Submit(DownloadJob{FileName: "wanted", Priority: PriorityUser}))
+
+	close(g.release)
+	<-first
+	for _, r := range rest {
+		<-r
+	}
+	want := "running wanted bg1 bg2 bg3"
+	if got := strings.Join(g.started, " "); got != want {
+		t.Errorf("start order = %q, want %q", got, want)
+	}
+}
+
+func TestPrintSchedulerStatus(t *testing.T) {
+	var buf bytes.Buffer
+	printSchedulerStatus(&buf, nil)
+	if !strings.Contains(buf.String(), "No downloads") {
+		t.Errorf("empty status = %q", buf.String())
+	}
+
+	buf.Reset()
+	printSchedulerStatus(&buf, []SchedulerStatus{{PID: 42, Workers: 4, Queued: 9,
+		Active: []DownloadJob{{GroupID: "g1", FileName: "a.iso"}, {GroupID: "g2", FileName: "b.iso"}}}})
+	out := buf.String()
+	for _, want := range []string{"Process 42: 2 of 4 workers busy, 9 queued", "g1/a.iso", "g2/b.iso"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("status output missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// peerDaemonWait is how long ensurePeerDaemon waits for a new daemon to come up.
+const peerDaemonWait = 5 * time.Second
+
+// startPeerDaemon spawns the background peer server for the logged-in user.
+func startPeerDaemon() (*exec.Cmd, error) {
+	args := []string{"peer_daemon"}
+	if offline {
+		args = append(args, "--offline")
+	}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// peerDaemonRunning reports whether the peer server recorded in the session
+// accepts connections.
+func peerDaemonRunning() bool {
+	addr := State.ListenAddr
+	if addr == "" {
+		return false
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+	conn, err := net.DialTimeout("tcp", addr, 300*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// ensurePeerDaemon starts the peer server if it isn't running and waits for
+// it to record its address, so the tracker lists a reachable peer.
+func ensurePeerDaemon() (started bool, err error) {
+	if peerDaemonRunning() {
+		return false, nil
+	}
+	State.ListenAddr = ""
+	if _, err := startPeerDaemon(); err != nil {
+		return false, err
+	}
+	deadline := time.Now().Add(peerDaemonWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		LoadSession() // the daemon saves its address once it is listening
+		if peerDaemonRunning() {
+			return true, nil
+		}
+	}
+	return true, errors.New("peer server did not start")
+}
+
+// SeedFile shares a file that is already complete on disk: it is chunked
+// into the local store and registered with the tracker, with the peer server
+// started first so the new file's peer list is immediately reachable.
+func SeedFile(filePath, groupID string) (*ChunkMetadata, Response, error) {
+	metadata, err := ChunkFile(filePath, uploadChunkOptions())
+	if err != nil {
+		return nil, Response{}, err
+	}
+	if err := SaveChunks(filePath, metadata); err != nil {
+		return nil, Response{}, err
+	}
+	if _, err := ensurePeerDaemon(); err != nil {
+		return metadata, Response{}, err
+	}
+	return metadata, registerUpload(metadata, groupID), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"p2p/common"
+	"strconv"
+	"testing"
+)
+
+// startUploadTracker is a tracker stand-in that records upload_file and
+// answers get_file_info from it, listing peer as the only seeder.
+func startUploadTracker(t *testing.T, peer string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		var upload []string // [fileName, groupID, userID, size, hash, chunksJSON, chunkSize]
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var msg Message
+			if recvRequest(conn, &msg) == nil {
+				switch {
+				case msg.Cmd == "upload_file" && len(msg.Args) >= 7:
+					upload = msg.Args
+					common.Send(conn, Response{"ok", map[string]interface{}{"file_name": upload[0], "group_id": upload[1]}})
+				case msg.Cmd == "get_file_info" && upload != nil && msg.Args[0] == upload[1] && msg.Args[1] == upload[0]:
+					size, _ := strconv.ParseInt(upload[3], 10, 64)
+					chunkSize, _ := strconv.ParseInt(upload[6], 10, 64)
+					var chunks []ChunkInfo
+					json.Unmarshal([]byte(upload[5]), &chunks)
+					common.Send(conn, Response{"ok", map[string]interface{}{
+						"file_name":    upload[0],
+						"file_hash":    upload[4],
+						"file_size":    size,
+						"chunk_size":   chunkSize,
+						"total_chunks": len(chunks),
+						"chunks":       chunks,
+						"peers":        []string{peer},
+					}})
+				default:
+					common.Send(conn, Response{"error", "file not found"})
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// TestSeedFile seeds a file with the peer server already running, checks the
+// tracker got its metadata and downloads it back from that peer.
+func TestSeedFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	content := bytes.Repeat([]byte("outside the network "), ChunkSize/10)
+	if err := os.WriteFile("found.bin", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	peer := startTestPeer(t)
+	savedUser, savedListen := State.UserID, State.ListenAddr
+	State.UserID, State.ListenAddr = "alice", peer
+	t.Cleanup(func() { State.UserID, State.ListenAddr = savedUser, savedListen })
+	useTestNetwork(t, startUploadTracker(t, peer), nil)
+
+	meta, resp, err := SeedFile("found.bin", "g1")
+	if err != nil {
+		t.Fatalf("SeedFile: %v", err)
+	}
+	if resp.Status != "ok" {
+		t.Fatalf("upload_file: %+v", resp)
+	}
+	if State.ListenAddr != peer {
+		t.Fatalf("listen address changed to %q; the running peer server should be reused", State.ListenAddr)
+	}
+
+	info, err := queryFileInfo("g1", "found.bin")
+	if err != nil {
+		t.Fatalf("tracker has no metadata: %v", err)
+	}
+	if info.FileHash != meta.FileHash || info.TotalChunks != meta.TotalChunks || len(info.Peers) != 1 || info.Peers[0] != peer {
+		t.Fatalf("tracker metadata %+v doesn't match the seeded file", info)
+	}
+
+	var out bytes.Buffer
+	if err := streamChunks(context.Background(), info, &out); err != nil {
+		t.Fatalf("download from seeder: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Error("downloaded file differs from the seeded one")
+	}
+}
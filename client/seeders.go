@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Probe timings for the seeder check. Variables so tests can shorten them.
+var (
+	seederProbeTimeout  = 500 * time.Millisecond
+	seederRetryInterval = 2 * time.Second
+)
+
+// DownloadConfig holds the download_file options that gate a download.
+type DownloadConfig struct {
+	// MinSeeders is how many peers must accept a connection before any
+	// chunk is requested.
+	MinSeeders int
+	// WaitForSeeders keeps re-checking for up to this long while too few
+	// seeders are online; 0 fails at once.
+	WaitForSeeders time.Duration
+	// ChunkTimeout is how long a chunk no peer could provide is retried
+	// for, with fresh peers from the tracker, before the download fails.
+	ChunkTimeout time.Duration
+}
+
+// defaultChunkTimeout is ChunkTimeout unless --chunk-timeout says otherwise.
+const defaultChunkTimeout = 60 * time.Second
+
+// downloadConfig is set from download_file's --min-seeders,
+// --wait-for-seeders and --chunk-timeout.
+var downloadConfig = DownloadConfig{MinSeeders: 1, ChunkTimeout: defaultChunkTimeout}
+
+// checksSeeders reports whether the config asks for anything beyond the
+// default, in which case peers are probed before downloading.
+func (c DownloadConfig) checksSeeders() bool {
+	return c.MinSeeders > 1 || c.WaitForSeeders > 0
+}
+
+// onlineSeeders dials every peer at once and returns those that answered,
+// in their original order.
+func onlineSeeders(peers []string) []string {
+	up := make([]bool, len(peers))
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Add(1)
+		go func(i int, p string) {
+			defer wg.Done()
+			if conn, err := net.DialTimeout("tcp", p, seederProbeTimeout); err == nil {
+				conn.Close()
+				up[i] = true
+			}
+		}(i, p)
+	}
+	wg.Wait()
+
+	var res []string
+	for i, p := range peers {
+		if up[i] {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// awaitSeeders returns once at least cfg.MinSeeders of info's peers are
+// online. While too few are, it re-checks every seederRetryInterval until
+// cfg.WaitForSeeders has passed, calling refresh (if non-nil) for an updated
+// peer list each time.
+func awaitSeeders(info *FileInfo, cfg DownloadConfig, refresh func() (*FileInfo, error)) (*FileInfo, error) {
+	deadline := time.Now().Add(cfg.WaitForSeeders)
+	for {
+		online := onlineSeeders(info.Peers)
+		if len(online) >= cfg.MinSeeders {
+			fmt.Printf("%d of %d seeders online\n", len(online), len(info.Peers))
+			return info, nil
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("only %d of %d seeders online, need at least %d",
+				len(online), len(info.Peers), cfg.MinSeeders)
+		}
+		fmt.Printf("%d of %d seeders online, waiting for %d...\n", len(online), len(info.Peers), cfg.MinSeeders)
+		time.Sleep(seederRetryInterval)
+		if refresh != nil {
+			if fresh, err := refresh(); err == nil {
+				info = fresh
+			}
+		}
+	}
+}
+
+// recoverChunk is the fallback for chunk i once every candidate peer
+// failed it. Every seederRetryInterval until timeout has passed it asks
+// fileInfo's refresh for the file's peers, adds any that came online to
+// those it knows, and tries them all in order, not only the ones the
+// piece selector picked. The peer the chunk came from is returned as by
+// downloadChunk.
+func recoverChunk(fileInfo *FileInfo, i int, chunkPath string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	// fileInfo is shared with the other workers, so its peer list is left alone
+	peers := append([]string(nil), fileInfo.Peers...)
+	for {
+		if fresh, err := fileInfo.refresh(); err == nil {
+			for _, p := range fresh.Peers {
+				if !slices.Contains(peers, p) {
+					peers = append(peers, p)
+				}
+			}
+		}
+		fmt.Printf("Retrying chunk %d from all %d peers...\n", i, len(peers))
+		if peer, err := downloadChunk(peers, fileInfo, i, chunkPath, " (sequential)"); err == nil {
+			return peer, nil
+		}
+		if !time.Now().Before(deadline) {
+			return "", fmt.Errorf("chunk %d unavailable, file cannot be completed", i)
+		}
+		time.Sleep(seederRetryInterval)
+	}
+}
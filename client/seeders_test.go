@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listeningPeer returns the address of a peer that accepts connections.
+func listeningPeer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// downPeer returns an address nothing listens on.
+func downPeer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+func useFastSeederChecks(t *testing.T) {
+	savedProbe, savedRetry := seederProbeTimeout, seederRetryInterval
+	seederProbeTimeout, seederRetryInterval = 200*time.Millisecond, 20*time.Millisecond
+	t.Cleanup(func() { seederProbeTimeout, seederRetryInterval = savedProbe, savedRetry })
+}
+
+func TestOnlineSeeders(t *testing.T) {
+	useFastSeederChecks(t)
+	a, down, b := listeningPeer(t), downPeer(t), listeningPeer(t)
+	if got := onlineSeeders([]string{a, down, b}); !reflect.DeepEqual(got, []string{a, b}) {
+		t.Fatalf("onlineSeeders = %v, want [%s %s]", got, a, b)
+	}
+}
+
+func TestAwaitSeeders_Threshold(t *testing.T) {
+	useFastSeederChecks(t)
+	info := &FileInfo{Peers: []string{listeningPeer(t), downPeer(t), listeningPeer(t)}}
+
+	if _, err := awaitSeeders(info, DownloadConfig{MinSeeders: 2}, nil); err != nil {
+		t.Fatalf("2 of 3 online with min 2: %v", err)
+	}
+	if _, err := awaitSeeders(info, DownloadConfig{MinSeeders: 3}, nil); err == nil {
+		t.Fatal("expected an error with 2 of 3 online and min 3")
+	}
+}
+
+// TestAwaitSeeders_WaitsForMore has a second seeder show up on the second
+// refresh and checks the download is allowed to go ahead.
+func TestAwaitSeeders_WaitsForMore(t *testing.T) {
+	useFastSeederChecks(t)
+	first, second := listeningPeer(t), listeningPeer(t)
+	refreshes := 0
+	refresh := func() (*FileInfo, error) {
+		refreshes++
+		if refreshes < 2 {
+			return &FileInfo{Peers: []string{first}}, nil
+		}
+		return &FileInfo{Peers: []string{first, second}}, nil
+	}
+
+	got, err := awaitSeeders(&FileInfo{Peers: []string{first}}, DownloadConfig{MinSeeders: 2, WaitForSeeders: 5 * time.Second}, refresh)
+	if err != nil {
+		t.Fatalf("awaitSeeders: %v", err)
+	}
+	if len(got.Peers) != 2 || refreshes != 2 {
+		t.Fatalf("got peers %v after %d refreshes", got.Peers, refreshes)
+	}
+}
+
+func TestAwaitSeeders_GivesUp(t *testing.T) {
+	useFastSeederChecks(t)
+	info := &FileInfo{Peers: []string{listeningPeer(t)}}
+	refresh := func() (*FileInfo, error) { return info, nil }
+
+	start := time.Now()
+	if _, err := awaitSeeders(info, DownloadConfig{MinSeeders: 2, WaitForSeeders: 100 * time.Millisecond}, refresh); err == nil {
+		t.Fatal("expected an error once the wait ran out")
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Fatal("gave up before the wait ran out")
+	}
+}
+
+func TestDownloadConfig_DefaultSkipsCheck(t *testing.T) {
+	if (DownloadConfig{MinSeeders: 1}).checksSeeders() {
+		t.Error("default config should not probe seeders")
+	}
+	if !(DownloadConfig{MinSeeders: 2}).checksSeeders() || !(DownloadConfig{MinSeeders: 1, WaitForSeeders: time.Second}).checksSeeders() {
+		t.Error("non-default config should probe seeders")
+	}
+}
+
+func TestStripValueFlag(t *testing.T) {
+	rest, v, found, err := stripValueFlag([]string{"g1", "--min-seeders", "3", "a.txt"}, "--min-seeders")
+	if err != nil || !found || v != "3" || !reflect.DeepEqual(rest, []string{"g1", "a.txt"}) {
+		t.Fatalf("got %v %q %v %v", rest, v, found, err)
+	}
+	rest, v, found, _ = stripValueFlag([]string{"--wait-for-seeders=1m", "g1"}, "--wait-for-seeders")
+	if !found || v != "1m" || !reflect.DeepEqual(rest, []string{"g1"}) {
+		t.Fatalf("got %v %q %v", rest, v, found)
+	}
+	if _, _, _, err := stripValueFlag([]string{"g1", "--min-seeders"}, "--min-seeders"); err == nil {
+		t.Fatal("expected an error for a missing value")
+	}
+}
+
+// TestDownload_RecoversChunkFromNewPeer starts a download from a peer
+// missing chunks 3 to 5, with a full peer only the refreshed peer list
+// knows, and checks the missing chunks come from it.
+func TestDownload_RecoversChunkFromNewPeer(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useFastSeederChecks(t)
+	meta, content := chunkTestFile(t, 6*smallChunkSize+100)
+	chunks := memoryChunks(t, meta)
+	partial, _ := startCountingPeer(t, meta.FileHash, chunks[:3])
+	full, counts := startCountingPeer(t, meta.FileHash, chunks)
+
+	var refreshes atomic.Int32
+	info := streamTestInfo(meta, partial)
+	info.refresh = func() (*FileInfo, error) {
+		refreshes.Add(1)
+		return streamTestInfo(meta, partial, full), nil
+	}
+	if err := downloadFromInfo(info, "downloaded.bin"); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if got, _ := os.ReadFile("downloaded.bin"); !bytes.Equal(got, content) {
+		t.Error("downloaded file differs")
+	}
+	if counts.requests(0) != 0 || counts.requests(3) != 1 || refreshes.Load() == 0 {
+		t.Errorf("full peer asked for chunk 0 %d times, chunk 3 %d times, after %d refreshes",
+			counts.requests(0), counts.requests(3), refreshes.Load())
+	}
+}
+
+// TestDownload_ChunkUnavailable checks a chunk no peer has, even after
+// refreshing, fails the download once the chunk timeout has passed.
+func TestDownload_ChunkUnavailable(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useFastSeederChecks(t)
+	saved := downloadConfig
+	downloadConfig.ChunkTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { downloadConfig = saved })
+	meta, _ := chunkTestFile(t, 6*smallChunkSize+100)
+	partial, _ := startCountingPeer(t, meta.FileHash, memoryChunks(t, meta)[:3])
+
+	refreshes := 0
+	info := streamTestInfo(meta, partial)
+	info.refresh = func() (*FileInfo, error) {
+		refreshes++
+		return streamTestInfo(meta, partial), nil
+	}
+	start := time.Now()
+	err := downloadFromInfo(info, "downloaded.bin")
+	if err == nil || err.Error() != "chunk 3 unavailable, file cannot be completed" {
+		t.Fatalf("download: %v", err)
+	}
+	if time.Since(start) < 100*time.Millisecond || refreshes < 2 {
+		t.Errorf("gave up after %v and %d refreshes", time.Since(start), refreshes)
+	}
+}
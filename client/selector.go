@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+)
+
+// PieceSelector decides the order chunks are downloaded in. available holds
+// the indices still missing locally, in ascending order. bitfields maps each
+// peer to the chunks it holds (a nil bitfield means the peer is assumed to
+// hold everything); it is nil for selectors that don't want it.
+type PieceSelector interface {
+	Select(available []int, bitfields map[string][]bool, totalChunks int) []int
+}
+
+// bitfieldSelector is implemented by selectors that can say whether they
+// read bitfields. Selectors that don't implement it are given them, which
+// costs a get_bitfield request to every peer.
+type bitfieldSelector interface {
+	NeedsBitfields() bool
+}
+
+// Built-in selector names.
+const (
+	SelectorSequential  = "sequential"
+	SelectorRarestFirst = "rarest_first"
+	SelectorRandom      = "random"
+)
+
+// pieceSelectors holds the selectors --selector and P2P_SELECTOR can name.
+var pieceSelectors = map[string]PieceSelector{
+	SelectorSequential:  sequentialSelector{},
+	SelectorRarestFirst: rarestFirstSelector{},
+	SelectorRandom:      randomSelector{},
+}
+
+// selectorName is set from download_file's --selector flag.
+var selectorName string
+
+// RegisterSelector makes s available under name, replacing any selector
+// already registered with it. Call it before DownloadFile.
+func RegisterSelector(name string, s PieceSelector) {
+	pieceSelectors[name] = s
+}
+
+// currentSelector returns the selector picked by --selector, then
+// P2P_SELECTOR, then the older P2P_RAREST_FIRST switch, defaulting to
+// sequential.
+func currentSelector() (string, PieceSelector, error) {
+	name := selectorName
+	if name == "" {
+		name = os.Getenv("P2P_SELECTOR")
+	}
+	if name == "" && os.Getenv("P2P_RAREST_FIRST") != "" {
+		name = SelectorRarestFirst
+	}
+	if name == "" {
+		name = SelectorSequential
+	}
+	s, ok := pieceSelectors[name]
+	if !ok {
+		names := make([]string, 0, len(pieceSelectors))
+		for n := range pieceSelectors {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", nil, fmt.Errorf("unknown piece selector %q (have %s)", name, strings.Join(names, ", "))
+	}
+	return name, s, nil
+}
+
+// needsBitfields reports whether peers should be asked for bitfields before s runs.
+func needsBitfields(s PieceSelector) bool {
+	if b, ok := s.(bitfieldSelector); ok {
+		return b.NeedsBitfields()
+	}
+	return true
+}
+
+// completeOrder returns order followed by any index of available it left
+// out, so a selector that drops chunks can't leave the file incomplete.
+func completeOrder(order, available []int) []int {
+	seen := make(map[int]bool, len(order))
+	out := make([]int, 0, len(available))
+	for _, i := range order {
+		if !seen[i] {
+			seen[i] = true
+			out = append(out, i)
+		}
+	}
+	for _, i := range available {
+		if !seen[i] {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// sequentialSelector downloads chunks in index order.
+type sequentialSelector struct{}
+
+func (sequentialSelector) Select(available []int, _ map[string][]bool, _ int) []int {
+	return append([]int(nil), available...)
+}
+
+func (sequentialSelector) NeedsBitfields() bool { return false }
+
+// rarestFirstSelector downloads the chunks fewest peers hold first.
+type rarestFirstSelector struct{}
+
+func (rarestFirstSelector) Select(available []int, bitfields map[string][]bool, totalChunks int) []int {
+	missing := make(map[int]bool, len(available))
+	for _, i := range available {
+		missing[i] = true
+	}
+	order := make([]int, 0, len(available))
+	for _, i := range buildRarityOrder(bitfields, totalChunks) {
+		if missing[i] {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// randomSelector downloads chunks in a random order, spreading load when
+// many peers start the same file at once.
+type randomSelector struct{}
+
+func (randomSelector) Select(available []int, _ map[string][]bool, _ int) []int {
+	order := append([]int(nil), available...)
+	rand.Shuffle(len(order), func(a, b int) { order[a], order[b] = order[b], order[a] })
+	return order
+}
+
+func (randomSelector) NeedsBitfields() bool { return false }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSequentialSelector(t *testing.T) {
+	got := sequentialSelector{}.Select([]int{0, 2, 3}, nil, 4)
+	if !reflect.DeepEqual(got, []int{0, 2, 3}) {
+		t.Errorf("sequential = %v, want [0 2 3]", got)
+	}
+}
+
+// TestRarestFirstSelector checks chunks go rarest first and that chunks
+// already on disk are left out.
+func TestRarestFirstSelector(t *testing.T) {
+	bf := map[string][]bool{
+		"peer1": {true, true, true, true},
+		"peer2": {true, false, true, false},
+		"peer3": {true, false, true, true},
+	}
+	got := rarestFirstSelector{}.Select([]int{0, 1, 3}, bf, 4)
+	if !reflect.DeepEqual(got, []int{1, 3, 0}) {
+		t.Errorf("rarest_first = %v, want [1 3 0]", got)
+	}
+}
+
+func TestRandomSelector(t *testing.T) {
+	available := make([]int, 50)
+	for i := range available {
+		available[i] = i
+	}
+	got := randomSelector{}.Select(available, nil, 50)
+	if reflect.DeepEqual(got, available) {
+		t.Error("random order came back sorted")
+	}
+	sorted := append([]int(nil), got...)
+	sort.Ints(sorted)
+	if !reflect.DeepEqual(sorted, available) {
+		t.Errorf("random order isn't a permutation of available: %v", got)
+	}
+}
+
+func TestCurrentSelector(t *testing.T) {
+	saved := selectorName
+	t.Cleanup(func() { selectorName = saved })
+	selectorName = ""
+
+	if name, _, _ := currentSelector(); name != SelectorSequential {
+		t.Errorf("default = %q", name)
+	}
+	t.Setenv("P2P_RAREST_FIRST", "1")
+	if name, _, _ := currentSelector(); name != SelectorRarestFirst {
+		t.Errorf("with P2P_RAREST_FIRST = %q", name)
+	}
+	t.Setenv("P2P_SELECTOR", SelectorRandom)
+	if name, _, _ := currentSelector(); name != SelectorRandom {
+		t.Errorf("with P2P_SELECTOR = %q", name)
+	}
+	selectorName = SelectorSequential
+	if name, _, _ := currentSelector(); name != SelectorSequential {
+		t.Errorf("--selector should win over P2P_SELECTOR, got %q", name)
+	}
+	selectorName = "nope"
+	if _, _, err := currentSelector(); err == nil {
+		t.Error("expected an error for an unknown selector")
+	}
+}
+
+// reverseSelector is a test double that records its input and asks for
+// the chunks last to first, leaving one out.
+type reverseSelector struct {
+	available []int
+	bitfields map[string][]bool
+}
+
+func (r *reverseSelector) Select(available []int, bitfields map[string][]bool, totalChunks int) []int {
+	r.available, r.bitfields = available, bitfields
+	var order []int
+	for i := len(available) - 1; i > 0; i-- {
+		order = append(order, available[i])
+	}
+	return order
+}
+
+// TestDownloadFile_CustomSelector registers a custom selector and checks
+// DownloadFile uses it, passes it bitfields, and still fetches the chunk
+// it left out.
+func TestDownloadFile_CustomSelector(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*ChunkSize+100)
+	chunks := moveChunksToMemory(t, meta)
+	peer := startMemoryPeer(t, meta.FileHash, chunks)
+
+	d := newFakeDHT()
+	d.files["g1:orig.bin"] = dhtMetadata(meta, "g1")
+	for i := 0; i < meta.TotalChunks; i++ {
+		d.AnnounceChunk(meta.FileHash, i, peer)
+	}
+	useTestNetwork(t, startEmptyTracker(t), d)
+
+	custom := &reverseSelector{}
+	RegisterSelector("reverse", custom)
+	saved := selectorName
+	selectorName = "reverse"
+	t.Cleanup(func() {
+		selectorName = saved
+		delete(pieceSelectors, "reverse")
+	})
+
+	if err := DownloadFile("g1", "orig.bin", "downloaded.bin"); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if len(custom.available) != meta.TotalChunks || custom.bitfields == nil {
+		t.Errorf("selector got available %v, bitfields %v", custom.available, custom.bitfields)
+	}
+	got, err := os.ReadFile("downloaded.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("downloaded file differs from original")
+	}
+}
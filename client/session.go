@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"sync"
+	"time"
 )
 
 const SessionFile = ".p2p_session.json"
@@ -11,6 +13,13 @@ const SessionFile = ".p2p_session.json"
 type SessionData struct {
 	UserID     string `json:"user_id"`
 	ListenAddr string `json:"listen_addr"`
+
+	// speed_test results, kept so later downloads can use them
+	PeerSpeeds   map[string]float64   `json:"peer_speeds,omitempty"`
+	PeerSpeedsAt map[string]time.Time `json:"peer_speeds_at,omitempty"`
+
+	// Blacklisted peers and when each ban ends
+	PeerBlacklist map[string]time.Time `json:"peer_blacklist,omitempty"`
 }
 
 // LoadSession reads session from file and populates State
@@ -32,15 +41,33 @@ func LoadSession() error {
 	// Populate global State
 	State.UserID = session.UserID
 	State.ListenAddr = session.ListenAddr
+	if session.PeerSpeeds != nil && session.PeerSpeedsAt != nil {
+		State.PeerSpeeds = session.PeerSpeeds
+		State.PeerSpeedsAt = session.PeerSpeedsAt
+	}
+	State.PeerBlacklist.Clear()
+	for peer, until := range session.PeerBlacklist {
+		State.PeerBlacklist.Store(peer, until)
+	}
 
 	return nil
 }
 
+// sessionMu keeps download workers blacklisting peers from writing the
+// session file at the same time.
+var sessionMu sync.Mutex
+
 // SaveSession writes current State to session file
 func SaveSession() error {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+
 	session := SessionData{
-		UserID:     State.UserID,
-		ListenAddr: State.ListenAddr,
+		UserID:        State.UserID,
+		ListenAddr:    State.ListenAddr,
+		PeerSpeeds:    State.PeerSpeeds,
+		PeerSpeedsAt:  State.PeerSpeedsAt,
+		PeerBlacklist: activeBlacklist(),
 	}
 
 	data, err := json.MarshalIndent(session, "", "  ")
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// simulateProbeTimeout bounds each seeder's handshake during a simulation.
+const simulateProbeTimeout = 3 * time.Second
+
+// PeerProbe is what a simulated download learned about one seeder.
+type PeerProbe struct {
+	Peer      string
+	Reachable bool          // the handshake was answered
+	HasFile   bool          // the handshake was accepted for this file
+	RTT       time.Duration // handshake round trip
+	Chunks    int           // needed chunks the peer holds; all of them if its bitfield is unknown
+	Err       error
+}
+
+// SimulationReport describes what download_file would do, without doing it.
+type SimulationReport struct {
+	FileName    string
+	GroupID     string
+	FileHash    string
+	FileSize    int64
+	ChunkSize   int64
+	TotalChunks int
+
+	// ChunksNeeded are the chunks not already in the local chunk store.
+	ChunksNeeded []int
+	// Unavailable are needed chunks that no usable peer holds.
+	Unavailable []int
+
+	Peers []PeerProbe
+
+	// Estimate is the expected download time; EstimateBasis says how it was
+	// worked out, since without speed_test results it only counts latency.
+	Estimate      time.Duration
+	EstimateBasis string
+}
+
+// UsablePeers counts the peers that answered the handshake for the file.
+func (r *SimulationReport) UsablePeers() int {
+	n := 0
+	for _, p := range r.Peers {
+		if p.HasFile {
+			n++
+		}
+	}
+	return n
+}
+
+// SimulateDownload walks through a download of fileName from groupID:
+// it gets the file's metadata and seeders, handshakes with each seeder and
+// asks for its bitfield. No chunk data is transferred and nothing is
+// written to disk.
+func SimulateDownload(groupID, fileName string) (*SimulationReport, error) {
+	fileInfo, err := queryFileInfo(groupID, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %v", err)
+	}
+	addDHTPeers(fileInfo)
+	addGossipPeers(fileInfo)
+
+	r := &SimulationReport{
+		FileName:    fileInfo.FileName,
+		GroupID:     groupID,
+		FileHash:    fileInfo.FileHash,
+		FileSize:    fileInfo.FileSize,
+		ChunkSize:   fileInfo.ChunkSize,
+		TotalChunks: fileInfo.TotalChunks,
+	}
+	chunkDir := filepath.Join(ChunksDir, fileInfo.FileHash)
+	for i := 0; i < fileInfo.TotalChunks; i++ {
+		if _, err := os.Stat(filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))); err != nil {
+			r.ChunksNeeded = append(r.ChunksNeeded, i)
+		}
+	}
+
+	var usable []string
+	for _, peer := range fileInfo.Peers {
+		probe := probePeer(peer, fileInfo.FileHash)
+		if probe.HasFile {
+			usable = append(usable, peer)
+		}
+		r.Peers = append(r.Peers, probe)
+	}
+
+	// Which needed chunks each usable peer holds; a nil bitfield means the
+	// peer didn't say, and the download would assume it has them all
+	held := make(map[int]bool, len(r.ChunksNeeded))
+	if len(usable) > 0 {
+		bitfields := getBitfields(usable, fileInfo.FileHash)
+		for i := range r.Peers {
+			p := &r.Peers[i]
+			if !p.HasFile {
+				continue
+			}
+			bf := bitfields[p.Peer]
+			for _, c := range r.ChunksNeeded {
+				if bf == nil || (c < len(bf) && bf[c]) {
+					p.Chunks++
+					held[c] = true
+				}
+			}
+		}
+	}
+	for _, c := range r.ChunksNeeded {
+		if !held[c] {
+			r.Unavailable = append(r.Unavailable, c)
+		}
+	}
+
+	r.Estimate, r.EstimateBasis = estimateDownload(r, time.Now())
+	return r, nil
+}
+
+// probePeer times a handshake with peer for fileHash.
+func probePeer(peer, fileHash string) PeerProbe {
+	probe := PeerProbe{Peer: peer}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", peer, simulateProbeTimeout)
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(simulateProbeTimeout))
+
+	var resp PeerResponse
+	if err := common.Send(conn, PeerRequest{Cmd: "handshake", FileHash: fileHash, Token: peerToken(fileHash)}); err != nil {
+		probe.Err = err
+		return probe
+	}
+	if err := common.Recv(conn, &resp); err != nil {
+		probe.Err = err
+		return probe
+	}
+	probe.RTT = time.Since(start)
+	probe.Reachable = true
+	if resp.Status != "ok" {
+		probe.Err = fmt.Errorf("handshake refused: %s", resp.Status)
+		return probe
+	}
+	probe.HasFile = true
+	return probe
+}
+
+// estimateDownload works out how long fetching the needed chunks would
+// take. With fresh speed_test results for the usable peers it uses their
+// bandwidth, shared across the P2P_PARALLEL workers; otherwise it can only
+// count the two round trips (handshake and request) each chunk costs.
+func estimateDownload(r *SimulationReport, now time.Time) (time.Duration, string) {
+	needed := len(r.ChunksNeeded) - len(r.Unavailable)
+	if needed <= 0 {
+		return 0, "nothing to download"
+	}
+	workers := parallelWorkers()
+
+	var speeds []float64
+	var rtt time.Duration
+	usable := 0
+	for _, p := range r.Peers {
+		if !p.HasFile {
+			continue
+		}
+		usable++
+		rtt += p.RTT
+		if mbps, ok := peerSpeed(p.Peer, now); ok && mbps > 0 {
+			speeds = append(speeds, mbps)
+		}
+	}
+	if usable == 0 {
+		return 0, "no usable peers"
+	}
+	rtt /= time.Duration(usable)
+	if workers > usable {
+		workers = usable
+	}
+	latency := time.Duration(needed) * 2 * rtt / time.Duration(workers)
+
+	if len(speeds) == 0 {
+		return latency, "latency only; run speed_test for a bandwidth estimate"
+	}
+	// Each worker uses one peer at a time, so at most the fastest
+	// `workers` peers download at once
+	sort.Sort(sort.Reverse(sort.Float64Slice(speeds)))
+	if len(speeds) > workers {
+		speeds = speeds[:workers]
+	}
+	var mbps float64
+	for _, s := range speeds {
+		mbps += s
+	}
+	bytes := int64(needed) * r.ChunkSize
+	if bytes > r.FileSize {
+		bytes = r.FileSize
+	}
+	transfer := time.Duration(float64(bytes) / (mbps * (1 << 20)) * float64(time.Second))
+	return transfer + latency, fmt.Sprintf("speed_test results for %d peers", len(speeds))
+}
+
+// printSimulationReport prints a simulated download for the user to review.
+func printSimulationReport(r *SimulationReport) {
+	fmt.Println("Simulation — nothing was downloaded")
+	fmt.Printf("  File: %s\n", r.FileName)
+	fmt.Printf("  Group: %s\n", r.GroupID)
+	fmt.Printf("  Size: %s (%d bytes)\n", formatByteSize(r.FileSize), r.FileSize)
+	if len(r.FileHash) >= 16 {
+		fmt.Printf("  Hash: %s...\n", r.FileHash[:16])
+	}
+	if have := r.TotalChunks - len(r.ChunksNeeded); have > 0 {
+		fmt.Printf("  Already on disk: %d of %d chunks\n", have, r.TotalChunks)
+	}
+	for _, p := range r.Peers {
+		if p.HasFile {
+			fmt.Printf("  ✓ %-22s %-8s %d chunks\n", p.Peer, p.RTT.Round(time.Millisecond), p.Chunks)
+		} else {
+			fmt.Printf("  ✗ %-22s %v\n", p.Peer, p.Err)
+		}
+	}
+
+	fetch := len(r.ChunksNeeded) - len(r.Unavailable)
+	fmt.Printf("Would download %d chunks from %d peers, estimated time %.1fs (%s)\n",
+		fetch, r.UsablePeers(), r.Estimate.Seconds(), r.EstimateBasis)
+	if len(r.Unavailable) > 0 {
+		fmt.Printf("✗ %d chunks are not held by any reachable peer\n", len(r.Unavailable))
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net"
+	"os"
+	"p2p/common"
+	"testing"
+	"time"
+)
+
+// startProbePeer runs a peer that answers handshakes and bitfield queries
+// for fileHash, holding the chunks in have (nil: no get_bitfield support).
+// Any request for chunk data fails the test.
+func startProbePeer(t *testing.T, fileHash string, have []int) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var req PeerRequest
+				if err := common.Recv(c, &req); err != nil {
+					return
+				}
+				switch {
+				case req.FileHash != fileHash:
+					common.Send(c, PeerResponse{Status: "error"})
+				case req.Cmd == "handshake":
+					common.Send(c, PeerResponse{Status: "ok"})
+				case req.Cmd == "get_bitfield" && have != nil:
+					common.Send(c, PeerResponse{Status: "ok", Bitfield: have})
+				case req.Cmd == "get_piece":
+					t.Errorf("simulation requested chunk %d", req.PieceIdx)
+					common.Send(c, PeerResponse{Status: "error"})
+				default:
+					common.Send(c, PeerResponse{Status: "error"})
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// useTestGossipCache gives the test an empty bitfield cache.
+func useTestGossipCache(t *testing.T) {
+	saved := localGossip.cache
+	localGossip.cache = newPeerBitfieldCache(bitfieldCacheTTL)
+	t.Cleanup(func() { localGossip.cache = saved })
+}
+
+// closedAddr returns an address nothing listens on.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// TestSimulateDownload_ProbesWithoutFetching has one seeder with half the
+// file, one that doesn't support bitfields, one that doesn't have the file
+// and one that is down, and checks the report and that nothing is fetched
+// or written.
+func TestSimulateDownload_ProbesWithoutFetching(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useTestGossipCache(t)
+	useTestCache(t, 0)
+
+	const hash = "abcdef0123456789abcdef0123456789"
+	partial := startProbePeer(t, hash, []int{0, 1})
+	legacy := startProbePeer(t, hash, nil)
+	other := startProbePeer(t, "another-hash", []int{0, 1, 2, 3})
+	down := closedAddr(t)
+
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"get_file_info": {"ok", map[string]interface{}{
+			"file_name": "big.iso", "file_hash": hash, "file_size": 4*ChunkSize - 10,
+			"chunk_size": ChunkSize, "total_chunks": 4,
+			"peers": []string{partial, legacy, other, down},
+		}},
+	})
+	useTestNetwork(t, tracker, nil)
+
+	r, err := SimulateDownload("g1", "big.iso")
+	if err != nil {
+		t.Fatalf("SimulateDownload: %v", err)
+	}
+	if len(r.ChunksNeeded) != 4 || len(r.Unavailable) != 0 || r.UsablePeers() != 2 {
+		t.Fatalf("report = %+v", r)
+	}
+	want := map[string]PeerProbe{
+		partial: {Reachable: true, HasFile: true, Chunks: 2},
+		legacy:  {Reachable: true, HasFile: true, Chunks: 4},
+		other:   {Reachable: true},
+		down:    {},
+	}
+	for _, p := range r.Peers {
+		w := want[p.Peer]
+		if p.Reachable != w.Reachable || p.HasFile != w.HasFile || p.Chunks != w.Chunks {
+			t.Errorf("probe %s = %+v, want %+v", p.Peer, p, w)
+		}
+		if p.HasFile && p.RTT <= 0 {
+			t.Errorf("probe %s has no round-trip time", p.Peer)
+		}
+		if !p.HasFile && p.Err == nil {
+			t.Errorf("probe %s failed without an error", p.Peer)
+		}
+	}
+	if r.Estimate <= 0 || r.EstimateBasis == "" {
+		t.Errorf("estimate = %v (%s)", r.Estimate, r.EstimateBasis)
+	}
+
+	if got := cmds(); len(got) != 1 || got[0] != "get_file_info" {
+		t.Errorf("tracker commands = %v, want only get_file_info", got)
+	}
+	if entries, _ := os.ReadDir("."); len(entries) != 0 {
+		t.Errorf("simulation wrote to disk: %v", entries)
+	}
+}
+
+// TestSimulateDownload_UnavailableChunks checks chunks no seeder holds are
+// reported, and that chunks already on disk aren't counted as needed.
+func TestSimulateDownload_UnavailableChunks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useTestGossipCache(t)
+	useTestCache(t, 0)
+
+	const hash = "00112233445566778899aabbccddeeff"
+	peer := startProbePeer(t, hash, []int{1})
+	tracker, _ := startRecordingTracker(t, map[string]Response{
+		"get_file_info": {"ok", map[string]interface{}{
+			"file_name": "f.bin", "file_hash": hash, "file_size": 3 * ChunkSize,
+			"chunk_size": ChunkSize, "total_chunks": 3, "peers": []string{peer},
+		}},
+	})
+	useTestNetwork(t, tracker, nil)
+
+	if err := os.MkdirAll(ChunksDir+"/"+hash, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ChunksDir+"/"+hash+"/chunk_0.dat", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := SimulateDownload("g1", "f.bin")
+	if err != nil {
+		t.Fatalf("SimulateDownload: %v", err)
+	}
+	if len(r.ChunksNeeded) != 2 || len(r.Unavailable) != 1 || r.Unavailable[0] != 2 {
+		t.Fatalf("needed %v, unavailable %v", r.ChunksNeeded, r.Unavailable)
+	}
+}
+
+// TestEstimateDownload checks the estimate uses the fastest peers' speeds,
+// one per worker, and falls back to latency without speed results.
+func TestEstimateDownload(t *testing.T) {
+	now := time.Now()
+	saved, savedAt := State.PeerSpeeds, State.PeerSpeedsAt
+	State.PeerSpeeds = map[string]float64{"a": 1, "b": 3}
+	State.PeerSpeedsAt = map[string]time.Time{"a": now, "b": now}
+	t.Cleanup(func() { State.PeerSpeeds, State.PeerSpeedsAt = saved, savedAt })
+	t.Setenv("P2P_PARALLEL", "1")
+
+	r := &SimulationReport{
+		FileSize: 6 << 20, ChunkSize: 1 << 20, TotalChunks: 6,
+		ChunksNeeded: []int{0, 1, 2, 3, 4, 5},
+		Peers: []PeerProbe{
+			{Peer: "a", HasFile: true, RTT: 5 * time.Millisecond},
+			{Peer: "b", HasFile: true, RTT: 5 * time.Millisecond},
+			{Peer: "c", HasFile: true, RTT: 5 * time.Millisecond},
+		},
+	}
+	// 6 MB at the fastest peer's 3 MB/s, plus two 5ms round trips a chunk
+	got, _ := estimateDownload(r, now)
+	if want := 2*time.Second + 60*time.Millisecond; got.Round(time.Millisecond) != want {
+		t.Errorf("one worker: estimate = %v, want %v", got, want)
+	}
+
+	// Four workers are capped at the three peers; both measured peers run at once
+	t.Setenv("P2P_PARALLEL", "4")
+	got, _ = estimateDownload(r, now)
+	if want := 1500*time.Millisecond + 20*time.Millisecond; got.Round(time.Millisecond) != want {
+		t.Errorf("four workers: estimate = %v, want %v", got, want)
+	}
+
+	State.PeerSpeeds, State.PeerSpeedsAt = map[string]float64{}, map[string]time.Time{}
+	got, basis := estimateDownload(r, now)
+	if want := 60 * time.Millisecond / 3; got.Round(time.Millisecond) != want || basis == "" {
+		t.Errorf("no speeds: estimate = %v (%s), want %v", got, basis, want)
+	}
+}
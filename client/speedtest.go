@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// speedResultTTL is how long a measured peer speed is trusted.
+const speedResultTTL = 10 * time.Minute
+
+// skipSpeedTest is set by --skip-speed-test: downloads then use whatever
+// rankings are already known and never measure peers themselves.
+var skipSpeedTest bool
+
+// PeerSpeed is one speed_test result.
+type PeerSpeed struct {
+	Peer string
+	MBps float64
+}
+
+// measurePeerSpeed times a download of chunk 0 from peer and returns MB/s.
+func measurePeerSpeed(peer string, fileInfo *FileInfo) (float64, error) {
+	start := time.Now()
+	data, algo, err := requestChunk(peer, fileInfo.FileHash, 0)
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	if len(fileInfo.Chunks) > 0 && !validateChunk(data, algo, fileInfo.Chunks[0]) {
+		return 0, errors.New("chunk 0 failed validation")
+	}
+	if elapsed <= 0 {
+		elapsed = time.Nanosecond
+	}
+	return float64(len(data)) / (1 << 20) / elapsed.Seconds(), nil
+}
+
+// SpeedTest measures each peer in turn, records the results in State and
+// returns them fastest first. Peers that can't be measured are reported in
+// failed and stay unranked.
+func SpeedTest(fileInfo *FileInfo, peers []string) (results []PeerSpeed, failed map[string]error) {
+	failed = make(map[string]error)
+	for _, peer := range peers {
+		mbps, err := measurePeerSpeed(peer, fileInfo)
+		if err != nil {
+			failed[peer] = err
+			continue
+		}
+		recordPeerSpeed(peer, mbps, time.Now())
+		results = append(results, PeerSpeed{Peer: peer, MBps: mbps})
+	}
+	sort.SliceStable(results, func(a, b int) bool { return results[a].MBps > results[b].MBps })
+	return results, failed
+}
+
+func recordPeerSpeed(peer string, mbps float64, at time.Time) {
+	State.PeerSpeeds[peer] = mbps
+	State.PeerSpeedsAt[peer] = at
+}
+
+// peerSpeed returns peer's measured speed if it is no older than speedResultTTL.
+func peerSpeed(peer string, now time.Time) (float64, bool) {
+	at, ok := State.PeerSpeedsAt[peer]
+	if !ok || now.Sub(at) > speedResultTTL {
+		return 0, false
+	}
+	return State.PeerSpeeds[peer], true
+}
+
+// rankPeers orders peers fastest first by their fresh speed results.
+// Unranked peers follow, keeping their given (round-robin) order.
+func rankPeers(peers []string, now time.Time) []string {
+	speeds := make(map[string]float64, len(peers))
+	ranked := make([]string, 0, len(peers))
+	var unranked []string
+	for _, p := range peers {
+		if s, ok := peerSpeed(p, now); ok {
+			speeds[p] = s
+			ranked = append(ranked, p)
+		} else {
+			unranked = append(unranked, p)
+		}
+	}
+	sort.SliceStable(ranked, func(a, b int) bool { return speeds[ranked[a]] > speeds[ranked[b]] })
+	return append(ranked, unranked...)
+}
+
+// unrankedPeers returns the peers without a fresh speed result.
+func unrankedPeers(peers []string, now time.Time) []string {
+	var res []string
+	for _, p := range peers {
+		if _, ok := peerSpeed(p, now); !ok {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// printSpeedResults prints a speed_test ranking.
+func printSpeedResults(results []PeerSpeed, failed map[string]error) {
+	for i, r := range results {
+		fmt.Printf("%d. %s  %.2f MB/s\n", i+1, r.Peer, r.MBps)
+	}
+	for peer, err := range failed {
+		fmt.Printf("✗ %s: %v\n", peer, err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useTestSpeeds gives the test an empty set of speed results.
+func useTestSpeeds(t *testing.T) {
+	t.Helper()
+	savedSpeeds, savedAt := State.PeerSpeeds, State.PeerSpeedsAt
+	State.PeerSpeeds = make(map[string]float64)
+	State.PeerSpeedsAt = make(map[string]time.Time)
+	t.Cleanup(func() { State.PeerSpeeds, State.PeerSpeedsAt = savedSpeeds, savedAt })
+}
+
+func TestRankPeers_FastestFirstThenRoundRobin(t *testing.T) {
+	useTestSpeeds(t)
+	now := time.Now()
+	recordPeerSpeed("slow", 1.5, now)
+	recordPeerSpeed("fast", 40, now)
+	recordPeerSpeed("mid", 12, now)
+
+	got := rankPeers([]string{"u2", "slow", "u1", "fast", "mid"}, now)
+	want := []string{"fast", "mid", "slow", "u2", "u1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("rankPeers = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRankPeers_ResultsExpire(t *testing.T) {
+	useTestSpeeds(t)
+	measured := time.Now()
+	recordPeerSpeed("fast", 40, measured)
+
+	peers := []string{"a", "fast"}
+	if got := rankPeers(peers, measured.Add(speedResultTTL)); got[0] != "fast" {
+		t.Errorf("result should still be fresh at the TTL: %v", got)
+	}
+	if got := rankPeers(peers, measured.Add(speedResultTTL+time.Second)); got[0] != "a" || got[1] != "fast" {
+		t.Errorf("expired result still used: %v", got)
+	}
+	if stale := unrankedPeers(peers, measured.Add(speedResultTTL+time.Second)); len(stale) != 2 {
+		t.Errorf("unranked after expiry = %v, want both", stale)
+	}
+}
+
+// TestChunkCandidates_PrefersRankedPeer verifies the download path uses the rankings.
+func TestChunkCandidates_PrefersRankedPeer(t *testing.T) {
+	useTestSpeeds(t)
+	recordPeerSpeed("p3", 5, time.Now())
+	info := &FileInfo{Peers: []string{"p1", "p2", "p3"}}
+
+	for i := 0; i < 3; i++ {
+		if got := chunkCandidates(info, nil, i); got[0] != "p3" {
+			t.Errorf("chunk %d: candidates %v, want p3 first", i, got)
+		}
+	}
+	// Unranked peers still take turns behind the ranked one
+	if got := chunkCandidates(info, nil, 1); got[1] != "p2" || got[2] != "p1" {
+		t.Errorf("chunk 1: candidates %v, want p2 then p1 after p3", got)
+	}
+}
+
+// TestSpeedTest_MeasuresAndRecords runs a speed test against one working and
+// one unreachable peer.
+func TestSpeedTest_MeasuresAndRecords(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useTestSpeeds(t)
+	meta, _ := chunkTestFile(t, ChunkSize+100)
+	peer := startMemoryPeer(t, meta.FileHash, memoryChunks(t, meta))
+	dead := "127.0.0.1:1"
+
+	info := &FileInfo{FileHash: meta.FileHash, Chunks: meta.Chunks, TotalChunks: meta.TotalChunks}
+	results, failed := SpeedTest(info, []string{dead, peer})
+	if len(results) != 1 || results[0].Peer != peer || results[0].MBps <= 0 {
+		t.Fatalf("results = %+v", results)
+	}
+	if _, ok := failed[dead]; !ok || len(failed) != 1 {
+		t.Errorf("failed = %v, want only %s", failed, dead)
+	}
+	if _, ok := peerSpeed(peer, time.Now()); !ok {
+		t.Error("speed not recorded in State")
+	}
+	if _, ok := peerSpeed(dead, time.Now()); ok {
+		t.Error("unreachable peer was ranked")
+	}
+}
@@ -1,15 +1,30 @@
 package main
 
+import (
+	"sync"
+	"time"
+)
+
 type ClientState struct {
 	UserID         string
 	ListenAddr     string
 	Files          map[string]string // filename -> filepath
 	TrackerAddrs   []string          // All configured tracker addresses
 	ActiveTrackers []string          // Currently responsive trackers
+
+	// Peer bandwidth from speed_test in MB/s, and when each was measured
+	PeerSpeeds   map[string]float64
+	PeerSpeedsAt map[string]time.Time
+
+	// PeerBlacklist maps peers that keep failing to when their ban ends;
+	// requestChunk won't dial them until then
+	PeerBlacklist sync.Map
 }
 
 var State = &ClientState{
 	TrackerAddrs:   []string{},
 	ActiveTrackers: []string{},
 	Files:          make(map[string]string),
+	PeerSpeeds:     make(map[string]float64),
+	PeerSpeedsAt:   make(map[string]time.Time),
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// StdoutPath is the download destination that selects streaming mode.
+const StdoutPath = "-"
+
+// StreamDownloadFile downloads a file chunk by chunk and writes each
+// validated chunk to w in index order. Nothing is assembled or cached on
+// disk, so rarest-first is not used. With P2P_PARALLEL set, chunks are
+// fetched from several peers at once and put back in order by a
+// ReorderBuffer. Progress goes to stderr so that w can safely be os.Stdout.
+func StreamDownloadFile(ctx context.Context, groupID, fileName string, w io.Writer) error {
+	fileInfo, err := queryFileInfo(groupID, fileName)
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %v", err)
+	}
+	addDHTPeers(fileInfo)
+	return streamChunks(ctx, fileInfo, w)
+}
+
+// streamWindow is how many chunks per worker may be held in memory ahead
+// of the next one due on the writer.
+const streamWindow = 2
+
+// streamChunks fetches every chunk of fileInfo, spreading them across its
+// peers, and writes them to w in order. A chunk is written only after its
+// hash has been checked.
+func streamChunks(ctx context.Context, fileInfo *FileInfo, w io.Writer) error {
+	if len(fileInfo.Peers) == 0 {
+		return errors.New("no peers available for download")
+	}
+	if len(fileInfo.Chunks) < fileInfo.TotalChunks {
+		return fmt.Errorf("tracker listed %d chunk hashes for %d chunks", len(fileInfo.Chunks), fileInfo.TotalChunks)
+	}
+
+	// The file is hashed as it goes out, to be checked once it is all written
+	hash, err := newHasher(fileInfo.hashAlgorithm())
+	if err != nil {
+		return err
+	}
+
+	transfers.NameFile(fileInfo.FileHash, fileInfo.FileName, fileInfo.TotalChunks)
+	workers := parallelWorkers()
+	buf := NewReorderBuffer(io.MultiWriter(w, hash), streamWindow*workers)
+	fetch := func(i int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		peer := fileInfo.Peers[i%len(fileInfo.Peers)]
+		fmt.Fprintf(os.Stderr, "Streaming chunk %d/%d from %s...\n", i+1, fileInfo.TotalChunks, peer)
+
+		chunkData, algo, err := requestChunk(peer, fileInfo.FileHash, i)
+		if err != nil {
+			return fmt.Errorf("failed to download chunk %d: %v", i, err)
+		}
+		if !validateChunk(chunkData, algo, fileInfo.Chunks[i]) {
+			recordPeerFailure(peer)
+			return fmt.Errorf("chunk %d hash mismatch", i)
+		}
+		recordPeerSuccess(peer)
+		return buf.WriteChunk(i, chunkData)
+	}
+
+	order := make([]int, fileInfo.TotalChunks)
+	for i := range order {
+		order[i] = i
+	}
+	// A failed chunk must release workers waiting for it to be written
+	err = runWorkers(order, workers, func(i int) error {
+		err := fetch(i)
+		if err != nil {
+			buf.Abort(err)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != fileInfo.FileHash {
+		return fmt.Errorf("streamed file has hash %s, want %s", got, fileInfo.FileHash)
+	}
+	return nil
+}
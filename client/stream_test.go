@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// startTestPeer serves the local chunk store on a random port for the test's lifetime.
+func startTestPeer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handlePeerConn(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// streamTestInfo builds the FileInfo a tracker would return for meta.
+func streamTestInfo(meta *ChunkMetadata, peers ...string) *FileInfo {
+	return &FileInfo{
+		FileName:    meta.FileName,
+		FileHash:    meta.FileHash,
+		FileSize:    meta.FileSize,
+		ChunkSize:   meta.ChunkSize,
+		TotalChunks: meta.TotalChunks,
+		Chunks:      meta.Chunks,
+		Peers:       peers,
+	}
+}
+
+// TestStreamChunks_WritesFileInOrder streams a 3-chunk file from a local peer
+// and checks the output is byte-identical to the original.
+func TestStreamChunks_WritesFileInOrder(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*ChunkSize+100)
+	peer := startTestPeer(t)
+
+	var out bytes.Buffer
+	if err := streamChunks(context.Background(), streamTestInfo(meta, peer), &out); err != nil {
+		t.Fatalf("streamChunks: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Errorf("streamed %d bytes, want %d identical bytes", out.Len(), len(content))
+	}
+}
+
+// TestStreamChunks_StopsAtCorruptChunk verifies a chunk failing validation is
+// never written and earlier chunks are.
+func TestStreamChunks_StopsAtCorruptChunk(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, content := chunkTestFile(t, 2*ChunkSize+100)
+	peer := startTestPeer(t)
+
+	chunkPath := filepath.Join(ChunksDir, meta.FileHash, "chunk_1.dat")
+	if err := os.WriteFile(chunkPath, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := streamChunks(context.Background(), streamTestInfo(meta, peer), &out); err == nil {
+		t.Fatal("expected hash mismatch error")
+	}
+	if !bytes.Equal(out.Bytes(), content[:meta.ChunkSize]) {
+		t.Errorf("expected only chunk 0 on the writer, got %d bytes", out.Len())
+	}
+}
+
+// TestStreamChunks_Cancelled verifies a cancelled context stops before any data is written.
+func TestStreamChunks_Cancelled(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, ChunkSize+1)
+	peer := startTestPeer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out bytes.Buffer
+	if err := streamChunks(ctx, streamTestInfo(meta, peer), &out); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("nothing should be written after cancel, got %d bytes", out.Len())
+	}
+}
+
+// TestStreamChunks_NoPeers verifies the no-peer case fails up front.
+func TestStreamChunks_NoPeers(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 10)
+	if err := streamChunks(context.Background(), streamTestInfo(meta), &bytes.Buffer{}); err == nil {
+		t.Fatal("expected error with no peers")
+	}
+}
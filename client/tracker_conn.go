@@ -2,31 +2,35 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"net"
 	"os"
 	"p2p/common"
 	"strings"
+	"sync"
 	"time"
 )
 
+// trackerEndpoint parses a tracker_info.txt entry. P2P_TRACKER_TLS=1 makes
+// every tracker TLS even when its entry has no tls:// prefix.
+func trackerEndpoint(addr string) common.TrackerEndpoint {
+	e := common.ParseTrackerEndpoint(addr)
+	if os.Getenv("P2P_TRACKER_TLS") == "1" {
+		e.TLS = true
+	}
+	return e
+}
+
 // SendToTracker tries active trackers first, then any remaining known trackers.
-// Returns the first successful response. Fast failover — no re-scan.
+// Returns the first successful response. Each tracker is retried per the
+// command's RetryPolicy before failing over to the next — no re-scan.
 func SendToTracker(msg Message) Response {
-	// Build candidate list: active trackers first, then remaining known addresses
-	seen := make(map[string]bool)
-	candidates := make([]string, 0)
-	for _, addr := range State.ActiveTrackers {
-		candidates = append(candidates, addr)
-		seen[addr] = true
-	}
-	for _, addr := range State.TrackerAddrs {
-		if !seen[addr] {
-			candidates = append(candidates, addr)
-		}
+	if offline {
+		return Response{"error", errOffline.Error()}
 	}
-
-	for _, addr := range candidates {
-		resp, ok := tryTracker(addr, msg)
+	policy := retryPolicyFor(msg.Cmd)
+	for _, addr := range trackerCandidates() {
+		resp, ok := tryTrackerWithRetry(addr, msg, policy)
 		if ok {
 			return resp
 		}
@@ -35,6 +39,57 @@ func SendToTracker(msg Message) Response {
 	return Response{"error", "no trackers available"}
 }
 
+// trackerCandidates lists active trackers first, then remaining known
+// addresses. Trackers marked down come last, in case they have recovered.
+func trackerCandidates() []string {
+	seen := make(map[string]bool)
+	candidates := make([]string, 0)
+	var down []string
+	now := time.Now()
+	for _, addrs := range [][]string{State.ActiveTrackers, State.TrackerAddrs} {
+		for _, addr := range addrs {
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			if trackerDown(addr, now) {
+				down = append(down, addr)
+			} else {
+				candidates = append(candidates, addr)
+			}
+		}
+	}
+	return append(candidates, down...)
+}
+
+// StreamFromTracker sends msg as a streaming request and passes each
+// response frame to cb as it arrives. Trackers are tried in the same order
+// as SendToTracker, but only until one accepts the request: a stream that
+// breaks part way is not restarted elsewhere, as cb has already seen items.
+func StreamFromTracker(msg Message, cb func(Response)) error {
+	if offline {
+		return errOffline
+	}
+	msg.Stream = true
+
+	for _, addr := range trackerCandidates() {
+		conn, err := common.DialTracker(trackerEndpoint(addr), 1*time.Second)
+		if err != nil {
+			continue
+		}
+		setKeepalive(conn)
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+		if err := common.Send(conn, msg); err != nil {
+			conn.Close()
+			continue
+		}
+		err = common.RecvStream(conn, func(r common.Response) { cb(Response(r)) })
+		conn.Close()
+		return err
+	}
+	return errors.New("no trackers available")
+}
+
 // BroadcastToTrackers sends message to all active trackers (for state changes)
 func BroadcastToTrackers(msg Message) []Response {
 	responses := make([]Response, 0)
@@ -63,34 +118,177 @@ done:
 	return responses
 }
 
+// trackerTimeout bounds each tracker request.
+const trackerTimeout = 5 * time.Second
+
+// One multiplexed connection is kept open per tracker, so requests to it
+// don't each pay for a dial (and TLS handshake) and can be in flight
+// together. plainTrackers are those that closed the connection after one
+// response, as trackers without multiplexing do; they get a connection per request.
+// v1Trackers are those that don't answer hello, which isn't sent to them again.
+var (
+	muxMu         sync.Mutex
+	trackerMuxes  = make(map[string]*trackerConn)
+	plainTrackers = make(map[string]bool)
+	v1Trackers    = make(map[string]bool)
+)
+
+// protocolVersion is the newest protocol version this client offers in hello.
+var protocolVersion = common.ProtocolVersion
+
+// wireFormat is the format --protocol asks for tracker requests to be sent
+// in. It is offered in hello; trackers that don't take it get JSON.
+var wireFormat = common.WireJSON
+
+// trackerConn is a multiplexed tracker connection and the protocol
+// version agreed on it.
+type trackerConn struct {
+	*common.Mux
+	version int
+}
+
 // tryTracker attempts to send message to a single tracker
 func tryTracker(addr string, msg Message) (Response, bool) {
-	conn, err := net.DialTimeout("tcp", addr, 1*time.Second)
+	muxMu.Lock()
+	mux := trackerMuxes[addr]
+	muxMu.Unlock()
+	if mux != nil {
+		var resp Response
+		err := mux.Call(msg, &resp, trackerTimeout)
+		if err == nil {
+			return resp, true
+		}
+		dropTrackerMux(addr, mux)
+		// Only redial if the request can't have run: the connection had
+		// closed before it was sent, left idle too long or by a tracker
+		// restart, or the tracker never answered on it, so it wasn't
+		// serving it
+		if mux.Answered() && !errors.Is(err, common.ErrMuxClosed) {
+			return Response{}, false
+		}
+	}
+
+	conn, version, format, err := dialTracker(addr)
 	if err != nil {
 		return Response{}, false
 	}
-	defer conn.Close()
-	
-	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	muxMu.Lock()
+	msg.Mux = !plainTrackers[addr]
+	muxMu.Unlock()
 	
-	if err := common.Send(conn, msg); err != nil {
+	if err := common.SendFormat(conn, format, msg); err != nil {
+		conn.Close()
 		return Response{}, false
 	}
 	
 	var resp Response
 	if err := common.Recv(conn, &resp); err != nil {
+		conn.Close()
 		return Response{}, false
 	}
-	
+
+	if !msg.Mux {
+		conn.Close()
+		return resp, true
+	}
+	conn.SetDeadline(time.Time{})
+	muxMu.Lock()
+	if trackerMuxes[addr] == nil {
+		trackerMuxes[addr] = &trackerConn{common.NewMuxFormat(conn, format), version}
+	} else {
+		conn.Close()
+	}
+	muxMu.Unlock()
 	return resp, true
 }
 
-// UpdateActiveTrackers checks which trackers are responsive
+// dialTracker connects to the tracker at addr, ready for a request, and
+// returns the protocol version and wire format agreed with it. A tracker
+// from before hello closes the connection after refusing it, so it is
+// dialled again.
+func dialTracker(addr string) (net.Conn, int, common.WireFormat, error) {
+	if offline {
+		return nil, 0, 0, errOffline
+	}
+	conn, err := common.DialTracker(trackerEndpoint(addr), 1*time.Second)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	setKeepalive(conn)
+	conn.SetDeadline(time.Now().Add(trackerTimeout))
+
+	muxMu.Lock()
+	v1 := v1Trackers[addr]
+	muxMu.Unlock()
+	if v1 {
+		return conn, common.ProtocolV1, common.WireJSON, nil
+	}
+	version, format, err := trackerHello(conn)
+	if err != nil {
+		conn.Close()
+		return nil, 0, 0, err
+	}
+	if version == common.ProtocolV1 {
+		conn.Close()
+		muxMu.Lock()
+		v1Trackers[addr] = true
+		muxMu.Unlock()
+		return dialTracker(addr)
+	}
+	return conn, version, format, nil
+}
+
+// trackerHello offers protocolVersion, and wireFormat unless it is JSON,
+// to the tracker on conn. It returns the version both will use, the older
+// of the two, and the format requests are then sent in. A tracker that
+// doesn't answer with a version is taken to speak version 1.
+func trackerHello(conn net.Conn) (int, common.WireFormat, error) {
+	msg := Message{Cmd: common.HelloCmd, Args: []string{common.FormatProtocolVersion(protocolVersion)}}
+	if wireFormat != common.WireJSON {
+		msg.Args = append(msg.Args, wireFormat.String())
+	}
+	if err := common.Send(conn, msg); err != nil {
+		return 0, 0, err
+	}
+	var resp Response
+	if err := common.Recv(conn, &resp); err != nil {
+		return 0, 0, err
+	}
+	s, _ := resp.Data.(string)
+	v, err := common.ParseProtocolVersion(s)
+	if resp.Status != "ok" || err != nil {
+		return common.ProtocolV1, common.WireJSON, nil
+	}
+	version := min(v, protocolVersion)
+	return version, common.NegotiateWireFormat(version, msg.Args[1:]), nil
+}
+
+// dropTrackerMux forgets a failed multiplexed connection. If the tracker
+// never answered on it, the tracker doesn't multiplex.
+func dropTrackerMux(addr string, mux *trackerConn) {
+	mux.Close()
+	muxMu.Lock()
+	defer muxMu.Unlock()
+	if trackerMuxes[addr] == mux {
+		delete(trackerMuxes, addr)
+	}
+	if !mux.Answered() {
+		plainTrackers[addr] = true
+	}
+}
+
+// UpdateActiveTrackers checks which trackers are responsive; offline, none are
 func UpdateActiveTrackers() {
 	active := make([]string, 0)
+	if offline {
+		State.ActiveTrackers = active
+		return
+	}
 	
 	for _, addr := range State.TrackerAddrs {
-		conn, err := net.DialTimeout("tcp", addr, 500*time.Millisecond)
+		// A plain TCP probe; the TLS handshake is left to the real request
+		conn, err := net.DialTimeout("tcp", trackerEndpoint(addr).Addr, 500*time.Millisecond)
 		if err == nil {
 			conn.Close()
 			active = append(active, addr)
@@ -100,7 +298,8 @@ func UpdateActiveTrackers() {
 	State.ActiveTrackers = active
 }
 
-// LoadTrackerConfig reads tracker addresses from a config file (one address per line).
+// LoadTrackerConfig reads tracker addresses from a config file (one address per line,
+// optionally tls:// prefixed and followed by a sha256: certificate fingerprint).
 // It populates State.TrackerAddrs and probes for responsive ones into State.ActiveTrackers.
 func LoadTrackerConfig(configFile string) {
 	file, err := os.Open(configFile)
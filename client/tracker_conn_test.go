@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"p2p/common"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTrackerEndpoint_EnvForcesTLS(t *testing.T) {
+	if e := trackerEndpoint("127.0.0.1:9000"); e.TLS {
+		t.Fatalf("plain entry came back as TLS: %+v", e)
+	}
+	t.Setenv("P2P_TRACKER_TLS", "1")
+	if e := trackerEndpoint("127.0.0.1:9000"); !e.TLS || e.Addr != "127.0.0.1:9000" {
+		t.Fatalf("P2P_TRACKER_TLS=1 gave %+v", e)
+	}
+}
+
+// startMuxTracker is a tracker stand-in that multiplexes like the real one:
+// after a request with Mux set it keeps the connection and answers framed
+// requests, echoing each command's first argument. It counts connections.
+func startMuxTracker(t *testing.T) (string, *int64) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var dials int64
+	echo := func(msg Message) Response {
+		if len(msg.Args) == 0 {
+			return Response{"ok", ""}
+		}
+		return Response{"ok", msg.Args[0]}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&dials, 1)
+			go func(c net.Conn) {
+				defer c.Close()
+				var msg Message
+				if recvRequest(c, &msg) != nil || common.Send(c, echo(msg)) != nil || !msg.Mux {
+					return
+				}
+				var wmu sync.Mutex
+				for {
+					id, data, err := common.RecvMux(c)
+					if err != nil {
+						return
+					}
+					go func() {
+						var m Message
+						json.Unmarshal(data, &m)
+						wmu.Lock()
+						defer wmu.Unlock()
+						common.SendMux(c, id, echo(m))
+					}()
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), &dials
+}
+
+// forgetTrackerConns drops the multiplexed connections tests opened.
+func forgetTrackerConns(t *testing.T) {
+	t.Cleanup(func() {
+		muxMu.Lock()
+		defer muxMu.Unlock()
+		for addr, m := range trackerMuxes {
+			m.Close()
+			delete(trackerMuxes, addr)
+		}
+		plainTrackers = make(map[string]bool)
+		v1Trackers = make(map[string]bool)
+	})
+}
+
+// recvRequest reads a request from a client of a tracker stand-in,
+// answering the hello before it as a version 2 tracker does.
+func recvRequest(c net.Conn, msg *Message) error {
+	if err := common.Recv(c, msg); err != nil || msg.Cmd != common.HelloCmd {
+		return err
+	}
+	if err := common.Send(c, Response{"ok", "v2"}); err != nil {
+		return err
+	}
+	*msg = Message{}
+	return common.Recv(c, msg)
+}
+
+// startVersionedTracker is a tracker stand-in speaking protocol version
+// up to version. Like trackers from before hello, one speaking only
+// version 1 refuses hello as an unknown command. It answers each request
+// with its command on a connection of its own, and records what it got,
+// hellos included. A request that should have come in MsgPack but didn't
+// is dropped.
+func startVersionedTracker(t *testing.T, version int) (string, func() []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	var got []string
+	record := func(msg Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, strings.TrimSpace(msg.Cmd+" "+strings.Join(msg.Args, " ")))
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var msg Message
+				if common.Recv(c, &msg) != nil {
+					return
+				}
+				record(msg)
+				if msg.Cmd == common.HelloCmd {
+					if version == common.ProtocolV1 {
+						common.Send(c, Response{"error", "unkown command"})
+						return
+					}
+					offered, _ := common.ParseProtocolVersion(msg.Args[0])
+					recv := common.Recv
+					if common.NegotiateWireFormat(min(offered, version), msg.Args[1:]) == common.WireMsgPack {
+						recv = common.RecvMsgPack
+					}
+					msg = Message{}
+					if common.Send(c, Response{"ok", common.FormatProtocolVersion(version)}) != nil || recv(c, &msg) != nil {
+						return
+					}
+					record(msg)
+				}
+				common.Send(c, Response{"ok", msg.Cmd})
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), got...)
+	}
+}
+
+// TestTryTracker_NegotiatesVersion sends two requests from clients and to
+// trackers of different protocol versions and checks the version and
+// format agreed and what the tracker got. A version 1 tracker is dialled
+// again after it refuses hello, and isn't sent hello after that.
+func TestTryTracker_NegotiatesVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		client, tracker int
+		format          common.WireFormat // --protocol
+		want            int
+		wantFormat      common.WireFormat
+		got             string
+	}{
+		{"client v2, tracker v2", 2, 2, common.WireJSON, 2, common.WireJSON, "hello v2,first,hello v2,second"},
+		{"client v2, tracker v1", 2, 1, common.WireJSON, 1, common.WireJSON, "hello v2,first,second"},
+		{"client v3, tracker v2", 3, 2, common.WireJSON, 2, common.WireJSON, "hello v3,first,hello v3,second"},
+		{"msgpack, tracker v3", 3, 3, common.WireMsgPack, 3, common.WireMsgPack, "hello v3 msgpack,first,hello v3 msgpack,second"},
+		{"msgpack, tracker v2", 3, 2, common.WireMsgPack, 2, common.WireJSON, "hello v3 msgpack,first,hello v3 msgpack,second"},
+		{"msgpack, tracker v1", 3, 1, common.WireMsgPack, 1, common.WireJSON, "hello v3 msgpack,first,second"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker, got := startVersionedTracker(t, tc.tracker)
+			forgetTrackerConns(t)
+			protocolVersion, wireFormat = tc.client, tc.format
+			t.Cleanup(func() { protocolVersion, wireFormat = common.ProtocolVersion, common.WireJSON })
+
+			conn, version, format, err := dialTracker(tracker)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if version != tc.want || format != tc.wantFormat {
+				t.Errorf("agreed on v%d in %s, want v%d in %s", version, format, tc.want, tc.wantFormat)
+			}
+			var resp Response
+			if common.SendFormat(conn, format, Message{Cmd: "first"}) != nil || common.Recv(conn, &resp) != nil || resp.Data != "first" {
+				t.Fatalf("first request = %+v", resp)
+			}
+			if resp, ok := tryTracker(tracker, Message{Cmd: "second"}); !ok || resp.Data != "second" {
+				t.Fatalf("second request = %+v", resp)
+			}
+			if g := strings.Join(got(), ","); g != tc.got {
+				t.Errorf("tracker got %s, want %s", g, tc.got)
+			}
+		})
+	}
+}
+
+// TestSendToTracker_SharesOneConnection sends concurrent requests and
+// checks they all went over one connection with the right answers.
+func TestSendToTracker_SharesOneConnection(t *testing.T) {
+	tracker, dials := startMuxTracker(t)
+	useTestNetwork(t, tracker, nil)
+	forgetTrackerConns(t)
+
+	if resp := SendToTracker(Message{Cmd: "echo", Args: []string{"first"}}); resp.Data != "first" {
+		t.Fatalf("first request = %+v", resp)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := fmt.Sprintf("req-%d", i)
+			if resp := SendToTracker(Message{Cmd: "echo", Args: []string{want}}); resp.Data != want {
+				t.Errorf("request %d got %+v", i, resp)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt64(dials); n != 1 {
+		t.Errorf("opened %d connections, want 1", n)
+	}
+	muxMu.Lock()
+	defer muxMu.Unlock()
+	// recvRequest answers hello as version 2
+	if m := trackerMuxes[tracker]; m == nil || m.version != 2 {
+		t.Errorf("connection %+v, want one on version 2", m)
+	}
+}
+
+// TestTryTracker_RedialsClosedMux closes a connection the tracker has
+// answered on, as the tracker does one left idle, and checks the next
+// request is sent on a new one rather than failing.
+func TestTryTracker_RedialsClosedMux(t *testing.T) {
+	tracker, dials := startMuxTracker(t)
+	forgetTrackerConns(t)
+
+	// The first request opens the connection, the second is answered on it
+	for _, arg := range []string{"first", "again"} {
+		if _, ok := tryTracker(tracker, Message{Cmd: "echo", Args: []string{arg}}); !ok {
+			t.Fatalf("request %q failed", arg)
+		}
+	}
+	muxMu.Lock()
+	m := trackerMuxes[tracker]
+	muxMu.Unlock()
+	if m == nil || !m.Answered() {
+		t.Fatalf("no answered connection kept: %+v", m)
+	}
+	m.Close()
+	for deadline := time.Now().Add(time.Second); m.Err() == nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("connection never noticed it was closed")
+		}
+	}
+
+	if resp, ok := tryTracker(tracker, Message{Cmd: "echo", Args: []string{"second"}}); !ok || resp.Data != "second" {
+		t.Fatalf("request after the close = %+v, %v", resp, ok)
+	}
+	if n := atomic.LoadInt64(dials); n != 2 {
+		t.Errorf("opened %d connections, want 2", n)
+	}
+}
+
+// TestSendToTracker_FallsBackWithoutMux uses a tracker that closes every
+// connection after one response, as trackers without multiplexing do.
+func TestSendToTracker_FallsBackWithoutMux(t *testing.T) {
+	tracker, dials := startCountingTracker(t)
+	useTestNetwork(t, tracker, nil)
+	forgetTrackerConns(t)
+
+	for i := 0; i < 3; i++ {
+		if resp := SendToTracker(Message{Cmd: "list_groups"}); resp.Status != "ok" {
+			t.Fatalf("request %d = %+v", i, resp)
+		}
+	}
+	if n := atomic.LoadInt64(dials); n != 3 {
+		t.Errorf("opened %d connections, want one per request", n)
+	}
+	muxMu.Lock()
+	defer muxMu.Unlock()
+	if !plainTrackers[tracker] {
+		t.Error("tracker not marked as plain after closing the connection")
+	}
+}
+
+// startFlakyTracker is a tracker stand-in that drops its first failures
+// connections without answering, then answers every request with ok. It
+// counts connections.
+func startFlakyTracker(t *testing.T, failures int64) (string, *int64) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var dials int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			n := atomic.AddInt64(&dials, 1)
+			go func(c net.Conn) {
+				defer c.Close()
+				var msg Message
+				if recvRequest(c, &msg) != nil || n <= failures {
+					return
+				}
+				common.Send(c, Response{"ok", msg.Cmd})
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), &dials
+}
+
+// useRetryPolicy sets the policy for cmd for the test's lifetime and
+// forgets which trackers it marked down.
+func useRetryPolicy(t *testing.T, cmd string, p RetryPolicy) {
+	retryPolicies[cmd] = p
+	t.Cleanup(func() {
+		delete(retryPolicies, cmd)
+		downMu.Lock()
+		defer downMu.Unlock()
+		downTrackers = make(map[string]time.Time)
+	})
+}
+
+// TestSendToTracker_RetriesSameTracker has the only tracker fail twice
+// and checks the request is still delivered on the third attempt.
+func TestSendToTracker_RetriesSameTracker(t *testing.T) {
+	tracker, dials := startFlakyTracker(t, 2)
+	useTestNetwork(t, tracker, nil)
+	forgetTrackerConns(t)
+	useRetryPolicy(t, "echo", RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+
+	if resp := SendToTracker(Message{Cmd: "echo"}); resp.Status != "ok" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if n := atomic.LoadInt64(dials); n != 3 {
+		t.Errorf("connections = %d, want 3", n)
+	}
+	if trackerDown(tracker, time.Now()) {
+		t.Error("tracker marked down after it answered")
+	}
+}
+
+// TestSendToTracker_FailsOverAfterRetries has the first tracker fail more
+// often than the policy allows and checks the request moves on to the
+// second, and the first is tried last from then on.
+func TestSendToTracker_FailsOverAfterRetries(t *testing.T) {
+	flaky, flakyDials := startFlakyTracker(t, 100)
+	good, _ := startFlakyTracker(t, 0)
+	useTestNetwork(t, flaky, nil)
+	State.TrackerAddrs = []string{flaky, good}
+	forgetTrackerConns(t)
+	useRetryPolicy(t, "echo", RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond})
+
+	if resp := SendToTracker(Message{Cmd: "echo"}); resp.Status != "ok" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if n := atomic.LoadInt64(flakyDials); n != 2 {
+		t.Errorf("failing tracker tried %d times, want 2", n)
+	}
+	if c := trackerCandidates(); len(c) != 2 || c[0] != good {
+		t.Errorf("candidates = %v, want the working tracker first", c)
+	}
+}
+
+func TestRetryPolicyFor(t *testing.T) {
+	if retryPolicyFor("list_files") != readRetryPolicy || retryPolicyFor("get_file_info") != readRetryPolicy {
+		t.Error("reads don't get the read policy")
+	}
+	if retryPolicyFor("upload_file") != writeRetryPolicy {
+		t.Error("writes don't get the write policy")
+	}
+	if readRetryPolicy.MaxAttempts <= writeRetryPolicy.MaxAttempts {
+		t.Error("reads should retry more than writes")
+	}
+	useRetryPolicy(t, "upload_file", RetryPolicy{MaxAttempts: 5})
+	if retryPolicyFor("upload_file").MaxAttempts != 5 {
+		t.Error("per-command override ignored")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransfersDir holds one snapshot file per running client process, so
+// show_transfers can see the peer daemon's uploads and every download.
+const TransfersDir = ".transfers"
+
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+)
+
+const (
+	transferIdle     = 10 * time.Second // a row with no chunk for this long is finished
+	transferStale    = 5 * time.Second  // a snapshot file this old belongs to a dead process
+	transferInterval = time.Second      // how often snapshots are written and redrawn
+)
+
+// transferEntry is the running total for one direction, file and peer.
+type transferEntry struct {
+	mu      sync.Mutex
+	chunks  int
+	bytes   int64
+	started time.Time
+	updated time.Time
+}
+
+type transferFile struct {
+	name        string
+	totalChunks int
+}
+
+// TransferRow is one line of show_transfers.
+type TransferRow struct {
+	Direction   string        `json:"direction"`
+	FileHash    string        `json:"file_hash"`
+	FileName    string        `json:"file_name"`
+	Peer        string        `json:"peer"`
+	Chunks      int           `json:"chunks"`
+	TotalChunks int           `json:"total_chunks"` // 0 if unknown
+	Bytes       int64         `json:"bytes"`
+	Rate        float64       `json:"rate"` // bytes per second
+	ETA         time.Duration `json:"eta"`  // 0 if unknown
+}
+
+// TransferRegistry tracks chunks moving to and from peers, keyed by
+// "direction:fileHash:peerAddr".
+type TransferRegistry struct {
+	entries sync.Map // key -> *transferEntry
+	files   sync.Map // fileHash -> transferFile
+
+	publishOnce sync.Once
+}
+
+var transfers = &TransferRegistry{}
+
+func transferKey(direction, fileHash, peer string) string {
+	return direction + ":" + fileHash + ":" + peer
+}
+
+// NameFile records the name and chunk count shown for fileHash.
+func (r *TransferRegistry) NameFile(fileHash, name string, totalChunks int) {
+	r.files.Store(fileHash, transferFile{name, totalChunks})
+}
+
+// Record counts one chunk of n bytes moved in direction with peer.
+func (r *TransferRegistry) Record(direction, fileHash, peer string, n int64, now time.Time) {
+	v, _ := r.entries.LoadOrStore(transferKey(direction, fileHash, peer), &transferEntry{started: now})
+	e := v.(*transferEntry)
+	e.mu.Lock()
+	if now.Sub(e.updated) > transferIdle && e.chunks > 0 {
+		// A new transfer after the last one finished starts from zero
+		e.chunks, e.bytes, e.started = 0, 0, now
+	}
+	e.chunks++
+	e.bytes += n
+	e.updated = now
+	e.mu.Unlock()
+
+	if _, ok := r.files.Load(fileHash); !ok && direction == DirectionUp {
+		// Uploads only see the hash; the local metadata has the name
+		if meta, err := loadChunkMetadata(fileHash); err == nil {
+			r.NameFile(fileHash, meta.FileName, meta.TotalChunks)
+		}
+	}
+}
+
+// Snapshot lists the transfers active at now, downloads first.
+func (r *TransferRegistry) Snapshot(now time.Time) []TransferRow {
+	var rows []TransferRow
+	r.entries.Range(func(k, v any) bool {
+		parts := strings.SplitN(k.(string), ":", 3)
+		e := v.(*transferEntry)
+		e.mu.Lock()
+		row := TransferRow{Direction: parts[0], FileHash: parts[1], Peer: parts[2], Chunks: e.chunks, Bytes: e.bytes}
+		idle := now.Sub(e.updated) > transferIdle
+		if elapsed := now.Sub(e.started).Seconds(); elapsed > 0 {
+			row.Rate = float64(e.bytes) / elapsed
+		}
+		e.mu.Unlock()
+		if idle {
+			return true
+		}
+		row.FileName = row.FileHash
+		if f, ok := r.files.Load(row.FileHash); ok {
+			row.FileName, row.TotalChunks = f.(transferFile).name, f.(transferFile).totalChunks
+		}
+		rows = append(rows, row)
+		return true
+	})
+	fillETAs(rows)
+	sortTransfers(rows)
+	return rows
+}
+
+// fillETAs estimates time left from the average chunk size and the rate.
+// A download's chunks come from several peers, so its rows share one
+// estimate for the whole file; an upload is estimated per peer.
+func fillETAs(rows []TransferRow) {
+	type total struct {
+		chunks int
+		bytes  int64
+		rate   float64
+	}
+	downloads := make(map[string]*total)
+	for _, row := range rows {
+		if row.Direction == DirectionDown {
+			t := downloads[row.FileHash]
+			if t == nil {
+				t = &total{}
+				downloads[row.FileHash] = t
+			}
+			t.chunks += row.Chunks
+			t.bytes += row.Bytes
+			t.rate += row.Rate
+		}
+	}
+	for i := range rows {
+		t := total{rows[i].Chunks, rows[i].Bytes, rows[i].Rate}
+		if rows[i].Direction == DirectionDown {
+			t = *downloads[rows[i].FileHash]
+		}
+		left := rows[i].TotalChunks - t.chunks
+		if rows[i].TotalChunks == 0 || left < 0 || t.chunks == 0 || t.rate <= 0 {
+			continue
+		}
+		secs := float64(left) * float64(t.bytes) / float64(t.chunks) / t.rate
+		rows[i].ETA = time.Duration(secs * float64(time.Second))
+	}
+}
+
+func sortTransfers(rows []TransferRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Direction != b.Direction {
+			return a.Direction == DirectionDown
+		}
+		if a.FileName != b.FileName {
+			return a.FileName < b.FileName
+		}
+		return a.Peer < b.Peer
+	})
+}
+
+// StartPublishing writes this process's snapshot to TransfersDir every
+// second, removing the file while nothing is moving.
+func (r *TransferRegistry) StartPublishing() {
+	r.publishOnce.Do(func() {
+		publishSnapshots(TransfersDir, func() (interface{}, bool) {
+			rows := r.Snapshot(time.Now())
+			return rows, len(rows) > 0
+		})
+	})
+}
+
+// publishSnapshots writes snapshot's result as JSON to dir/<pid>.json every
+// second, removing the file while snapshot reports nothing to show.
+func publishSnapshots(dir string, snapshot func() (interface{}, bool)) {
+	path := filepath.Join(dir, fmt.Sprintf("%d.json", os.Getpid()))
+	go func() {
+		for range time.Tick(transferInterval) {
+			v, show := snapshot()
+			if !show {
+				os.Remove(path)
+				continue
+			}
+			data, err := json.Marshal(v)
+			if err != nil || os.MkdirAll(dir, 0755) != nil {
+				continue
+			}
+			tmp := path + ".tmp"
+			if os.WriteFile(tmp, data, 0644) == nil {
+				os.Rename(tmp, path)
+			}
+		}
+	}()
+}
+
+// readTransfers merges the snapshots written by running client processes.
+func readTransfers(now time.Time) ([]TransferRow, error) {
+	var rows []TransferRow
+	err := readSnapshots(TransfersDir, now, func(data []byte) {
+		var part []TransferRow
+		if json.Unmarshal(data, &part) == nil {
+			rows = append(rows, part...)
+		}
+	})
+	sortTransfers(rows)
+	return rows, err
+}
+
+// readSnapshots calls read with each snapshot file in dir, skipping (and
+// removing) those left by processes that have exited.
+func readSnapshots(dir string, now time.Time, read func(data []byte)) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) > transferStale {
+			os.Remove(path)
+			continue
+		}
+		if data, err := os.ReadFile(path); err == nil {
+			read(data)
+		}
+	}
+	return nil
+}
+
+// peerHost drops the port from a peer's address. Each chunk is served on a
+// fresh connection, so the port would split one peer across many rows.
+func peerHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// printTransfers writes the show_transfers table.
+func printTransfers(w io.Writer, rows []TransferRow) {
+	if len(rows) == 0 {
+		fmt.Fprintln(w, "No active transfers")
+		return
+	}
+	fmt.Fprintf(w, "   %-24s %-22s %-10s %-12s %s\n", "FILE", "PEER", "CHUNKS", "SPEED", "ETA")
+	for _, row := range rows {
+		arrow := "↓"
+		if row.Direction == DirectionUp {
+			arrow = "↑"
+		}
+		chunks := fmt.Sprintf("%d", row.Chunks)
+		if row.TotalChunks > 0 {
+			chunks = fmt.Sprintf("%d/%d", row.Chunks, row.TotalChunks)
+		}
+		eta := "-"
+		if row.ETA > 0 {
+			eta = row.ETA.Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s  %-24s %-22s %-10s %-12s %s\n", arrow, truncate(row.FileName, 24), row.Peer,
+			chunks, formatByteSize(int64(row.Rate))+"/s", eta)
+	}
+}
+
+// truncate shortens s to n runes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// ShowTransfers prints the active transfers and blacklisted peers,
+// redrawing every second until interrupted unless once is set.
+func ShowTransfers(once bool) error {
+	for {
+		rows, err := readTransfers(time.Now())
+		if err != nil {
+			return err
+		}
+		// Downloads in other processes save their blacklists to the session
+		LoadSession()
+		if once {
+			printTransfers(os.Stdout, rows)
+			printBlacklist(os.Stdout, activeBlacklist(), blacklistNow())
+			return nil
+		}
+		// Home the cursor and clear the screen before each redraw
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("Transfers at %s (Ctrl-C to quit)\n\n", time.Now().Format("15:04:05"))
+		printTransfers(os.Stdout, rows)
+		printBlacklist(os.Stdout, activeBlacklist(), blacklistNow())
+		time.Sleep(transferInterval)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTransferRegistry_Record checks chunks add up per direction, file and
+// peer, and that rates and ETAs follow from them.
+func TestTransferRegistry_Record(t *testing.T) {
+	r := &TransferRegistry{}
+	start := time.Now()
+	r.NameFile("h1", "movie.mkv", 10)
+
+	// Two peers each send two 1000-byte chunks over 2 seconds
+	for i := 0; i < 2; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		r.Record(DirectionDown, "h1", "10.0.0.1:9000", 1000, at)
+		r.Record(DirectionDown, "h1", "10.0.0.2:9000", 1000, at)
+	}
+	r.Record(DirectionUp, "h1", "10.0.0.3", 500, start)
+
+	rows := r.Snapshot(start.Add(2 * time.Second))
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3: %+v", len(rows), rows)
+	}
+	down := rows[0]
+	if down.Direction != DirectionDown || down.Peer != "10.0.0.1:9000" || down.FileName != "movie.mkv" {
+		t.Fatalf("first row = %+v", down)
+	}
+	if down.Chunks != 2 || down.Bytes != 2000 || down.TotalChunks != 10 {
+		t.Errorf("down row = %+v", down)
+	}
+	if down.Rate != 1000 {
+		t.Errorf("rate = %v, want 1000 B/s", down.Rate)
+	}
+	// 6 chunks of 1000 bytes left at 2000 B/s across both peers
+	if down.ETA != 3*time.Second || rows[1].ETA != down.ETA {
+		t.Errorf("ETAs = %v, %v, want 3s for both download rows", down.ETA, rows[1].ETA)
+	}
+	// The upload is estimated on its own: 9 chunks of 500 bytes at 250 B/s
+	if up := rows[2]; up.Direction != DirectionUp || up.ETA != 18*time.Second {
+		t.Errorf("upload row = %+v", up)
+	}
+}
+
+// TestTransferRegistry_Idle checks finished transfers drop out of the
+// snapshot and a later one with the same peer starts again from zero.
+func TestTransferRegistry_Idle(t *testing.T) {
+	r := &TransferRegistry{}
+	start := time.Now()
+	r.Record(DirectionDown, "h1", "p1", 1000, start)
+	r.Record(DirectionDown, "h1", "p1", 1000, start)
+
+	later := start.Add(transferIdle + time.Second)
+	if rows := r.Snapshot(later); len(rows) != 0 {
+		t.Fatalf("idle transfer still listed: %+v", rows)
+	}
+	r.Record(DirectionDown, "h1", "p1", 1000, later)
+	rows := r.Snapshot(later)
+	if len(rows) != 1 || rows[0].Chunks != 1 || rows[0].FileName != "h1" {
+		t.Fatalf("rows after restart = %+v", rows)
+	}
+}
+
+func TestReadTransfers_SkipsStaleSnapshots(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.MkdirAll(TransfersDir, 0755)
+	write := func(name string, rows []TransferRow, age time.Duration) {
+		data, _ := json.Marshal(rows)
+		path := filepath.Join(TransfersDir, name)
+		os.WriteFile(path, data, 0644)
+		mtime := time.Now().Add(-age)
+		os.Chtimes(path, mtime, mtime)
+	}
+	write("1.json", []TransferRow{{Direction: DirectionUp, FileName: "a", Peer: "p1", Chunks: 1}}, 0)
+	write("2.json", []TransferRow{{Direction: DirectionDown, FileName: "b", Peer: "p2", Chunks: 3, TotalChunks: 4}}, 0)
+	write("3.json", []TransferRow{{Direction: DirectionDown, FileName: "dead", Peer: "p3"}}, time.Minute)
+
+	rows, err := readTransfers(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].FileName != "b" || rows[1].FileName != "a" {
+		t.Fatalf("rows = %+v", rows)
+	}
+	if _, err := os.Stat(filepath.Join(TransfersDir, "3.json")); !os.IsNotExist(err) {
+		t.Error("stale snapshot not removed")
+	}
+
+	var out bytes.Buffer
+	printTransfers(&out, rows)
+	if s := out.String(); !strings.Contains(s, "↓  b") || !strings.Contains(s, "3/4") || !strings.Contains(s, "↑  a") {
+		t.Errorf("table:\n%s", s)
+	}
+}
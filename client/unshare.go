@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"time"
+)
+
+// UnsharedMarker marks a chunk directory the peer server no longer serves,
+// because the group the file was shared in was deleted. The chunks are
+// kept; sharing the file again removes the marker.
+const UnsharedMarker = ".unshared"
+
+// StopServing makes the peer server refuse requests for fileHash.
+func StopServing(fileHash string) error {
+	if !validFileHash(fileHash) {
+		return errors.New("invalid file hash")
+	}
+	chunkDir := filepath.Join(ChunksDir, fileHash)
+	if _, err := os.Stat(chunkDir); err != nil {
+		return fmt.Errorf("no local file with hash %s", fileHash)
+	}
+	return os.WriteFile(filepath.Join(chunkDir, UnsharedMarker), nil, 0644)
+}
+
+// resumeServing removes chunkDir's unshared marker, if any, once its file
+// is shared again.
+func resumeServing(chunkDir string) {
+	os.Remove(filepath.Join(chunkDir, UnsharedMarker))
+}
+
+// isUnshared reports whether StopServing was called for fileHash.
+func isUnshared(fileHash string) bool {
+	_, err := os.Stat(filepath.Join(ChunksDir, fileHash, UnsharedMarker))
+	return err == nil
+}
+
+// handleStopSharing stops serving req.FileHash. Only requests from this
+// machine are accepted, so other peers can't switch our files off.
+func handleStopSharing(conn net.Conn, req PeerRequest) {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !addr.IP.IsLoopback() {
+		common.Send(conn, PeerResponse{Status: "error"})
+		return
+	}
+	if err := StopServing(req.FileHash); err != nil {
+		common.Send(conn, PeerResponse{Status: "error"})
+		return
+	}
+	common.Send(conn, PeerResponse{Status: "ok"})
+}
+
+// stopSharingLocally asks our peer server to stop serving each of hashes
+// we hold. Without a running peer server the chunk directories are
+// marked directly. It returns the hashes that were stopped.
+func stopSharingLocally(hashes []string) []string {
+	var stopped []string
+	for _, hash := range hashes {
+		if _, err := os.Stat(filepath.Join(ChunksDir, hash)); err != nil {
+			continue
+		}
+		if askPeerToStop(hash) == nil || StopServing(hash) == nil {
+			stopped = append(stopped, hash)
+		}
+	}
+	return stopped
+}
+
+func askPeerToStop(fileHash string) error {
+	if State.ListenAddr == "" {
+		return errors.New("no peer server")
+	}
+	conn, err := net.DialTimeout("tcp", "127.0.0.1"+State.ListenAddr, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := common.Send(conn, PeerRequest{Cmd: "stop_sharing", FileHash: fileHash}); err != nil {
+		return err
+	}
+	var resp PeerResponse
+	if err := common.Recv(conn, &resp); err != nil {
+		return err
+	}
+	if resp.Status != "ok" {
+		return errors.New("peer server refused")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// peerRequest sends req to the peer at addr and returns its reply status.
+func peerRequest(t *testing.T, addr string, req PeerRequest) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := common.Send(conn, req); err != nil {
+		t.Fatal(err)
+	}
+	var resp PeerResponse
+	if err := common.Recv(conn, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Status
+}
+
+// TestStopSharing_PeerRefuses checks a file the local peer server was told
+// to stop sharing is refused to other peers, and served again once it is
+// shared again.
+func TestStopSharing_PeerRefuses(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*smallChunkSize+100)
+	peer := startTestPeer(t)
+	hash := meta.FileHash
+
+	if got := peerRequest(t, peer, PeerRequest{Cmd: "stop_sharing", FileHash: hash}); got != "ok" {
+		t.Fatalf("stop_sharing = %s", got)
+	}
+	for _, cmd := range []string{"handshake", "get_piece", "get_bitfield"} {
+		if got := peerRequest(t, peer, PeerRequest{Cmd: cmd, FileHash: hash}); got != "error" {
+			t.Errorf("%s of an unshared file = %s", cmd, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(ChunksDir, hash, "chunk_0.dat")); err != nil {
+		t.Errorf("chunks removed: %v", err)
+	}
+
+	if err := SaveChunks("orig.bin", meta); err != nil {
+		t.Fatal(err)
+	}
+	if got := peerRequest(t, peer, PeerRequest{Cmd: "get_piece", FileHash: hash}); got != "ok" {
+		t.Errorf("get_piece after sharing again = %s", got)
+	}
+}
+
+// TestStopSharingLocally checks hashes we hold are stopped, directly when no
+// peer server runs, and hashes we don't hold are skipped.
+func TestStopSharingLocally(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 100)
+	saved := State.ListenAddr
+	State.ListenAddr = ""
+	t.Cleanup(func() { State.ListenAddr = saved })
+
+	missing := strings.Repeat("ab", 32)
+	if got := stopSharingLocally([]string{meta.FileHash, missing}); len(got) != 1 || got[0] != meta.FileHash {
+		t.Errorf("stopped %v", got)
+	}
+	if !isUnshared(meta.FileHash) {
+		t.Error("file still shared")
+	}
+	if err := StopServing("../etc"); err == nil {
+		t.Error("StopServing accepted a path")
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	for answer, want := range map[string]bool{"y\n": true, "YES\n": true, " yes ": true, "n\n": false, "\n": false, "": false} {
+		if got := confirm(strings.NewReader(answer), "Delete?"); got != want {
+			t.Errorf("confirm(%q) = %v, want %v", answer, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// clientVersion is this build's version. Release builds set it with
+//
+//	go build -ldflags "-X main.clientVersion=v1.4.0"
+var clientVersion = "v0.0.0-dev"
+
+// UpdateInfo is the tracker's answer to client_version.
+type UpdateInfo struct {
+	Available bool
+	Latest    string
+	Changelog string
+	GroupID   string // where the new binary is shared
+	FileName  string
+	FileHash  string // the new binary's SHA-256, as the tracker's operator announced it
+	Message   string
+}
+
+// CheckUpdate asks the tracker whether a newer client than this one has
+// been released.
+func CheckUpdate() (*UpdateInfo, error) {
+	resp := SendToTracker(Message{Cmd: "client_version", Args: []string{clientVersion}})
+	data, ok := resp.Data.(map[string]interface{})
+	if resp.Status != "ok" || !ok {
+		return nil, fmt.Errorf("%v", resp.Data)
+	}
+	info := &UpdateInfo{}
+	info.Available, _ = data["update_available"].(bool)
+	info.Latest, _ = data["latest"].(string)
+	info.Changelog, _ = data["changelog"].(string)
+	info.GroupID, _ = data["group_id"].(string)
+	info.FileName, _ = data["file_name"].(string)
+	info.FileHash, _ = data["file_hash"].(string)
+	info.Message, _ = data["message"].(string)
+	return info, nil
+}
+
+// AutoUpdate downloads the release the tracker announces over the P2P
+// network, where it is shared like any other file, and swaps it in for
+// the running executable. Only a binary with the SHA-256 the tracker
+// announces is installed: whoever seeds the file decides what is
+// downloaded. It returns the version installed, or nil info if we are up
+// to date.
+func AutoUpdate() (*UpdateInfo, error) {
+	info, err := CheckUpdate()
+	if err != nil || !info.Available {
+		return nil, err
+	}
+	if info.GroupID == "" || info.FileName == "" {
+		return nil, errors.New("the tracker doesn't say where the new client is shared")
+	}
+	if info.FileHash == "" {
+		return nil, errors.New("the tracker doesn't give the new client's hash to check it against")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	// Downloaded next to the executable so the final rename can't cross filesystems
+	tmp := exe + ".new"
+	if err := downloadAndSeed(info.GroupID, info.FileName, tmp); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("downloading %s: %v", info.Latest, err)
+	}
+	if err := checkReleaseHash(tmp, info.FileHash); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := installBinary(tmp, exe); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return info, nil
+}
+
+// checkReleaseHash checks the binary downloaded to path has SHA-256 want.
+func checkReleaseHash(path, want string) error {
+	hash, err := CalculateFileHash(path)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(hash, want) {
+		return fmt.Errorf("downloaded client has SHA-256 %s, the release's is %s; not installing it", hash, want)
+	}
+	return nil
+}
+
+// installBinary makes path executable and moves it over exe. The running
+// process keeps its old image; the new one is used from the next start.
+func installBinary(path, exe string) error {
+	if err := os.Chmod(path, 0755); err != nil {
+		return err
+	}
+	return os.Rename(path, exe)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckUpdate(t *testing.T) {
+	tracker, _ := startRecordingTracker(t, map[string]Response{
+		"client_version": {"ok", map[string]interface{}{
+			"update_available": true, "latest": "v1.4.0", "group_id": "releases",
+			"file_name": "client_bin-v1.4.0", "message": "update available: v1.4.0",
+		}},
+	})
+	useTestNetwork(t, tracker, nil)
+
+	info, err := CheckUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Available || info.Latest != "v1.4.0" || info.GroupID != "releases" || info.FileName != "client_bin-v1.4.0" {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+// TestAutoUpdate_UpToDate checks nothing is downloaded when the tracker
+// has no newer release.
+func TestAutoUpdate_UpToDate(t *testing.T) {
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"client_version": {"ok", map[string]interface{}{"update_available": false, "message": "up to date"}},
+	})
+	useTestNetwork(t, tracker, nil)
+
+	if info, err := AutoUpdate(); info != nil || err != nil {
+		t.Fatalf("AutoUpdate = %+v, %v", info, err)
+	}
+	if c := cmds(); len(c) != 1 {
+		t.Errorf("tracker commands = %v, want only client_version", c)
+	}
+}
+
+// TestAutoUpdate_NoHash checks a release announced without its hash isn't
+// downloaded.
+func TestAutoUpdate_NoHash(t *testing.T) {
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"client_version": {"ok", map[string]interface{}{
+			"update_available": true, "latest": "v1.4.0", "group_id": "releases", "file_name": "client_bin-v1.4.0",
+		}},
+	})
+	useTestNetwork(t, tracker, nil)
+
+	if info, err := AutoUpdate(); info != nil || err == nil {
+		t.Fatalf("AutoUpdate = %+v, %v", info, err)
+	}
+	if c := cmds(); len(c) != 1 {
+		t.Errorf("tracker commands = %v, want only client_version", c)
+	}
+}
+
+func TestCheckReleaseHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client_bin.new")
+	os.WriteFile(path, []byte("new"), 0600)
+	sum := sha256.Sum256([]byte("new"))
+	want := hex.EncodeToString(sum[:])
+
+	if err := checkReleaseHash(path, strings.ToUpper(want)); err != nil {
+		t.Errorf("matching hash: %v", err)
+	}
+	if err := checkReleaseHash(path, strings.Repeat("0", 64)); err == nil {
+		t.Error("a binary with another hash passed")
+	}
+}
+
+func TestInstallBinary(t *testing.T) {
+	dir := t.TempDir()
+	exe, tmp := filepath.Join(dir, "client_bin"), filepath.Join(dir, "client_bin.new")
+	os.WriteFile(exe, []byte("old"), 0755)
+	os.WriteFile(tmp, []byte("new"), 0600)
+
+	if err := installBinary(tmp, exe); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(exe)
+	st, _ := os.Stat(exe)
+	if string(data) != "new" || st.Mode().Perm()&0100 == 0 {
+		t.Errorf("installed %q with mode %v", data, st.Mode())
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Error("download left behind")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// registerUpload announces a locally chunked file to the tracker so other
+// group members can discover and download it.
+func registerUpload(metadata *ChunkMetadata, groupID string) Response {
+	return registerReservedUpload(metadata, groupID, "")
+}
+
+// registerReservedUpload is registerUpload into a slot held by reserve_slot,
+// whose token is reservation; "" uploads without one.
+func registerReservedUpload(metadata *ChunkMetadata, groupID, reservation string) Response {
+	chunksJSON, err := json.Marshal(metadata.Chunks)
+	if err != nil {
+		return Response{"error", fmt.Sprintf("marshal chunks: %v", err)}
+	}
+
+	args := []string{
+		metadata.FileName,
+		groupID,
+		State.UserID,
+		fmt.Sprintf("%d", metadata.FileSize),
+		metadata.FileHash,
+		string(chunksJSON),
+		fmt.Sprintf("%d", metadata.ChunkSize),
+	}
+	if reservation != "" {
+		args = append(args, reservation)
+	}
+
+	defer trackerCache.InvalidateGroup(groupID)
+	resp := SendToTracker(Message{Cmd: "upload_file", Args: args})
+	for _, m := range mirroredGroups(resp) {
+		trackerCache.InvalidateGroup(m)
+	}
+	return resp
+}
+
+// UploadDir chunks every regular file directly inside dir and registers
+// them all in groupID with one batch_upload_files request, which the
+// tracker applies all or nothing. Trackers without the command get one
+// upload_file per file instead.
+func UploadDir(dir, groupID string) ([]*ChunkMetadata, Response, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, Response{}, err
+	}
+	var metas []*ChunkMetadata
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		meta, err := ChunkFile(path, uploadChunkOptions())
+		if err != nil {
+			return nil, Response{}, fmt.Errorf("%s: %v", e.Name(), err)
+		}
+		if err := SaveChunks(path, meta); err != nil {
+			return nil, Response{}, fmt.Errorf("%s: %v", e.Name(), err)
+		}
+		metas = append(metas, meta)
+	}
+	if len(metas) == 0 {
+		return nil, Response{}, fmt.Errorf("no files in %s", dir)
+	}
+	return metas, registerBatchUpload(metas, groupID), nil
+}
+
+// batchDescriptor is one file of a batch_upload_files request.
+type batchDescriptor struct {
+	*ChunkMetadata
+	GroupID string `json:"group_id"`
+}
+
+// registerBatchUpload announces metas to the tracker in one message.
+func registerBatchUpload(metas []*ChunkMetadata, groupID string) Response {
+	descs := make([]batchDescriptor, len(metas))
+	for i, m := range metas {
+		descs[i] = batchDescriptor{m, groupID}
+	}
+	filesJSON, err := json.Marshal(descs)
+	if err != nil {
+		return Response{"error", fmt.Sprintf("marshal files: %v", err)}
+	}
+
+	defer trackerCache.InvalidateGroup(groupID)
+	resp := SendToTracker(Message{Cmd: "batch_upload_files", Args: []string{State.UserID, string(filesJSON)}})
+	if resp.Status == "error" && resp.Data == "unkown command" {
+		for _, m := range metas {
+			if resp = registerUpload(m, groupID); resp.Status != "ok" {
+				return resp
+			}
+		}
+		return Response{"ok", fmt.Sprintf("%d files uploaded one at a time", len(metas))}
+	}
+
+	data, _ := resp.Data.(map[string]interface{})
+	list, _ := data["files"].([]interface{})
+	for _, f := range list {
+		entry, _ := f.(map[string]interface{})
+		for _, m := range mirroredGroups(Response{"ok", entry}) {
+			trackerCache.InvalidateGroup(m)
+		}
+	}
+	return resp
+}
+
+// mirroredGroups lists the mirror groups an upload_file reply says the
+// file was also added to.
+func mirroredGroups(resp Response) []string {
+	data, _ := resp.Data.(map[string]interface{})
+	list, _ := data["mirrored_to"].([]interface{})
+	var groups []string
+	for _, g := range list {
+		if s, ok := g.(string); ok {
+			groups = append(groups, s)
+		}
+	}
+	return groups
+}
+
+// dedupedChunks returns the hashes of the chunks an upload_file reply says
+// the tracker knew from other files already. Older trackers list none.
+func dedupedChunks(resp Response) []string {
+	data, _ := resp.Data.(map[string]interface{})
+	list, _ := data["deduplicated_chunks"].([]interface{})
+	var hashes []string
+	for _, h := range list {
+		if s, ok := h.(string); ok {
+			hashes = append(hashes, s)
+		}
+	}
+	return hashes
+}
+
+// printUploadResult prints the tracker's reply to an upload_file request.
+func printUploadResult(resp Response, metadata *ChunkMetadata) {
+	if resp.Status != "ok" {
+		fmt.Println(resp)
+		return
+	}
+	data, ok := resp.Data.(map[string]interface{})
+	if !ok {
+		fmt.Println(resp)
+		return
+	}
+	fmt.Printf("✓ File chunked and uploaded successfully\n")
+	fmt.Printf("  File: %s\n", data["file_name"])
+	fmt.Printf("  Group: %s\n", data["group_id"])
+	fmt.Printf("  Size: %v bytes\n", data["file_size"])
+	if fileHash, ok := data["file_hash"].(string); ok {
+		fmt.Printf("  Hash: %s...\n", fileHash[:16])
+	}
+	if totalChunks, ok := data["total_chunks"].(float64); ok {
+		fmt.Printf("  Chunks: %.0f\n", totalChunks)
+	}
+	fmt.Printf("  Chunks stored in: .chunks/%s/\n", metadata.FileHash)
+	if deduped := dedupedChunks(resp); len(deduped) > 0 {
+		fmt.Printf("  Deduplicated: %d of %d chunks are held by other files on the network already\n", len(deduped), metadata.TotalChunks)
+	}
+	if mirrors := mirroredGroups(resp); len(mirrors) > 0 {
+		fmt.Printf("  Mirrored to: %s\n", strings.Join(mirrors, ", "))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeUploadDir creates a directory of n small files and a subdirectory,
+// which upload_all skips.
+func writeUploadDir(t *testing.T, n int) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i := 0; i < n; i++ {
+		os.WriteFile(filepath.Join(dir, string(rune('a'+i))+".txt"), []byte(strings.Repeat("x", 100+i)), 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	return dir
+}
+
+// TestUploadDir_OneRequest checks every file is chunked and registered
+// with a single batch_upload_files request.
+func TestUploadDir_OneRequest(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir := writeUploadDir(t, 3)
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"batch_upload_files": {"ok", map[string]interface{}{"message": "3 files uploaded"}},
+	})
+	useTestNetwork(t, tracker, nil)
+
+	metas, resp, err := UploadDir(dir, "g1")
+	if err != nil || resp.Status != "ok" || len(metas) != 3 {
+		t.Fatalf("UploadDir = %d files, %+v, %v", len(metas), resp, err)
+	}
+	if c := cmds(); len(c) != 1 || c[0] != "batch_upload_files" {
+		t.Errorf("tracker commands = %v", c)
+	}
+	for _, m := range metas {
+		if _, err := os.Stat(filepath.Join(ChunksDir, m.FileHash, "chunk_0.dat")); err != nil {
+			t.Errorf("%s not chunked: %v", m.FileName, err)
+		}
+	}
+}
+
+// TestUploadDir_OlderTracker falls back to one upload_file per file when
+// the tracker doesn't know batch_upload_files.
+func TestUploadDir_OlderTracker(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir := writeUploadDir(t, 2)
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"batch_upload_files": {"error", "unkown command"},
+		"upload_file":        {"ok", map[string]interface{}{"message": "file uploaded successfully"}},
+	})
+	useTestNetwork(t, tracker, nil)
+
+	if _, resp, err := UploadDir(dir, "g1"); err != nil || resp.Status != "ok" {
+		t.Fatalf("UploadDir = %+v, %v", resp, err)
+	}
+	if c := strings.Join(cmds(), " "); c != "batch_upload_files upload_file upload_file" {
+		t.Errorf("tracker commands = %s", c)
+	}
+}
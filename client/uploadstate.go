@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// UploadStateFile records, in a file's chunk directory, how far upload_file
+// got with it, so an interrupted upload skips the steps already done.
+const UploadStateFile = "upload_state.json"
+
+// ChunksFile holds the metadata ChunkFile computed until the chunks are
+// saved, so an upload interrupted in between doesn't hash every chunk again.
+const ChunksFile = "chunks.json"
+
+// UploadState is the content of UploadStateFile.
+type UploadState struct {
+	ChunksSaved       bool   `json:"chunksSaved"`
+	TrackerRegistered bool   `json:"trackerRegistered"`
+	GroupID           string `json:"groupID,omitempty"` // registered in
+}
+
+// loadUploadState reads chunkDir's upload state, the zero state if there
+// is none or it can't be read.
+func loadUploadState(chunkDir string) UploadState {
+	var st UploadState
+	if data, err := os.ReadFile(filepath.Join(chunkDir, UploadStateFile)); err == nil {
+		json.Unmarshal(data, &st)
+	}
+	return st
+}
+
+// saveUploadState writes st to chunkDir, via a temp file so a crash never
+// leaves it half-written.
+func saveUploadState(chunkDir string, st UploadState) error {
+	return writeJSONFile(filepath.Join(chunkDir, UploadStateFile), st)
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// chunkForUpload chunks filePath and stores its chunks, returning the full
+// metadata and the file's upload state. Steps an earlier attempt finished
+// are skipped: chunking once chunks.json is written, and storing once the
+// state says the chunks are saved. Files over largeFileLimit go through
+// ChunkAndStore, which does both at once, and their entries are only read
+// back because the tracker needs every chunk hash.
+func chunkForUpload(filePath string) (*ChunkMetadata, UploadState, error) {
+	opts := uploadChunkOptions()
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, UploadState{}, err
+	}
+	// The chunk directory, and so any earlier attempt, is found by the file hash
+	opts.FileHash, err = CalculateFileHash(filePath, opts)
+	if err != nil {
+		return nil, UploadState{}, err
+	}
+	chunkDir := filepath.Join(ChunksDir, opts.FileHash)
+	st := loadUploadState(chunkDir)
+	if st.ChunksSaved {
+		if metadata, err := loadChunkMetadata(opts.FileHash); err == nil && chunksStored(chunkDir, metadata.TotalChunks) {
+			fmt.Println("Chunks already saved by an earlier upload; skipping chunking")
+			return metadata, st, nil
+		}
+		st = UploadState{} // the chunks went since; start over
+	}
+
+	var metadata *ChunkMetadata
+	if info.Size() > largeFileLimit {
+		header, err := ChunkAndStore(filePath, opts)
+		if err != nil {
+			return nil, st, err
+		}
+		if metadata, err = loadChunkMetadata(header.FileHash); err != nil {
+			return nil, st, err
+		}
+	} else {
+		if metadata = loadChunksFile(chunkDir, opts.FileHash); metadata != nil {
+			fmt.Println("Chunk hashes found from an earlier upload; skipping chunking")
+		} else {
+			if metadata, err = ChunkFile(filePath, opts); err != nil {
+				return nil, st, err
+			}
+			if err := os.MkdirAll(chunkDir, 0755); err != nil {
+				return nil, st, err
+			}
+			if err := writeJSONFile(filepath.Join(chunkDir, ChunksFile), metadata); err != nil {
+				return nil, st, err
+			}
+		}
+		if err := SaveChunks(filePath, metadata); err != nil {
+			return nil, st, err
+		}
+		os.Remove(filepath.Join(chunkDir, ChunksFile)) // metadata.json has it all now
+	}
+
+	st.ChunksSaved = true
+	return metadata, st, saveUploadState(chunkDir, st)
+}
+
+// chunksStored reports whether chunks 0 to total-1 are all in chunkDir.
+func chunksStored(chunkDir string, total int) bool {
+	for i := 0; i < total; i++ {
+		if _, err := os.Stat(filepath.Join(chunkDir, fmt.Sprintf("chunk_%d.dat", i))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// loadChunksFile returns the metadata in chunkDir's chunks.json, nil if
+// there is none or it isn't for fileHash.
+func loadChunksFile(chunkDir, fileHash string) *ChunkMetadata {
+	data, err := os.ReadFile(filepath.Join(chunkDir, ChunksFile))
+	if err != nil {
+		return nil
+	}
+	var metadata ChunkMetadata
+	if json.Unmarshal(data, &metadata) != nil || metadata.FileHash != fileHash || len(metadata.Chunks) != metadata.TotalChunks {
+		return nil
+	}
+	return &metadata
+}
+
+// resumableUpload chunks, stores and registers filePath in groupID as
+// upload_file does, skipping what an earlier attempt finished. registered
+// reports that the file was registered in groupID already and the tracker
+// still has it, in which case it isn't registered again and resp is empty.
+func resumableUpload(filePath, groupID, reservation string) (metadata *ChunkMetadata, resp Response, registered bool, err error) {
+	metadata, st, err := chunkForUpload(filePath)
+	if err != nil {
+		return nil, Response{}, false, err
+	}
+	if st.TrackerRegistered && st.GroupID == groupID && trackerHasFile(groupID, metadata) {
+		return metadata, Response{}, true, nil
+	}
+
+	resp = registerReservedUpload(metadata, groupID, reservation)
+	if resp.Status == "ok" {
+		st.TrackerRegistered, st.GroupID = true, groupID
+		if err := saveUploadState(filepath.Join(ChunksDir, metadata.FileHash), st); err != nil {
+			fmt.Printf("Warning: Failed to record the upload: %v\n", err)
+		}
+	}
+	return metadata, resp, false, nil
+}
+
+// trackerHasFile reports whether the tracker lists metadata's file in
+// groupID, with the same content. It may have been unshared since.
+func trackerHasFile(groupID string, metadata *ChunkMetadata) bool {
+	resp := SendToTracker(Message{Cmd: "get_file_info", Args: []string{groupID, metadata.FileName, State.UserID}})
+	info, ok := resp.Data.(map[string]interface{})
+	return resp.Status == "ok" && ok && info["file_hash"] == metadata.FileHash
+}
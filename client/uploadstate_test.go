@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeUploadFile writes a 3-chunk orig.bin and returns its hash.
+func writeUploadFile(t *testing.T) string {
+	t.Helper()
+	if err := os.WriteFile("orig.bin", []byte(strings.Repeat("upload ", 3*smallChunkSize/7+10)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := CalculateFileHash("orig.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// uploadTracker starts a tracker accepting upload_file, and listing the
+// file under hash in get_file_info, and returns the commands it got.
+func uploadTracker(t *testing.T, hash string) func() []string {
+	t.Helper()
+	tracker, cmds := startRecordingTracker(t, map[string]Response{
+		"upload_file":   {"ok", map[string]interface{}{"message": "file uploaded successfully"}},
+		"get_file_info": {"ok", map[string]interface{}{"file_hash": hash}},
+	})
+	useTestNetwork(t, tracker, nil)
+	return cmds
+}
+
+// markMetadata rewrites the file name in the JSON metadata at path, so a
+// test can tell whether it was read or the file chunked again.
+func markMetadata(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m ChunkMetadata
+	json.Unmarshal(data, &m)
+	m.FileName = "marked.bin"
+	if err := writeJSONFile(path, m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResumableUpload_Fresh(t *testing.T) {
+	t.Chdir(t.TempDir())
+	hash := writeUploadFile(t)
+	cmds := uploadTracker(t, hash)
+
+	meta, resp, registered, err := resumableUpload("orig.bin", "g1", "")
+	if err != nil || registered || resp.Status != "ok" || meta.FileHash != hash {
+		t.Fatalf("resumableUpload = %v, %v, %v", resp, registered, err)
+	}
+	chunkDir := filepath.Join(ChunksDir, hash)
+	if st := loadUploadState(chunkDir); !st.ChunksSaved || !st.TrackerRegistered || st.GroupID != "g1" {
+		t.Errorf("upload state = %+v", st)
+	}
+	if _, err := os.Stat(filepath.Join(chunkDir, ChunksFile)); !os.IsNotExist(err) {
+		t.Error("chunks.json left behind")
+	}
+	if c := strings.Join(cmds(), " "); c != "upload_file" {
+		t.Errorf("tracker commands = %s", c)
+	}
+}
+
+// TestResumableUpload_AfterChunking resumes an upload that died after
+// writing chunks.json: the chunk list is reused and the chunks saved.
+func TestResumableUpload_AfterChunking(t *testing.T) {
+	t.Chdir(t.TempDir())
+	hash := writeUploadFile(t)
+	cmds := uploadTracker(t, hash)
+	meta, err := ChunkFile("orig.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunkDir := filepath.Join(ChunksDir, hash)
+	os.MkdirAll(chunkDir, 0755)
+	writeJSONFile(filepath.Join(chunkDir, ChunksFile), meta)
+	markMetadata(t, filepath.Join(chunkDir, ChunksFile))
+
+	got, resp, _, err := resumableUpload("orig.bin", "g1", "")
+	if err != nil || resp.Status != "ok" {
+		t.Fatalf("resumableUpload = %v, %v", resp, err)
+	}
+	if got.FileName != "marked.bin" {
+		t.Error("file chunked again despite chunks.json")
+	}
+	if !chunksStored(chunkDir, meta.TotalChunks) {
+		t.Error("chunks not saved")
+	}
+	if c := strings.Join(cmds(), " "); c != "upload_file" {
+		t.Errorf("tracker commands = %s", c)
+	}
+}
+
+// TestResumableUpload_AfterSaving resumes an upload that died before the
+// tracker heard of it: only registration is left.
+func TestResumableUpload_AfterSaving(t *testing.T) {
+	t.Chdir(t.TempDir())
+	hash := writeUploadFile(t)
+	cmds := uploadTracker(t, hash)
+	if _, st, err := chunkForUpload("orig.bin"); err != nil || !st.ChunksSaved {
+		t.Fatalf("chunkForUpload = %+v, %v", st, err)
+	}
+	markMetadata(t, filepath.Join(ChunksDir, hash, "metadata.json"))
+
+	got, resp, registered, err := resumableUpload("orig.bin", "g1", "")
+	if err != nil || registered || resp.Status != "ok" {
+		t.Fatalf("resumableUpload = %v, %v, %v", resp, registered, err)
+	}
+	if got.FileName != "marked.bin" {
+		t.Error("chunks saved again")
+	}
+	if c := strings.Join(cmds(), " "); c != "upload_file" {
+		t.Errorf("tracker commands = %s", c)
+	}
+
+	// A chunk gone since means chunking again
+	os.Remove(filepath.Join(ChunksDir, hash, "chunk_1.dat"))
+	got, _, _, err = resumableUpload("orig.bin", "g2", "")
+	if err != nil || got.FileName != "orig.bin" || !chunksStored(filepath.Join(ChunksDir, hash), got.TotalChunks) {
+		t.Errorf("after losing a chunk: %+v, %v", got, err)
+	}
+}
+
+// TestResumableUpload_Registered checks a file registered in the group
+// isn't registered again while the tracker has it, and is once it hasn't.
+func TestResumableUpload_Registered(t *testing.T) {
+	t.Chdir(t.TempDir())
+	hash := writeUploadFile(t)
+	cmds := uploadTracker(t, hash)
+	if _, _, _, err := resumableUpload("orig.bin", "g1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, registered, err := resumableUpload("orig.bin", "g1", ""); err != nil || !registered {
+		t.Errorf("second upload to g1: registered %v, %v", registered, err)
+	}
+	if _, _, registered, _ := resumableUpload("orig.bin", "g2", ""); registered {
+		t.Error("upload to another group skipped")
+	}
+	if c := strings.Join(cmds(), " "); c != "upload_file get_file_info upload_file" {
+		t.Errorf("tracker commands = %s", c)
+	}
+
+	// Unshared since: the tracker lists another file under the name
+	uploadTracker(t, "other")
+	if _, resp, registered, _ := resumableUpload("orig.bin", "g2", ""); registered || resp.Status != "ok" {
+		t.Errorf("upload of an unshared file: %+v, registered %v", resp, registered)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"sync"
+)
+
+// errPeerCorrupt is returned by requestChunk when the peer found its own
+// copy of the chunk corrupt; the downloader moves on to another seeder.
+var errPeerCorrupt = errors.New("peer reported chunk corrupt")
+
+// verifyOnServe reports whether P2P_VERIFY_ON_SERVE is set, in which case
+// every chunk is re-hashed before it is served.
+func verifyOnServe() bool {
+	return os.Getenv("P2P_VERIFY_ON_SERVE") != ""
+}
+
+// ServeHashes caches the expected chunk hashes of local files, keyed by file
+// hash, so serving doesn't re-read metadata.json for every chunk. Each
+// entry is checked against the metadata file it was read from, which
+// compact_chunks, run in another process, replaces with a new layout.
+type ServeHashes struct {
+	mu     sync.Mutex
+	hashes map[string]servedHashes
+}
+
+// servedHashes are the chunk hashes of one file and the metadata file they
+// were read from.
+type servedHashes struct {
+	chunks []ChunkInfo
+	from   os.FileInfo
+}
+
+func newServeHashes() *ServeHashes {
+	return &ServeHashes{hashes: make(map[string]servedHashes)}
+}
+
+var serveHashes = newServeHashes()
+
+// LoadAll reads the metadata of every file in the chunk store.
+func (s *ServeHashes) LoadAll() error {
+	entries, err := os.ReadDir(ChunksDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != GlobalChunkDir {
+			s.lookup(e.Name())
+		}
+	}
+	return nil
+}
+
+// lookup returns the chunk hashes for fileHash, loading its metadata the
+// first time, so files downloaded after startup are covered too, and again
+// whenever the metadata file has been replaced since.
+func (s *ServeHashes) lookup(fileHash string) ([]ChunkInfo, bool) {
+	from, err := metadataFileInfo(fileHash)
+	if err != nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.hashes[fileHash]; ok && os.SameFile(e.from, from) &&
+		e.from.ModTime().Equal(from.ModTime()) && e.from.Size() == from.Size() {
+		return e.chunks, true
+	}
+	metadata, err := loadChunkMetadata(fileHash)
+	if err != nil {
+		return nil, false
+	}
+	h := make([]ChunkInfo, metadata.TotalChunks)
+	for _, c := range metadata.Chunks {
+		if c.Index >= 0 && c.Index < len(h) {
+			h[c.Index] = c
+		}
+	}
+	s.hashes[fileHash] = servedHashes{h, from}
+	return h, true
+}
+
+// metadataFileInfo stats the file loadChunkMetadata reads fileHash's
+// metadata from.
+func metadataFileInfo(fileHash string) (os.FileInfo, error) {
+	dir := filepath.Join(ChunksDir, fileHash)
+	info, err := os.Stat(filepath.Join(dir, "metadata.json"))
+	if os.IsNotExist(err) {
+		return os.Stat(filepath.Join(dir, MetadataLinesFile))
+	}
+	return info, err
+}
+
+// Verify checks data against the expected hash of chunk idx. Chunks without
+// known metadata can't be checked and are passed through.
+func (s *ServeHashes) Verify(fileHash string, idx int, data []byte) error {
+	h, ok := s.lookup(fileHash)
+	if !ok || idx < 0 || idx >= len(h) || h[idx].Hash == "" {
+		return nil
+	}
+	if !validateChunkHash(data, h[idx].HashAlgorithm, h[idx].Hash) {
+		return fmt.Errorf("chunk %d of %s failed its hash check", idx, fileHash)
+	}
+	return nil
+}
+
+// Algorithm returns the hash algorithm of chunk idx, "" (SHA256) if its
+// metadata names none or can't be read.
+func (s *ServeHashes) Algorithm(fileHash string, idx int) string {
+	h, ok := s.lookup(fileHash)
+	if !ok || idx < 0 || idx >= len(h) {
+		return ""
+	}
+	return h[idx].HashAlgorithm
+}
+
+// Proof returns the Merkle proof of chunk idx, nil if its metadata can't
+// be read or is incomplete.
+func (s *ServeHashes) Proof(fileHash string, idx int) []string {
+	h, ok := s.lookup(fileHash)
+	if !ok {
+		return nil
+	}
+	return common.MerkleProof(chunkLeaves(h, len(h)), idx)
+}
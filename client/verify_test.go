@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"testing"
+)
+
+// corruptChunk overwrites chunk i on disk with bytes that no longer match its hash.
+func corruptChunk(t *testing.T, meta *ChunkMetadata, i int) {
+	t.Helper()
+	if err := os.WriteFile(chunkPath(meta, i), bytes.Repeat([]byte{0xff}, int(meta.Chunks[i].Size)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useVerifyOnServe turns on P2P_VERIFY_ON_SERVE with an empty hash cache.
+func useVerifyOnServe(t *testing.T) {
+	t.Setenv("P2P_VERIFY_ON_SERVE", "1")
+	saved := serveHashes
+	serveHashes = newServeHashes()
+	t.Cleanup(func() { serveHashes = saved })
+}
+
+func getPiece(t *testing.T, peer, fileHash string, i int) PeerResponse {
+	t.Helper()
+	conn, err := net.Dial("tcp", peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := common.Send(conn, PeerRequest{Cmd: "get_piece", FileHash: fileHash, PieceIdx: i}); err != nil {
+		t.Fatal(err)
+	}
+	var resp PeerResponse
+	if err := common.Recv(conn, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// TestGetPiece_CorruptWhenVerifying corrupts a chunk on disk and checks the
+// peer answers "corrupt" for it while still serving the intact one.
+func TestGetPiece_CorruptWhenVerifying(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*ChunkSize)
+	useVerifyOnServe(t)
+	if err := serveHashes.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	corruptChunk(t, meta, 1)
+	peer := startTestPeer(t)
+
+	if resp := getPiece(t, peer, meta.FileHash, 1); resp.Status != "corrupt" || len(resp.Data) != 0 {
+		t.Fatalf("corrupt chunk: status %q with %d bytes", resp.Status, len(resp.Data))
+	}
+	if resp := getPiece(t, peer, meta.FileHash, 0); resp.Status != "ok" {
+		t.Fatalf("intact chunk: status %q", resp.Status)
+	}
+	if _, _, err := requestChunk(peer, meta.FileHash, 1); !errors.Is(err, errPeerCorrupt) {
+		t.Fatalf("requestChunk: %v, want errPeerCorrupt", err)
+	}
+}
+
+func TestGetPiece_NoVerifyByDefault(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*ChunkSize)
+	corruptChunk(t, meta, 1)
+	peer := startTestPeer(t)
+
+	if resp := getPiece(t, peer, meta.FileHash, 1); resp.Status != "ok" {
+		t.Fatalf("status %q, want the unverified chunk served", resp.Status)
+	}
+}
+
+// TestDownloadChunk_RetriesAfterCorrupt checks a chunk a seeder reports
+// corrupt is fetched from the next seeder instead.
+func TestDownloadChunk_RetriesAfterCorrupt(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 2*ChunkSize)
+	good := startMemoryPeer(t, meta.FileHash, memoryChunks(t, meta))
+	chunkTestFile(t, 2*ChunkSize) // memoryChunks emptied the store
+	useVerifyOnServe(t)
+	corruptChunk(t, meta, 1)
+	bad := startTestPeer(t)
+
+	info := streamTestInfo(meta, bad, good)
+	dest := filepath.Join(t.TempDir(), "chunk_1.dat")
+	from, err := downloadChunk([]string{bad, good}, info, 1, dest, "")
+	if err != nil || from != good {
+		t.Fatalf("downloadChunk: from=%q err=%v", from, err)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validateChunkHash(data, "", meta.Chunks[1].Hash) {
+		t.Fatal("saved chunk doesn't match its hash")
+	}
+
+	// With no good seeder left the chunk fails
+	if _, err := downloadChunk([]string{bad}, info, 1, filepath.Join(t.TempDir(), "c.dat"), ""); err == nil {
+		t.Fatal("expected an error when every seeder's copy is corrupt")
+	}
+}
+
+// TestGetPiece_AfterCompaction serves a chunk, re-chunks the file with
+// compact_chunks and checks the next chunk served is verified and proved
+// against the new layout, not the cached old one.
+func TestGetPiece_AfterCompaction(t *testing.T) {
+	t.Chdir(t.TempDir())
+	meta, _ := chunkTestFile(t, 4*smallChunkSize+100)
+	useVerifyOnServe(t)
+	peer := startTestPeer(t)
+	tracker, _ := startRecordingTracker(t, map[string]Response{
+		"update_file_chunks": {"ok", map[string]interface{}{"updated": []string{"g1:orig.bin"}}},
+	})
+	useTestNetwork(t, tracker, nil)
+	useTestCache(t, 0)
+
+	getProved := func(m *ChunkMetadata) {
+		t.Helper()
+		conn, err := net.Dial("tcp", peer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var resp PeerResponse
+		if err := common.Send(conn, PeerRequest{Cmd: "get_piece", FileHash: m.FileHash, PieceIdx: 0, WantProof: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := common.Recv(conn, &resp); err != nil {
+			t.Fatal(err)
+		}
+		root := common.MerkleRoot(chunkLeaves(m.Chunks, m.TotalChunks))
+		if resp.Status != "ok" || !MerkleVerify(resp.Data, 0, resp.Proof, root) {
+			t.Errorf("chunk 0 of %d: status %q, proof doesn't verify", m.TotalChunks, resp.Status)
+		}
+	}
+	getProved(meta)
+
+	compacted, resp, err := CompactChunks(meta.FileHash, 3*smallChunkSize, []string{"g1"})
+	if err != nil || resp.Status != "ok" {
+		t.Fatalf("CompactChunks: %v, %+v", err, resp)
+	}
+	getProved(compacted)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"p2p/common"
+	"reflect"
+	"testing"
+)
+
+// sameOverMsgPack checks v decodes from MsgPack to just what it decodes
+// to from JSON.
+func sameOverMsgPack(t *testing.T, v any) {
+	t.Helper()
+	var decoded [2]any
+	for i, f := range []common.WireFormat{common.WireJSON, common.WireMsgPack} {
+		data, err := common.MarshalFrame(f, v)
+		if err != nil {
+			t.Fatalf("%T in %s: %v", v, f, err)
+		}
+		out := reflect.New(reflect.TypeOf(v))
+		if err := common.UnmarshalFrame(data, out.Interface()); err != nil {
+			t.Fatalf("%T in %s: %v", v, f, err)
+		}
+		decoded[i] = out.Elem().Interface()
+	}
+	if !reflect.DeepEqual(decoded[0], decoded[1]) {
+		t.Errorf("%T: MsgPack decoded %+v, JSON %+v", v, decoded[1], decoded[0])
+	}
+}
+
+// testChunkMetadata lists n chunks of a file, as upload_file sends them.
+func testChunkMetadata(n int) *ChunkMetadata {
+	m := &ChunkMetadata{FileName: "big.iso", FileHash: fmt.Sprintf("%064x", n), ChunkSize: smallChunkSize, TotalChunks: n, MerkleRoot: fmt.Sprintf("%064x", 1)}
+	for i := 0; i < n; i++ {
+		m.Chunks = append(m.Chunks, ChunkInfo{Index: i, Hash: fmt.Sprintf("%064x", i), Size: smallChunkSize})
+		m.FileSize += smallChunkSize
+	}
+	return m
+}
+
+// TestMsgPack_ClientMessages sends every message type the client puts on
+// the wire through MsgPack.
+func TestMsgPack_ClientMessages(t *testing.T) {
+	for _, v := range []any{
+		Message{Cmd: "upload_file", Args: []string{"a.txt", "g1", "alice", "10"}, Mux: true},
+		Message{Cmd: "list_files", Stream: true},
+		Message{Cmd: common.HelloCmd, Args: []string{"v3", "msgpack"}},
+		Response{"ok", map[string]interface{}{
+			"file_name": "a.txt", "file_size": 10, "peers": []string{"127.0.0.1:7000"},
+			"chunks": []ChunkInfo{{Index: 0, Hash: "h", Size: 10}}, "owners": map[string]bool{"alice": true},
+		}},
+		Response{"error", "file not found"},
+		PeerRequest{Cmd: "get_piece", FileHash: "h", PieceIdx: 3, AcceptEncoding: []string{EncodingZstd}, WantProof: true},
+		PeerRequest{Cmd: "push_chunk", FileHash: "h", Data: []byte("chunk"), Metadata: testChunkMetadata(2)},
+		PeerResponse{Status: "ok", Data: []byte{0, 255}, Bitfield: []int{0, 2}, ContentEncoding: EncodingGzip, Proof: []string{"p"}},
+		PeerResponse{Status: "unauthorized"},
+		*testChunkMetadata(3),
+	} {
+		sameOverMsgPack(t, v)
+	}
+}
+
+func benchmarkEncode(b *testing.B, f common.WireFormat) {
+	m := testChunkMetadata(2000)
+	var size int
+	for i := 0; i < b.N; i++ {
+		data, err := common.MarshalFrame(f, m)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = len(data)
+	}
+	b.ReportMetric(float64(size), "wire-bytes")
+}
+
+func benchmarkDecode(b *testing.B, f common.WireFormat) {
+	data, err := common.MarshalFrame(f, testChunkMetadata(2000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var m ChunkMetadata
+		if err := common.UnmarshalFrame(data, &m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The metadata of a 2000-chunk file, in each format
+func BenchmarkEncodeChunkMetadata_JSON(b *testing.B)    { benchmarkEncode(b, common.WireJSON) }
+func BenchmarkEncodeChunkMetadata_MsgPack(b *testing.B) { benchmarkEncode(b, common.WireMsgPack) }
+func BenchmarkDecodeChunkMetadata_JSON(b *testing.B)    { benchmarkDecode(b, common.WireJSON) }
+func BenchmarkDecodeChunkMetadata_MsgPack(b *testing.B) { benchmarkDecode(b, common.WireMsgPack) }
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Clients open each tracker connection with hello [vN], naming the newest
+// protocol version they speak. The tracker answers with the newest it
+// speaks and both sides use the older of the two, carrying on with the
+// request on the same connection. Clients and trackers from before hello
+// speak ProtocolV1: the request is the first message.
+//
+// From ProtocolFormats on, hello may name wire formats after the version,
+// as in hello [v3, msgpack]. Once hello is answered both sides send in the
+// first of them they speak, per NegotiateWireFormat.
+const (
+	HelloCmd        = "hello"
+	ProtocolV1      = 1
+	ProtocolFormats = 3
+	ProtocolVersion = 3 // newest version this build speaks
+)
+
+// FormatProtocolVersion returns v as hello carries it, e.g. "v2".
+func FormatProtocolVersion(v int) string {
+	return "v" + strconv.Itoa(v)
+}
+
+// ParseProtocolVersion parses a version formatted by FormatProtocolVersion.
+func ParseProtocolVersion(s string) (int, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "v"), 10, 16)
+	if err != nil || !strings.HasPrefix(s, "v") || n < ProtocolV1 {
+		return 0, fmt.Errorf("invalid protocol version %q", s)
+	}
+	return int(n), nil
+}
+
+// NegotiateWireFormat returns the format a connection is sent in after
+// hello, given the version agreed and the formats the client offered: the
+// first one known, if the version has formats, else JSON.
+func NegotiateWireFormat(version int, offered []string) WireFormat {
+	if version < ProtocolFormats {
+		return WireJSON
+	}
+	for _, name := range offered {
+		if f, err := ParseWireFormat(name); err == nil {
+			return f
+		}
+	}
+	return WireJSON
+}
@@ -0,0 +1,19 @@
+package common
+
+import "testing"
+
+func TestParseProtocolVersion(t *testing.T) {
+	for in, want := range map[string]int{"v1": 1, "v2": 2, "v12": 12} {
+		if got, err := ParseProtocolVersion(in); err != nil || got != want {
+			t.Errorf("ParseProtocolVersion(%q) = %d, %v; want %d", in, got, err, want)
+		}
+		if s := FormatProtocolVersion(want); s != in {
+			t.Errorf("FormatProtocolVersion(%d) = %q", want, s)
+		}
+	}
+	for _, bad := range []string{"", "v", "2", "v0", "v-1", "v+2", "v2.0", "unkown command"} {
+		if v, err := ParseProtocolVersion(bad); err == nil {
+			t.Errorf("ParseProtocolVersion(%q) = %d, want an error", bad, v)
+		}
+	}
+}
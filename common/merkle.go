@@ -0,0 +1,115 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// A file's Merkle tree is built over its chunk hashes, in chunk order, so
+// one chunk can be checked against the file's root with log2(chunks)
+// sibling hashes instead of the whole chunk list. Nodes are SHA256 of a
+// prefix byte, telling leaves from inner nodes, and their children; the
+// last node of a level with an odd count is paired with itself.
+
+// merkleLeaf returns the leaf node for a hex chunk hash.
+func merkleLeaf(chunkHash string) ([]byte, bool) {
+	b, err := hex.DecodeString(chunkHash)
+	if err != nil || len(b) == 0 {
+		return nil, false
+	}
+	sum := sha256.Sum256(append([]byte{0}, b...))
+	return sum[:], true
+}
+
+func merkleNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleLevels returns every level of the tree over chunkHashes, leaves
+// first and the root last, or nil if there are none or one isn't hex.
+func merkleLevels(chunkHashes []string) [][][]byte {
+	if len(chunkHashes) == 0 {
+		return nil
+	}
+	level := make([][]byte, len(chunkHashes))
+	for i, c := range chunkHashes {
+		leaf, ok := merkleLeaf(c)
+		if !ok {
+			return nil
+		}
+		level[i] = leaf
+	}
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, merkleNode(level[i], right))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// MerkleRoot returns the hex root of the tree over chunkHashes, "" if
+// there are none or one isn't a hex hash.
+func MerkleRoot(chunkHashes []string) string {
+	levels := merkleLevels(chunkHashes)
+	if levels == nil {
+		return ""
+	}
+	return hex.EncodeToString(levels[len(levels)-1][0])
+}
+
+// MerkleProof returns the hex sibling hashes, from the leaves up, linking
+// chunk idx to the root of the tree over chunkHashes. It is nil if idx is
+// out of range or the tree can't be built.
+func MerkleProof(chunkHashes []string, idx int) []string {
+	if idx < 0 || idx >= len(chunkHashes) {
+		return nil
+	}
+	levels := merkleLevels(chunkHashes)
+	if levels == nil {
+		return nil
+	}
+	proof := make([]string, 0, len(levels)-1)
+	for _, level := range levels[:len(levels)-1] {
+		sibling := idx ^ 1
+		if sibling >= len(level) {
+			sibling = idx
+		}
+		proof = append(proof, hex.EncodeToString(level[sibling]))
+		idx /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof reports whether proof, from MerkleProof, links the
+// chunk hash chunkHash at index idx to root.
+func VerifyMerkleProof(chunkHash string, idx int, proof []string, root string) bool {
+	node, ok := merkleLeaf(chunkHash)
+	if !ok || idx < 0 {
+		return false
+	}
+	for _, s := range proof {
+		sibling, err := hex.DecodeString(s)
+		if err != nil || len(sibling) != sha256.Size {
+			return false
+		}
+		if idx%2 == 0 {
+			node = merkleNode(node, sibling)
+		} else {
+			node = merkleNode(sibling, node)
+		}
+		idx /= 2
+	}
+	return idx == 0 && hex.EncodeToString(node) == root
+}
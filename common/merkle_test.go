@@ -0,0 +1,75 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func testChunkHashes(n int) []string {
+	hashes := make([]string, n)
+	for i := range hashes {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("chunk %d", i)))
+		hashes[i] = hex.EncodeToString(sum[:])
+	}
+	return hashes
+}
+
+// TestMerkleProof checks every chunk's proof verifies against the root,
+// for power-of-two and odd chunk counts, with log2 sibling hashes.
+func TestMerkleProof(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 8, 13} {
+		hashes := testChunkHashes(n)
+		root := MerkleRoot(hashes)
+		depth := 0
+		for 1<<depth < n {
+			depth++
+		}
+		for i := range hashes {
+			proof := MerkleProof(hashes, i)
+			if len(proof) != depth {
+				t.Errorf("n=%d chunk %d: %d hashes in proof, want %d", n, i, len(proof), depth)
+			}
+			if !VerifyMerkleProof(hashes[i], i, proof, root) {
+				t.Errorf("n=%d chunk %d: proof doesn't verify", n, i)
+			}
+		}
+	}
+}
+
+// TestMerkleProof_Rejects checks a proof fails for the wrong chunk, the
+// wrong index, a tampered sibling or another file's root.
+func TestMerkleProof_Rejects(t *testing.T) {
+	hashes := testChunkHashes(6)
+	root := MerkleRoot(hashes)
+	proof := MerkleProof(hashes, 2)
+
+	if VerifyMerkleProof(hashes[3], 2, proof, root) {
+		t.Error("another chunk's hash verified")
+	}
+	if VerifyMerkleProof(hashes[2], 3, proof, root) {
+		t.Error("wrong index verified")
+	}
+	if VerifyMerkleProof(hashes[2], 2+8, proof, root) {
+		t.Error("index beyond the tree verified")
+	}
+	tampered := append([]string(nil), proof...)
+	tampered[1] = hashes[0]
+	if VerifyMerkleProof(hashes[2], 2, tampered, root) {
+		t.Error("tampered proof verified")
+	}
+	if VerifyMerkleProof(hashes[2], 2, proof, MerkleRoot(testChunkHashes(5))) {
+		t.Error("another root verified")
+	}
+	if VerifyMerkleProof(hashes[2], 2, proof[:1], root) {
+		t.Error("short proof verified")
+	}
+
+	if MerkleRoot(nil) != "" || MerkleRoot([]string{"not hex"}) != "" {
+		t.Error("root of an unbuildable tree")
+	}
+	if MerkleProof(hashes, 6) != nil || MerkleProof(hashes, -1) != nil {
+		t.Error("proof for an index out of range")
+	}
+}
@@ -0,0 +1,173 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrMuxTimeout is returned by Mux.Call when no response arrives in time.
+// The connection stays usable; a late response is dropped.
+var ErrMuxTimeout = errors.New("mux: request timed out")
+
+// ErrMuxClosed is returned by Mux.Call, wrapping why the connection
+// stopped, when it had stopped before the request was sent, so the request
+// never reached the far end.
+var ErrMuxClosed = errors.New("mux: connection closed")
+
+// SendMux writes v as a multiplexed frame: a 4-byte length and a 4-byte
+// request ID, both big-endian, then the JSON payload the length counts.
+func SendMux(conn net.Conn, id uint32, v any) error {
+	return SendMuxFormat(conn, id, WireJSON, v)
+}
+
+// SendMuxFormat is SendMux with the payload in format f.
+func SendMuxFormat(conn net.Conn, id uint32, f WireFormat, v any) error {
+	data, err := MarshalFrame(f, v)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(frame[4:8], id)
+	copy(frame[8:], data)
+	_, err = conn.Write(frame)
+	return err
+}
+
+// RecvMux reads one multiplexed frame and returns its request ID and payload.
+func RecvMux(conn net.Conn) (uint32, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint32(header[4:8]), data, nil
+}
+
+// Mux carries many requests at once over one connection. Each request gets
+// the next ID and its response is matched back by that ID, so responses may
+// arrive in any order.
+type Mux struct {
+	conn   net.Conn
+	format WireFormat // of the requests; responses may come in any
+	wmu    sync.Mutex // one frame is written at a time
+
+	mu       sync.Mutex
+	nextID   uint32
+	pending  map[uint32]chan []byte
+	err      error // why the read loop stopped; nil while it runs
+	answered bool  // a response has arrived
+}
+
+// NewMux starts demultiplexing responses from conn.
+func NewMux(conn net.Conn) *Mux {
+	return NewMuxFormat(conn, WireJSON)
+}
+
+// NewMuxFormat is NewMux for a connection whose requests are sent in
+// format f.
+func NewMuxFormat(conn net.Conn, f WireFormat) *Mux {
+	m := &Mux{conn: conn, format: f, pending: make(map[uint32]chan []byte)}
+	go m.readLoop()
+	return m
+}
+
+// Format returns the format requests are sent in.
+func (m *Mux) Format() WireFormat {
+	return m.format
+}
+
+func (m *Mux) readLoop() {
+	for {
+		id, data, err := RecvMux(m.conn)
+		m.mu.Lock()
+		if err != nil {
+			m.err = err
+			for id, ch := range m.pending {
+				close(ch)
+				delete(m.pending, id)
+			}
+			m.mu.Unlock()
+			return
+		}
+		ch := m.pending[id]
+		delete(m.pending, id)
+		m.answered = true
+		m.mu.Unlock()
+		if ch != nil {
+			ch <- data // buffered, never blocks
+		}
+	}
+}
+
+// Call sends req and decodes its response into resp, waiting at most timeout.
+func (m *Mux) Call(req, resp any, timeout time.Duration) error {
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %w", ErrMuxClosed, m.err)
+	}
+	m.nextID++
+	id := m.nextID
+	ch := make(chan []byte, 1)
+	m.pending[id] = ch
+	m.mu.Unlock()
+
+	m.wmu.Lock()
+	m.conn.SetWriteDeadline(time.Now().Add(timeout))
+	err := SendMuxFormat(m.conn, id, m.format, req)
+	m.wmu.Unlock()
+	if err != nil {
+		// A partly written frame leaves the stream unusable
+		m.conn.Close()
+		m.forget(id)
+		return err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case data, ok := <-ch:
+		if !ok {
+			return m.Err()
+		}
+		return UnmarshalFrame(data, resp)
+	case <-timer.C:
+		m.forget(id)
+		return ErrMuxTimeout
+	}
+}
+
+func (m *Mux) forget(id uint32) {
+	m.mu.Lock()
+	delete(m.pending, id)
+	m.mu.Unlock()
+}
+
+// Err returns why the connection stopped, or nil while it is open.
+func (m *Mux) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Answered reports whether any response has come back over the connection,
+// which shows the far end speaks the multiplexed protocol.
+func (m *Mux) Answered() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.answered
+}
+
+// Close closes the connection; calls waiting on it return an error.
+func (m *Mux) Close() error {
+	return m.conn.Close()
+}
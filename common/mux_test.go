@@ -0,0 +1,113 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reverseServer reads n multiplexed requests, then answers them last to
+// first, echoing each payload back with an "echo:" prefix.
+func reverseServer(conn net.Conn, n int) {
+	type req struct {
+		id   uint32
+		body string
+	}
+	var reqs []req
+	for len(reqs) < n {
+		id, data, err := RecvMux(conn)
+		if err != nil {
+			return
+		}
+		var body string
+		json.Unmarshal(data, &body)
+		reqs = append(reqs, req{id, body})
+	}
+	for i := len(reqs) - 1; i >= 0; i-- {
+		SendMux(conn, reqs[i].id, "echo:"+reqs[i].body)
+	}
+}
+
+// TestMux_ConcurrentRequests has many goroutines share one connection to a
+// server that answers out of order, and checks each gets its own response.
+func TestMux_ConcurrentRequests(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	const n = 20
+	go reverseServer(server, n)
+
+	m := NewMux(client)
+	defer m.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var got string
+			want := fmt.Sprintf("echo:req-%d", i)
+			if err := m.Call(fmt.Sprintf("req-%d", i), &got, 2*time.Second); err != nil {
+				errs <- err
+			} else if got != want {
+				errs <- fmt.Errorf("request %d got %q, want %q", i, got, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if !m.Answered() {
+		t.Error("Answered() is false after responses arrived")
+	}
+}
+
+func TestMux_Timeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		for {
+			if _, _, err := RecvMux(server); err != nil {
+				return
+			}
+		}
+	}()
+
+	m := NewMux(client)
+	defer m.Close()
+	var got string
+	if err := m.Call("ping", &got, 50*time.Millisecond); err != ErrMuxTimeout {
+		t.Fatalf("got %v, want ErrMuxTimeout", err)
+	}
+	if m.Err() != nil {
+		t.Fatalf("connection closed after a timeout: %v", m.Err())
+	}
+}
+
+// TestMux_ClosedConnection checks waiting and later calls fail once the far
+// end hangs up, and that the mux reports it never got an answer.
+func TestMux_ClosedConnection(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		RecvMux(server)
+		server.Close()
+	}()
+
+	m := NewMux(client)
+	defer m.Close()
+	var got string
+	if err := m.Call("ping", &got, time.Second); err == nil || errors.Is(err, ErrMuxClosed) {
+		t.Fatalf("sent request = %v, want the hang-up's error", err)
+	}
+	if err := m.Call("again", &got, time.Second); !errors.Is(err, ErrMuxClosed) {
+		t.Fatalf("call on a closed mux = %v, want ErrMuxClosed", err)
+	}
+	if m.Answered() {
+		t.Error("Answered() is true with no response")
+	}
+}
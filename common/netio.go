@@ -2,13 +2,25 @@ package common
 
 import (
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"io"
 	"net"
 )
 
 func Send(conn net.Conn, v any) error {
-	data, err := json.Marshal(v)
+	return SendFormat(conn, WireJSON, v)
+}
+
+// SendMsgPack writes v as a MsgPack frame. Only peers that agreed on
+// MsgPack in hello can read it; Recv and RecvMsgPack both do.
+func SendMsgPack(conn net.Conn, v any) error {
+	return SendFormat(conn, WireMsgPack, v)
+}
+
+// SendFormat writes v as a frame in format f: a 4-byte big-endian length,
+// then the payload it counts.
+func SendFormat(conn net.Conn, f WireFormat, v any) error {
+	data, err := MarshalFrame(f, v)
 	if err != nil {
 		return err
 	}
@@ -24,9 +36,30 @@ func Send(conn net.Conn, v any) error {
 }
 
 func Recv(conn net.Conn, v any) error {
+	data, err := recvFrame(conn)
+	if err != nil {
+		return err
+	}
+	return UnmarshalFrame(data, v)
+}
+
+// RecvMsgPack reads a frame written by SendMsgPack, refusing any other.
+func RecvMsgPack(conn net.Conn, v any) error {
+	data, err := recvFrame(conn)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || WireFormat(data[0]) != WireMsgPack {
+		return errors.New("not a MsgPack frame")
+	}
+	return UnmarshalFrame(data, v)
+}
+
+// recvFrame reads a frame's length and returns the payload it counts.
+func recvFrame(conn net.Conn) ([]byte, error) {
 	lenBuf := make([]byte, 4)
 	if _, err := io.ReadFull(conn, lenBuf); err != nil{
-		return err
+		return nil, err
 	}
 
 	n := binary.BigEndian.Uint32(lenBuf)
@@ -34,9 +67,38 @@ func Recv(conn net.Conn, v any) error {
 
 
 	if _, err := io.ReadFull(conn, data); err != nil {
-		return err
+		return nil, err
 	}
 
-	return json.Unmarshal(data, v)
+	return data, nil
+
+}
+
+// StreamDone is the status of the frame that ends a streamed response.
+const StreamDone = "done"
+
+// Response is a tracker response frame. It has the same layout as the
+// Response types in the tracker and client, so they convert to each other.
+type Response struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data"`
+}
 
-}
\ No newline at end of file
+// RecvStream reads response frames from conn and passes each one to cb
+// until the StreamDone frame arrives. A connection that closes before
+// then is reported as io.ErrUnexpectedEOF.
+func RecvStream(conn net.Conn, cb func(Response)) error {
+	for {
+		var resp Response
+		if err := Recv(conn, &resp); err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if resp.Status == StreamDone {
+			return nil
+		}
+		cb(resp)
+	}
+}
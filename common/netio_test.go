@@ -0,0 +1,55 @@
+package common
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// TestRecvStream_StopsAtDone verifies frames are delivered in order and
+// nothing after the done frame is read.
+func TestRecvStream_StopsAtDone(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		for _, item := range []string{"a", "b", "c"} {
+			Send(server, Response{"ok", item})
+		}
+		Send(server, Response{StreamDone, 3})
+		Send(server, Response{"ok", "after done"})
+	}()
+
+	var got []interface{}
+	if err := RecvStream(client, func(r Response) { got = append(got, r.Data) }); err != nil {
+		t.Fatalf("RecvStream: %v", err)
+	}
+	if len(got) != 3 || got[0] != "a" || got[2] != "c" {
+		t.Errorf("got %v, want [a b c]", got)
+	}
+
+	var next Response
+	if err := Recv(client, &next); err != nil || next.Data != "after done" {
+		t.Errorf("frame after done was consumed: %+v %v", next, err)
+	}
+}
+
+// TestRecvStream_UnterminatedStream verifies a connection closed before the
+// done frame is an error rather than a short result.
+func TestRecvStream_UnterminatedStream(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		Send(server, Response{"ok", "a"})
+		server.Close()
+	}()
+
+	n := 0
+	err := RecvStream(client, func(Response) { n++ })
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if n != 1 {
+		t.Errorf("delivered %d frames, want 1", n)
+	}
+}
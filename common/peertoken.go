@@ -0,0 +1,78 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PeerSecretEnv names the secret trackers sign download tokens with and
+// peer daemons check them with. Peers without it serve anyone, as before.
+const PeerSecretEnv = "P2P_PEER_SECRET"
+
+// PeerTokenTTL is how long a download token is accepted.
+const PeerTokenTTL = time.Hour
+
+var (
+	ErrTokenMissing = errors.New("missing download token")
+	ErrTokenExpired = errors.New("download token expired")
+	ErrTokenInvalid = errors.New("invalid download token")
+)
+
+// PeerSecret returns the download token secret, nil if none is set.
+func PeerSecret() []byte {
+	if s := os.Getenv(PeerSecretEnv); s != "" {
+		return []byte(s)
+	}
+	return nil
+}
+
+// peerTokenMAC is the hex HMAC-SHA256 of "userID:fileHash:expiry".
+func peerTokenMAC(secret []byte, userID, fileHash string, expiry int64) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(userID + ":" + fileHash + ":" + strconv.FormatInt(expiry, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignPeerToken returns a token letting userID download fileHash's chunks
+// until expiry. It reads "expiry:mac:userID", with expiry in Unix seconds;
+// the user comes last as it may contain colons.
+func SignPeerToken(secret []byte, userID, fileHash string, expiry time.Time) string {
+	exp := expiry.Unix()
+	return strconv.FormatInt(exp, 10) + ":" + peerTokenMAC(secret, userID, fileHash, exp) + ":" + userID
+}
+
+// ReplicateTokenSubject is what a replicate_file token is signed for in
+// place of the file hash, so a download token can't ask a peer to replicate.
+func ReplicateTokenSubject(fileHash string) string {
+	return "replicate:" + fileHash
+}
+
+// VerifyPeerToken checks token was signed with secret for fileHash and
+// hasn't expired at now, and returns the user it was issued to.
+func VerifyPeerToken(secret []byte, token, fileHash string, now time.Time) (string, error) {
+	if token == "" {
+		return "", ErrTokenMissing
+	}
+	parts := strings.SplitN(token, ":", 3)
+	if len(parts) != 3 {
+		return "", ErrTokenInvalid
+	}
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+	userID := parts[2]
+	if !hmac.Equal([]byte(parts[1]), []byte(peerTokenMAC(secret, userID, fileHash, exp))) {
+		return "", ErrTokenInvalid
+	}
+	if !now.Before(time.Unix(exp, 0)) {
+		return "", ErrTokenExpired
+	}
+	return userID, nil
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPeerToken checks a signed token verifies for its file until it
+// expires, and that missing, tampered or misapplied tokens don't.
+func TestPeerToken(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1_700_000_000, 0)
+	token := SignPeerToken(secret, "bob:smith", "fh", now.Add(PeerTokenTTL))
+
+	if user, err := VerifyPeerToken(secret, token, "fh", now); err != nil || user != "bob:smith" {
+		t.Fatalf("valid token: %q, %v", user, err)
+	}
+	if _, err := VerifyPeerToken(secret, token, "fh", now.Add(PeerTokenTTL)); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expired token: %v", err)
+	}
+	if _, err := VerifyPeerToken(secret, "", "fh", now); !errors.Is(err, ErrTokenMissing) {
+		t.Errorf("missing token: %v", err)
+	}
+
+	exp, rest, _ := strings.Cut(token, ":")
+	mac, user, _ := strings.Cut(rest, ":")
+	later := SignPeerToken(secret, "bob:smith", "fh", now.Add(2*PeerTokenTTL))
+	laterExp, _, _ := strings.Cut(later, ":")
+	for name, bad := range map[string]string{
+		"other user":     exp + ":" + mac + ":alice",
+		"extended":       laterExp + ":" + mac + ":" + user,
+		"flipped mac":    exp + ":" + strings.Repeat("0", len(mac)) + ":" + user,
+		"garbage":        "not a token",
+		"bad expiry":     "soon:" + mac + ":" + user,
+		"another secret": SignPeerToken([]byte("other"), "bob:smith", "fh", now.Add(PeerTokenTTL)),
+	} {
+		if _, err := VerifyPeerToken(secret, bad, "fh", now); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := VerifyPeerToken(secret, token, "other-file", now); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("token for another file: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package common
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	for in, want := range map[string]Version{
+		"1.2.3":             {1, 2, 3, ""},
+		"v0.10.0":           {0, 10, 0, ""},
+		"v2.0.0-rc.1":       {2, 0, 0, "rc.1"},
+		"v1.0.0-beta+exp.7": {1, 0, 0, "beta"},
+		" v3.4.5 ":          {3, 4, 5, ""},
+	} {
+		got, err := ParseVersion(in)
+		if err != nil || got != want {
+			t.Errorf("ParseVersion(%q) = %+v, %v; want %+v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "v1", "1.2", "1.2.3.4", "v1.x.0", "1.-2.3", "1.+2.3", "1.2.3-", "dev"} {
+		if v, err := ParseVersion(bad); err == nil {
+			t.Errorf("ParseVersion(%q) = %+v, want an error", bad, v)
+		}
+	}
+}
+
+// TestVersionCompare checks precedence follows semver, including the
+// pre-release rules, and that the order is the same either way round.
+func TestVersionCompare(t *testing.T) {
+	// Each version is older than the next
+	ordered := []string{
+		"v0.0.0-dev",
+		"v0.9.9",
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"v1.0.0",
+		"v1.0.1",
+		"v1.2.0",
+		"v1.10.0",
+		"v2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParseVersion(ordered[i])
+			b, _ := ParseVersion(ordered[j])
+			want := sign(i - j)
+			if got := a.Compare(b); got != want {
+				t.Errorf("%s vs %s = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+
+	a, _ := ParseVersion("v1.2.3+linux")
+	b, _ := ParseVersion("1.2.3")
+	if a.Compare(b) != 0 {
+		t.Error("build metadata changed precedence")
+	}
+}
@@ -0,0 +1,29 @@
+package common
+
+import "testing"
+
+func TestParseTrackerEndpoint(t *testing.T) {
+	cases := []struct {
+		in   string
+		want TrackerEndpoint
+	}{
+		{"127.0.0.1:9000", TrackerEndpoint{Addr: "127.0.0.1:9000"}},
+		{"tls://127.0.0.1:9000", TrackerEndpoint{Addr: "127.0.0.1:9000", TLS: true}},
+		{"tls://host:9000 sha256:ABCD", TrackerEndpoint{Addr: "host:9000", TLS: true, Fingerprint: "abcd"}},
+		// A pinned fingerprint is only useful over TLS, so it implies it
+		{"host:9000 sha256:abcd", TrackerEndpoint{Addr: "host:9000", TLS: true, Fingerprint: "abcd"}},
+	}
+	for _, c := range cases {
+		if got := ParseTrackerEndpoint(c.in); got != c.want {
+			t.Errorf("ParseTrackerEndpoint(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestTrackerEndpoint_StringRoundTrip(t *testing.T) {
+	for _, s := range []string{"127.0.0.1:9000", "tls://127.0.0.1:9000", "tls://host:9000 sha256:abcd"} {
+		if got := ParseTrackerEndpoint(s).String(); got != s {
+			t.Errorf("round trip of %q gave %q", s, got)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// WireFormat is how a frame's payload is encoded. Frames in a format other
+// than JSON start with the format's byte. JSON frames are sent without
+// one, as they were before formats were negotiated, so trackers and
+// clients that predate them can read them. They never start with 0x00 or
+// 0x01, so the two are told apart by the first byte.
+type WireFormat byte
+
+const (
+	WireJSON    WireFormat = 0x00
+	WireMsgPack WireFormat = 0x01
+)
+
+// String returns the format's name as --protocol and hello give it.
+func (f WireFormat) String() string {
+	switch f {
+	case WireJSON:
+		return "json"
+	case WireMsgPack:
+		return "msgpack"
+	}
+	return fmt.Sprintf("format 0x%02x", byte(f))
+}
+
+// ParseWireFormat parses a format name, "json" or "msgpack".
+func ParseWireFormat(name string) (WireFormat, error) {
+	switch name {
+	case "json":
+		return WireJSON, nil
+	case "msgpack":
+		return WireMsgPack, nil
+	}
+	return 0, fmt.Errorf("unknown wire format %q (want json or msgpack)", name)
+}
+
+// Times in struct fields go over MsgPack as RFC 3339 strings, as over
+// JSON, rather than as timestamps, which lose the sender's time zone.
+func init() {
+	msgpack.Register(time.Time{},
+		func(e *msgpack.Encoder, v reflect.Value) error {
+			text, err := v.Interface().(time.Time).MarshalText()
+			if err != nil {
+				return err
+			}
+			return e.EncodeString(string(text))
+		},
+		func(d *msgpack.Decoder, v reflect.Value) error {
+			tm, err := d.DecodeTime()
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(tm))
+			return nil
+		})
+}
+
+// MarshalFrame encodes v as a frame payload in format f.
+func MarshalFrame(f WireFormat, v any) ([]byte, error) {
+	switch f {
+	case WireJSON:
+		return json.Marshal(v)
+	case WireMsgPack:
+		var buf bytes.Buffer
+		buf.WriteByte(byte(WireMsgPack))
+		enc := msgpack.GetEncoder()
+		defer msgpack.PutEncoder(enc)
+		enc.Reset(&buf)
+		enc.SetCustomStructTag("json")
+		enc.UseCompactInts(true)
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown wire format 0x%02x", byte(f))
+}
+
+// UnmarshalFrame decodes a frame payload in any format into v. Values
+// decoded into interface{} come out as they would from JSON: numbers as
+// float64, byte slices as base64 strings, times as RFC 3339 strings (in
+// UTC) and map keys as strings.
+func UnmarshalFrame(data []byte, v any) error {
+	if len(data) == 0 {
+		return errors.New("empty frame")
+	}
+	switch WireFormat(data[0]) {
+	case WireJSON:
+		return json.Unmarshal(data[1:], v)
+	case WireMsgPack:
+		dec := msgpack.GetDecoder()
+		defer msgpack.PutDecoder(dec)
+		dec.Reset(bytes.NewReader(data[1:]))
+		dec.SetCustomStructTag("json")
+		dec.SetMapDecoder(decodeStringMap)
+		clearInterfaces(reflect.ValueOf(v))
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+		normalizeInterfaces(reflect.ValueOf(v))
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeStringMap decodes a map into interface{} as map[string]interface{},
+// whatever its keys, as JSON would have written them as strings.
+func decodeStringMap(d *msgpack.Decoder) (interface{}, error) {
+	n, err := d.DecodeMapLen()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.DecodeInterface()
+		if err != nil {
+			return nil, err
+		}
+		if m[fmt.Sprint(k)], err = d.DecodeInterface(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// jsonValue converts a value MsgPack decoded into interface{} to what JSON
+// would have decoded.
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint, float32:
+		return reflect.ValueOf(x).Convert(reflect.TypeFor[float64]()).Float()
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x)
+	case time.Time:
+		// Held in an interface{}, a time is sent as a timestamp, which
+		// doesn't keep the sender's zone
+		return x.UTC().Format(time.RFC3339Nano)
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = jsonValue(e)
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = jsonValue(e)
+		}
+	}
+	return v
+}
+
+// hasInterface caches, per type, whether values of it can hold an
+// interface{} anywhere, which normalizeInterfaces must visit.
+var hasInterface sync.Map // reflect.Type -> bool
+
+func containsInterface(t reflect.Type) bool {
+	if v, ok := hasInterface.Load(t); ok {
+		return v.(bool)
+	}
+	found := typeHasInterface(t, make(map[reflect.Type]bool))
+	hasInterface.Store(t, found)
+	return found
+}
+
+func typeHasInterface(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false // a recursive type, already being looked at
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return typeHasInterface(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() && typeHasInterface(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeInterfaces replaces every interface{} value reachable from v
+// with its jsonValue.
+func normalizeInterfaces(v reflect.Value) {
+	if !v.IsValid() || !containsInterface(v.Type()) {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		normalizeInterfaces(v.Elem())
+	case reflect.Interface:
+		if !v.IsNil() && v.CanSet() {
+			v.Set(reflect.ValueOf(jsonValue(v.Interface())))
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			normalizeInterfaces(v.Index(i))
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			normalizeInterfaces(e)
+			v.SetMapIndex(k, e)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				normalizeInterfaces(v.Field(i))
+			}
+		}
+	}
+}
+
+// clearInterfaces empties the interface{} values in v that hold anything
+// but a pointer. MsgPack decodes into what such a value holds, which it
+// can't set, where JSON replaces it.
+func clearInterfaces(v reflect.Value) {
+	if !v.IsValid() || !containsInterface(v.Type()) {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		clearInterfaces(v.Elem())
+	case reflect.Interface:
+		if !v.IsNil() && v.Elem().Kind() != reflect.Pointer && v.CanSet() {
+			v.SetZero()
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			clearInterfaces(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				clearInterfaces(v.Field(i))
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package common
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// wireSample has the kinds of fields tracker messages carry.
+type wireSample struct {
+	Name     string            `json:"name"`
+	Size     int64             `json:"size"`
+	Ratio    float64           `json:"ratio,omitempty"`
+	Owners   map[string]bool   `json:"owners"`
+	Peers    map[int][]string  `json:"peers"`
+	Raw      []byte            `json:"raw"`
+	Created  time.Time         `json:"created"`
+	Skipped  string            `json:"-"`
+	Data     interface{}       `json:"data"`
+	Children []*wireSample     `json:"children,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// decodeBoth decodes v sent as JSON and as MsgPack into fresh values of
+// its type and returns both.
+func decodeBoth(t *testing.T, v any) (fromJSON, fromMsgPack any) {
+	t.Helper()
+	typ := reflect.TypeOf(v)
+	for _, f := range []WireFormat{WireJSON, WireMsgPack} {
+		data, err := MarshalFrame(f, v)
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		out := reflect.New(typ)
+		if err := UnmarshalFrame(data, out.Interface()); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if f == WireJSON {
+			fromJSON = out.Elem().Interface()
+		} else {
+			fromMsgPack = out.Elem().Interface()
+		}
+	}
+	return fromJSON, fromMsgPack
+}
+
+// TestMsgPack_DecodesAsJSON checks a value sent in MsgPack decodes to just
+// what it would from JSON, interface{} values included.
+func TestMsgPack_DecodesAsJSON(t *testing.T) {
+	zone := time.FixedZone("", 2*60*60)
+	sample := wireSample{
+		Name:    "a.txt",
+		Size:    1 << 40,
+		Owners:  map[string]bool{"alice": true},
+		Peers:   map[int][]string{0: {"127.0.0.1:7000"}},
+		Raw:     []byte{0, 1, 2},
+		Created: time.Date(2026, 10, 16, 12, 30, 0, 500, zone),
+		Skipped: "not sent",
+		Data: map[string]interface{}{
+			"count":   7,
+			"small":   int8(-3),
+			"big":     uint64(1 << 50),
+			"ratio":   0.25,
+			"when":    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			"chunks":  []int{1, 2, 3},
+			"by_id":   map[int]string{4: "four"},
+			"bytes":   []byte("hi"),
+			"nothing": nil,
+			"ok":      true,
+		},
+		Children: []*wireSample{{Name: "child", Data: []interface{}{1, "x"}}},
+	}
+	fromJSON, fromMsgPack := decodeBoth(t, sample)
+	if !reflect.DeepEqual(fromJSON, fromMsgPack) {
+		t.Errorf("MsgPack decoded\n%#v\nJSON decoded\n%#v", fromMsgPack, fromJSON)
+	}
+
+	for _, v := range []any{
+		Response{"ok", []interface{}{map[string]interface{}{"n": 1}}},
+		Response{"error", "file not found"},
+		Response{StreamDone, 3},
+		[]string{"a", "b"},
+		map[string]int{"a": 1},
+	} {
+		fromJSON, fromMsgPack := decodeBoth(t, v)
+		if !reflect.DeepEqual(fromJSON, fromMsgPack) {
+			t.Errorf("%+v: MsgPack decoded %#v, JSON %#v", v, fromMsgPack, fromJSON)
+		}
+	}
+}
+
+// TestUnmarshalFrame_Formats decodes the same response framed each way.
+func TestUnmarshalFrame_Formats(t *testing.T) {
+	plain, _ := json.Marshal(Response{"ok", "x"})
+	packed, _ := MarshalFrame(WireMsgPack, Response{"ok", "x"})
+	for name, data := range map[string][]byte{
+		"untagged JSON": plain,
+		"tagged JSON":   append([]byte{byte(WireJSON)}, plain...),
+		"MsgPack":       packed,
+	} {
+		var resp Response
+		if err := UnmarshalFrame(data, &resp); err != nil || resp != (Response{"ok", "x"}) {
+			t.Errorf("%s: %+v, %v", name, resp, err)
+		}
+	}
+	for name, data := range map[string][]byte{
+		"empty":          nil,
+		"unknown format": append([]byte{0x02}, packed[1:]...),
+		"truncated":      packed[:len(packed)-1],
+	} {
+		var resp Response
+		if err := UnmarshalFrame(data, &resp); err == nil {
+			t.Errorf("%s decoded: %+v", name, resp)
+		}
+	}
+}
+
+// TestUnmarshalFrame_ReusedValue decodes into a response still holding
+// the last one's data, which MsgPack on its own can't replace.
+func TestUnmarshalFrame_ReusedValue(t *testing.T) {
+	resp := Response{"ok", "previous"}
+	data, _ := MarshalFrame(WireMsgPack, Response{"ok", map[string]interface{}{"n": 2}})
+	if err := UnmarshalFrame(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := resp.Data.(map[string]interface{}); !ok || m["n"] != float64(2) {
+		t.Errorf("decoded %+v", resp)
+	}
+}
+
+func TestRecvMsgPack(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		SendMsgPack(server, Response{"ok", 1})
+		Send(server, Response{"ok", 2})
+	}()
+
+	var resp Response
+	if err := RecvMsgPack(client, &resp); err != nil || resp.Data != float64(1) {
+		t.Errorf("RecvMsgPack: %+v, %v", resp, err)
+	}
+	if err := RecvMsgPack(client, &resp); err == nil {
+		t.Errorf("RecvMsgPack took a JSON frame: %+v", resp)
+	}
+}
+
+func TestNegotiateWireFormat(t *testing.T) {
+	for _, tc := range []struct {
+		version int
+		offered []string
+		want    WireFormat
+	}{
+		{3, []string{"msgpack"}, WireMsgPack},
+		{3, []string{"cbor", "msgpack"}, WireMsgPack},
+		{3, []string{"json", "msgpack"}, WireJSON},
+		{3, nil, WireJSON},
+		{2, []string{"msgpack"}, WireJSON},
+	} {
+		if got := NegotiateWireFormat(tc.version, tc.offered); got != tc.want {
+			t.Errorf("NegotiateWireFormat(%d, %v) = %s, want %s", tc.version, tc.offered, got, tc.want)
+		}
+	}
+}
@@ -155,9 +155,10 @@ type FileMetadata struct {
 
 // ChunkInfo represents chunk metadata
 type ChunkInfo struct {
-	Index int    `json:"index"`
-	Hash  string `json:"hash"`
-	Size  int64  `json:"size"`
+	Index         int    `json:"index"`
+	Hash          string `json:"hash"`
+	Size          int64  `json:"size"`
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
 }
 
 // UploadFile stores file metadata in DHT
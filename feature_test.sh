@@ -209,7 +209,7 @@ grep -i "merged state\|rejoin\|sync\|missed" /tmp/feature_tracker2.log | tail -6
 section "3. Rarest-first piece selection (P2P_RAREST_FIRST=1)"
 # ═══════════════════════════════════════════════════════════════════════════════
 
-# Create a file that spans exactly 2 chunks (~600KB → chunk 0 = 512KB, chunk 1 = ~88KB)
+# Create a file that spans several chunks (~600KB → 256KB + 256KB + ~88KB)
 dd if=/dev/urandom bs=1024 count=600 of="$TESTROOT/testfiles/rarity.bin" 2>/dev/null
 
 # Set up group and upload (Alice is the seeder with ALL chunks)
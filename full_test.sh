@@ -99,8 +99,8 @@ done
 
 # Create test files
 echo "Hello from Alice, this is a test file!" > "$TESTROOT/testfiles/hello.txt"
-dd if=/dev/urandom bs=1024 count=600 of="$TESTROOT/testfiles/medium.bin" 2>/dev/null  # ~600KB (1+ chunk)
-dd if=/dev/urandom bs=1024 count=1800 of="$TESTROOT/testfiles/large.bin" 2>/dev/null  # ~1.8MB (3+ chunks)
+dd if=/dev/urandom bs=1024 count=600 of="$TESTROOT/testfiles/medium.bin" 2>/dev/null  # ~600KB (2+ chunks)
+dd if=/dev/urandom bs=1024 count=1800 of="$TESTROOT/testfiles/large.bin" 2>/dev/null  # ~1.8MB (7+ chunks)
 
 pass "Test directories created"
 pass "Test files created (hello.txt, medium.bin ~600KB, large.bin ~1.8MB)"
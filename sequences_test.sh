@@ -506,7 +506,7 @@ login_user "$A9" alice9 pass9; sleep 0.5
 login_user "$B9" bob9   pass9; sleep 0.4
 
 run "$A9" create_group grp9 > /dev/null
-make_file "$A9/big.dat" 1100   # > 1 MiB → multiple 512 KiB chunks
+make_file "$A9/big.dat" 1100   # > 1 MiB → multiple 256 KiB chunks
 run "$A9" upload_file big.dat grp9 > /dev/null
 run "$B9" join_group grp9 > /dev/null
 run "$A9" accept_request grp9 bob9 > /dev/null
@@ -598,7 +598,7 @@ login_user "$D11" dave11  pass11; sleep 0.5
 login_user "$E11" eve11   pass11; sleep 0.5
 
 run "$A11" create_group grp11 > /dev/null
-make_file "$A11/rarest.dat" 720   # ~720 KiB → 2 × 512 KiB chunks
+make_file "$A11/rarest.dat" 720   # ~720 KiB → 3 × 256 KiB chunks
 run "$A11" upload_file rarest.dat grp11 > /dev/null
 
 run "$B11" join_group grp11 > /dev/null
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+const aclFile = "acl.txt"
+
+// ACL restricts which client IPs may talk to the tracker.
+// Deny rules take priority; if any allow rules exist, only matching IPs get in.
+type ACL struct {
+	mu    sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+
+	// Ranges given on the command line; always kept across acl.txt reloads
+	flagAllow []*net.IPNet
+	flagDeny  []*net.IPNet
+}
+
+var trackerACL = &ACL{}
+
+// Allowed reports whether a connection from ip should be served.
+func (a *ACL) Allowed(ip net.IP) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if ip == nil {
+		return len(a.allow) == 0 && len(a.deny) == 0
+	}
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedAddr extracts the IP from a net.Addr and checks it.
+func (a *ACL) AllowedAddr(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return a.Allowed(net.ParseIP(host))
+}
+
+// SetFlags installs the --allowlist / --denylist ranges.
+func (a *ACL) SetFlags(allow, deny []*net.IPNet) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.flagAllow, a.flagDeny = allow, deny
+	a.allow = append([]*net.IPNet(nil), allow...)
+	a.deny = append([]*net.IPNet(nil), deny...)
+}
+
+// Reload re-reads path and replaces the file-based rules, keeping the flag ranges.
+// A missing file simply means no extra rules.
+func (a *ACL) Reload(path string) error {
+	allow, deny, err := loadACLFile(path)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.allow = append(append([]*net.IPNet(nil), a.flagAllow...), allow...)
+	a.deny = append(append([]*net.IPNet(nil), a.flagDeny...), deny...)
+	return nil
+}
+
+// loadACLFile parses an ACL file with one "allow <cidr>" or "deny <cidr>" per line.
+func loadACLFile(path string) (allow, deny []*net.IPNet, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("%s:%d: expected \"allow|deny <cidr>\"", path, lineNum)
+		}
+		n, err := parseCIDR(fields[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		switch fields[0] {
+		case "allow":
+			allow = append(allow, n)
+		case "deny":
+			deny = append(deny, n)
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: unknown rule %q", path, lineNum, fields[0])
+		}
+	}
+	return allow, deny, scanner.Err()
+}
+
+// parseCIDRList parses a comma-separated list of CIDR ranges or bare IPs.
+func parseCIDRList(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		n, err := parseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// parseCIDR accepts "10.0.0.0/8" style ranges as well as single addresses.
+func parseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", s)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestACL builds an ACL from comma-separated allow and deny lists.
+func newTestACL(t *testing.T, allow, deny string) *ACL {
+	t.Helper()
+	a, err := parseCIDRList(allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := parseCIDRList(deny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl := &ACL{}
+	acl.SetFlags(a, d)
+	return acl
+}
+
+// checkAllowed asserts the verdict for each IP in cases.
+func checkAllowed(t *testing.T, acl *ACL, cases map[string]bool) {
+	t.Helper()
+	for ip, want := range cases {
+		if got := acl.Allowed(net.ParseIP(ip)); got != want {
+			t.Errorf("Allowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+// ── CIDR parsing and matching ─────────────────────────────────────────────────
+
+// TestParseCIDR_Matching verifies ranges, bare IPv4/IPv6 addresses and rejects
+// malformed input.
+func TestParseCIDR_Matching(t *testing.T) {
+	cases := []struct {
+		cidr  string
+		ip    string
+		match bool
+	}{
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "11.0.0.1", false},
+		{"192.168.1.0/24", "192.168.1.255", true},
+		{"192.168.1.0/24", "192.168.2.1", false},
+		{"127.0.0.1", "127.0.0.1", true},
+		{"127.0.0.1", "127.0.0.2", false},
+		{"::1", "::1", true},
+		{"fd00::/8", "fd12::1", true},
+		{"fd00::/8", "fe80::1", false},
+	}
+	for _, c := range cases {
+		n, err := parseCIDR(c.cidr)
+		if err != nil {
+			t.Fatalf("parseCIDR(%q): %v", c.cidr, err)
+		}
+		if got := n.Contains(net.ParseIP(c.ip)); got != c.match {
+			t.Errorf("%s contains %s = %v, want %v", c.cidr, c.ip, got, c.match)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip", "300.1.1.1"} {
+		if _, err := parseCIDR(bad); err == nil {
+			t.Errorf("parseCIDR(%q) should fail", bad)
+		}
+	}
+}
+
+// TestParseCIDRList_SkipsBlanks verifies whitespace and empty entries are ignored.
+func TestParseCIDRList_SkipsBlanks(t *testing.T) {
+	nets, err := parseCIDRList(" 10.0.0.0/8, ,127.0.0.1 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 2 {
+		t.Fatalf("got %d ranges, want 2", len(nets))
+	}
+}
+
+// ── Modes ─────────────────────────────────────────────────────────────────────
+
+// TestACL_Open verifies that with no rules every client is accepted.
+func TestACL_Open(t *testing.T) {
+	acl := newTestACL(t, "", "")
+	checkAllowed(t, acl, map[string]bool{
+		"10.0.0.1":    true,
+		"203.0.113.9": true,
+	})
+}
+
+// TestACL_AllowlistOnly verifies only listed ranges get in.
+func TestACL_AllowlistOnly(t *testing.T) {
+	acl := newTestACL(t, "10.0.0.0/8,127.0.0.1", "")
+	checkAllowed(t, acl, map[string]bool{
+		"10.20.30.40": true,
+		"127.0.0.1":   true,
+		"127.0.0.2":   false,
+		"192.168.0.1": false,
+	})
+	if acl.Allowed(nil) {
+		t.Error("unparseable address should be rejected when an allowlist is set")
+	}
+}
+
+// TestACL_DenylistOnly verifies listed ranges are blocked and everyone else passes.
+func TestACL_DenylistOnly(t *testing.T) {
+	acl := newTestACL(t, "", "192.168.0.0/16")
+	checkAllowed(t, acl, map[string]bool{
+		"192.168.5.5": false,
+		"10.0.0.1":    true,
+		"127.0.0.1":   true,
+	})
+}
+
+// TestACL_Combined verifies deny wins over allow when ranges overlap.
+func TestACL_Combined(t *testing.T) {
+	acl := newTestACL(t, "10.0.0.0/8", "10.1.0.0/16")
+	checkAllowed(t, acl, map[string]bool{
+		"10.2.0.1":    true,
+		"10.1.0.1":    false,
+		"172.16.0.1":  false,
+		"10.1.255.25": false,
+	})
+}
+
+// TestACL_AllowedAddr verifies the IP is taken from a host:port address.
+func TestACL_AllowedAddr(t *testing.T) {
+	acl := newTestACL(t, "127.0.0.1", "")
+	if !acl.AllowedAddr(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}) {
+		t.Error("127.0.0.1:5000 should be allowed")
+	}
+	if acl.AllowedAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}) {
+		t.Error("10.0.0.1:5000 should be rejected")
+	}
+}
+
+// ── acl.txt reload ────────────────────────────────────────────────────────────
+
+// TestACL_Reload verifies file rules are merged with flag rules and replaced
+// wholesale on the next reload.
+func TestACL_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.txt")
+	acl := newTestACL(t, "", "10.9.0.0/16")
+
+	if err := os.WriteFile(path, []byte("# comment\nallow 10.0.0.0/8\ndeny 10.1.0.0/16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := acl.Reload(path); err != nil {
+		t.Fatal(err)
+	}
+	checkAllowed(t, acl, map[string]bool{
+		"10.2.0.1":   true,
+		"10.1.0.1":   false,
+		"10.9.0.1":   false, // flag deny survives
+		"172.16.0.1": false,
+	})
+
+	if err := os.WriteFile(path, []byte("deny 10.2.0.0/16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := acl.Reload(path); err != nil {
+		t.Fatal(err)
+	}
+	checkAllowed(t, acl, map[string]bool{
+		"10.2.0.1":   false,
+		"10.1.0.1":   true,
+		"10.9.0.1":   false,
+		"172.16.0.1": true,
+	})
+
+	// A missing file drops back to just the flag rules
+	if err := acl.Reload(filepath.Join(t.TempDir(), "missing.txt")); err != nil {
+		t.Fatal(err)
+	}
+	checkAllowed(t, acl, map[string]bool{"10.2.0.1": true, "10.9.0.1": false})
+}
+
+// TestACL_ReloadBadFileKeepsRules verifies a malformed file leaves the old rules in place.
+func TestACL_ReloadBadFileKeepsRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.txt")
+	acl := newTestACL(t, "", "10.0.0.0/8")
+
+	if err := os.WriteFile(path, []byte("block 10.0.0.0/8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := acl.Reload(path); err == nil {
+		t.Fatal("expected error for unknown rule")
+	}
+	checkAllowed(t, acl, map[string]bool{"10.0.0.1": false})
+}
+
+// TestParseACLFlags verifies flags are consumed and positional args survive.
+func TestParseACLFlags(t *testing.T) {
+	saved := trackerACL
+	trackerACL = &ACL{}
+	defer func() { trackerACL = saved }()
+
+	rest, err := parseACLFlags([]string{"--allowlist", "10.0.0.0/8", "tracker_info.txt", "--denylist=10.1.0.0/16", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 2 || rest[0] != "tracker_info.txt" || rest[1] != "1" {
+		t.Fatalf("rest = %v", rest)
+	}
+	checkAllowed(t, trackerACL, map[string]bool{"10.2.0.1": true, "10.1.0.1": false})
+
+	if _, err := parseACLFlags([]string{"--allowlist"}); err == nil {
+		t.Error("expected error for missing value")
+	}
+}
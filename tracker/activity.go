@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const activityFile = "activity_log.json"
+
+// activityFlushInterval is how often activity logs are written to
+// activityFile. Events since the last flush are lost if the tracker dies.
+const activityFlushInterval = time.Hour
+
+// Activity event types
+const (
+	ActivityUpload   = "upload"
+	ActivityDownload = "download"
+	ActivityJoin     = "join"
+)
+
+// ActivityEvent is one entry in a group's activity log.
+type ActivityEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	EventType string    `json:"event_type"`
+	UserID    string    `json:"user_id"`
+}
+
+// activityPeriod is a group_activity window split into equal buckets.
+type activityPeriod struct {
+	buckets int
+	width   time.Duration
+}
+
+var activityPeriods = map[string]activityPeriod{
+	"day":   {24, time.Hour},
+	"week":  {7, 24 * time.Hour},
+	"month": {30, 24 * time.Hour},
+}
+
+// activityRetention is the longest period; older events are dropped on flush.
+const activityRetention = 30 * 24 * time.Hour
+
+// activityLog holds each group's events in the order they were recorded.
+// It has its own lock so events can be recorded by handlers holding mu.
+var (
+	activityMu  sync.Mutex
+	activityLog = make(map[string][]ActivityEvent)
+	activityNow = time.Now // tests move the clock
+)
+
+// recordActivity appends an event to groupID's log.
+func recordActivity(groupID, eventType, userID string, at time.Time) {
+	if isCanaryKey(groupID) {
+		return
+	}
+	activityMu.Lock()
+	defer activityMu.Unlock()
+	activityLog[groupID] = append(activityLog[groupID], ActivityEvent{Timestamp: at.UTC(), EventType: eventType, UserID: userID})
+}
+
+// moveActivity keeps a renamed group's history.
+func moveActivity(groupID, newGroupID string) {
+	activityMu.Lock()
+	defer activityMu.Unlock()
+	if log, ok := activityLog[groupID]; ok {
+		activityLog[newGroupID] = log
+		delete(activityLog, groupID)
+	}
+}
+
+// dropActivity forgets groupID's log, when the group is deleted.
+func dropActivity(groupID string) {
+	activityMu.Lock()
+	defer activityMu.Unlock()
+	delete(activityLog, groupID)
+}
+
+// runActivityFlusher writes the activity logs to disk every interval.
+func runActivityFlusher(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := flushActivity(); err != nil {
+			fmt.Printf("Warning: Failed to save activity log: %v\n", err)
+		}
+	}
+}
+
+// flushActivity drops events past activityRetention and writes the rest
+// to activityFile.
+func flushActivity() error {
+	cutoff := activityNow().Add(-activityRetention)
+
+	activityMu.Lock()
+	for groupID, log := range activityLog {
+		// Synced events can arrive late, so the log isn't strictly in order
+		kept := log[:0]
+		for _, e := range log {
+			if !e.Timestamp.Before(cutoff) {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			delete(activityLog, groupID)
+		} else {
+			activityLog[groupID] = kept
+		}
+	}
+	data, err := json.MarshalIndent(activityLog, "", "  ")
+	activityMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(activityFile, data, 0644)
+}
+
+// loadActivity reads activityFile if it exists.
+func loadActivity() error {
+	data, err := os.ReadFile(activityFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log := make(map[string][]ActivityEvent)
+	if err := json.Unmarshal(data, &log); err != nil {
+		return err
+	}
+	activityMu.Lock()
+	activityLog = log
+	activityMu.Unlock()
+	return nil
+}
+
+// groupActivity counts a group's uploads, downloads and joins over the
+// last day (24 hourly buckets), week (7 daily buckets) or month (30 daily
+// buckets), oldest bucket first. Only the owner may ask.
+// args: [groupID, ownerID, period]
+func groupActivity(args []string) Response {
+	groupID, ownerID, name := args[0], args[1], args[2]
+	period, ok := activityPeriods[name]
+	if !ok {
+		return Response{"error", fmt.Sprintf("invalid period %q: want day, week or month", name)}
+	}
+
+	mu.RLock()
+	g, ok := groups[groupID]
+	owner := ok && g.Owner == ownerID
+	mu.RUnlock()
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if !owner {
+		return Response{"error", "not owner"}
+	}
+
+	now := activityNow().UTC()
+	start := now.Add(-time.Duration(period.buckets) * period.width)
+	buckets := make([]map[string]interface{}, period.buckets)
+	for i := range buckets {
+		buckets[i] = map[string]interface{}{
+			"start":          start.Add(time.Duration(i) * period.width),
+			ActivityUpload:   0,
+			ActivityDownload: 0,
+			ActivityJoin:     0,
+		}
+	}
+
+	activityMu.Lock()
+	for _, e := range activityLog[groupID] {
+		if e.Timestamp.Before(start) || e.Timestamp.After(now) {
+			continue
+		}
+		i := int(e.Timestamp.Sub(start) / period.width)
+		if i == period.buckets { // exactly now
+			i--
+		}
+		if n, ok := buckets[i][e.EventType].(int); ok {
+			buckets[i][e.EventType] = n + 1
+		}
+	}
+	activityMu.Unlock()
+
+	return Response{"ok", map[string]interface{}{
+		"group_id":       groupID,
+		"period":         name,
+		"bucket_seconds": int(period.width.Seconds()),
+		"buckets":        buckets,
+	}}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useActivityClock empties the activity logs and fixes the clock at now.
+func useActivityClock(t *testing.T, now time.Time) {
+	t.Helper()
+	activityMu.Lock()
+	activityLog = make(map[string][]ActivityEvent)
+	activityMu.Unlock()
+	saved := activityNow
+	activityNow = func() time.Time { return now }
+	t.Cleanup(func() { activityNow = saved })
+}
+
+// activityCounts runs group_activity and returns each bucket's count of eventType.
+func activityCounts(t *testing.T, period, eventType string) []int {
+	t.Helper()
+	resp := groupActivity([]string{"g1", "alice", period})
+	if resp.Status != "ok" {
+		t.Fatalf("group_activity %s: %+v", period, resp)
+	}
+	var counts []int
+	for _, b := range resp.Data.(map[string]interface{})["buckets"].([]map[string]interface{}) {
+		counts = append(counts, b[eventType].(int))
+	}
+	return counts
+}
+
+// TestGroupActivity_Buckets records events around the period boundaries
+// and checks each lands in the right bucket and older ones are left out.
+func TestGroupActivity_Buckets(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	useActivityClock(t, now)
+
+	for _, ago := range []time.Duration{
+		30 * time.Minute,              // last hour, today
+		90 * time.Minute,              // second to last hour
+		100 * time.Minute,             //   "
+		23*time.Hour + 30*time.Minute, // first hour
+		25 * time.Hour,                // yesterday: in the week only
+		6*24*time.Hour + time.Hour,    // first day of the week
+		8 * 24 * time.Hour,            // before the week
+	} {
+		recordActivity("g1", ActivityDownload, "bob", now.Add(-ago))
+	}
+	recordActivity("g1", ActivityUpload, "alice", now.Add(-time.Minute))
+	recordActivity("g2", ActivityDownload, "bob", now.Add(-time.Minute))
+
+	day := activityCounts(t, "day", ActivityDownload)
+	if len(day) != 24 || day[23] != 1 || day[22] != 2 || day[0] != 1 || sum(day) != 4 {
+		t.Errorf("day downloads = %v", day)
+	}
+	if up := activityCounts(t, "day", ActivityUpload); up[23] != 1 || sum(up) != 1 {
+		t.Errorf("day uploads = %v", up)
+	}
+	week := activityCounts(t, "week", ActivityDownload)
+	if len(week) != 7 || week[6] != 4 || week[5] != 1 || week[0] != 1 || sum(week) != 6 {
+		t.Errorf("week downloads = %v", week)
+	}
+	if month := activityCounts(t, "month", ActivityDownload); len(month) != 30 || sum(month) != 7 {
+		t.Errorf("month downloads = %v", month)
+	}
+}
+
+func sum(counts []int) int {
+	n := 0
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+// TestGroupActivity_Recorded checks uploads, seeding and accepted join
+// requests are counted, and that only the owner may ask.
+func TestGroupActivity_Recorded(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	useActivityClock(t, time.Now().Add(time.Second))
+	mu.Lock()
+	groups["g1"].Pending["carol"] = true
+	mu.Unlock()
+
+	if resp := uploadFile([]string{"a.txt", "g1", "alice", "10", "h", "[]"}); resp.Status != "ok" {
+		t.Fatalf("upload: %+v", resp)
+	}
+	if resp := addSeeder([]string{"g1", "a.txt", "bob"}); resp.Status != "ok" {
+		t.Fatalf("add_seeder: %+v", resp)
+	}
+	if resp := acceptRequest([]string{"g1", "alice", "carol"}); resp.Status != "ok" {
+		t.Fatalf("accept: %+v", resp)
+	}
+	for _, typ := range []string{ActivityUpload, ActivityDownload, ActivityJoin} {
+		if c := activityCounts(t, "day", typ); c[23] != 1 {
+			t.Errorf("%s counts = %v", typ, c)
+		}
+	}
+
+	if resp := groupActivity([]string{"g1", "bob", "day"}); resp.Status != "error" {
+		t.Errorf("member got activity: %+v", resp)
+	}
+	if resp := groupActivity([]string{"g1", "alice", "year"}); resp.Status != "error" {
+		t.Errorf("period year accepted: %+v", resp)
+	}
+}
+
+// TestFlushActivity writes the logs out and reads them back, dropping
+// events older than the longest period.
+func TestFlushActivity(t *testing.T) {
+	t.Chdir(t.TempDir())
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	useActivityClock(t, now)
+	recordActivity("g1", ActivityJoin, "bob", now.Add(-time.Hour))
+	recordActivity("g1", ActivityJoin, "old", now.Add(-activityRetention-time.Hour))
+	recordActivity("g2", ActivityJoin, "old", now.Add(-activityRetention-time.Hour))
+
+	if err := flushActivity(); err != nil {
+		t.Fatal(err)
+	}
+	activityMu.Lock()
+	activityLog = nil
+	activityMu.Unlock()
+	if err := loadActivity(); err != nil {
+		t.Fatal(err)
+	}
+	activityMu.Lock()
+	defer activityMu.Unlock()
+	if len(activityLog) != 1 || len(activityLog["g1"]) != 1 || activityLog["g1"][0].UserID != "bob" {
+		t.Errorf("activity after reload = %+v", activityLog)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditLog   = "audit.log"
+	auditKeepDays     = 7            // days of rotated logs kept, including today
+	auditDayFormat    = "2006-01-02" // suffix of rotated files: audit.log.2006-01-02
+	defaultAuditLines = 50
+	redacted          = "[REDACTED]"
+)
+
+// auditedCommands are the state-modifying client commands written to the audit log.
+// kick_user is listed so it is recorded as soon as a tracker supports it.
+var auditedCommands = map[string]bool{
+	"create_user":        true,
+	"login":              true,
+	"create_group":       true,
+	"join_group":         true,
+	"accept_requests":    true,
+	"bulk_add_members":   true,
+	"import_group":       true,
+	"upload_file":        true,
+	"batch_upload_files": true,
+	"reserve_slot":       true,
+	"cancel_reservation": true,
+	"stop_sharing":       true,
+	"leave_group":        true,
+	"kick_user":          true,
+	"set_group_quota":    true,
+	"rename_group":       true,
+	"delete_group":       true,
+	"share_file":         true,
+	"update_file_chunks": true,
+	"set_mirrors":        true,
+	"add_moderator":      true,
+	"remove_moderator":   true,
+	"backup_now":         true,
+	"restore_backup":     true,
+}
+
+// AuditEntry is one line of the audit log.
+type AuditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Cmd       string    `json:"cmd"`
+	Args      []string  `json:"args"`
+	Tracker   string    `json:"tracker"` // address of the tracker that handled the command
+	Status    string    `json:"status"`
+}
+
+// AuditLog appends one JSON line per audited command. The file is rotated
+// when the day changes, and rotated files older than auditKeepDays are removed.
+type AuditLog struct {
+	mu      sync.Mutex
+	path    string
+	tracker string
+	now     func() time.Time
+	file    *os.File
+	day     string // day the open file belongs to
+}
+
+var trackerAudit = &AuditLog{path: defaultAuditLog, now: time.Now}
+
+// Configure sets the log path and this tracker's address, closing any open file.
+func (a *AuditLog) Configure(path, tracker string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+	a.path, a.tracker = path, tracker
+}
+
+// Record appends an entry for cmd if it is audited. Failures are logged, not returned,
+// so a full disk never blocks the command itself.
+func (a *AuditLog) Record(cmd string, args []string, status string) {
+	if !auditedCommands[cmd] {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	line, err := json.Marshal(AuditEntry{
+		Timestamp: now.UTC(),
+		Cmd:       cmd,
+		Args:      redactArgs(cmd, args),
+		Tracker:   a.tracker,
+		Status:    status,
+	})
+	if err != nil {
+		return
+	}
+	if err := a.openFor(now); err != nil {
+		fmt.Printf("Warning: audit log: %v\n", err)
+		return
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		fmt.Printf("Warning: audit log: %v\n", err)
+	}
+}
+
+// openFor makes sure the open file is the one for now's day, rotating the
+// previous day's file out of the way first. Caller must hold a.mu.
+func (a *AuditLog) openFor(now time.Time) error {
+	day := now.Format(auditDayFormat)
+	if a.file != nil && a.day == day {
+		return nil
+	}
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+
+	// A file left from an earlier day (possibly by a previous run) is rotated
+	if info, err := os.Stat(a.path); err == nil {
+		fileDay := a.day
+		if fileDay == "" {
+			fileDay = info.ModTime().Format(auditDayFormat)
+		}
+		if fileDay != day {
+			if err := os.Rename(a.path, a.path+"."+fileDay); err != nil {
+				return err
+			}
+		}
+	}
+	a.prune(now)
+
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	a.file, a.day = f, day
+	return nil
+}
+
+// prune removes rotated files from before the last auditKeepDays days.
+func (a *AuditLog) prune(now time.Time) {
+	cutoff := now.AddDate(0, 0, -(auditKeepDays - 1)).Format(auditDayFormat)
+	for day, path := range a.rotated() {
+		if day < cutoff {
+			os.Remove(path)
+		}
+	}
+}
+
+// rotated maps the day of each rotated file to its path.
+func (a *AuditLog) rotated() map[string]string {
+	matches, _ := filepath.Glob(a.path + ".*")
+	res := make(map[string]string, len(matches))
+	for _, m := range matches {
+		day := strings.TrimPrefix(m, a.path+".")
+		if _, err := time.Parse(auditDayFormat, day); err == nil {
+			res[day] = m
+		}
+	}
+	return res
+}
+
+// Tail returns the last n entries, reaching back into rotated files if needed.
+func (a *AuditLog) Tail(n int) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Oldest first: rotated files by day, then the current file
+	rotated := a.rotated()
+	days := make([]string, 0, len(rotated))
+	for day := range rotated {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	paths := make([]string, 0, len(days)+1)
+	for _, day := range days {
+		paths = append(paths, rotated[day])
+	}
+	paths = append(paths, a.path)
+
+	var entries []AuditEntry
+	for i := len(paths) - 1; i >= 0 && len(entries) < n; i-- {
+		fileEntries, err := readAuditFile(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(fileEntries, entries...)
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, nil
+}
+
+// readAuditFile parses every entry in one log file; a missing file has none.
+func readAuditFile(path string) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// redactArgs returns a copy of args with passwords, invite codes and
+// reservation tokens blanked out and upload_file's chunk list (which can
+// run to megabytes) reduced to a count, as are batch_upload_files' file
+// list and update_file_chunks' chunk list.
+func redactArgs(cmd string, args []string) []string {
+	out := append([]string(nil), args...)
+	switch cmd {
+	case "create_user", "login": // [user, password, ...]
+		if len(out) > 1 {
+			out[1] = redacted
+		}
+	case "create_group": // [groupID, userID, quota, inviteCode]
+		if len(out) > 3 && out[3] != "" {
+			out[3] = redacted
+		}
+	case "join_group": // [groupID, userID, inviteCode]
+		if len(out) > 2 && out[2] != "" {
+			out[2] = redacted
+		}
+	case "upload_file": // [fileName, groupID, userID, size, hash, chunksJSON, chunkSize, reservationToken]
+		if len(out) > 5 {
+			var chunks []json.RawMessage
+			json.Unmarshal([]byte(out[5]), &chunks)
+			out[5] = fmt.Sprintf("[%d chunks]", len(chunks))
+		}
+		if len(out) > 7 && out[7] != "" {
+			out[7] = redacted
+		}
+	case "batch_upload_files": // [userID, filesJSON]
+		if len(out) > 1 {
+			var batch []json.RawMessage
+			json.Unmarshal([]byte(out[1]), &batch)
+			out[1] = fmt.Sprintf("[%d files]", len(batch))
+		}
+	case "update_file_chunks": // [fileHash, userID, chunkSize, chunksJSON, groupID...]
+		if len(out) > 3 {
+			var chunks []json.RawMessage
+			json.Unmarshal([]byte(out[3]), &chunks)
+			out[3] = fmt.Sprintf("[%d chunks]", len(chunks))
+		}
+	case "import_group": // [ownerID, bundleJSON]
+		if len(out) > 1 {
+			var b GroupBundle
+			json.Unmarshal([]byte(out[1]), &b)
+			out[1] = fmt.Sprintf("[bundle of %s: %d members, %d files]", b.Group.GroupID, len(b.Group.Members), len(b.Files))
+		}
+	}
+	return out
+}
+
+// getAuditLog returns the last N audit entries. It is an admin command, only
+// answered for connections from the tracker's own host.
+// args: [N (optional, default 50)]
+func getAuditLog(args []string, remote net.Addr) Response {
+	if !isLoopback(remote) {
+		return Response{"error", "get_audit_log is only available from localhost"}
+	}
+	n := defaultAuditLines
+	if len(args) >= 1 && args[0] != "" {
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v < 1 {
+			return Response{"error", "get_audit_log: N must be a positive integer"}
+		}
+		n = v
+	}
+
+	entries, err := trackerAudit.Tail(n)
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	return Response{"ok", entries}
+}
+
+func isLoopback(addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parseAuditFlag removes --audit-log from args and returns its value
+// (defaultAuditLog if absent) and the remaining arguments.
+func parseAuditFlag(args []string) (string, []string, error) {
+	path := defaultAuditLog
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--audit-log" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("--audit-log requires a file path")
+			}
+			i++
+			value = args[i]
+		}
+		path = value
+	}
+	return path, rest, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestAuditLog points trackerAudit at a fresh file in a temp dir, which
+// also becomes the working directory so saved state lands there too.
+func useTestAuditLog(t *testing.T, tracker string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	path := filepath.Join(dir, "audit.log")
+	trackerAudit.Configure(path, tracker)
+	t.Cleanup(func() {
+		trackerAudit.Configure(defaultAuditLog, "")
+		trackerAudit.now = time.Now
+	})
+	return path
+}
+
+// startTestTracker serves handleConn on a random loopback port.
+func startTestTracker(t testing.TB) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go acceptConns(ln)
+	return ln.Addr().String()
+}
+
+func sendCmd(t testing.TB, addr, cmd string, args ...string) Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := common.Send(conn, Message{Cmd: cmd, Args: args}); err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	if err := common.Recv(conn, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// TestAuditLog_RecordsEachCommand runs every audited command through the
+// tracker and checks each one produced a log line with the right status.
+func TestAuditLog_RecordsEachCommand(t *testing.T) {
+	addr := startTestTracker(t)
+	path := useTestAuditLog(t, addr)
+	mu.Lock()
+	users = make(map[string]*User)
+	groups = make(map[string]*Group)
+	replaceFiles(make(map[string]*File))
+	mu.Unlock()
+
+	steps := []struct {
+		cmd    string
+		args   []string
+		status string
+	}{
+		{"create_user", []string{"alice", "s3cret"}, "ok"},
+		{"login", []string{"alice", "s3cret", ""}, "ok"},
+		{"create_group", []string{"g1", "alice"}, "ok"},
+		{"join_group", []string{"g1", "bob"}, "ok"},
+		{"accept_requests", []string{"g1", "alice", "bob"}, "ok"},
+		{"upload_file", []string{"a.txt", "g1", "alice", "10", "h", `[{"index":0,"hash":"x","size":10}]`}, "ok"},
+		{"stop_sharing", []string{"g1", "a.txt", "alice"}, "ok"},
+		{"leave_group", []string{"g1", "bob"}, "ok"},
+		{"kick_user", []string{"g1", "alice", "bob"}, "error"},
+		{"list_groups", nil, "ok"}, // read-only: not audited
+	}
+	for _, s := range steps {
+		if resp := sendCmd(t, addr, s.cmd, s.args...); resp.Status != s.status {
+			t.Fatalf("%s: got %+v, want status %s", s.cmd, resp, s.status)
+		}
+	}
+
+	entries, err := trackerAudit.Tail(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audited := steps[:len(steps)-1]
+	if len(entries) != len(audited) {
+		t.Fatalf("got %d audit entries, want %d", len(entries), len(audited))
+	}
+	for i, s := range audited {
+		e := entries[i]
+		if e.Cmd != s.cmd || e.Status != s.status || e.Tracker != addr || e.Timestamp.IsZero() {
+			t.Errorf("entry %d = %+v, want %s/%s from %s", i, e, s.cmd, s.status, addr)
+		}
+	}
+	if entries[5].Args[5] != "[1 chunks]" {
+		t.Errorf("upload_file chunk list logged as %q", entries[5].Args[5])
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Error("password written to the audit log")
+	}
+	if strings.Count(string(data), redacted) != 2 {
+		t.Errorf("expected create_user and login to be redacted:\n%s", data)
+	}
+}
+
+// TestAuditLog_RotatesDaily writes on several days and checks old files are
+// rotated and anything beyond seven days is deleted.
+func TestAuditLog_RotatesDaily(t *testing.T) {
+	path := useTestAuditLog(t, ":9000")
+	day := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	trackerAudit.now = func() time.Time { return day }
+
+	for i := 0; i < 10; i++ {
+		trackerAudit.Record("create_group", []string{"g", "alice"}, "ok")
+		day = day.AddDate(0, 0, 1)
+	}
+	// day is now 2025-03-11; the last write was on 2025-03-10
+	trackerAudit.Record("create_group", []string{"g", "alice"}, "ok")
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("current log missing: %v", err)
+	}
+	for d := 1; d <= 10; d++ {
+		rotated := path + "." + time.Date(2025, 3, d, 0, 0, 0, 0, time.UTC).Format(auditDayFormat)
+		_, err := os.Stat(rotated)
+		kept := d >= 5 // 2025-03-05 .. 2025-03-10 plus today's file = 7 days
+		if kept && err != nil {
+			t.Errorf("rotated log for March %d should be kept: %v", d, err)
+		}
+		if !kept && err == nil {
+			t.Errorf("rotated log for March %d should have been removed", d)
+		}
+	}
+
+	// Tail reaches back across rotated files
+	entries, err := trackerAudit.Tail(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[2].Timestamp.Day() != 11 || entries[0].Timestamp.Day() != 9 {
+		t.Errorf("tail = %+v", entries)
+	}
+}
+
+// TestGetAuditLog_LocalhostOnly verifies the admin command is refused remotely
+// and returns the last N entries locally.
+func TestGetAuditLog_LocalhostOnly(t *testing.T) {
+	useTestAuditLog(t, ":9000")
+	for _, g := range []string{"g1", "g2", "g3"} {
+		trackerAudit.Record("create_group", []string{g, "alice"}, "ok")
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 5000}
+	if resp := getAuditLog(nil, remote); resp.Status != "error" {
+		t.Errorf("remote caller got the audit log: %+v", resp)
+	}
+
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	resp := getAuditLog([]string{"2"}, local)
+	if resp.Status != "ok" {
+		t.Fatalf("get_audit_log: %+v", resp)
+	}
+	entries := resp.Data.([]AuditEntry)
+	if len(entries) != 2 || entries[0].Args[0] != "g2" || entries[1].Args[0] != "g3" {
+		t.Errorf("entries = %+v, want g2 and g3", entries)
+	}
+	if resp := getAuditLog([]string{"0"}, local); resp.Status != "error" {
+		t.Errorf("N=0 accepted: %+v", resp)
+	}
+}
+
+func TestParseAuditFlag(t *testing.T) {
+	path, rest, err := parseAuditFlag([]string{"--audit-log", "/var/log/a.log", "cfg.txt", "1"})
+	if err != nil || path != "/var/log/a.log" || len(rest) != 2 {
+		t.Errorf("got %q %v %v", path, rest, err)
+	}
+	path, _, _ = parseAuditFlag([]string{"--audit-log=x.log"})
+	if path != "x.log" {
+		t.Errorf("--audit-log=x.log gave %q", path)
+	}
+	path, _, _ = parseAuditFlag(nil)
+	if path != defaultAuditLog {
+		t.Errorf("default path = %q", path)
+	}
+	if _, _, err := parseAuditFlag([]string{"--audit-log"}); err == nil {
+		t.Error("missing value accepted")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// availabilityInterval is how often every file's owners are probed.
+const availabilityInterval = 5 * time.Minute
+
+// probeSeeder reports whether a peer server answers at addr. A variable
+// so tests can decide who is reachable.
+var probeSeeder = func(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, seederProbeTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// runAvailabilityMonitor checks file availability every interval.
+func runAvailabilityMonitor(interval time.Duration) {
+	for range time.Tick(interval) {
+		if lost := checkAvailability(); len(lost) > 0 {
+			fmt.Printf("Warning: %d files have no reachable seeders: %v\n", len(lost), lost)
+		}
+	}
+}
+
+// checkAvailability probes the owners of every file and flags the files
+// none of them can serve, publishing EventFileUnavailable for each one
+// that has just become unavailable. Files whose owners answer again are
+// cleared. It returns the keys of the newly unavailable files.
+func checkAvailability() []string {
+	// Owners' addresses are collected under the lock and dialled without it
+	mu.RLock()
+	owners := make(map[string][]string, len(files))
+	addrs := make(map[string]bool)
+	for key, f := range withoutCanary(files) {
+		if f.isReserved() {
+			continue // nothing to serve until the upload arrives
+		}
+		for userID := range f.Owners {
+			if u, ok := users[userID]; ok && u.LoggedIn && u.Addr != "" {
+				owners[key] = append(owners[key], u.Addr)
+				addrs[u.Addr] = true
+			}
+		}
+		if _, ok := owners[key]; !ok {
+			owners[key] = nil
+		}
+	}
+	mu.RUnlock()
+
+	var rmu sync.Mutex
+	var wg sync.WaitGroup
+	reachable := make(map[string]bool, len(addrs))
+	for addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok := probeSeeder(addr)
+			rmu.Lock()
+			reachable[addr] = ok
+			rmu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var lost []string
+	for key, list := range owners {
+		f, ok := files[key]
+		if !ok {
+			continue // removed while we were probing
+		}
+		available := false
+		for _, addr := range list {
+			available = available || reachable[addr]
+		}
+		switch {
+		case !available && !f.Unavailable:
+			f.Unavailable = true
+			lost = append(lost, key)
+			go trackerEvents.Publish(EventFileUnavailable, Message{Cmd: "file_unavailable", Args: []string{f.GroupID, f.FileName}})
+		case available && f.Unavailable:
+			f.Unavailable = false
+		}
+	}
+	if len(lost) > 0 {
+		go SaveState()
+	}
+	return lost
+}
+
+// clearUnavailable clears the flag on every file userID owns, now that
+// they are back to seed it. Caller must hold mu.
+func clearUnavailable(userID string) {
+	for _, f := range files {
+		if f.Unavailable && f.Owners[userID] {
+			f.Unavailable = false
+		}
+	}
+}
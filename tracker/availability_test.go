@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// useReachable makes probeSeeder answer from up for the test.
+func useReachable(t *testing.T, up map[string]bool) *sync.Mutex {
+	var upMu sync.Mutex
+	saved := probeSeeder
+	probeSeeder = func(addr string) bool {
+		upMu.Lock()
+		defer upMu.Unlock()
+		return up[addr]
+	}
+	t.Cleanup(func() { probeSeeder = saved })
+	return &upMu
+}
+
+// unavailableAlerts collects the file_unavailable events published for g1.
+func unavailableAlerts(t *testing.T) <-chan []string {
+	alerts := make(chan []string, 16)
+	trackerEvents.Subscribe(EventFileUnavailable, func(msg Message) {
+		if msg.Args[0] == "g1" {
+			select {
+			case alerts <- msg.Args:
+			default:
+			}
+		}
+	})
+	return alerts
+}
+
+// TestCheckAvailability_AllSeedersOffline takes a file's seeders offline
+// one by one and checks the alert fires once, only when the last goes, and
+// that a seeder logging back in clears the flag.
+func TestCheckAvailability_AllSeedersOffline(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice", "bob")
+	seedFiles(t, "a.txt")
+	mu.Lock()
+	users = map[string]*User{
+		"alice": {UserID: "alice", Password: "pw", LoggedIn: true, Addr: "127.0.0.1:6001"},
+		"bob":   {UserID: "bob", Password: "pw", LoggedIn: true, Addr: "127.0.0.1:6002"},
+	}
+	files["g1:a.txt"].Owners["bob"] = true
+	mu.Unlock()
+	up := map[string]bool{"127.0.0.1:6001": true, "127.0.0.1:6002": true}
+	upMu := useReachable(t, up)
+	alerts := unavailableAlerts(t)
+
+	unavailable := func() bool {
+		mu.RLock()
+		defer mu.RUnlock()
+		return files["g1:a.txt"].Unavailable
+	}
+
+	// alice's peer stops answering, but bob can still serve the file
+	upMu.Lock()
+	up["127.0.0.1:6001"] = false
+	upMu.Unlock()
+	if lost := checkAvailability(); len(lost) != 0 || unavailable() {
+		t.Fatalf("file lost with bob still online: %v", lost)
+	}
+
+	// bob logs out: nobody is left
+	mu.Lock()
+	users["bob"].LoggedIn = false
+	mu.Unlock()
+	if lost := checkAvailability(); len(lost) != 1 || lost[0] != "g1:a.txt" || !unavailable() {
+		t.Fatalf("lost = %v, unavailable = %v", lost, unavailable())
+	}
+	select {
+	case args := <-alerts:
+		if args[1] != "a.txt" {
+			t.Errorf("alert for %v", args)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no file_unavailable event")
+	}
+	entry := listFiles([]string{"g1", "alice"}).Data.([]map[string]interface{})[0]
+	if entry["unavailable"] != true {
+		t.Errorf("list_files entry = %v", entry)
+	}
+
+	// Still down on the next run: no second alert
+	if lost := checkAvailability(); len(lost) != 0 {
+		t.Errorf("alerted again: %v", lost)
+	}
+
+	if resp := login([]string{"bob", "pw", "127.0.0.1:6002"}); resp.Status != "ok" {
+		t.Fatalf("login: %+v", resp)
+	}
+	if unavailable() {
+		t.Error("flag not cleared when bob logged back in")
+	}
+}
+
+// TestAddSeeder_ClearsUnavailable checks a new seeder clears the flag.
+func TestAddSeeder_ClearsUnavailable(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice", "bob")
+	seedFiles(t, "a.txt")
+	mu.Lock()
+	files["g1:a.txt"].Unavailable = true
+	mu.Unlock()
+
+	if resp := addSeeder([]string{"g1", "a.txt", "bob"}); resp.Status != "ok" {
+		t.Fatalf("add_seeder: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if files["g1:a.txt"].Unavailable {
+		t.Error("flag not cleared by add_seeder")
+	}
+}
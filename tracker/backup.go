@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBackupPath = "backups"
+	backupPrefix      = "tracker_state_"
+	backupSuffix      = ".json.gz"
+	backupTimeFormat  = "20060102T150405Z"
+)
+
+// backupPath is where backups go: a local directory, or an http(s) URL
+// that each backup is PUT under (an S3-compatible bucket with a
+// pre-authorised path, for example).
+var backupPath = defaultBackupPath
+
+var backupHTTP = &http.Client{Timeout: 30 * time.Second}
+
+func isRemoteBackupPath(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// backupName names a backup taken at t.
+func backupName(t time.Time) string {
+	return backupPrefix + t.UTC().Format(backupTimeFormat) + backupSuffix
+}
+
+// encodeBackup returns state as gzip-compressed JSON.
+func encodeBackup(state TrackerState) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(state); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBackup reverses encodeBackup.
+func decodeBackup(data []byte) (TrackerState, error) {
+	var state TrackerState
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return state, fmt.Errorf("not a gzip backup: %v", err)
+	}
+	defer zr.Close()
+	if err := json.NewDecoder(zr).Decode(&state); err != nil {
+		return state, fmt.Errorf("invalid backup: %v", err)
+	}
+	return state, nil
+}
+
+// encodeCurrentState encodes the persistent state as it is now.
+func encodeCurrentState() ([]byte, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return encodeBackup(TrackerState{
+		Users:      withoutCanary(users),
+		Groups:     withoutCanary(groups),
+		Files:      withoutCanary(files),
+		Tombstones: tombstones,
+	})
+}
+
+// writeBackup stores data as name under dest and returns where it went.
+func writeBackup(dest, name string, data []byte) (string, error) {
+	if isRemoteBackupPath(dest) {
+		url := strings.TrimSuffix(dest, "/") + "/" + name
+		req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/gzip")
+		resp, err := backupHTTP.Do(req)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return "", fmt.Errorf("PUT %s: %s", url, resp.Status)
+		}
+		return url, nil
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dest, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// readBackup fetches a backup from a file or http(s) URL. A local
+// directory means the newest backup in it.
+func readBackup(src string) ([]byte, string, error) {
+	if isRemoteBackupPath(src) {
+		resp, err := backupHTTP.Get(src)
+		if err != nil {
+			return nil, src, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, src, fmt.Errorf("GET %s: %s", src, resp.Status)
+		}
+		data, err := io.ReadAll(resp.Body)
+		return data, src, err
+	}
+
+	if info, err := os.Stat(src); err == nil && info.IsDir() {
+		latest, err := latestBackup(src)
+		if err != nil {
+			return nil, src, err
+		}
+		src = latest
+	}
+	data, err := os.ReadFile(src)
+	return data, src, err
+}
+
+// latestBackup returns the newest backup file in dir. Backup names sort
+// in time order.
+func latestBackup(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), backupPrefix) && strings.HasSuffix(e.Name(), backupSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no backups in %s", dir)
+	}
+	sort.Strings(names)
+	return filepath.Join(dir, names[len(names)-1]), nil
+}
+
+// takeBackup writes a backup of the current state to backupPath.
+func takeBackup(now time.Time) (string, int, error) {
+	data, err := encodeCurrentState()
+	if err != nil {
+		return "", 0, err
+	}
+	location, err := writeBackup(backupPath, backupName(now), data)
+	return location, len(data), err
+}
+
+// runBackups takes a backup every interval until the process exits.
+func runBackups(interval time.Duration) {
+	for range time.Tick(interval) {
+		if location, _, err := takeBackup(time.Now()); err != nil {
+			fmt.Printf("Warning: scheduled backup failed: %v\n", err)
+		} else {
+			fmt.Printf("Backup written to %s\n", location)
+		}
+	}
+}
+
+// mergeBackup adds the backup's entries to the running state. An entry
+// replaces the local one only if its version is newer, so restoring never
+// undoes later changes. Files deleted after the backup was taken stay
+// deleted. Restored users start logged out. Caller must hold mu.
+func mergeBackup(state TrackerState) (restoredUsers, restoredGroups, restoredFiles int) {
+	for id, u := range state.Users {
+		local, ok := users[id]
+		if ok && local.Version >= u.Version {
+			continue
+		}
+		if ok {
+			local.Password, local.Version = u.Password, u.Version
+		} else {
+			users[id] = &User{UserID: u.UserID, Password: u.Password, Version: u.Version}
+		}
+		restoredUsers++
+	}
+	for id, g := range state.Groups {
+		local, ok := groups[id]
+		if ok && local.Version >= g.Version {
+			continue
+		}
+		if ok {
+			g.PendingHistory = local.PendingHistory
+		}
+		groups[id] = g
+		restoredGroups++
+	}
+	for key, f := range state.Files {
+		if local, ok := files[key]; ok && local.Version >= f.Version {
+			continue
+		}
+		if t, ok := tombstones[key]; ok && t.DeletedAt.After(f.UpdatedAt) {
+			continue
+		}
+		if f.Owners == nil {
+			f.Owners = make(map[string]bool)
+		}
+		putFile(key, f)
+		delete(tombstones, key)
+		restoredFiles++
+	}
+	return
+}
+
+// backupNow writes a backup immediately. It is an admin command, only
+// answered for connections from the tracker's own host.
+func backupNow(args []string, remote net.Addr) Response {
+	if !isLoopback(remote) {
+		return Response{"error", "backup_now is only available from localhost"}
+	}
+	location, size, err := takeBackup(time.Now())
+	if err != nil {
+		return Response{"error", fmt.Sprintf("backup failed: %v", err)}
+	}
+	fmt.Printf("Backup written to %s\n", location)
+	return Response{"ok", map[string]interface{}{"location": location, "bytes": size}}
+}
+
+// restoreBackup merges a backup into the running state. It is an admin
+// command, only answered for connections from the tracker's own host.
+// args: [path (optional; a backup file, a directory to take the newest
+// from, or an http(s) URL; defaults to the backup directory)]
+func restoreBackup(args []string, remote net.Addr) Response {
+	if !isLoopback(remote) {
+		return Response{"error", "restore_backup is only available from localhost"}
+	}
+	src := backupPath
+	if len(args) >= 1 && args[0] != "" {
+		src = args[0]
+	} else if isRemoteBackupPath(src) {
+		return Response{"error", "restore_backup: give the URL of the backup to restore"}
+	}
+	data, src, err := readBackup(src)
+	if err != nil {
+		return Response{"error", fmt.Sprintf("reading backup: %v", err)}
+	}
+	state, err := decodeBackup(data)
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+
+	mu.Lock()
+	u, g, f := mergeBackup(state)
+	mu.Unlock()
+	go SaveState()
+	fmt.Printf("Restored %d users, %d groups and %d files from %s\n", u, g, f, src)
+	return Response{"ok", map[string]interface{}{"source": src, "users": u, "groups": g, "files": f}}
+}
+
+// parseBackupFlags removes --backup-interval and --backup-path from args.
+// The interval is 0 (no scheduled backups) if absent.
+func parseBackupFlags(args []string) (time.Duration, string, []string, error) {
+	var interval time.Duration
+	path := defaultBackupPath
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--backup-interval" && name != "--backup-path" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return 0, "", nil, fmt.Errorf("%s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "--backup-path" {
+			path = value
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return 0, "", nil, fmt.Errorf("--backup-interval: invalid interval %q", value)
+		}
+		interval = d
+	}
+	return interval, path, rest, nil
+}
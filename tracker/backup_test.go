@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var localAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}
+
+func TestBackup_RoundTrip(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	state := TrackerState{
+		Users:  map[string]*User{"alice": {UserID: "alice", Password: "pw", Version: 2}},
+		Groups: map[string]*Group{"g1": {GroupID: "g1", Owner: "alice", Members: map[string]bool{"alice": true}, Version: 3}},
+		Files: map[string]*File{"g1:a.txt": {FileName: "a.txt", GroupID: "g1", FileHash: "h",
+			Owners: map[string]bool{"alice": true}, Version: 4, UpdatedAt: now}},
+	}
+	data, err := encodeBackup(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0x1f || data[1] != 0x8b {
+		t.Fatal("backup is not gzip")
+	}
+	got, err := decodeBackup(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Users["alice"].Password != "pw" || got.Groups["g1"].Version != 3 || !got.Files["g1:a.txt"].UpdatedAt.Equal(now) {
+		t.Errorf("round trip lost data: %+v", got)
+	}
+	if _, err := decodeBackup([]byte("{}")); err == nil {
+		t.Error("decoded a backup that isn't gzip")
+	}
+	if name := backupName(now); name != "tracker_state_20260301T120000Z.json.gz" {
+		t.Errorf("backupName = %s", name)
+	}
+}
+
+// TestBackup_NowAndRestore backs up to a local directory, changes the
+// state and restores the newest backup from the directory.
+func TestBackup_NowAndRestore(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice")
+	saved := backupPath
+	backupPath = t.TempDir()
+	t.Cleanup(func() { backupPath = saved })
+	if resp := uploadFile([]string{"a.txt", "g1", "alice", "10", "hash-a", "[]"}); resp.Status != "ok" {
+		t.Fatalf("upload: %+v", resp)
+	}
+
+	if resp := backupNow(nil, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1)}); resp.Status != "error" {
+		t.Fatalf("backup_now from a remote host: %+v", resp)
+	}
+	resp := backupNow(nil, localAddr)
+	if resp.Status != "ok" {
+		t.Fatalf("backup_now: %+v", resp)
+	}
+	location := resp.Data.(map[string]interface{})["location"].(string)
+	if filepath.Dir(location) != backupPath || !strings.HasPrefix(filepath.Base(location), backupPrefix) {
+		t.Fatalf("backup written to %s", location)
+	}
+
+	// Lose the group and file, then restore them
+	mu.Lock()
+	delete(groups, "g1")
+	replaceFiles(make(map[string]*File))
+	mu.Unlock()
+	resp = restoreBackup(nil, localAddr)
+	if resp.Status != "ok" {
+		t.Fatalf("restore_backup: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if groups["g1"] == nil || files["g1:a.txt"] == nil || files["g1:a.txt"].FileHash != "hash-a" {
+		t.Errorf("state not restored: groups %v, files %v", groups, files)
+	}
+}
+
+// TestMergeBackup checks newer local entries and later deletions win.
+func TestMergeBackup(t *testing.T) {
+	resetGroupState(t, "alice")
+	old := time.Now().Add(-time.Hour)
+	mu.Lock()
+	defer mu.Unlock()
+	users = map[string]*User{"alice": {UserID: "alice", Password: "new", Version: 5, LoggedIn: true}}
+	groups["g1"].Version = 5
+	putFile("g1:kept.txt", &File{FileName: "kept.txt", GroupID: "g1", FileHash: "local", Version: 5})
+	tombstones["g1:deleted.txt"] = &Tombstone{GroupID: "g1", FileName: "deleted.txt", DeletedAt: time.Now()}
+
+	u, g, f := mergeBackup(TrackerState{
+		Users: map[string]*User{
+			"alice": {UserID: "alice", Password: "old", Version: 1},
+			"bob":   {UserID: "bob", Password: "pw", Version: 1, LoggedIn: true, Addr: "1.2.3.4:5"},
+		},
+		Groups: map[string]*Group{
+			"g1": {GroupID: "g1", Owner: "zed", Version: 1},
+			"g2": {GroupID: "g2", Owner: "bob", Members: map[string]bool{"bob": true}, Version: 1},
+		},
+		Files: map[string]*File{
+			"g1:kept.txt":    {FileName: "kept.txt", GroupID: "g1", FileHash: "backup", Version: 2},
+			"g1:deleted.txt": {FileName: "deleted.txt", GroupID: "g1", Version: 1, UpdatedAt: old},
+			"g2:new.txt":     {FileName: "new.txt", GroupID: "g2", Version: 1},
+		},
+	})
+	if u != 1 || g != 1 || f != 1 {
+		t.Errorf("restored %d users, %d groups, %d files; want 1 of each", u, g, f)
+	}
+	if users["alice"].Password != "new" || groups["g1"].Owner != "alice" || files["g1:kept.txt"].FileHash != "local" {
+		t.Error("backup overwrote newer local state")
+	}
+	if bob := users["bob"]; bob == nil || bob.LoggedIn || bob.Addr != "" {
+		t.Errorf("restored user = %+v, want logged out", bob)
+	}
+	if _, ok := files["g1:deleted.txt"]; ok {
+		t.Error("file deleted after the backup was restored")
+	}
+	if files["g2:new.txt"] == nil || groups["g2"] == nil {
+		t.Error("missing entries not restored")
+	}
+}
+
+// TestBackup_HTTPPut sends a backup to an HTTP endpoint and restores it
+// from the URL it was stored under.
+func TestBackup_HTTPPut(t *testing.T) {
+	var mu sync.Mutex
+	stored := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			stored[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			data, ok := stored[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		}
+	}))
+	defer srv.Close()
+
+	data, _ := encodeBackup(TrackerState{Users: map[string]*User{"carol": {UserID: "carol", Version: 1}}})
+	url, err := writeBackup(srv.URL+"/bucket/", "b.json.gz", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != srv.URL+"/bucket/b.json.gz" {
+		t.Errorf("url = %s", url)
+	}
+	got, _, err := readBackup(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, err := decodeBackup(got); err != nil || state.Users["carol"] == nil {
+		t.Errorf("fetched backup = %+v, %v", state, err)
+	}
+	if _, _, err := readBackup(srv.URL + "/bucket/missing"); err == nil {
+		t.Error("expected an error for a missing backup")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// maxBatchUpload caps the files in one batch_upload_files request.
+const maxBatchUpload = 1000
+
+// uploadDescriptor is one file of a batch_upload_files request, with the
+// same fields upload_file takes as args.
+type uploadDescriptor struct {
+	FileName  string  `json:"file_name"`
+	GroupID   string  `json:"group_id"`
+	FileSize  int64   `json:"file_size"`
+	FileHash  string  `json:"file_hash"`
+	Chunks    []Chunk `json:"chunks"`
+	ChunkSize int64   `json:"chunk_size,omitempty"`
+}
+
+// parseBatch decodes a batch's files, uploaded by userID.
+func parseBatch(userID, filesJSON string) ([]*File, error) {
+	var descs []uploadDescriptor
+	if err := json.Unmarshal([]byte(filesJSON), &descs); err != nil {
+		return nil, fmt.Errorf("invalid file list: %v", err)
+	}
+	if len(descs) == 0 || len(descs) > maxBatchUpload {
+		return nil, fmt.Errorf("a batch holds 1 to %d files, got %d", maxBatchUpload, len(descs))
+	}
+	batch := make([]*File, 0, len(descs))
+	for i, d := range descs {
+		if d.FileName == "" || d.GroupID == "" || d.FileSize < 0 || d.ChunkSize < 0 {
+			return nil, fmt.Errorf("file %d: need file_name, group_id and a valid size", i+1)
+		}
+		if d.ChunkSize == 0 {
+			d.ChunkSize = defaultChunkSize
+		}
+		batch = append(batch, &File{
+			FileName:    d.FileName,
+			GroupID:     d.GroupID,
+			Uploader:    userID,
+			FileSize:    d.FileSize,
+			FileHash:    d.FileHash,
+			ChunkSize:   d.ChunkSize,
+			TotalChunks: len(d.Chunks),
+			Chunks:      d.Chunks,
+		})
+	}
+	return batch, nil
+}
+
+// batchUploadFiles adds several files in one request, all or none: if any
+// file can't be uploaded, e.g. because its name is taken, nothing is
+// stored. Peers get the whole batch as one sync_batch_upload message.
+// args: [userID, filesJSON]
+func batchUploadFiles(args []string) Response {
+	userID := args[0]
+	batch, err := parseBatch(userID, args[1])
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Check every file before storing any, counting the batch's own bytes
+	// against each group's quota
+	listed := make(map[string]bool)
+	pending := make(map[string]int64)
+	targets := make([]*Group, len(batch))
+	for i, f := range batch {
+		key := f.GroupID + ":" + f.FileName
+		if listed[key] {
+			return Response{"error", fmt.Sprintf("%s: listed twice in the batch", key)}
+		}
+		listed[key] = true
+		g, err := checkUpload(f, pending[f.GroupID])
+		if err != nil {
+			return Response{"error", fmt.Sprintf("%s: %v; no files were uploaded", key, err)}
+		}
+		pending[f.GroupID] += f.FileSize
+		targets[i] = g
+	}
+
+	uploaded := make([]map[string]interface{}, 0, len(batch))
+	for i, f := range batch {
+		deduped := addUpload(f)
+		recordDedup(len(f.Chunks), len(deduped))
+		entry := map[string]interface{}{"file_name": f.FileName, "group_id": f.GroupID, "total_chunks": f.TotalChunks,
+			"deduplicated_chunks": deduped}
+		if mirrored := mirrorUpload(targets[i], f, userID); len(mirrored) > 0 {
+			entry["mirrored_to"] = mirrored
+		}
+		uploaded = append(uploaded, entry)
+	}
+
+	go trackerEvents.Publish(EventBatchUploaded, Message{Cmd: "sync_batch_upload", Args: args})
+	go SaveState()
+
+	return Response{"ok", map[string]interface{}{
+		"message": strconv.Itoa(len(batch)) + " files uploaded",
+		"files":   uploaded,
+	}}
+}
+
+// applyBatchSync stores a batch another tracker accepted. Files we already
+// have are skipped rather than failing the rest, as the other tracker
+// checked the batch as a whole already.
+func applyBatchSync(args []string) Response {
+	if len(args) < 2 {
+		return Response{"error", "sync_batch_upload: need userID, filesJSON"}
+	}
+	batch, err := parseBatch(args[0], args[1])
+	if err != nil {
+		return Response{"error", "sync_batch_upload: " + err.Error()}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	stored := 0
+	for _, f := range batch {
+		if _, err := checkUpload(f, 0); err != nil {
+			fmt.Printf("[sync] batch upload of %s/%s skipped: %v\n", f.GroupID, f.FileName, err)
+			continue
+		}
+		addUpload(f)
+		stored++
+	}
+	go SaveState()
+	fmt.Printf("[sync] batch upload: stored %d of %d files\n", stored, len(batch))
+	return Response{"ok", "synced"}
+}
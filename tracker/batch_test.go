@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// batchJSON encodes a batch of 10-byte files in g1.
+func batchJSON(t testing.TB, names ...string) string {
+	t.Helper()
+	descs := make([]uploadDescriptor, len(names))
+	for i, name := range names {
+		descs[i] = uploadDescriptor{FileName: name, GroupID: "g1", FileSize: 10, FileHash: "hash-" + name,
+			Chunks: []Chunk{{Index: 0, Hash: "c-" + name, Size: 10}}}
+	}
+	data, err := json.Marshal(descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBatchUploadFiles_StoresAll(t *testing.T) {
+	resetGroupState(t, "alice")
+
+	resp := batchUploadFiles([]string{"alice", batchJSON(t, "a.txt", "b.txt", "c.txt")})
+	if resp.Status != "ok" {
+		t.Fatalf("batch_upload_files: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if n := len(groupFiles("g1")); n != 3 {
+		t.Fatalf("group has %d files, want 3", n)
+	}
+	f := files["g1:b.txt"]
+	if f.Uploader != "alice" || !f.Owners["alice"] || f.TotalChunks != 1 || f.ChunkSize != defaultChunkSize || f.CreatedAt.IsZero() {
+		t.Errorf("stored file = %+v", f)
+	}
+}
+
+// TestBatchUploadFiles_AllOrNothing checks one bad file in a batch keeps
+// every other file in it from being stored.
+func TestBatchUploadFiles_AllOrNothing(t *testing.T) {
+	resetGroupState(t, "alice")
+	seedFiles(t, "taken.txt")
+	mu.Lock()
+	groups["g1"].StorageQuota = 45 // taken.txt uses 10
+	mu.Unlock()
+
+	for name, batch := range map[string]string{
+		"existing file": batchJSON(t, "a.txt", "taken.txt"),
+		"listed twice":  batchJSON(t, "a.txt", "a.txt"),
+		"over quota":    batchJSON(t, "a.txt", "b.txt", "c.txt", "d.txt"),
+		"no group":      `[{"file_name":"a.txt","group_id":"nope","file_size":1}]`,
+		"empty":         `[]`,
+		"not json":      `a.txt`,
+	} {
+		if resp := batchUploadFiles([]string{"alice", batch}); resp.Status != "error" {
+			t.Errorf("%s: %+v", name, resp)
+		}
+	}
+	if resp := batchUploadFiles([]string{"mallory", batchJSON(t, "a.txt")}); resp.Status != "error" {
+		t.Errorf("non-member: %+v", resp)
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if n := len(groupFiles("g1")); n != 1 {
+		t.Errorf("group has %d files after failed batches, want only taken.txt", n)
+	}
+}
+
+// TestApplyBatchSync stores a peer's batch, skipping a file we already
+// have rather than dropping the rest.
+func TestApplyBatchSync(t *testing.T) {
+	resetGroupState(t, "alice")
+	seedFiles(t, "b.txt")
+
+	if resp := applySync(Message{Cmd: "sync_batch_upload", Args: []string{"alice", batchJSON(t, "a.txt", "b.txt", "c.txt")}}); resp.Status != "ok" {
+		t.Fatalf("sync_batch_upload: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if n := len(groupFiles("g1")); n != 3 {
+		t.Errorf("group has %d files, want 3", n)
+	}
+	if files["g1:b.txt"].FileHash != "hash-b.txt" {
+		t.Error("existing file replaced")
+	}
+}
+
+// benchUploadTracker starts a tracker with alice in g1 for a benchmark.
+func benchUploadTracker(b *testing.B) string {
+	b.Chdir(b.TempDir()) // SaveState writes here
+	resetGroupState(b, "alice")
+	return startTestTracker(b)
+}
+
+// BenchmarkUpload10_Individual registers ten files with one upload_file
+// round trip each.
+func BenchmarkUpload10_Individual(b *testing.B) {
+	addr := benchUploadTracker(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 10; j++ {
+			name := fmt.Sprintf("f%d-%d", i, j)
+			if resp := sendCmd(b, addr, "upload_file", name, "g1", "alice", "10", "h", "[]"); resp.Status != "ok" {
+				b.Fatalf("upload_file: %+v", resp)
+			}
+		}
+	}
+}
+
+// BenchmarkUpload10_Batch registers the same ten files with one
+// batch_upload_files round trip.
+func BenchmarkUpload10_Batch(b *testing.B) {
+	addr := benchUploadTracker(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		names := make([]string, 10)
+		for j := range names {
+			names[j] = fmt.Sprintf("f%d-%d", i, j)
+		}
+		if resp := sendCmd(b, addr, "batch_upload_files", "alice", batchJSON(b, names...)); resp.Status != "ok" {
+			b.Fatalf("batch_upload_files: %+v", resp)
+		}
+	}
+}
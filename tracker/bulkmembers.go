@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BulkAddResult sorts the users given to bulk_add_members.
+type BulkAddResult struct {
+	Added          []string `json:"added"`
+	AlreadyMembers []string `json:"already_members"`
+	NotFound       []string `json:"not_found"`
+}
+
+// bulkAddMembers adds registered users to a group at once, for moving a
+// group's members over from another system. Either all of them are
+// added or, if any user isn't registered, none are. Pending join
+// requests of the users added are accepted. Only the owner may add.
+// args: [groupID, ownerID, userID...]
+func bulkAddMembers(args []string) Response {
+	groupID, owner := args[0], args[1]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if g.Owner != owner {
+		return Response{"error", "not owner"}
+	}
+
+	result := BulkAddResult{Added: []string{}, AlreadyMembers: []string{}, NotFound: []string{}}
+	var toAdd []string
+	seen := make(map[string]bool)
+	for _, userID := range args[2:] {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		switch _, registered := users[userID]; {
+		case !registered || isCanaryKey(userID):
+			result.NotFound = append(result.NotFound, userID)
+		case g.Members[userID]:
+			result.AlreadyMembers = append(result.AlreadyMembers, userID)
+		default:
+			toAdd = append(toAdd, userID)
+		}
+	}
+	if len(result.NotFound) > 0 {
+		fmt.Printf("Bulk add to group %s refused: %s not registered\n", groupID, strings.Join(result.NotFound, ", "))
+		return Response{"error", result}
+	}
+	if len(toAdd) == 0 {
+		return Response{"ok", result}
+	}
+
+	now := time.Now()
+	for _, userID := range toAdd {
+		commitAccept(g, userID, now)
+	}
+	result.Added = toAdd
+	g.Version++
+	fmt.Printf("Added %d members to group %s\n", len(toAdd), groupID)
+	go SaveState()
+	go trackerEvents.Publish(EventMembersAdded, groupSync(g, "sync_bulk_add_members", append([]string{groupID}, toAdd...)))
+	return Response{"ok", result}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestBulkAddMembers_AllOrNone checks one unregistered user keeps every
+// user out of the group, and the reply says which one.
+func TestBulkAddMembers_AllOrNone(t *testing.T) {
+	resetGroupState(t, "alice")
+	seedUsers("alice", "bob", "carol")
+	mu.Lock()
+	groups["g1"].Pending["carol"] = true
+	version := groups["g1"].Version
+	mu.Unlock()
+
+	resp := bulkAddMembers([]string{"g1", "alice", "bob", "zed", "carol", "alice", "yves"})
+	want := BulkAddResult{Added: []string{}, AlreadyMembers: []string{"alice"}, NotFound: []string{"zed", "yves"}}
+	if resp.Status != "error" || !reflect.DeepEqual(resp.Data, want) {
+		t.Fatalf("bulk_add_members = %+v, want error %+v", resp, want)
+	}
+	mu.RLock()
+	g := groups["g1"]
+	if len(g.Members) != 1 || !g.Pending["carol"] || g.Version != version {
+		t.Errorf("group changed: members %v, pending %v, version %d", g.Members, g.Pending, g.Version)
+	}
+	mu.RUnlock()
+
+	if resp := bulkAddMembers([]string{"g1", "bob", "carol"}); resp.Status != "error" || resp.Data != "not owner" {
+		t.Errorf("bulk_add_members by a non-owner: %+v", resp)
+	}
+	if resp := bulkAddMembers([]string{"g9", "alice", "bob"}); resp.Status != "error" {
+		t.Errorf("bulk_add_members to a missing group: %+v", resp)
+	}
+}
+
+// TestBulkAddMembers_Summary adds new, existing, pending and repeated
+// users and checks the reply sorts them, pending requests are accepted,
+// and one sync message carries the change to a peer tracker.
+func TestBulkAddMembers_Summary(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedUsers("alice", "bob", "carol", "dave", "erin")
+	mu.Lock()
+	groups["g1"].Pending["dave"] = true
+	mu.Unlock()
+	saved := trackerEvents
+	trackerEvents = NewEventBus()
+	t.Cleanup(func() { trackerEvents = saved })
+	published := make(chan Message, 4)
+	trackerEvents.Subscribe(EventMembersAdded, func(msg Message) { published <- msg })
+
+	resp := bulkAddMembers([]string{"g1", "alice", "carol", "bob", "dave", "carol", "erin"})
+	want := BulkAddResult{Added: []string{"carol", "dave", "erin"}, AlreadyMembers: []string{"bob"}, NotFound: []string{}}
+	if resp.Status != "ok" || !reflect.DeepEqual(resp.Data, want) {
+		t.Fatalf("bulk_add_members = %+v, want %+v", resp, want)
+	}
+	mu.RLock()
+	g := groups["g1"]
+	if len(g.Members) != 5 || len(g.Pending) != 0 {
+		t.Errorf("members %v, pending %v", g.Members, g.Pending)
+	}
+	mu.RUnlock()
+
+	var msg Message
+	select {
+	case msg = <-published:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no sync message")
+	}
+	if msg.Cmd != "sync_bulk_add_members" || !reflect.DeepEqual(msg.Args, []string{"g1", "carol", "dave", "erin"}) {
+		t.Errorf("sync message %+v", msg)
+	}
+
+	// Adding only members already there changes nothing
+	if resp := bulkAddMembers([]string{"g1", "alice", "bob", "carol"}); resp.Status != "ok" {
+		t.Errorf("bulk_add_members of members: %+v", resp)
+	}
+	select {
+	case msg := <-published:
+		t.Errorf("sync message for no change: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A peer tracker applies the message
+	resetGroupState(t, "alice", "bob")
+	mu.Lock()
+	groups["g1"].Pending["dave"] = true
+	mu.Unlock()
+	if resp := applySync(msg); resp.Status != "ok" {
+		t.Fatalf("applySync: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if g := groups["g1"]; len(g.Members) != 5 || g.Pending["dave"] || g.Version != msg.Version {
+		t.Errorf("after sync: members %v, pending %v, version %d", g.Members, g.Pending, g.Version)
+	}
+}
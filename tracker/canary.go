@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"p2p/common"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	canaryGroup      = "__canary__" // also the canary's user ID
+	canaryFileSize   = 4096         // one chunk
+	canaryAlertAfter = 3            // consecutive failures before alerting
+	canaryHistory    = 20           // results kept for /health
+)
+
+// CanaryResult is the outcome of one canary check.
+type CanaryResult struct {
+	Time      time.Time `json:"time"`
+	OK        bool      `json:"ok"`
+	Peer      string    `json:"peer,omitempty"`
+	LatencyMs int       `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// CanaryStatus is what /health reports.
+type CanaryStatus struct {
+	Healthy             bool           `json:"healthy"`
+	ConsecutiveFailures int            `json:"consecutive_failures"`
+	Results             []CanaryResult `json:"results"` // newest last
+}
+
+// Canary periodically registers a generated file in the __canary__ group,
+// downloads it back from a peer found through the normal file lookup and
+// checks its hash. The group, file and user exist only during a check; they
+// are never broadcast or saved.
+type Canary struct {
+	mu       sync.Mutex
+	results  []CanaryResult
+	failures int // consecutive
+	alertURL string
+
+	// seed starts a peer serving content and returns its address; tests
+	// swap in mock peers.
+	seed func(content []byte) (addr string, stop func(), err error)
+}
+
+var trackerCanary = &Canary{seed: startCanarySeeder}
+
+// Run checks every interval until the process exits.
+func (c *Canary) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		c.Check()
+	}
+}
+
+// Check runs one canary round trip and records its result.
+func (c *Canary) Check() CanaryResult {
+	start := time.Now()
+	peer, err := c.roundTrip()
+	r := CanaryResult{
+		Time:      start.UTC(),
+		OK:        err == nil,
+		Peer:      peer,
+		LatencyMs: int(time.Since(start).Milliseconds()),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	c.record(r)
+	return r
+}
+
+// roundTrip does the upload/lookup/download and returns the peer used.
+func (c *Canary) roundTrip() (string, error) {
+	content := make([]byte, canaryFileSize)
+	if _, err := rand.Read(content); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	addr, stop, err := c.seed(content)
+	if err != nil {
+		return "", fmt.Errorf("start seeder: %v", err)
+	}
+	defer stop()
+
+	fileName := fmt.Sprintf("canary-%d.bin", time.Now().UnixNano())
+	registerCanaryFile(fileName, hash, addr)
+	defer unregisterCanaryFile(fileName)
+
+	resp := getFileInfo([]string{canaryGroup, fileName})
+	if resp.Status != "ok" {
+		return "", fmt.Errorf("lookup: %v", resp.Data)
+	}
+	peers, _ := resp.Data.(map[string]interface{})["peers"].([]string)
+	if len(peers) == 0 {
+		return "", errors.New("no online peers for the canary file")
+	}
+	peer := peers[mrand.Intn(len(peers))]
+
+	data, err := fetchCanaryChunk(peer, hash)
+	if err != nil {
+		return peer, fmt.Errorf("download from %s: %v", peer, err)
+	}
+	got := sha256.Sum256(data)
+	if hex.EncodeToString(got[:]) != hash {
+		return peer, fmt.Errorf("download from %s: hash mismatch", peer)
+	}
+	return peer, nil
+}
+
+// record stores r and raises an alert when the failure streak reaches canaryAlertAfter.
+func (c *Canary) record(r CanaryResult) {
+	c.mu.Lock()
+	c.results = append(c.results, r)
+	if len(c.results) > canaryHistory {
+		c.results = c.results[len(c.results)-canaryHistory:]
+	}
+	if r.OK {
+		c.failures = 0
+	} else {
+		c.failures++
+	}
+	alert := c.failures == canaryAlertAfter
+	failures := c.failures
+	c.mu.Unlock()
+
+	if alert {
+		c.alert(failures, r)
+	}
+}
+
+// alert logs a critical message and, if TRACKER_ALERT_URL was set, POSTs it there.
+func (c *Canary) alert(failures int, last CanaryResult) {
+	fmt.Printf("CRITICAL: canary failed %d consecutive checks, last error: %s\n", failures, last.Error)
+	if c.alertURL == "" {
+		return
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"alert":                "canary_failing",
+		"consecutive_failures": failures,
+		"last_result":          last,
+	})
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(c.alertURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("Warning: canary alert webhook: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// Status returns the recent results.
+func (c *Canary) Status() CanaryStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CanaryStatus{
+		Healthy:             c.failures < canaryAlertAfter,
+		ConsecutiveFailures: c.failures,
+		Results:             append([]CanaryResult{}, c.results...),
+	}
+}
+
+// handleHealth serves the canary status as JSON, with 503 while it is failing.
+func (c *Canary) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := c.Status()
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// StartHealthServer serves /metrics, /ws for browser clients, and /health
+// for c unless it is nil, on addr in the background, open to the browser origins in
+// TRACKER_CORS_ORIGINS. With tlsConfig, the tracker's own, it serves HTTPS
+// and wss only, so browser clients' passwords aren't sent in the clear. The
+// server is returned for serveUntil to shut down.
+func StartHealthServer(addr string, c *Canary, tlsConfig *tls.Config) *http.Server {
+	srv := newHealthServer(addr, c, tlsConfig)
+	if tlsConfig != nil {
+		go srv.ListenAndServeTLS("", "")
+	} else {
+		go srv.ListenAndServe()
+	}
+	return srv
+}
+
+// newHealthServer is the server StartHealthServer runs.
+func newHealthServer(addr string, c *Canary, tlsConfig *tls.Config) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/ws", handleWS)
+	if c != nil {
+		mux.HandleFunc("/health", c.handleHealth)
+	}
+	srv := &http.Server{Addr: addr, Handler: corsMW(mux, corsOrigins()), TLSConfig: tlsConfig}
+	if tlsConfig != nil {
+		// HTTP/1.1 only: WebSocket upgrades don't work over HTTP/2
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return srv
+}
+
+// registerCanaryFile puts the canary user, group and file into the tracker
+// state directly, so nothing is broadcast or persisted.
+func registerCanaryFile(fileName, hash, addr string) {
+	now := time.Now().UTC()
+	mu.Lock()
+	defer mu.Unlock()
+	users[canaryGroup] = &User{UserID: canaryGroup, LoggedIn: true, Addr: addr}
+	groups[canaryGroup] = &Group{
+		GroupID: canaryGroup,
+		Owner:   canaryGroup,
+		Members: map[string]bool{canaryGroup: true},
+		Pending: map[string]bool{},
+	}
+	putFile(canaryGroup+":"+fileName, &File{
+		FileName:    fileName,
+		GroupID:     canaryGroup,
+		Uploader:    canaryGroup,
+		FileSize:    canaryFileSize,
+		FileHash:    hash,
+		ChunkSize:   canaryFileSize,
+		TotalChunks: 1,
+		Chunks:      []Chunk{{Index: 0, Hash: hash, Size: canaryFileSize}},
+		Owners:      map[string]bool{canaryGroup: true},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+}
+
+func unregisterCanaryFile(fileName string) {
+	mu.Lock()
+	defer mu.Unlock()
+	removeFile(canaryGroup + ":" + fileName)
+	delete(groups, canaryGroup)
+	delete(users, canaryGroup)
+}
+
+// isCanaryKey reports whether a users, groups or files key belongs to the canary.
+func isCanaryKey(key string) bool {
+	return key == canaryGroup || strings.HasPrefix(key, canaryGroup+":")
+}
+
+// withoutCanary returns m minus any canary entries, copying only if it has some.
+func withoutCanary[V any](m map[string]V) map[string]V {
+	found := false
+	for k := range m {
+		if isCanaryKey(k) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return m
+	}
+	res := make(map[string]V, len(m))
+	for k, v := range m {
+		if !isCanaryKey(k) {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// canaryPeerRequest and canaryPeerResponse mirror the client's peer protocol.
+type canaryPeerRequest struct {
+	Cmd      string `json:"cmd"`
+	FileHash string `json:"file_hash"`
+	PieceIdx int    `json:"piece_idx"`
+}
+
+type canaryPeerResponse struct {
+	Status string `json:"status"`
+	Data   []byte `json:"data,omitempty"`
+}
+
+// fetchCanaryChunk downloads chunk 0 of fileHash from peer.
+func fetchCanaryChunk(peer, fileHash string) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", peer, 500*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := common.Send(conn, canaryPeerRequest{Cmd: "get_piece", FileHash: fileHash}); err != nil {
+		return nil, err
+	}
+	var resp canaryPeerResponse
+	if err := common.Recv(conn, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != "ok" {
+		return nil, fmt.Errorf("peer answered %q", resp.Status)
+	}
+	return resp.Data, nil
+}
+
+// startCanarySeeder serves content as chunk 0 of its own hash on a local port.
+func startCanarySeeder(content []byte) (string, func(), error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				var req canaryPeerRequest
+				if err := common.Recv(conn, &req); err != nil {
+					return
+				}
+				if req.FileHash != hash || (req.Cmd == "get_piece" && req.PieceIdx != 0) {
+					common.Send(conn, canaryPeerResponse{Status: "error"})
+					return
+				}
+				resp := canaryPeerResponse{Status: "ok"}
+				if req.Cmd == "get_piece" {
+					resp.Data = content
+				}
+				common.Send(conn, resp)
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }, nil
+}
+
+// parseCanaryFlag removes --canary from args and returns its interval
+// (0 if absent) and the remaining arguments.
+func parseCanaryFlag(args []string) (time.Duration, []string, error) {
+	var interval time.Duration
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--canary" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return 0, nil, fmt.Errorf("--canary requires an interval such as 1m")
+			}
+			i++
+			value = args[i]
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return 0, nil, fmt.Errorf("--canary: invalid interval %q", value)
+		}
+		interval = d
+	}
+	return interval, rest, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"p2p/common"
+	"sync/atomic"
+	"testing"
+)
+
+// mockCanaryPeer answers every get_piece with data, whatever was asked for.
+func mockCanaryPeer(t *testing.T, data func(content []byte) []byte) func([]byte) (string, func(), error) {
+	return func(content []byte) (string, func(), error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				var req canaryPeerRequest
+				if common.Recv(conn, &req) == nil {
+					common.Send(conn, canaryPeerResponse{Status: "ok", Data: data(content)})
+				}
+				conn.Close()
+			}
+		}()
+		return ln.Addr().String(), func() { ln.Close() }, nil
+	}
+}
+
+func unreachableCanaryPeer(content []byte) (string, func(), error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	ln.Close()
+	return ln.Addr().String(), func() {}, nil
+}
+
+// TestCanary_RecordsSuccessAndFailure runs checks against a good, a corrupt
+// and an unreachable peer and checks each result, and that the canary leaves
+// no trace in the tracker state.
+func TestCanary_RecordsSuccessAndFailure(t *testing.T) {
+	resetGroupState(t, "alice")
+	c := &Canary{}
+
+	c.seed = mockCanaryPeer(t, func(content []byte) []byte { return content })
+	if r := c.Check(); !r.OK || r.Peer == "" || r.Error != "" {
+		t.Fatalf("good peer: %+v", r)
+	}
+	c.seed = mockCanaryPeer(t, func(content []byte) []byte { return append([]byte{1}, content[1:]...) })
+	if r := c.Check(); r.OK || r.Error == "" {
+		t.Fatalf("corrupt peer: %+v", r)
+	}
+	c.seed = unreachableCanaryPeer
+	if r := c.Check(); r.OK {
+		t.Fatalf("unreachable peer: %+v", r)
+	}
+
+	status := c.Status()
+	if len(status.Results) != 3 || !status.Results[0].OK || status.Results[1].OK || status.Results[2].OK {
+		t.Fatalf("results %+v", status.Results)
+	}
+	if status.ConsecutiveFailures != 2 || !status.Healthy {
+		t.Fatalf("status %+v, want 2 failures and still healthy", status)
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if _, ok := groups[canaryGroup]; ok {
+		t.Error("canary group left behind")
+	}
+	if _, ok := users[canaryGroup]; ok {
+		t.Error("canary user left behind")
+	}
+	if len(files) != 0 {
+		t.Errorf("canary files left behind: %v", files)
+	}
+}
+
+// TestCanary_AlertsAfterThreeFailures checks the webhook fires once per
+// failure streak and /health turns unhealthy.
+func TestCanary_AlertsAfterThreeFailures(t *testing.T) {
+	resetGroupState(t, "alice")
+	var posts int64
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&posts, 1)
+	}))
+	defer hook.Close()
+
+	c := &Canary{seed: unreachableCanaryPeer, alertURL: hook.URL}
+	for i := 0; i < 4; i++ {
+		c.Check()
+	}
+	if n := atomic.LoadInt64(&posts); n != 1 {
+		t.Fatalf("webhook called %d times, want 1", n)
+	}
+
+	rec := httptest.NewRecorder()
+	c.handleHealth(rec, httptest.NewRequest("GET", "/health", nil))
+	var status CanaryStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || status.Healthy || status.ConsecutiveFailures != 4 {
+		t.Fatalf("/health = %d %+v", rec.Code, status)
+	}
+
+	c.seed = mockCanaryPeer(t, func(content []byte) []byte { return content })
+	c.Check()
+	if s := c.Status(); !s.Healthy || s.ConsecutiveFailures != 0 {
+		t.Fatalf("after a success: %+v", s)
+	}
+}
+
+func TestWithoutCanary(t *testing.T) {
+	m := map[string]int{"g1": 1, canaryGroup: 2, canaryGroup + ":f": 3, "g1:f": 4}
+	got := withoutCanary(m)
+	if len(got) != 2 || got["g1"] != 1 || got["g1:f"] != 4 {
+		t.Fatalf("withoutCanary = %v", got)
+	}
+	if len(m) != 4 {
+		t.Fatal("input map was modified")
+	}
+}
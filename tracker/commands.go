@@ -0,0 +1,243 @@
+package main
+
+import (
+	"net"
+	"p2p/common"
+	"strings"
+)
+
+// CommandSpec describes a tracker command for list_commands. In Args a
+// trailing "?" marks an optional argument and "..." any number of them.
+type CommandSpec struct {
+	Description  string   `json:"description"`
+	Args         []string `json:"args"`
+	RequiresAuth bool     `json:"requires_auth"` // acts as, or on behalf of, a user
+}
+
+// requiredArgs counts the arguments a request must carry.
+func (s CommandSpec) requiredArgs() int {
+	n := 0
+	for _, a := range s.Args {
+		if !strings.HasSuffix(a, "?") && !strings.HasSuffix(a, "...") {
+			n++
+		}
+	}
+	return n
+}
+
+type trackerCommand struct {
+	spec   CommandSpec
+	handle func(msg Message, remote net.Addr) Response
+	sync   bool // tracker-to-tracker; not listed by list_commands
+
+	// middleware wraps handle, outermost first (see commandMiddleware)
+	middleware []Middleware
+}
+
+// trackerCommands is the dispatch table for every request the tracker answers.
+var trackerCommands = make(map[string]trackerCommand)
+
+// registerCommand adds a client command whose handler only needs the args.
+func registerCommand(name string, spec CommandSpec, h func(args []string) Response) {
+	trackerCommands[name] = trackerCommand{spec: spec, handle: func(msg Message, _ net.Addr) Response {
+		return h(msg.Args)
+	}}
+}
+
+// syncCommands carry replicated changes from peer trackers. They apply
+// state locally without re-broadcasting, to prevent loops.
+var syncCommands = []string{
+	"sync_create_user", "sync_create_group", "sync_join_group",
+	"sync_accept_request", "sync_upload_file", "sync_stop_sharing",
+	"sync_leave_group", "sync_add_seeder", "sync_patch_file", "sync_put_file",
+	"sync_increment_download_count", "sync_set_group_quota", "sync_rename_group",
+	"sync_log_download", "sync_add_moderator", "sync_remove_moderator",
+	"sync_set_mirrors", "sync_batch_upload", "sync_reserve_slot", "sync_cancel_reservation",
+	"sync_delete_group", "sync_bulk_add_members", "sync_import_group",
+}
+
+func init() {
+	// ── Users ─────────────────────────────────────────────────────────────────
+	registerCommand("create_user", CommandSpec{"Create a user account", []string{"userID", "password"}, false}, createUser)
+	registerCommand("login", CommandSpec{"Log in and record the peer address", []string{"userID", "password", "peerAddr"}, false}, login)
+	registerCommand("update_address", CommandSpec{"Change a logged-in user's peer address", []string{"userID", "peerAddr"}, true}, updateAddress)
+
+	// ── Groups ────────────────────────────────────────────────────────────────
+	registerCommand("create_group", CommandSpec{"Create a group owned by userID",
+		[]string{"groupID", "userID", "quotaBytes?", "inviteCode?"}, true}, createGroup)
+	registerCommand("list_groups", CommandSpec{"List groups; \"public\" leaves out private ones", []string{"public?"}, false}, listGroups)
+	registerCommand("get_group_info", CommandSpec{"Show a group's owner, members and storage use", []string{"groupID"}, false}, getGroupInfo)
+	registerCommand("join_group", CommandSpec{"Ask to join a group", []string{"groupID", "userID", "inviteCode?"}, true}, joinGroup)
+	registerCommand("list_requests", CommandSpec{"List pending join requests (owner or moderator)", []string{"groupID", "userID"}, true}, listRequests)
+	registerCommand("accept_requests", CommandSpec{"Accept a join request (owner or moderator)",
+		[]string{"groupID", "userID", "requesterID"}, true}, acceptRequest)
+	registerCommand("bulk_add_members", CommandSpec{"Add registered users to a group at once, all or none (owner)",
+		[]string{"groupID", "ownerID", "userID..."}, true}, bulkAddMembers)
+	registerCommand("leave_group", CommandSpec{"Leave a group", []string{"groupID", "userID"}, true}, leaveGroup)
+	registerCommand("set_group_quota", CommandSpec{"Set a group's storage quota; 0 removes it",
+		[]string{"groupID", "ownerID", "bytes"}, true}, setGroupQuota)
+	registerCommand("rename_group", CommandSpec{"Rename a group and move its files", []string{"groupID", "newGroupID", "ownerID"}, true}, renameGroup)
+	registerCommand("delete_group", CommandSpec{"Delete a group and all its files", []string{"groupID", "ownerID"}, true}, deleteGroup)
+	registerCommand("set_mirrors", CommandSpec{"Set the groups uploads are mirrored to",
+		[]string{"groupID", "ownerID", "mirrorGroupID..."}, true}, setMirrors)
+	registerCommand("add_moderator", CommandSpec{"Let a member accept join requests",
+		[]string{"groupID", "ownerID", "userID"}, true}, addModerator)
+	registerCommand("remove_moderator", CommandSpec{"Take a member's moderator role away",
+		[]string{"groupID", "ownerID", "userID"}, true}, removeModerator)
+	registerCommand("get_peer_address", CommandSpec{"Show another member's peer address",
+		[]string{"groupID", "userID", "targetUserID"}, true}, getPeerAddress)
+	registerCommand("list_moderators", CommandSpec{"List a group's moderators", []string{"groupID"}, false}, listModerators)
+	registerCommand("group_activity", CommandSpec{"Count a group's uploads, downloads and joins per hour or day",
+		[]string{"groupID", "ownerID", "period"}, true}, groupActivity)
+	registerCommand("export_group", CommandSpec{"Export a group's members and files as a JSON bundle (owner)",
+		[]string{"groupID", "ownerID"}, true}, exportGroup)
+	registerCommand("import_group", CommandSpec{"Merge a bundle from export_group into this tracker",
+		[]string{"ownerID", "bundleJSON"}, true}, importGroup)
+
+	// ── Files ─────────────────────────────────────────────────────────────────
+	registerCommand("upload_file", CommandSpec{"Share a file in a group",
+		[]string{"fileName", "groupID", "userID", "fileSize", "fileHash?", "chunksJSON?", "chunkSize?", "reservationToken?"}, true}, uploadFile)
+	registerCommand("reserve_slot", CommandSpec{"Hold a file name and quota for an upload for an hour",
+		[]string{"groupID", "fileName", "fileSize", "userID"}, true}, reserveSlot)
+	registerCommand("cancel_reservation", CommandSpec{"Give back a slot held by reserve_slot",
+		[]string{"groupID", "fileName", "userID"}, true}, cancelReservation)
+	registerCommand("batch_upload_files", CommandSpec{"Share several files at once, all or none",
+		[]string{"userID", "filesJSON"}, true}, batchUploadFiles)
+	registerCommand("list_files", CommandSpec{"List the files in a group, a page at a time if asked",
+		[]string{"groupID", "userID?", "pageSize?", "pageToken?"}, false}, listFiles)
+	registerCommand("get_file_info", CommandSpec{"Show a file's chunks and peers",
+		[]string{"groupID", "fileName", "userID?"}, false}, getFileInfo)
+	registerCommand("stop_sharing", CommandSpec{"Stop seeding a file", []string{"groupID", "fileName", "userID"}, true}, stopSharing)
+	registerCommand("add_seeder", CommandSpec{"Seed a downloaded file", []string{"groupID", "fileName", "userID"}, true}, addSeeder)
+	registerCommand("share_file", CommandSpec{"List a file in another group by reference",
+		[]string{"srcGroupID", "fileName", "destGroupID", "userID"}, true}, shareFile)
+	registerCommand("update_file_chunks", CommandSpec{"Replace the chunk layout of a file you uploaded and re-chunked; you become its only seeder",
+		[]string{"fileHash", "userID", "chunkSize", "chunksJSON", "groupID..."}, true}, updateFileChunks)
+	registerCommand("get_popular_files", CommandSpec{"List the most seeded files", []string{"topN?"}, false}, getPopularFiles)
+	registerCommand("get_download_log", CommandSpec{"Show who downloaded a file",
+		[]string{"groupID", "fileName", "userID", "since?"}, true}, getDownloadLog)
+	registerCommand("get_file_diff", CommandSpec{"List files changed in a group since a time",
+		[]string{"groupID", "userID", "since"}, true}, getFileDiff)
+	registerCommand("replicate_to_all", CommandSpec{"Ask every online member to download and seed a file (owner)",
+		[]string{"groupID", "fileName", "adminID"}, true}, replicateToAll)
+	registerCommand("replication_failed", CommandSpec{"Report that a download asked for by replicate_to_all failed",
+		[]string{"groupID", "fileName", "userID"}, true}, replicationFailed)
+	registerCommand("get_seeder_health", CommandSpec{"Probe which of a file's seeders answer",
+		[]string{"groupID", "fileName", "userID?"}, false}, getSeederHealth)
+	registerCommand("report_corrupt_seeder", CommandSpec{"Report a seeder whose chunk disagrees with the other seeders'; it is no longer listed",
+		[]string{"groupID", "fileName", "userID", "seederAddr"}, true}, reportCorruptSeeder)
+	registerCommand("similar_files", CommandSpec{"Rank a group's files by the chunks they share with a file",
+		[]string{"groupID", "fileName", "topN?", "userID?"}, false}, similarFiles)
+
+	// ── Tracker ───────────────────────────────────────────────────────────────
+	registerCommand(common.HelloCmd, CommandSpec{"Agree on a protocol version; answers with the newest this tracker speaks",
+		[]string{"version"}, false}, hello)
+	registerCommand("list_commands", CommandSpec{"List the commands this tracker answers", nil, false}, listCommands)
+	registerCommand("local_stats", CommandSpec{"List the users, groups and files this tracker holds", nil, false}, localStats)
+	registerCommand("client_version", CommandSpec{"Check a client version against the latest release",
+		[]string{"version"}, false}, clientVersion)
+	registerCommand("global_stats", CommandSpec{"Count users, groups and files across all trackers", nil, false}, globalStats)
+	registerCommand("list_members", CommandSpec{"List the trackers of the cluster and their status", nil, false}, listMembers)
+	registerCommand("speed_test", CommandSpec{"Hold a payload of random bytes for speed_test_fetch, to time an upload",
+		[]string{"sizeBytes", "payloadBase64"}, false}, speedTest)
+	registerCommand("speed_test_fetch", CommandSpec{"Return, once, a payload held by speed_test", []string{"id"}, false}, speedTestFetch)
+	trackerCommands["get_audit_log"] = trackerCommand{
+		spec: CommandSpec{"Show recent audited commands (localhost only)", []string{"lines?"}, false},
+		handle: func(msg Message, remote net.Addr) Response {
+			return getAuditLog(msg.Args, remote)
+		},
+	}
+
+	trackerCommands["backup_now"] = trackerCommand{
+		spec: CommandSpec{"Write a compressed state backup now (localhost only)", nil, false},
+		handle: func(msg Message, remote net.Addr) Response {
+			return backupNow(msg.Args, remote)
+		},
+	}
+	trackerCommands["restore_backup"] = trackerCommand{
+		spec: CommandSpec{"Merge a state backup into the running state (localhost only)", []string{"path?"}, false},
+		handle: func(msg Message, remote net.Addr) Response {
+			return restoreBackup(msg.Args, remote)
+		},
+	}
+	trackerCommands["inject_partition"] = trackerCommand{
+		spec: CommandSpec{"Drop sync messages to and from a peer tracker for a while, for testing (localhost only)",
+			[]string{"targetPeer", "durationSeconds"}, false},
+		handle: func(msg Message, remote net.Addr) Response {
+			return injectPartition(msg.Args, remote)
+		},
+	}
+	trackerCommands["clear_partition"] = trackerCommand{
+		spec: CommandSpec{"End a partition and pull the peer's state (localhost only)", []string{"targetPeer"}, false},
+		handle: func(msg Message, remote net.Addr) Response {
+			return clearPartition(msg.Args, remote)
+		},
+	}
+
+	// sync_pull returns the full state so a restarted tracker can catch up
+	trackerCommands["sync_pull"] = trackerCommand{sync: true, handle: func(Message, net.Addr) Response {
+		return syncPull()
+	}}
+	// Membership gossip between trackers, answered by trackerMembers
+	for _, name := range []string{"member_join", "member_alive", "member_ping", "member_ping_req", "member_fail", "member_leave"} {
+		trackerCommands[name] = trackerCommand{sync: true, handle: func(msg Message, _ net.Addr) Response {
+			if trackerMembers == nil {
+				return Response{"error", "membership not started"}
+			}
+			return trackerMembers.Handle(msg)
+		}}
+	}
+	for _, name := range syncCommands {
+		trackerCommands[name] = trackerCommand{sync: true, handle: func(msg Message, _ net.Addr) Response {
+			return applySync(msg)
+		}}
+	}
+	// Tokens users spent on peer trackers, for the rate limiter
+	trackerCommands["consume_token"] = trackerCommand{sync: true, handle: func(msg Message, _ net.Addr) Response {
+		return trackerRateLimiter.handleConsumeToken(msg.Args)
+	}}
+
+	for name, c := range trackerCommands {
+		c.middleware = commandMiddleware(name, c)
+		trackerCommands[name] = c
+	}
+}
+
+// runCommand looks msg.Cmd up in trackerCommands and runs it through the
+// command's middleware, which audits it, refuses requests with too few
+// arguments and, for client requests, refuses those not naming the user
+// they act as or over their user's rate limit. With --event-sourcing,
+// state-modifying commands that succeed are logged.
+func runCommand(msg Message, remote net.Addr) Response {
+	c, ok := trackerCommands[msg.Cmd]
+	if !ok {
+		unknown := func([]string) Response { return Response{"error", "unkown command"} }
+		return chain(unknown, AuditMiddleware(msg.Cmd))(msg.Args)
+	}
+	h := func(args []string) Response {
+		msg.Args = args
+		if eventSourced(msg.Cmd) {
+			return trackerEventLog.Load().Record(msg, func() Response { return c.handle(msg, remote) })
+		}
+		return c.handle(msg, remote)
+	}
+	return chain(h, c.middleware...)(msg.Args)
+}
+
+// listCommands returns the client commands and their argument signatures.
+// Tracker-to-tracker sync commands are left out.
+func listCommands([]string) Response {
+	specs := make(map[string]CommandSpec)
+	for name, c := range trackerCommands {
+		if !c.sync {
+			specs[name] = c.spec
+		}
+	}
+	return Response{"ok", specs}
+}
+
+func syncPull() Response {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Response{"ok", SyncSnapshot{Users: withoutCanary(users), Groups: withoutCanary(groups), Files: withoutCanary(files)}}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// switchCases returns the string constants of every case in funcName's
+// switch statements in file.
+func switchCases(t *testing.T, file, funcName string) []string {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(sourceDir, file), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []string
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != funcName {
+			continue
+		}
+		ast.Inspect(fn, func(n ast.Node) bool {
+			if cc, ok := n.(*ast.CaseClause); ok {
+				for _, e := range cc.List {
+					if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						s, _ := strconv.Unquote(lit.Value)
+						cases = append(cases, s)
+					}
+				}
+			}
+			return true
+		})
+	}
+	if len(cases) == 0 {
+		t.Fatalf("no switch cases found in %s", funcName)
+	}
+	return cases
+}
+
+// TestCommandRegistry_Complete keeps the registry in step with the code it
+// dispatches to: every sync message applySync handles, and every command
+// answered as a stream, must be registered.
+func TestCommandRegistry_Complete(t *testing.T) {
+	for _, name := range switchCases(t, "sync.go", "applySync") {
+		if c, ok := trackerCommands[name]; !ok || !c.sync {
+			t.Errorf("applySync handles %s but it isn't a registered sync command", name)
+		}
+	}
+	for name := range streamableCommands {
+		if c, ok := trackerCommands[name]; !ok || c.sync {
+			t.Errorf("streamable command %s isn't a registered client command", name)
+		}
+	}
+	for name, c := range trackerCommands {
+		if c.handle == nil {
+			t.Errorf("%s has no handler", name)
+		}
+		if !c.sync && c.spec.Description == "" {
+			t.Errorf("%s has no description", name)
+		}
+	}
+}
+
+// TestRunCommand_TooFewArgs sends every command that needs arguments
+// without any, which must be refused rather than reach the handler.
+func TestRunCommand_TooFewArgs(t *testing.T) {
+	resetGroupState(t, "alice")
+	useTestAuditLog(t, "")
+	for name, c := range trackerCommands {
+		n := c.spec.requiredArgs()
+		if n == 0 {
+			continue
+		}
+		resp := runCommand(Message{Cmd: name}, nil)
+		msg, _ := resp.Data.(string)
+		if resp.Status != "error" || !strings.HasPrefix(msg, name+": need") {
+			t.Errorf("%s with no args: %+v", name, resp)
+		}
+	}
+	if resp := runCommand(Message{Cmd: "no_such_command"}, nil); resp.Status != "error" {
+		t.Errorf("unknown command: %+v", resp)
+	}
+}
+
+func TestListCommands(t *testing.T) {
+	resp := runCommand(Message{Cmd: "list_commands"}, nil)
+	specs, ok := resp.Data.(map[string]CommandSpec)
+	if resp.Status != "ok" || !ok {
+		t.Fatalf("list_commands: %+v", resp)
+	}
+	up, ok := specs["upload_file"]
+	if !ok || up.requiredArgs() != 4 || !up.RequiresAuth {
+		t.Errorf("upload_file spec = %+v", up)
+	}
+	if _, ok := specs["list_commands"]; !ok {
+		t.Error("list_commands doesn't list itself")
+	}
+	for name := range specs {
+		if strings.HasPrefix(name, "sync_") {
+			t.Errorf("sync command %s listed", name)
+		}
+	}
+}
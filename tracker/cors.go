@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	corsMethods = "GET, POST, OPTIONS"
+	corsHeaders = "Content-Type"
+)
+
+// corsOrigins reads the origins allowed to call the tracker's HTTP
+// endpoints from TRACKER_CORS_ORIGINS (comma-separated, "*" for any).
+// Unset means none, so browsers keep the same-origin default.
+func corsOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("TRACKER_CORS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// corsMW adds CORS headers for requests from allowedOrigins and answers
+// preflight requests itself with 204. Other origins get no CORS headers,
+// which browsers treat as a refusal.
+func corsMW(h http.Handler, allowedOrigins []string) http.Handler {
+	anyOrigin := false
+	allowed := make(map[string]bool)
+	for _, o := range allowedOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		allowed[o] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && (anyOrigin || allowed[origin]) {
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
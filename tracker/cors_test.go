@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func corsTestHandler(origins ...string) http.Handler {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return corsMW(ok, origins)
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	req := httptest.NewRequest(http.MethodOptions, "/health", nil)
+	req.Header.Set("Origin", "https://app.example")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	corsTestHandler("https://app.example").ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight status = %d, want 204", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != corsMethods {
+		t.Errorf("Allow-Methods = %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != corsHeaders {
+		t.Errorf("Allow-Headers = %q", got)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("preflight reached the handler: %q", rec.Body.String())
+	}
+}
+
+func TestCORS_ActualRequest(t *testing.T) {
+	for _, allowed := range [][]string{{"https://app.example"}, {"*"}} {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.Header.Set("Origin", "https://app.example")
+		rec := httptest.NewRecorder()
+		corsTestHandler(allowed...).ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example" {
+			t.Errorf("allowed %v: Allow-Origin = %q", allowed, got)
+		}
+		if rec.Body.String() != "ok" {
+			t.Errorf("allowed %v: body = %q", allowed, rec.Body.String())
+		}
+	}
+}
+
+func TestCORS_DisallowedOrigin(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rec := httptest.NewRecorder()
+	corsTestHandler("https://app.example").ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed origin got Allow-Origin %q", got)
+	}
+	if rec.Body.String() != "ok" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+}
+
+func TestCORSOrigins_Env(t *testing.T) {
+	t.Setenv("TRACKER_CORS_ORIGINS", " https://a.example, https://b.example ,")
+	if got, want := corsOrigins(), []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("corsOrigins() = %v, want %v", got, want)
+	}
+	t.Setenv("TRACKER_CORS_ORIGINS", "")
+	if got := corsOrigins(); got != nil {
+		t.Errorf("unset: corsOrigins() = %v", got)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import "fmt"
+
+// reportCorruptSeeder marks the seeder of a file at seederAddr suspicious,
+// after userID's cross_verify found its copy of a chunk hashing differently
+// from the other seeders'. Its address is left out of get_file_info from
+// then on, for every file it seeds.
+// args: [groupID, fileName, userID, seederAddr]
+func reportCorruptSeeder(args []string) Response {
+	groupID, fileName, userID, seederAddr := args[0], args[1], args[2], args[3]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if !g.Members[userID] {
+		return Response{"error", "not a member of this group"}
+	}
+	file, ok := files[groupID+":"+fileName]
+	if !ok || file.isReserved() {
+		return Response{"error", "file not found"}
+	}
+	for owner := range file.Owners {
+		u, ok := users[owner]
+		if !ok || !u.LoggedIn || u.Addr != seederAddr {
+			continue
+		}
+		if owner == userID {
+			return Response{"error", "cannot report yourself"}
+		}
+		if !u.Suspicious {
+			u.Suspicious = true
+			fileInfoCache.InvalidateOwner(owner)
+			fmt.Printf("[cross_verify] %s reported %s's copy of %s in %s as corrupt\n", userID, owner, fileName, groupID)
+			go SaveState()
+		}
+		return Response{"ok", "seeder marked suspicious"}
+	}
+	return Response{"error", "no seeder of the file at " + seederAddr}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// filePeers returns the peers get_file_info lists for g1's name.
+func filePeers(t *testing.T, name string) []string {
+	t.Helper()
+	resp := getFileInfo([]string{"g1", name})
+	info, ok := resp.Data.(map[string]interface{})
+	if resp.Status != "ok" || !ok {
+		t.Fatalf("get_file_info %s: %+v", name, resp)
+	}
+	peers, _ := info["peers"].([]string)
+	slices.Sort(peers)
+	return peers
+}
+
+// TestReportCorruptSeeder has carol report bob's copy of a file and checks
+// bob is left out of that file's peers, and of the other files he seeds,
+// while alice stays listed.
+func TestReportCorruptSeeder(t *testing.T) {
+	resetGroupState(t, "alice", "bob", "carol")
+	seedUsers("alice", "bob", "carol")
+	seedFiles(t, "a.txt", "b.txt")
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if resp := addSeeder([]string{"g1", name, "bob"}); resp.Status != "ok" {
+			t.Fatalf("add_seeder %s: %+v", name, resp)
+		}
+	}
+	mu.RLock()
+	alice, bob := users["alice"].Addr, users["bob"].Addr
+	mu.RUnlock()
+	if got := filePeers(t, "b.txt"); !slices.Equal(got, []string{alice, bob}) {
+		t.Fatalf("peers before the report = %v", got)
+	}
+
+	if resp := reportCorruptSeeder([]string{"g1", "a.txt", "carol", bob}); resp.Status != "ok" {
+		t.Fatalf("report_corrupt_seeder: %+v", resp)
+	}
+	mu.RLock()
+	suspicious := users["bob"].Suspicious
+	mu.RUnlock()
+	if !suspicious {
+		t.Error("bob not marked suspicious")
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if got := filePeers(t, name); !slices.Equal(got, []string{alice}) {
+			t.Errorf("%s peers after the report = %v", name, got)
+		}
+	}
+}
+
+func TestReportCorruptSeeder_Refused(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedUsers("alice", "bob", "mallory")
+	seedFiles(t, "a.txt")
+	mu.RLock()
+	alice := users["alice"].Addr
+	mu.RUnlock()
+
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"not a member", []string{"g1", "a.txt", "mallory", alice}},
+		{"no such group", []string{"g2", "a.txt", "bob", alice}},
+		{"no such file", []string{"g1", "b.txt", "bob", alice}},
+		{"not a seeder", []string{"g1", "a.txt", "bob", "127.0.0.1:1"}},
+		{"yourself", []string{"g1", "a.txt", "alice", alice}},
+	} {
+		if resp := reportCorruptSeeder(tc.args); resp.Status != "error" {
+			t.Errorf("%s: %+v", tc.name, resp)
+		}
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if users["alice"].Suspicious {
+		t.Error("alice marked suspicious")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import "sync/atomic"
+
+// ChunkRegistry records the hash of every chunk of the files on this
+// tracker, by group, so an upload can be told which of its chunks the
+// groups its uploader belongs to hold already. Like chunkSets it is kept by
+// indexChunks and unindexChunks, under mu.
+type ChunkRegistry struct {
+	offsets map[string]int64          // chunk hash → byte offset in a file holding it, a hint
+	groups  map[string]map[string]int // chunk hash → group → how many of its files hold it
+}
+
+// NewChunkRegistry returns an empty registry.
+func NewChunkRegistry() *ChunkRegistry {
+	return &ChunkRegistry{offsets: make(map[string]int64), groups: make(map[string]map[string]int)}
+}
+
+var chunkRegistry = NewChunkRegistry()
+
+// Add registers set, the distinct chunk hashes of f, each with the offset
+// of its first chunk in f unless another file gave one already.
+func (r *ChunkRegistry) Add(f *File, set map[string]struct{}) {
+	for _, c := range f.Chunks {
+		if _, ok := set[c.Hash]; !ok {
+			continue
+		}
+		if _, ok := r.offsets[c.Hash]; !ok {
+			r.offsets[c.Hash] = int64(c.Index) * f.ChunkSize
+		}
+	}
+	for h := range set {
+		if r.groups[h] == nil {
+			r.groups[h] = make(map[string]int)
+		}
+		r.groups[h][f.GroupID]++
+	}
+}
+
+// Remove drops the distinct chunk hashes of a file in groupID, as given to
+// Add.
+func (r *ChunkRegistry) Remove(groupID string, set map[string]struct{}) {
+	for h := range set {
+		byGroup := r.groups[h]
+		if byGroup[groupID]--; byGroup[groupID] <= 0 {
+			delete(byGroup, groupID)
+		}
+		if len(byGroup) == 0 {
+			delete(r.groups, h)
+			delete(r.offsets, h)
+		}
+	}
+}
+
+// Lookup returns the offset hint of a chunk hash held by a file in one of
+// the groups visible accepts.
+func (r *ChunkRegistry) Lookup(hash string, visible func(groupID string) bool) (int64, bool) {
+	for groupID := range r.groups[hash] {
+		if visible(groupID) {
+			return r.offsets[hash], true
+		}
+	}
+	return 0, false
+}
+
+// Chunks counted by recordDedup for the dedup_ratio metric.
+var uploadedChunks, dedupedChunks atomic.Int64
+
+// markDeduplicated marks the chunks of a new upload held already by files
+// in groups its uploader belongs to, before f itself is registered, and
+// returns their hashes. Their uploader needn't keep them to serve the file.
+// Other groups aren't looked at: the reply would tell the uploader what
+// they hold. Caller must hold mu.
+func markDeduplicated(f *File) []string {
+	member := func(groupID string) bool {
+		g := groups[groupID]
+		return g != nil && g.Members[f.Uploader]
+	}
+	deduped := make([]string, 0)
+	for i := range f.Chunks {
+		c := &f.Chunks[i]
+		_, c.Deduplicated = chunkRegistry.Lookup(c.Hash, member)
+		if c.Deduplicated {
+			deduped = append(deduped, c.Hash)
+		}
+	}
+	return deduped
+}
+
+// recordDedup counts an upload from a client of total chunks, deduped of
+// them deduplicated. Uploads synced from peer trackers were counted there.
+func recordDedup(total, deduped int) {
+	uploadedChunks.Add(int64(total))
+	dedupedChunks.Add(int64(deduped))
+}
+
+// dedupRatio returns the fraction of uploaded chunks that were already
+// registered, 0 before any upload with chunks.
+func dedupRatio() float64 {
+	uploaded := uploadedChunks.Load()
+	if uploaded == 0 {
+		return 0
+	}
+	return float64(dedupedChunks.Load()) / float64(uploaded)
+}
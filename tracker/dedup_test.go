@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// resetDedup clears the dedup_ratio counters.
+func resetDedup() {
+	uploadedChunks.Store(0)
+	dedupedChunks.Store(0)
+}
+
+// dedupedHashes returns the deduplicated_chunks of an upload_file reply.
+func dedupedHashes(t *testing.T, data map[string]interface{}) []string {
+	t.Helper()
+	hashes, ok := data["deduplicated_chunks"].([]string)
+	if !ok {
+		t.Fatalf("no deduplicated_chunks in %+v", data)
+	}
+	return hashes
+}
+
+// dedupFlags returns which stored chunks of a file are marked deduplicated.
+func dedupFlags(fileName string) []bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	var flags []bool
+	for _, c := range files["g1:"+fileName].Chunks {
+		flags = append(flags, c.Deduplicated)
+	}
+	return flags
+}
+
+func TestUploadDedup(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		hashes  []string
+		deduped []string
+		flags   []bool
+		ratio   float64
+	}{
+		{"full", []string{"c1", "c2", "c3"}, []string{"c1", "c2", "c3"}, []bool{true, true, true}, 0.5},
+		{"partial", []string{"c1", "x1", "c3", "x2"}, []string{"c1", "c3"}, []bool{true, false, true, false}, 2.0 / 7},
+		{"none", []string{"x1", "x2"}, []string{}, []bool{false, false}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetGroupState(t, "alice")
+			resetDedup()
+			uploadChunked(t, "first.bin", "c1", "c2", "c3")
+
+			data := uploadChunked(t, "second.bin", tc.hashes...)
+			if got := dedupedHashes(t, data); !reflect.DeepEqual(got, tc.deduped) {
+				t.Errorf("deduplicated %v, want %v", got, tc.deduped)
+			}
+			if got := dedupFlags("second.bin"); !reflect.DeepEqual(got, tc.flags) {
+				t.Errorf("stored flags %v, want %v", got, tc.flags)
+			}
+			if got := dedupFlags("first.bin"); !reflect.DeepEqual(got, []bool{false, false, false}) {
+				t.Errorf("first upload flagged %v", got)
+			}
+			if got := dedupRatio(); got != tc.ratio {
+				t.Errorf("dedup ratio %v, want %v", got, tc.ratio)
+			}
+		})
+	}
+}
+
+// TestChunkRegistry_FollowsFiles checks a chunk stays registered while any
+// file holds it, with the offset it was first seen at.
+func TestChunkRegistry_FollowsFiles(t *testing.T) {
+	resetGroupState(t, "alice")
+	uploadChunked(t, "a.bin", "c1", "c2")
+	uploadChunked(t, "b.bin", "c0", "c2", "c2")
+
+	lookup := func(hash string) (int64, bool) {
+		mu.RLock()
+		defer mu.RUnlock()
+		return chunkRegistry.Lookup(hash, func(string) bool { return true })
+	}
+	if off, ok := lookup("c2"); !ok || off != defaultChunkSize {
+		t.Errorf("c2 at %d, %v; want the second chunk of a.bin", off, ok)
+	}
+
+	mu.Lock()
+	removeFile("g1:a.bin")
+	mu.Unlock()
+	if _, ok := lookup("c1"); ok {
+		t.Error("c1 still registered with no file holding it")
+	}
+	if _, ok := lookup("c2"); !ok {
+		t.Error("c2 unregistered while b.bin holds it")
+	}
+
+	mu.Lock()
+	removeFile("g1:b.bin")
+	n := len(chunkRegistry.groups)
+	mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d chunks registered with no files", n)
+	}
+}
+
+// TestBatchUploadDedup checks batch uploads report their deduplicated
+// chunks per file, counting files earlier in the same batch.
+func TestBatchUploadDedup(t *testing.T) {
+	resetGroupState(t, "alice")
+	resetDedup()
+	uploadChunked(t, "first.bin", "c1")
+	batch := `[{"file_name":"a.bin","group_id":"g1","file_size":20,"file_hash":"ha","chunks":[{"index":0,"hash":"c1","size":10},{"index":1,"hash":"c2","size":10}]},` +
+		`{"file_name":"b.bin","group_id":"g1","file_size":10,"file_hash":"hb","chunks":[{"index":0,"hash":"c2","size":10}]}]`
+
+	resp := batchUploadFiles([]string{"alice", batch})
+	if resp.Status != "ok" {
+		t.Fatalf("batch_upload_files: %+v", resp)
+	}
+	entries := resp.Data.(map[string]interface{})["files"].([]map[string]interface{})
+	for i, want := range [][]string{{"c1"}, {"c2"}} {
+		if got := dedupedHashes(t, entries[i]); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s deduplicated %v, want %v", entries[i]["file_name"], got, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if want := "tracker_dedup_ratio 0.5\n"; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("metrics missing %q:\n%s", want, rec.Body.String())
+	}
+}
+
+// TestUploadDedup_OwnGroupsOnly checks an upload is only told about chunks
+// in groups its uploader belongs to, so it can't probe what others hold.
+func TestUploadDedup_OwnGroupsOnly(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	mu.Lock()
+	groups["private"] = &Group{GroupID: "private", Owner: "carol", Members: map[string]bool{"carol": true, "alice": true}, Pending: map[string]bool{}}
+	mu.Unlock()
+	chunks := `[{"index":0,"hash":"secret","size":10}]`
+	if resp := uploadFile([]string{"s.bin", "private", "carol", "10", "hs", chunks}); resp.Status != "ok" {
+		t.Fatalf("upload to private: %+v", resp)
+	}
+
+	resp := uploadFile([]string{"guess.bin", "g1", "bob", "10", "hg", chunks})
+	if resp.Status != "ok" {
+		t.Fatalf("bob's upload: %+v", resp)
+	}
+	if got := dedupedHashes(t, resp.Data.(map[string]interface{})); len(got) != 0 {
+		t.Errorf("bob learned private chunks %v", got)
+	}
+	if got := dedupedHashes(t, uploadChunked(t, "a.bin", "secret")); !reflect.DeepEqual(got, []string{"secret"}) {
+		t.Errorf("alice, a member of private, deduplicated %v", got)
+	}
+}
+
+// TestUploadDedup_SyncNotCounted checks uploads replayed from a peer
+// tracker are marked but left out of tracker_dedup_ratio, as the tracker
+// they came in on counted them.
+func TestUploadDedup_SyncNotCounted(t *testing.T) {
+	resetGroupState(t, "alice")
+	resetDedup()
+	uploadChunked(t, "first.bin", "c1", "c2")
+	chunks := `[{"index":0,"hash":"c1","size":10}]`
+	if resp := storeUpload([]string{"synced.bin", "g1", "alice", "10", "hs", chunks}, &Message{Cmd: "sync_upload_file"}); resp.Status != "ok" {
+		t.Fatalf("synced upload: %+v", resp)
+	}
+	if got := dedupFlags("synced.bin"); !reflect.DeepEqual(got, []bool{true}) {
+		t.Errorf("synced upload flags %v", got)
+	}
+	if n, d := uploadedChunks.Load(), dedupedChunks.Load(); n != 2 || d != 0 {
+		t.Errorf("counted %d chunks, %d deduplicated; want only first.bin's 2", n, d)
+	}
+}
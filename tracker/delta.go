@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PatchOp is a single RFC 6902 JSON Patch operation.
+// Only add, remove and replace are generated or accepted; arrays are always
+// replaced as a whole rather than patched element by element.
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// cloneFile returns a copy of f that shares no maps or slices with the original,
+// so it can be diffed or marshalled after the lock is released.
+func cloneFile(f *File) *File {
+	c := *f
+	c.Chunks = append([]Chunk(nil), f.Chunks...)
+	c.DownloadLog = append([]DownloadEvent(nil), f.DownloadLog...)
+	c.Owners = make(map[string]bool, len(f.Owners))
+	for k, v := range f.Owners {
+		c.Owners[k] = v
+	}
+	if f.ReplicationStatus != nil {
+		c.ReplicationStatus = maps.Clone(f.ReplicationStatus)
+	}
+	return &c
+}
+
+// diffFiles returns the JSON patch that turns before into after.
+func diffFiles(before, after *File) ([]PatchOp, error) {
+	a, err := toJSONValue(before)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toJSONValue(after)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]PatchOp, 0)
+	diffJSON("", a, b, &ops)
+	return ops, nil
+}
+
+// diffJSON appends the operations needed to turn before into after at path.
+// Objects are compared key by key; everything else is replaced if it differs.
+func diffJSON(path string, before, after interface{}, ops *[]PatchOp) {
+	bm, bok := before.(map[string]interface{})
+	am, aok := after.(map[string]interface{})
+	if !bok || !aok {
+		if !reflect.DeepEqual(before, after) {
+			*ops = append(*ops, PatchOp{Op: "replace", Path: path, Value: after})
+		}
+		return
+	}
+
+	// Sorted keys keep the generated patch deterministic
+	keys := make([]string, 0, len(bm)+len(am))
+	for k := range bm {
+		keys = append(keys, k)
+	}
+	for k := range am {
+		if _, ok := bm[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		child := path + "/" + escapePointer(k)
+		bv, inBefore := bm[k]
+		av, inAfter := am[k]
+		switch {
+		case inBefore && !inAfter:
+			*ops = append(*ops, PatchOp{Op: "remove", Path: child})
+		case !inBefore && inAfter:
+			*ops = append(*ops, PatchOp{Op: "add", Path: child, Value: av})
+		default:
+			diffJSON(child, bv, av, ops)
+		}
+	}
+}
+
+// applyFilePatch applies ops to a copy of f and returns the patched file.
+// f itself is left untouched if any operation fails.
+func applyFilePatch(f *File, ops []PatchOp) (*File, error) {
+	doc, err := toJSONValue(f)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range ops {
+		if doc, err = applyOp(doc, op); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var patched File
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return nil, err
+	}
+	return &patched, nil
+}
+
+// applyOp applies a single operation to doc and returns the new document root.
+func applyOp(doc interface{}, op PatchOp) (interface{}, error) {
+	if op.Path == "" {
+		if op.Op == "remove" {
+			return nil, fmt.Errorf("patch: cannot remove document root")
+		}
+		return op.Value, nil
+	}
+
+	tokens := strings.Split(op.Path, "/")
+	if tokens[0] != "" {
+		return nil, fmt.Errorf("patch: invalid path %q", op.Path)
+	}
+	tokens = tokens[1:]
+
+	// Walk down to the object that holds the last path segment
+	parent, ok := doc.(map[string]interface{})
+	for _, tok := range tokens[:len(tokens)-1] {
+		if !ok {
+			break
+		}
+		parent, ok = parent[unescapePointer(tok)].(map[string]interface{})
+	}
+	if !ok || parent == nil {
+		return nil, fmt.Errorf("patch: path %q not found", op.Path)
+	}
+
+	last := unescapePointer(tokens[len(tokens)-1])
+	_, exists := parent[last]
+	switch op.Op {
+	case "add":
+		parent[last] = op.Value
+	case "replace":
+		if !exists {
+			return nil, fmt.Errorf("patch: replace of missing path %q", op.Path)
+		}
+		parent[last] = op.Value
+	case "remove":
+		if !exists {
+			return nil, fmt.Errorf("patch: remove of missing path %q", op.Path)
+		}
+		delete(parent, last)
+	default:
+		return nil, fmt.Errorf("patch: unsupported op %q", op.Op)
+	}
+	return doc, nil
+}
+
+// applyPatchSync handles sync_patch_file: args = [fileKey, baseVersion, patchJSON].
+// The patch is only applied if the local file is at baseVersion; otherwise the
+// sender is told to fall back to a full sync_put_file.
+func applyPatchSync(args []string) Response {
+	if len(args) < 3 {
+		return Response{"error", "sync_patch_file: need fileKey, baseVersion, patch"}
+	}
+	fileKey := args[0]
+	base, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return Response{"error", "sync_patch_file: invalid base version"}
+	}
+	var ops []PatchOp
+	if err := json.Unmarshal([]byte(args[2]), &ops); err != nil {
+		return Response{"error", "sync_patch_file: invalid patch"}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, ok := files[fileKey]
+	if !ok {
+		return Response{"error", "file not found"}
+	}
+	if f.Version != base {
+		return Response{"error", fmt.Sprintf("version conflict: have %d, patch base %d", f.Version, base)}
+	}
+
+	patched, err := applyFilePatch(f, ops)
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+	putFile(fileKey, patched)
+	fmt.Printf("[sync] patched %s to version %d (%d ops)\n", fileKey, patched.Version, len(ops))
+	go SaveState()
+	return Response{"ok", "synced"}
+}
+
+// applyPutSync handles sync_put_file: args = [fileJSON].
+// It replaces the local entry unless the local copy is at a newer version.
+// Two trackers that changed the file at once both reach the same version
+// and send each other a put; the entry with the greater fileEntryHash wins on
+// both, as in mergeState.
+func applyPutSync(args []string) Response {
+	if len(args) < 1 {
+		return Response{"error", "sync_put_file: need file"}
+	}
+	var f File
+	if err := json.Unmarshal([]byte(args[0]), &f); err != nil {
+		return Response{"error", "sync_put_file: invalid file"}
+	}
+	fileKey := f.GroupID + ":" + f.FileName
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if local, ok := files[fileKey]; ok && !preferIncoming("file", fileKey, local.Version, fileEntryHash(local), f.Version, fileEntryHash(&f)) {
+		return Response{"ok", "already up to date"}
+	}
+	if f.Owners == nil {
+		f.Owners = make(map[string]bool)
+	}
+	putFile(fileKey, &f)
+	fmt.Printf("[sync] full sync of %s at version %d\n", fileKey, f.Version)
+	go SaveState()
+	return Response{"ok", "synced"}
+}
+
+// broadcastFilePatch sends the delta between before and after to every peer tracker.
+// Peers that don't have the file, or hold a different version, get the full file instead.
+func broadcastFilePatch(fileKey string, before, after *File) {
+	ops, err := diffFiles(before, after)
+	if err != nil || len(ops) == 0 {
+		return
+	}
+	patchJSON, err := json.Marshal(ops)
+	if err != nil {
+		return
+	}
+	fullJSON, err := json.Marshal(after)
+	if err != nil {
+		return
+	}
+
+	patch := Message{
+		Cmd:  "sync_patch_file",
+		Args: []string{fileKey, strconv.FormatUint(before.Version, 10), string(patchJSON)},
+	}
+	full := Message{Cmd: "sync_put_file", Args: []string{string(fullJSON)}}
+
+	for _, addr := range syncPeers() {
+		go func(target string) {
+			// A queued patch could apply to a version the peer no longer
+			// has by the time it is resent, so the full file is queued
+			if trackerDLQ.Pending(target) {
+				trackerDLQ.Add(target, full)
+				return
+			}
+			resp, err := sendToPeer(target, patch)
+			if errors.Is(err, errSyncUndelivered) {
+				trackerDLQ.Add(target, full)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if resp.Status != "ok" {
+				sendToPeer(target, full)
+			}
+		}(addr)
+	}
+}
+
+// toJSONValue round-trips v through JSON to get its generic representation.
+func toJSONValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// escapePointer escapes a key for use as a JSON Pointer segment (RFC 6901).
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+// unescapePointer reverses escapePointer.
+func unescapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+}
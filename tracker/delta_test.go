@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// newTestFile returns a small file entry with two owners.
+func newTestFile() *File {
+	return &File{
+		FileName:    "a.txt",
+		GroupID:     "g1",
+		Uploader:    "alice",
+		FileSize:    10,
+		FileHash:    "abc",
+		ChunkSize:   512 * 1024,
+		TotalChunks: 1,
+		Chunks:      []Chunk{{Index: 0, Hash: "h0", Size: 10}},
+		Owners:      map[string]bool{"alice": true, "bob": true},
+		Version:     1,
+	}
+}
+
+// ── Patch generation ──────────────────────────────────────────────────────────
+
+// TestDiffFiles_OwnerAdded verifies that adding a seeder produces a small patch
+// touching only the new owner and the version, not the chunk list.
+func TestDiffFiles_OwnerAdded(t *testing.T) {
+	before := newTestFile()
+	after := cloneFile(before)
+	after.Owners["carol"] = true
+	after.Version++
+
+	ops, err := diffFiles(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PatchOp{
+		{Op: "add", Path: "/owners/carol", Value: true},
+		{Op: "replace", Path: "/version", Value: float64(2)},
+	}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("patch: want %+v got %+v", want, ops)
+	}
+}
+
+// TestDiffFiles_OwnerRemoved verifies that removing an owner emits a remove op.
+func TestDiffFiles_OwnerRemoved(t *testing.T) {
+	before := newTestFile()
+	after := cloneFile(before)
+	delete(after.Owners, "bob")
+
+	ops, err := diffFiles(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PatchOp{{Op: "remove", Path: "/owners/bob"}}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("patch: want %+v got %+v", want, ops)
+	}
+}
+
+// TestDiffFiles_NoChange verifies identical files produce an empty patch.
+func TestDiffFiles_NoChange(t *testing.T) {
+	f := newTestFile()
+	ops, err := diffFiles(f, cloneFile(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 0 {
+		t.Errorf("expected empty patch, got %+v", ops)
+	}
+}
+
+// TestPointerEscaping verifies keys containing '/' and '~' survive a round trip.
+func TestPointerEscaping(t *testing.T) {
+	before := newTestFile()
+	after := cloneFile(before)
+	after.Owners["we/ird~name"] = true
+
+	ops, err := diffFiles(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0].Path != "/owners/we~1ird~0name" {
+		t.Fatalf("unexpected patch: %+v", ops)
+	}
+
+	patched, err := applyFilePatch(before, ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !patched.Owners["we/ird~name"] {
+		t.Errorf("escaped owner not applied: %+v", patched.Owners)
+	}
+}
+
+// ── Patch application ─────────────────────────────────────────────────────────
+
+// TestApplyFilePatch_RoundTrip verifies that applying diff(before, after) to
+// before yields after.
+func TestApplyFilePatch_RoundTrip(t *testing.T) {
+	before := newTestFile()
+	after := cloneFile(before)
+	after.Owners["carol"] = true
+	delete(after.Owners, "alice")
+	after.Chunks = append(after.Chunks, Chunk{Index: 1, Hash: "h1", Size: 5})
+	after.TotalChunks = 2
+	after.Version = 3
+
+	ops, err := diffFiles(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := applyFilePatch(before, ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(patched, after) {
+		t.Errorf("round trip mismatch:\n  want %+v\n  got  %+v", after, patched)
+	}
+	if !before.Owners["alice"] {
+		t.Error("applyFilePatch must not modify its input")
+	}
+}
+
+// TestApplyFilePatch_InvalidOps verifies malformed operations are rejected.
+func TestApplyFilePatch_InvalidOps(t *testing.T) {
+	cases := []PatchOp{
+		{Op: "remove", Path: "/owners/nobody"},
+		{Op: "replace", Path: "/no_such_field", Value: 1},
+		{Op: "add", Path: "/file_name/deeper", Value: 1},
+		{Op: "move", Path: "/version"},
+		{Op: "add", Path: "version", Value: 1},
+	}
+	for _, op := range cases {
+		if _, err := applyFilePatch(newTestFile(), []PatchOp{op}); err == nil {
+			t.Errorf("expected error for %+v", op)
+		}
+	}
+}
+
+// ── sync_patch_file / sync_put_file ───────────────────────────────────────────
+
+// resetFiles installs a single file in the global state for sync tests.
+func resetFiles(t *testing.T, f *File) {
+	t.Helper()
+	mu.Lock()
+	replaceFiles(map[string]*File{f.GroupID + ":" + f.FileName: f})
+	mu.Unlock()
+}
+
+// TestApplyPatchSync_VersionConflict verifies a patch based on a stale
+// version is rejected and leaves local state untouched.
+func TestApplyPatchSync_VersionConflict(t *testing.T) {
+	local := newTestFile()
+	local.Version = 5
+	resetFiles(t, local)
+
+	after := cloneFile(local)
+	after.Owners["carol"] = true
+	after.Version = 5
+	ops, _ := diffFiles(local, after)
+	patch, _ := json.Marshal(ops)
+
+	resp := applyPatchSync([]string{"g1:a.txt", "4", string(patch)})
+	if resp.Status != "error" {
+		t.Fatalf("expected version conflict, got %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if files["g1:a.txt"].Owners["carol"] {
+		t.Error("conflicting patch must not be applied")
+	}
+}
+
+// TestApplyPatchSync_Applies verifies a patch on the matching base version is applied.
+func TestApplyPatchSync_Applies(t *testing.T) {
+	local := newTestFile()
+	resetFiles(t, local)
+
+	after := cloneFile(local)
+	after.Owners["carol"] = true
+	after.Version = 2
+	ops, _ := diffFiles(local, after)
+	patch, _ := json.Marshal(ops)
+
+	resp := applyPatchSync([]string{"g1:a.txt", "1", string(patch)})
+	if resp.Status != "ok" {
+		t.Fatalf("expected ok, got %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	got := files["g1:a.txt"]
+	if !got.Owners["carol"] || got.Version != 2 {
+		t.Errorf("patch not applied: %+v", got)
+	}
+}
+
+// TestApplyPatchSync_MissingFile verifies the receiver reports a missing file
+// so the sender can fall back to a full sync.
+func TestApplyPatchSync_MissingFile(t *testing.T) {
+	resetFiles(t, newTestFile())
+	resp := applyPatchSync([]string{"g1:missing.txt", "1", "[]"})
+	if resp.Status != "error" {
+		t.Fatalf("expected error for missing file, got %+v", resp)
+	}
+}
+
+// TestApplyPutSync_KeepsNewerLocal verifies a full sync never downgrades a file.
+func TestApplyPutSync_KeepsNewerLocal(t *testing.T) {
+	local := newTestFile()
+	local.Version = 7
+	resetFiles(t, local)
+
+	stale := cloneFile(local)
+	stale.Version = 3
+	delete(stale.Owners, "alice")
+	data, _ := json.Marshal(stale)
+
+	applyPutSync([]string{string(data)})
+	mu.RLock()
+	got := files["g1:a.txt"]
+	mu.RUnlock()
+	if got.Version != 7 || !got.Owners["alice"] {
+		t.Errorf("stale full sync overwrote newer local copy: %+v", got)
+	}
+
+	newer := cloneFile(local)
+	newer.Version = 8
+	data, _ = json.Marshal(newer)
+	applyPutSync([]string{string(data)})
+	mu.RLock()
+	got = files["g1:a.txt"]
+	mu.RUnlock()
+	if got.Version != 8 {
+		t.Errorf("newer full sync not applied: %+v", got)
+	}
+}
+
+// TestApplyPutSync_ConcurrentEditsConverge simulates two trackers that add a
+// different seeder to the same v1 file at once. Each rejects the other's
+// patch and gets its full file instead; both must keep the same entry.
+func TestApplyPutSync_ConcurrentEditsConverge(t *testing.T) {
+	onA, onB := newTestFile(), newTestFile()
+	onA.Owners["carol"], onB.Owners["dave"] = true, true
+	onA.Version, onB.Version = 2, 2
+	putA, _ := json.Marshal(onA)
+	putB, _ := json.Marshal(onB)
+
+	var kept []*File
+	for _, tc := range []struct {
+		local *File
+		put   []byte
+	}{{onA, putB}, {onB, putA}} {
+		resetFiles(t, cloneFile(tc.local))
+		applyPutSync([]string{string(tc.put)})
+		mu.RLock()
+		kept = append(kept, files["g1:a.txt"])
+		mu.RUnlock()
+	}
+	if !reflect.DeepEqual(kept[0].Owners, kept[1].Owners) || kept[0].Version != kept[1].Version {
+		t.Errorf("trackers diverged: A has %v v%d, B has %v v%d",
+			kept[0].Owners, kept[0].Version, kept[1].Owners, kept[1].Version)
+	}
+}
+
+// TestSyncUploadFile_ConcurrentUploads simulates two trackers taking an
+// upload of the same name at once. Each replays the other's; both must keep
+// the same entry, and a replay older than the local entry is ignored.
+func TestSyncUploadFile_ConcurrentUploads(t *testing.T) {
+	upload := func(uploader, chunk string) Message {
+		resetGroupState(t, "alice", "bob")
+		args := []string{"a.bin", "g1", uploader, "10", "h-" + uploader, `[{"index":0,"hash":"` + chunk + `","size":10}]`}
+		if resp := uploadFile(args); resp.Status != "ok" {
+			t.Fatalf("upload as %s: %+v", uploader, resp)
+		}
+		mu.RLock()
+		defer mu.RUnlock()
+		f := files["g1:a.bin"]
+		return Message{Cmd: "sync_upload_file", Args: args, Version: f.Version, Hash: fileEntryHash(f)}
+	}
+	kept := func() string {
+		mu.RLock()
+		defer mu.RUnlock()
+		return fileEntryHash(files["g1:a.bin"])
+	}
+
+	fromA := upload("alice", "c1")
+	fromB := upload("bob", "c2")
+	applySync(fromA)
+	onB := kept()
+	upload("alice", "c1")
+	applySync(fromB)
+	if onA := kept(); onA != onB {
+		t.Errorf("trackers diverged: A kept %.8s, B kept %.8s", onA, onB)
+	}
+	if onB != max(fromA.Hash, fromB.Hash) {
+		t.Errorf("kept %.8s, want the greater hash", onB)
+	}
+
+	upload("alice", "c1")
+	mu.Lock()
+	files["g1:a.bin"].Version = 2
+	mu.Unlock()
+	applySync(fromB)
+	if kept() != fromA.Hash {
+		t.Error("replayed v1 upload replaced a v2 entry")
+	}
+}
@@ -149,9 +149,10 @@ func convertChunks(chunks []Chunk) []dht.ChunkInfo {
 	dhtChunks := make([]dht.ChunkInfo, len(chunks))
 	for i, c := range chunks {
 		dhtChunks[i] = dht.ChunkInfo{
-			Index: c.Index,
-			Hash:  c.Hash,
-			Size:  c.Size,
+			Index:         c.Index,
+			Hash:          c.Hash,
+			Size:          c.Size,
+			HashAlgorithm: c.HashAlgorithm,
 		}
 	}
 	return dhtChunks
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dlqFile = "dlq.json"
+
+// dlqMaxEntries caps the dead letter queue; the oldest messages are dropped
+// past it, and a peer that missed them catches up with sync_pull on restart.
+const dlqMaxEntries = 10000
+
+// defaultDLQInterval is how often the queue is retried without --dlq-interval.
+const defaultDLQInterval = 30 * time.Second
+
+// errSyncUndelivered marks a sync message that never reached the peer: the
+// dial or the send failed. Messages that were sent but not acknowledged
+// may have been applied, and aren't retried.
+var errSyncUndelivered = errors.New("not delivered")
+
+// SyncMessage is a sync broadcast that couldn't be delivered to Peer.
+type SyncMessage struct {
+	Seq      uint64    `json:"seq"`
+	Peer     string    `json:"peer"`
+	Msg      Message   `json:"msg"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// DeadLetterQueue holds sync messages for peers that were down, oldest
+// first, so a peer that comes back gets what it missed without a full
+// state pull. While a peer has queued messages, newer ones for it queue
+// behind them, so it still receives them in order.
+type DeadLetterQueue struct {
+	mu      sync.Mutex
+	entries []SyncMessage
+	nextSeq uint64
+	dirty   bool // changed since the last save
+	path    string
+}
+
+var trackerDLQ = &DeadLetterQueue{path: dlqFile}
+
+// Add queues msg for peer, dropping the oldest entry if the queue is full.
+func (q *DeadLetterQueue) Add(peer string, msg Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) >= dlqMaxEntries {
+		dropped := q.entries[0]
+		q.entries = q.entries[1:]
+		fmt.Printf("Warning: dead letter queue full, dropped %s for %s\n", dropped.Msg.Cmd, dropped.Peer)
+	}
+	q.nextSeq++
+	q.entries = append(q.entries, SyncMessage{Seq: q.nextSeq, Peer: peer, Msg: msg, FailedAt: time.Now().UTC()})
+	q.dirty = true
+}
+
+// Pending reports whether peer has queued messages.
+func (q *DeadLetterQueue) Pending(peer string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range q.entries {
+		if e.Peer == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns how many messages are queued.
+func (q *DeadLetterQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// byPeer returns the queued messages grouped by peer, each oldest first.
+func (q *DeadLetterQueue) byPeer() map[string][]SyncMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	res := make(map[string][]SyncMessage)
+	for _, e := range q.entries {
+		res[e.Peer] = append(res[e.Peer], e)
+	}
+	return res
+}
+
+// remove drops peer's messages up to and including seq.
+func (q *DeadLetterQueue) remove(peer string, seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kept := q.entries[:0]
+	for _, e := range q.entries {
+		if e.Peer != peer || e.Seq > seq {
+			kept = append(kept, e)
+		}
+	}
+	q.entries = kept
+	q.dirty = true
+}
+
+// Retry resends the queued messages of every peer that answers a dial
+// probe, in order, stopping at the first one that can't be sent. It
+// returns how many were delivered.
+func (q *DeadLetterQueue) Retry() int {
+	delivered := 0
+	for peer, msgs := range q.byPeer() {
+		conn, err := dialTracker(peer, 500*time.Millisecond)
+		if err != nil {
+			continue // still down
+		}
+		conn.Close()
+
+		var last uint64
+		for _, m := range msgs {
+			if _, err := sendToPeer(peer, m.Msg); errors.Is(err, errSyncUndelivered) {
+				break
+			}
+			last = m.Seq
+			delivered++
+		}
+		if last > 0 {
+			q.remove(peer, last)
+			fmt.Printf("[sync] redelivered queued messages to %s\n", peer)
+		}
+	}
+	return delivered
+}
+
+// Save writes the queue to its file if it changed since the last save,
+// compressed with --compress-state.
+func (q *DeadLetterQueue) Save() error {
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(q.entries, "", "  ")
+	q.dirty = false
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// Queued messages can carry password hashes, like state.json
+	return writeStateFile(q.path, data, 0600)
+}
+
+// Load reads the queue's file if it exists.
+func (q *DeadLetterQueue) Load() error {
+	data, err := readStateFile(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []SyncMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries = entries
+	for _, e := range entries {
+		if e.Seq > q.nextSeq {
+			q.nextSeq = e.Seq
+		}
+	}
+	return nil
+}
+
+// runDLQRetrier retries trackerDLQ every interval and saves it.
+func runDLQRetrier(interval time.Duration) {
+	for range time.Tick(interval) {
+		trackerDLQ.Retry()
+		if err := trackerDLQ.Save(); err != nil {
+			fmt.Printf("Warning: Failed to save %s: %v\n", dlqFile, err)
+		}
+	}
+}
+
+// deliverSync sends msg to target, queueing it in trackerDLQ if it can't
+// be sent or if earlier messages for target are still queued.
+func deliverSync(target string, msg Message) {
+	if trackerDLQ.Pending(target) {
+		trackerDLQ.Add(target, msg)
+		return
+	}
+	if _, err := sendToPeer(target, msg); errors.Is(err, errSyncUndelivered) {
+		trackerDLQ.Add(target, msg)
+	}
+}
+
+// parseDLQFlag removes --dlq-interval from args.
+func parseDLQFlag(args []string) (time.Duration, []string, error) {
+	interval := defaultDLQInterval
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--dlq-interval" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return 0, nil, fmt.Errorf("--dlq-interval requires an interval such as 30s")
+			}
+			i++
+			value = args[i]
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return 0, nil, fmt.Errorf("--dlq-interval: invalid interval %q", value)
+		}
+		interval = d
+	}
+	return interval, rest, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net"
+	"p2p/common"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// useTestDLQ gives the test an empty dead letter queue saved under a temp dir.
+func useTestDLQ(t *testing.T) *DeadLetterQueue {
+	t.Helper()
+	saved := trackerDLQ
+	trackerDLQ = &DeadLetterQueue{path: filepath.Join(t.TempDir(), dlqFile)}
+	t.Cleanup(func() { trackerDLQ = saved })
+	return trackerDLQ
+}
+
+// downPeer returns a loopback address nothing is listening on.
+func downPeer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// startSyncPeer listens on addr as a peer tracker that acks every message
+// and records the commands it got, in order.
+func startSyncPeer(t *testing.T, addr string) func() []string {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("can't listen on %s again: %v", addr, err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	var got []string
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var msg Message
+			if err := common.Recv(conn, &msg); err == nil {
+				mu.Lock()
+				got = append(got, msg.Cmd)
+				mu.Unlock()
+				common.Send(conn, Response{"ok", "synced"})
+			}
+			conn.Close()
+		}
+	}()
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), got...)
+	}
+}
+
+// TestDeliverSync_QueuesWhenPeerDown checks a message for a peer that
+// can't be reached is queued, and later ones queue behind it.
+func TestDeliverSync_QueuesWhenPeerDown(t *testing.T) {
+	q := useTestDLQ(t)
+	peer := downPeer(t)
+
+	deliverSync(peer, Message{Cmd: "sync_create_user", Args: []string{"alice", "pw"}})
+	if q.Len() != 1 || !q.Pending(peer) {
+		t.Fatalf("queue has %d messages after a failed send", q.Len())
+	}
+	deliverSync(peer, Message{Cmd: "sync_create_group", Args: []string{"g1", "alice"}})
+	if q.Len() != 2 {
+		t.Fatalf("queue has %d messages, want 2", q.Len())
+	}
+
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := &DeadLetterQueue{path: q.path}
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 || loaded.entries[1].Msg.Cmd != "sync_create_group" || loaded.entries[1].Peer != peer {
+		t.Errorf("loaded %+v", loaded.entries)
+	}
+}
+
+// TestDeadLetterQueue_RetriesRecoveredPeer checks queued messages are
+// resent in order once the peer answers, and only for that peer.
+func TestDeadLetterQueue_RetriesRecoveredPeer(t *testing.T) {
+	q := useTestDLQ(t)
+	peer, stillDown := downPeer(t), downPeer(t)
+	deliverSync(peer, Message{Cmd: "sync_create_user"})
+	deliverSync(peer, Message{Cmd: "sync_create_group"})
+	deliverSync(stillDown, Message{Cmd: "sync_create_user"})
+
+	if n := q.Retry(); n != 0 || q.Len() != 3 {
+		t.Fatalf("Retry with both peers down delivered %d, left %d", n, q.Len())
+	}
+
+	got := startSyncPeer(t, peer)
+	if n := q.Retry(); n != 2 {
+		t.Errorf("Retry delivered %d, want 2", n)
+	}
+	if cmds := got(); len(cmds) != 2 || cmds[0] != "sync_create_user" || cmds[1] != "sync_create_group" {
+		t.Errorf("peer got %v", cmds)
+	}
+	if q.Pending(peer) || !q.Pending(stillDown) {
+		t.Errorf("after retry: %+v", q.entries)
+	}
+
+	// The recovered peer gets new messages directly again
+	deliverSync(peer, Message{Cmd: "sync_join_group"})
+	if cmds := got(); len(cmds) != 3 || q.Len() != 1 {
+		t.Errorf("peer got %v, queue has %d", cmds, q.Len())
+	}
+}
+
+// TestDeadLetterQueue_Cap checks the oldest messages are dropped once the
+// queue is full.
+func TestDeadLetterQueue_Cap(t *testing.T) {
+	q := useTestDLQ(t)
+	for i := 0; i < dlqMaxEntries+5; i++ {
+		q.Add("peer", Message{Cmd: "sync_create_user"})
+	}
+	if q.Len() != dlqMaxEntries {
+		t.Errorf("queue has %d messages, want %d", q.Len(), dlqMaxEntries)
+	}
+	if q.entries[0].Seq != 6 {
+		t.Errorf("oldest kept message is #%d, want #6", q.entries[0].Seq)
+	}
+}
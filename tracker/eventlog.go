@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With --event-sourcing every state-modifying command is appended to
+// commands.log, and the state is the last snapshot plus the commands
+// logged after it. Snapshots record the sequence number of the last
+// command they include, so restarting replays only the ones after.
+
+const (
+	eventLogFile = "commands.log"
+
+	// defaultEventLogCompactAt is how many commands the log holds before
+	// a snapshot is written and the log truncated.
+	defaultEventLogCompactAt = 100000
+)
+
+// trackerEventLog logs state-modifying commands; it holds nil without
+// --event-sourcing.
+var trackerEventLog atomic.Pointer[EventLog]
+
+// eventSourcedCommands are the client commands that change state. The
+// sync commands applying peers' changes are logged too. replicate_to_all
+// asks clients to download, which a replay mustn't repeat, and backups
+// are snapshots themselves, so they aren't logged.
+var eventSourcedCommands = map[string]bool{
+	"create_user":        true,
+	"login":              true,
+	"update_address":     true,
+	"create_group":       true,
+	"join_group":         true,
+	"accept_requests":    true,
+	"bulk_add_members":   true,
+	"import_group":       true,
+	"leave_group":        true,
+	"set_group_quota":    true,
+	"rename_group":       true,
+	"delete_group":       true,
+	"set_mirrors":        true,
+	"add_moderator":      true,
+	"remove_moderator":   true,
+	"upload_file":        true,
+	"batch_upload_files": true,
+	"reserve_slot":       true,
+	"cancel_reservation": true,
+	"stop_sharing":       true,
+	"add_seeder":         true,
+	"share_file":         true,
+	"update_file_chunks": true,
+	"replication_failed": true,
+}
+
+// eventSourced reports whether cmd is written to the event log.
+func eventSourced(cmd string) bool {
+	return eventSourcedCommands[cmd] || slices.Contains(syncCommands, cmd)
+}
+
+// LoggedCommand is one line of the event log.
+type LoggedCommand struct {
+	Seq uint64    `json:"seq"`
+	At  time.Time `json:"at"`
+	Msg Message   `json:"msg"`
+}
+
+// EventLog appends commands to a file, one JSON line each, numbered from
+// 1. Commands are recorded one at a time, so the log's order is the order
+// they changed the state in.
+type EventLog struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	seq       uint64 // of the last command logged or included in the state
+	count     int    // commands in the file
+	compactAt int
+}
+
+// OpenEventLog opens the log at path for appending, creating it if
+// needed. A partly written last line, left by a crash, is cut off.
+func OpenEventLog(path string, compactAt int) (*EventLog, error) {
+	// Like tracker_state.json, logged commands carry passwords
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &EventLog{path: path, file: f, compactAt: compactAt}
+	var good int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			break // a line without its newline wasn't finished
+		}
+		var c LoggedCommand
+		if json.Unmarshal(line, &c) != nil {
+			break
+		}
+		good += int64(len(line))
+		l.seq = c.Seq
+		l.count++
+	}
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append writes cmd to the log. Once the log holds compactAt commands the
+// state is snapshotted and the log truncated.
+func (l *EventLog) Append(cmd Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.appendLocked(cmd)
+}
+
+func (l *EventLog) appendLocked(cmd Message) error {
+	line, err := json.Marshal(LoggedCommand{Seq: l.seq + 1, At: time.Now().UTC(), Msg: cmd})
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.seq++
+	l.count++
+	if l.compactAt > 0 && l.count >= l.compactAt {
+		return l.compactLocked()
+	}
+	return nil
+}
+
+// Record runs apply, which carries out cmd, and logs cmd if it
+// succeeded. No other command is recorded meanwhile. A nil log just runs
+// apply.
+func (l *EventLog) Record(cmd Message, apply func() Response) Response {
+	if l == nil {
+		return apply()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	resp := apply()
+	if resp.Status == "ok" {
+		if err := l.appendLocked(cmd); err != nil {
+			fmt.Printf("Warning: Failed to log %s to %s: %v\n", cmd.Cmd, l.path, err)
+		}
+	}
+	return resp
+}
+
+// Replay returns the logged commands from sequence number from on, in
+// order. The channel is closed after the last one.
+func (l *EventLog) Replay(from int) chan Message {
+	ch := make(chan Message)
+	go func() {
+		defer close(ch)
+		f, err := os.Open(l.path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			var c LoggedCommand
+			if json.Unmarshal(bytes.TrimSpace(line), &c) != nil {
+				return
+			}
+			if c.Seq >= uint64(from) {
+				ch <- c.Msg
+			}
+		}
+	}()
+	return ch
+}
+
+// restore applies the logged commands after snapshotSeq, the last one the
+// loaded state includes, and returns how many there were. Snapshots wait
+// for it, so none is written of a half-replayed state.
+func (l *EventLog) restore(snapshotSeq uint64) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if snapshotSeq > l.seq {
+		l.seq = snapshotSeq // the log was truncated after the snapshot
+	}
+	n := 0
+	for msg := range l.Replay(int(snapshotSeq + 1)) {
+		if resp := replayCommand(msg); resp.Status != "ok" {
+			fmt.Printf("Warning: replaying %s: %v\n", msg.Cmd, resp.Data)
+		}
+		n++
+	}
+	return n
+}
+
+// replayAddr is where replayed commands appear to come from, so
+// localhost-only ones are allowed.
+var replayAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+// replayCommand applies a logged command. It was checked when first run,
+// so it skips the middleware.
+func replayCommand(msg Message) Response {
+	c, ok := trackerCommands[msg.Cmd]
+	if !ok {
+		return Response{"error", "unknown command"}
+	}
+	return c.handle(msg, replayAddr)
+}
+
+// Snapshot writes the state to disk, including every command logged so
+// far.
+func (l *EventLog) Snapshot() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return saveState(l.seq)
+}
+
+// compactLocked writes a snapshot and truncates the log. Caller must hold
+// l.mu.
+func (l *EventLog) compactLocked() error {
+	if err := saveState(l.seq); err != nil {
+		return err
+	}
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	l.count = 0
+	fmt.Printf("Compacted %s at command %d\n", l.path, l.seq)
+	return nil
+}
+
+// Close flushes the log to disk and closes its file.
+func (l *EventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestEventLog opens commands.log in the current directory as
+// trackerEventLog for the test.
+func useTestEventLog(t *testing.T, compactAt int) *EventLog {
+	t.Helper()
+	l, err := OpenEventLog(eventLogFile, compactAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trackerEventLog.Store(l)
+	t.Cleanup(func() {
+		trackerEventLog.Store(nil)
+		l.Close()
+	})
+	return l
+}
+
+// eventSession is a run of client commands, each logged but the failing
+// one and the read.
+var eventSession = [][]string{
+	{"create_user", "alice", "pw"},
+	{"create_user", "bob", "pw"},
+	{"create_user", "alice", "again"}, // exists: fails, not logged
+	{"login", "alice", "pw", "127.0.0.1:7001"},
+	{"create_group", "g1", "alice"},
+	{"join_group", "g1", "bob"},
+	{"list_groups"}, // read: not logged
+	{"accept_requests", "g1", "alice", "bob"},
+	{"upload_file", "a.txt", "g1", "alice", "10", "hash-a", "[]"},
+	{"add_seeder", "g1", "a.txt", "bob"},
+}
+
+const eventSessionLogged = 8
+
+func runEventSession(t *testing.T) {
+	t.Helper()
+	for _, c := range eventSession {
+		resp := runCommand(Message{Cmd: c[0], Args: c[1:]}, localAddr)
+		if failing := c[0] == "create_user" && c[2] == "again"; (resp.Status == "ok") == failing {
+			t.Fatalf("%v: %+v", c, resp)
+		}
+	}
+	// Let the snapshots the handlers started finish before the state is
+	// cleared for a restart
+	time.Sleep(100 * time.Millisecond)
+}
+
+// timestamps matches the times in marshalled state, which replaying sets
+// anew.
+var timestamps = regexp.MustCompile(`"\d{4}-\d\d-\d\dT[^"]*"`)
+
+// replayableState returns the persistent state as JSON, times left out.
+func replayableState(t *testing.T) string {
+	return timestamps.ReplaceAllString(string(currentState(t)), `"T"`)
+}
+
+// restartTracker starts over in a new directory holding copies of files,
+// with empty state and a new event log, as a restarted tracker would.
+func restartTracker(t *testing.T, compactAt int, files ...string) *EventLog {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trackerEventLog.Store(nil)
+	t.Chdir(dir)
+	clearState()
+	return useTestEventLog(t, compactAt)
+}
+
+// replayed lists the commands l replays from seq from on.
+func replayed(l *EventLog, from int) []string {
+	var cmds []string
+	for msg := range l.Replay(from) {
+		cmds = append(cmds, msg.Cmd)
+	}
+	return cmds
+}
+
+// TestEventLog_Replay logs a session and checks a restart with no
+// snapshot rebuilds the same state from the log alone.
+func TestEventLog_Replay(t *testing.T) {
+	useTestAuditLog(t, "")
+	clearState()
+	l := useTestEventLog(t, 0)
+	runEventSession(t)
+	want := replayableState(t)
+
+	if got := replayed(l, 1); len(got) != eventSessionLogged {
+		t.Fatalf("logged %v, want %d commands", got, eventSessionLogged)
+	}
+	if got := replayed(l, 4); strings.Join(got, " ") != "create_group join_group accept_requests upload_file add_seeder" {
+		t.Errorf("Replay(4) = %v", got)
+	}
+
+	restartTracker(t, 0, eventLogFile)
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if got := replayableState(t); got != want {
+		t.Errorf("replayed state:\n got %s\nwant %s", got, want)
+	}
+}
+
+// TestEventLog_Compaction compacts every three commands and checks a
+// restart from the snapshot and what is left of the log gives the same
+// state, and that numbering carries on from there.
+func TestEventLog_Compaction(t *testing.T) {
+	useTestAuditLog(t, "")
+	clearState()
+	l := useTestEventLog(t, 3)
+	runEventSession(t)
+	want := replayableState(t)
+
+	if got := replayed(l, 1); len(got) != eventSessionLogged%3 {
+		t.Fatalf("log holds %v after compaction", got)
+	}
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("no snapshot: %v", err)
+	}
+	if !strings.Contains(string(data), `"event_seq"`) {
+		t.Error("snapshot doesn't say which commands it includes")
+	}
+
+	l = restartTracker(t, 0, stateFile, eventLogFile)
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if got := replayableState(t); got != want {
+		t.Errorf("state after restart:\n got %s\nwant %s", got, want)
+	}
+
+	if resp := runCommand(Message{Cmd: "leave_group", Args: []string{"g1", "bob"}}, localAddr); resp.Status != "ok" {
+		t.Fatalf("leave_group: %+v", resp)
+	}
+	if got := replayed(l, eventSessionLogged+1); len(got) != 1 || got[0] != "leave_group" {
+		t.Errorf("Replay(%d) = %v, want the command after the session", eventSessionLogged+1, got)
+	}
+}
+
+// TestOpenEventLog_TornLine checks a line cut short by a crash is dropped
+// and appending carries on after the last whole one.
+func TestOpenEventLog_TornLine(t *testing.T) {
+	t.Chdir(t.TempDir())
+	l, err := OpenEventLog(eventLogFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append(Message{Cmd: "create_user", Args: []string{"alice", "pw"}})
+	l.Append(Message{Cmd: "create_user", Args: []string{"bob", "pw"}})
+	l.Close()
+	f, _ := os.OpenFile(eventLogFile, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(`{"seq":3,"msg":{"cmd":"create_gr`)
+	f.Close()
+
+	l, err = OpenEventLog(eventLogFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.Append(Message{Cmd: "create_group", Args: []string{"g1", "alice"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(replayed(l, 1), " "); got != "create_user create_user create_group" {
+		t.Errorf("log holds %q", got)
+	}
+	if got := replayed(l, 3); len(got) != 1 {
+		t.Errorf("Replay(3) = %v, want the command appended after reopening", got)
+	}
+}
+
+func TestParseBoolFlag(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want bool
+		rest int
+	}{
+		{[]string{"tracker_info.txt", "1"}, false, 2},
+		{[]string{"--event-sourcing", "tracker_info.txt", "1"}, true, 2},
+		{[]string{"--event-sourcing=false"}, false, 0},
+		{[]string{"--compress-state"}, false, 1},
+	} {
+		got, rest, err := parseBoolFlag(tc.args, "--event-sourcing")
+		if err != nil || got != tc.want || len(rest) != tc.rest {
+			t.Errorf("parseBoolFlag(%v) = %v, %v, %v", tc.args, got, rest, err)
+		}
+	}
+	if _, _, err := parseBoolFlag([]string{"--event-sourcing=maybe"}, "--event-sourcing"); err == nil {
+		t.Error("accepted --event-sourcing=maybe")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import "sync"
+
+// State change events published by the handlers. The payload is the sync
+// message for the change, so subscribers see the same Args, Version and Hash
+// the other trackers get.
+const (
+	EventUserCreated      = "user.created"
+	EventGroupCreated     = "group.created"
+	EventGroupQuotaSet    = "group.quota_set"
+	EventGroupJoined      = "group.joined"
+	EventGroupAccepted    = "group.request_accepted"
+	EventMembersAdded     = "group.members_added"
+	EventGroupLeft        = "group.left"
+	EventGroupRenamed     = "group.renamed"
+	EventGroupDeleted     = "group.deleted"
+	EventModeratorAdded   = "group.moderator_added"
+	EventModeratorRemoved = "group.moderator_removed"
+	EventGroupMirrorsSet  = "group.mirrors_set"
+	EventGroupImported    = "group.imported"
+	EventFileUploaded     = "file.uploaded"
+	EventBatchUploaded    = "file.batch_uploaded"
+	EventFileUnshared     = "file.unshared"
+	EventFileShared       = "file.shared"
+	EventFileDownloaded   = "file.downloaded"
+	EventDownloadRecorded = "file.download_logged"
+	EventFileReserved     = "file.reserved"
+	EventSlotCancelled    = "file.reservation_cancelled"
+	EventFileUnavailable  = "file.unavailable" // local to this tracker; not synced
+)
+
+// syncedEvents are the events the sync broadcaster forwards to peer trackers.
+var syncedEvents = []string{
+	EventUserCreated,
+	EventGroupCreated,
+	EventGroupQuotaSet,
+	EventGroupJoined,
+	EventGroupAccepted,
+	EventMembersAdded,
+	EventGroupLeft,
+	EventGroupRenamed,
+	EventGroupDeleted,
+	EventModeratorAdded,
+	EventModeratorRemoved,
+	EventGroupMirrorsSet,
+	EventGroupImported,
+	EventFileUploaded,
+	EventBatchUploaded,
+	EventFileUnshared,
+	EventFileShared,
+	EventFileDownloaded,
+	EventDownloadRecorded,
+	EventFileReserved,
+	EventSlotCancelled,
+}
+
+// EventHandler receives a published event's sync message.
+type EventHandler func(msg Message)
+
+// EventBus lets components react to state changes without the handlers
+// knowing about them. Handlers run synchronously, in subscription order.
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]EventHandler
+}
+
+var trackerEvents = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{handlers: make(map[string][]EventHandler)}
+}
+
+// Subscribe registers handler for event.
+func (b *EventBus) Subscribe(event string, handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[event] = append(b.handlers[event], handler)
+}
+
+// Publish calls every handler subscribed to event. Publishers that hold mu
+// should call it in a goroutine, as they did broadcastToTrackers.
+func (b *EventBus) Publish(event string, msg Message) {
+	b.mu.RLock()
+	handlers := append([]EventHandler(nil), b.handlers[event]...)
+	b.mu.RUnlock()
+	for _, h := range handlers {
+		h(msg)
+	}
+}
+
+// subscribeSync makes b forward every replicated event to the peer trackers.
+func subscribeSync(b *EventBus) {
+	for _, event := range syncedEvents {
+		b.Subscribe(event, broadcastToTrackers)
+	}
+}
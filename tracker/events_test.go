@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventBus_InvokesSubscriber(t *testing.T) {
+	b := NewEventBus()
+	var got []Message
+	b.Subscribe(EventFileUploaded, func(msg Message) { got = append(got, msg) })
+
+	b.Publish(EventFileUploaded, Message{Cmd: "sync_upload_file", Args: []string{"a.txt", "g1"}})
+	b.Publish(EventUserCreated, Message{Cmd: "sync_create_user"}) // no subscriber
+
+	if len(got) != 1 || got[0].Cmd != "sync_upload_file" || got[0].Args[0] != "a.txt" {
+		t.Fatalf("subscriber got %+v, want the one upload", got)
+	}
+}
+
+// TestEventBus_FanOut checks every subscriber of an event is called, in
+// subscription order.
+func TestEventBus_FanOut(t *testing.T) {
+	b := NewEventBus()
+	var order []int
+	for i := 0; i < 3; i++ {
+		i := i
+		b.Subscribe(EventGroupJoined, func(Message) { order = append(order, i) })
+	}
+	b.Publish(EventGroupJoined, Message{})
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Fatalf("subscribers called in order %v, want [0 1 2]", order)
+	}
+}
+
+// TestHandlers_PublishEvents checks the handlers publish their state changes
+// with the sync message peers would receive.
+func TestHandlers_PublishEvents(t *testing.T) {
+	resetGroupState(t, "alice")
+	saved := trackerEvents
+	trackerEvents = NewEventBus()
+	t.Cleanup(func() { trackerEvents = saved })
+
+	published := make(chan Message, 8)
+	for _, event := range []string{EventUserCreated, EventGroupJoined, EventFileUploaded} {
+		trackerEvents.Subscribe(event, func(msg Message) { published <- msg })
+	}
+	expect := func(cmd string) {
+		t.Helper()
+		select {
+		case msg := <-published:
+			if msg.Cmd != cmd {
+				t.Fatalf("got %s, want %s", msg.Cmd, cmd)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no event for %s", cmd)
+		}
+	}
+
+	mu.Lock()
+	delete(users, "bob")
+	mu.Unlock()
+	if resp := createUser([]string{"bob", "pw"}); resp.Status != "ok" {
+		t.Fatalf("create_user: %+v", resp)
+	}
+	expect("sync_create_user")
+	if resp := joinGroup([]string{"g1", "bob"}); resp.Status != "ok" {
+		t.Fatalf("join_group: %+v", resp)
+	}
+	expect("sync_join_group")
+	if resp := uploadFile([]string{"a.txt", "g1", "alice", "10", "hash-a", "[]"}); resp.Status != "ok" {
+		t.Fatalf("upload_file: %+v", resp)
+	}
+	expect("sync_upload_file")
+}
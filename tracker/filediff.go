@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// buryFile removes a file and leaves a tombstone in its place so polling
+// clients learn it was deleted. Caller must hold mu.
+func buryFile(fileKey string) {
+	f, ok := files[fileKey]
+	if !ok {
+		return
+	}
+	removeFile(fileKey)
+	tombstones[fileKey] = &Tombstone{GroupID: f.GroupID, FileName: f.FileName, DeletedAt: time.Now().UTC()}
+}
+
+// pruneTombstones drops tombstones older than tombstoneTTL. Caller must hold mu.
+func pruneTombstones(now time.Time) {
+	for key, t := range tombstones {
+		if now.Sub(t.DeletedAt) > tombstoneTTL {
+			delete(tombstones, key)
+		}
+	}
+}
+
+// getFileDiff returns the files in a group added, modified or deleted after
+// a given time, oldest change first, so sync tools can poll without listing
+// the whole group. Deletions older than tombstoneTTL are not reported.
+// args: [groupID, requestingUserID, since (RFC3339)]
+func getFileDiff(args []string) Response {
+	if len(args) < 3 {
+		return Response{"error", "get_file_diff: need groupID, userID, since"}
+	}
+	groupID, userID := args[0], args[1]
+	since, err := time.Parse(time.RFC3339, args[2])
+	if err != nil {
+		return Response{"error", "get_file_diff: since must be an RFC3339 time"}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if !g.Members[userID] {
+		return Response{"error", "not a member of this group"}
+	}
+
+	type change struct {
+		at    time.Time
+		entry map[string]interface{}
+	}
+	var changes []change
+	for _, f := range groupFiles(groupID) {
+		if !f.UpdatedAt.After(since) {
+			continue
+		}
+		action := "modified"
+		if f.CreatedAt.After(since) {
+			action = "added"
+		}
+		changes = append(changes, change{f.UpdatedAt, map[string]interface{}{
+			"file_name":  f.FileName,
+			"action":     action,
+			"updated_at": f.UpdatedAt,
+			"file_size":  f.FileSize,
+			"file_hash":  f.FileHash,
+			"uploader":   f.Uploader,
+		}})
+	}
+	for _, t := range tombstones {
+		if t.GroupID == groupID && t.DeletedAt.After(since) {
+			changes = append(changes, change{t.DeletedAt, map[string]interface{}{
+				"file_name":  t.FileName,
+				"action":     "deleted",
+				"updated_at": t.DeletedAt,
+			}})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].at.Before(changes[j].at) })
+	diff := make([]map[string]interface{}, len(changes))
+	for i, c := range changes {
+		diff[i] = c.entry
+	}
+	return Response{"ok", diff}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// diffActions runs get_file_diff for alice in g1 and maps file name to action.
+func diffActions(t *testing.T, since time.Time) map[string]string {
+	t.Helper()
+	resp := getFileDiff([]string{"g1", "alice", since.Format(time.RFC3339Nano)})
+	if resp.Status != "ok" {
+		t.Fatalf("get_file_diff: %+v", resp)
+	}
+	actions := make(map[string]string)
+	for _, e := range resp.Data.([]map[string]interface{}) {
+		actions[e["file_name"].(string)] = e["action"].(string)
+	}
+	return actions
+}
+
+// backdate pretends a file was uploaded and last changed at when.
+func backdate(fileKey string, when time.Time) {
+	mu.Lock()
+	files[fileKey].CreatedAt = when
+	files[fileKey].UpdatedAt = when
+	mu.Unlock()
+}
+
+// TestGetFileDiff_OnlyChangesSince verifies an upload after since appears in
+// the diff and an older one does not.
+func TestGetFileDiff_OnlyChangesSince(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice", "bob")
+	seedFiles(t, "old.txt")
+	backdate("g1:old.txt", time.Now().Add(-time.Hour))
+
+	since := time.Now().Add(-30 * time.Minute)
+	seedFiles(t, "new.txt")
+
+	actions := diffActions(t, since)
+	if len(actions) != 1 || actions["new.txt"] != "added" {
+		t.Errorf("diff = %v, want only new.txt added", actions)
+	}
+}
+
+// TestGetFileDiff_ModifiedAndDeleted verifies a new seeder shows as a
+// modification and removing the last owner leaves a tombstone.
+func TestGetFileDiff_ModifiedAndDeleted(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice", "bob")
+	seedFiles(t, "a.txt", "b.txt")
+	backdate("g1:a.txt", time.Now().Add(-time.Hour))
+	backdate("g1:b.txt", time.Now().Add(-time.Hour))
+	since := time.Now().Add(-time.Minute)
+
+	if resp := addSeeder([]string{"g1", "a.txt", "bob"}); resp.Status != "ok" {
+		t.Fatalf("add_seeder: %+v", resp)
+	}
+	if resp := stopSharing([]string{"g1", "b.txt", "alice"}); resp.Status != "ok" {
+		t.Fatalf("stop_sharing: %+v", resp)
+	}
+
+	actions := diffActions(t, since)
+	if actions["a.txt"] != "modified" || actions["b.txt"] != "deleted" || len(actions) != 2 {
+		t.Errorf("diff = %v, want a.txt modified and b.txt deleted", actions)
+	}
+	if resp := getFileInfo([]string{"g1", "b.txt"}); resp.Status != "error" {
+		t.Error("deleted file is still served")
+	}
+
+	// Uploading the name again replaces the tombstone
+	seedFiles(t, "b.txt")
+	if actions := diffActions(t, since); actions["b.txt"] != "added" {
+		t.Errorf("re-uploaded b.txt reported as %q", actions["b.txt"])
+	}
+}
+
+func TestGetFileDiff_Rejects(t *testing.T) {
+	resetGroupState(t, "alice")
+	now := time.Now().Format(time.RFC3339)
+	cases := map[string][]string{
+		"non-member":    {"g1", "mallory", now},
+		"unknown group": {"nope", "alice", now},
+		"bad since":     {"g1", "alice", "yesterday"},
+		"missing since": {"g1", "alice"},
+	}
+	for name, args := range cases {
+		if resp := getFileDiff(args); resp.Status != "error" {
+			t.Errorf("%s: got %+v", name, resp)
+		}
+	}
+}
+
+func TestPruneTombstones(t *testing.T) {
+	resetGroupState(t, "alice")
+	now := time.Now()
+	mu.Lock()
+	tombstones["g1:old"] = &Tombstone{GroupID: "g1", FileName: "old", DeletedAt: now.Add(-tombstoneTTL - time.Hour)}
+	tombstones["g1:new"] = &Tombstone{GroupID: "g1", FileName: "new", DeletedAt: now.Add(-time.Hour)}
+	pruneTombstones(now)
+	_, oldKept := tombstones["g1:old"]
+	_, newKept := tombstones["g1:new"]
+	mu.Unlock()
+	if oldKept || !newKept {
+		t.Errorf("old kept = %v, new kept = %v", oldKept, newKept)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+// filesByGroup indexes files by group ID and then file name, so per-group
+// lookups don't scan every file on the tracker. It must always agree with
+// files: write both through putFile, removeFile and replaceFiles, under mu.
+// They keep chunkSets and chunkRegistry too.
+var filesByGroup = make(map[string]map[string]*File)
+
+// putFile stores f under key, replacing and unindexing any previous entry.
+// Caller must hold mu.
+func putFile(key string, f *File) {
+	if old, ok := files[key]; ok {
+		unindexFile(old)
+	}
+	files[key] = f
+	indexFile(f)
+	fileInfoCache.Invalidate(key)
+}
+
+// removeFile deletes the file stored under key. Caller must hold mu.
+func removeFile(key string) {
+	if f, ok := files[key]; ok {
+		unindexFile(f)
+		delete(files, key)
+	}
+	fileInfoCache.Invalidate(key)
+}
+
+// replaceFiles replaces all files with m and rebuilds the index from it.
+// Caller must hold mu.
+func replaceFiles(m map[string]*File) {
+	files = m
+	filesByGroup = make(map[string]map[string]*File)
+	chunkSets = make(map[*File]map[string]struct{})
+	chunkRegistry = NewChunkRegistry()
+	for _, f := range files {
+		indexFile(f)
+	}
+	fileInfoCache.Clear()
+}
+
+// groupFiles returns the files of groupID by name. The map is the index
+// itself and must not be modified. Caller must hold mu.
+func groupFiles(groupID string) map[string]*File {
+	return filesByGroup[groupID]
+}
+
+func indexFile(f *File) {
+	byName, ok := filesByGroup[f.GroupID]
+	if !ok {
+		byName = make(map[string]*File)
+		filesByGroup[f.GroupID] = byName
+	}
+	byName[f.FileName] = f
+	indexChunks(f)
+}
+
+// unindexFile drops f from the index, unless its slot already holds another file.
+func unindexFile(f *File) {
+	unindexChunks(f)
+	byName := filesByGroup[f.GroupID]
+	if byName[f.FileName] != f {
+		return
+	}
+	delete(byName, f.FileName)
+	if len(byName) == 0 {
+		delete(filesByGroup, f.GroupID)
+	}
+}
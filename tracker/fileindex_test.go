@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// checkFileIndex fails unless filesByGroup holds exactly the files in files.
+func checkFileIndex(t *testing.T) {
+	t.Helper()
+	mu.RLock()
+	defer mu.RUnlock()
+	indexed := 0
+	for groupID, byName := range filesByGroup {
+		for name, f := range byName {
+			if f.GroupID != groupID || f.FileName != name || files[groupID+":"+name] != f {
+				t.Errorf("index entry %s/%s doesn't match files", groupID, name)
+			}
+			indexed++
+		}
+	}
+	if indexed != len(files) {
+		t.Errorf("index holds %d files, files has %d", indexed, len(files))
+	}
+}
+
+// TestFileIndex_FollowsWrites runs the handlers that add, move and remove
+// files and checks the index agrees with files after each.
+func TestFileIndex_FollowsWrites(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedFiles(t, "a.txt", "b.txt", "c.txt")
+	checkFileIndex(t)
+
+	if resp := addSeeder([]string{"g1", "a.txt", "bob"}); resp.Status != "ok" {
+		t.Fatalf("add_seeder: %+v", resp)
+	}
+	if resp := renameGroup([]string{"g1", "team", "alice"}); resp.Status != "ok" {
+		t.Fatalf("rename_group: %+v", resp)
+	}
+	checkFileIndex(t)
+	mu.RLock()
+	if len(groupFiles("g1")) != 0 || len(groupFiles("team")) != 3 {
+		t.Errorf("after rename: g1 has %d files, team has %d", len(groupFiles("g1")), len(groupFiles("team")))
+	}
+	mu.RUnlock()
+
+	if resp := stopSharing([]string{"team", "b.txt", "alice"}); resp.Status != "ok" {
+		t.Fatalf("stop_sharing: %+v", resp)
+	}
+	checkFileIndex(t)
+	resp := listFiles([]string{"team", "alice"})
+	if list, ok := resp.Data.([]map[string]interface{}); !ok || len(list) != 2 {
+		t.Fatalf("list_files after stop_sharing = %+v", resp)
+	}
+}
+
+// TestFileIndex_RebuiltOnLoad saves state, clears it and loads it back.
+func TestFileIndex_RebuiltOnLoad(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice")
+	seedFiles(t, "a.txt", "b.txt")
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	replaceFiles(make(map[string]*File))
+	mu.Unlock()
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	checkFileIndex(t)
+	mu.RLock()
+	defer mu.RUnlock()
+	if n := len(groupFiles("g1")); n != 2 {
+		t.Fatalf("g1 has %d indexed files after load, want 2", n)
+	}
+}
+
+// benchFiles fills the tracker with 100,000 files spread over 1,000 groups.
+func benchFiles(b *testing.B) {
+	b.Helper()
+	m := make(map[string]*File, 100000)
+	for g := 0; g < 1000; g++ {
+		groupID := fmt.Sprintf("g%d", g)
+		for i := 0; i < 100; i++ {
+			name := fmt.Sprintf("file%d.bin", i)
+			m[groupID+":"+name] = &File{FileName: name, GroupID: groupID, FileSize: int64(i)}
+		}
+	}
+	mu.Lock()
+	saved, savedGroups := files, groups
+	replaceFiles(m)
+	groups = map[string]*Group{"g500": {GroupID: "g500", Members: map[string]bool{"alice": true}}}
+	mu.Unlock()
+	b.Cleanup(func() {
+		mu.Lock()
+		replaceFiles(saved)
+		groups = savedGroups
+		mu.Unlock()
+	})
+}
+
+// BenchmarkListFiles_Scan is the old O(N) scan over every file.
+func BenchmarkListFiles_Scan(b *testing.B) {
+	benchFiles(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mu.RLock()
+		var n int
+		for _, f := range files {
+			if f.GroupID == "g500" {
+				n++
+			}
+		}
+		mu.RUnlock()
+		if n != 100 {
+			b.Fatalf("found %d files", n)
+		}
+	}
+}
+
+// BenchmarkListFiles_Index is list_files using the per-group index, O(K).
+func BenchmarkListFiles_Index(b *testing.B) {
+	benchFiles(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if resp := listFiles([]string{"g500", "alice"}); resp.Status != "ok" {
+			b.Fatalf("list_files: %+v", resp)
+		}
+	}
+}
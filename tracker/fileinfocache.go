@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fileInfoCacheTTL is how long a get_file_info reply is reused. Changes
+// that don't invalidate it, like patches from peer trackers, show after
+// at most this long.
+const fileInfoCacheTTL = 10 * time.Second
+
+type cachedFileInfo struct {
+	info    map[string]interface{}
+	expires time.Time
+}
+
+// FileInfoCache memoizes get_file_info replies by files key, so busy files
+// don't rebuild their peer list from users on every lookup. Entries are
+// put and invalidated with mu held, so a reply built from state that has
+// since changed can't be cached after its invalidation.
+type FileInfoCache struct {
+	entries sync.Map // files key -> *cachedFileInfo
+	ttl     time.Duration
+	now     func() time.Time
+
+	hits, misses atomic.Int64
+}
+
+var fileInfoCache = &FileInfoCache{ttl: fileInfoCacheTTL, now: time.Now}
+
+// Get returns the cached reply for key, counting a hit or a miss. The map
+// is shared and must not be modified.
+func (c *FileInfoCache) Get(key string) (map[string]interface{}, bool) {
+	if v, ok := c.entries.Load(key); ok {
+		if e := v.(*cachedFileInfo); c.now().Before(e.expires) {
+			c.hits.Add(1)
+			return e.info, true
+		}
+		c.entries.CompareAndDelete(key, v)
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+// Put caches info as the reply for key for the cache's TTL.
+func (c *FileInfoCache) Put(key string, info map[string]interface{}) {
+	c.entries.Store(key, &cachedFileInfo{info: info, expires: c.now().Add(c.ttl)})
+}
+
+// Invalidate drops the reply for key.
+func (c *FileInfoCache) Invalidate(key string) {
+	c.entries.Delete(key)
+}
+
+// InvalidateOwner drops the replies for every file userID seeds, whose
+// peer lists carry userID's address. Caller must hold mu.
+func (c *FileInfoCache) InvalidateOwner(userID string) {
+	for key, f := range files {
+		if f.Owners[userID] {
+			c.entries.Delete(key)
+		}
+	}
+}
+
+// Clear drops every reply.
+func (c *FileInfoCache) Clear() {
+	c.entries.Clear()
+}
+
+// HitRate returns the fraction of lookups answered from the cache, 0
+// before the first.
+func (c *FileInfoCache) HitRate() float64 {
+	hits, misses := c.hits.Load(), c.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// handleMetrics serves tracker metrics in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP tracker_file_info_cache_hits_total get_file_info lookups answered from the cache.\n")
+	fmt.Fprintf(w, "# TYPE tracker_file_info_cache_hits_total counter\n")
+	fmt.Fprintf(w, "tracker_file_info_cache_hits_total %d\n", fileInfoCache.hits.Load())
+	fmt.Fprintf(w, "# HELP tracker_file_info_cache_misses_total get_file_info lookups that built a reply.\n")
+	fmt.Fprintf(w, "# TYPE tracker_file_info_cache_misses_total counter\n")
+	fmt.Fprintf(w, "tracker_file_info_cache_misses_total %d\n", fileInfoCache.misses.Load())
+	fmt.Fprintf(w, "# HELP tracker_file_info_cache_hit_rate Fraction of get_file_info lookups answered from the cache.\n")
+	fmt.Fprintf(w, "# TYPE tracker_file_info_cache_hit_rate gauge\n")
+	fmt.Fprintf(w, "tracker_file_info_cache_hit_rate %g\n", fileInfoCache.HitRate())
+	fmt.Fprintf(w, "# HELP tracker_dedup_ratio Fraction of uploaded chunks another file on the tracker held already.\n")
+	fmt.Fprintf(w, "# TYPE tracker_dedup_ratio gauge\n")
+	fmt.Fprintf(w, "tracker_dedup_ratio %g\n", dedupRatio())
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useFileInfoClock empties fileInfoCache and puts it on a clock the test
+// moves by changing the returned time.
+func useFileInfoClock(t *testing.T) *time.Time {
+	t.Helper()
+	now := time.Unix(1_700_000_000, 0)
+	fileInfoCache.Clear()
+	fileInfoCache.now = func() time.Time { return now }
+	t.Cleanup(func() {
+		fileInfoCache.now = time.Now
+		fileInfoCache.Clear()
+	})
+	return &now
+}
+
+func fileInfoPeers(t *testing.T, fileName string) []string {
+	t.Helper()
+	resp := getFileInfo([]string{"g1", fileName})
+	if resp.Status != "ok" {
+		t.Fatalf("get_file_info %s: %+v", fileName, resp)
+	}
+	peers, _ := resp.Data.(map[string]interface{})["peers"].([]string)
+	return peers
+}
+
+// seedUsers replaces users with ids, logged in at 127.0.0.1:6000, :6001 and so on.
+func seedUsers(ids ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	users = make(map[string]*User)
+	for i, id := range ids {
+		users[id] = &User{UserID: id, LoggedIn: true, Addr: fmt.Sprintf("127.0.0.1:%d", 6000+i)}
+	}
+}
+
+// TestFileInfoCache_PopulatedOnMiss checks the first lookup builds and
+// caches the reply, and the next is answered from the cache.
+func TestFileInfoCache_PopulatedOnMiss(t *testing.T) {
+	resetGroupState(t, "alice")
+	useFileInfoClock(t)
+	seedUsers("alice")
+	seedFiles(t, "a.txt")
+	hits, misses := fileInfoCache.hits.Load(), fileInfoCache.misses.Load()
+
+	first := getFileInfo([]string{"g1", "a.txt"})
+	if _, ok := fileInfoCache.entries.Load("g1:a.txt"); !ok {
+		t.Fatal("reply not cached")
+	}
+	second := getFileInfo([]string{"g1", "a.txt"})
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("cached reply %+v, built %+v", second, first)
+	}
+	if h, m := fileInfoCache.hits.Load()-hits, fileInfoCache.misses.Load()-misses; h != 1 || m != 1 {
+		t.Errorf("%d hits, %d misses; want 1 and 1", h, m)
+	}
+	if resp := getFileInfo([]string{"g1", "missing.txt"}); resp.Status != "error" {
+		t.Errorf("missing file: %+v", resp)
+	}
+	if _, ok := fileInfoCache.entries.Load("g1:missing.txt"); ok {
+		t.Error("error reply cached")
+	}
+}
+
+// TestFileInfoCache_StaleWithinTTL checks a change the cache isn't told
+// about shows only once the entry expires.
+func TestFileInfoCache_StaleWithinTTL(t *testing.T) {
+	resetGroupState(t, "alice")
+	now := useFileInfoClock(t)
+	seedUsers("alice")
+	seedFiles(t, "a.txt")
+	fileInfoPeers(t, "a.txt")
+
+	mu.Lock()
+	users["alice"].Addr = "127.0.0.1:7000"
+	mu.Unlock()
+	*now = now.Add(fileInfoCacheTTL - time.Second)
+	if got := fileInfoPeers(t, "a.txt"); len(got) != 1 || got[0] != "127.0.0.1:6000" {
+		t.Errorf("peers within TTL = %v, want the cached address", got)
+	}
+	*now = now.Add(time.Second)
+	if got := fileInfoPeers(t, "a.txt"); len(got) != 1 || got[0] != "127.0.0.1:7000" {
+		t.Errorf("peers after TTL = %v, want the new address", got)
+	}
+}
+
+// TestFileInfoCache_Invalidated checks stop_sharing, add_seeder, login and
+// deleting the file drop the cached reply at once.
+func TestFileInfoCache_Invalidated(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	useFileInfoClock(t)
+	seedUsers("alice", "bob")
+	seedFiles(t, "a.txt", "b.txt")
+
+	fileInfoPeers(t, "a.txt")
+	if resp := addSeeder([]string{"g1", "a.txt", "bob"}); resp.Status != "ok" {
+		t.Fatalf("add_seeder: %+v", resp)
+	}
+	if got := fileInfoPeers(t, "a.txt"); len(got) != 2 {
+		t.Errorf("peers after add_seeder = %v", got)
+	}
+
+	if resp := stopSharing([]string{"g1", "a.txt", "bob"}); resp.Status != "ok" {
+		t.Fatalf("stop_sharing: %+v", resp)
+	}
+	if got := fileInfoPeers(t, "a.txt"); len(got) != 1 || got[0] != "127.0.0.1:6000" {
+		t.Errorf("peers after stop_sharing = %v", got)
+	}
+
+	mu.Lock()
+	users["alice"].Password = "pw"
+	mu.Unlock()
+	fileInfoPeers(t, "b.txt")
+	if resp := login([]string{"alice", "pw", "127.0.0.1:7000"}); resp.Status != "ok" {
+		t.Fatalf("login: %+v", resp)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if got := fileInfoPeers(t, name); len(got) != 1 || got[0] != "127.0.0.1:7000" {
+			t.Errorf("%s peers after login = %v", name, got)
+		}
+	}
+
+	if resp := stopSharing([]string{"g1", "b.txt", "alice"}); resp.Status != "ok" {
+		t.Fatalf("stop_sharing: %+v", resp)
+	}
+	if resp := getFileInfo([]string{"g1", "b.txt"}); resp.Status != "error" {
+		t.Errorf("file with no seeders left: %+v", resp)
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	resetGroupState(t, "alice")
+	useFileInfoClock(t)
+	seedUsers("alice")
+	seedFiles(t, "a.txt")
+	fileInfoCache.hits.Store(0)
+	fileInfoCache.misses.Store(0)
+	getFileInfo([]string{"g1", "a.txt"})
+	getFileInfo([]string{"g1", "a.txt"})
+	getFileInfo([]string{"g1", "a.txt"})
+	getFileInfo([]string{"g1", "a.txt"})
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"tracker_file_info_cache_hits_total 3\n",
+		"tracker_file_info_cache_misses_total 1\n",
+		"tracker_file_info_cache_hit_rate 0.75\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// groupBundleVersion is the version of the GroupBundle format written by
+// export_group. import_group refuses bundles from a newer version.
+const groupBundleVersion = 1
+
+// GroupBundle is a group exported by export_group for import_group on
+// another tracker cluster: its settings, members and files. Files carry
+// their chunk hashes, not their data, which stays with the seeders.
+// Passwords aren't exported, so members must be registered on the
+// destination. Mirrors name groups of the source cluster and are left out.
+type GroupBundle struct {
+	Version    int          `json:"version"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Group      BundleGroup  `json:"group"`
+	Files      []BundleFile `json:"files"`
+}
+
+type BundleGroup struct {
+	GroupID        string   `json:"group_id"`
+	Owner          string   `json:"owner"`
+	Members        []string `json:"members"`
+	Moderators     []string `json:"moderators,omitempty"`
+	StorageQuota   int64    `json:"storage_quota,omitempty"`
+	InviteCodeHash string   `json:"invite_code_hash,omitempty"`
+}
+
+type BundleFile struct {
+	FileName    string    `json:"file_name"`
+	Uploader    string    `json:"uploader"`
+	FileSize    int64     `json:"file_size"`
+	FileHash    string    `json:"file_hash"`
+	ChunkSize   int64     `json:"chunk_size"`
+	TotalChunks int       `json:"total_chunks"`
+	Chunks      []Chunk   `json:"chunks"`
+	Owners      []string  `json:"owners"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GroupImportResult says what import_group did with a bundle.
+type GroupImportResult struct {
+	GroupID        string   `json:"group_id"`
+	Created        bool     `json:"created"`
+	MembersAdded   []string `json:"members_added"`
+	MembersSkipped []string `json:"members_skipped"` // not registered here
+	FilesAdded     []string `json:"files_added"`
+	FilesMerged    []string `json:"files_merged"`  // here already with the same hash; owners merged
+	FilesSkipped   []string `json:"files_skipped"` // a different file has the name, no owner is a member, or over quota
+}
+
+// exportGroup returns a GroupBundle of a group. Only the owner may export.
+// args: [groupID, ownerID]
+func exportGroup(args []string) Response {
+	groupID, owner := args[0], args[1]
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if g.Owner != owner {
+		return Response{"error", "not owner"}
+	}
+
+	b := GroupBundle{
+		Version:    groupBundleVersion,
+		ExportedAt: time.Now().UTC(),
+		Group: BundleGroup{
+			GroupID:        g.GroupID,
+			Owner:          g.Owner,
+			Members:        sortedKeys(g.Members),
+			Moderators:     slices.Clone(g.Moderators),
+			StorageQuota:   g.StorageQuota,
+			InviteCodeHash: g.InviteCodeHash,
+		},
+		Files: []BundleFile{},
+	}
+	for _, f := range groupFiles(groupID) {
+		if f.IsReference || f.isReserved() {
+			continue // shared from another group, or not uploaded yet
+		}
+		b.Files = append(b.Files, BundleFile{
+			FileName:    f.FileName,
+			Uploader:    f.Uploader,
+			FileSize:    f.FileSize,
+			FileHash:    f.FileHash,
+			ChunkSize:   f.ChunkSize,
+			TotalChunks: f.TotalChunks,
+			Chunks:      f.Chunks,
+			Owners:      sortedKeys(f.Owners),
+			CreatedAt:   f.CreatedAt,
+		})
+	}
+	sort.Slice(b.Files, func(i, j int) bool { return b.Files[i].FileName < b.Files[j].FileName })
+	return Response{"ok", b}
+}
+
+// importGroup merges a bundle from export_group into this tracker: the
+// group is created, owned by ownerID, if it doesn't exist; otherwise
+// ownerID must own it. Registered members are added and files listed,
+// keeping whatever is here already when the two differ.
+// args: [ownerID, bundleJSON]
+func importGroup(args []string) Response {
+	owner := args[0]
+	b, err := parseGroupBundle(args[1])
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := users[owner]; !ok {
+		return Response{"error", "user not found"}
+	}
+	if g, ok := groups[b.Group.GroupID]; ok && g.Owner != owner {
+		return Response{"error", "not owner"}
+	}
+
+	result, changed := mergeGroupBundle(owner, b, time.Now())
+	if !changed {
+		return Response{"ok", result}
+	}
+	g := groups[b.Group.GroupID]
+	g.Version++
+	fmt.Printf("Imported group %s: %d members and %d files added\n", g.GroupID, len(result.MembersAdded), len(result.FilesAdded))
+	go SaveState()
+	go trackerEvents.Publish(EventGroupImported, groupSync(g, "sync_import_group", args))
+	return Response{"ok", result}
+}
+
+// parseGroupBundle decodes a bundle, refusing ones this tracker can't read.
+func parseGroupBundle(data string) (*GroupBundle, error) {
+	var b GroupBundle
+	if err := json.Unmarshal([]byte(data), &b); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	if b.Version < 1 || b.Version > groupBundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	if b.Group.GroupID == "" || isCanaryKey(b.Group.GroupID) {
+		return nil, fmt.Errorf("invalid bundle: bad group ID %q", b.Group.GroupID)
+	}
+	return &b, nil
+}
+
+// mergeGroupBundle adds b to the state for import_group and its sync,
+// reporting what it did and whether anything changed. Merging the same
+// bundle again changes nothing. Caller must hold mu.
+func mergeGroupBundle(owner string, b *GroupBundle, now time.Time) (GroupImportResult, bool) {
+	result := GroupImportResult{
+		GroupID: b.Group.GroupID, MembersAdded: []string{}, MembersSkipped: []string{},
+		FilesAdded: []string{}, FilesMerged: []string{}, FilesSkipped: []string{},
+	}
+	g, ok := groups[b.Group.GroupID]
+	if !ok {
+		g = &Group{
+			GroupID:        b.Group.GroupID,
+			Owner:          owner,
+			Members:        map[string]bool{owner: true},
+			Pending:        make(map[string]bool),
+			StorageQuota:   b.Group.StorageQuota,
+			InviteCodeHash: b.Group.InviteCodeHash,
+		}
+		groups[g.GroupID] = g
+		result.Created = true
+	}
+
+	for _, userID := range append([]string{b.Group.Owner}, b.Group.Members...) {
+		if _, registered := users[userID]; !registered || isCanaryKey(userID) {
+			if !slices.Contains(result.MembersSkipped, userID) {
+				result.MembersSkipped = append(result.MembersSkipped, userID)
+			}
+			continue
+		}
+		if !g.Members[userID] {
+			commitAccept(g, userID, now)
+			result.MembersAdded = append(result.MembersAdded, userID)
+		}
+	}
+	moderatorsAdded := false
+	for _, userID := range b.Group.Moderators {
+		if g.Members[userID] && userID != g.Owner && !slices.Contains(g.Moderators, userID) {
+			g.Moderators = append(g.Moderators, userID)
+			moderatorsAdded = true
+		}
+	}
+
+	for _, bf := range b.Files {
+		key := g.GroupID + ":" + bf.FileName
+		owners := make(map[string]bool)
+		for _, userID := range bf.Owners {
+			if g.Members[userID] {
+				owners[userID] = true
+			}
+		}
+		f, exists := files[key]
+		switch {
+		case len(owners) == 0:
+			result.FilesSkipped = append(result.FilesSkipped, bf.FileName)
+		case exists && (f.FileHash != bf.FileHash || f.IsReference || f.isReserved()):
+			result.FilesSkipped = append(result.FilesSkipped, bf.FileName)
+		case exists:
+			added := false
+			for userID := range owners {
+				if !f.Owners[userID] {
+					f.Owners[userID] = true
+					added = true
+				}
+			}
+			if added {
+				f.Version++
+				f.UpdatedAt = now.UTC()
+				fileInfoCache.Invalidate(key)
+				result.FilesMerged = append(result.FilesMerged, bf.FileName)
+			}
+		case g.StorageQuota > 0 && groupStorageUsed(g.GroupID)+bf.FileSize > g.StorageQuota:
+			result.FilesSkipped = append(result.FilesSkipped, bf.FileName)
+		default:
+			putFile(key, &File{
+				FileName:    bf.FileName,
+				GroupID:     g.GroupID,
+				Uploader:    bf.Uploader,
+				FileSize:    bf.FileSize,
+				FileHash:    bf.FileHash,
+				ChunkSize:   bf.ChunkSize,
+				TotalChunks: bf.TotalChunks,
+				Chunks:      bf.Chunks,
+				Owners:      owners,
+				Version:     1,
+				CreatedAt:   bf.CreatedAt,
+				UpdatedAt:   now.UTC(),
+			})
+			delete(tombstones, key)
+			result.FilesAdded = append(result.FilesAdded, bf.FileName)
+		}
+	}
+
+	changed := result.Created || moderatorsAdded || len(result.MembersAdded) > 0 ||
+		len(result.FilesAdded) > 0 || len(result.FilesMerged) > 0
+	return result, changed
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
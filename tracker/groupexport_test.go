@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// exportJSON exports g1 as alice and returns the bundle as import_group
+// takes it.
+func exportJSON(t *testing.T) string {
+	t.Helper()
+	resp := exportGroup([]string{"g1", "alice"})
+	if resp.Status != "ok" {
+		t.Fatalf("export_group: %+v", resp)
+	}
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// bundleJSON marshals b as import_group takes it.
+func bundleJSON(t *testing.T, b GroupBundle) string {
+	t.Helper()
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// importResult runs import_group and returns its result.
+func importResult(t *testing.T, owner, bundle string) GroupImportResult {
+	t.Helper()
+	resp := importGroup([]string{owner, bundle})
+	result, ok := resp.Data.(GroupImportResult)
+	if resp.Status != "ok" || !ok {
+		t.Fatalf("import_group: %+v", resp)
+	}
+	return result
+}
+
+// TestGroupBundle_RoundTrip exports a group, imports it into an empty
+// tracker and checks exporting it there gives the same bundle.
+func TestGroupBundle_RoundTrip(t *testing.T) {
+	resetGroupState(t, "alice", "bob", "carol")
+	seedUsers("alice", "bob", "carol")
+	seedFiles(t, "a.txt", "b.txt")
+	mu.Lock()
+	groups["g1"].Moderators = []string{"bob"}
+	groups["g1"].StorageQuota = 1 << 20
+	files["g1:a.txt"].Owners["carol"] = true
+	files["g1:b.txt"].Chunks = []Chunk{{Index: 0, Hash: "chunk-b"}}
+	mu.Unlock()
+	bundle := exportJSON(t)
+
+	mu.Lock()
+	groups = make(map[string]*Group)
+	replaceFiles(make(map[string]*File))
+	mu.Unlock()
+	result := importResult(t, "alice", bundle)
+	want := GroupImportResult{
+		GroupID: "g1", Created: true,
+		MembersAdded: []string{"bob", "carol"}, MembersSkipped: []string{},
+		FilesAdded: []string{"a.txt", "b.txt"}, FilesMerged: []string{}, FilesSkipped: []string{},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("import_group = %+v, want %+v", result, want)
+	}
+
+	var before, after GroupBundle
+	json.Unmarshal([]byte(bundle), &before)
+	json.Unmarshal([]byte(exportJSON(t)), &after)
+	before.ExportedAt, after.ExportedAt = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("exported again:\n got %+v\nwant %+v", after, before)
+	}
+}
+
+// TestGroupBundle_Merge imports into a group that exists already and
+// checks what is here is kept, what is missing is added, and importing
+// the same bundle again changes nothing.
+func TestGroupBundle_Merge(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedUsers("alice", "bob", "carol", "dave")
+	seedFiles(t, "a.txt", "b.txt")
+	bundle := bundleJSON(t, GroupBundle{
+		Version: groupBundleVersion,
+		Group: BundleGroup{
+			GroupID: "g1", Owner: "olga",
+			Members:    []string{"olga", "bob", "carol", "dave", "zed"},
+			Moderators: []string{"carol", "zed"},
+		},
+		Files: []BundleFile{
+			{FileName: "a.txt", FileSize: 10, FileHash: "other-hash", Owners: []string{"carol"}},
+			{FileName: "b.txt", FileSize: 10, FileHash: "hash-b.txt", Owners: []string{"olga", "dave"}},
+			{FileName: "c.txt", FileSize: 10, FileHash: "hash-c.txt", Owners: []string{"carol"}},
+			{FileName: "d.txt", FileSize: 10, FileHash: "hash-d.txt", Owners: []string{"zed"}},
+		},
+	})
+
+	result := importResult(t, "alice", bundle)
+	want := GroupImportResult{
+		GroupID:      "g1",
+		MembersAdded: []string{"carol", "dave"}, MembersSkipped: []string{"olga", "zed"},
+		FilesAdded: []string{"c.txt"}, FilesMerged: []string{"b.txt"}, FilesSkipped: []string{"a.txt", "d.txt"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("import_group = %+v, want %+v", result, want)
+	}
+	mu.RLock()
+	g := groups["g1"]
+	if g.Owner != "alice" || len(g.Members) != 4 || !reflect.DeepEqual(g.Moderators, []string{"carol"}) {
+		t.Errorf("group: owner %s, members %v, moderators %v", g.Owner, g.Members, g.Moderators)
+	}
+	if f := files["g1:a.txt"]; f.FileHash != "hash-a.txt" || f.Owners["carol"] {
+		t.Errorf("a.txt replaced: %+v", f)
+	}
+	if f := files["g1:b.txt"]; !reflect.DeepEqual(f.Owners, map[string]bool{"alice": true, "dave": true}) {
+		t.Errorf("b.txt owners %v", f.Owners)
+	}
+	version := g.Version
+	mu.RUnlock()
+
+	result = importResult(t, "alice", bundle)
+	if len(result.MembersAdded)+len(result.FilesAdded)+len(result.FilesMerged) != 0 {
+		t.Errorf("importing again: %+v", result)
+	}
+	mu.RLock()
+	if g.Version != version {
+		t.Errorf("importing again changed the version from %d to %d", version, g.Version)
+	}
+	mu.RUnlock()
+}
+
+// TestGroupBundle_Quota checks files that would take the group over its
+// quota are skipped.
+func TestGroupBundle_Quota(t *testing.T) {
+	resetGroupState(t, "alice")
+	seedUsers("alice")
+	mu.Lock()
+	groups["g1"].StorageQuota = 25
+	mu.Unlock()
+	seedFiles(t, "a.txt")
+	bundle := bundleJSON(t, GroupBundle{
+		Version: groupBundleVersion,
+		Group:   BundleGroup{GroupID: "g1", Owner: "alice", Members: []string{"alice"}},
+		Files: []BundleFile{
+			{FileName: "b.txt", FileSize: 10, FileHash: "hash-b.txt", Owners: []string{"alice"}},
+			{FileName: "c.txt", FileSize: 10, FileHash: "hash-c.txt", Owners: []string{"alice"}},
+		},
+	})
+	result := importResult(t, "alice", bundle)
+	if !reflect.DeepEqual(result.FilesAdded, []string{"b.txt"}) || !reflect.DeepEqual(result.FilesSkipped, []string{"c.txt"}) {
+		t.Errorf("added %v, skipped %v", result.FilesAdded, result.FilesSkipped)
+	}
+}
+
+func TestGroupBundle_Refused(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedUsers("alice", "bob")
+	bundle := exportJSON(t)
+
+	if resp := exportGroup([]string{"g1", "bob"}); resp.Status != "error" || resp.Data != "not owner" {
+		t.Errorf("export_group by a member: %+v", resp)
+	}
+	if resp := exportGroup([]string{"g9", "alice"}); resp.Status != "error" {
+		t.Errorf("export_group of a missing group: %+v", resp)
+	}
+	if resp := importGroup([]string{"bob", bundle}); resp.Status != "error" || resp.Data != "not owner" {
+		t.Errorf("import_group into a group bob doesn't own: %+v", resp)
+	}
+	if resp := importGroup([]string{"zed", bundle}); resp.Status != "error" {
+		t.Errorf("import_group by an unregistered user: %+v", resp)
+	}
+	for _, bad := range []string{
+		`{"version":2,"group":{"group_id":"g1"}}`,
+		`{"version":0,"group":{"group_id":"g1"}}`,
+		`{"version":1,"group":{"group_id":""}}`,
+		`not json`,
+	} {
+		if resp := importGroup([]string{"alice", bad}); resp.Status != "error" {
+			t.Errorf("import_group(%s) = %+v", bad, resp)
+		}
+	}
+}
+
+// TestGroupBundle_Sync checks one sync message carries an import to a
+// peer tracker.
+func TestGroupBundle_Sync(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedUsers("alice", "bob")
+	seedFiles(t, "a.txt")
+	bundle := exportJSON(t)
+	mu.Lock()
+	groups = make(map[string]*Group)
+	replaceFiles(make(map[string]*File))
+	mu.Unlock()
+	saved := trackerEvents
+	trackerEvents = NewEventBus()
+	t.Cleanup(func() { trackerEvents = saved })
+	published := make(chan Message, 1)
+	trackerEvents.Subscribe(EventGroupImported, func(msg Message) { published <- msg })
+
+	importResult(t, "alice", bundle)
+	var msg Message
+	select {
+	case msg = <-published:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no sync message")
+	}
+	if msg.Cmd != "sync_import_group" || msg.Version != 1 {
+		t.Errorf("sync message %s, version %d", msg.Cmd, msg.Version)
+	}
+
+	// A peer tracker without the group
+	mu.Lock()
+	groups = make(map[string]*Group)
+	replaceFiles(make(map[string]*File))
+	mu.Unlock()
+	if resp := applySync(msg); resp.Status != "ok" {
+		t.Fatalf("applySync: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	g := groups["g1"]
+	if g == nil || g.Owner != "alice" || !g.Members["bob"] || g.Version != 1 || files["g1:a.txt"] == nil {
+		t.Errorf("peer has group %+v, files %v", g, files)
+	}
+}
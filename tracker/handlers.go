@@ -2,11 +2,21 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"p2p/common"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func createUser(args []string) Response {
 	user, pass := args[0], args[1]
+	if isCanaryKey(user) {
+		return Response{"error", "user name is reserved"}
+	}
 
 	mu.Lock()
 	defer mu.Unlock()
@@ -15,14 +25,16 @@ func createUser(args []string) Response {
 		return Response{"error", "user exists"}
 	}
 
-	users[user] = &User{
+	u := &User{
 		UserID:   user,
 		Password: pass,
+		Version:  1,
 	}
+	users[user] = u
 
 	fmt.Printf("A user with username %s has been created. ", args[0])
 	go SaveState() // Persist asynchronously
-	go broadcastToTrackers("sync_create_user", []string{user, pass})
+	go trackerEvents.Publish(EventUserCreated, Message{Cmd: "sync_create_user", Args: []string{user, pass}, Version: u.Version, Hash: userHash(u)})
 	return Response{"ok", "user created"}
 }
 
@@ -38,6 +50,8 @@ func login(args []string) Response {
 	}
 	u.LoggedIn = true
 	u.Addr = addr
+	clearUnavailable(user)
+	fileInfoCache.InvalidateOwner(user)
 
 	fmt.Printf("user with username = %s has logged in successfully. ", args[0])
 	go SaveState() // Persist asynchronously
@@ -60,13 +74,31 @@ func updateAddress(args []string) Response {
 	}
 
 	u.Addr = addr
+	fileInfoCache.InvalidateOwner(user)
 	fmt.Printf("Updated address for %s to %s\n", user, addr)
 	go SaveState() // Persist asynchronously
 	return Response{"ok", "address updated"}
 }
 
+// createGroup args: [groupID, userID, quotaBytes (optional), inviteCode (optional)]
 func createGroup(args []string) Response {
 	groupID, user := args[0], args[1]
+	if isCanaryKey(groupID) {
+		return Response{"error", "group ID is reserved"}
+	}
+
+	var quota int64
+	if len(args) >= 3 && args[2] != "" {
+		q, err := parseQuota(args[2])
+		if err != nil {
+			return Response{"error", err.Error()}
+		}
+		quota = q
+	}
+	var inviteHash string
+	if len(args) >= 4 && args[3] != "" {
+		inviteHash = hashInviteCode(args[3])
+	}
 
 	mu.Lock()
 	defer mu.Unlock()
@@ -75,15 +107,20 @@ func createGroup(args []string) Response {
 		return Response{"error", "group exists"}
 	}
 
-	groups[groupID] = &Group{
-		GroupID: groupID,
-		Owner:   user,
-		Members: map[string]bool{user: true},
-		Pending: make(map[string]bool),
+	g := &Group{
+		GroupID:      groupID,
+		Owner:        user,
+		Members:      map[string]bool{user: true},
+		Pending:      make(map[string]bool),
+		StorageQuota: quota,
+		Version:      1,
+
+		InviteCodeHash: inviteHash,
 	}
+	groups[groupID] = g
 	fmt.Printf("A group with group name = %s and group owner = %s has been created. ", groupID, user)
 	go SaveState() // Persist asynchronously
-	go broadcastToTrackers("sync_create_group", []string{groupID, user})
+	go trackerEvents.Publish(EventGroupCreated, groupSync(g, "sync_create_group", []string{groupID, user, strconv.FormatInt(quota, 10), inviteHash}))
 	return Response{"ok", map[string]string{
 		"group_id": groupID,
 		"owner":    user,
@@ -91,6 +128,93 @@ func createGroup(args []string) Response {
 	}}
 }
 
+// setGroupQuota changes a group's storage quota. Only the owner may do this.
+// args: [groupID, ownerID, bytes]; 0 removes the limit
+func setGroupQuota(args []string) Response {
+	if len(args) < 3 {
+		return Response{"error", "set_group_quota: need groupID, ownerID, bytes"}
+	}
+	groupID, owner := args[0], args[1]
+	quota, err := parseQuota(args[2])
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if g.Owner != owner {
+		return Response{"error", "not owner"}
+	}
+
+	g.StorageQuota = quota
+	g.Version++
+	fmt.Printf("Quota for group %s set to %d bytes\n", groupID, quota)
+	go SaveState()
+	go trackerEvents.Publish(EventGroupQuotaSet, groupSync(g, "sync_set_group_quota", []string{groupID, args[2]}))
+	return Response{"ok", "quota updated"}
+}
+
+// getGroupInfo returns a group's owner, members and storage usage against its quota.
+// args: [groupID]
+func getGroupInfo(args []string) Response {
+	if len(args) < 1 {
+		return Response{"error", "get_group_info: need groupID"}
+	}
+	groupID := args[0]
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+
+	members := make([]string, 0, len(g.Members))
+	for m := range g.Members {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+
+	return Response{"ok", map[string]interface{}{
+		"group_id":      g.GroupID,
+		"owner":         g.Owner,
+		"members":       members,
+		"storage_used":  groupStorageUsed(groupID),
+		"storage_quota": g.StorageQuota,
+		"private":       g.isPrivate(),
+	}}
+}
+
+// groupStorageUsed sums the sizes of all files in a group, counting slots
+// reserved for uploads still running. Caller must hold mu.
+func groupStorageUsed(groupID string) int64 {
+	var used int64
+	now := time.Now()
+	for _, f := range groupFiles(groupID) {
+		if f.isReserved() && !f.reservedAt(now) {
+			continue // expired reservation, not pruned yet
+		}
+		used += f.FileSize
+	}
+	return used
+}
+
+// parseQuota parses a non-negative byte count.
+func parseQuota(s string) (int64, error) {
+	quota, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || quota < 0 {
+		return 0, fmt.Errorf("invalid quota %q: must be a non-negative number of bytes", s)
+	}
+	return quota, nil
+}
+
+// joinGroup args: [groupID, userID, inviteCode (private groups only)]
 func joinGroup(args []string) Response {
 	groupID, userID := args[0], args[1]
 
@@ -101,9 +225,13 @@ func joinGroup(args []string) Response {
 	if !ok {
 		return Response{"error", "group not found"}
 	}
+	if g.isPrivate() && (len(args) < 3 || !checkInviteCode(g.InviteCodeHash, args[2])) {
+		return Response{"error", "invalid invite code"}
+	}
 
 	g.Pending[userID] = true
-	go broadcastToTrackers("sync_join_group", []string{groupID, userID})
+	g.Version++
+	go trackerEvents.Publish(EventGroupJoined, groupSync(g, "sync_join_group", []string{groupID, userID}))
 	return Response{"ok", "request sent to the group"}
 }
 
@@ -113,15 +241,54 @@ func acceptRequest(args []string) Response {
 	mu.Lock()
 	defer mu.Unlock()
 
-	g := groups[groupID]
-	if g.Owner != owner {
-		return Response{"error", "not owner"}
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if !canManageRequests(g, owner) {
+		return Response{"error", "not owner or moderator"}
+	}
+
+	commitAccept(g, userID, time.Now())
+	g.Version++
+	go trackerEvents.Publish(EventGroupAccepted, groupSync(g, "sync_accept_request", []string{groupID, userID}))
+	return Response{"ok", "request accepted successfully"}
+}
+
+// commitAccept moves userID from Pending to Members in two phases: the
+// accept is first committed to PendingHistory, and only then is the pending
+// entry removed. Commits older than acceptCommitWindow are dropped.
+// Caller must hold mu.
+func commitAccept(g *Group, userID string, now time.Time) {
+	if g.PendingHistory == nil {
+		g.PendingHistory = make(map[string]time.Time)
+	}
+	for u, at := range g.PendingHistory {
+		if now.Sub(at) > acceptCommitWindow {
+			delete(g.PendingHistory, u)
+		}
 	}
+	g.PendingHistory[userID] = now
 
 	delete(g.Pending, userID)
 	g.Members[userID] = true
-	go broadcastToTrackers("sync_accept_request", []string{groupID, userID})
-	return Response{"ok", "request accepted successfully"}
+	recordActivity(g.GroupID, ActivityJoin, userID, now)
+}
+
+// removeMember takes userID out of g's members and moderators, and forgets
+// any accept committed for it, so rejoining within acceptCommitWindow isn't
+// mistaken for a late join. Caller must hold mu.
+func removeMember(g *Group, userID string) {
+	delete(g.Members, userID)
+	dropModerator(g, userID)
+	delete(g.PendingHistory, userID)
+}
+
+// recentlyAccepted reports whether userID's join request was committed
+// within acceptCommitWindow of now. Caller must hold mu.
+func recentlyAccepted(g *Group, userID string, now time.Time) bool {
+	at, ok := g.PendingHistory[userID]
+	return ok && now.Sub(at) <= acceptCommitWindow
 }
 
 func listRequests(args []string) Response {
@@ -130,9 +297,12 @@ func listRequests(args []string) Response {
 	mu.RLock()
 	defer mu.RUnlock()
 
-	g := groups[groupID]
-	if g.Owner != userID {
-		return Response{"error", "not owner"}
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if !canManageRequests(g, userID) {
+		return Response{"error", "not owner or moderator"}
 	}
 
 	var res []string
@@ -144,6 +314,18 @@ func listRequests(args []string) Response {
 }
 
 func uploadFile(args []string) Response {
+	return storeUpload(args, nil)
+}
+
+// storeUpload adds an uploaded file to its group. synced is the
+// sync_upload_file message when the upload is replayed from a peer tracker.
+// If two trackers took an upload of the same name at once, its version and
+// hash decide, as for sync_put_file, which entry both keep. Mirroring is left to the tracker the upload came
+// in on, which syncs the mirrored entries itself, and so is counting the
+// upload for tracker_dedup_ratio.
+// A slot reserved with reserve_slot takes its token as args[7].
+func storeUpload(args []string, synced *Message) Response {
+	mirror := synced == nil
 	fileName, groupID, userID, fileSize := args[0], args[1], args[2], args[3]
 
 	// New args: fileHash and chunksJSON (optional for backward compatibility)
@@ -160,41 +342,79 @@ func uploadFile(args []string) Response {
 		}
 	}
 
-	mu.Lock()
-	defer mu.Unlock()
-
-	g, ok := groups[groupID]
-	if !ok {
-		return Response{"error", "group not found"}
+	// Optional chunk size picked by the client; older clients always used 512KB
+	chunkSize := int64(defaultChunkSize)
+	if len(args) >= 7 && args[6] != "" {
+		cs, err := strconv.ParseInt(args[6], 10, 64)
+		if err != nil || cs <= 0 {
+			return Response{"error", "invalid chunk size"}
+		}
+		chunkSize = cs
 	}
 
-	if !g.Members[userID] {
-		return Response{"error", "not a member"}
+	size, err := strconv.ParseInt(fileSize, 10, 64)
+	if err != nil || size < 0 {
+		return Response{"error", fmt.Sprintf("upload_file: invalid file size %q", fileSize)}
 	}
 
-	fileKey := groupID + ":" + fileName
-	if _, exists := files[fileKey]; exists {
-		return Response{"error", "file already exists in group"}
+	var token string
+	if len(args) >= 8 {
+		token = args[7]
 	}
 
-	var size int64
-	fmt.Sscanf(fileSize, "%d", &size)
-
-	files[fileKey] = &File{
+	file := &File{
 		FileName:    fileName,
 		GroupID:     groupID,
 		Uploader:    userID,
 		FileSize:    size,
 		FileHash:    fileHash,
-		ChunkSize:   512 * 1024,
+		ChunkSize:   chunkSize,
 		TotalChunks: len(chunks),
 		Chunks:      chunks,
-		Owners:      map[string]bool{userID: true},
 	}
 
-	fmt.Printf("File %s uploaded to group %s by user %s\n", fileName, groupID, userID)
+	mu.Lock()
+	defer mu.Unlock()
+
+	fileKey := groupID + ":" + fileName
+	reserved, err := claimReservation(fileKey, token, time.Now())
+	if errors.Is(err, errNoReservation) && !mirror {
+		// Checked by the tracker the upload came in on; this one may
+		// have expired the reservation already, or never got it
+		err = nil
+	}
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+	if reserved != nil {
+		removeFile(fileKey) // its bytes are counted again as the upload's
+	}
+	var replaced *File
+	if local, ok := files[fileKey]; ok && synced != nil && synced.Version != 0 && !local.isReserved() {
+		if !preferIncoming("file", fileKey, local.Version, fileEntryHash(local), synced.Version, synced.Hash) {
+			return Response{"ok", "already up to date"}
+		}
+		replaced = local
+		removeFile(fileKey)
+	}
+	g, err := checkUpload(file, 0)
+	if err != nil {
+		if reserved != nil {
+			putFile(fileKey, reserved)
+		} else if replaced != nil {
+			putFile(fileKey, replaced)
+		}
+		return Response{"error", err.Error()}
+	}
+	deduped := addUpload(file)
+	if mirror {
+		recordDedup(len(chunks), len(deduped))
+	} else if synced.Version != 0 {
+		file.Version = synced.Version
+	}
+
 	if len(args) >= 6 {
-		go broadcastToTrackers("sync_upload_file", args)
+		go trackerEvents.Publish(EventFileUploaded, Message{Cmd: "sync_upload_file", Args: args, Version: file.Version, Hash: fileEntryHash(file)})
 	}
 
 	responseData := map[string]interface{}{
@@ -208,6 +428,12 @@ func uploadFile(args []string) Response {
 	if fileHash != "" {
 		responseData["file_hash"] = fileHash
 		responseData["total_chunks"] = len(chunks)
+		responseData["deduplicated_chunks"] = deduped
+	}
+	if mirror {
+		if mirrored := mirrorUpload(g, file, userID); len(mirrored) > 0 {
+			responseData["mirrored_to"] = mirrored
+		}
 	}
 
 	go SaveState() // Persist asynchronously
@@ -215,6 +441,56 @@ func uploadFile(args []string) Response {
 	return Response{"ok", responseData}
 }
 
+// checkUpload returns f's group if f.Uploader may add f to it: the uploader
+// is a member, the name is free and the group's quota has room for f on top
+// of pending bytes not stored yet. Caller must hold mu.
+func checkUpload(f *File, pending int64) (*Group, error) {
+	g, ok := groups[f.GroupID]
+	if !ok {
+		return nil, errors.New("group not found")
+	}
+
+	if !g.Members[f.Uploader] {
+		return nil, errors.New("not a member")
+	}
+
+	if err := checkSlotFree(f.GroupID+":"+f.FileName, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if g.StorageQuota > 0 {
+		if used := groupStorageUsed(f.GroupID) + pending; used+f.FileSize > g.StorageQuota {
+			return nil, fmt.Errorf("group storage quota exceeded: %d of %d bytes used, file is %d bytes",
+				used, g.StorageQuota, f.FileSize)
+		}
+	}
+	return g, nil
+}
+
+// addUpload stores a new file checked by checkUpload, owned by its
+// uploader, and returns the hashes of its chunks markDeduplicated found
+// registered already. Caller must hold mu.
+func addUpload(f *File) []string {
+	deduped := markDeduplicated(f)
+	now := time.Now().UTC()
+	f.Owners = map[string]bool{f.Uploader: true}
+	f.Version = 1
+	f.CreatedAt, f.UpdatedAt = now, now
+
+	fileKey := f.GroupID + ":" + f.FileName
+	putFile(fileKey, f)
+	delete(tombstones, fileKey) // re-uploaded after being deleted
+
+	recordActivity(f.GroupID, ActivityUpload, f.Uploader, now)
+	fmt.Printf("File %s uploaded to group %s by user %s\n", f.FileName, f.GroupID, f.Uploader)
+	return deduped
+}
+
+// listFiles lists a group's files.
+// args: [groupID, userID (optional), pageSize (optional), pageToken (optional)]
+// Given a page size or token the answer is one page, oldest uploads first:
+// {"files": [...], "next_page_token": "..."}, with an empty token on the
+// last page. Otherwise every file is returned as a plain list.
 func listFiles(args []string) Response {
 	groupID := args[0]
 
@@ -223,6 +499,17 @@ func listFiles(args []string) Response {
 	if len(args) >= 2 {
 		requestingUser = args[1]
 	}
+	paged := len(args) >= 3
+	pageSize, pageToken := defaultPageSize, ""
+	if paged {
+		var err error
+		if pageSize, err = parsePageSize(args[2]); err != nil {
+			return Response{"error", err.Error()}
+		}
+		if len(args) >= 4 {
+			pageToken = args[3]
+		}
+	}
 
 	mu.RLock()
 	defer mu.RUnlock()
@@ -237,15 +524,24 @@ func listFiles(args []string) Response {
 		return Response{"error", "not a member of this group"}
 	}
 
+	if paged {
+		page, next, err := pageFiles(groupFiles(groupID), pageSize, pageToken)
+		if err != nil {
+			return Response{"error", err.Error()}
+		}
+		fileList := make([]map[string]interface{}, 0, len(page))
+		for _, file := range page {
+			fileList = append(fileList, fileListEntry(file))
+		}
+		return Response{"ok", map[string]interface{}{"files": fileList, "next_page_token": next}}
+	}
+
 	var fileList []map[string]interface{}
-	for _, file := range files {
-		if file.GroupID == groupID {
-			fileList = append(fileList, map[string]interface{}{
-				"file_name": file.FileName,
-				"file_size": file.FileSize,
-				"uploader":  file.Uploader,
-			})
+	for _, file := range groupFiles(groupID) {
+		if file.isReserved() {
+			continue // its upload hasn't finished
 		}
+		fileList = append(fileList, fileListEntry(file))
 	}
 
 	if len(fileList) == 0 {
@@ -255,10 +551,25 @@ func listFiles(args []string) Response {
 	return Response{"ok", fileList}
 }
 
+// fileListEntry is how list_files shows a file.
+func fileListEntry(file *File) map[string]interface{} {
+	return map[string]interface{}{
+		"file_name":    file.FileName,
+		"file_size":    file.FileSize,
+		"uploader":     file.Uploader,
+		"is_reference": file.IsReference,
+		"unavailable":  file.Unavailable,
+	}
+}
+
 // getFileInfo returns file metadata including chunks and peer list.
-// If args[2] (requesting userID) is provided, membership is enforced.
+// If args[2] (requesting userID) is provided, membership is enforced and,
+// with P2P_PEER_SECRET set, the reply carries a token the file's seeders
+// accept from that user for common.PeerTokenTTL. Replies are served from
+// fileInfoCache for up to fileInfoCacheTTL.
 func getFileInfo(args []string) Response {
 	groupID, fileName := args[0], args[1]
+	fileKey := groupID + ":" + fileName
 
 	mu.RLock()
 	defer mu.RUnlock()
@@ -275,13 +586,31 @@ func getFileInfo(args []string) Response {
 		}
 	}
 
-	fileKey := groupID + ":" + fileName
-	file, ok := files[fileKey]
+	info, ok := fileInfoCache.Get(fileKey)
 	if !ok {
-		return Response{"error", "file not found"}
+		file, ok := files[fileKey]
+		if !ok {
+			return Response{"error", "file not found"}
+		}
+		if file.isReserved() {
+			return Response{"error", "file is reserved; its upload hasn't finished"}
+		}
+		info = buildFileInfo(file)
+		fileInfoCache.Put(fileKey, info)
+	}
+	if secret := common.PeerSecret(); secret != nil && len(args) >= 3 && args[2] != "" {
+		fileHash, _ := info["file_hash"].(string)
+		expiry := time.Now().Add(common.PeerTokenTTL)
+		info = maps.Clone(info) // the cached reply is shared
+		info["token"] = common.SignPeerToken(secret, args[2], fileHash, expiry)
+		info["token_expires"] = expiry.UTC().Format(time.RFC3339)
 	}
+	return Response{"ok", info}
+}
 
-	return Response{"ok", map[string]interface{}{
+// buildFileInfo returns get_file_info's reply for file. Caller must hold mu.
+func buildFileInfo(file *File) map[string]interface{} {
+	info := map[string]interface{}{
 		"file_name":    file.FileName,
 		"file_hash":    file.FileHash,
 		"file_size":    file.FileSize,
@@ -289,32 +618,86 @@ func getFileInfo(args []string) Response {
 		"total_chunks": file.TotalChunks,
 		"chunks":       file.Chunks,
 		"peers":        getPeerAddresses(file.Owners),
-	}}
+		"is_reference": file.IsReference,
+	}
+	if root := common.MerkleRoot(chunkHashes(file.Chunks, file.TotalChunks)); root != "" {
+		info["merkle_root"] = root
+	}
+	if len(file.ReplicationStatus) > 0 {
+		info["replication_status"] = maps.Clone(file.ReplicationStatus)
+	}
+	return info
 }
 
-// getPeerAddresses returns addresses of logged-in users who own the file
+// chunkHashes returns the hashes of a file's total chunks in index order,
+// nil if one is missing.
+func chunkHashes(chunks []Chunk, total int) []string {
+	hashes := make([]string, total)
+	for _, c := range chunks {
+		if c.Index < 0 || c.Index >= total {
+			return nil
+		}
+		hashes[c.Index] = c.Hash
+	}
+	for _, h := range hashes {
+		if h == "" {
+			return nil
+		}
+	}
+	return hashes
+}
+
+// getPeerAddresses returns addresses of logged-in users who own the file,
+// leaving out those marked suspicious by report_corrupt_seeder
 func getPeerAddresses(owners map[string]bool) []string {
 	var addrs []string
 	for userID := range owners {
-		if user, ok := users[userID]; ok && user.LoggedIn {
+		if user, ok := users[userID]; ok && user.LoggedIn && !user.Suspicious {
 			addrs = append(addrs, user.Addr)
 		}
 	}
 	return addrs
 }
 
-// listGroups returns all group IDs in the network
-func listGroups(args []string) Response {
+// getPeerAddress returns the peer address of targetUserID, so a member can
+// push chunks to another member of the same group.
+// args: [groupID, userID, targetUserID]
+func getPeerAddress(args []string) Response {
+	groupID, userID, target := args[0], args[1], args[2]
+
 	mu.RLock()
 	defer mu.RUnlock()
 
-	if len(groups) == 0 {
-		return Response{"ok", "no groups found"}
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if !g.Members[userID] || !g.Members[target] {
+		return Response{"error", "both users must be members of the group"}
+	}
+	u, ok := users[target]
+	if !ok || !u.LoggedIn || u.Addr == "" {
+		return Response{"error", "user is not online"}
 	}
+	return Response{"ok", map[string]interface{}{"user_id": target, "peer_addr": u.Addr}}
+}
+
+// listGroups returns all group IDs in the network.
+// args: ["public"] (optional) leaves out private groups
+func listGroups(args []string) Response {
+	publicOnly := len(args) > 0 && args[0] == publicFilter
+
+	mu.RLock()
+	defer mu.RUnlock()
 
 	var groupList []string
-	for groupID := range groups {
-		groupList = append(groupList, groupID)
+	for groupID, g := range groups {
+		if !isCanaryKey(groupID) && !(publicOnly && g.isPrivate()) {
+			groupList = append(groupList, groupID)
+		}
+	}
+	if len(groupList) == 0 {
+		return Response{"ok", "no groups found"}
 	}
 
 	return Response{"ok", groupList}
@@ -329,23 +712,30 @@ func stopSharing(args []string) Response {
 
 	fileKey := groupID + ":" + fileName
 	file, ok := files[fileKey]
-	if !ok {
+	if !ok || file.isReserved() {
 		return Response{"error", "file not found"}
 	}
 
 	// Remove user from owners
+	before := cloneFile(file)
 	delete(file.Owners, userID)
+	fileInfoCache.Invalidate(fileKey)
 
 	// If no owners left, delete file metadata
 	if len(file.Owners) == 0 {
-		delete(files, fileKey)
+		buryFile(fileKey)
+		if !file.IsReference {
+			promoteReferences(file.FileHash)
+		}
 		fmt.Printf("File %s removed from group %s (no owners left)\n", fileName, groupID)
-		go broadcastToTrackers("sync_stop_sharing", args)
+		go trackerEvents.Publish(EventFileUnshared, Message{Cmd: "sync_stop_sharing", Args: args})
 		return Response{"ok", "file removed from tracker (no owners)"}
 	}
 
+	file.Version++
+	file.UpdatedAt = time.Now().UTC()
 	fmt.Printf("User %s stopped sharing %s in group %s\n", userID, fileName, groupID)
-	go broadcastToTrackers("sync_stop_sharing", args)
+	go broadcastFilePatch(fileKey, before, cloneFile(file))
 	return Response{"ok", "stopped sharing"}
 }
 
@@ -370,13 +760,191 @@ func leaveGroup(args []string) Response {
 		return Response{"error", "not a member"}
 	}
 
-	delete(g.Members, userID)
+	removeMember(g, userID)
+	g.Version++
 	fmt.Printf("User %s left group %s\n", userID, groupID)
-	go broadcastToTrackers("sync_leave_group", args)
+	go trackerEvents.Publish(EventGroupLeft, groupSync(g, "sync_leave_group", args))
 	go SaveState()
 	return Response{"ok", "left group"}
 }
 
+// renameGroup moves a group, and every file shared in it, to a new group ID.
+// Only the owner may rename. args: [groupID, newGroupID, ownerID]
+func renameGroup(args []string) Response {
+	if len(args) < 3 {
+		return Response{"error", "rename_group: need groupID, newGroupID, ownerID"}
+	}
+	groupID, newGroupID, owner := args[0], args[1], args[2]
+	if newGroupID == "" {
+		return Response{"error", "new group ID must not be empty"}
+	}
+	if isCanaryKey(newGroupID) {
+		return Response{"error", "group ID is reserved"}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if g.Owner != owner {
+		return Response{"error", "not owner"}
+	}
+	if err := renameGroupLocked(groupID, newGroupID); err != nil {
+		return Response{"error", err.Error()}
+	}
+
+	g.Version++
+	fmt.Printf("Group %s renamed to %s by %s\n", groupID, newGroupID, owner)
+	go SaveState()
+	go trackerEvents.Publish(EventGroupRenamed, groupSync(g, "sync_rename_group", []string{groupID, newGroupID}))
+	return Response{"ok", "group renamed"}
+}
+
+// renameGroupLocked re-keys groupID and its files under newGroupID. Either
+// everything moves or, if any file can't be moved, nothing does.
+// Caller must hold mu.
+func renameGroupLocked(groupID, newGroupID string) error {
+	g, ok := groups[groupID]
+	if !ok {
+		return fmt.Errorf("group %s not found", groupID)
+	}
+	if _, exists := groups[newGroupID]; exists {
+		return fmt.Errorf("group %s already exists", newGroupID)
+	}
+
+	prefix := groupID + ":"
+	var oldKeys []string
+	for name := range groupFiles(groupID) {
+		if key := prefix + name; files[key] != nil {
+			oldKeys = append(oldKeys, key)
+		}
+	}
+	sort.Strings(oldKeys)
+
+	// Move files one by one, keeping what was done so it can be undone
+	moved := make(map[string]string, len(oldKeys)) // new key -> old key
+	rollback := func() {
+		for newKey, oldKey := range moved {
+			f := files[newKey]
+			removeFile(newKey)
+			f.GroupID = groupID
+			f.Version--
+			putFile(oldKey, f)
+		}
+	}
+	for _, oldKey := range oldKeys {
+		newKey := newGroupID + ":" + strings.TrimPrefix(oldKey, prefix)
+		if _, exists := files[newKey]; exists {
+			rollback()
+			return fmt.Errorf("cannot move %s: %s already exists", oldKey, newKey)
+		}
+		f := files[oldKey]
+		removeFile(oldKey)
+		f.GroupID = newGroupID
+		f.Version++
+		putFile(newKey, f)
+		moved[newKey] = oldKey
+	}
+
+	now := time.Now().UTC()
+	for newKey := range moved {
+		files[newKey].UpdatedAt = now
+	}
+	// Deletions move with the group so its diff still reports them
+	for key, t := range tombstones {
+		if t.GroupID == groupID && strings.HasPrefix(key, prefix) {
+			delete(tombstones, key)
+			t.GroupID = newGroupID
+			tombstones[newGroupID+":"+t.FileName] = t
+		}
+	}
+
+	delete(groups, groupID)
+	g.GroupID = newGroupID
+	groups[newGroupID] = g
+	moveActivity(groupID, newGroupID)
+	return nil
+}
+
+// deleteGroup removes a group and every file shared in it. Only the owner
+// may delete it. The members are listed in the group.deleted event so
+// they can be told. The response lists the file hashes no file on the
+// tracker uses any more, which the owner's peer can stop serving.
+// args: [groupID, ownerID]
+func deleteGroup(args []string) Response {
+	if len(args) < 2 {
+		return Response{"error", "delete_group: need groupID, ownerID"}
+	}
+	groupID, owner := args[0], args[1]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if g.Owner != owner {
+		return Response{"error", "not owner"}
+	}
+	members := make([]string, 0, len(g.Members))
+	for m := range g.Members {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+
+	deleted, unused := deleteGroupLocked(groupID)
+	g.Version++
+	fmt.Printf("Group %s deleted by %s with %d files\n", groupID, owner, deleted)
+	go SaveState()
+	go trackerEvents.Publish(EventGroupDeleted, groupSync(g, "sync_delete_group", append([]string{groupID, owner}, members...)))
+	return Response{"ok", map[string]interface{}{
+		"files_deleted": deleted,
+		"unused_hashes": unused,
+	}}
+}
+
+// deleteGroupLocked removes groupID, its files and their tombstones. It
+// returns how many files were removed and, sorted, the hashes of those no
+// remaining file shares. References elsewhere to a removed original take
+// its place. Caller must hold mu.
+func deleteGroupLocked(groupID string) (int, []string) {
+	hashes := make(map[string]bool)
+	var keys []string
+	for name, f := range groupFiles(groupID) {
+		keys = append(keys, groupID+":"+name)
+		if f.FileHash != "" {
+			hashes[f.FileHash] = true
+		}
+	}
+	for _, key := range keys {
+		removeFile(key)
+	}
+	for key, t := range tombstones {
+		if t.GroupID == groupID {
+			delete(tombstones, key)
+		}
+	}
+	delete(groups, groupID)
+	dropActivity(groupID)
+
+	for hash := range hashes {
+		promoteReferences(hash)
+	}
+	for _, f := range files {
+		delete(hashes, f.FileHash)
+	}
+	unused := make([]string, 0, len(hashes))
+	for hash := range hashes {
+		unused = append(unused, hash)
+	}
+	sort.Strings(unused)
+	return len(keys), unused
+}
+
 // addSeeder registers an additional peer as a chunk owner for a file.
 // Called by the client after successfully downloading a file.
 // args: [groupID, fileName, userID]
@@ -391,16 +959,139 @@ func addSeeder(args []string) Response {
 
 	fileKey := groupID + ":" + fileName
 	f, ok := files[fileKey]
-	if !ok {
+	if !ok || f.isReserved() {
 		return Response{"error", "file not found"}
 	}
 	if _, isMember := groups[groupID]; !isMember {
 		return Response{"error", "group not found"}
 	}
 
-	f.Owners[userID] = true
+	// A seeder registering again changes nothing peers or get_file_diff
+	// should hear about, unless replicate_to_all was waiting on it
+	newSeeder := !f.Owners[userID]
+	status, asked := f.ReplicationStatus[userID]
+	if newSeeder || (asked && status != ReplicationSeeding) {
+		before := cloneFile(f)
+		f.Owners[userID] = true
+		if asked {
+			f.ReplicationStatus[userID] = ReplicationSeeding
+		}
+		f.Version++
+		f.UpdatedAt = time.Now().UTC()
+		go broadcastFilePatch(fileKey, before, cloneFile(f))
+	}
+	fileInfoCache.Invalidate(fileKey)
+	f.Unavailable = false
+
+	// Counted and logged after the patch so it doesn't carry them;
+	// peers get each on its own and would otherwise record it twice
+	if newSeeder {
+		f.DownloadCount++
+		go trackerEvents.Publish(EventFileDownloaded, Message{Cmd: "sync_increment_download_count", Args: []string{groupID, fileName}})
+	}
+
+	event := DownloadEvent{UserID: userID, Timestamp: time.Now().UTC()}
+	if u, ok := users[userID]; ok {
+		event.PeerAddr = u.Addr
+	}
+	f.DownloadLog = append(f.DownloadLog, event)
+	recordActivity(groupID, ActivityDownload, userID, event.Timestamp)
+	go trackerEvents.Publish(EventDownloadRecorded, Message{Cmd: "sync_log_download", Args: []string{
+		groupID, fileName, userID, event.Timestamp.Format(time.RFC3339Nano), event.PeerAddr,
+	}})
 	fmt.Printf("[seeder] %s is now seeding %s in %s\n", userID, fileName, groupID)
-	go broadcastToTrackers("sync_add_seeder", args)
 	go SaveState()
 	return Response{"ok", "registered as seeder"}
 }
+
+// getPopularFiles returns the most downloaded files across all groups.
+// args: [topN (optional, default 10)]
+func getPopularFiles(args []string) Response {
+	topN := 10
+	if len(args) >= 1 && args[0] != "" {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return Response{"error", "get_popular_files: topN must be a positive integer"}
+		}
+		topN = n
+	}
+
+	mu.RLock()
+	ranked := make([]*File, 0, len(files))
+	for _, f := range files {
+		if !isCanaryKey(f.GroupID) && !f.isReserved() {
+			ranked = append(ranked, f)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].DownloadCount != ranked[j].DownloadCount {
+			return ranked[i].DownloadCount > ranked[j].DownloadCount
+		}
+		// Stable order for ties
+		if ranked[i].GroupID != ranked[j].GroupID {
+			return ranked[i].GroupID < ranked[j].GroupID
+		}
+		return ranked[i].FileName < ranked[j].FileName
+	})
+	if len(ranked) > topN {
+		ranked = ranked[:topN]
+	}
+
+	fileList := make([]map[string]interface{}, 0, len(ranked))
+	for _, f := range ranked {
+		fileList = append(fileList, map[string]interface{}{
+			"group_id":       f.GroupID,
+			"file_name":      f.FileName,
+			"file_size":      f.FileSize,
+			"download_count": f.DownloadCount,
+		})
+	}
+	mu.RUnlock()
+
+	return Response{"ok", fileList}
+}
+
+// getDownloadLog returns up to the last 100 download events for a file,
+// oldest first. Only the group owner may read it.
+// args: [groupID, fileName, requestingUserID, since (optional, RFC3339)]
+func getDownloadLog(args []string) Response {
+	if len(args) < 3 {
+		return Response{"error", "get_download_log: need groupID, fileName, userID"}
+	}
+	groupID, fileName, userID := args[0], args[1], args[2]
+
+	var since time.Time
+	if len(args) >= 4 && args[3] != "" {
+		t, err := time.Parse(time.RFC3339, args[3])
+		if err != nil {
+			return Response{"error", fmt.Sprintf("invalid since time %q: want RFC3339", args[3])}
+		}
+		since = t
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if g.Owner != userID {
+		return Response{"error", "not owner"}
+	}
+	f, ok := files[groupID+":"+fileName]
+	if !ok {
+		return Response{"error", "file not found"}
+	}
+
+	events := make([]DownloadEvent, 0)
+	for _, e := range f.DownloadLog {
+		if !e.Timestamp.Before(since) {
+			events = append(events, e)
+		}
+	}
+	if len(events) > downloadLogPage {
+		events = events[len(events)-downloadLogPage:]
+	}
+	return Response{"ok", events}
+}
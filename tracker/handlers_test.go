@@ -0,0 +1,690 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"p2p/common"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetGroupState installs one group with the given members and no files.
+func resetGroupState(t testing.TB, members ...string) {
+	t.Helper()
+	mu.Lock()
+	g := &Group{GroupID: "g1", Owner: members[0], Members: map[string]bool{}, Pending: map[string]bool{}}
+	for _, m := range members {
+		g.Members[m] = true
+	}
+	groups = map[string]*Group{"g1": g}
+	replaceFiles(make(map[string]*File))
+	tombstones = make(map[string]*Tombstone)
+	mu.Unlock()
+}
+
+// TestGetPopularFiles_TopThree uploads five files, gives each a different
+// number of seeders and checks the top three come back in order.
+func TestGetPopularFiles_TopThree(t *testing.T) {
+	resetGroupState(t, "alice", "u1", "u2", "u3", "u4")
+
+	downloads := map[string]int{"a.txt": 1, "b.txt": 4, "c.txt": 0, "d.txt": 3, "e.txt": 2}
+	for name, n := range downloads {
+		resp := uploadFile([]string{name, "g1", "alice", "10", "hash-" + name, "[]"})
+		if resp.Status != "ok" {
+			t.Fatalf("upload %s: %+v", name, resp)
+		}
+		for i := 1; i <= n; i++ {
+			if resp := addSeeder([]string{"g1", name, fmt.Sprintf("u%d", i)}); resp.Status != "ok" {
+				t.Fatalf("add_seeder %s: %+v", name, resp)
+			}
+		}
+	}
+
+	resp := getPopularFiles([]string{"3"})
+	if resp.Status != "ok" {
+		t.Fatalf("get_popular_files: %+v", resp)
+	}
+	got := resp.Data.([]map[string]interface{})
+	want := []struct {
+		name  string
+		count int
+	}{{"b.txt", 4}, {"d.txt", 3}, {"e.txt", 2}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d files, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i]["file_name"] != w.name || got[i]["download_count"] != w.count {
+			t.Errorf("rank %d = %v (%v), want %s (%d)", i+1, got[i]["file_name"], got[i]["download_count"], w.name, w.count)
+		}
+		if got[i]["group_id"] != "g1" || got[i]["file_size"] != int64(10) {
+			t.Errorf("rank %d: unexpected group/size %v", i+1, got[i])
+		}
+	}
+}
+
+// TestAddSeeder_CountsOncePerSeeder verifies re-registering the same seeder
+// doesn't inflate the download count.
+func TestAddSeeder_CountsOncePerSeeder(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	uploadFile([]string{"a.txt", "g1", "alice", "10", "h", "[]"})
+
+	addSeeder([]string{"g1", "a.txt", "bob"})
+	addSeeder([]string{"g1", "a.txt", "bob"})
+	addSeeder([]string{"g1", "a.txt", "alice"}) // uploader already seeds
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if n := files["g1:a.txt"].DownloadCount; n != 1 {
+		t.Errorf("download count = %d, want 1", n)
+	}
+}
+
+// TestAddSeeder_RepeatUnchanged verifies a seeder registering again leaves
+// the file's version and update time alone, so it isn't synced or listed
+// by get_file_diff as modified.
+func TestAddSeeder_RepeatUnchanged(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	uploadFile([]string{"a.txt", "g1", "alice", "10", "h", "[]"})
+	addSeeder([]string{"g1", "a.txt", "bob"})
+
+	mu.RLock()
+	version, updated := files["g1:a.txt"].Version, files["g1:a.txt"].UpdatedAt
+	mu.RUnlock()
+	for _, user := range []string{"bob", "alice"} {
+		if resp := addSeeder([]string{"g1", "a.txt", user}); resp.Status != "ok" {
+			t.Fatalf("add_seeder %s: %+v", user, resp)
+		}
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if f := files["g1:a.txt"]; f.Version != version || !f.UpdatedAt.Equal(updated) {
+		t.Errorf("version %d → %d, updated %v → %v", version, f.Version, updated, f.UpdatedAt)
+	}
+}
+
+// TestGetPopularFiles_BadTopN verifies a non-numeric or non-positive limit is rejected.
+func TestGetPopularFiles_BadTopN(t *testing.T) {
+	for _, arg := range []string{"x", "0", "-2"} {
+		if resp := getPopularFiles([]string{arg}); resp.Status != "error" {
+			t.Errorf("topN %q: expected error, got %+v", arg, resp)
+		}
+	}
+}
+
+// TestSyncIncrementDownloadCount verifies peers apply the increment locally.
+func TestSyncIncrementDownloadCount(t *testing.T) {
+	f := newTestFile()
+	f.DownloadCount = 2
+	resetFiles(t, f)
+
+	if resp := applySync(Message{Cmd: "sync_increment_download_count", Args: []string{"g1", "a.txt"}}); resp.Status != "ok" {
+		t.Fatalf("sync: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if n := files["g1:a.txt"].DownloadCount; n != 3 {
+		t.Errorf("download count = %d, want 3", n)
+	}
+}
+
+// ── Group storage quota ───────────────────────────────────────────────────────
+
+// uploadSized uploads name with the given size to g1 as alice.
+func uploadSized(name string, size int) Response {
+	return uploadFile([]string{name, "g1", "alice", fmt.Sprint(size), "hash-" + name, "[]"})
+}
+
+// TestQuota_RejectsOverLimit verifies an upload that would push the group
+// past its quota is refused and not recorded.
+func TestQuota_RejectsOverLimit(t *testing.T) {
+	resetGroupState(t, "alice")
+	if resp := setGroupQuota([]string{"g1", "alice", "100"}); resp.Status != "ok" {
+		t.Fatalf("set_group_quota: %+v", resp)
+	}
+
+	if resp := uploadSized("a.txt", 60); resp.Status != "ok" {
+		t.Fatalf("first upload: %+v", resp)
+	}
+	if resp := uploadSized("b.txt", 41); resp.Status != "error" {
+		t.Fatalf("expected quota error, got %+v", resp)
+	}
+	mu.RLock()
+	_, stored := files["g1:b.txt"]
+	mu.RUnlock()
+	if stored {
+		t.Error("rejected upload must not be stored")
+	}
+}
+
+// TestQuota_ExactlyAtLimit verifies filling the quota exactly is allowed,
+// and one more byte is not.
+func TestQuota_ExactlyAtLimit(t *testing.T) {
+	resetGroupState(t, "alice")
+	setGroupQuota([]string{"g1", "alice", "100"})
+
+	if resp := uploadSized("a.txt", 40); resp.Status != "ok" {
+		t.Fatalf("a.txt: %+v", resp)
+	}
+	if resp := uploadSized("b.txt", 60); resp.Status != "ok" {
+		t.Fatalf("upload reaching the limit exactly should pass: %+v", resp)
+	}
+	if resp := uploadSized("c.txt", 1); resp.Status != "error" {
+		t.Fatalf("expected quota error once full, got %+v", resp)
+	}
+}
+
+// TestQuota_NegativeSize verifies a negative size can't be uploaded to
+// free up quota for another file.
+func TestQuota_NegativeSize(t *testing.T) {
+	resetGroupState(t, "alice")
+	setGroupQuota([]string{"g1", "alice", "100"})
+
+	if resp := uploadSized("a.txt", -1000); resp.Status != "error" {
+		t.Fatalf("negative size accepted: %+v", resp)
+	}
+	if resp := uploadFile([]string{"b.txt", "g1", "alice", "10 bytes", "hash-b", "[]"}); resp.Status != "error" {
+		t.Fatalf("non-numeric size accepted: %+v", resp)
+	}
+	if resp := uploadSized("c.txt", 101); resp.Status != "error" {
+		t.Fatalf("expected quota error, got %+v", resp)
+	}
+}
+
+// TestQuota_ZeroIsUnlimited verifies a zero quota never blocks uploads.
+func TestQuota_ZeroIsUnlimited(t *testing.T) {
+	resetGroupState(t, "alice")
+	if resp := uploadSized("big.bin", 1<<40); resp.Status != "ok" {
+		t.Fatalf("unlimited group rejected upload: %+v", resp)
+	}
+	if resp := uploadSized("bigger.bin", 1<<41); resp.Status != "ok" {
+		t.Fatalf("unlimited group rejected upload: %+v", resp)
+	}
+}
+
+// TestSetGroupQuota_OwnerOnly verifies members can't change the quota and bad values are refused.
+func TestSetGroupQuota_OwnerOnly(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	if resp := setGroupQuota([]string{"g1", "bob", "100"}); resp.Status != "error" {
+		t.Errorf("non-owner set quota: %+v", resp)
+	}
+	if resp := setGroupQuota([]string{"g1", "alice", "-5"}); resp.Status != "error" {
+		t.Errorf("negative quota accepted: %+v", resp)
+	}
+}
+
+// TestCreateGroup_WithQuota verifies the optional quota argument and that
+// get_group_info reports usage against it.
+func TestCreateGroup_WithQuota(t *testing.T) {
+	mu.Lock()
+	groups = make(map[string]*Group)
+	replaceFiles(make(map[string]*File))
+	mu.Unlock()
+
+	if resp := createGroup([]string{"g1", "alice", "1000"}); resp.Status != "ok" {
+		t.Fatalf("create_group: %+v", resp)
+	}
+	uploadSized("a.txt", 250)
+
+	resp := getGroupInfo([]string{"g1"})
+	if resp.Status != "ok" {
+		t.Fatalf("get_group_info: %+v", resp)
+	}
+	info := resp.Data.(map[string]interface{})
+	if info["storage_quota"] != int64(1000) || info["storage_used"] != int64(250) {
+		t.Errorf("usage/quota = %v/%v, want 250/1000", info["storage_used"], info["storage_quota"])
+	}
+}
+
+// TestSyncSetGroupQuota verifies peers apply a synced quota change.
+func TestSyncSetGroupQuota(t *testing.T) {
+	resetGroupState(t, "alice")
+	if resp := applySync(Message{Cmd: "sync_set_group_quota", Args: []string{"g1", "4096"}}); resp.Status != "ok" {
+		t.Fatalf("sync: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if q := groups["g1"].StorageQuota; q != 4096 {
+		t.Errorf("quota = %d, want 4096", q)
+	}
+}
+
+// seedFiles uploads the named files to g1 as alice.
+func seedFiles(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if resp := uploadFile([]string{name, "g1", "alice", "10", "hash-" + name, "[]"}); resp.Status != "ok" {
+			t.Fatalf("upload %s: %+v", name, resp)
+		}
+	}
+}
+
+// TestRenameGroup_MovesGroupAndFiles verifies the group and all its files are re-keyed.
+func TestRenameGroup_MovesGroupAndFiles(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	mu.Lock()
+	groups["g1"].Pending["carol"] = true
+	mu.Unlock()
+	seedFiles(t, "a.txt", "b.txt")
+
+	if resp := renameGroup([]string{"g1", "team", "alice"}); resp.Status != "ok" {
+		t.Fatalf("rename_group: %+v", resp)
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if _, ok := groups["g1"]; ok {
+		t.Error("old group key still present")
+	}
+	g, ok := groups["team"]
+	if !ok || g.GroupID != "team" || !g.Members["bob"] || !g.Pending["carol"] {
+		t.Fatalf("renamed group = %+v", g)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, ok := files["g1:"+name]; ok {
+			t.Errorf("old file key g1:%s still present", name)
+		}
+		if f, ok := files["team:"+name]; !ok || f.GroupID != "team" {
+			t.Errorf("file %s not moved: %+v", name, f)
+		}
+	}
+}
+
+// TestRenameGroup_Rejects covers a non-owner, a missing group and a taken name.
+func TestRenameGroup_Rejects(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	mu.Lock()
+	groups["g2"] = &Group{GroupID: "g2", Owner: "bob", Members: map[string]bool{"bob": true}, Pending: map[string]bool{}}
+	mu.Unlock()
+
+	for _, args := range [][]string{
+		{"g1", "team", "bob"},   // not owner
+		{"nope", "team", "bob"}, // no such group
+		{"g1", "g2", "alice"},   // name taken
+		{"g1", "", "alice"},     // empty name
+	} {
+		if resp := renameGroup(args); resp.Status != "error" {
+			t.Errorf("rename_group %v: %+v, want error", args, resp)
+		}
+	}
+}
+
+// TestRenameGroup_RollsBack plants a file already keyed under the new name,
+// so the rename fails part way through moving files. Everything must be
+// restored as it was.
+func TestRenameGroup_RollsBack(t *testing.T) {
+	resetGroupState(t, "alice")
+	seedFiles(t, "a.txt", "b.txt", "c.txt")
+
+	mu.Lock()
+	orphan := &File{FileName: "b.txt", GroupID: "team", Owners: map[string]bool{"zed": true}}
+	putFile("team:b.txt", orphan)
+	before := make(map[string]File, len(files))
+	for k, f := range files {
+		before[k] = *f
+	}
+	groupVersion := groups["g1"].Version
+	mu.Unlock()
+
+	if resp := renameGroup([]string{"g1", "team", "alice"}); resp.Status != "error" {
+		t.Fatalf("rename_group should fail on the file collision, got %+v", resp)
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if g, ok := groups["g1"]; !ok || g.GroupID != "g1" || g.Version != groupVersion {
+		t.Errorf("group not restored: %+v", g)
+	}
+	if _, ok := groups["team"]; ok {
+		t.Error("new group key left behind")
+	}
+	if len(files) != len(before) {
+		t.Fatalf("got %d files, want %d", len(files), len(before))
+	}
+	for k, want := range before {
+		got, ok := files[k]
+		if !ok {
+			t.Errorf("file %s missing after rollback", k)
+			continue
+		}
+		if got.GroupID != want.GroupID || got.Version != want.Version {
+			t.Errorf("file %s = group %s v%d, want group %s v%d", k, got.GroupID, got.Version, want.GroupID, want.Version)
+		}
+	}
+	if files["team:b.txt"] != orphan {
+		t.Error("colliding file was replaced")
+	}
+}
+
+// TestSyncRenameGroup verifies peers apply a synced rename.
+func TestSyncRenameGroup(t *testing.T) {
+	resetGroupState(t, "alice")
+	seedFiles(t, "a.txt")
+
+	if resp := applySync(Message{Cmd: "sync_rename_group", Args: []string{"g1", "team"}, Version: 1}); resp.Status != "ok" {
+		t.Fatalf("sync: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if _, ok := groups["team"]; !ok {
+		t.Error("group not renamed")
+	}
+	if f, ok := files["team:a.txt"]; !ok || f.GroupID != "team" {
+		t.Error("file not moved")
+	}
+}
+
+// TestDeleteGroup_OwnerOnly verifies only the owner can delete a group.
+func TestDeleteGroup_OwnerOnly(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedFiles(t, "a.txt")
+
+	for _, args := range [][]string{{"g1", "bob"}, {"nope", "alice"}} {
+		if resp := deleteGroup(args); resp.Status != "error" {
+			t.Errorf("delete_group %v: %+v, want error", args, resp)
+		}
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if groups["g1"] == nil || files["g1:a.txt"] == nil {
+		t.Error("group or file deleted by a non-owner")
+	}
+}
+
+// TestDeleteGroup_DeletesFiles verifies every file of the group goes with
+// it, references to them elsewhere become full entries, and hashes still
+// in use aren't reported unused.
+func TestDeleteGroup_DeletesFiles(t *testing.T) {
+	resetGroupState(t, "alice")
+	seedFiles(t, "a.txt", "b.txt", "c.txt")
+	mu.Lock()
+	groups["g2"] = &Group{GroupID: "g2", Owner: "alice", Members: map[string]bool{"alice": true}, Pending: map[string]bool{}}
+	mu.Unlock()
+	if resp := shareFile([]string{"g1", "b.txt", "g2", "alice"}); resp.Status != "ok" {
+		t.Fatalf("share_file: %+v", resp)
+	}
+	if resp := stopSharing([]string{"g1", "c.txt", "alice"}); resp.Status != "ok" {
+		t.Fatalf("stop_sharing: %+v", resp)
+	}
+
+	resp := deleteGroup([]string{"g1", "alice"})
+	if resp.Status != "ok" {
+		t.Fatalf("delete_group: %+v", resp)
+	}
+	data := resp.Data.(map[string]interface{})
+	if data["files_deleted"] != 2 || !reflect.DeepEqual(data["unused_hashes"], []string{"hash-a.txt"}) {
+		t.Errorf("delete_group = %+v", data)
+	}
+	checkFileIndex(t)
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if _, ok := groups["g1"]; ok {
+		t.Error("group still present")
+	}
+	for key, f := range files {
+		if f.GroupID == "g1" {
+			t.Errorf("file %s left behind", key)
+		}
+	}
+	for key, ts := range tombstones {
+		if ts.GroupID == "g1" {
+			t.Errorf("tombstone %s left behind", key)
+		}
+	}
+	if f := files["g2:b.txt"]; f == nil || f.IsReference {
+		t.Errorf("reference in g2 not promoted: %+v", f)
+	}
+}
+
+// TestDeleteGroup_NotifiesMembers verifies the deletion is published with
+// the members to tell, sent to peers as sync_delete_group, and applied by them.
+func TestDeleteGroup_NotifiesMembers(t *testing.T) {
+	resetGroupState(t, "alice", "carol", "bob")
+	seedFiles(t, "a.txt")
+	saved := trackerEvents
+	trackerEvents = NewEventBus()
+	t.Cleanup(func() { trackerEvents = saved })
+	published := make(chan Message, 1)
+	trackerEvents.Subscribe(EventGroupDeleted, func(msg Message) { published <- msg })
+
+	if resp := deleteGroup([]string{"g1", "alice"}); resp.Status != "ok" {
+		t.Fatalf("delete_group: %+v", resp)
+	}
+	var msg Message
+	select {
+	case msg = <-published:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event for delete_group")
+	}
+	if msg.Cmd != "sync_delete_group" || !reflect.DeepEqual(msg.Args, []string{"g1", "alice", "alice", "bob", "carol"}) {
+		t.Errorf("published %s %v", msg.Cmd, msg.Args)
+	}
+	hook := webhookEvents["group.deleted"].data(msg.Args)
+	if !reflect.DeepEqual(hook["members"], []string{"alice", "bob", "carol"}) || hook["owner"] != "alice" {
+		t.Errorf("group.deleted webhook data = %v", hook)
+	}
+
+	// A peer still holding the group deletes it too
+	resetGroupState(t, "alice", "carol", "bob")
+	seedFiles(t, "a.txt")
+	if resp := applySync(msg); resp.Status != "ok" {
+		t.Fatalf("applySync: %+v", resp)
+	}
+	checkFileIndex(t)
+	mu.RLock()
+	defer mu.RUnlock()
+	if groups["g1"] != nil || files["g1:a.txt"] != nil {
+		t.Error("sync_delete_group not applied")
+	}
+}
+
+// TestAddSeeder_LogsDownload verifies each add_seeder appends an event with
+// the user's peer address.
+func TestAddSeeder_LogsDownload(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	mu.Lock()
+	users = map[string]*User{"bob": {UserID: "bob", LoggedIn: true, Addr: "127.0.0.1:6001"}}
+	mu.Unlock()
+	seedFiles(t, "a.txt")
+
+	start := time.Now()
+	addSeeder([]string{"g1", "a.txt", "bob"})
+	addSeeder([]string{"g1", "a.txt", "bob"})
+
+	resp := getDownloadLog([]string{"g1", "a.txt", "alice"})
+	if resp.Status != "ok" {
+		t.Fatalf("get_download_log: %+v", resp)
+	}
+	events := resp.Data.([]DownloadEvent)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	for _, e := range events {
+		if e.UserID != "bob" || e.PeerAddr != "127.0.0.1:6001" || e.Timestamp.Before(start.Add(-time.Second)) {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+}
+
+// TestGetDownloadLog_OwnerOnly verifies members and outsiders can't read the log.
+func TestGetDownloadLog_OwnerOnly(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedFiles(t, "a.txt")
+	addSeeder([]string{"g1", "a.txt", "bob"})
+
+	for _, user := range []string{"bob", "mallory"} {
+		if resp := getDownloadLog([]string{"g1", "a.txt", user}); resp.Status != "error" {
+			t.Errorf("%s read the log: %+v", user, resp)
+		}
+	}
+}
+
+// TestGetDownloadLog_LastHundredSince verifies the page limit and the since filter.
+func TestGetDownloadLog_LastHundredSince(t *testing.T) {
+	resetGroupState(t, "alice")
+	seedFiles(t, "a.txt")
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mu.Lock()
+	for i := 0; i < 150; i++ {
+		files["g1:a.txt"].DownloadLog = append(files["g1:a.txt"].DownloadLog,
+			DownloadEvent{UserID: fmt.Sprintf("u%d", i), Timestamp: base.Add(time.Duration(i) * time.Minute)})
+	}
+	mu.Unlock()
+
+	events := getDownloadLog([]string{"g1", "a.txt", "alice"}).Data.([]DownloadEvent)
+	if len(events) != downloadLogPage || events[0].UserID != "u50" || events[99].UserID != "u149" {
+		t.Errorf("got %d events from %s to %s, want u50..u149", len(events), events[0].UserID, events[len(events)-1].UserID)
+	}
+
+	since := base.Add(140 * time.Minute).Format(time.RFC3339)
+	events = getDownloadLog([]string{"g1", "a.txt", "alice", since}).Data.([]DownloadEvent)
+	if len(events) != 10 || events[0].UserID != "u140" {
+		t.Errorf("since filter: got %d events starting %v, want 10 from u140", len(events), events)
+	}
+
+	if resp := getDownloadLog([]string{"g1", "a.txt", "alice", "yesterday"}); resp.Status != "error" {
+		t.Errorf("bad since accepted: %+v", resp)
+	}
+}
+
+// TestSaveState_TruncatesDownloadLog verifies only the newest 1000 events are persisted.
+func TestSaveState_TruncatesDownloadLog(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice")
+	seedFiles(t, "a.txt")
+	mu.Lock()
+	for i := 0; i < maxDownloadLogLen+200; i++ {
+		files["g1:a.txt"].DownloadLog = append(files["g1:a.txt"].DownloadLog, DownloadEvent{UserID: fmt.Sprintf("u%d", i)})
+	}
+	mu.Unlock()
+
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved TrackerState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	log := saved.Files["g1:a.txt"].DownloadLog
+	if len(log) != maxDownloadLogLen {
+		t.Fatalf("saved %d events, want %d", len(log), maxDownloadLogLen)
+	}
+	if log[0].UserID != "u200" || log[len(log)-1].UserID != "u1199" {
+		t.Errorf("kept %s..%s, want the newest u200..u1199", log[0].UserID, log[len(log)-1].UserID)
+	}
+}
+
+// TestUploadFile_ChunkSize verifies the client's chunk size is stored and
+// older clients that don't send one get the 512KB default.
+func TestUploadFile_ChunkSize(t *testing.T) {
+	resetGroupState(t, "alice")
+	uploadFile([]string{"big.iso", "g1", "alice", "2147483648", "h1", "[]", "4194304"})
+	seedFiles(t, "old.txt")
+	if resp := uploadFile([]string{"bad.txt", "g1", "alice", "10", "h2", "[]", "zero"}); resp.Status != "error" {
+		t.Errorf("bad chunk size accepted: %+v", resp)
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if cs := files["g1:big.iso"].ChunkSize; cs != 4*1024*1024 {
+		t.Errorf("big.iso chunk size = %d, want 4MB", cs)
+	}
+	if cs := files["g1:old.txt"].ChunkSize; cs != defaultChunkSize {
+		t.Errorf("old.txt chunk size = %d, want %d", cs, defaultChunkSize)
+	}
+}
+
+// TestUploadFile_KeepsHashAlgorithm checks a chunk's hash algorithm is
+// stored and handed to downloaders, and left out for SHA256 chunks.
+func TestUploadFile_KeepsHashAlgorithm(t *testing.T) {
+	resetGroupState(t, "alice")
+	uploadFile([]string{"sha3.txt", "g1", "alice", "10", "h1", `[{"index":0,"hash":"aa","size":10,"hash_algorithm":"sha3-256"}]`})
+	uploadFile([]string{"sha2.txt", "g1", "alice", "10", "h2", `[{"index":0,"hash":"bb","size":10}]`})
+
+	resp := getFileInfo([]string{"g1", "sha3.txt"})
+	data, _ := json.Marshal(resp.Data)
+	if resp.Status != "ok" || !strings.Contains(string(data), `"hash_algorithm":"sha3-256"`) {
+		t.Errorf("get_file_info sha3.txt = %s", data)
+	}
+	resp = getFileInfo([]string{"g1", "sha2.txt"})
+	data, _ = json.Marshal(resp.Data)
+	if resp.Status != "ok" || strings.Contains(string(data), "hash_algorithm") {
+		t.Errorf("get_file_info sha2.txt = %s", data)
+	}
+}
+
+// TestGetFileInfo_MerkleRoot checks get_file_info carries the root of the
+// Merkle tree over the file's chunk hashes, and none for files uploaded
+// without chunks.
+func TestGetFileInfo_MerkleRoot(t *testing.T) {
+	resetGroupState(t, "alice")
+	uploadFile([]string{"a.txt", "g1", "alice", "20", "h1", `[{"index":1,"hash":"bb","size":10},{"index":0,"hash":"aa","size":10}]`})
+	seedFiles(t, "bare.txt")
+
+	info, _ := getFileInfo([]string{"g1", "a.txt"}).Data.(map[string]interface{})
+	if want := common.MerkleRoot([]string{"aa", "bb"}); info["merkle_root"] != want {
+		t.Errorf("merkle_root = %v, want %s", info["merkle_root"], want)
+	}
+	info, _ = getFileInfo([]string{"g1", "bare.txt"}).Data.(map[string]interface{})
+	if root, ok := info["merkle_root"]; ok {
+		t.Errorf("merkle_root %v for a file without chunks", root)
+	}
+}
+
+// TestGetFileInfo_IssuesPeerToken checks members get a download token for
+// the file when P2P_PEER_SECRET is set, and nobody gets one without a user.
+func TestGetFileInfo_IssuesPeerToken(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedFiles(t, "a.txt")
+	t.Setenv(common.PeerSecretEnv, "s3cret")
+
+	resp := getFileInfo([]string{"g1", "a.txt", "bob"})
+	info, _ := resp.Data.(map[string]interface{})
+	token, _ := info["token"].(string)
+	if user, err := common.VerifyPeerToken([]byte("s3cret"), token, "hash-a.txt", time.Now()); err != nil || user != "bob" {
+		t.Errorf("token %q: user %q, %v", token, user, err)
+	}
+	if _, err := common.VerifyPeerToken([]byte("s3cret"), token, "hash-a.txt", time.Now().Add(common.PeerTokenTTL)); err != common.ErrTokenExpired {
+		t.Errorf("token after its TTL: %v", err)
+	}
+
+	resp = getFileInfo([]string{"g1", "a.txt"})
+	if info, _ := resp.Data.(map[string]interface{}); info["token"] != nil {
+		t.Error("token issued without a user")
+	}
+}
+
+// TestGetPeerAddress checks a member can look up another online member,
+// and nobody else.
+func TestGetPeerAddress(t *testing.T) {
+	resetGroupState(t, "alice", "bob", "carol")
+	mu.Lock()
+	users = map[string]*User{
+		"bob":   {UserID: "bob", LoggedIn: true, Addr: "127.0.0.1:6001"},
+		"carol": {UserID: "carol", Addr: "127.0.0.1:6002"},
+		"dave":  {UserID: "dave", LoggedIn: true, Addr: "127.0.0.1:6003"},
+	}
+	mu.Unlock()
+
+	resp := getPeerAddress([]string{"g1", "alice", "bob"})
+	if data, ok := resp.Data.(map[string]interface{}); resp.Status != "ok" || !ok || data["peer_addr"] != "127.0.0.1:6001" {
+		t.Fatalf("get_peer_address bob = %+v", resp)
+	}
+	for _, args := range [][]string{
+		{"g1", "alice", "carol"}, // logged out
+		{"g1", "alice", "dave"},  // not a member
+		{"g1", "dave", "bob"},    // asker not a member
+		{"g2", "alice", "bob"},   // no such group
+	} {
+		if resp := getPeerAddress(args); resp.Status != "error" {
+			t.Errorf("get_peer_address %v = %+v", args, resp)
+		}
+	}
+}
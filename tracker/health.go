@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+// Probe timeouts for get_seeder_health. Variables so tests can shorten them.
+var (
+	seederProbeTimeout   = 500 * time.Millisecond
+	seederHealthDeadline = 2 * time.Second
+)
+
+// SeederHealth is one owner's probe result. Owners that are logged out, or
+// whose probe didn't finish before the deadline, are reported unreachable.
+type SeederHealth struct {
+	PeerAddr  string `json:"peer_addr"`
+	UserID    string `json:"user_id"`
+	Reachable bool   `json:"reachable"`
+	LatencyMs int    `json:"latency_ms"`
+}
+
+// getSeederHealth dials every owner of a file and reports who answered.
+// It only reads state; nothing is changed based on the results.
+// args: [groupID, fileName, userID (optional; checked for membership)]
+func getSeederHealth(args []string) Response {
+	if len(args) < 2 {
+		return Response{"error", "get_seeder_health: need groupID, fileName"}
+	}
+	groupID, fileName := args[0], args[1]
+
+	mu.RLock()
+	if len(args) >= 3 && args[2] != "" {
+		g, ok := groups[groupID]
+		if !ok {
+			mu.RUnlock()
+			return Response{"error", "group not found"}
+		}
+		if !g.Members[args[2]] {
+			mu.RUnlock()
+			return Response{"error", "not a member of this group"}
+		}
+	}
+	file, ok := files[groupID+":"+fileName]
+	if !ok {
+		mu.RUnlock()
+		return Response{"error", "file not found"}
+	}
+	seeders := make([]SeederHealth, 0, len(file.Owners))
+	for userID := range file.Owners {
+		s := SeederHealth{UserID: userID}
+		if u, ok := users[userID]; ok && u.LoggedIn {
+			s.PeerAddr = u.Addr
+		}
+		seeders = append(seeders, s)
+	}
+	mu.RUnlock()
+
+	// Dial without holding mu; each probe reports back by index
+	type probeResult struct {
+		idx     int
+		latency time.Duration
+		err     error
+	}
+	results := make(chan probeResult, len(seeders))
+	pending := 0
+	for i, s := range seeders {
+		if s.PeerAddr == "" {
+			continue
+		}
+		pending++
+		go func(i int, addr string) {
+			start := time.Now()
+			conn, err := net.DialTimeout("tcp", addr, seederProbeTimeout)
+			if err == nil {
+				conn.Close()
+			}
+			results <- probeResult{i, time.Since(start), err}
+		}(i, s.PeerAddr)
+	}
+
+	deadline := time.After(seederHealthDeadline)
+collect:
+	for ; pending > 0; pending-- {
+		select {
+		case r := <-results:
+			if r.err == nil {
+				seeders[r.idx].Reachable = true
+				seeders[r.idx].LatencyMs = int(r.latency.Milliseconds())
+			}
+		case <-deadline:
+			break collect
+		}
+	}
+
+	sort.Slice(seeders, func(a, b int) bool { return seeders[a].UserID < seeders[b].UserID })
+	return Response{"ok", seeders}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// mockSeeder listens on a free port; closed ones refuse connections.
+func mockSeeder(t *testing.T, reachable bool) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	if !reachable {
+		ln.Close()
+		return addr
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return addr
+}
+
+// TestGetSeederHealth probes one reachable, one unreachable and one
+// logged-out owner and checks each is reported correctly.
+func TestGetSeederHealth(t *testing.T) {
+	resetGroupState(t, "alice", "bob", "carol")
+	up, down := mockSeeder(t, true), mockSeeder(t, false)
+	mu.Lock()
+	users = map[string]*User{
+		"alice": {UserID: "alice", LoggedIn: true, Addr: up},
+		"bob":   {UserID: "bob", LoggedIn: true, Addr: down},
+		"carol": {UserID: "carol", LoggedIn: false, Addr: up},
+	}
+	putFile("g1:a.txt", &File{FileName: "a.txt", GroupID: "g1", Owners: map[string]bool{"alice": true, "bob": true, "carol": true}})
+	mu.Unlock()
+
+	resp := getSeederHealth([]string{"g1", "a.txt", "alice"})
+	if resp.Status != "ok" {
+		t.Fatalf("get_seeder_health: %+v", resp)
+	}
+	got := resp.Data.([]SeederHealth)
+	if len(got) != 3 {
+		t.Fatalf("got %d results, want 3: %+v", len(got), got)
+	}
+	want := []SeederHealth{
+		{UserID: "alice", PeerAddr: up, Reachable: true},
+		{UserID: "bob", PeerAddr: down, Reachable: false},
+		{UserID: "carol", PeerAddr: "", Reachable: false},
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.UserID != w.UserID || g.PeerAddr != w.PeerAddr || g.Reachable != w.Reachable {
+			t.Errorf("result %d = %+v, want %+v", i, g, w)
+		}
+		if !g.Reachable && g.LatencyMs != 0 {
+			t.Errorf("unreachable %s has latency %d", g.UserID, g.LatencyMs)
+		}
+	}
+}
+
+func TestGetSeederHealth_Errors(t *testing.T) {
+	resetGroupState(t, "alice")
+	if resp := getSeederHealth([]string{"g1"}); resp.Status != "error" {
+		t.Fatalf("missing args: %+v", resp)
+	}
+	if resp := getSeederHealth([]string{"g1", "missing.txt"}); resp.Status != "error" {
+		t.Fatalf("unknown file: %+v", resp)
+	}
+	mu.Lock()
+	putFile("g1:a.txt", &File{FileName: "a.txt", GroupID: "g1", Owners: map[string]bool{"alice": true}})
+	mu.Unlock()
+	if resp := getSeederHealth([]string{"g1", "a.txt", "mallory"}); resp.Status != "error" {
+		t.Fatalf("non-member: %+v", resp)
+	}
+}
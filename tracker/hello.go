@@ -0,0 +1,22 @@
+package main
+
+import "p2p/common"
+
+// hello answers a client's hello [vN, format...] with the newest protocol
+// version this tracker speaks; both then use the older of the two. The
+// request that follows is served alike whatever the version, as it is for
+// clients that open without hello (version 1), but from version 3 on it
+// and its response are in the format helloFormat picks.
+func hello(args []string) Response {
+	if _, err := common.ParseProtocolVersion(args[0]); err != nil {
+		return Response{"error", err.Error()}
+	}
+	return Response{"ok", common.FormatProtocolVersion(common.ProtocolVersion)}
+}
+
+// helloFormat returns the wire format of responses on a connection opened
+// with an accepted hello carrying args.
+func helloFormat(args []string) common.WireFormat {
+	v, _ := common.ParseProtocolVersion(args[0])
+	return common.NegotiateWireFormat(min(v, common.ProtocolVersion), args[1:])
+}
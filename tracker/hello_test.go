@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net"
+	"p2p/common"
+	"testing"
+	"time"
+)
+
+// TestHello opens connections as clients of each protocol version would
+// and checks the tracker answers hello with its own and then serves the
+// request on the same connection.
+func TestHello(t *testing.T) {
+	useTestAuditLog(t, "")
+	seedUsers()
+	addr := startTestTracker(t)
+
+	for _, tc := range []struct {
+		name   string
+		client string // "" for a client from before hello
+	}{
+		{"client v2", "v2"},
+		{"client v1", ""},
+		{"client v4", "v4"},
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if tc.client != "" {
+			resp := exchange(t, conn, Message{Cmd: common.HelloCmd, Args: []string{tc.client}})
+			if resp.Status != "ok" || resp.Data != common.FormatProtocolVersion(common.ProtocolVersion) {
+				t.Errorf("%s: hello = %+v", tc.name, resp)
+			}
+		}
+		if resp := exchange(t, conn, Message{Cmd: "create_user", Args: []string{"user-" + tc.name, "pw"}}); resp.Status != "ok" {
+			t.Errorf("%s: create_user = %+v", tc.name, resp)
+		}
+		conn.Close()
+	}
+}
+
+// TestHello_Invalid checks a hello without a version is refused and the
+// connection closed.
+func TestHello_Invalid(t *testing.T) {
+	useTestAuditLog(t, "")
+	addr := startTestTracker(t)
+	for _, args := range [][]string{nil, {"2"}, {"v0"}} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if resp := exchange(t, conn, Message{Cmd: common.HelloCmd, Args: args}); resp.Status != "error" {
+			t.Errorf("hello %v = %+v", args, resp)
+		}
+		if common.Send(conn, Message{Cmd: "list_groups"}) == nil {
+			var resp Response
+			if common.Recv(conn, &resp) == nil {
+				t.Errorf("request after hello %v answered: %+v", args, resp)
+			}
+		}
+		conn.Close()
+	}
+}
+
+// TestHello_MsgPack checks a client offering MsgPack gets its responses,
+// multiplexed ones included, in MsgPack from version 3 on, and in JSON if
+// it speaks only version 2.
+func TestHello_MsgPack(t *testing.T) {
+	useTestAuditLog(t, "")
+	seedUsers()
+	addr := startTestTracker(t)
+	dial := func(version string) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if resp := exchange(t, conn, Message{Cmd: common.HelloCmd, Args: []string{version, "msgpack"}}); resp.Status != "ok" {
+			t.Fatalf("hello %s = %+v", version, resp)
+		}
+		return conn
+	}
+
+	conn := dial("v3")
+	if err := common.SendMsgPack(conn, Message{Cmd: "create_user", Args: []string{"alice", "pw"}, Mux: true}); err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	if err := common.RecvMsgPack(conn, &resp); err != nil || resp.Status != "ok" {
+		t.Fatalf("create_user over MsgPack = %+v, %v", resp, err)
+	}
+	mux := common.NewMuxFormat(conn, common.WireMsgPack)
+	if err := mux.Call(Message{Cmd: "list_groups"}, &resp, 5*time.Second); err != nil || resp.Status != "ok" {
+		t.Errorf("multiplexed list_groups = %+v, %v", resp, err)
+	}
+
+	conn = dial("v2")
+	if err := common.Send(conn, Message{Cmd: "create_user", Args: []string{"bob", "pw"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := common.RecvMsgPack(conn, &resp); err == nil {
+		t.Errorf("version 2 client answered in MsgPack: %+v", resp)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const hooksFile = "hooks.json"
+
+// Webhook request headers. The signature is "sha256=" and the hex
+// HMAC-SHA256 of the body, keyed with the hook's secret.
+const (
+	webhookEventHeader     = "X-P2P-Event"
+	webhookSignatureHeader = "X-P2P-Signature"
+)
+
+// webhookRetries is how many times a failed delivery is retried, waiting
+// webhookBackoff before the first retry and twice as long before each
+// one after.
+const webhookRetries = 3
+
+var (
+	webhookBackoff = time.Second
+	webhookHTTP    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Hook is one hooks.json entry: POST event to URL, signed with Secret.
+type Hook struct {
+	Event  string `json:"event"`
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// webhookEvent maps a webhook event onto the bus event it follows and
+// picks the fields an outside service may see out of its sync message.
+type webhookEvent struct {
+	source string
+	data   func(args []string) map[string]interface{}
+}
+
+var webhookEvents = map[string]webhookEvent{
+	// args: [userID, password]; the password is never sent
+	"user.created": {EventUserCreated, func(a []string) map[string]interface{} {
+		return map[string]interface{}{"user_id": a[0]}
+	}},
+	// args: [groupID, ownerID, quota, inviteHash]
+	"group.created": {EventGroupCreated, func(a []string) map[string]interface{} {
+		return map[string]interface{}{"group_id": a[0], "owner": a[1]}
+	}},
+	// args: [fileName, groupID, userID, fileSize, fileHash, chunksJSON, ...]
+	"file.uploaded": {EventFileUploaded, func(a []string) map[string]interface{} {
+		size, _ := strconv.ParseInt(a[3], 10, 64)
+		return map[string]interface{}{"file_name": a[0], "group_id": a[1], "uploader": a[2], "file_size": size, "file_hash": a[4]}
+	}},
+	// args: [groupID, fileName, userID]; fired when the last owner stops sharing
+	"file.deleted": {EventFileUnshared, func(a []string) map[string]interface{} {
+		return map[string]interface{}{"group_id": a[0], "file_name": a[1], "user_id": a[2]}
+	}},
+	// args: [groupID, fileName]; fired when no owner of the file can be reached
+	"file.unavailable": {EventFileUnavailable, func(a []string) map[string]interface{} {
+		return map[string]interface{}{"group_id": a[0], "file_name": a[1]}
+	}},
+	// args: [groupID, ownerID, members...]
+	"group.deleted": {EventGroupDeleted, func(a []string) map[string]interface{} {
+		return map[string]interface{}{"group_id": a[0], "owner": a[1], "members": a[2:]}
+	}},
+	// args: [groupID, userID]; fired when a join request is accepted
+	"member.joined": {EventGroupAccepted, func(a []string) map[string]interface{} {
+		return map[string]interface{}{"group_id": a[0], "user_id": a[1]}
+	}},
+}
+
+// loadHooks reads path. A missing file means no hooks.
+func loadHooks(path string) ([]Hook, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hooks []Hook
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	for i, h := range hooks {
+		if _, ok := webhookEvents[h.Event]; !ok {
+			return nil, fmt.Errorf("hook %d: unknown event %q", i+1, h.Event)
+		}
+		if !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+			return nil, fmt.Errorf("hook %d: url must be http or https", i+1)
+		}
+	}
+	return hooks, nil
+}
+
+// subscribeHooks makes b deliver each hook's event to its URL. Deliveries
+// run in the background so a slow endpoint holds up nothing else.
+func subscribeHooks(b *EventBus, hooks []Hook) {
+	for _, h := range hooks {
+		ev := webhookEvents[h.Event]
+		b.Subscribe(ev.source, func(msg Message) {
+			body, err := json.Marshal(map[string]interface{}{
+				"event":     h.Event,
+				"timestamp": time.Now().UTC(),
+				"data":      ev.data(msg.Args),
+			})
+			if err != nil {
+				return
+			}
+			go func() {
+				if err := deliverWebhook(h, body); err != nil {
+					fmt.Printf("Warning: webhook %s to %s failed: %v\n", h.Event, h.URL, err)
+				}
+			}()
+		})
+	}
+}
+
+// signWebhook returns the signature header value for body.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook POSTs body to h.URL, retrying with exponential backoff
+// until it gets a 2xx answer or runs out of retries.
+func deliverWebhook(h Hook, body []byte) error {
+	wait := webhookBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = postWebhook(h, body); err == nil {
+			return nil
+		}
+		if attempt == webhookRetries {
+			return fmt.Errorf("%v (after %d attempts)", err, attempt+1)
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func postWebhook(h Hook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, h.Event)
+	req.Header.Set(webhookSignatureHeader, signWebhook(h.Secret, body))
+	resp, err := webhookHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", h.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookReceiver records webhook requests, failing the first failFirst.
+type hookReceiver struct {
+	mu        sync.Mutex
+	failFirst int
+	bodies    [][]byte
+	headers   []http.Header
+	got       chan struct{}
+}
+
+func startHookReceiver(t *testing.T, failFirst int) (*hookReceiver, string) {
+	t.Helper()
+	r := &hookReceiver{failFirst: failFirst, got: make(chan struct{}, 16)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.bodies = append(r.bodies, body)
+		r.headers = append(r.headers, req.Header.Clone())
+		fail := len(r.bodies) <= r.failFirst
+		r.mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		r.got <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return r, srv.URL
+}
+
+func useFastBackoff(t *testing.T) {
+	saved := webhookBackoff
+	webhookBackoff = time.Millisecond
+	t.Cleanup(func() { webhookBackoff = saved })
+}
+
+// TestDeliverWebhook_Signed checks the body arrives with the event header
+// and an HMAC-SHA256 signature the receiver can verify with the secret.
+func TestDeliverWebhook_Signed(t *testing.T) {
+	r, url := startHookReceiver(t, 0)
+	body := []byte(`{"event":"file.uploaded"}`)
+
+	if err := deliverWebhook(Hook{Event: "file.uploaded", URL: url, Secret: "s3cret"}, body); err != nil {
+		t.Fatalf("deliverWebhook: %v", err)
+	}
+	if len(r.bodies) != 1 || string(r.bodies[0]) != string(body) {
+		t.Fatalf("received %q", r.bodies)
+	}
+	h := r.headers[0]
+	if h.Get(webhookEventHeader) != "file.uploaded" || h.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v", h)
+	}
+	if got, want := h.Get(webhookSignatureHeader), signWebhook("s3cret", body); got != want || !strings.HasPrefix(got, "sha256=") {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if h.Get(webhookSignatureHeader) == signWebhook("other", body) {
+		t.Error("signature doesn't depend on the secret")
+	}
+}
+
+// TestDeliverWebhook_Retries checks failed deliveries are retried with
+// growing waits, and given up on after three retries.
+func TestDeliverWebhook_Retries(t *testing.T) {
+	useFastBackoff(t)
+
+	r, url := startHookReceiver(t, 2)
+	if err := deliverWebhook(Hook{Event: "user.created", URL: url}, []byte("{}")); err != nil {
+		t.Fatalf("deliverWebhook after two failures: %v", err)
+	}
+	if len(r.bodies) != 3 {
+		t.Errorf("attempts = %d, want 3", len(r.bodies))
+	}
+
+	r, url = startHookReceiver(t, 100)
+	start := time.Now()
+	if err := deliverWebhook(Hook{Event: "user.created", URL: url}, []byte("{}")); err == nil {
+		t.Fatal("deliverWebhook to a failing endpoint succeeded")
+	}
+	if len(r.bodies) != 1+webhookRetries {
+		t.Errorf("attempts = %d, want %d", len(r.bodies), 1+webhookRetries)
+	}
+	// 1ms + 2ms + 4ms between the four attempts
+	if elapsed := time.Since(start); elapsed < 7*time.Millisecond {
+		t.Errorf("retries took %v, want at least 7ms of backoff", elapsed)
+	}
+}
+
+// TestSubscribeHooks_Events publishes bus events and checks each hook
+// receives only its own event, with the user's password left out.
+func TestSubscribeHooks_Events(t *testing.T) {
+	r, url := startHookReceiver(t, 0)
+	b := NewEventBus()
+	subscribeHooks(b, []Hook{
+		{Event: "user.created", URL: url, Secret: "k"},
+		{Event: "file.uploaded", URL: url, Secret: "k"},
+	})
+
+	b.Publish(EventGroupCreated, Message{Cmd: "sync_create_group", Args: []string{"g1", "alice", "0", ""}})
+	b.Publish(EventUserCreated, Message{Cmd: "sync_create_user", Args: []string{"alice", "hunter2"}})
+	b.Publish(EventFileUploaded, Message{Cmd: "sync_upload_file", Args: []string{"a.txt", "g1", "alice", "10", "h", "[]"}})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-r.got:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("got %d of 2 webhooks", i)
+		}
+	}
+	time.Sleep(20 * time.Millisecond) // a stray group.created would have arrived
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.bodies) != 2 {
+		t.Fatalf("received %d webhooks, want 2", len(r.bodies))
+	}
+	events := map[string]map[string]interface{}{}
+	for _, body := range r.bodies {
+		if strings.Contains(string(body), "hunter2") {
+			t.Errorf("webhook leaked the password: %s", body)
+		}
+		var payload struct {
+			Event string                 `json:"event"`
+			Data  map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("body %s: %v", body, err)
+		}
+		events[payload.Event] = payload.Data
+	}
+	if events["user.created"]["user_id"] != "alice" {
+		t.Errorf("user.created data = %v", events["user.created"])
+	}
+	if f := events["file.uploaded"]; f["file_name"] != "a.txt" || f["group_id"] != "g1" || f["file_size"] != float64(10) {
+		t.Errorf("file.uploaded data = %v", f)
+	}
+}
+
+func TestLoadHooks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if hooks, err := loadHooks(hooksFile); err != nil || hooks != nil {
+		t.Fatalf("missing file: %v, %v", hooks, err)
+	}
+
+	os.WriteFile(hooksFile, []byte(`[{"event":"member.joined","url":"https://ci.example/hook","secret":"x"}]`), 0644)
+	hooks, err := loadHooks(hooksFile)
+	if err != nil || len(hooks) != 1 || hooks[0].Event != "member.joined" || hooks[0].Secret != "x" {
+		t.Fatalf("loadHooks = %+v, %v", hooks, err)
+	}
+
+	for _, bad := range []string{
+		`{"event":"user.created"}`,
+		`[{"event":"file.renamed","url":"https://ci.example/hook"}]`,
+		`[{"event":"user.created","url":"ftp://ci.example/hook"}]`,
+	} {
+		os.WriteFile(hooksFile, []byte(bad), 0644)
+		if _, err := loadHooks(hooksFile); err == nil {
+			t.Errorf("loadHooks accepted %s", bad)
+		}
+	}
+}
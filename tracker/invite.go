@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// publicFilter is the list_groups argument that leaves out private groups.
+const publicFilter = "public"
+
+// hashInviteCode returns "salt:sha256(salt+code)" for storing in
+// Group.InviteCodeHash. The salt is random, so the hash survives renames
+// and equal codes don't give equal hashes.
+func hashInviteCode(code string) string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	s := hex.EncodeToString(salt)
+	return s + ":" + inviteDigest(s, code)
+}
+
+func inviteDigest(salt, code string) string {
+	sum := sha256.Sum256([]byte(salt + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkInviteCode reports whether code matches a hash from hashInviteCode.
+func checkInviteCode(hash, code string) bool {
+	salt, want, ok := strings.Cut(hash, ":")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(inviteDigest(salt, code)), []byte(want)) == 1
+}
+
+// isPrivate reports whether joining g needs an invite code.
+func (g *Group) isPrivate() bool {
+	return g.InviteCodeHash != ""
+}
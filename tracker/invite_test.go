@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// createTestGroups creates an open group "open" and a private group
+// "secret" with invite code "letmein", both owned by alice.
+func createTestGroups(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice")
+	mu.Lock()
+	groups = make(map[string]*Group)
+	mu.Unlock()
+	if resp := createGroup([]string{"open", "alice"}); resp.Status != "ok" {
+		t.Fatalf("create open group: %+v", resp)
+	}
+	if resp := createGroup([]string{"secret", "alice", "", "letmein"}); resp.Status != "ok" {
+		t.Fatalf("create private group: %+v", resp)
+	}
+}
+
+func TestJoinGroup_PrivateCorrectCode(t *testing.T) {
+	createTestGroups(t)
+	if resp := joinGroup([]string{"secret", "bob", "letmein"}); resp.Status != "ok" {
+		t.Fatalf("join with correct code: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if !groups["secret"].Pending["bob"] {
+		t.Error("bob not pending after joining with the right code")
+	}
+}
+
+func TestJoinGroup_PrivateWrongCode(t *testing.T) {
+	createTestGroups(t)
+	for _, args := range [][]string{{"secret", "bob", "guess"}, {"secret", "bob"}} {
+		if resp := joinGroup(args); resp.Status != "error" {
+			t.Errorf("join_group %v = %+v, want an error", args, resp)
+		}
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if groups["secret"].Pending["bob"] {
+		t.Error("bob became pending without the invite code")
+	}
+}
+
+func TestJoinGroup_OpenGroup(t *testing.T) {
+	createTestGroups(t)
+	if resp := joinGroup([]string{"open", "bob"}); resp.Status != "ok" {
+		t.Fatalf("join open group: %+v", resp)
+	}
+}
+
+func TestInviteCode_NotStoredPlaintext(t *testing.T) {
+	createTestGroups(t)
+	mu.RLock()
+	hash := groups["secret"].InviteCodeHash
+	mu.RUnlock()
+	if hash == "" || strings.Contains(hash, "letmein") {
+		t.Fatalf("invite code stored as %q", hash)
+	}
+	if !checkInviteCode(hash, "letmein") || checkInviteCode(hash, "letmeout") {
+		t.Error("checkInviteCode doesn't match the hash")
+	}
+	if hashInviteCode("letmein") == hash {
+		t.Error("equal codes gave equal hashes; salt missing")
+	}
+}
+
+func TestListGroups_PrivateVisibility(t *testing.T) {
+	createTestGroups(t)
+
+	all, _ := listGroups(nil).Data.([]string)
+	sort.Strings(all)
+	if !reflect.DeepEqual(all, []string{"open", "secret"}) {
+		t.Errorf("list_groups = %v, want both groups", all)
+	}
+	public, _ := listGroups([]string{publicFilter}).Data.([]string)
+	if !reflect.DeepEqual(public, []string{"open"}) {
+		t.Errorf("list_groups public = %v, want [open]", public)
+	}
+}
+
+func TestRedactArgs_InviteCode(t *testing.T) {
+	if got := redactArgs("join_group", []string{"g1", "bob", "letmein"}); got[2] != redacted {
+		t.Errorf("join_group args logged as %v", got)
+	}
+	if got := redactArgs("create_group", []string{"g1", "alice", "", "letmein"}); got[3] != redacted {
+		t.Errorf("create_group args logged as %v", got)
+	}
+}
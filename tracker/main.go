@@ -2,10 +2,13 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"p2p/common"
 	"strconv"
 	"strings"
 	"syscall"
@@ -15,6 +18,59 @@ func main() {
 	// Default address
 	address := ":9000"
 
+	// Pull out --allowlist / --denylist before handling positional arguments
+	args, err := parseACLFlags(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	auditPath, args, err := parseAuditFlag(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	canaryInterval, args, err := parseCanaryFlag(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	tlsCert, tlsKey, args, err := parseTLSFlags(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	backupInterval, backupDest, args, err := parseBackupFlags(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	backupPath = backupDest
+	tenantsPath, args, err := parseTenantsFlag(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	dlqInterval, args, err := parseDLQFlag(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	compress, args, err := parseBoolFlag(args, "--compress-state")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	compressState.Store(compress)
+	eventSourcing, args, err := parseBoolFlag(args, "--event-sourcing")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Args = append(os.Args[:1], args...)
+	if err := trackerACL.Reload(aclFile); err != nil {
+		fmt.Printf("Warning: Failed to load %s: %v\n", aclFile, err)
+	}
+
 	// Check for command-line arguments
 	if len(os.Args) == 3 {
 		configFile := os.Args[1]
@@ -49,12 +105,12 @@ func main() {
 			os.Exit(1)
 		}
 
-		address = lines[lineNum-1]
+		address = common.ParseTrackerEndpoint(lines[lineNum-1]).Addr
 		fmt.Printf("Using tracker address from config: %s\n", address)
 	} else if len(os.Args) == 1 {
 		fmt.Printf("Using default address: %s\n", address)
 	} else {
-		fmt.Println("Usage: ./tracker_bin [config_file] [line_number]")
+		fmt.Println("Usage: ./tracker_bin [--allowlist cidrs] [--denylist cidrs] [--audit-log path] [--canary interval] [--tls-cert path|auto --tls-key path] [--backup-interval interval] [--backup-path dir|url] [--tenants file] [--dlq-interval interval] [--compress-state] [--event-sourcing] [config_file] [line_number]")
 		fmt.Println("Example: ./tracker_bin tracker_info.txt 1")
 		os.Exit(1)
 	}
@@ -64,20 +120,159 @@ func main() {
 		fmt.Printf("Error: Failed to start tracker on %s: %v\n", address, err)
 		os.Exit(1)
 	}
+
+	// With a certificate every connection is TLS; its fingerprint goes into
+	// tracker_info.txt so clients and other trackers can pin it
+	var defaultCert *tls.Certificate
+	if tlsCert != "" {
+		cert, err := loadTrackerCert(tlsCert, tlsKey, address)
+		if err != nil {
+			fmt.Printf("Error: Failed to load TLS certificate: %v\n", err)
+			os.Exit(1)
+		}
+		fingerprint, err := common.CertificateFingerprint(cert)
+		if err != nil {
+			fmt.Printf("Error: Failed to load TLS certificate: %v\n", err)
+			os.Exit(1)
+		}
+		defaultCert = &cert
+		entry := common.TrackerEndpoint{Addr: address, TLS: true, Fingerprint: fingerprint}.String()
+		fmt.Printf("TLS enabled: %s\n", entry)
+		if len(os.Args) == 3 {
+			lineNum, _ := strconv.Atoi(os.Args[2])
+			if err := updateTrackerInfo(os.Args[1], lineNum, entry); err != nil {
+				fmt.Printf("Warning: Failed to record fingerprint in %s: %v\n", os.Args[1], err)
+			}
+		}
+	}
+
+	// Tenants share the port, each reached by its own TLS server name
+	if tenantsPath != "" {
+		if tenants, err = loadTenants(tenantsPath); err != nil {
+			fmt.Printf("Error: Failed to load %s: %v\n", tenantsPath, err)
+			os.Exit(1)
+		}
+		for _, t := range tenants {
+			if err := startTenant(t); err != nil {
+				fmt.Printf("Error: Failed to start tenant %s: %v\n", t.Host, err)
+				stopTenants()
+				os.Exit(1)
+			}
+			fmt.Printf("Tenant %s: state in %s\n", t.Host, t.DataDir)
+		}
+	}
+	var tlsConfig *tls.Config
+	if defaultCert != nil || len(tenants) > 0 {
+		tlsConfig = tenantTLSConfig(defaultCert, tenants)
+		ln = tls.NewListener(ln, tlsConfig)
+	}
 	
+	trackerAudit.Configure(auditPath, address)
+	fmt.Printf("Audit log: %s\n", auditPath)
+
+	// Every state change is logged, and replayed on top of the last snapshot
+	if eventSourcing {
+		l, err := OpenEventLog(eventLogFile, defaultEventLogCompactAt)
+		if err != nil {
+			fmt.Printf("Error: Failed to open %s: %v\n", eventLogFile, err)
+			os.Exit(1)
+		}
+		trackerEventLog.Store(l)
+		fmt.Printf("Event sourcing: commands logged to %s\n", eventLogFile)
+	}
+
 	// Load persistent state from disk
 	if err := LoadState(); err != nil {
 		fmt.Printf("Warning: Failed to load state: %v\n", err)
 	}
 
+	// End-to-end checks; results on http://<TRACKER_HEALTH_ADDR>/health
+	var canary *Canary
+	if canaryInterval > 0 {
+		trackerCanary.alertURL = os.Getenv("TRACKER_ALERT_URL")
+		canary = trackerCanary
+		go trackerCanary.Run(canaryInterval)
+		fmt.Printf("Canary check every %v\n", canaryInterval)
+	}
+	var healthServer *http.Server
+	if addr := os.Getenv("TRACKER_HEALTH_ADDR"); addr != "" {
+		healthServer = StartHealthServer(addr, canary, tlsConfig)
+	}
+
+	// Per-user request limits, shared with the other trackers
+	rate, burst, err := rateLimitFromEnv()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if rate > 0 {
+		trackerRateLimiter = NewDistributedRateLimiter(rate, burst)
+		go trackerRateLimiter.Run(rateGossipInterval)
+		fmt.Printf("Rate limit: %g requests/s per user, bursts of %g\n", rate, burst)
+	}
+
+	if backupInterval > 0 {
+		go runBackups(backupInterval)
+		fmt.Printf("Backing up state to %s every %v\n", backupPath, backupInterval)
+	}
+
 	// Initialize TCP broadcast peer list (all trackers except self)
+	// Entries keep their tls:// prefix and fingerprint so sync dials them the same way
 	allTrackerPeers := readAllTrackerAddresses(os.Args[1])
-	for _, peer := range allTrackerPeers {
-		if peer != address {
-			peerAddrs = append(peerAddrs, peer)
+	selfEntry := address
+	var seeds []string
+	for i, peer := range allTrackerPeers {
+		if common.ParseTrackerEndpoint(peer).Addr != address {
+			seeds = append(seeds, peer)
+		} else {
+			selfEntry = peer
 		}
+		allTrackerPeers[i] = common.ParseTrackerEndpoint(peer).Addr
+	}
+	setSyncPeers(seeds)
+	fmt.Printf("Sync peers: %v\n", seeds)
+
+	// Only trackers that know TRACKER_SYNC_SECRET may send sync commands
+	if auth := peerAuthFromEnv(selfEntry); auth != nil {
+		trackerPeerAuth.Store(auth)
+		fmt.Println("Sync authentication: enabled")
+	} else {
+		fmt.Printf("Warning: %s not set; any client can send sync commands\n", syncSecretEnv)
+	}
+	subscribeSync(trackerEvents)
+
+	// Trackers find each other through the configured ones, then probe each
+	// other; sync follows whoever has joined
+	trackerMembers = NewMembership(selfEntry, seeds)
+	trackerMembers.onChange = setSyncPeers
+	go func() {
+		n := trackerMembers.Join()
+		fmt.Printf("Joined cluster through %d of %d seeds\n", n, len(seeds))
+		trackerMembers.Run()
+	}()
+
+	// Sync messages peers missed while down are resent once they're back
+	if err := trackerDLQ.Load(); err != nil {
+		fmt.Printf("Warning: Failed to load %s: %v\n", dlqFile, err)
 	}
-	fmt.Printf("Sync peers: %v\n", peerAddrs)
+	go runDLQRetrier(dlqInterval)
+
+	// Webhooks from hooks.json for operators' CI and notification services
+	if hooks, err := loadHooks(hooksFile); err != nil {
+		fmt.Printf("Warning: Failed to load %s: %v\n", hooksFile, err)
+	} else if len(hooks) > 0 {
+		subscribeHooks(trackerEvents, hooks)
+		fmt.Printf("Webhooks: %d loaded from %s\n", len(hooks), hooksFile)
+	}
+
+	// Group activity is kept in memory and written out hourly
+	if err := loadActivity(); err != nil {
+		fmt.Printf("Warning: Failed to load %s: %v\n", activityFile, err)
+	}
+	go runActivityFlusher(activityFlushInterval)
+
+	// Flag files whose seeders have all gone, alerting through the event bus
+	go runAvailabilityMonitor(availabilityInterval)
 
 	// Catch up on any state missed while this tracker was down
 	go pullStateFromPeers()
@@ -99,29 +294,103 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
-	// Accept connections in a goroutine
+	// Re-read acl.txt on SIGHUP so rules can change without a restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				// Listener was closed, exit gracefully
-				return
+		for range hup {
+			if err := trackerACL.Reload(aclFile); err != nil {
+				fmt.Printf("Warning: Failed to reload %s: %v\n", aclFile, err)
+			} else {
+				fmt.Printf("Reloaded %s\n", aclFile)
 			}
-			go handleConn(conn)
 		}
 	}()
 
-	<-quit
-	
+	// On SIGTERM, stop accepting and let requests in flight finish
+	if !serveUntil(ln, healthServer, quit) {
+		fmt.Printf("Closed connections still open after %v\n", shutdownTimeout)
+	}
+
+	// Tell the other trackers we're going, so they don't wait to detect it
+	trackerMembers.Leave()
+
 	// Save state before shutdown
 	fmt.Println("Saving state...")
 	if err := SaveState(); err != nil {
 		fmt.Printf("Error saving state: %v\n", err)
 	}
+	if err := flushActivity(); err != nil {
+		fmt.Printf("Error saving activity log: %v\n", err)
+	}
+	if err := trackerDLQ.Save(); err != nil {
+		fmt.Printf("Error saving %s: %v\n", dlqFile, err)
+	}
+	if l := trackerEventLog.Load(); l != nil {
+		if err := l.Close(); err != nil {
+			fmt.Printf("Error flushing %s: %v\n", eventLogFile, err)
+		}
+	}
+	stopTenants()
 	
 	fmt.Println("Tracker stopped.")
 }
 
+// parseACLFlags installs any --allowlist/--denylist ranges and returns the
+// remaining positional arguments. Both "--flag value" and "--flag=value" work.
+func parseACLFlags(args []string) ([]string, error) {
+	var allow, deny []*net.IPNet
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--allowlist" && name != "--denylist" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a comma-separated list of CIDR ranges", name)
+			}
+			i++
+			value = args[i]
+		}
+		nets, err := parseCIDRList(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if name == "--allowlist" {
+			allow = append(allow, nets...)
+		} else {
+			deny = append(deny, nets...)
+		}
+	}
+	trackerACL.SetFlags(allow, deny)
+	return rest, nil
+}
+
+// parseBoolFlag removes the switch name (or name=true|false) from args
+// and reports whether it was on.
+func parseBoolFlag(args []string, name string) (bool, []string, error) {
+	on := false
+	rest := []string{}
+	for _, arg := range args {
+		flag, value, hasValue := strings.Cut(arg, "=")
+		if flag != name {
+			rest = append(rest, arg)
+			continue
+		}
+		on = true
+		if hasValue {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return false, nil, fmt.Errorf("%s: invalid value %q", name, value)
+			}
+			on = b
+		}
+	}
+	return on, rest, nil
+}
+
 // readAllTrackerAddresses reads all tracker addresses from config file
 func readAllTrackerAddresses(configFile string) []string {
 	file, err := os.Open(configFile)
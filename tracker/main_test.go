@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// sourceDir is the package directory, for tests that read the source.
+var sourceDir string
+
+// TestMain runs the tracker tests from a scratch directory so that the
+// asynchronous SaveState calls made by handlers never touch the source tree.
+func TestMain(m *testing.M) {
+	sourceDir, _ = os.Getwd()
+	dir, err := os.MkdirTemp("", "tracker-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"p2p/common"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cluster membership, SWIM style. A tracker joins through the seeds in
+// tracker_info.txt, which tell the members it joined through about it, and
+// hear about later joins, failures and leaves from the others. Each
+// tracker probes a random live member every probeInterval. A member that
+// answers neither directly nor through indirectProbes other members
+// within failTimeout is marked failed, and the failure is broadcast.
+const (
+	probeInterval  = time.Second
+	probeTimeout   = 500 * time.Millisecond
+	failTimeout    = 2 * time.Second
+	indirectProbes = 2
+)
+
+// Member statuses.
+const (
+	MemberAlive  = "alive"
+	MemberFailed = "failed"
+	MemberLeft   = "left"
+)
+
+// trackerVersion is the tracker's release, announced when it joins.
+// Release builds set it with -ldflags "-X main.trackerVersion=v1.4.0".
+var trackerVersion = "dev"
+
+// activeRequests counts the connections handleConn is serving; it is the
+// load a tracker announces.
+var activeRequests atomic.Int64
+
+// Member is one tracker of the cluster.
+type Member struct {
+	Addr    string `json:"addr"` // tracker_info.txt entry, so it is dialled the same way
+	Load    int64  `json:"load"` // requests being handled when last heard from
+	Version string `json:"version"`
+	// Incarnation orders what is said about a member: a tracker bumps its
+	// own to refute a failure, and news about an older one is ignored.
+	Incarnation uint64    `json:"incarnation"`
+	Status      string    `json:"status"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Membership is a tracker's view of the cluster. Members are keyed by
+// host:port, so entries with and without TLS details match.
+type Membership struct {
+	mu      sync.Mutex
+	self    Member
+	members map[string]*Member
+
+	load         func() int64
+	dial         func(entry string, timeout time.Duration) (net.Conn, error)
+	probeTimeout time.Duration
+	failTimeout  time.Duration
+
+	// onChange gets the members sync messages should go to whenever that
+	// set changes: all but those that left. Failed members stay, so the
+	// dead letter queue keeps their messages until they are back.
+	onChange func(peers []string)
+}
+
+// trackerMembers is this tracker's membership, set up at startup.
+var trackerMembers *Membership
+
+// NewMembership returns the membership of the tracker at self, which
+// assumes seeds are alive until it has probed them.
+func NewMembership(self string, seeds []string) *Membership {
+	ms := &Membership{
+		self: Member{
+			Addr:    self,
+			Version: trackerVersion,
+			Status:  MemberAlive,
+			// A restarted tracker outranks what was said about its last run
+			Incarnation: uint64(time.Now().UnixMilli()),
+		},
+		members:      make(map[string]*Member),
+		load:         activeRequests.Load,
+		dial:         dialPeerTracker,
+		probeTimeout: probeTimeout,
+		failTimeout:  failTimeout,
+	}
+	for _, s := range seeds {
+		if key := memberKey(s); key != memberKey(self) {
+			ms.members[key] = &Member{Addr: s, Status: MemberAlive, UpdatedAt: time.Now().UTC()}
+		}
+	}
+	return ms
+}
+
+func memberKey(entry string) string {
+	return common.ParseTrackerEndpoint(entry).Addr
+}
+
+// Self returns this tracker's member entry with its current load.
+func (ms *Membership) Self() Member {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.selfLocked()
+}
+
+func (ms *Membership) selfLocked() Member {
+	s := ms.self
+	s.Load = ms.load()
+	s.UpdatedAt = time.Now().UTC()
+	return s
+}
+
+// Members returns every member, this tracker included, by address.
+func (ms *Membership) Members() []Member {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	list := []Member{ms.selfLocked()}
+	for _, m := range ms.members {
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Addr < list[j].Addr })
+	return list
+}
+
+// Status returns what this tracker knows of the member at entry.
+func (ms *Membership) Status(entry string) string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if m, ok := ms.members[memberKey(entry)]; ok {
+		return m.Status
+	}
+	return ""
+}
+
+// alive returns up to n live members other than except, in random order.
+func (ms *Membership) alive(n int, except string) []string {
+	ms.mu.Lock()
+	var addrs []string
+	for key, m := range ms.members {
+		if m.Status == MemberAlive && key != memberKey(except) {
+			addrs = append(addrs, m.Addr)
+		}
+	}
+	ms.mu.Unlock()
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	if n >= 0 && len(addrs) > n {
+		addrs = addrs[:n]
+	}
+	return addrs
+}
+
+// peersLocked lists the members sync messages go to. Caller must hold ms.mu.
+func (ms *Membership) peersLocked() []string {
+	var peers []string
+	for _, m := range ms.members {
+		if m.Status != MemberLeft {
+			peers = append(peers, m.Addr)
+		}
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// changed passes the new sync peers to onChange. Call it without ms.mu held.
+func (ms *Membership) changed(peers []string) {
+	if ms.onChange != nil {
+		ms.onChange(peers)
+	}
+}
+
+// markAlive records that m is alive, unless what we know is newer. It
+// reports whether m is new or was not alive before.
+func (ms *Membership) markAlive(m Member) bool {
+	key := memberKey(m.Addr)
+	ms.mu.Lock()
+	if key == memberKey(ms.self.Addr) {
+		ms.mu.Unlock()
+		return false
+	}
+	cur, ok := ms.members[key]
+	if ok && (m.Incarnation < cur.Incarnation || (m.Incarnation == cur.Incarnation && cur.Status != MemberAlive)) {
+		ms.mu.Unlock()
+		return false
+	}
+	news := !ok || cur.Status != MemberAlive
+	m.Status = MemberAlive
+	m.UpdatedAt = time.Now().UTC()
+	ms.members[key] = &m
+	peers := ms.peersLocked()
+	ms.mu.Unlock()
+
+	if news {
+		fmt.Printf("[members] %s is alive (load %d, version %s)\n", m.Addr, m.Load, m.Version)
+		ms.changed(peers)
+	}
+	return news
+}
+
+// markDown records that the member at entry failed or left, at
+// incarnation inc. It reports whether that changed its status.
+func (ms *Membership) markDown(entry string, inc uint64, status string) bool {
+	ms.mu.Lock()
+	cur, ok := ms.members[memberKey(entry)]
+	if !ok || inc < cur.Incarnation || cur.Status == status || cur.Status == MemberLeft {
+		ms.mu.Unlock()
+		return false
+	}
+	cur.Status = status
+	cur.Incarnation = inc
+	cur.UpdatedAt = time.Now().UTC()
+	peers := ms.peersLocked()
+	ms.mu.Unlock()
+
+	fmt.Printf("[members] %s %s\n", cur.Addr, status)
+	ms.changed(peers)
+	return true
+}
+
+// call sends msg to the tracker at entry and returns its reply, giving up
+// after timeout.
+func (ms *Membership) call(entry string, msg Message, timeout time.Duration) (Response, error) {
+	if timeout <= 0 {
+		return Response{}, errors.New("timed out")
+	}
+	conn, err := ms.dial(entry, timeout)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := common.Send(conn, msg); err != nil {
+		return Response{}, err
+	}
+	var resp Response
+	if err := common.Recv(conn, &resp); err != nil {
+		return Response{}, err
+	}
+	if resp.Status != "ok" {
+		return resp, fmt.Errorf("%v", resp.Data)
+	}
+	return resp, nil
+}
+
+// broadcast sends msg to every live member but except, and waits for them.
+func (ms *Membership) broadcast(msg Message, except string) {
+	var wg sync.WaitGroup
+	for _, addr := range ms.alive(-1, except) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ms.call(addr, msg, ms.failTimeout)
+		}()
+	}
+	wg.Wait()
+}
+
+// Join announces this tracker to the seeds it was created with and learns
+// the members they know. It returns how many seeds answered.
+func (ms *Membership) Join() int {
+	self, err := json.Marshal(ms.Self())
+	if err != nil {
+		return 0
+	}
+	joined := 0
+	for _, seed := range ms.alive(-1, "") {
+		resp, err := ms.call(seed, Message{Cmd: "member_join", Args: []string{string(self)}}, ms.failTimeout)
+		if err != nil {
+			continue
+		}
+		joined++
+		members, err := decodeMembers(resp.Data)
+		if err != nil {
+			continue
+		}
+		for _, m := range members {
+			if m.Status == MemberAlive {
+				ms.markAlive(m)
+			} else {
+				ms.markDown(m.Addr, m.Incarnation, m.Status)
+			}
+		}
+	}
+	return joined
+}
+
+// Leave tells every live member this tracker is shutting down, so they
+// stop probing it and drop it from their sync peers.
+func (ms *Membership) Leave() {
+	ms.mu.Lock()
+	ms.self.Status = MemberLeft
+	args := []string{ms.self.Addr, strconv.FormatUint(ms.self.Incarnation, 10)}
+	ms.mu.Unlock()
+	ms.broadcast(Message{Cmd: "member_leave", Args: args}, "")
+}
+
+// Run probes a random live member every probeInterval.
+func (ms *Membership) Run() {
+	for range time.Tick(probeInterval) {
+		if targets := ms.alive(1, ""); len(targets) == 1 {
+			ms.probe(targets[0])
+		}
+	}
+}
+
+// probe checks target answers, directly or through other members, and
+// marks it failed and tells the others if it doesn't.
+func (ms *Membership) probe(target string) {
+	ms.mu.Lock()
+	cur, ok := ms.members[memberKey(target)]
+	var inc uint64
+	if ok {
+		inc = cur.Incarnation
+	}
+	ms.mu.Unlock()
+
+	if ms.reachable(target) {
+		return
+	}
+	if ms.markDown(target, inc, MemberFailed) {
+		ms.broadcast(Message{Cmd: "member_fail", Args: []string{target, strconv.FormatUint(inc, 10)}}, target)
+	}
+}
+
+// reachable pings target and, if it doesn't answer, asks indirectProbes
+// other members to. Every probe must answer within failTimeout.
+func (ms *Membership) reachable(target string) bool {
+	deadline := time.Now().Add(ms.failTimeout)
+	if ms.ping(target) {
+		return true
+	}
+	helpers := ms.alive(indirectProbes, target)
+	acks := make(chan bool, len(helpers))
+	for _, h := range helpers {
+		go func() {
+			_, err := ms.call(h, Message{Cmd: "member_ping_req", Args: []string{target}}, time.Until(deadline))
+			acks <- err == nil
+		}()
+	}
+	for range helpers {
+		if <-acks {
+			return true
+		}
+	}
+	return false
+}
+
+// ping sends target a member_ping, noting the load it answers with.
+func (ms *Membership) ping(target string) bool {
+	resp, err := ms.call(target, Message{Cmd: "member_ping"}, ms.probeTimeout)
+	if err != nil {
+		return false
+	}
+	if members, err := decodeMembers([]interface{}{resp.Data}); err == nil && len(members) == 1 {
+		ms.markAlive(members[0])
+	}
+	return true
+}
+
+// Handle answers a membership message from another tracker.
+func (ms *Membership) Handle(msg Message) Response {
+	args := msg.Args
+	switch msg.Cmd {
+	case "member_join", "member_alive":
+		if len(args) < 1 {
+			return Response{"error", msg.Cmd + ": need member"}
+		}
+		var m Member
+		if err := json.Unmarshal([]byte(args[0]), &m); err != nil || m.Addr == "" {
+			return Response{"error", msg.Cmd + ": invalid member"}
+		}
+		if msg.Cmd == "member_alive" {
+			ms.markAlive(m)
+			return Response{"ok", "noted"}
+		}
+		// A join always counts: the tracker has just started
+		ms.mu.Lock()
+		if cur, ok := ms.members[memberKey(m.Addr)]; ok && cur.Incarnation > m.Incarnation {
+			m.Incarnation = cur.Incarnation + 1
+		}
+		ms.mu.Unlock()
+		if ms.markAlive(m) {
+			alive, _ := json.Marshal(m)
+			go ms.broadcast(Message{Cmd: "member_alive", Args: []string{string(alive)}}, m.Addr)
+		}
+		return Response{"ok", ms.Members()}
+
+	case "member_ping":
+		return Response{"ok", ms.Self()}
+
+	case "member_ping_req":
+		if len(args) < 1 {
+			return Response{"error", "member_ping_req: need target"}
+		}
+		if !ms.ping(args[0]) {
+			return Response{"error", "no ack"}
+		}
+		return Response{"ok", "ack"}
+
+	case "member_fail", "member_leave":
+		if len(args) < 2 {
+			return Response{"error", msg.Cmd + ": need addr, incarnation"}
+		}
+		inc, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return Response{"error", msg.Cmd + ": bad incarnation"}
+		}
+		if msg.Cmd == "member_leave" {
+			ms.markDown(args[0], inc, MemberLeft)
+			return Response{"ok", "noted"}
+		}
+		if memberKey(args[0]) == memberKey(ms.Self().Addr) {
+			go ms.refute(inc)
+			return Response{"ok", "refuted"}
+		}
+		ms.markDown(args[0], inc, MemberFailed)
+		return Response{"ok", "noted"}
+	}
+	return Response{"error", "unknown membership command"}
+}
+
+// refute answers a failure reported about this tracker at incarnation inc
+// by announcing itself alive at a newer one.
+func (ms *Membership) refute(inc uint64) {
+	ms.mu.Lock()
+	if ms.self.Incarnation <= inc {
+		ms.self.Incarnation = inc + 1
+	}
+	self := ms.selfLocked()
+	ms.mu.Unlock()
+
+	fmt.Printf("[members] refuting reported failure at incarnation %d\n", inc)
+	data, err := json.Marshal(self)
+	if err != nil {
+		return
+	}
+	// Members that marked us failed don't probe us, so everyone is told
+	for _, m := range ms.Members() {
+		if memberKey(m.Addr) != memberKey(self.Addr) && m.Status != MemberLeft {
+			ms.call(m.Addr, Message{Cmd: "member_alive", Args: []string{string(data)}}, ms.failTimeout)
+		}
+	}
+}
+
+// decodeMembers converts a list of members from a decoded JSON response.
+func decodeMembers(data interface{}) ([]Member, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var members []Member
+	err = json.Unmarshal(raw, &members)
+	return members, err
+}
+
+// listMembers shows this tracker's view of the cluster.
+func listMembers([]string) Response {
+	if trackerMembers == nil {
+		return Response{"error", "membership not started"}
+	}
+	return Response{"ok", trackerMembers.Members()}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"p2p/common"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMember is a mock tracker that answers membership messages only.
+type testMember struct {
+	*Membership
+	ln net.Listener
+
+	peersMu sync.Mutex
+	peers   []string // last set passed to onChange
+}
+
+// startMember starts a mock tracker joining through seeds, with short timeouts.
+func startMember(t *testing.T, seeds ...string) *testMember {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	m := &testMember{Membership: NewMembership(ln.Addr().String(), seeds), ln: ln}
+	m.probeTimeout = 100 * time.Millisecond
+	m.failTimeout = 400 * time.Millisecond
+	m.onChange = func(peers []string) {
+		m.peersMu.Lock()
+		m.peers = peers
+		m.peersMu.Unlock()
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(2 * time.Second))
+				var msg Message
+				if common.Recv(conn, &msg) == nil {
+					common.Send(conn, m.Handle(msg))
+				}
+			}()
+		}
+	}()
+	return m
+}
+
+func (m *testMember) addr() string { return m.ln.Addr().String() }
+
+func (m *testMember) syncPeers() []string {
+	m.peersMu.Lock()
+	defer m.peersMu.Unlock()
+	return m.peers
+}
+
+// startCluster starts n mock trackers, each joining through the first.
+func startCluster(t *testing.T, n int) []*testMember {
+	t.Helper()
+	cluster := []*testMember{startMember(t)}
+	for i := 1; i < n; i++ {
+		m := startMember(t, cluster[0].addr())
+		if joined := m.Join(); joined != 1 {
+			t.Fatalf("member %d joined through %d seeds", i, joined)
+		}
+		cluster = append(cluster, m)
+	}
+	for _, m := range cluster {
+		for _, other := range cluster {
+			if other != m {
+				waitForStatus(t, m, other.addr(), MemberAlive)
+			}
+		}
+	}
+	return cluster
+}
+
+// waitForStatus waits for m to see the member at addr as status.
+func waitForStatus(t *testing.T, m *testMember, addr, status string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for m.Status(addr) != status {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s sees %s as %q, want %q", m.addr(), addr, m.Status(addr), status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestMembership_Join checks a tracker joining through one seed learns the
+// members it knows, and they all learn about it.
+func TestMembership_Join(t *testing.T) {
+	a := startMember(t)
+	a.mu.Lock()
+	a.load = func() int64 { return 7 }
+	a.mu.Unlock()
+	b := startMember(t, a.addr())
+	if b.Join() != 1 {
+		t.Fatal("b couldn't join through a")
+	}
+	waitForStatus(t, a, b.addr(), MemberAlive)
+
+	c := startMember(t, a.addr())
+	c.Join()
+	// c hears of b from a, and b of c from a's broadcast
+	waitForStatus(t, c, b.addr(), MemberAlive)
+	waitForStatus(t, b, c.addr(), MemberAlive)
+
+	want := []string{a.addr(), c.addr()}
+	sort.Strings(want)
+	if got := b.syncPeers(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("b syncs to %v, want %v", got, want)
+	}
+	for _, m := range c.Members() {
+		if m.Addr == a.addr() && (m.Load != 7 || m.Version != trackerVersion) {
+			t.Errorf("c sees a as %+v", m)
+		}
+	}
+	if resp := a.Handle(Message{Cmd: "member_join", Args: []string{"not json"}}); resp.Status != "error" {
+		t.Errorf("join with bad member = %+v", resp)
+	}
+}
+
+// TestMembership_Leave checks a tracker shutting down is dropped by the
+// others, from their sync peers too, and not probed.
+func TestMembership_Leave(t *testing.T) {
+	cluster := startCluster(t, 3)
+	a, b, c := cluster[0], cluster[1], cluster[2]
+
+	c.Leave()
+	waitForStatus(t, a, c.addr(), MemberLeft)
+	waitForStatus(t, b, c.addr(), MemberLeft)
+	for _, peer := range a.syncPeers() {
+		if peer == c.addr() {
+			t.Errorf("a still syncs to c: %v", a.syncPeers())
+		}
+	}
+	if targets := a.alive(-1, ""); len(targets) != 1 || targets[0] != b.addr() {
+		t.Errorf("a probes %v after c left", targets)
+	}
+	// A stale failure report doesn't bring it back as failed
+	a.Handle(Message{Cmd: "member_fail", Args: []string{c.addr(), "0"}})
+	if got := a.Status(c.addr()); got != MemberLeft {
+		t.Errorf("c is %q after stale failure", got)
+	}
+}
+
+// TestMembership_FailureDetection checks a tracker that stops answering is
+// marked failed within failTimeout and the failure reaches the others,
+// while one only some members can't reach stays alive.
+func TestMembership_FailureDetection(t *testing.T) {
+	cluster := startCluster(t, 3)
+	a, b, c := cluster[0], cluster[1], cluster[2]
+
+	// a can't reach c, but b can: the indirect probe keeps c alive
+	a.dial = func(entry string, timeout time.Duration) (net.Conn, error) {
+		if memberKey(entry) == c.addr() {
+			return nil, errors.New("connection refused")
+		}
+		return dialTracker(entry, timeout)
+	}
+	a.probe(c.addr())
+	if got := a.Status(c.addr()); got != MemberAlive {
+		t.Errorf("c reachable through b is %q", got)
+	}
+	a.dial = dialTracker
+
+	c.ln.Close()
+	start := time.Now()
+	a.probe(c.addr())
+	if elapsed := time.Since(start); elapsed > a.failTimeout+a.probeTimeout+200*time.Millisecond {
+		t.Errorf("probe took %v", elapsed)
+	}
+	if got := a.Status(c.addr()); got != MemberFailed {
+		t.Fatalf("a sees stopped c as %q", got)
+	}
+	waitForStatus(t, b, c.addr(), MemberFailed)
+	// Failed members stay sync peers, so the dead letter queue keeps their messages
+	if peers := a.syncPeers(); len(peers) != 2 {
+		t.Errorf("a syncs to %v", peers)
+	}
+}
+
+// TestMembership_Refute checks a tracker told it has failed announces
+// itself alive at a newer incarnation.
+func TestMembership_Refute(t *testing.T) {
+	cluster := startCluster(t, 2)
+	a, b := cluster[0], cluster[1]
+	inc := b.Self().Incarnation
+
+	a.markDown(b.addr(), inc, MemberFailed)
+	if resp := b.Handle(Message{Cmd: "member_fail", Args: []string{b.addr(), strconv.FormatUint(inc, 10)}}); resp.Data != "refuted" {
+		t.Fatalf("member_fail about b = %+v", resp)
+	}
+	waitForStatus(t, a, b.addr(), MemberAlive)
+	if got := b.Self().Incarnation; got <= inc {
+		t.Errorf("b's incarnation %d, was %d", got, inc)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HandlerFunc answers a request from its arguments.
+type HandlerFunc func(args []string) Response
+
+// Middleware wraps a HandlerFunc with a concern shared by many commands,
+// such as checking who sent the request. It may answer without calling
+// the handler it wraps.
+type Middleware func(HandlerFunc) HandlerFunc
+
+// chain wraps h in mws, the first one outermost, so it runs first.
+func chain(h HandlerFunc, mws ...Middleware) HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// commandMiddleware returns the middleware every request for the command
+// name goes through, in order. Sync commands come from peer trackers
+// rather than users, so they skip authentication and rate limiting.
+func commandMiddleware(name string, c trackerCommand) []Middleware {
+	mws := []Middleware{AuditMiddleware(name), ArgsMiddleware(name, c.spec)}
+	if !c.sync {
+		mws = append(mws, AuthMiddleware(c.spec), RateLimitMiddleware(c.spec))
+	}
+	return mws
+}
+
+// AuditMiddleware records audited commands and their outcome in
+// trackerAudit.
+func AuditMiddleware(cmd string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(args []string) Response {
+			resp := next(args)
+			trackerAudit.Record(cmd, args, resp.Status)
+			return resp
+		}
+	}
+}
+
+// ArgsMiddleware refuses requests with fewer arguments than spec needs.
+func ArgsMiddleware(cmd string, spec CommandSpec) Middleware {
+	n := spec.requiredArgs()
+	return func(next HandlerFunc) HandlerFunc {
+		return func(args []string) Response {
+			if len(args) < n {
+				return Response{"error", fmt.Sprintf("%s: need %s", cmd, strings.Join(spec.Args[:n], ", "))}
+			}
+			return next(args)
+		}
+	}
+}
+
+// AuthMiddleware refuses requests to commands that act as a user when
+// they don't say which user: clients send an empty user ID until they
+// have logged in.
+func AuthMiddleware(spec CommandSpec) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		if !spec.RequiresAuth {
+			return next
+		}
+		return func(args []string) Response {
+			if user, ok := spec.actingUser(args); ok && user == "" {
+				return Response{"error", "not logged in"}
+			}
+			return next(args)
+		}
+	}
+}
+
+// RateLimitMiddleware refuses requests over their user's rate limit in
+// trackerRateLimiter. Requests without a user aren't limited.
+func RateLimitMiddleware(spec CommandSpec) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(args []string) Response {
+			if !trackerRateLimiter.Allow(spec.requestUser(args)) {
+				return Response{"error", errRateLimited}
+			}
+			return next(args)
+		}
+	}
+}
+
+// actingUser returns the user a request acts as: its first userID,
+// ownerID or adminID argument. ok is false if the command has none.
+func (s CommandSpec) actingUser(args []string) (user string, ok bool) {
+	for i, a := range s.Args {
+		switch strings.TrimSuffix(a, "?") {
+		case "userID", "ownerID", "adminID":
+			if i < len(args) {
+				return args[i], true
+			}
+			return "", !strings.HasSuffix(a, "?")
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// recordingMiddleware notes name in calls on the way in and name+"'" on
+// the way out.
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(args []string) Response {
+			*calls = append(*calls, name)
+			resp := next(args)
+			*calls = append(*calls, name+"'")
+			return resp
+		}
+	}
+}
+
+func TestChain_Order(t *testing.T) {
+	var calls []string
+	h := chain(func([]string) Response {
+		calls = append(calls, "handler")
+		return Response{"ok", nil}
+	}, recordingMiddleware("a", &calls), recordingMiddleware("b", &calls), recordingMiddleware("c", &calls))
+	h(nil)
+	if got := strings.Join(calls, " "); got != "a b c handler c' b' a'" {
+		t.Errorf("calls = %s", got)
+	}
+}
+
+// useTestCommand registers c as test_cmd, with its middleware, for the
+// test, and returns how many times its handler ran.
+func useTestCommand(t *testing.T, c trackerCommand) *int {
+	t.Helper()
+	ran := new(int)
+	c.handle = func(Message, net.Addr) Response {
+		*ran++
+		return Response{"ok", "ran"}
+	}
+	c.middleware = commandMiddleware("test_cmd", c)
+	trackerCommands["test_cmd"] = c
+	t.Cleanup(func() { delete(trackerCommands, "test_cmd") })
+	return ran
+}
+
+// TestAuthMiddleware_ShortCircuits checks a request not naming its user
+// is refused before the rate limiter or the handler see it, and sync
+// commands aren't checked.
+func TestAuthMiddleware_ShortCircuits(t *testing.T) {
+	l, _ := testLimiter(1, 1)
+	saved := trackerRateLimiter
+	trackerRateLimiter = l
+	t.Cleanup(func() { trackerRateLimiter = saved })
+	ran := useTestCommand(t, trackerCommand{spec: CommandSpec{"test", []string{"groupID", "userID"}, true}})
+
+	if resp := runCommand(Message{Cmd: "test_cmd", Args: []string{"g1", ""}}, nil); resp.Data != "not logged in" || *ran != 0 {
+		t.Errorf("without a user: %+v, handler ran %d times", resp, *ran)
+	}
+	if resp := runCommand(Message{Cmd: "test_cmd", Args: []string{"g1", "alice"}}, nil); resp.Data != "ran" || *ran != 1 {
+		t.Errorf("as alice: %+v, handler ran %d times", resp, *ran)
+	}
+	if resp := runCommand(Message{Cmd: "test_cmd", Args: []string{"g1", "alice"}}, nil); resp.Data != errRateLimited || *ran != 1 {
+		t.Errorf("over the limit: %+v, handler ran %d times", resp, *ran)
+	}
+
+	ran = useTestCommand(t, trackerCommand{sync: true, spec: CommandSpec{"test", []string{"groupID", "userID"}, true}})
+	if resp := runCommand(Message{Cmd: "test_cmd", Args: []string{"g1", ""}}, nil); resp.Status != "ok" || *ran != 1 {
+		t.Errorf("sync command without a user: %+v", resp)
+	}
+}
+
+func TestActingUser(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		req  []string
+		user string
+		ok   bool
+	}{
+		{[]string{"groupID", "userID"}, []string{"g1", "alice"}, "alice", true},
+		{[]string{"groupID", "ownerID", "userID"}, []string{"g1", "alice", "bob"}, "alice", true},
+		{[]string{"groupID", "fileName", "adminID"}, []string{"g1", "a.txt", ""}, "", true},
+		{[]string{"groupID", "userID?"}, []string{"g1"}, "", false},
+		{[]string{"groupID"}, []string{"g1"}, "", false},
+	} {
+		user, ok := CommandSpec{Args: tc.args}.actingUser(tc.req)
+		if user != tc.user || ok != tc.ok {
+			t.Errorf("actingUser(%v, %v) = %q, %v", tc.args, tc.req, user, ok)
+		}
+	}
+}
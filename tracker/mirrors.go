@@ -0,0 +1,63 @@
+package main
+
+import "fmt"
+
+// setMirrors sets the groups a group's uploads are copied to, as
+// references. Only the owner may do this; no mirrors clears the list.
+// args: [groupID, ownerID, mirrorGroupIDs...]
+func setMirrors(args []string) Response {
+	if len(args) < 2 {
+		return Response{"error", "set_mirrors: need groupID, ownerID, mirrorGroupIDs..."}
+	}
+	groupID, owner := args[0], args[1]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if g.Owner != owner {
+		return Response{"error", "not owner"}
+	}
+	var mirrors []string
+	seen := make(map[string]bool)
+	for _, m := range args[2:] {
+		if m == groupID {
+			return Response{"error", "a group can't mirror to itself"}
+		}
+		if _, ok := groups[m]; !ok {
+			return Response{"error", fmt.Sprintf("mirror group %s not found", m)}
+		}
+		if !seen[m] {
+			seen[m] = true
+			mirrors = append(mirrors, m)
+		}
+	}
+
+	g.Mirrors = mirrors
+	g.Version++
+	fmt.Printf("Mirrors for group %s set to %v\n", groupID, mirrors)
+	go SaveState()
+	go trackerEvents.Publish(EventGroupMirrorsSet, groupSync(g, "sync_set_mirrors", append([]string{groupID}, mirrors...)))
+	if len(mirrors) == 0 {
+		return Response{"ok", "mirrors cleared"}
+	}
+	return Response{"ok", "mirrors updated"}
+}
+
+// mirrorUpload adds f to each of its group's mirrors on behalf of userID
+// and returns the groups it was added to. A mirror that refuses it is
+// logged and skipped; the upload itself stands. Caller must hold mu.
+func mirrorUpload(g *Group, f *File, userID string) []string {
+	var mirrored []string
+	for _, m := range g.Mirrors {
+		if _, err := addReference(f, m, userID); err != nil {
+			fmt.Printf("Warning: not mirroring %s to group %s: %v\n", f.FileName, m, err)
+			continue
+		}
+		mirrored = append(mirrored, m)
+	}
+	return mirrored
+}
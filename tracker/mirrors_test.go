@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// resetMirrorState sets up g1 (alice, bob) plus g2 and g3 with alice as a
+// member, and mirrors g1's uploads to g2 and g3.
+func resetMirrorState(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice", "bob")
+	mu.Lock()
+	for _, id := range []string{"g2", "g3"} {
+		groups[id] = &Group{GroupID: id, Owner: "alice",
+			Members: map[string]bool{"alice": true}, Pending: map[string]bool{}}
+	}
+	mu.Unlock()
+	if resp := setMirrors([]string{"g1", "alice", "g2", "g3"}); resp.Status != "ok" {
+		t.Fatalf("set_mirrors: %+v", resp)
+	}
+}
+
+func mirroredTo(t *testing.T, resp Response) []string {
+	t.Helper()
+	if resp.Status != "ok" {
+		t.Fatalf("upload: %+v", resp)
+	}
+	data := resp.Data.(map[string]interface{})
+	got, _ := data["mirrored_to"].([]string)
+	return got
+}
+
+func TestMirrors_Upload(t *testing.T) {
+	resetMirrorState(t)
+
+	resp := uploadFile([]string{"report.pdf", "g1", "alice", "10", "hash-report", "[]"})
+	if got := mirroredTo(t, resp); !reflect.DeepEqual(got, []string{"g2", "g3"}) {
+		t.Fatalf("mirrored_to = %v", got)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, g := range []string{"g2", "g3"} {
+		f := files[g+":report.pdf"]
+		if f == nil || !f.IsReference || f.FileHash != "hash-report" {
+			t.Errorf("mirror in %s = %+v", g, f)
+		}
+	}
+}
+
+// TestMirrors_Rejected checks a mirror that refuses the file is skipped
+// without failing the upload: g2 is over quota, g3 doesn't have bob and
+// g4 no longer exists.
+func TestMirrors_Rejected(t *testing.T) {
+	resetMirrorState(t)
+	mu.Lock()
+	groups["g4"] = &Group{GroupID: "g4", Owner: "alice", Members: map[string]bool{"alice": true, "bob": true}}
+	groups["g2"].Members["bob"] = true
+	groups["g2"].StorageQuota = 5
+	mu.Unlock()
+	if resp := setMirrors([]string{"g1", "alice", "g2", "g3", "g4"}); resp.Status != "ok" {
+		t.Fatalf("set_mirrors: %+v", resp)
+	}
+	mu.Lock()
+	delete(groups, "g4")
+	mu.Unlock()
+
+	resp := uploadFile([]string{"report.pdf", "g1", "bob", "10", "hash-report", "[]"})
+	if got := mirroredTo(t, resp); len(got) != 0 {
+		t.Fatalf("mirrored_to = %v, want none", got)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if files["g1:report.pdf"] == nil {
+		t.Fatal("original upload missing")
+	}
+	for _, g := range []string{"g2", "g3", "g4"} {
+		if f := files[g+":report.pdf"]; f != nil {
+			t.Errorf("rejected mirror %s got the file: %+v", g, f)
+		}
+	}
+}
+
+func TestSetMirrors_Validation(t *testing.T) {
+	resetMirrorState(t)
+	if resp := setMirrors([]string{"g1", "bob", "g2"}); resp.Status != "error" {
+		t.Errorf("non-owner set mirrors: %+v", resp)
+	}
+	if resp := setMirrors([]string{"g1", "alice", "nope"}); resp.Status != "error" {
+		t.Errorf("mirrored to a missing group: %+v", resp)
+	}
+	if resp := setMirrors([]string{"g1", "alice", "g1"}); resp.Status != "error" {
+		t.Errorf("mirrored to itself: %+v", resp)
+	}
+	if resp := setMirrors([]string{"g1", "alice"}); resp.Status != "ok" {
+		t.Fatalf("clearing mirrors: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if groups["g1"].Mirrors != nil {
+		t.Errorf("mirrors not cleared: %v", groups["g1"].Mirrors)
+	}
+}
+
+// TestMirrors_Synced replays set_mirrors on a tracker that hasn't seen it.
+func TestMirrors_Synced(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice")
+	mu.Lock()
+	groups["g2"] = &Group{GroupID: "g2", Owner: "alice", Members: map[string]bool{"alice": true}}
+	mu.Unlock()
+	saved := trackerEvents
+	trackerEvents = NewEventBus()
+	t.Cleanup(func() { trackerEvents = saved })
+	published := make(chan Message, 1)
+	trackerEvents.Subscribe(EventGroupMirrorsSet, func(msg Message) { published <- msg })
+
+	if resp := setMirrors([]string{"g1", "alice", "g2"}); resp.Status != "ok" {
+		t.Fatalf("set_mirrors: %+v", resp)
+	}
+	var msg Message
+	select {
+	case msg = <-published:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event for set_mirrors")
+	}
+
+	mu.Lock()
+	groups["g1"].Mirrors = nil
+	groups["g1"].Version = msg.Version - 1
+	mu.Unlock()
+	if resp := applySync(msg); resp.Status != "ok" {
+		t.Fatalf("applySync: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if !reflect.DeepEqual(groups["g1"].Mirrors, []string{"g2"}) {
+		t.Errorf("sync_set_mirrors not applied: %v", groups["g1"].Mirrors)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import "fmt"
+
+// isModerator reports whether userID moderates g. Caller must hold mu.
+func isModerator(g *Group, userID string) bool {
+	for _, m := range g.Moderators {
+		if m == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// canManageRequests reports whether userID may list and accept g's join
+// requests: the owner or a moderator. Caller must hold mu.
+func canManageRequests(g *Group, userID string) bool {
+	return g.Owner == userID || isModerator(g, userID)
+}
+
+// dropModerator removes userID from g's moderators, if present. Caller must hold mu.
+func dropModerator(g *Group, userID string) {
+	for i, m := range g.Moderators {
+		if m == userID {
+			g.Moderators = append(g.Moderators[:i:i], g.Moderators[i+1:]...)
+			return
+		}
+	}
+}
+
+// addModerator lets a member accept join requests. Only the owner may do this.
+// args: [groupID, ownerID, targetUserID]
+func addModerator(args []string) Response {
+	if len(args) < 3 {
+		return Response{"error", "add_moderator: need groupID, ownerID, targetUserID"}
+	}
+	groupID, owner, target := args[0], args[1], args[2]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if g.Owner != owner {
+		return Response{"error", "not owner"}
+	}
+	if target == g.Owner {
+		return Response{"error", "owner is already able to accept requests"}
+	}
+	if !g.Members[target] {
+		return Response{"error", "not a member"}
+	}
+	if isModerator(g, target) {
+		return Response{"error", "already a moderator"}
+	}
+
+	g.Moderators = append(g.Moderators, target)
+	g.Version++
+	fmt.Printf("%s is now a moderator of group %s\n", target, groupID)
+	go SaveState()
+	go trackerEvents.Publish(EventModeratorAdded, groupSync(g, "sync_add_moderator", []string{groupID, target}))
+	return Response{"ok", "moderator added"}
+}
+
+// removeModerator takes a member's moderator role away. Only the owner may do this.
+// args: [groupID, ownerID, targetUserID]
+func removeModerator(args []string) Response {
+	if len(args) < 3 {
+		return Response{"error", "remove_moderator: need groupID, ownerID, targetUserID"}
+	}
+	groupID, owner, target := args[0], args[1], args[2]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	g, ok := groups[groupID]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if g.Owner != owner {
+		return Response{"error", "not owner"}
+	}
+	if !isModerator(g, target) {
+		return Response{"error", "not a moderator"}
+	}
+
+	dropModerator(g, target)
+	g.Version++
+	fmt.Printf("%s is no longer a moderator of group %s\n", target, groupID)
+	go SaveState()
+	go trackerEvents.Publish(EventModeratorRemoved, groupSync(g, "sync_remove_moderator", []string{groupID, target}))
+	return Response{"ok", "moderator removed"}
+}
+
+// listModerators returns a group's moderators. args: [groupID]
+func listModerators(args []string) Response {
+	if len(args) < 1 {
+		return Response{"error", "list_moderators: need groupID"}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	g, ok := groups[args[0]]
+	if !ok {
+		return Response{"error", "group not found"}
+	}
+	if len(g.Moderators) == 0 {
+		return Response{"ok", "no moderators"}
+	}
+	return Response{"ok", append([]string(nil), g.Moderators...)}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestModerator_CanAcceptRequests promotes a member to moderator and checks
+// they can list and accept a join request while another member can't.
+func TestModerator_CanAcceptRequests(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice", "bob", "carol")
+
+	if resp := addModerator([]string{"g1", "bob", "carol"}); resp.Status != "error" {
+		t.Fatalf("non-owner promoted a moderator: %+v", resp)
+	}
+	if resp := addModerator([]string{"g1", "alice", "bob"}); resp.Status != "ok" {
+		t.Fatalf("add_moderator: %+v", resp)
+	}
+	if resp := joinGroup([]string{"g1", "dave"}); resp.Status != "ok" {
+		t.Fatalf("join_group: %+v", resp)
+	}
+
+	if resp := acceptRequest([]string{"g1", "carol", "dave"}); resp.Status != "error" {
+		t.Fatalf("non-moderator accepted a request: %+v", resp)
+	}
+	if resp := listRequests([]string{"g1", "bob"}); resp.Status != "ok" {
+		t.Fatalf("moderator list_requests: %+v", resp)
+	}
+	if resp := acceptRequest([]string{"g1", "bob", "dave"}); resp.Status != "ok" {
+		t.Fatalf("moderator accept_requests: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if !groups["g1"].Members["dave"] {
+		t.Error("dave not a member after the moderator accepted him")
+	}
+}
+
+func TestModerator_ListAndRemove(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice", "bob", "carol")
+	for _, m := range []string{"bob", "carol"} {
+		if resp := addModerator([]string{"g1", "alice", m}); resp.Status != "ok" {
+			t.Fatalf("add_moderator %s: %+v", m, resp)
+		}
+	}
+	if resp := addModerator([]string{"g1", "alice", "zed"}); resp.Status != "error" {
+		t.Errorf("promoted a non-member: %+v", resp)
+	}
+	if resp := listModerators([]string{"g1"}); !reflect.DeepEqual(resp.Data, []string{"bob", "carol"}) {
+		t.Fatalf("list_moderators = %+v", resp)
+	}
+
+	if resp := removeModerator([]string{"g1", "alice", "bob"}); resp.Status != "ok" {
+		t.Fatalf("remove_moderator: %+v", resp)
+	}
+	if resp := acceptRequest([]string{"g1", "bob", "dave"}); resp.Status != "error" {
+		t.Errorf("removed moderator could still accept: %+v", resp)
+	}
+	// Leaving the group ends the role too
+	if resp := leaveGroup([]string{"g1", "carol"}); resp.Status != "ok" {
+		t.Fatalf("leave_group: %+v", resp)
+	}
+	if resp := listModerators([]string{"g1"}); resp.Data != "no moderators" {
+		t.Errorf("list_moderators after removals = %+v", resp)
+	}
+}
+
+// TestModerator_Synced checks add_moderator publishes a sync message that
+// applies on a peer tracker.
+func TestModerator_Synced(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice", "bob")
+	saved := trackerEvents
+	trackerEvents = NewEventBus()
+	t.Cleanup(func() { trackerEvents = saved })
+	published := make(chan Message, 1)
+	trackerEvents.Subscribe(EventModeratorAdded, func(msg Message) { published <- msg })
+
+	if resp := addModerator([]string{"g1", "alice", "bob"}); resp.Status != "ok" {
+		t.Fatalf("add_moderator: %+v", resp)
+	}
+	var msg Message
+	select {
+	case msg = <-published:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event for add_moderator")
+	}
+
+	// Replay it against a tracker that hasn't seen the change
+	resetGroupState(t, "alice", "bob")
+	mu.Lock()
+	groups["g1"].Version = msg.Version - 1
+	mu.Unlock()
+	if resp := applySync(msg); resp.Status != "ok" {
+		t.Fatalf("applySync: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if !isModerator(groups["g1"], "bob") {
+		t.Errorf("sync_add_moderator not applied: %+v", groups["g1"].Moderators)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"p2p/common"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestServeMux_ConcurrentRequests opens one connection, switches it to
+// multiplexing with its first request and then runs many requests on it
+// at once, checking every response matches its request.
+func TestServeMux_ConcurrentRequests(t *testing.T) {
+	addr := startTestTracker(t)
+	useTestAuditLog(t, addr)
+	resetGroupState(t, "alice")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := common.Send(conn, Message{Cmd: "list_groups", Mux: true}); err != nil {
+		t.Fatal(err)
+	}
+	var first Response
+	if err := common.Recv(conn, &first); err != nil || first.Status != "ok" {
+		t.Fatalf("first response %+v, %v", first, err)
+	}
+
+	m := common.NewMux(conn)
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Alternate a write and a read so responses differ per request
+			var resp Response
+			var err error
+			if i%2 == 0 {
+				err = m.Call(Message{Cmd: "create_user", Args: []string{fmt.Sprintf("mux%d", i), "pw"}}, &resp, 2*time.Second)
+				if err == nil && resp.Status != "ok" {
+					err = fmt.Errorf("create_user mux%d: %+v", i, resp)
+				}
+			} else {
+				err = m.Call(Message{Cmd: "get_group_info", Args: []string{"g1"}}, &resp, 2*time.Second)
+				info, _ := resp.Data.(map[string]interface{})
+				if err == nil && (resp.Status != "ok" || info["group_id"] != "g1") {
+					err = fmt.Errorf("get_group_info: %+v", resp)
+				}
+			}
+			if err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < n; i += 2 {
+		name := fmt.Sprintf("mux%d", i)
+		if users[name] == nil {
+			t.Errorf("%s not created", name)
+		}
+		delete(users, name)
+	}
+}
+
+// TestHandleConn_OneShotWithoutMux checks a request without Mux still gets
+// its response and the connection closed, as before.
+func TestHandleConn_OneShotWithoutMux(t *testing.T) {
+	addr := startTestTracker(t)
+	useTestAuditLog(t, addr)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	common.Send(conn, Message{Cmd: "list_groups"})
+	var resp Response
+	if err := common.Recv(conn, &resp); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err := common.Recv(conn, &resp); err == nil {
+		t.Fatal("connection stayed open without Mux")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+var errBadPageToken = errors.New("invalid page token")
+
+// pageCursor is the position after the last file of a page. Files are
+// ordered by upload time, and by name among files uploaded at once.
+type pageCursor struct {
+	createdAt time.Time
+	fileName  string
+}
+
+func (c pageCursor) precedes(f *File) bool {
+	if !f.CreatedAt.Equal(c.createdAt) {
+		return f.CreatedAt.After(c.createdAt)
+	}
+	return f.FileName > c.fileName
+}
+
+// encodePageToken turns the last file of a page into an opaque token.
+func encodePageToken(f *File) string {
+	raw := f.CreatedAt.UTC().Format(time.RFC3339Nano) + "\x00" + f.FileName
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePageToken(token string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return pageCursor{}, errBadPageToken
+	}
+	ts, name, ok := strings.Cut(string(raw), "\x00")
+	if !ok {
+		return pageCursor{}, errBadPageToken
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return pageCursor{}, errBadPageToken
+	}
+	return pageCursor{t, name}, nil
+}
+
+// parsePageSize reads a page size argument; "" means the default.
+func parsePageSize(s string) (int, error) {
+	if s == "" {
+		return defaultPageSize, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > maxPageSize {
+		return 0, errors.New("page size must be between 1 and " + strconv.Itoa(maxPageSize))
+	}
+	return n, nil
+}
+
+// pageFiles returns up to size files from byName that come after token
+// (all files if token is ""), in upload order, and the token for the next
+// page, which is "" on the last page. Reserved slots are left out.
+func pageFiles(byName map[string]*File, size int, token string) ([]*File, string, error) {
+	var cursor *pageCursor
+	if token != "" {
+		c, err := decodePageToken(token)
+		if err != nil {
+			return nil, "", err
+		}
+		cursor = &c
+	}
+
+	sorted := make([]*File, 0, len(byName))
+	for _, f := range byName {
+		if f.isReserved() {
+			continue
+		}
+		if cursor == nil || cursor.precedes(f) {
+			sorted = append(sorted, f)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.FileName < b.FileName
+	})
+
+	if len(sorted) <= size {
+		return sorted, "", nil
+	}
+	page := sorted[:size]
+	return page, encodePageToken(page[size-1]), nil
+}
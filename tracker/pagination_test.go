@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// putTimedFiles adds files named f0000, f0001, ... to g1, uploaded a second
+// apart in reverse name order so upload order and name order differ.
+func putTimedFiles(t *testing.T, n int) {
+	t.Helper()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("f%04d", i)
+		at := base.Add(time.Duration(n-i) * time.Second)
+		putFile("g1:"+name, &File{FileName: name, GroupID: "g1", Uploader: "alice", Owners: map[string]bool{"alice": true},
+			CreatedAt: at, UpdatedAt: at})
+	}
+}
+
+// listPage asks for one page of g1 and returns its file names and next token.
+func listPage(t *testing.T, size, token string) ([]string, string) {
+	t.Helper()
+	resp := listFiles([]string{"g1", "alice", size, token})
+	page, ok := resp.Data.(map[string]interface{})
+	if resp.Status != "ok" || !ok {
+		t.Fatalf("list_files page: %+v", resp)
+	}
+	var names []string
+	for _, f := range page["files"].([]map[string]interface{}) {
+		names = append(names, f["file_name"].(string))
+	}
+	return names, page["next_page_token"].(string)
+}
+
+// TestListFiles_Pages walks a small group page by page: the first page
+// uses the default size, later pages continue from the token in upload
+// order, and the last page has an empty token.
+func TestListFiles_Pages(t *testing.T) {
+	resetGroupState(t, "alice")
+	putTimedFiles(t, defaultPageSize+3)
+
+	first, next := listPage(t, "", "")
+	if len(first) != defaultPageSize || next == "" {
+		t.Fatalf("first page: %d files, next %q", len(first), next)
+	}
+	// Newest names were uploaded first
+	if want := fmt.Sprintf("f%04d", defaultPageSize+2); first[0] != want {
+		t.Errorf("first file = %s, want %s", first[0], want)
+	}
+
+	second, next := listPage(t, "2", next)
+	if len(second) != 2 || second[0] != "f0002" || second[1] != "f0001" || next == "" {
+		t.Fatalf("second page = %v, next %q", second, next)
+	}
+
+	last, next := listPage(t, "2", next)
+	if len(last) != 1 || last[0] != "f0000" || next != "" {
+		t.Fatalf("last page = %v, next %q", last, next)
+	}
+}
+
+// TestListFiles_ManyPages lists a large group and checks every file comes
+// back exactly once, including files uploaded at the same instant.
+func TestListFiles_ManyPages(t *testing.T) {
+	resetGroupState(t, "alice")
+	putTimedFiles(t, 1234)
+	same := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	mu.Lock()
+	for i := 0; i < 7; i++ {
+		name := "tie" + strconv.Itoa(i)
+		putFile("g1:"+name, &File{FileName: name, GroupID: "g1", Owners: map[string]bool{}, CreatedAt: same})
+	}
+	mu.Unlock()
+
+	seen := make(map[string]bool)
+	pages := 0
+	token := ""
+	for {
+		names, next := listPage(t, "100", token)
+		pages++
+		for _, name := range names {
+			if seen[name] {
+				t.Fatalf("%s listed twice", name)
+			}
+			seen[name] = true
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if len(seen) != 1241 || pages != 13 {
+		t.Errorf("listed %d files in %d pages, want 1241 in 13", len(seen), pages)
+	}
+}
+
+// TestListFiles_PageErrors checks bad sizes and tokens are refused, and
+// that a plain request still gets the whole list.
+func TestListFiles_PageErrors(t *testing.T) {
+	resetGroupState(t, "alice")
+	putTimedFiles(t, 3)
+
+	for _, size := range []string{"0", "-1", "x", strconv.Itoa(maxPageSize + 1)} {
+		if resp := listFiles([]string{"g1", "alice", size}); resp.Status != "error" {
+			t.Errorf("page size %q accepted: %+v", size, resp)
+		}
+	}
+	if resp := listFiles([]string{"g1", "alice", "10", "not a token"}); resp.Status != "error" {
+		t.Errorf("bad token accepted: %+v", resp)
+	}
+	if resp := listFiles([]string{"g1", "mallory", "10"}); resp.Status != "error" {
+		t.Errorf("non-member got a page: %+v", resp)
+	}
+
+	resp := listFiles([]string{"g1", "alice"})
+	if list, ok := resp.Data.([]map[string]interface{}); !ok || len(list) != 3 {
+		t.Errorf("unpaged list_files = %+v", resp)
+	}
+}
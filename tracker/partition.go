@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"p2p/common"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PartitionedPeers simulates network partitions for testing failure
+// handling: it maps a peer tracker's host:port to the time.Time its
+// partition ends. Until then sync messages to the peer are dropped, and
+// sync messages arriving from its host are dropped unanswered. Inbound
+// connections come from an ephemeral port, so they can only be matched
+// by host: every tracker on that host is cut off.
+var PartitionedPeers sync.Map
+
+// errPartitioned is returned for sync messages dropped by a partition.
+var errPartitioned = errors.New("partitioned")
+
+// partitioned reports whether the tracker_info.txt entry addr is cut off
+// by a partition, forgetting the partition once it has ended.
+func partitioned(addr string) bool {
+	addr = common.ParseTrackerEndpoint(addr).Addr
+	until, ok := PartitionedPeers.Load(addr)
+	if !ok {
+		return false
+	}
+	if time.Now().Before(until.(time.Time)) {
+		return true
+	}
+	PartitionedPeers.CompareAndDelete(addr, until)
+	return false
+}
+
+// partitionedHost reports whether remote's host is that of a partitioned
+// peer.
+func partitionedHost(remote net.Addr) bool {
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return false
+	}
+	found := false
+	PartitionedPeers.Range(func(k, _ any) bool {
+		if h, _, err := net.SplitHostPort(k.(string)); err == nil && h == host && partitioned(k.(string)) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// injectPartition cuts this tracker off from a peer tracker for a while.
+// It is an admin command, only answered for connections from the
+// tracker's own host.
+// args: [targetPeer, durationSeconds]
+func injectPartition(args []string, remote net.Addr) Response {
+	if !isLoopback(remote) {
+		return Response{"error", "inject_partition is only available from localhost"}
+	}
+	target := common.ParseTrackerEndpoint(args[0]).Addr
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return Response{"error", "invalid peer address: " + args[0]}
+	}
+	secs, err := strconv.Atoi(args[1])
+	if err != nil || secs <= 0 {
+		return Response{"error", "invalid duration: " + args[1]}
+	}
+	until := time.Now().Add(time.Duration(secs) * time.Second)
+	PartitionedPeers.Store(target, until)
+	fmt.Printf("[partition] dropping sync messages to and from %s until %s\n", target, until.Format(time.TimeOnly))
+	return Response{"ok", map[string]interface{}{"peer": target, "until": until.UTC()}}
+}
+
+// clearPartition ends a partition made by inject_partition, then pulls
+// the peer's state so writes made on either side meanwhile converge: the
+// peer gets ours the same way when it clears its side.
+// It is an admin command, only answered for connections from the
+// tracker's own host.
+// args: [targetPeer]
+func clearPartition(args []string, remote net.Addr) Response {
+	if !isLoopback(remote) {
+		return Response{"error", "clear_partition is only available from localhost"}
+	}
+	target := common.ParseTrackerEndpoint(args[0]).Addr
+	if _, ok := PartitionedPeers.LoadAndDelete(target); !ok {
+		return Response{"error", "no partition for " + target}
+	}
+	fmt.Printf("[partition] %s reachable again\n", target)
+	if err := pullStateFrom(args[0]); err != nil {
+		return Response{"ok", fmt.Sprintf("partition cleared; pulling state from %s failed: %v", target, err)}
+	}
+	return Response{"ok", "partition cleared, state merged from " + target}
+}
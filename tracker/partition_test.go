@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"p2p/common"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// peerTracker is the other side of a partition: a tracker holding only
+// users, applying sync_create_user and answering sync_pull.
+type peerTracker struct {
+	addr  string
+	mu    sync.Mutex
+	users map[string]*User
+}
+
+func startPeerTracker(t *testing.T) *peerTracker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	p := &peerTracker{addr: ln.Addr().String(), users: make(map[string]*User)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var msg Message
+			if common.Recv(conn, &msg) == nil {
+				p.mu.Lock()
+				resp := Response{"ok", nil}
+				switch msg.Cmd {
+				case "sync_create_user":
+					p.users[msg.Args[0]] = &User{UserID: msg.Args[0], Password: msg.Args[1], Version: msg.Version}
+				case "sync_pull":
+					resp.Data = SyncSnapshot{Users: p.users}
+				}
+				common.Send(conn, resp)
+				p.mu.Unlock()
+			}
+			conn.Close()
+		}
+	}()
+	return p
+}
+
+// userIDs lists the users in m, sorted.
+func userIDs(m map[string]*User) string {
+	var ids []string
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, " ")
+}
+
+// sendSync sends a sync message to the tracker at addr the way a peer
+// tracker does and returns its answer.
+func sendSync(addr string, msg Message) (Response, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := common.Send(conn, msg); err != nil {
+		return Response{}, err
+	}
+	var resp Response
+	err = common.Recv(conn, &resp)
+	return resp, err
+}
+
+// TestPartition_Converges partitions this tracker from a peer, creates a
+// user on each side, and checks neither hears of the other's until the
+// partition is cleared and each pulls the other's state.
+func TestPartition_Converges(t *testing.T) {
+	mu.Lock()
+	users = make(map[string]*User)
+	mu.Unlock()
+	savedEvents, savedPeers := trackerEvents, syncPeers()
+	trackerEvents = NewEventBus()
+	subscribeSync(trackerEvents)
+	t.Cleanup(func() {
+		trackerEvents = savedEvents
+		setSyncPeers(savedPeers)
+		PartitionedPeers.Clear()
+	})
+	peer := startPeerTracker(t)
+	setSyncPeers([]string{peer.addr})
+	addr := startTestTracker(t)
+
+	if resp := sendCmd(t, addr, "inject_partition", peer.addr, "60"); resp.Status != "ok" {
+		t.Fatalf("inject_partition: %+v", resp)
+	}
+	if resp := sendCmd(t, addr, "create_user", "alice", "pw"); resp.Status != "ok" {
+		t.Fatalf("create_user: %+v", resp)
+	}
+	peer.mu.Lock()
+	peer.users["bob"] = &User{UserID: "bob", Password: "pw", Version: 1}
+	peer.mu.Unlock()
+	if resp, err := sendSync(addr, Message{Cmd: "sync_create_user", Args: []string{"bob", "pw"}, Version: 1}); err == nil {
+		t.Errorf("sync from the partitioned peer answered: %+v", resp)
+	}
+	if resp := sendCmd(t, addr, "list_commands"); resp.Status != "ok" {
+		t.Errorf("client request during the partition: %+v", resp)
+	}
+
+	time.Sleep(100 * time.Millisecond) // for a broadcast that shouldn't happen
+	peer.mu.Lock()
+	peerUsers := userIDs(peer.users)
+	peer.mu.Unlock()
+	mu.RLock()
+	localUsers := userIDs(users)
+	mu.RUnlock()
+	if peerUsers != "bob" || localUsers != "alice" {
+		t.Fatalf("during the partition: peer has %q, this tracker %q", peerUsers, localUsers)
+	}
+
+	// Clearing pulls the peer's state; the peer pulls ours
+	resp := sendCmd(t, addr, "clear_partition", peer.addr)
+	if msg, _ := resp.Data.(string); resp.Status != "ok" || !strings.Contains(msg, "state merged") {
+		t.Fatalf("clear_partition: %+v", resp)
+	}
+	resp, err := sendSync(addr, Message{Cmd: "sync_pull"})
+	if err != nil || resp.Status != "ok" {
+		t.Fatalf("sync_pull: %+v, %v", resp, err)
+	}
+	raw, _ := json.Marshal(resp.Data)
+	var snap SyncSnapshot
+	json.Unmarshal(raw, &snap)
+	peer.mu.Lock()
+	for id, u := range snap.Users {
+		if _, ok := peer.users[id]; !ok {
+			peer.users[id] = u
+		}
+	}
+	peerUsers = userIDs(peer.users)
+	peer.mu.Unlock()
+	mu.RLock()
+	localUsers = userIDs(users)
+	mu.RUnlock()
+	if peerUsers != "alice bob" || localUsers != "alice bob" {
+		t.Errorf("after clearing: peer has %q, this tracker %q", peerUsers, localUsers)
+	}
+}
+
+func TestPartition_Commands(t *testing.T) {
+	t.Cleanup(func() { PartitionedPeers.Clear() })
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 5000}
+
+	if resp := injectPartition([]string{"10.0.0.9:7000", "60"}, remote); resp.Status != "error" {
+		t.Errorf("inject_partition from another host: %+v", resp)
+	}
+	for _, args := range [][]string{{"10.0.0.9:7000", "0"}, {"10.0.0.9:7000", "soon"}, {"no-port", "60"}} {
+		if resp := injectPartition(args, local); resp.Status != "error" {
+			t.Errorf("inject_partition %v: %+v", args, resp)
+		}
+	}
+
+	if resp := injectPartition([]string{"tls://10.0.0.9:7000", "60"}, local); resp.Status != "ok" {
+		t.Fatalf("inject_partition: %+v", resp)
+	}
+	if !partitioned("10.0.0.9:7000") || !partitionedHost(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 41234}) {
+		t.Error("peer not partitioned")
+	}
+	if partitioned("10.0.0.8:7000") || partitionedHost(remote) {
+		t.Error("another peer partitioned")
+	}
+	if _, err := sendToPeer("10.0.0.9:7000", Message{Cmd: "sync_pull"}); err != errPartitioned {
+		t.Errorf("sendToPeer to a partitioned peer: %v", err)
+	}
+
+	// A partition that has run out is forgotten
+	PartitionedPeers.Store("10.0.0.9:7000", time.Now().Add(-time.Second))
+	if partitioned("10.0.0.9:7000") {
+		t.Error("expired partition still applies")
+	}
+	if _, ok := PartitionedPeers.Load("10.0.0.9:7000"); ok {
+		t.Error("expired partition kept")
+	}
+	if resp := clearPartition([]string{"10.0.0.9:7000"}, local); resp.Status != "error" {
+		t.Errorf("clear_partition with no partition: %+v", resp)
+	}
+}
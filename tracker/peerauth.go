@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"p2p/common"
+	"sync/atomic"
+	"time"
+)
+
+// With TRACKER_SYNC_SECRET set, trackers prove to each other that they
+// know the secret before sending sync commands. The connecting tracker
+// opens with auth [trackerID]; the receiver answers with a random nonce,
+// which the connecting tracker returns as auth [trackerID, HMAC-SHA256 of
+// the nonce keyed with the secret]. Sync commands are then answered on
+// that connection until it closes. Anywhere else they are refused.
+
+const (
+	syncSecretEnv = "TRACKER_SYNC_SECRET"
+
+	peerAuthCmd     = "auth"
+	peerAuthNonce   = 32 // bytes
+	peerAuthStatus  = "challenge"
+	peerAuthTimeout = 2 * time.Second
+)
+
+// PeerAuth is this tracker's identity and the secret trackers share.
+type PeerAuth struct {
+	TrackerID string
+	secret    []byte
+}
+
+// trackerPeerAuth holds nil when TRACKER_SYNC_SECRET isn't set: sync
+// commands are then accepted from anyone, as before it existed.
+var trackerPeerAuth atomic.Pointer[PeerAuth]
+
+// peerAuthFromEnv returns the PeerAuth for trackerID configured by
+// TRACKER_SYNC_SECRET, nil if it is empty.
+func peerAuthFromEnv(trackerID string) *PeerAuth {
+	secret := os.Getenv(syncSecretEnv)
+	if secret == "" {
+		return nil
+	}
+	return &PeerAuth{TrackerID: trackerID, secret: []byte(secret)}
+}
+
+// mac returns the hex HMAC-SHA256 of nonce keyed with the shared secret.
+func (a *PeerAuth) mac(nonce []byte) string {
+	h := hmac.New(sha256.New, a.secret)
+	h.Write(nonce)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// syncUnauthorized reports whether msg is a tracker-to-tracker command
+// that must be refused because its connection hasn't authenticated.
+func syncUnauthorized(msg Message, authenticated bool) bool {
+	c, ok := trackerCommands[msg.Cmd]
+	return ok && c.sync && !authenticated && trackerPeerAuth.Load() != nil
+}
+
+// serveAuth answers the auth message that opened conn and, if the peer
+// proves it knows the secret, serves its requests on conn.
+func serveAuth(conn net.Conn, msg Message) {
+	t := TCPTransport{conn: conn}
+	auth := trackerPeerAuth.Load()
+	if auth == nil {
+		// Nothing to prove; a tracker with a secret can still sync here
+		if t.Send(Response{"ok", "authentication not required"}) == nil {
+			serveAuthenticated(conn)
+		}
+		return
+	}
+
+	nonce := make([]byte, peerAuthNonce)
+	if _, err := rand.Read(nonce); err != nil {
+		t.Send(Response{"error", "internal error"})
+		return
+	}
+	if t.Send(Response{peerAuthStatus, hex.EncodeToString(nonce)}) != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(peerAuthTimeout))
+	var proof Message
+	if t.Recv(&proof) != nil {
+		return
+	}
+	if proof.Cmd != peerAuthCmd || len(proof.Args) < 2 || !hmac.Equal([]byte(proof.Args[1]), []byte(auth.mac(nonce))) {
+		peer := "?"
+		if len(msg.Args) > 0 {
+			peer = msg.Args[0]
+		}
+		fmt.Printf("[auth] tracker %q at %s failed authentication\n", peer, conn.RemoteAddr())
+		t.Send(Response{"error", "unauthorized"})
+		return
+	}
+	if t.Send(Response{"ok", "authenticated"}) == nil {
+		serveAuthenticated(conn)
+	}
+}
+
+// serveAuthenticated answers requests from an authenticated peer tracker
+// on conn, one after another, until it closes, sits idle for
+// muxIdleTimeout or the tracker shuts down. Like WebSocket requests they
+// go to this tracker's own state.
+func serveAuthenticated(conn net.Conn) {
+	t := TCPTransport{conn: conn}
+	defer idleUntilDrained(conn)()
+	for {
+		if !awaitRequest(conn) {
+			return
+		}
+		var msg Message
+		if t.Recv(&msg) != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+		if c, ok := trackerCommands[msg.Cmd]; ok && c.sync && partitionedHost(conn.RemoteAddr()) {
+			return
+		}
+		msg.Tenant = tenantName
+		msg.Stream, msg.Mux = false, false
+		if t.Send(answer(msg, conn.RemoteAddr())) != nil {
+			return
+		}
+	}
+}
+
+// authenticatePeer proves to the tracker on conn that this one knows the
+// shared secret, so it will accept sync commands on conn. It does nothing
+// without TRACKER_SYNC_SECRET.
+func authenticatePeer(conn net.Conn) error {
+	auth := trackerPeerAuth.Load()
+	if auth == nil {
+		return nil
+	}
+	if err := common.Send(conn, Message{Cmd: peerAuthCmd, Args: []string{auth.TrackerID}}); err != nil {
+		return err
+	}
+	var challenge Response
+	if err := common.Recv(conn, &challenge); err != nil {
+		return err
+	}
+	switch challenge.Status {
+	case "ok":
+		return nil // the peer has no secret set
+	case peerAuthStatus:
+	default:
+		return fmt.Errorf("auth: %v", challenge.Data)
+	}
+	s, _ := challenge.Data.(string)
+	nonce, err := hex.DecodeString(s)
+	if err != nil || len(nonce) != peerAuthNonce {
+		return errors.New("auth: invalid challenge")
+	}
+	if err := common.Send(conn, Message{Cmd: peerAuthCmd, Args: []string{auth.TrackerID, auth.mac(nonce)}}); err != nil {
+		return err
+	}
+	var resp Response
+	if err := common.Recv(conn, &resp); err != nil {
+		return err
+	}
+	if resp.Status != "ok" {
+		return fmt.Errorf("auth: %v", resp.Data)
+	}
+	return nil
+}
+
+// dialPeerTracker dials another tracker of the cluster and authenticates
+// to it, ready for sync commands.
+func dialPeerTracker(entry string, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialTracker(entry, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(peerAuthTimeout))
+	if err := authenticatePeer(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
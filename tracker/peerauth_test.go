@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/hex"
+	"net"
+	"p2p/common"
+	"testing"
+	"time"
+)
+
+// useTestPeerAuth makes this tracker, and the trackers it syncs with,
+// share secret for the test.
+func useTestPeerAuth(t *testing.T, secret string) *PeerAuth {
+	t.Helper()
+	auth := &PeerAuth{TrackerID: "tracker-test", secret: []byte(secret)}
+	trackerPeerAuth.Store(auth)
+	t.Cleanup(func() { trackerPeerAuth.Store(nil) })
+	return auth
+}
+
+// exchange sends msg on conn and returns the reply.
+func exchange(t *testing.T, conn net.Conn, msg Message) Response {
+	t.Helper()
+	if err := common.Send(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	if err := common.Recv(conn, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func userExists(id string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := users[id]
+	return ok
+}
+
+// TestPeerAuth_ChallengeResponse walks through the handshake by hand,
+// then sends two sync commands on the authenticated connection.
+func TestPeerAuth_ChallengeResponse(t *testing.T) {
+	useTestAuditLog(t, "")
+	seedUsers()
+	auth := useTestPeerAuth(t, "s3cret")
+	conn, err := net.Dial("tcp", startTestTracker(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	challenge := exchange(t, conn, Message{Cmd: "auth", Args: []string{"tracker-2"}})
+	s, _ := challenge.Data.(string)
+	nonce, err := hex.DecodeString(s)
+	if challenge.Status != "challenge" || err != nil || len(nonce) != peerAuthNonce {
+		t.Fatalf("challenge = %+v", challenge)
+	}
+	if resp := exchange(t, conn, Message{Cmd: "auth", Args: []string{"tracker-2", auth.mac(nonce)}}); resp.Status != "ok" {
+		t.Fatalf("proof refused: %+v", resp)
+	}
+	for _, id := range []string{"alice", "bob"} {
+		if resp := exchange(t, conn, Message{Cmd: "sync_create_user", Args: []string{id, "pw"}}); resp.Status != "ok" {
+			t.Fatalf("sync_create_user %s: %+v", id, resp)
+		}
+		if !userExists(id) {
+			t.Errorf("%s not created", id)
+		}
+	}
+}
+
+// TestPeerAuth_SendToPeer checks sendToPeer and pullStateFrom
+// authenticate on their own.
+func TestPeerAuth_SendToPeer(t *testing.T) {
+	useTestAuditLog(t, "")
+	seedUsers()
+	useTestPeerAuth(t, "s3cret")
+	addr := startTestTracker(t)
+
+	resp, err := sendToPeer(addr, Message{Cmd: "sync_create_user", Args: []string{"alice", "pw"}})
+	if err != nil || resp.Status != "ok" || !userExists("alice") {
+		t.Fatalf("sendToPeer: %+v, %v", resp, err)
+	}
+	if err := pullStateFrom(addr); err != nil {
+		t.Errorf("pullStateFrom: %v", err)
+	}
+}
+
+// TestPeerAuth_Refused checks sync commands are refused without the
+// handshake or with a proof made with another secret, while client
+// commands are answered as usual.
+func TestPeerAuth_Refused(t *testing.T) {
+	useTestAuditLog(t, "")
+	seedUsers()
+	useTestPeerAuth(t, "s3cret")
+	addr := startTestTracker(t)
+
+	if resp := sendCmd(t, addr, "sync_create_user", "mallory", "pw"); resp.Status != "error" || resp.Data != "unauthorized" {
+		t.Errorf("unauthenticated sync_create_user: %+v", resp)
+	}
+	if resp := sendCmd(t, addr, "sync_pull"); resp.Status != "error" {
+		t.Errorf("unauthenticated sync_pull: %+v", resp)
+	}
+	if resp := sendCmd(t, addr, "create_user", "carol", "pw"); resp.Status != "ok" {
+		t.Errorf("create_user: %+v", resp)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	challenge := exchange(t, conn, Message{Cmd: "auth", Args: []string{"mallory"}})
+	s, _ := challenge.Data.(string)
+	nonce, _ := hex.DecodeString(s)
+	forged := (&PeerAuth{secret: []byte("guess")}).mac(nonce)
+	if resp := exchange(t, conn, Message{Cmd: "auth", Args: []string{"mallory", forged}}); resp.Status != "error" {
+		t.Fatalf("forged proof accepted: %+v", resp)
+	}
+	// The connection is closed after a failed proof
+	if err := common.Send(conn, Message{Cmd: "sync_create_user", Args: []string{"mallory", "pw"}}); err == nil {
+		var resp Response
+		if common.Recv(conn, &resp) == nil {
+			t.Errorf("sync after a failed proof answered: %+v", resp)
+		}
+	}
+	if userExists("mallory") {
+		t.Error("mallory was created")
+	}
+}
+
+// TestPeerAuth_NotConfigured checks a tracker without a secret answers
+// auth without a challenge and keeps taking sync commands from anyone.
+func TestPeerAuth_NotConfigured(t *testing.T) {
+	useTestAuditLog(t, "")
+	seedUsers()
+	addr := startTestTracker(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if resp := exchange(t, conn, Message{Cmd: "auth", Args: []string{"tracker-2"}}); resp.Status != "ok" {
+		t.Fatalf("auth = %+v", resp)
+	}
+	if resp := exchange(t, conn, Message{Cmd: "sync_create_user", Args: []string{"alice", "pw"}}); resp.Status != "ok" {
+		t.Errorf("sync_create_user after auth: %+v", resp)
+	}
+	if resp := sendCmd(t, addr, "sync_create_user", "bob", "pw"); resp.Status != "ok" {
+		t.Errorf("sync_create_user without auth: %+v", resp)
+	}
+}
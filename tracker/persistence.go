@@ -4,26 +4,52 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 )
 
 const stateFile = "tracker_state.json"
 
 // TrackerState represents all persistent state
 type TrackerState struct {
-	Users  map[string]*User  `json:"users"`
-	Groups map[string]*Group `json:"groups"`
-	Files  map[string]*File  `json:"files"`
+	Users      map[string]*User      `json:"users"`
+	Groups     map[string]*Group     `json:"groups"`
+	Files      map[string]*File      `json:"files"`
+	Tombstones map[string]*Tombstone `json:"tombstones,omitempty"`
+
+	// EventSeq is the last command in commands.log the state includes
+	EventSeq uint64 `json:"event_seq,omitempty"`
 }
 
-// SaveState writes current state to disk
+// SaveState writes current state to disk. With --event-sourcing the
+// snapshot includes every command logged so far.
 func SaveState() error {
+	if l := trackerEventLog.Load(); l != nil {
+		return l.Snapshot()
+	}
+	return saveState(0)
+}
+
+// saveState writes current state to disk, recording eventSeq as the last
+// logged command it includes.
+func saveState(eventSeq uint64) error {
 	mu.Lock()
 	defer mu.Unlock()
 	
+	// Bound the file size: only the newest download events are kept
+	for _, f := range files {
+		if n := len(f.DownloadLog); n > maxDownloadLogLen {
+			f.DownloadLog = append([]DownloadEvent(nil), f.DownloadLog[n-maxDownloadLogLen:]...)
+		}
+	}
+	pruneTombstones(time.Now())
+	pruneReservations(time.Now())
+	
 	state := TrackerState{
-		Users:  users,
-		Groups: groups,
-		Files:  files,
+		Users:      withoutCanary(users),
+		Groups:     withoutCanary(groups),
+		Files:      withoutCanary(files),
+		Tombstones: tombstones,
+		EventSeq:   eventSeq,
 	}
 	
 	data, err := json.MarshalIndent(state, "", "  ")
@@ -31,26 +57,35 @@ func SaveState() error {
 		return err
 	}
 	
-	return os.WriteFile(stateFile, data, 0644)
+	return writeStateFile(stateFile, data, 0644)
 }
 
-// LoadState reads state from disk if it exists
+// LoadState reads state from disk if it exists, compressed or not. With
+// --event-sourcing the commands logged since are then replayed.
 func LoadState() error {
-	data, err := os.ReadFile(stateFile)
+	var state TrackerState
+	data, err := readStateFile(stateFile)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// No saved state, start fresh
-			fmt.Println("No saved state found, starting fresh")
-			return nil
+		if !os.IsNotExist(err) {
+			return err
 		}
+		// No saved state, start fresh
+		fmt.Println("No saved state found, starting fresh")
+	} else if err := json.Unmarshal(data, &state); err != nil {
 		return err
+	} else {
+		loadSnapshot(state)
 	}
 	
-	var state TrackerState
-	if err := json.Unmarshal(data, &state); err != nil {
-		return err
+	if l := trackerEventLog.Load(); l != nil {
+		n := l.restore(state.EventSeq)
+		fmt.Printf("Replayed %d commands from %s\n", n, l.path)
 	}
-	
+	return nil
+}
+
+// loadSnapshot replaces the state with what state holds.
+func loadSnapshot(state TrackerState) {
 	mu.Lock()
 	defer mu.Unlock()
 	
@@ -63,9 +98,10 @@ func LoadState() error {
 		fmt.Printf("Loaded %d groups from disk\n", len(groups))
 	}
 	if state.Files != nil {
-		files = state.Files
+		replaceFiles(state.Files)
 		fmt.Printf("Loaded %d files from disk\n", len(files))
 	}
-	
-	return nil
+	if state.Tombstones != nil {
+		tombstones = state.Tombstones
+	}
 }
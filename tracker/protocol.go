@@ -3,6 +3,26 @@ package main
 type Message struct{
 	Cmd 	  string  `json:"cmd"`
 	Args	[]string  `json:"args"`
+
+	// Set on user/group sync messages: the writer's version and content hash after the write
+	Version uint64 `json:"version,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+
+	// Stream asks for one response frame per item, ended by a "done" frame
+	Stream bool `json:"stream,omitempty"`
+
+	// Mux keeps the connection open after this request's response, after
+	// which requests and responses are multiplexed frames (common.Mux)
+	Mux bool `json:"mux,omitempty"`
+
+	// Tenant is the TLS server name the request arrived on, set by the
+	// tracker (never trusted from clients) to route it to a tenant's state
+	Tenant string `json:"tenant,omitempty"`
+
+	// ClientAddr is the address of the client a tenant's request came
+	// from, set by the tracker that proxies it. The tenant's tracker only
+	// sees the proxy's loopback connection.
+	ClientAddr string `json:"client_addr,omitempty"`
 }
 
 type Response struct{
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Per-user request limits. Each tracker keeps a token bucket per user and
+// tells the other trackers how many tokens users spent on it, which they
+// take from their own buckets, so spreading requests over the cluster
+// doesn't get round the limit. Spending is gossiped every
+// rateGossipInterval, so with N trackers a user may get up to N*burst
+// requests through before every bucket has caught up.
+
+// rateGossipInterval is how often spent tokens are sent to peer trackers.
+const rateGossipInterval = 200 * time.Millisecond
+
+// errRateLimited is the reply to a request over its user's limit.
+const errRateLimited = "rate limit exceeded; try again shortly"
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // when tokens was last refilled
+}
+
+// DistributedRateLimiter limits each user to rate requests per second,
+// with bursts of up to burst, across the tracker cluster. A zero rate
+// lets everything through.
+type DistributedRateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	pending map[string]float64 // tokens spent here since the last Gossip
+	now     func() time.Time
+
+	peers func() []string
+	send  func(peer string, msg Message) (Response, error)
+}
+
+// trackerRateLimiter is replaced at startup when TRACKER_RATE_LIMIT is set.
+var trackerRateLimiter = NewDistributedRateLimiter(0, 0)
+
+// NewDistributedRateLimiter returns a limiter gossiping to the sync peers.
+func NewDistributedRateLimiter(rate, burst float64) *DistributedRateLimiter {
+	return &DistributedRateLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+		pending: make(map[string]float64),
+		now:     time.Now,
+		peers:   syncPeers,
+		send:    sendToPeer,
+	}
+}
+
+// rateLimitFromEnv reads TRACKER_RATE_LIMIT, requests per second per user,
+// and TRACKER_RATE_BURST, which defaults to one second's worth and at
+// least 1. The rate is 0 if unset.
+func rateLimitFromEnv() (rate, burst float64, err error) {
+	parse := func(name string) (float64, error) {
+		s := os.Getenv(name)
+		if s == "" {
+			return 0, nil
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || !(v >= 0) || math.IsInf(v, 0) {
+			return 0, fmt.Errorf("invalid %s %q", name, s)
+		}
+		return v, nil
+	}
+	if rate, err = parse("TRACKER_RATE_LIMIT"); err != nil || rate == 0 {
+		return 0, 0, err
+	}
+	if burst, err = parse("TRACKER_RATE_BURST"); err != nil {
+		return 0, 0, err
+	}
+	if burst == 0 {
+		burst = max(rate, 1)
+	}
+	return rate, burst, nil
+}
+
+// bucketLocked returns userID's bucket, refilled up to now. Caller must
+// hold l.mu.
+func (l *DistributedRateLimiter) bucketLocked(userID string) *tokenBucket {
+	now := l.now()
+	b, ok := l.buckets[userID]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[userID] = b
+		return b
+	}
+	if now.After(b.last) {
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	}
+	return b
+}
+
+// Allow takes a token from userID's bucket and reports whether there was
+// one. Requests not made as a user are always allowed.
+func (l *DistributedRateLimiter) Allow(userID string) bool {
+	if l.rate <= 0 || userID == "" {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucketLocked(userID)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	l.pending[userID]++
+	return true
+}
+
+// Consume takes tokens userID spent on another tracker from its bucket,
+// leaving it empty at worst.
+func (l *DistributedRateLimiter) Consume(userID string, tokens float64) {
+	if l.rate <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucketLocked(userID)
+	b.tokens = max(0, b.tokens-tokens)
+}
+
+// Gossip sends the tokens spent here since the last call to every peer
+// tracker as one consume_token [userID, tokens, userID, tokens...]. Lost
+// messages aren't resent; the buckets refill before long anyway. Buckets
+// that have refilled are dropped, as a new one starts full.
+func (l *DistributedRateLimiter) Gossip() {
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = make(map[string]float64)
+	for userID := range l.buckets {
+		if _, spent := pending[userID]; !spent && l.bucketLocked(userID).tokens >= l.burst {
+			delete(l.buckets, userID)
+		}
+	}
+	l.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	args := make([]string, 0, 2*len(pending))
+	for userID, tokens := range pending {
+		args = append(args, userID, strconv.FormatFloat(tokens, 'f', -1, 64))
+	}
+	msg := Message{Cmd: "consume_token", Args: args}
+	var wg sync.WaitGroup
+	for _, peer := range l.peers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.send(peer, msg)
+		}()
+	}
+	wg.Wait()
+}
+
+// Run gossips spent tokens every interval, forever.
+func (l *DistributedRateLimiter) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		l.Gossip()
+	}
+}
+
+// handleConsumeToken applies a peer tracker's consume_token.
+func (l *DistributedRateLimiter) handleConsumeToken(args []string) Response {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Response{"error", "consume_token: need userID, tokens pairs"}
+	}
+	for i := 0; i < len(args); i += 2 {
+		tokens, err := strconv.ParseFloat(args[i+1], 64)
+		if err != nil || !(tokens >= 0) || math.IsInf(tokens, 0) { // also NaN
+			return Response{"error", fmt.Sprintf("consume_token: invalid tokens %q", args[i+1])}
+		}
+		l.Consume(args[i], tokens)
+	}
+	return Response{"ok", "consumed"}
+}
+
+// requestUser returns the user a request acts as: its "userID" argument,
+// optional or not, "" if the command has none.
+func (s CommandSpec) requestUser(args []string) string {
+	for i, a := range s.Args {
+		if strings.TrimSuffix(a, "?") == "userID" && i < len(args) {
+			return args[i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testLimiter returns a limiter on a clock the test moves by changing the
+// returned time, gossiping to nobody.
+func testLimiter(rate, burst float64) (*DistributedRateLimiter, *time.Time) {
+	now := time.Unix(1_700_000_000, 0)
+	l := NewDistributedRateLimiter(rate, burst)
+	l.now = func() time.Time { return now }
+	l.peers = func() []string { return nil }
+	return l, &now
+}
+
+// allowed counts how many of n requests by userID l lets through.
+func allowed(l *DistributedRateLimiter, userID string, n int) int {
+	got := 0
+	for i := 0; i < n; i++ {
+		if l.Allow(userID) {
+			got++
+		}
+	}
+	return got
+}
+
+func TestRateLimiter_Bucket(t *testing.T) {
+	l, now := testLimiter(2, 3)
+	if got := allowed(l, "alice", 5); got != 3 {
+		t.Errorf("burst let %d requests through, want 3", got)
+	}
+	if !l.Allow("bob") {
+		t.Error("bob limited by alice's requests")
+	}
+	*now = now.Add(time.Second)
+	if got := allowed(l, "alice", 5); got != 2 {
+		t.Errorf("%d requests after a second, want 2", got)
+	}
+	*now = now.Add(time.Hour)
+	if got := allowed(l, "alice", 5); got != 3 {
+		t.Errorf("%d requests after an idle hour, want the burst of 3", got)
+	}
+	if !NewDistributedRateLimiter(0, 0).Allow("alice") || !l.Allow("") {
+		t.Error("disabled limiter or request without a user limited")
+	}
+}
+
+// TestDistributedRateLimiter_TwoTrackers checks a user spreading requests
+// over two trackers gets about the limit of one once their spending has
+// been gossiped, not the limit of each.
+func TestDistributedRateLimiter_TwoTrackers(t *testing.T) {
+	a, nowA := testLimiter(1, 5)
+	b, nowB := testLimiter(1, 5)
+	link := func(from, to *DistributedRateLimiter) {
+		from.peers = func() []string { return []string{"peer"} }
+		from.send = func(_ string, msg Message) (Response, error) {
+			if msg.Cmd != "consume_token" {
+				t.Errorf("gossiped %s", msg.Cmd)
+			}
+			return to.handleConsumeToken(msg.Args), nil
+		}
+	}
+	link(a, b)
+	link(b, a)
+
+	if got := allowed(a, "alice", 4); got != 4 {
+		t.Fatalf("a allowed %d of 4", got)
+	}
+	a.Allow("bob")
+	a.Gossip()
+	if got := allowed(b, "alice", 5); got != 1 {
+		t.Errorf("b allowed %d after alice spent 4 of 5 on a, want 1", got)
+	}
+	if got := allowed(b, "bob", 5); got != 4 {
+		t.Errorf("b allowed bob %d, want 4", got)
+	}
+	b.Gossip()
+	if a.Allow("alice") {
+		t.Error("a allowed alice after her last token went on b")
+	}
+
+	// Both refill at the shared rate
+	*nowA = nowA.Add(2 * time.Second)
+	*nowB = nowB.Add(2 * time.Second)
+	if got := allowed(a, "alice", 5); got != 2 {
+		t.Errorf("a allowed %d after 2s, want 2", got)
+	}
+	a.Gossip()
+	if b.Allow("alice") {
+		t.Error("b allowed alice after her refill went on a")
+	}
+}
+
+// TestRateLimiter_Gossip checks spending is sent once, refilled buckets
+// are dropped, and bad consume_token messages are refused.
+func TestRateLimiter_Gossip(t *testing.T) {
+	l, now := testLimiter(1, 2)
+	var sent []Message
+	l.peers = func() []string { return []string{"p1"} }
+	l.send = func(_ string, msg Message) (Response, error) {
+		sent = append(sent, msg)
+		return Response{"ok", nil}, nil
+	}
+	l.Allow("alice")
+	l.Allow("alice")
+	l.Gossip()
+	l.Gossip()
+	if len(sent) != 1 || len(sent[0].Args) != 2 || sent[0].Args[0] != "alice" || sent[0].Args[1] != "2" {
+		t.Errorf("sent %+v, want one consume_token [alice 2]", sent)
+	}
+	*now = now.Add(time.Minute)
+	l.Gossip()
+	if len(l.buckets) != 0 {
+		t.Errorf("%d refilled buckets kept", len(l.buckets))
+	}
+
+	for _, args := range [][]string{nil, {"alice"}, {"alice", "x"}, {"alice", "-1"}, {"alice", "NaN"}, {"alice", "1", "bob"}} {
+		if resp := l.handleConsumeToken(args); resp.Status != "error" {
+			t.Errorf("consume_token %v: %+v", args, resp)
+		}
+	}
+}
+
+// TestRunCommand_RateLimited checks client requests over their user's
+// limit are refused, and requests without a user and sync commands aren't.
+func TestRunCommand_RateLimited(t *testing.T) {
+	resetGroupState(t, "alice")
+	l, _ := testLimiter(1, 1)
+	saved := trackerRateLimiter
+	trackerRateLimiter = l
+	t.Cleanup(func() { trackerRateLimiter = saved })
+
+	req := Message{Cmd: "get_file_info", Args: []string{"g1", "a.txt", "alice"}}
+	if resp := runCommand(req, nil); resp.Data == errRateLimited {
+		t.Fatal("first request limited")
+	}
+	if resp := runCommand(req, nil); resp.Data != errRateLimited {
+		t.Errorf("second request: %+v", resp)
+	}
+	if resp := runCommand(Message{Cmd: "get_file_info", Args: []string{"g1", "a.txt"}}, nil); resp.Data == errRateLimited {
+		t.Error("request without a user limited")
+	}
+	if resp := runCommand(Message{Cmd: "consume_token", Args: []string{"alice", "1"}}, nil); resp.Status != "ok" {
+		t.Errorf("consume_token: %+v", resp)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// updateFileChunks replaces the chunk layout of the files with fileHash
+// that userID uploaded to the groups named, after the client re-chunked
+// its copy at chunkSize. The data and file hash are the same, but the
+// other seeders still hold the old chunks, so userID is left the only
+// owner until they fetch the new ones. Only the uploader may: downloaders
+// check chunks against this layout, so it decides what they get.
+// args: [fileHash, userID, chunkSize, chunksJSON, groupID...]
+func updateFileChunks(args []string) Response {
+	if len(args) < 5 {
+		return Response{"error", "update_file_chunks: need fileHash, userID, chunkSize, chunksJSON, groupID..."}
+	}
+	fileHash, userID, groupIDs := args[0], args[1], args[4:]
+	chunkSize, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || chunkSize <= 0 {
+		return Response{"error", "invalid chunk size"}
+	}
+	var chunks []Chunk
+	if err := json.Unmarshal([]byte(args[3]), &chunks); err != nil || len(chunks) == 0 {
+		return Response{"error", "invalid chunk data"}
+	}
+	var size int64
+	for i, c := range chunks {
+		if c.Index != i || c.Size <= 0 || c.Size > chunkSize || (i < len(chunks)-1 && c.Size != chunkSize) {
+			return Response{"error", fmt.Sprintf("invalid chunk %d", i)}
+		}
+		size += c.Size
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var keys []string
+	for _, groupID := range groupIDs {
+		found := false
+		for key, f := range groupFiles(groupID) {
+			if f.FileHash == fileHash && f.Uploader == userID && f.Owners[userID] && !f.isReserved() {
+				keys = append(keys, groupID+":"+key)
+				found = true
+			}
+		}
+		if !found {
+			return Response{"error", fmt.Sprintf("no file you uploaded with that hash in group %s", groupID)}
+		}
+	}
+	sort.Strings(keys)
+	keys = slices.Compact(keys)
+	for _, key := range keys {
+		if files[key].FileSize != size {
+			return Response{"error", fmt.Sprintf("chunks add up to %d bytes, file is %d", size, files[key].FileSize)}
+		}
+	}
+
+	now := time.Now().UTC()
+	dropped := make(map[string]bool)
+	for _, key := range keys {
+		f := files[key]
+		before := cloneFile(f)
+		for owner := range f.Owners {
+			if owner != userID {
+				dropped[owner] = true
+			}
+		}
+		f.ChunkSize = chunkSize
+		f.TotalChunks = len(chunks)
+		f.Chunks = append([]Chunk(nil), chunks...)
+		indexChunks(f)
+		f.Owners = map[string]bool{userID: true}
+		f.Version++
+		f.UpdatedAt = now
+		fileInfoCache.Invalidate(key)
+		go broadcastFilePatch(key, before, cloneFile(f))
+	}
+
+	fmt.Printf("[rechunk] %s re-chunked %s into %d chunks of %d bytes (%d files)\n", userID, fileHash, len(chunks), chunkSize, len(keys))
+	go SaveState()
+	return Response{"ok", map[string]interface{}{
+		"updated":         keys,
+		"total_chunks":    len(chunks),
+		"seeders_dropped": sortedKeys(dropped),
+	}}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// layoutJSON lists chunks of chunkSize covering size bytes.
+func layoutJSON(t *testing.T, size, chunkSize int64) string {
+	t.Helper()
+	var chunks []Chunk
+	for i := 0; size > 0; i++ {
+		n := min(size, chunkSize)
+		chunks = append(chunks, Chunk{Index: i, Hash: "new-hash", Size: n})
+		size -= n
+	}
+	data, err := json.Marshal(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestUpdateFileChunks re-chunks a file shared in two groups and checks
+// both entries take the layout and the other seeders are dropped.
+func TestUpdateFileChunks(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedUsers("alice", "bob")
+	seedFiles(t, "a.txt")
+	mu.Lock()
+	groups["g2"] = &Group{GroupID: "g2", Owner: "alice", Members: map[string]bool{"alice": true}, Pending: map[string]bool{}}
+	files["g1:a.txt"].Owners["bob"] = true
+	mu.Unlock()
+	if resp := shareFile([]string{"g1", "a.txt", "g2", "alice"}); resp.Status != "ok" {
+		t.Fatalf("share_file: %+v", resp)
+	}
+
+	resp := updateFileChunks([]string{"hash-a.txt", "alice", "4", layoutJSON(t, 10, 4), "g1", "g2"})
+	if resp.Status != "ok" {
+		t.Fatalf("update_file_chunks: %+v", resp)
+	}
+	data := resp.Data.(map[string]interface{})
+	if !reflect.DeepEqual(data["updated"], []string{"g1:a.txt", "g2:a.txt"}) || !reflect.DeepEqual(data["seeders_dropped"], []string{"bob"}) {
+		t.Errorf("reply %+v", data)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, key := range []string{"g1:a.txt", "g2:a.txt"} {
+		f := files[key]
+		if f.ChunkSize != 4 || f.TotalChunks != 3 || len(f.Chunks) != 3 || !reflect.DeepEqual(f.Owners, map[string]bool{"alice": true}) {
+			t.Errorf("%s: chunk size %d, %d chunks, owners %v", key, f.ChunkSize, f.TotalChunks, f.Owners)
+		}
+	}
+}
+
+func TestUpdateFileChunks_Refused(t *testing.T) {
+	resetGroupState(t, "alice", "bob", "carol")
+	seedUsers("alice", "bob", "carol")
+	seedFiles(t, "a.txt")
+	mu.Lock()
+	groups["g2"] = &Group{GroupID: "g2", Owner: "alice", Members: map[string]bool{"alice": true}, Pending: map[string]bool{}}
+	files["g1:a.txt"].Owners["carol"] = true
+	mu.Unlock()
+
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"not a seeder", []string{"hash-a.txt", "bob", "4", layoutJSON(t, 10, 4), "g1"}},
+		{"seeder, not the uploader", []string{"hash-a.txt", "carol", "4", layoutJSON(t, 10, 4), "g1"}},
+		{"no group", []string{"hash-a.txt", "alice", "4", layoutJSON(t, 10, 4)}},
+		{"group without the file", []string{"hash-a.txt", "alice", "4", layoutJSON(t, 10, 4), "g1", "g2"}},
+		{"unknown hash", []string{"hash-b.txt", "alice", "4", layoutJSON(t, 10, 4), "g1"}},
+		{"wrong size", []string{"hash-a.txt", "alice", "4", layoutJSON(t, 12, 4), "g1"}},
+		{"uneven chunks", []string{"hash-a.txt", "alice", "4", `[{"index":0,"size":2},{"index":1,"size":4},{"index":2,"size":4}]`, "g1"}},
+		{"out of order", []string{"hash-a.txt", "alice", "5", `[{"index":1,"size":5},{"index":0,"size":5}]`, "g1"}},
+		{"bad chunk size", []string{"hash-a.txt", "alice", "0", layoutJSON(t, 10, 4), "g1"}},
+		{"no chunks", []string{"hash-a.txt", "alice", "4", `[]`, "g1"}},
+	} {
+		if resp := updateFileChunks(tc.args); resp.Status != "error" {
+			t.Errorf("%s: %+v", tc.name, resp)
+		}
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if f := files["g1:a.txt"]; f.TotalChunks != 0 || f.Version != 1 {
+		t.Errorf("file changed: %+v", f)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"p2p/common"
+	"sync"
+	"time"
+)
+
+// Replication statuses kept per member in File.ReplicationStatus.
+const (
+	ReplicationPending = "pending" // asked to download the file
+	ReplicationSeeding = "seeding" // registered as a seeder since
+	ReplicationFailed  = "failed"  // couldn't be asked, or its download failed
+)
+
+// replicateTimeout bounds asking one member's peer server to replicate.
+// A variable so tests can shorten it.
+var replicateTimeout = 5 * time.Second
+
+// replicateRequest is the replicate_file message sent to a peer server, in
+// the peers' request format.
+type replicateRequest struct {
+	Cmd      string `json:"cmd"`
+	FileHash string `json:"file_hash"`
+	GroupID  string `json:"group_id"`
+	FileName string `json:"file_name"`
+	// Token, with P2P_PEER_SECRET set, shows the peer the request is ours
+	Token string `json:"token,omitempty"`
+}
+
+// replicateToAll asks every online member of a group that doesn't seed a
+// file yet to download and seed it, and records each one's progress in
+// the file's ReplicationStatus: pending until the member registers with
+// add_seeder, or failed if its peer server can't be reached or reports
+// that the download failed. Only the group owner may ask.
+// args: [groupID, fileName, adminID]
+func replicateToAll(args []string) Response {
+	groupID, fileName, admin := args[0], args[1], args[2]
+	fileKey := groupID + ":" + fileName
+
+	mu.Lock()
+	g, ok := groups[groupID]
+	if !ok {
+		mu.Unlock()
+		return Response{"error", "group not found"}
+	}
+	if g.Owner != admin {
+		mu.Unlock()
+		return Response{"error", "not owner"}
+	}
+	f, ok := files[fileKey]
+	if !ok || f.isReserved() {
+		mu.Unlock()
+		return Response{"error", "file not found"}
+	}
+	statuses := make(map[string]string)
+	targets := make(map[string]string) // userID -> peer address
+	for m := range g.Members {
+		u, ok := users[m]
+		switch {
+		case f.Owners[m]:
+			statuses[m] = ReplicationSeeding
+		case ok && u.LoggedIn && u.Addr != "":
+			statuses[m] = ReplicationPending
+			targets[m] = u.Addr
+		}
+	}
+	if len(statuses) > 0 {
+		setReplicationStatus(fileKey, f, statuses)
+	}
+	req := replicateRequest{Cmd: "replicate_file", FileHash: f.FileHash, GroupID: groupID, FileName: fileName}
+	mu.Unlock()
+
+	// Ask every member at once, without holding mu
+	var wg sync.WaitGroup
+	var failedMu sync.Mutex
+	failed := make(map[string]string)
+	for userID, addr := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := req
+			if secret := common.PeerSecret(); secret != nil {
+				req.Token = common.SignPeerToken(secret, userID, common.ReplicateTokenSubject(req.FileHash), time.Now().Add(common.PeerTokenTTL))
+			}
+			if err := sendReplicate(addr, req); err != nil {
+				fmt.Printf("[replicate] %s at %s: %v\n", userID, addr, err)
+				failedMu.Lock()
+				failed[userID] = ReplicationFailed
+				failedMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if f, ok = files[fileKey]; ok && len(failed) > 0 {
+		setReplicationStatus(fileKey, f, failed)
+	}
+	for userID, status := range failed {
+		statuses[userID] = status
+	}
+	fmt.Printf("[replicate] %s in %s: asked %d members, %d unreachable\n", fileName, groupID, len(targets), len(failed))
+	return Response{"ok", statuses}
+}
+
+// sendReplicate sends req to the peer server at addr and waits for it to
+// accept.
+func sendReplicate(addr string, req replicateRequest) error {
+	conn, err := net.DialTimeout("tcp", addr, replicateTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(replicateTimeout))
+	if err := common.Send(conn, req); err != nil {
+		return err
+	}
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := common.Recv(conn, &resp); err != nil {
+		return err
+	}
+	if resp.Status != "ok" {
+		return fmt.Errorf("peer answered %q", resp.Status)
+	}
+	return nil
+}
+
+// replicationFailed records that a member asked by replicate_to_all
+// couldn't download the file. Members not pending are left alone.
+// args: [groupID, fileName, userID]
+func replicationFailed(args []string) Response {
+	groupID, fileName, userID := args[0], args[1], args[2]
+	fileKey := groupID + ":" + fileName
+
+	mu.Lock()
+	defer mu.Unlock()
+	f, ok := files[fileKey]
+	if !ok {
+		return Response{"error", "file not found"}
+	}
+	if f.ReplicationStatus[userID] != ReplicationPending {
+		return Response{"error", "no replication pending for " + userID}
+	}
+	setReplicationStatus(fileKey, f, map[string]string{userID: ReplicationFailed})
+	return Response{"ok", "replication marked failed"}
+}
+
+// setReplicationStatus merges statuses into f's and sends the change to the
+// other trackers as a file patch. Caller must hold mu.
+func setReplicationStatus(fileKey string, f *File, statuses map[string]string) {
+	before := cloneFile(f)
+	if f.ReplicationStatus == nil {
+		f.ReplicationStatus = make(map[string]string, len(statuses))
+	}
+	for userID, status := range statuses {
+		f.ReplicationStatus[userID] = status
+	}
+	fileInfoCache.Invalidate(fileKey)
+	f.Version++
+	f.UpdatedAt = time.Now().UTC()
+	go broadcastFilePatch(fileKey, before, cloneFile(f))
+	go SaveState()
+}
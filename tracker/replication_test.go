@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net"
+	"p2p/common"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startReplicaPeer starts a peer server answering replicate_file with
+// status, and returns its address and the requests it got.
+func startReplicaPeer(t *testing.T, status string) (string, func() []replicateRequest) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	var got []replicateRequest
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req replicateRequest
+			if common.Recv(conn, &req) == nil {
+				mu.Lock()
+				got = append(got, req)
+				mu.Unlock()
+				common.Send(conn, map[string]string{"status": status})
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), func() []replicateRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]replicateRequest(nil), got...)
+	}
+}
+
+// TestReplicateToAll asks a group's two online members to seed a file,
+// with one offline member and one whose peer server refuses, and follows
+// their statuses as they seed or fail.
+func TestReplicateToAll(t *testing.T) {
+	resetGroupState(t, "alice", "bob", "carol", "dave", "erin")
+	seedUsers("alice", "bob", "carol", "dave", "erin")
+	seedFiles(t, "a.txt")
+	bobAddr, bobGot := startReplicaPeer(t, "ok")
+	carolAddr, carolGot := startReplicaPeer(t, "ok")
+	erinAddr, _ := startReplicaPeer(t, "error")
+	mu.Lock()
+	users["bob"].Addr = bobAddr
+	users["carol"].Addr = carolAddr
+	users["dave"].LoggedIn = false
+	users["erin"].Addr = erinAddr
+	mu.Unlock()
+
+	if resp := replicateToAll([]string{"g1", "a.txt", "bob"}); resp.Status != "error" {
+		t.Errorf("replicate_to_all by a member: %+v", resp)
+	}
+	resp := replicateToAll([]string{"g1", "a.txt", "alice"})
+	want := map[string]string{"alice": ReplicationSeeding, "bob": ReplicationPending, "carol": ReplicationPending, "erin": ReplicationFailed}
+	if resp.Status != "ok" || !reflect.DeepEqual(resp.Data, want) {
+		t.Fatalf("replicate_to_all = %+v, want %v", resp, want)
+	}
+	wantReq := replicateRequest{Cmd: "replicate_file", FileHash: "hash-a.txt", GroupID: "g1", FileName: "a.txt"}
+	for name, got := range map[string][]replicateRequest{"bob": bobGot(), "carol": carolGot()} {
+		if len(got) != 1 || got[0] != wantReq {
+			t.Errorf("%s got %+v", name, got)
+		}
+	}
+
+	// bob's download finishes, carol's doesn't
+	if resp := addSeeder([]string{"g1", "a.txt", "bob"}); resp.Status != "ok" {
+		t.Fatalf("add_seeder: %+v", resp)
+	}
+	if resp := replicationFailed([]string{"g1", "a.txt", "carol"}); resp.Status != "ok" {
+		t.Fatalf("replication_failed: %+v", resp)
+	}
+	if resp := replicationFailed([]string{"g1", "a.txt", "bob"}); resp.Status != "error" {
+		t.Errorf("replication_failed for a seeder: %+v", resp)
+	}
+	want = map[string]string{"alice": ReplicationSeeding, "bob": ReplicationSeeding, "carol": ReplicationFailed, "erin": ReplicationFailed}
+	info := getFileInfo([]string{"g1", "a.txt"})
+	if got := info.Data.(map[string]interface{})["replication_status"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("get_file_info replication_status = %v, want %v", got, want)
+	}
+}
+
+// TestReplicateToAll_Token checks that with P2P_PEER_SECRET set each member
+// is sent a replicate_file token signed for it, which isn't a download token.
+func TestReplicateToAll_Token(t *testing.T) {
+	t.Setenv(common.PeerSecretEnv, "s3cret")
+	resetGroupState(t, "alice", "bob")
+	seedUsers("alice", "bob")
+	seedFiles(t, "a.txt")
+	bobAddr, bobGot := startReplicaPeer(t, "ok")
+	mu.Lock()
+	users["bob"].Addr = bobAddr
+	mu.Unlock()
+
+	if resp := replicateToAll([]string{"g1", "a.txt", "alice"}); resp.Status != "ok" {
+		t.Fatalf("replicate_to_all: %+v", resp)
+	}
+	got := bobGot()
+	if len(got) != 1 {
+		t.Fatalf("bob got %+v", got)
+	}
+	secret := []byte("s3cret")
+	now := time.Now()
+	if user, err := common.VerifyPeerToken(secret, got[0].Token, common.ReplicateTokenSubject("hash-a.txt"), now); err != nil || user != "bob" {
+		t.Errorf("replicate token: %q, %v", user, err)
+	}
+	if _, err := common.VerifyPeerToken(secret, got[0].Token, "hash-a.txt", now); err == nil {
+		t.Error("replicate token accepted as a download token")
+	}
+}
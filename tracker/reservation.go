@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// FileReserved is the Status of a placeholder File added by reserve_slot.
+const FileReserved = "reserved"
+
+// reservationTTL is how long a reserved slot waits for its upload.
+const reservationTTL = time.Hour
+
+// errNoReservation is returned for an upload carrying a reservation token
+// when its slot isn't reserved, or the reservation has expired.
+var errNoReservation = errors.New("reservation not found or expired")
+
+// isReserved reports whether f is a reserve_slot placeholder rather than
+// an uploaded file, whether or not the reservation has expired.
+func (f *File) isReserved() bool {
+	return f.Status == FileReserved
+}
+
+// reservedAt reports whether f is a reservation still held at now.
+func (f *File) reservedAt(now time.Time) bool {
+	return f.isReserved() && now.Before(f.ReservedUntil)
+}
+
+// reserveSlot claims a file name and fileSize bytes of its group's quota
+// for userID, so a long upload can't lose them to others that start while
+// it runs. The placeholder expires after reservationTTL, and upload_file
+// into it must carry the token returned.
+// args: [groupID, fileName, fileSize, userID]
+func reserveSlot(args []string) Response {
+	if len(args) < 4 {
+		return Response{"error", "reserve_slot: need groupID, fileName, fileSize, userID"}
+	}
+	groupID, fileName, userID := args[0], args[1], args[3]
+	size, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || size < 0 {
+		return Response{"error", fmt.Sprintf("reserve_slot: invalid file size %q", args[2])}
+	}
+	token, err := newReservationToken()
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+	now := time.Now().UTC()
+	f := &File{
+		FileName:        fileName,
+		GroupID:         groupID,
+		Uploader:        userID,
+		FileSize:        size,
+		Owners:          make(map[string]bool),
+		Version:         1,
+		Status:          FileReserved,
+		ReservationHash: hashInviteCode(token),
+		ReservedUntil:   now.Add(reservationTTL),
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := checkUpload(f, 0); err != nil {
+		return Response{"error", err.Error()}
+	}
+	putFile(groupID+":"+fileName, f)
+	fmt.Printf("Slot for %s reserved in group %s by %s until %s\n", fileName, groupID, userID, f.ReservedUntil.Format(time.RFC3339))
+
+	go trackerEvents.Publish(EventFileReserved, Message{Cmd: "sync_reserve_slot", Args: []string{
+		groupID, fileName, args[2], userID, f.ReservationHash, f.ReservedUntil.Format(time.RFC3339Nano),
+	}})
+	go SaveState()
+	return Response{"ok", map[string]interface{}{
+		"reservation_token": token,
+		"group_id":          groupID,
+		"file_name":         fileName,
+		"file_size":         size,
+		"expires_at":        f.ReservedUntil,
+	}}
+}
+
+// cancelReservation gives a reserved slot back. Only the user who reserved
+// it may cancel it. args: [groupID, fileName, userID]
+func cancelReservation(args []string) Response {
+	if len(args) < 3 {
+		return Response{"error", "cancel_reservation: need groupID, fileName, userID"}
+	}
+	groupID, fileName, userID := args[0], args[1], args[2]
+	fileKey := groupID + ":" + fileName
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, ok := files[fileKey]
+	if !ok || !f.reservedAt(time.Now()) {
+		return Response{"error", errNoReservation.Error()}
+	}
+	if f.Uploader != userID {
+		return Response{"error", "not your reservation"}
+	}
+	removeFile(fileKey)
+	fmt.Printf("Reservation of %s in group %s cancelled by %s\n", fileName, groupID, userID)
+
+	go trackerEvents.Publish(EventSlotCancelled, Message{Cmd: "sync_cancel_reservation", Args: []string{groupID, fileName}})
+	go SaveState()
+	return Response{"ok", "reservation cancelled"}
+}
+
+// newReservationToken returns a random token for reserve_slot.
+func newReservationToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate reservation token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// claimReservation checks token against the reservation of fileKey, if it
+// has one, and returns the placeholder. Uploads into a reserved slot need
+// its token; an upload with a token whose reservation is gone gets
+// errNoReservation. Caller must hold mu.
+func claimReservation(fileKey, token string, now time.Time) (*File, error) {
+	r, ok := files[fileKey]
+	if !ok || !r.reservedAt(now) {
+		if token != "" {
+			return nil, errNoReservation
+		}
+		return nil, nil
+	}
+	if token == "" {
+		return nil, errors.New("file slot is reserved; upload it with its reservation token")
+	}
+	if !checkInviteCode(r.ReservationHash, token) {
+		return nil, errors.New("invalid reservation token")
+	}
+	return r, nil
+}
+
+// checkSlotFree returns an error if fileKey holds a file, or a reservation
+// that hasn't expired. Caller must hold mu.
+func checkSlotFree(fileKey string, now time.Time) error {
+	f, exists := files[fileKey]
+	switch {
+	case !exists || (f.isReserved() && !f.reservedAt(now)):
+		return nil
+	case f.isReserved():
+		return fmt.Errorf("file name is reserved by %s until %s", f.Uploader, f.ReservedUntil.Format(time.RFC3339))
+	default:
+		return errors.New("file already exists in group")
+	}
+}
+
+// pruneReservations drops reservations that expired before now.
+// Caller must hold mu.
+func pruneReservations(now time.Time) {
+	for key, f := range files {
+		if f.isReserved() && !f.reservedAt(now) {
+			removeFile(key)
+		}
+	}
+}
+
+// applyReserveSync adds a peer tracker's reservation, unless the file has
+// been uploaded here already.
+// args: [groupID, fileName, fileSize, userID, reservationHash, reservedUntil (RFC3339Nano)]
+func applyReserveSync(args []string) Response {
+	if len(args) < 6 {
+		return Response{"error", "sync_reserve_slot: need groupID, fileName, fileSize, userID, hash, reservedUntil"}
+	}
+	size, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return Response{"error", "sync_reserve_slot: bad file size"}
+	}
+	until, err := time.Parse(time.RFC3339Nano, args[5])
+	if err != nil {
+		return Response{"error", "sync_reserve_slot: bad expiry"}
+	}
+	fileKey := args[0] + ":" + args[1]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if f, ok := files[fileKey]; ok && !f.isReserved() {
+		return Response{"ok", "already uploaded"}
+	}
+	putFile(fileKey, &File{
+		FileName:        args[1],
+		GroupID:         args[0],
+		Uploader:        args[3],
+		FileSize:        size,
+		Owners:          make(map[string]bool),
+		Version:         1,
+		Status:          FileReserved,
+		ReservationHash: args[4],
+		ReservedUntil:   until,
+	})
+	fmt.Printf("[sync] slot %s reserved by %s\n", fileKey, args[3])
+	go SaveState()
+	return Response{"ok", "synced"}
+}
+
+// applyCancelReservationSync drops a reservation cancelled on a peer tracker.
+// args: [groupID, fileName]
+func applyCancelReservationSync(args []string) Response {
+	if len(args) < 2 {
+		return Response{"error", "sync_cancel_reservation: need groupID, fileName"}
+	}
+	fileKey := args[0] + ":" + args[1]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if f, ok := files[fileKey]; ok && f.isReserved() {
+		removeFile(fileKey)
+		fmt.Printf("[sync] reservation of %s cancelled\n", fileKey)
+		go SaveState()
+	}
+	return Response{"ok", "synced"}
+}
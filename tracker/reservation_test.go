@@ -0,0 +1,201 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reserve calls reserve_slot and returns the reservation token.
+func reserve(t *testing.T, fileName, size, userID string) string {
+	t.Helper()
+	resp := reserveSlot([]string{"g1", fileName, size, userID})
+	data, ok := resp.Data.(map[string]interface{})
+	if resp.Status != "ok" || !ok {
+		t.Fatalf("reserve_slot %s: %+v", fileName, resp)
+	}
+	return data["reservation_token"].(string)
+}
+
+// TestReserveSlot_HoldsNameAndQuota checks a reservation keeps others from
+// the name and the quota it holds, stays out of listings, and is turned
+// into the file by an upload carrying its token.
+func TestReserveSlot_HoldsNameAndQuota(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	groups["g1"].StorageQuota = 100
+	token := reserve(t, "big.bin", "80", "alice")
+
+	if resp := uploadFile([]string{"other.txt", "g1", "bob", "30", "h", "[]"}); resp.Status != "error" ||
+		!strings.Contains(resp.Data.(string), "quota") {
+		t.Errorf("upload past the reserved quota = %+v", resp)
+	}
+	if resp := reserveSlot([]string{"g1", "big.bin", "10", "bob"}); resp.Status != "error" ||
+		!strings.Contains(resp.Data.(string), "reserved by alice") {
+		t.Errorf("second reservation = %+v", resp)
+	}
+	if resp := listFiles([]string{"g1"}); resp.Data != "no files in group" {
+		t.Errorf("list_files shows the reservation: %+v", resp)
+	}
+	if resp := getFileInfo([]string{"g1", "big.bin"}); resp.Status != "error" {
+		t.Errorf("get_file_info on a reservation = %+v", resp)
+	}
+
+	upload := []string{"big.bin", "g1", "alice", "90", "h", "[]", "10"}
+	if resp := uploadFile(upload); resp.Status != "error" || !strings.Contains(resp.Data.(string), "reservation token") {
+		t.Errorf("upload without token = %+v", resp)
+	}
+	if resp := uploadFile(append(upload, "wrong")); resp.Data != "invalid reservation token" {
+		t.Errorf("upload with wrong token = %+v", resp)
+	}
+	// The upload may differ from the reserved size, within the quota
+	if resp := uploadFile(append(upload, token)); resp.Status != "ok" {
+		t.Fatalf("upload with token = %+v", resp)
+	}
+	f := files["g1:big.bin"]
+	if f.isReserved() || f.FileSize != 90 || !f.Owners["alice"] {
+		t.Errorf("uploaded file = %+v", f)
+	}
+	if resp := getFileInfo([]string{"g1", "big.bin"}); resp.Status != "ok" {
+		t.Errorf("get_file_info after upload = %+v", resp)
+	}
+	if resp := uploadFile(append(upload, token)); resp.Status != "error" {
+		t.Errorf("token reused = %+v", resp)
+	}
+}
+
+// TestReserveSlot_Expiry checks an expired reservation frees its name and
+// quota, and its token is no longer accepted.
+func TestReserveSlot_Expiry(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	groups["g1"].StorageQuota = 100
+	token := reserve(t, "big.bin", "80", "alice")
+	if until := files["g1:big.bin"].ReservedUntil; time.Until(until) < reservationTTL-time.Minute {
+		t.Errorf("reservation expires at %v", until)
+	}
+	files["g1:big.bin"].ReservedUntil = time.Now().Add(-time.Second)
+
+	if resp := uploadFile([]string{"big.bin", "g1", "alice", "80", "h", "[]", "10", token}); resp.Data != errNoReservation.Error() {
+		t.Errorf("upload with expired token = %+v", resp)
+	}
+	if resp := cancelReservation([]string{"g1", "big.bin", "alice"}); resp.Status != "error" {
+		t.Errorf("cancel expired reservation = %+v", resp)
+	}
+	if resp := uploadFile([]string{"big.bin", "g1", "bob", "90", "h", "[]"}); resp.Status != "ok" {
+		t.Errorf("upload after expiry = %+v", resp)
+	}
+
+	resetGroupState(t, "alice")
+	reserve(t, "old.bin", "1", "alice")
+	reserve(t, "new.bin", "1", "alice")
+	mu.Lock()
+	files["g1:old.bin"].ReservedUntil = time.Now().Add(-time.Second)
+	pruneReservations(time.Now())
+	_, oldKept := files["g1:old.bin"]
+	_, newKept := files["g1:new.bin"]
+	mu.Unlock()
+	if oldKept || !newKept {
+		t.Errorf("after prune: old kept %v, new kept %v", oldKept, newKept)
+	}
+}
+
+// TestCancelReservation checks only the user who reserved a slot can give it back.
+func TestCancelReservation(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	reserve(t, "big.bin", "80", "alice")
+
+	if resp := cancelReservation([]string{"g1", "big.bin", "bob"}); resp.Data != "not your reservation" {
+		t.Errorf("cancel by bob = %+v", resp)
+	}
+	if resp := cancelReservation([]string{"g1", "big.bin", "alice"}); resp.Status != "ok" {
+		t.Fatalf("cancel by alice = %+v", resp)
+	}
+	if _, ok := files["g1:big.bin"]; ok {
+		t.Error("placeholder kept after cancel")
+	}
+	if resp := uploadFile([]string{"big.bin", "g1", "bob", "10", "h", "[]"}); resp.Status != "ok" {
+		t.Errorf("upload after cancel = %+v", resp)
+	}
+	if resp := cancelReservation([]string{"g1", "big.bin", "bob"}); resp.Status != "error" {
+		t.Errorf("cancel of an uploaded file = %+v", resp)
+	}
+}
+
+// TestReserveSlot_Concurrent checks concurrent reservations of one name
+// give it to exactly one user, and those of different names never
+// oversubscribe the quota.
+func TestReserveSlot_Concurrent(t *testing.T) {
+	resetGroupState(t, "alice")
+	groups["g1"].StorageQuota = 100
+
+	var wg sync.WaitGroup
+	var okMu sync.Mutex
+	sameName, differentNames := 0, 0
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if reserveSlot([]string{"g1", "same.bin", "10", "alice"}).Status == "ok" {
+				okMu.Lock()
+				sameName++
+				okMu.Unlock()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			name := "part" + strings.Repeat("x", i) + ".bin"
+			if reserveSlot([]string{"g1", name, "30", "alice"}).Status == "ok" {
+				okMu.Lock()
+				differentNames++
+				okMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// same.bin takes 10 bytes, leaving room for three 30-byte reservations
+	if sameName != 1 {
+		t.Errorf("%d reservations of the same name succeeded", sameName)
+	}
+	if differentNames != 3 {
+		t.Errorf("%d 30-byte reservations fit a quota with 90 bytes free", differentNames)
+	}
+}
+
+// TestReserveSync checks a reservation synced from a peer tracker holds the
+// slot here with the same token, and is dropped by its cancellation.
+func TestReserveSync(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	token := reserve(t, "big.bin", "80", "alice")
+	hash := files["g1:big.bin"].ReservationHash
+	until := files["g1:big.bin"].ReservedUntil.Format(time.RFC3339Nano)
+
+	resetGroupState(t, "alice", "bob")
+	if resp := applyReserveSync([]string{"g1", "big.bin", "80", "alice", hash, until}); resp.Status != "ok" {
+		t.Fatalf("sync_reserve_slot = %+v", resp)
+	}
+	if resp := uploadFile([]string{"big.bin", "g1", "bob", "10", "h", "[]"}); resp.Status != "error" {
+		t.Errorf("upload into synced reservation without token = %+v", resp)
+	}
+	if resp := uploadFile([]string{"big.bin", "g1", "alice", "80", "h", "[]", "10", token}); resp.Status != "ok" {
+		t.Errorf("upload with token on peer = %+v", resp)
+	}
+	// A late reservation doesn't replace the uploaded file
+	applyReserveSync([]string{"g1", "big.bin", "80", "alice", hash, until})
+	if files["g1:big.bin"].isReserved() {
+		t.Error("sync_reserve_slot replaced an uploaded file")
+	}
+
+	applyReserveSync([]string{"g1", "other.bin", "80", "alice", hash, until})
+	applyCancelReservationSync([]string{"g1", "other.bin"})
+	if _, ok := files["g1:other.bin"]; ok {
+		t.Error("sync_cancel_reservation kept the placeholder")
+	}
+
+	// Uploads synced from the tracker that checked the token apply even
+	// when the reservation never got here
+	resetGroupState(t, "alice")
+	if resp := storeUpload([]string{"late.bin", "g1", "alice", "10", "h", "[]", "10", token}, &Message{Cmd: "sync_upload_file"}); resp.Status != "ok" {
+		t.Errorf("synced upload with token = %+v", resp)
+	}
+}
@@ -3,65 +3,121 @@ package main
 import (
 	"net"
 	"p2p/common"
+	"sync"
+	"time"
 )
 
+// muxIdleTimeout closes a multiplexed connection no request has used for this long.
+const muxIdleTimeout = 5 * time.Minute
+
 func handleConn(conn net.Conn) {
 	defer conn.Close()
 
+	// Rejected clients get no response at all
+	if !trackerACL.AllowedAddr(conn.RemoteAddr()) {
+		return
+	}
+
+	t := TCPTransport{conn: conn}
 	var msg Message
-	if err := common.Recv(conn, &msg); err != nil {
+	if err := t.Recv(&msg); err != nil {
 		return
 	}
 
-	var resp Response
+	// Clients open with hello, then send the request on the same
+	// connection. Those that don't speak protocol version 1 and are
+	// served just the same.
+	if msg.Cmd == common.HelloCmd {
+		resp := answer(msg, t.RemoteAddr())
+		if t.Send(resp) != nil || resp.Status != "ok" {
+			return
+		}
+		t.format = helloFormat(msg.Args)
+		msg = Message{}
+		if err := t.Recv(&msg); err != nil {
+			return
+		}
+	}
 
-	switch msg.Cmd {
-	case "create_user":
-		resp = createUser(msg.Args)
-	case "login":
-		resp = login(msg.Args)
-	case "update_address":
-		resp = updateAddress(msg.Args)
-	case "create_group":
-		resp = createGroup(msg.Args)
-	case "list_requests":
-		resp = listRequests(msg.Args)
-	case "accept_requests":
-		resp = acceptRequest(msg.Args)
-	case "join_group":
-		resp = joinGroup(msg.Args)
-	case "upload_file":
-		resp = uploadFile(msg.Args)
-	case "list_files":
-		resp = listFiles(msg.Args)
-	case "get_file_info":
-		resp = getFileInfo(msg.Args)
-	case "list_groups":
-		resp = listGroups(msg.Args)
-	case "stop_sharing":
-		resp = stopSharing(msg.Args)
-	case "leave_group":
-		resp = leaveGroup(msg.Args)
-	case "add_seeder":
-		resp = addSeeder(msg.Args)
+	// Peer trackers prove they share TRACKER_SYNC_SECRET before syncing
+	if msg.Cmd == peerAuthCmd {
+		serveAuth(conn, msg)
+		return
+	}
+	if syncUnauthorized(msg, false) {
+		t.Send(Response{"error", "unauthorized"})
+		return
+	}
 
-	// ── Sync commands from peer trackers ──────────────────────────────────────
-	// These apply state locally without re-broadcasting to prevent loops.
-	case "sync_create_user", "sync_create_group", "sync_join_group",
-		"sync_accept_request", "sync_upload_file", "sync_stop_sharing",
-		"sync_leave_group", "sync_add_seeder":
-		resp = applySync(msg.Cmd, msg.Args)
+	// Peer trackers on the other side of a simulated partition get no answer
+	if c, ok := trackerCommands[msg.Cmd]; ok && c.sync && partitionedHost(conn.RemoteAddr()) {
+		return
+	}
 
-	// sync_pull: return full state snapshot so a restarted tracker can catch up
-	case "sync_pull":
-		mu.RLock()
-		snap := SyncSnapshot{Users: users, Groups: groups, Files: files}
-		mu.RUnlock()
-		resp = Response{"ok", snap}
+	// Requests on a tenant's server name belong to that tenant's tracker
+	if tenantName == "" {
+		msg.Tenant = connTenant(conn)
+		if tn, ok := tenants[msg.Tenant]; ok {
+			proxyTenant(conn, msg, tn.backend)
+			return
+		}
+	}
 
-	default:
-		resp = Response{"error", "unkown command"}
+	// Requests after the first on a proxied connection are the client's
+	// own frames, so the address is taken from the first only
+	remote := proxiedRemote(msg, t.RemoteAddr())
+	resp := answer(msg, remote)
+	if msg.Stream && streamableCommands[msg.Cmd] {
+		sendStream(conn, resp)
+		return
 	}
+	if err := t.Send(resp); err == nil && msg.Mux {
+		serveMux(conn, t.format, remote, msg.Tenant)
+	}
+}
 
-	common.Send(conn, resp)
+// answer runs one request from remote, whatever transport it came over.
+// A tenant's tracker refuses requests for any other tenant.
+func answer(msg Message, remote net.Addr) Response {
+	if tenantName != "" && msg.Tenant != tenantName {
+		return Response{"error", "wrong tenant"}
+	}
+	activeRequests.Add(1)
+	defer activeRequests.Add(-1)
+	return runCommand(msg, remote)
+}
+
+// serveMux answers multiplexed requests from remote on conn until it
+// closes, sits idle for muxIdleTimeout or the tracker shuts down. Requests
+// are handled concurrently and each response carries its request's ID,
+// sent in format; those in flight are answered before it returns. Each is
+// taken to be for the tenant the connection's first request was.
+func serveMux(conn net.Conn, format common.WireFormat, remote net.Addr, tenant string) {
+	var wmu sync.Mutex
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+	defer idleUntilDrained(conn)()
+	for {
+		if !awaitRequest(conn) {
+			return
+		}
+		id, data, err := common.RecvMux(conn)
+		if err != nil {
+			return
+		}
+		inFlight.Go(func() {
+			resp := Response{"error", "invalid request"}
+			var msg Message
+			if common.UnmarshalFrame(data, &msg) == nil {
+				msg.Tenant = tenant
+				resp = Response{"error", "unauthorized"}
+				if !syncUnauthorized(msg, false) {
+					resp = answer(msg, remote)
+				}
+			}
+			wmu.Lock()
+			defer wmu.Unlock()
+			common.SendMuxFormat(conn, id, format, resp)
+		})
+	}
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// shareFile adds a reference to a file in another group: a new entry with
+// the same hash and chunks, so no data is uploaded again. The caller must
+// be a member of both groups. args: [srcGroupID, fileName, destGroupID, userID]
+func shareFile(args []string) Response {
+	if len(args) < 4 {
+		return Response{"error", "share_file: need srcGroupID, fileName, destGroupID, userID"}
+	}
+	srcGroupID, fileName, destGroupID, userID := args[0], args[1], args[2], args[3]
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	src, ok := files[srcGroupID+":"+fileName]
+	if !ok || src.isReserved() {
+		return Response{"error", "file not found"}
+	}
+	if g := groups[srcGroupID]; g == nil || !g.Members[userID] {
+		return Response{"error", "not a member of both groups"}
+	}
+	ref, err := addReference(src, destGroupID, userID)
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+
+	fmt.Printf("File %s shared from group %s to %s by %s\n", fileName, srcGroupID, destGroupID, userID)
+	go SaveState()
+	return Response{"ok", map[string]interface{}{
+		"message":   "file shared",
+		"file_name": fileName,
+		"group_id":  destGroupID,
+		"file_hash": ref.FileHash,
+	}}
+}
+
+// addReference lists src in destGroupID as a reference, on behalf of
+// userID who must be a member there, and publishes it to peer trackers.
+// The destination's storage quota applies. Caller must hold mu.
+func addReference(src *File, destGroupID, userID string) (*File, error) {
+	dest := groups[destGroupID]
+	if dest == nil {
+		return nil, errors.New("group not found")
+	}
+	if !dest.Members[userID] {
+		return nil, errors.New("not a member of both groups")
+	}
+	destKey := destGroupID + ":" + src.FileName
+	if err := checkSlotFree(destKey, time.Now()); err != nil {
+		return nil, err
+	}
+	if dest.StorageQuota > 0 {
+		if used := groupStorageUsed(destGroupID); used+src.FileSize > dest.StorageQuota {
+			return nil, fmt.Errorf("group storage quota exceeded: %d of %d bytes used, file is %d bytes",
+				used, dest.StorageQuota, src.FileSize)
+		}
+	}
+
+	now := time.Now().UTC()
+	ref := cloneFile(src)
+	ref.GroupID = destGroupID
+	ref.IsReference = true
+	ref.Version = 1
+	ref.DownloadCount = 0
+	ref.DownloadLog = nil
+	ref.CreatedAt, ref.UpdatedAt = now, now
+	putFile(destKey, ref)
+	delete(tombstones, destKey)
+
+	if data, err := json.Marshal(ref); err == nil {
+		go trackerEvents.Publish(EventFileShared, Message{Cmd: "sync_put_file", Args: []string{string(data)}})
+	}
+	return ref, nil
+}
+
+// promoteReferences turns references to fileHash into full entries once
+// the last original with that hash is gone. Caller must hold mu.
+func promoteReferences(fileHash string) {
+	if fileHash == "" {
+		return
+	}
+	var refs []string
+	for key, f := range files {
+		if f.FileHash != fileHash {
+			continue
+		}
+		if !f.IsReference {
+			return // another original remains
+		}
+		refs = append(refs, key)
+	}
+	for _, key := range refs {
+		f := files[key]
+		before := cloneFile(f)
+		f.IsReference = false
+		f.Version++
+		f.UpdatedAt = time.Now().UTC()
+		fmt.Printf("Reference %s is now a full entry\n", key)
+		go broadcastFilePatch(key, before, cloneFile(f))
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+// resetShareState sets up groups g1 and g2 and uploads report.pdf to g1.
+func resetShareState(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice", "bob")
+	mu.Lock()
+	groups["g2"] = &Group{GroupID: "g2", Owner: "alice",
+		Members: map[string]bool{"alice": true}, Pending: map[string]bool{}}
+	mu.Unlock()
+	if resp := uploadFile([]string{"report.pdf", "g1", "alice", "10", "hash-report", "[]"}); resp.Status != "ok" {
+		t.Fatalf("upload: %+v", resp)
+	}
+}
+
+// TestShareFile_Lifecycle shares a file into a second group, removes the
+// reference, shares it again and then removes the original.
+func TestShareFile_Lifecycle(t *testing.T) {
+	resetShareState(t)
+
+	if resp := shareFile([]string{"g1", "report.pdf", "g2", "alice"}); resp.Status != "ok" {
+		t.Fatalf("share_file: %+v", resp)
+	}
+	mu.RLock()
+	ref, orig := files["g2:report.pdf"], files["g1:report.pdf"]
+	mu.RUnlock()
+	if ref == nil || !ref.IsReference || ref.FileHash != "hash-report" || !ref.Owners["alice"] {
+		t.Fatalf("reference = %+v", ref)
+	}
+	if orig.IsReference || orig.GroupID != "g1" {
+		t.Fatalf("original changed by share: %+v", orig)
+	}
+	if resp := shareFile([]string{"g1", "report.pdf", "g2", "alice"}); resp.Status != "error" {
+		t.Errorf("shared twice into the same group: %+v", resp)
+	}
+
+	// Removing the reference leaves the original alone
+	if resp := stopSharing([]string{"g2", "report.pdf", "alice"}); resp.Status != "ok" {
+		t.Fatalf("stop_sharing reference: %+v", resp)
+	}
+	mu.RLock()
+	_, refLeft := files["g2:report.pdf"]
+	_, origLeft := files["g1:report.pdf"]
+	mu.RUnlock()
+	if refLeft || !origLeft {
+		t.Fatalf("after removing reference: ref present %v, original present %v", refLeft, origLeft)
+	}
+
+	// Removing the original turns the reference into a full entry
+	if resp := shareFile([]string{"g1", "report.pdf", "g2", "alice"}); resp.Status != "ok" {
+		t.Fatalf("share_file again: %+v", resp)
+	}
+	if resp := stopSharing([]string{"g1", "report.pdf", "alice"}); resp.Status != "ok" {
+		t.Fatalf("stop_sharing original: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	ref = files["g2:report.pdf"]
+	if ref == nil || ref.IsReference {
+		t.Fatalf("reference not promoted after original removed: %+v", ref)
+	}
+	if ref.Version != 2 {
+		t.Errorf("promoted version = %d, want 2", ref.Version)
+	}
+}
+
+func TestShareFile_RequiresBothMemberships(t *testing.T) {
+	resetShareState(t)
+
+	// bob is only in g1
+	if resp := shareFile([]string{"g1", "report.pdf", "g2", "bob"}); resp.Status != "error" {
+		t.Errorf("non-member of destination shared: %+v", resp)
+	}
+	if resp := shareFile([]string{"g1", "missing.pdf", "g2", "alice"}); resp.Status != "error" {
+		t.Errorf("shared a missing file: %+v", resp)
+	}
+	if resp := shareFile([]string{"g1", "report.pdf", "nope", "alice"}); resp.Status != "error" {
+		t.Errorf("shared into a missing group: %+v", resp)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if _, ok := files["g2:report.pdf"]; ok {
+		t.Error("a rejected share left a reference behind")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownTimeout is how long a stopping tracker waits for the requests
+// it is serving before closing their connections. A variable so tests can
+// shorten it.
+var shutdownTimeout = 30 * time.Second
+
+// ConnGroup tracks the connections a tracker is serving, so that on
+// shutdown it can stop taking requests and wait for those in flight.
+type ConnGroup struct {
+	wg sync.WaitGroup
+
+	// draining is cancelled when shutdown starts: connections kept open
+	// between requests (mux, peer trackers) stop waiting for the next one
+	draining   context.Context
+	startDrain context.CancelFunc
+
+	// closing is cancelled when shutdown stops waiting: every connection
+	// still being served is closed
+	closing  context.Context
+	closeAll context.CancelFunc
+}
+
+func NewConnGroup() *ConnGroup {
+	g := &ConnGroup{}
+	g.draining, g.startDrain = context.WithCancel(context.Background())
+	g.closing, g.closeAll = context.WithCancel(context.Background())
+	return g
+}
+
+// trackerConns is the group acceptConns adds connections to.
+var trackerConns atomic.Pointer[ConnGroup]
+
+func init() {
+	trackerConns.Store(NewConnGroup())
+}
+
+// acceptConns serves every connection ln accepts until ln is closed.
+func acceptConns(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			// Listener was closed, exit gracefully
+			return
+		}
+		g := trackerConns.Load()
+		g.wg.Add(1)
+		go g.serve(conn)
+	}
+}
+
+// serve runs handleConn on conn, closing conn early if the group stops
+// waiting for it.
+func (g *ConnGroup) serve(conn net.Conn) {
+	defer g.wg.Done()
+	defer context.AfterFunc(g.closing, func() { conn.Close() })()
+	handleConn(conn)
+}
+
+// Drain stops connections waiting for another request and waits up to
+// timeout for the others to finish. It then closes any still open and
+// reports whether all finished in time.
+func (g *ConnGroup) Drain(timeout time.Duration) bool {
+	g.startDrain()
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		g.closeAll()
+		return false
+	}
+}
+
+// idleUntilDrained makes a wait for conn's next request on a kept-open
+// connection end once the tracker starts shutting down. Call the returned
+// func when done with conn.
+func idleUntilDrained(conn net.Conn) (stop func() bool) {
+	return context.AfterFunc(trackerConns.Load().draining, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+}
+
+// awaitRequest sets conn's deadline for the next request on a kept-open
+// connection, and reports false if the tracker is shutting down, when
+// none should be read.
+func awaitRequest(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(muxIdleTimeout))
+	return trackerConns.Load().draining.Err() == nil
+}
+
+// serveUntil answers connections on ln until quit receives a signal. It
+// then closes ln, shuts down web unless it is nil, and waits, for up to
+// shutdownTimeout, for the requests in flight to finish, WebSocket ones
+// included.
+func serveUntil(ln net.Listener, web *http.Server, quit <-chan os.Signal) bool {
+	go acceptConns(ln)
+	<-quit
+	ln.Close()
+	if web != nil {
+		// Connections upgraded to WebSockets are left to the drain
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		web.Shutdown(ctx)
+		cancel()
+	}
+	return trackerConns.Load().Drain(shutdownTimeout)
+}
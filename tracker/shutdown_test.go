@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"p2p/common"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// useTestConnGroup gives the test a fresh ConnGroup to drain.
+func useTestConnGroup(t *testing.T) {
+	t.Helper()
+	saved := trackerConns.Swap(NewConnGroup())
+	t.Cleanup(func() { trackerConns.Store(saved) })
+}
+
+// slowCommand registers slow_cmd for the test. Its handler signals
+// started, then answers once release is closed.
+func slowCommand(t *testing.T) (started, release chan struct{}) {
+	t.Helper()
+	started, release = make(chan struct{}, 1), make(chan struct{})
+	trackerCommands["slow_cmd"] = trackerCommand{handle: func(Message, net.Addr) Response {
+		started <- struct{}{}
+		<-release
+		return Response{"ok", "done"}
+	}}
+	t.Cleanup(func() { delete(trackerCommands, "slow_cmd") })
+	return started, release
+}
+
+func dialTest(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return conn
+}
+
+// TestServeUntil_WaitsForInFlight sends SIGTERM while a slow request is
+// being answered and checks the tracker stops accepting, waits for the
+// answer to go out, and doesn't wait for an idle mux connection.
+func TestServeUntil_WaitsForInFlight(t *testing.T) {
+	useTestAuditLog(t, "")
+	useTestConnGroup(t)
+	started, release := slowCommand(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	stopped := make(chan bool, 1)
+	go func() { stopped <- serveUntil(ln, nil, quit) }()
+
+	idle := dialTest(t, addr)
+	if resp := exchange(t, idle, Message{Cmd: "list_commands", Mux: true}); resp.Status != "ok" {
+		t.Fatalf("list_commands: %+v", resp)
+	}
+	slow := dialTest(t, addr)
+	if err := common.Send(slow, Message{Cmd: "slow_cmd"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("still accepting after SIGTERM")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("stopped with a request in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	var resp Response
+	if err := common.Recv(slow, &resp); err != nil || resp.Data != "done" {
+		t.Fatalf("in-flight request: %+v, %v", resp, err)
+	}
+	select {
+	case ok := <-stopped:
+		if !ok {
+			t.Error("connections were closed rather than finishing")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tracker didn't stop once its request was answered")
+	}
+}
+
+// TestServeUntil_ClosesAfterTimeout checks a request still running after
+// shutdownTimeout has its connection closed.
+func TestServeUntil_ClosesAfterTimeout(t *testing.T) {
+	useTestAuditLog(t, "")
+	useTestConnGroup(t)
+	started, release := slowCommand(t)
+	defer close(release)
+	saved := shutdownTimeout
+	shutdownTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { shutdownTimeout = saved })
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan os.Signal, 1)
+	stopped := make(chan bool, 1)
+	go func() { stopped <- serveUntil(ln, nil, quit) }()
+
+	slow := dialTest(t, ln.Addr().String())
+	if err := common.Send(slow, Message{Cmd: "slow_cmd"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	quit <- syscall.SIGTERM
+
+	select {
+	case ok := <-stopped:
+		if ok {
+			t.Error("reported finishing with a request still running")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tracker didn't stop after shutdownTimeout")
+	}
+	var resp Response
+	if err := common.Recv(slow, &resp); err == nil {
+		t.Errorf("answered after being closed: %+v", resp)
+	}
+}
+
+// TestServeUntil_WaitsForWebSocket checks shutdown waits for a request in
+// flight on a WebSocket, then ends the connection instead of waiting for
+// its next request, and stops the HTTP server taking new ones.
+func TestServeUntil_WaitsForWebSocket(t *testing.T) {
+	useTestAuditLog(t, "")
+	useTestConnGroup(t)
+	started, release := slowCommand(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	webLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := &http.Server{Handler: http.HandlerFunc(handleWS)}
+	go web.Serve(webLn)
+	quit := make(chan os.Signal, 1)
+	stopped := make(chan bool, 1)
+	go func() { stopped <- serveUntil(ln, web, quit) }()
+
+	url := "ws://" + webLn.Addr().String() + "/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err := ws.WriteJSON(Message{Cmd: "slow_cmd"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	quit <- syscall.SIGTERM
+
+	select {
+	case <-stopped:
+		t.Fatal("stopped with a WebSocket request in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, _, err := websocket.DefaultDialer.Dial(url, nil); err == nil {
+		t.Error("new WebSocket accepted while shutting down")
+	}
+
+	close(release)
+	var resp Response
+	if err := ws.ReadJSON(&resp); err != nil || resp.Data != "done" {
+		t.Fatalf("in-flight request: %+v, %v", resp, err)
+	}
+	select {
+	case ok := <-stopped:
+		if !ok {
+			t.Error("WebSocket was closed rather than finishing")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tracker didn't stop once its WebSocket request was answered")
+	}
+}
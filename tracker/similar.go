@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+// defaultSimilarFiles is how many files similar_files lists without topN.
+const defaultSimilarFiles = 10
+
+// chunkSets holds the set of chunk hashes of every file in files, for
+// similar_files to compare without rebuilding them per request. Like
+// filesByGroup it is kept by indexFile and unindexFile, under mu.
+var chunkSets = make(map[*File]map[string]struct{})
+
+// indexChunks (re)computes f's chunk hash set and registers it in
+// chunkRegistry. Caller must hold mu.
+func indexChunks(f *File) {
+	unindexChunks(f)
+	set := make(map[string]struct{}, len(f.Chunks))
+	for _, c := range f.Chunks {
+		if c.Hash != "" {
+			set[c.Hash] = struct{}{}
+		}
+	}
+	chunkSets[f] = set
+	chunkRegistry.Add(f, set)
+}
+
+// unindexChunks drops f's chunk hash set and its registration. Caller must
+// hold mu.
+func unindexChunks(f *File) {
+	if set, ok := chunkSets[f]; ok {
+		chunkRegistry.Remove(f.GroupID, set)
+		delete(chunkSets, f)
+	}
+}
+
+// jaccard returns |a ∩ b| / |a ∪ b| and the size of the intersection.
+// Two empty sets have nothing in common and score 0.
+func jaccard(a, b map[string]struct{}) (float64, int) {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for h := range a {
+		if _, ok := b[h]; ok {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0, 0
+	}
+	return float64(shared) / float64(union), shared
+}
+
+// SimilarFile is one entry of similar_files.
+type SimilarFile struct {
+	FileName     string  `json:"file_name"`
+	FileHash     string  `json:"file_hash"`
+	Score        float64 `json:"score"`
+	SharedChunks int     `json:"shared_chunks"`
+}
+
+// similarFiles ranks the other files of a group by the Jaccard similarity
+// of their chunk hash sets to fileName's, most similar first. Files with
+// no chunk in common are left out.
+// args: [groupID, fileName, topN (optional, default 10), userID (optional; checked for membership)]
+func similarFiles(args []string) Response {
+	groupID, fileName := args[0], args[1]
+	topN := defaultSimilarFiles
+	if len(args) >= 3 && args[2] != "" {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 1 {
+			return Response{"error", "similar_files: topN must be a positive integer"}
+		}
+		topN = n
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(args) >= 4 && args[3] != "" {
+		g, ok := groups[groupID]
+		if !ok {
+			return Response{"error", "group not found"}
+		}
+		if !g.Members[args[3]] {
+			return Response{"error", "not a member of this group"}
+		}
+	}
+	file, ok := files[groupID+":"+fileName]
+	if !ok || file.isReserved() {
+		return Response{"error", "file not found"}
+	}
+
+	similar := make([]SimilarFile, 0)
+	for name, other := range groupFiles(groupID) {
+		if other == file || other.isReserved() {
+			continue
+		}
+		score, shared := jaccard(chunkSets[file], chunkSets[other])
+		if shared == 0 {
+			continue
+		}
+		similar = append(similar, SimilarFile{name, other.FileHash, score, shared})
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Score != similar[j].Score {
+			return similar[i].Score > similar[j].Score
+		}
+		return similar[i].FileName < similar[j].FileName
+	})
+	if len(similar) > topN {
+		similar = similar[:topN]
+	}
+	return Response{"ok", similar}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// uploadChunked uploads fileName to g1 as alice with one chunk per hash
+// and returns the tracker's reply.
+func uploadChunked(t *testing.T, fileName string, hashes ...string) map[string]interface{} {
+	t.Helper()
+	chunks := make([]Chunk, len(hashes))
+	for i, h := range hashes {
+		chunks[i] = Chunk{Index: i, Hash: h, Size: 10}
+	}
+	data, _ := json.Marshal(chunks)
+	size := strconv.Itoa(10 * len(hashes))
+	resp := uploadFile([]string{fileName, "g1", "alice", size, "hash-" + fileName, string(data)})
+	if resp.Status != "ok" {
+		t.Fatalf("upload %s: %+v", fileName, resp)
+	}
+	return resp.Data.(map[string]interface{})
+}
+
+func similarTo(t *testing.T, args ...string) []SimilarFile {
+	t.Helper()
+	resp := similarFiles(args)
+	if resp.Status != "ok" {
+		t.Fatalf("similar_files %v: %+v", args, resp)
+	}
+	return resp.Data.([]SimilarFile)
+}
+
+func TestJaccard(t *testing.T) {
+	set := func(hashes ...string) map[string]struct{} {
+		s := make(map[string]struct{})
+		for _, h := range hashes {
+			s[h] = struct{}{}
+		}
+		return s
+	}
+	for _, tc := range []struct {
+		name   string
+		a, b   map[string]struct{}
+		score  float64
+		shared int
+	}{
+		{"identical", set("a", "b", "c"), set("c", "b", "a"), 1, 3},
+		{"disjoint", set("a", "b"), set("c", "d"), 0, 0},
+		{"half", set("a", "b", "c"), set("b", "c", "d"), 0.5, 2},
+		{"subset", set("a"), set("a", "b", "c", "d"), 0.25, 1},
+		{"both empty", set(), set(), 0, 0},
+	} {
+		score, shared := jaccard(tc.a, tc.b)
+		if score != tc.score || shared != tc.shared {
+			t.Errorf("%s: jaccard = %v, %d shared, want %v, %d", tc.name, score, shared, tc.score, tc.shared)
+		}
+	}
+}
+
+// TestSimilarFiles ranks identical, overlapping and unrelated files and
+// checks the scores are exact.
+func TestSimilarFiles(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	uploadChunked(t, "ep1.mkv", "intro", "c1", "c2", "outro")
+	uploadChunked(t, "ep1-copy.mkv", "intro", "c1", "c2", "outro")
+	uploadChunked(t, "ep2.mkv", "intro", "d1", "d2", "outro")      // 2 of 6
+	uploadChunked(t, "ep1-cut.mkv", "intro", "c1", "c2")           // 3 of 4
+	uploadChunked(t, "notes.txt", "n1")                            // none
+	uploadChunked(t, "dup.mkv", "intro", "intro", "c1", "c2", "x") // 3 of 5 distinct
+
+	want := []SimilarFile{
+		{"ep1-copy.mkv", "hash-ep1-copy.mkv", 1, 4},
+		{"ep1-cut.mkv", "hash-ep1-cut.mkv", 0.75, 3},
+		{"dup.mkv", "hash-dup.mkv", 0.6, 3},
+		{"ep2.mkv", "hash-ep2.mkv", 2.0 / 6, 2},
+	}
+	if got := similarTo(t, "g1", "ep1.mkv"); !reflect.DeepEqual(got, want) {
+		t.Errorf("similar to ep1.mkv:\n got %+v\nwant %+v", got, want)
+	}
+	if got := similarTo(t, "g1", "ep1.mkv", "2", "bob"); !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("top 2: %+v", got)
+	}
+	if got := similarTo(t, "g1", "notes.txt"); len(got) != 0 {
+		t.Errorf("similar to notes.txt: %+v", got)
+	}
+}
+
+// TestSimilarFiles_IndexFollowsChanges checks the chunk sets are rebuilt
+// when a file is replaced or re-chunked and dropped when it is removed.
+func TestSimilarFiles_IndexFollowsChanges(t *testing.T) {
+	resetGroupState(t, "alice")
+	uploadChunked(t, "a.bin", "x", "y")
+	uploadChunked(t, "b.bin", "x", "z")
+
+	chunks := `[{"index":0,"hash":"x","size":10},{"index":1,"hash":"z","size":10}]`
+	if resp := updateFileChunks([]string{"hash-a.bin", "alice", "10", chunks, "g1"}); resp.Status != "ok" {
+		t.Fatalf("update_file_chunks: %+v", resp)
+	}
+	if got := similarTo(t, "g1", "b.bin"); len(got) != 1 || got[0].Score != 1 {
+		t.Errorf("after re-chunking: %+v", got)
+	}
+
+	mu.Lock()
+	removeFile("g1:a.bin")
+	n := len(chunkSets)
+	mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d chunk sets indexed for 1 file", n)
+	}
+	if got := similarTo(t, "g1", "b.bin"); len(got) != 0 {
+		t.Errorf("removed file still listed: %+v", got)
+	}
+}
+
+func TestSimilarFiles_Refused(t *testing.T) {
+	resetGroupState(t, "alice")
+	uploadChunked(t, "a.bin", "x")
+	for name, args := range map[string][]string{
+		"missing file": {"g1", "none.bin"},
+		"bad topN":     {"g1", "a.bin", "0"},
+		"not a member": {"g1", "a.bin", "", "mallory"},
+		"no group":     {"g2", "a.bin", "", "alice"},
+	} {
+		if resp := similarFiles(args); resp.Status != "error" {
+			t.Errorf("%s: %+v", name, resp)
+		}
+	}
+}
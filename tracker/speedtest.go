@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits on what speed_test holds: payloads are kept in memory only, so
+// they are capped in size and number and dropped if never fetched.
+const (
+	speedTestMaxBytes = 8 << 20
+	speedTestMaxHeld  = 16
+	speedTestTTL      = time.Minute
+)
+
+type heldPayload struct {
+	data    string // base64, as it is sent back
+	expires time.Time
+}
+
+// SpeedTestPayloads holds the payloads clients upload with speed_test until
+// they download them with speed_test_fetch. Nothing is persisted.
+type SpeedTestPayloads struct {
+	mu   sync.Mutex
+	now  func() time.Time
+	held map[string]heldPayload
+}
+
+var speedTestPayloads = &SpeedTestPayloads{now: time.Now, held: make(map[string]heldPayload)}
+
+// Put holds data and returns the ID to fetch it with.
+func (s *SpeedTestPayloads) Put(data string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for k, p := range s.held {
+		if now.After(p.expires) {
+			delete(s.held, k)
+		}
+	}
+	if len(s.held) >= speedTestMaxHeld {
+		return "", fmt.Errorf("%d speed tests already in progress", len(s.held))
+	}
+	s.held[id] = heldPayload{data: data, expires: now.Add(speedTestTTL)}
+	return id, nil
+}
+
+// Take returns the payload held as id and drops it.
+func (s *SpeedTestPayloads) Take(id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.held[id]
+	delete(s.held, id)
+	if !ok || s.now().After(p.expires) {
+		return "", false
+	}
+	return p.data, true
+}
+
+// speedTest holds a payload of random bytes for a client timing its
+// upload, and returns the ID speed_test_fetch gives it back for.
+// args: [sizeBytes, payloadBase64]
+func speedTest(args []string) Response {
+	size, err := strconv.Atoi(args[0])
+	if err != nil || size <= 0 || size > speedTestMaxBytes {
+		return Response{"error", fmt.Sprintf("size must be 1 to %d bytes", speedTestMaxBytes)}
+	}
+	payload, err := base64.StdEncoding.DecodeString(args[1])
+	if err != nil {
+		return Response{"error", "payload is not base64"}
+	}
+	if len(payload) != size {
+		return Response{"error", fmt.Sprintf("payload is %d bytes, expected %d", len(payload), size)}
+	}
+	id, err := speedTestPayloads.Put(args[1])
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+	return Response{"ok", id}
+}
+
+// speedTestFetch returns, once, the payload speed_test held as id.
+// args: [id]
+func speedTestFetch(args []string) Response {
+	data, ok := speedTestPayloads.Take(args[0])
+	if !ok {
+		return Response{"error", "no speed test payload " + args[0]}
+	}
+	return Response{"ok", data}
+}
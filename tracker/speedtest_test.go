@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// useTestSpeedTests gives the test an empty SpeedTestPayloads and a clock
+// it can move.
+func useTestSpeedTests(t *testing.T) *time.Time {
+	t.Helper()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	saved := speedTestPayloads
+	speedTestPayloads = &SpeedTestPayloads{now: func() time.Time { return now }, held: make(map[string]heldPayload)}
+	t.Cleanup(func() { speedTestPayloads = saved })
+	return &now
+}
+
+// TestSpeedTest_RoundTrip uploads a megabyte and fetches it back, once.
+func TestSpeedTest_RoundTrip(t *testing.T) {
+	useTestAuditLog(t, "")
+	useTestSpeedTests(t)
+	addr := startTestTracker(t)
+	payload := base64.StdEncoding.EncodeToString(make([]byte, 1<<20))
+
+	resp := sendCmd(t, addr, "speed_test", strconv.Itoa(1<<20), payload)
+	id, _ := resp.Data.(string)
+	if resp.Status != "ok" || id == "" {
+		t.Fatalf("speed_test: %+v", resp)
+	}
+	if resp := sendCmd(t, addr, "speed_test_fetch", id); resp.Status != "ok" || resp.Data != payload {
+		t.Fatalf("speed_test_fetch: %s, %d bytes", resp.Status, len(resp.Data.(string)))
+	}
+	if resp := sendCmd(t, addr, "speed_test_fetch", id); resp.Status != "error" {
+		t.Errorf("fetched twice: %+v", resp.Status)
+	}
+}
+
+func TestSpeedTest_Refused(t *testing.T) {
+	now := useTestSpeedTests(t)
+	payload := base64.StdEncoding.EncodeToString([]byte("12345"))
+	for name, args := range map[string][]string{
+		"size mismatch": {"6", payload},
+		"not base64":    {"5", "!!"},
+		"bad size":      {"x", payload},
+		"too large":     {strconv.Itoa(speedTestMaxBytes + 1), payload},
+	} {
+		if resp := speedTest(args); resp.Status != "error" {
+			t.Errorf("%s: %+v", name, resp)
+		}
+	}
+
+	for i := 0; i < speedTestMaxHeld; i++ {
+		if resp := speedTest([]string{"5", payload}); resp.Status != "ok" {
+			t.Fatalf("payload %d: %+v", i, resp)
+		}
+	}
+	if resp := speedTest([]string{"5", payload}); resp.Status != "error" {
+		t.Errorf("held more than %d payloads", speedTestMaxHeld)
+	}
+	*now = now.Add(speedTestTTL + time.Second)
+	resp := speedTest([]string{"5", payload})
+	if resp.Status != "ok" || len(speedTestPayloads.held) != 1 {
+		t.Errorf("expired payloads not dropped: %+v, %d held", resp, len(speedTestPayloads.held))
+	}
+}
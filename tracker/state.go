@@ -1,25 +1,64 @@
 package main
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 type User struct {
 	UserID   string
 	Password string
 	LoggedIn bool
 	Addr     string
+	Version  uint64 // Bumped on every replicated change; used to drop stale syncs
+
+	// Suspicious is set when another member's cross-check found this
+	// user's peer serving a chunk the other seeders disagree with. Its
+	// address is then left out of get_file_info. Like LoggedIn it is
+	// local to this tracker.
+	Suspicious bool `json:",omitempty"`
 }
 
 type Group struct {
-	GroupID string
-	Owner   string
-	Members map[string]bool
-	Pending map[string]bool
+	GroupID      string
+	Owner        string
+	Members      map[string]bool
+	Pending      map[string]bool
+	StorageQuota int64  // Max total bytes of files in the group; 0 = unlimited
+	Version      uint64 // Bumped on every replicated change; used to drop stale syncs
+
+	// InviteCodeHash is set for private groups, which join_group only accepts
+	// with the matching invite code. Empty means the group is open.
+	InviteCodeHash string `json:",omitempty"`
+
+	// Moderators are members the owner has allowed to accept join requests.
+	Moderators []string `json:",omitempty"`
+
+	// Mirrors are groups every upload to this group is also listed in.
+	Mirrors []string `json:",omitempty"`
+
+	// PendingHistory records when each join request was committed (accepted),
+	// so a sync_join_group that arrives late can't put the user back in Pending.
+	// It is local to each tracker and not part of the group hash.
+	PendingHistory map[string]time.Time
 }
 
+// acceptCommitWindow is how long after an accept a sync_join_group for the
+// same user is ignored.
+const acceptCommitWindow = 60 * time.Second
+
 type Chunk struct {
 	Index int    `json:"index"`
-	Hash  string `json:"hash"` // SHA256 hex
+	Hash  string `json:"hash"` // hex, SHA256 unless HashAlgorithm says otherwise
 	Size  int64  `json:"size"` // Bytes
+
+	// HashAlgorithm is kept as the uploading client sent it for downloaders
+	// to check chunks with; "" means SHA256.
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
+
+	// Deduplicated is set on upload when chunkRegistry held the hash
+	// already, from another file: the uploader needn't keep the chunk.
+	Deduplicated bool `json:"deduplicated,omitempty"`
 }
 
 type File struct {
@@ -28,10 +67,71 @@ type File struct {
 	Uploader    string          `json:"uploader"`
 	FileSize    int64           `json:"file_size"`
 	FileHash    string          `json:"file_hash"`     // SHA256 of entire file
-	ChunkSize   int64           `json:"chunk_size"`    // 512KB
+	ChunkSize   int64           `json:"chunk_size"`    // Chosen by the uploading client
 	TotalChunks int             `json:"total_chunks"`
 	Chunks      []Chunk         `json:"chunks"`
 	Owners      map[string]bool `json:"owners"`
+	Version     uint64          `json:"version"` // Bumped on every change; used by delta sync
+
+	// DownloadCount is bumped once per new seeder, as a proxy for completed downloads.
+	// It is synced with sync_increment_download_count rather than file patches.
+	DownloadCount int `json:"download_count"`
+
+	// DownloadLog records every add_seeder call, oldest first. Like DownloadCount
+	// it is synced on its own (sync_log_download) rather than via file patches.
+	DownloadLog []DownloadEvent `json:"download_log,omitempty"`
+
+	// CreatedAt is when the file was uploaded, UpdatedAt when it last changed.
+	// get_file_diff compares both against the caller's timestamp.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// IsReference marks an entry added by share_file, which points at the
+	// same hash and chunks as a file in another group. It becomes a full
+	// entry when the last original is removed.
+	IsReference bool `json:"is_reference,omitempty"`
+
+	// Unavailable is set by the availability monitor when none of the
+	// owners can be reached, and cleared when one logs in or seeds again.
+	// Each tracker keeps its own; it is not synced.
+	Unavailable bool `json:"unavailable,omitempty"`
+
+	// Status is FileReserved for a placeholder added by reserve_slot, which
+	// holds the name and quota until the upload arrives, and "" otherwise.
+	// ReservationHash is the salted hash of its token, checked like invite codes.
+	Status          string    `json:"status,omitempty"`
+	ReservationHash string    `json:"reservation_hash,omitempty"`
+	ReservedUntil   time.Time `json:"reserved_until,omitzero,omitempty"` // MsgPack honours only omitempty
+
+	// ReplicationStatus tracks the members replicate_to_all asked to seed
+	// the file: ReplicationPending, ReplicationSeeding or ReplicationFailed.
+	ReplicationStatus map[string]string `json:"replication_status,omitempty"`
+}
+
+// Tombstone remembers a file whose last owner stopped sharing it, so
+// get_file_diff can report the deletion. Tombstones expire after tombstoneTTL.
+type Tombstone struct {
+	GroupID   string    `json:"group_id"`
+	FileName  string    `json:"file_name"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+const tombstoneTTL = 30 * 24 * time.Hour
+
+// defaultChunkSize is assumed for uploads from clients that don't send a chunk size.
+const defaultChunkSize = 512 * 1024
+
+// Download log bounds: events returned per get_download_log, and kept per file on save
+const (
+	downloadLogPage   = 100
+	maxDownloadLogLen = 1000
+)
+
+// DownloadEvent is one entry in a file's download log.
+type DownloadEvent struct {
+	UserID    string    `json:"user_id"`
+	Timestamp time.Time `json:"timestamp"`
+	PeerAddr  string    `json:"peer_addr"`
 }
 
 var (
@@ -39,4 +139,7 @@ var (
 	groups = make(map[string]*Group)
 	files  = make(map[string]*File)
 	mu     sync.RWMutex
+
+	// tombstones holds deleted files by fileKey, guarded by mu
+	tombstones = make(map[string]*Tombstone)
 )
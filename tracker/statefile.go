@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedSuffix is added to tracker_state.json and dlq.json when they
+// are written compressed.
+const compressedSuffix = ".zst"
+
+// compressState makes SaveState and the dead letter queue write their
+// files zstd-compressed; set by --compress-state. Either form is read
+// whatever it says, so the flag can be turned on or off between restarts.
+var compressState atomic.Bool
+
+var (
+	stateZstdOnce    sync.Once
+	stateZstdEncoder *zstd.Encoder
+	stateZstdDecoder *zstd.Decoder
+	stateZstdErr     error
+)
+
+// stateCodec returns the shared zstd encoder and decoder for state files.
+func stateCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	stateZstdOnce.Do(func() {
+		stateZstdEncoder, stateZstdErr = zstd.NewWriter(nil)
+		if stateZstdErr == nil {
+			stateZstdDecoder, stateZstdErr = zstd.NewReader(nil)
+		}
+	})
+	return stateZstdEncoder, stateZstdDecoder, stateZstdErr
+}
+
+// writeStateFile writes data to path, or compressed to path.zst if
+// compressState is set. The other form is removed, so a later load can't
+// pick up a stale copy.
+func writeStateFile(path string, data []byte, perm os.FileMode) error {
+	target, stale := path, path+compressedSuffix
+	if compressState.Load() {
+		enc, _, err := stateCodec()
+		if err != nil {
+			return err
+		}
+		data = enc.EncodeAll(data, nil)
+		target, stale = stale, target
+	}
+	if err := os.WriteFile(target, data, perm); err != nil {
+		return err
+	}
+	if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readStateFile reads what writeStateFile wrote to path: path.zst,
+// decompressed, or path itself, whichever is newer if both exist. Like
+// os.ReadFile the error satisfies os.IsNotExist if neither does.
+func readStateFile(path string) ([]byte, error) {
+	plain, plainErr := os.Stat(path)
+	compressed, err := os.Stat(path + compressedSuffix)
+	if err != nil || (plainErr == nil && plain.ModTime().After(compressed.ModTime())) {
+		return os.ReadFile(path)
+	}
+	data, err := os.ReadFile(path + compressedSuffix)
+	if err != nil {
+		return nil, err
+	}
+	_, dec, err := stateCodec()
+	if err != nil {
+		return nil, err
+	}
+	data, err = dec.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path+compressedSuffix, err)
+	}
+	return data, nil
+}
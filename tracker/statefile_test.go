@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// useCompressState sets compressState for the test.
+func useCompressState(t testing.TB, on bool) {
+	t.Helper()
+	saved := compressState.Load()
+	compressState.Store(on)
+	t.Cleanup(func() { compressState.Store(saved) })
+}
+
+// currentState returns the persistent state as JSON.
+func currentState(t testing.TB) []byte {
+	t.Helper()
+	mu.RLock()
+	defer mu.RUnlock()
+	data, err := json.Marshal(TrackerState{Users: users, Groups: groups, Files: files, Tombstones: tombstones})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// clearState empties the persistent state, as after a restart.
+func clearState() {
+	mu.Lock()
+	users = make(map[string]*User)
+	groups = make(map[string]*Group)
+	replaceFiles(make(map[string]*File))
+	tombstones = make(map[string]*Tombstone)
+	mu.Unlock()
+}
+
+// TestStateFile_RoundTrip saves the state compressed and plain, checks
+// which file each writes, and that loading either gives back the same
+// state.
+func TestStateFile_RoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice", "bob")
+	seedUsers("alice", "bob")
+	seedFiles(t, "a.txt", "b.txt")
+
+	useCompressState(t, true)
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	want := currentState(t)
+	data, err := os.ReadFile(stateFile + compressedSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		t.Error("state file is not zstd")
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Errorf("uncompressed state file written too: %v", err)
+	}
+	clearState()
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if got := currentState(t); !bytes.Equal(got, want) {
+		t.Errorf("compressed round trip:\n got %s\nwant %s", got, want)
+	}
+
+	// Turning compression off replaces the compressed file
+	compressState.Store(false)
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stateFile + compressedSuffix); !os.IsNotExist(err) {
+		t.Errorf("compressed state file kept: %v", err)
+	}
+	clearState()
+	if err := LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if got := currentState(t); !bytes.Equal(got, want) {
+		t.Errorf("uncompressed round trip:\n got %s\nwant %s", got, want)
+	}
+}
+
+// TestStateFile_NewerWins checks that with both forms on disk the newer
+// one is read.
+func TestStateFile_NewerWins(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useCompressState(t, true)
+	if err := writeStateFile("x.json", []byte("compressed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("x.json", []byte("plain"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes("x.json", old, old)
+	if data, err := readStateFile("x.json"); err != nil || string(data) != "compressed" {
+		t.Errorf("readStateFile = %q, %v; want the newer compressed file", data, err)
+	}
+	os.Chtimes("x.json", time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	if data, err := readStateFile("x.json"); err != nil || string(data) != "plain" {
+		t.Errorf("readStateFile = %q, %v; want the newer plain file", data, err)
+	}
+
+	os.WriteFile("y.json"+compressedSuffix, []byte("not zstd"), 0644)
+	if _, err := readStateFile("y.json"); err == nil {
+		t.Error("read a corrupt compressed file")
+	}
+	if _, err := readStateFile("z.json"); !os.IsNotExist(err) {
+		t.Errorf("readStateFile of a missing file: %v", err)
+	}
+}
+
+// TestDLQ_Compressed saves the queue compressed and loads it back.
+func TestDLQ_Compressed(t *testing.T) {
+	useCompressState(t, true)
+	q := useTestDLQ(t)
+	q.Add("10.0.0.1:9000", Message{Cmd: "sync_create_user", Args: []string{"alice", "pw"}})
+	q.Add("10.0.0.2:9000", Message{Cmd: "sync_create_group", Args: []string{"g1", "alice"}})
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(q.path + compressedSuffix); err != nil {
+		t.Fatalf("no compressed queue file: %v", err)
+	}
+
+	loaded := &DeadLetterQueue{path: q.path}
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(loaded.entries)
+	want, _ := json.Marshal(q.entries)
+	if !bytes.Equal(got, want) || loaded.nextSeq != 2 {
+		t.Errorf("loaded queue %s (next %d), want %s", got, loaded.nextSeq, want)
+	}
+}
+
+// benchState fills the tracker with 10,000 files of 20 chunks each.
+func benchState(b *testing.B) {
+	b.Helper()
+	b.Chdir(b.TempDir())
+	resetGroupState(b, "alice")
+	m := make(map[string]*File, 10000)
+	for i := 0; i < 10000; i++ {
+		name := fmt.Sprintf("file%d.bin", i)
+		f := &File{FileName: name, GroupID: "g1", Uploader: "alice", FileSize: 20 << 20,
+			FileHash: fmt.Sprintf("%064x", i), ChunkSize: 1 << 20, TotalChunks: 20,
+			Owners: map[string]bool{"alice": true}, Version: 1}
+		for c := 0; c < 20; c++ {
+			f.Chunks = append(f.Chunks, Chunk{Index: c, Hash: fmt.Sprintf("%032x%032x", i, c), Size: 1 << 20})
+		}
+		m["g1:"+name] = f
+	}
+	mu.Lock()
+	replaceFiles(m)
+	mu.Unlock()
+	b.Cleanup(func() { resetGroupState(b, "alice") })
+}
+
+func benchmarkSaveState(b *testing.B, compress bool) {
+	benchState(b)
+	useCompressState(b, compress)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := SaveState(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	reportStateSize(b)
+}
+
+func benchmarkLoadState(b *testing.B, compress bool) {
+	benchState(b)
+	useCompressState(b, compress)
+	if err := SaveState(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := LoadState(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	reportStateSize(b)
+}
+
+// reportStateSize reports the size of the state file SaveState wrote.
+func reportStateSize(b *testing.B) {
+	path := stateFile
+	if compressState.Load() {
+		path += compressedSuffix
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(info.Size()), "file-bytes")
+}
+
+func BenchmarkSaveState_JSON(b *testing.B) { benchmarkSaveState(b, false) }
+func BenchmarkSaveState_Zstd(b *testing.B) { benchmarkSaveState(b, true) }
+func BenchmarkLoadState_JSON(b *testing.B) { benchmarkLoadState(b, false) }
+func BenchmarkLoadState_Zstd(b *testing.B) { benchmarkLoadState(b, true) }
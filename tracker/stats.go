@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// trackerStats is what local_stats answers: the IDs this tracker holds,
+// so global_stats can count each user, group and file once however many
+// trackers hold it. Files maps each files key to the file's size.
+type trackerStats struct {
+	Users  []string         `json:"users"`
+	Groups []string         `json:"groups"`
+	Files  map[string]int64 `json:"files"`
+}
+
+// localStats returns the IDs in this tracker's own state, without asking
+// any peer. Canary entries are left out, and share_file references count
+// as files but not as bytes, as they point at a file counted already.
+func localStats(args []string) Response {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	st := trackerStats{Users: []string{}, Groups: []string{}, Files: make(map[string]int64)}
+	for id := range withoutCanary(users) {
+		st.Users = append(st.Users, id)
+	}
+	for id := range withoutCanary(groups) {
+		st.Groups = append(st.Groups, id)
+	}
+	for key, f := range withoutCanary(files) {
+		if f.isReserved() {
+			continue
+		}
+		if f.IsReference {
+			st.Files[key] = 0
+		} else {
+			st.Files[key] = f.FileSize
+		}
+	}
+	sort.Strings(st.Users)
+	sort.Strings(st.Groups)
+	return Response{"ok", st}
+}
+
+// globalStats asks every peer tracker for its local_stats and merges them
+// with ours. Trackers that don't answer are listed, not fatal.
+func globalStats(args []string) Response {
+	return gatherStats(syncPeers())
+}
+
+// gatherStats is globalStats over the given peer trackers.
+func gatherStats(peers []string) Response {
+	all := []trackerStats{localStats(nil).Data.(trackerStats)}
+	unreachable := []string{}
+
+	var wmu sync.Mutex
+	var wg sync.WaitGroup
+	for _, addr := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st, err := fetchPeerStats(addr)
+			wmu.Lock()
+			defer wmu.Unlock()
+			if err != nil {
+				unreachable = append(unreachable, addr)
+				return
+			}
+			all = append(all, st)
+		}()
+	}
+	wg.Wait()
+	sort.Strings(unreachable)
+
+	users, groups, fileCount, bytes := mergeStats(all)
+	return Response{"ok", map[string]interface{}{
+		"users":                users,
+		"groups":               groups,
+		"files":                fileCount,
+		"total_bytes":          bytes,
+		"trackers_reached":     len(all),
+		"trackers_unreachable": unreachable,
+	}}
+}
+
+// fetchPeerStats asks one peer tracker for its local_stats.
+func fetchPeerStats(addr string) (trackerStats, error) {
+	var st trackerStats
+	resp, err := sendToPeer(addr, Message{Cmd: "local_stats"})
+	if err != nil {
+		return st, err
+	}
+	if resp.Status != "ok" {
+		return st, fmt.Errorf("local_stats: %v", resp.Data)
+	}
+	raw, err := json.Marshal(resp.Data)
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(raw, &st)
+	return st, err
+}
+
+// mergeStats counts the distinct users, groups and files across all and
+// sums each distinct file's size once. Trackers that disagree on a file's
+// size are settled by taking the larger.
+func mergeStats(all []trackerStats) (users, groups, fileCount int, bytes int64) {
+	userSet := make(map[string]bool)
+	groupSet := make(map[string]bool)
+	sizes := make(map[string]int64)
+	for _, st := range all {
+		for _, id := range st.Users {
+			userSet[id] = true
+		}
+		for _, id := range st.Groups {
+			groupSet[id] = true
+		}
+		for key, size := range st.Files {
+			if old, ok := sizes[key]; !ok || size > old {
+				sizes[key] = size
+			}
+		}
+	}
+	for _, size := range sizes {
+		bytes += size
+	}
+	return len(userSet), len(groupSet), len(sizes), bytes
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"p2p/common"
+	"testing"
+)
+
+// startStatsPeer is a peer tracker stand-in answering local_stats with st.
+func startStatsPeer(t *testing.T, st trackerStats) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var msg Message
+			if common.Recv(conn, &msg) == nil && msg.Cmd == "local_stats" {
+				common.Send(conn, Response{"ok", st})
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// TestGlobalStats_Dedup gives this tracker and two peers overlapping
+// users, groups and files, adds a peer that is down, and checks each ID
+// is counted once and the dead peer is reported.
+func TestGlobalStats_Dedup(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	mu.Lock()
+	users = map[string]*User{"alice": {UserID: "alice"}, "bob": {UserID: "bob"}}
+	mu.Unlock()
+	seedFiles(t, "a.txt", "b.txt") // 10 bytes each
+
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := down.Addr().String()
+	down.Close()
+
+	peers := []string{
+		startStatsPeer(t, trackerStats{
+			Users:  []string{"alice", "carol"},
+			Groups: []string{"g1", "g2"},
+			Files:  map[string]int64{"g1:a.txt": 10, "g2:c.txt": 100},
+		}),
+		startStatsPeer(t, trackerStats{
+			Users:  []string{"bob", "carol", "dave"},
+			Groups: []string{"g2"},
+			Files:  map[string]int64{"g1:b.txt": 10, "g2:c.txt": 100, "g2:d.txt": 1000},
+		}),
+		downAddr,
+	}
+
+	resp := gatherStats(peers)
+	if resp.Status != "ok" {
+		t.Fatalf("global_stats: %+v", resp)
+	}
+	data := resp.Data.(map[string]interface{})
+	if data["users"] != 4 || data["groups"] != 2 || data["files"] != 4 || data["total_bytes"] != int64(1120) {
+		t.Errorf("totals = %v", data)
+	}
+	if data["trackers_reached"] != 3 {
+		t.Errorf("trackers_reached = %v, want 3", data["trackers_reached"])
+	}
+	if dead := data["trackers_unreachable"].([]string); len(dead) != 1 || dead[0] != downAddr {
+		t.Errorf("trackers_unreachable = %v", dead)
+	}
+}
+
+// TestLocalStats_SkipsReferences checks a share_file reference counts as
+// a file but its bytes are only counted under the original.
+func TestLocalStats_SkipsReferences(t *testing.T) {
+	resetGroupState(t, "alice")
+	seedFiles(t, "a.txt")
+	mu.Lock()
+	putFile("g2:a.txt", &File{FileName: "a.txt", GroupID: "g2", FileSize: 10, IsReference: true})
+	mu.Unlock()
+
+	st := localStats(nil).Data.(trackerStats)
+	if _, _, n, bytes := mergeStats([]trackerStats{st}); n != 2 || bytes != 10 {
+		t.Errorf("files = %d, bytes = %d; want 2 and 10", n, bytes)
+	}
+}
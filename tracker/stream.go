@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+	"p2p/common"
+	"reflect"
+)
+
+// streamableCommands are the commands that answer Message.Stream with one
+// frame per item. Other commands ignore the flag.
+var streamableCommands = map[string]bool{
+	"list_files":  true,
+	"list_groups": true,
+}
+
+// sendStream writes resp as a stream: a list result is sent one item per
+// frame and an error as a single frame. Other successful results are
+// messages such as "no files in group" and send no frames. The stream
+// always ends with a StreamDone frame carrying the number of frames before it.
+func sendStream(conn net.Conn, resp Response) error {
+	var frames []Response
+	if resp.Status != "ok" {
+		frames = []Response{resp}
+	} else if v := reflect.ValueOf(resp.Data); v.Kind() == reflect.Slice {
+		frames = make([]Response, v.Len())
+		for i := range frames {
+			frames[i] = Response{"ok", v.Index(i).Interface()}
+		}
+	}
+
+	for _, f := range frames {
+		if err := common.Send(conn, f); err != nil {
+			return err
+		}
+	}
+	return common.Send(conn, Response{common.StreamDone, len(frames)})
+}
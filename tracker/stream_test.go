@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net"
+	"p2p/common"
+	"testing"
+)
+
+// streamCmd sends a streaming request and collects the frames before "done".
+func streamCmd(t *testing.T, addr, cmd string, args ...string) []common.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := common.Send(conn, Message{Cmd: cmd, Args: args, Stream: true}); err != nil {
+		t.Fatal(err)
+	}
+	var frames []common.Response
+	if err := common.RecvStream(conn, func(r common.Response) { frames = append(frames, r) }); err != nil {
+		t.Fatalf("%s stream: %v", cmd, err)
+	}
+	return frames
+}
+
+// TestStream_ListFiles verifies each file arrives in its own frame and the
+// stream is ended by a done frame that counts them.
+func TestStream_ListFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice")
+	seedFiles(t, "a.txt", "b.txt", "c.txt")
+	addr := startTestTracker(t)
+
+	frames := streamCmd(t, addr, "list_files", "g1", "alice")
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3: %+v", len(frames), frames)
+	}
+	names := map[string]bool{}
+	for _, f := range frames {
+		file, ok := f.Data.(map[string]interface{})
+		if f.Status != "ok" || !ok {
+			t.Fatalf("bad frame %+v", f)
+		}
+		names[file["file_name"].(string)] = true
+	}
+	if !names["a.txt"] || !names["b.txt"] || !names["c.txt"] {
+		t.Errorf("files = %v", names)
+	}
+
+	// The done frame is the last thing on the connection
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	common.Send(conn, Message{Cmd: "list_groups", Stream: true})
+	var first, done common.Response
+	common.Recv(conn, &first)
+	if err := common.Recv(conn, &done); err != nil || done.Status != common.StreamDone || done.Data != float64(1) {
+		t.Fatalf("done frame = %+v (%v)", done, err)
+	}
+	var extra common.Response
+	if err := common.Recv(conn, &extra); err == nil {
+		t.Errorf("frame after done: %+v", extra)
+	}
+}
+
+// TestStream_ErrorsAndEmpty verifies an error is one frame before done and an
+// empty result is no frames at all.
+func TestStream_ErrorsAndEmpty(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice")
+	addr := startTestTracker(t)
+
+	frames := streamCmd(t, addr, "list_files", "g1", "mallory")
+	if len(frames) != 1 || frames[0].Status != "error" {
+		t.Errorf("non-member got %+v", frames)
+	}
+	if frames := streamCmd(t, addr, "list_files", "g1", "alice"); len(frames) != 0 {
+		t.Errorf("empty group streamed %+v", frames)
+	}
+}
+
+// TestStream_NonStreamClientsUnaffected verifies a plain request still gets
+// the whole list in a single response.
+func TestStream_NonStreamClientsUnaffected(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice")
+	seedFiles(t, "a.txt", "b.txt")
+	addr := startTestTracker(t)
+
+	resp := sendCmd(t, addr, "list_files", "g1", "alice")
+	if list, ok := resp.Data.([]interface{}); resp.Status != "ok" || !ok || len(list) != 2 {
+		t.Errorf("list_files = %+v", resp)
+	}
+	resp = sendCmd(t, addr, "list_groups")
+	if list, ok := resp.Data.([]interface{}); resp.Status != "ok" || !ok || len(list) != 1 {
+		t.Errorf("list_groups = %+v", resp)
+	}
+
+	// Commands that don't stream ignore the flag and answer as usual
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	common.Send(conn, Message{Cmd: "get_group_info", Args: []string{"g1"}, Stream: true})
+	var info Response
+	if err := common.Recv(conn, &info); err != nil || info.Status != "ok" {
+		t.Errorf("get_group_info with stream set = %+v (%v)", info, err)
+	}
+}
@@ -5,50 +5,107 @@ import (
 	"fmt"
 	"net"
 	"p2p/common"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 )
 
-// peerAddrs holds the TCP addresses of all other trackers (set at startup)
-var peerAddrs []string
+// peerAddrs holds the tracker_info.txt entries of all other trackers. It is
+// set at startup and follows cluster membership after that; read it with
+// syncPeers.
+var (
+	peersMu   sync.RWMutex
+	peerAddrs []string
+)
+
+// syncPeers returns the trackers sync messages go to.
+func syncPeers() []string {
+	peersMu.RLock()
+	defer peersMu.RUnlock()
+	return append([]string(nil), peerAddrs...)
+}
+
+// setSyncPeers replaces the trackers sync messages go to.
+func setSyncPeers(addrs []string) {
+	peersMu.Lock()
+	defer peersMu.Unlock()
+	peerAddrs = append([]string(nil), addrs...)
+}
 
 // broadcastToTrackers fans out a sync command to all peer trackers asynchronously.
-// It skips trackers that are unreachable — they will receive the state on restart
-// via the persisted SaveState/LoadState mechanism.
-func broadcastToTrackers(cmd string, args []string) {
-	msg := Message{Cmd: cmd, Args: args}
-	for _, addr := range peerAddrs {
-		go func(target string) {
-			conn, err := net.DialTimeout("tcp", target, 500*time.Millisecond)
-			if err != nil {
-				// Peer is down — not an error, skip silently
-				return
-			}
-			defer conn.Close()
-			conn.SetDeadline(time.Now().Add(2 * time.Second))
-			if err := common.Send(conn, msg); err != nil {
-				return
-			}
-			// Read (and discard) the ack so the peer's handleConn completes cleanly
-			var resp Response
-			common.Recv(conn, &resp)
-		}(addr)
+// Handlers don't call it directly: it is subscribed to syncedEvents at startup.
+// User and group writes set msg.Version and msg.Hash to the writer's new version
+// and content hash so receivers can drop stale updates and apply group ops
+// in order.
+// Messages for trackers that are unreachable go to trackerDLQ and are
+// resent once they answer again. Messages for partitioned trackers are
+// dropped (see inject_partition).
+func broadcastToTrackers(msg Message) {
+	for _, addr := range syncPeers() {
+		if partitioned(addr) {
+			continue
+		}
+		go deliverSync(addr, msg)
 	}
 }
 
+// dialTracker connects to a tracker_info.txt entry, over TLS if it asks for it.
+func dialTracker(entry string, timeout time.Duration) (net.Conn, error) {
+	return common.DialTracker(common.ParseTrackerEndpoint(entry), timeout)
+}
+
+// sendToPeer delivers a single sync message to one peer tracker and returns its ack.
+// Errors before the message was sent, failed authentication included,
+// wrap errSyncUndelivered. Messages to a partitioned tracker are dropped
+// with errPartitioned.
+func sendToPeer(target string, msg Message) (Response, error) {
+	if partitioned(target) {
+		return Response{}, errPartitioned
+	}
+	conn, err := dialPeerTracker(target, 500*time.Millisecond)
+	if err != nil {
+		return Response{}, fmt.Errorf("%w: %v", errSyncUndelivered, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := common.Send(conn, msg); err != nil {
+		return Response{}, fmt.Errorf("%w: %v", errSyncUndelivered, err)
+	}
+	// Read the ack so the peer's handleConn completes cleanly
+	var resp Response
+	err = common.Recv(conn, &resp)
+	return resp, err
+}
+
 // applySync applies an inbound sync message to local in-memory state
 // WITHOUT re-broadcasting (prevents infinite loops).
-func applySync(cmd string, args []string) Response {
+// User and group messages are checked against the local version first.
+func applySync(msg Message) Response {
+	cmd, args := msg.Cmd, msg.Args
 	switch cmd {
 	case "sync_create_user":
 		if len(args) < 2 {
 			return Response{"error", "sync_create_user: need user, pass"}
 		}
 		user, pass := args[0], args[1]
+		incoming := &User{UserID: user, Password: pass, Version: msg.Version}
 		mu.Lock()
 		defer mu.Unlock()
-		if _, exists := users[user]; !exists {
-			users[user] = &User{UserID: user, Password: pass}
+		local, exists := users[user]
+		if !exists {
+			users[user] = incoming
 			fmt.Printf("[sync] created user %s\n", user)
+			return Response{"ok", "synced"}
+		}
+		switch checkVersion("user", user, local.Version, userHash(local), msg) {
+		case syncApply:
+			local.Password, local.Version = pass, msg.Version
+		case syncConflict:
+			// Same version on both sides; pick by hash so every tracker keeps the same one
+			if msg.Hash > userHash(local) {
+				local.Password = pass
+			}
 		}
 		return Response{"ok", "synced"}
 
@@ -57,17 +114,41 @@ func applySync(cmd string, args []string) Response {
 			return Response{"error", "sync_create_group: need groupID, owner"}
 		}
 		groupID, owner := args[0], args[1]
+		var quota int64
+		if len(args) >= 3 {
+			quota, _ = strconv.ParseInt(args[2], 10, 64)
+		}
+		incoming := &Group{
+			GroupID:      groupID,
+			Owner:        owner,
+			Members:      map[string]bool{owner: true},
+			Pending:      make(map[string]bool),
+			StorageQuota: quota,
+			Version:      msg.Version,
+		}
+		// The invite code arrives already hashed
+		if len(args) >= 4 {
+			incoming.InviteCodeHash = args[3]
+		}
 		mu.Lock()
 		defer mu.Unlock()
-		if _, exists := groups[groupID]; !exists {
-			groups[groupID] = &Group{
-				GroupID: groupID,
-				Owner:   owner,
-				Members: map[string]bool{owner: true},
-				Pending: make(map[string]bool),
-			}
+		local, exists := groups[groupID]
+		if !exists {
+			groups[groupID] = incoming
 			fmt.Printf("[sync] created group %s\n", groupID)
+			releaseGroupOps(groupID)
+			return Response{"ok", "synced"}
 		}
+		switch checkVersion("group", groupID, local.Version, groupHash(local), msg) {
+		case syncApply:
+			groups[groupID] = incoming
+		case syncConflict:
+			// Two trackers created the same group at once; pick by hash so both keep the same one
+			if msg.Hash > groupHash(local) {
+				groups[groupID] = incoming
+			}
+		}
+		releaseGroupOps(groupID)
 		return Response{"ok", "synced"}
 
 	case "sync_join_group":
@@ -77,10 +158,15 @@ func applySync(cmd string, args []string) Response {
 		groupID, userID := args[0], args[1]
 		mu.Lock()
 		defer mu.Unlock()
-		if g, ok := groups[groupID]; ok {
+		applyGroupOp(msg, groupID, func(g *Group) {
+			// A join delivered after its own accept must not undo it
+			if recentlyAccepted(g, userID, time.Now()) {
+				fmt.Printf("[sync] ignoring late join of %s to group %s: already accepted\n", userID, groupID)
+				return
+			}
 			g.Pending[userID] = true
 			fmt.Printf("[sync] %s pending in group %s\n", userID, groupID)
-		}
+		})
 		return Response{"ok", "synced"}
 
 	case "sync_accept_request":
@@ -90,11 +176,101 @@ func applySync(cmd string, args []string) Response {
 		groupID, userID := args[0], args[1]
 		mu.Lock()
 		defer mu.Unlock()
-		if g, ok := groups[groupID]; ok {
-			delete(g.Pending, userID)
-			g.Members[userID] = true
+		applyGroupOp(msg, groupID, func(g *Group) {
+			commitAccept(g, userID, time.Now())
 			fmt.Printf("[sync] accepted %s into group %s\n", userID, groupID)
+		})
+		return Response{"ok", "synced"}
+
+	case "sync_bulk_add_members":
+		if len(args) < 2 {
+			return Response{"error", "sync_bulk_add_members: need groupID, userIDs"}
 		}
+		groupID := args[0]
+		mu.Lock()
+		defer mu.Unlock()
+		applyGroupOp(msg, groupID, func(g *Group) {
+			now := time.Now()
+			for _, userID := range args[1:] {
+				commitAccept(g, userID, now)
+			}
+			fmt.Printf("[sync] added %d members to group %s\n", len(args)-1, groupID)
+			go SaveState()
+		})
+		return Response{"ok", "synced"}
+
+	case "sync_import_group":
+		if len(args) < 2 {
+			return Response{"error", "sync_import_group: need ownerID, bundleJSON"}
+		}
+		b, err := parseGroupBundle(args[1])
+		if err != nil {
+			return Response{"error", "sync_import_group: " + err.Error()}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// Merging is idempotent, so a group that is here already only
+		// gains what it lacks
+		result, changed := mergeGroupBundle(args[0], b, time.Now())
+		if g := groups[b.Group.GroupID]; g.Version < msg.Version {
+			g.Version = msg.Version
+		}
+		releaseGroupOps(b.Group.GroupID)
+		if changed {
+			fmt.Printf("[sync] imported group %s: %d members and %d files added\n", b.Group.GroupID, len(result.MembersAdded), len(result.FilesAdded))
+			go SaveState()
+		}
+		return Response{"ok", "synced"}
+
+	case "sync_set_group_quota":
+		if len(args) < 2 {
+			return Response{"error", "sync_set_group_quota: need groupID, bytes"}
+		}
+		quota, err := parseQuota(args[1])
+		if err != nil {
+			return Response{"error", err.Error()}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		applyGroupOp(msg, args[0], func(g *Group) {
+			g.StorageQuota = quota
+			fmt.Printf("[sync] quota for group %s set to %d bytes\n", args[0], quota)
+			go SaveState()
+		})
+		return Response{"ok", "synced"}
+
+	case "sync_rename_group":
+		if len(args) < 2 {
+			return Response{"error", "sync_rename_group: need groupID, newGroupID"}
+		}
+		groupID, newGroupID := args[0], args[1]
+		mu.Lock()
+		defer mu.Unlock()
+		applyGroupOp(msg, groupID, func(g *Group) {
+			if err := renameGroupLocked(groupID, newGroupID); err != nil {
+				fmt.Printf("[sync] rename of group %s failed: %v\n", groupID, err)
+				return
+			}
+			fmt.Printf("[sync] group %s renamed to %s\n", groupID, newGroupID)
+			go SaveState()
+		})
+		// Ops stamped with the new ID may have arrived first
+		releaseGroupOps(newGroupID)
+		return Response{"ok", "synced"}
+
+	case "sync_delete_group":
+		if len(args) < 1 {
+			return Response{"error", "sync_delete_group: need groupID"}
+		}
+		groupID := args[0]
+		mu.Lock()
+		defer mu.Unlock()
+		applyGroupOp(msg, groupID, func(g *Group) {
+			deleted, _ := deleteGroupLocked(groupID)
+			delete(heldGroupOps, groupID)
+			fmt.Printf("[sync] group %s deleted with %d files\n", groupID, deleted)
+			go SaveState()
+		})
 		return Response{"ok", "synced"}
 
 	case "sync_upload_file":
@@ -102,8 +278,8 @@ func applySync(cmd string, args []string) Response {
 		if len(args) < 6 {
 			return Response{"error", "sync_upload_file: insufficient args"}
 		}
-		// Reuse the existing uploadFile handler (it's idempotent for new files)
-		resp := uploadFile(args)
+		// Reuse the existing upload handler (it's idempotent for new files)
+		resp := storeUpload(args, &msg)
 		fmt.Printf("[sync] upload_file result: %s\n", resp.Status)
 		return Response{"ok", "synced"}
 
@@ -111,8 +287,19 @@ func applySync(cmd string, args []string) Response {
 		if len(args) < 3 {
 			return Response{"error", "sync_stop_sharing: need groupID, fileName, userID"}
 		}
-		resp := stopSharing(args)
-		fmt.Printf("[sync] stop_sharing result: %s\n", resp.Status)
+		// Applied locally only: stopSharing would re-broadcast back to the sender
+		groupID, fileName, userID := args[0], args[1], args[2]
+		fileKey := groupID + ":" + fileName
+		mu.Lock()
+		defer mu.Unlock()
+		if f, ok := files[fileKey]; ok {
+			delete(f.Owners, userID)
+			fileInfoCache.Invalidate(fileKey)
+			if len(f.Owners) == 0 {
+				buryFile(fileKey)
+			}
+			fmt.Printf("[sync] %s stopped sharing %s/%s\n", userID, groupID, fileName)
+		}
 		return Response{"ok", "synced"}
 
 	case "sync_leave_group":
@@ -122,10 +309,45 @@ func applySync(cmd string, args []string) Response {
 		groupID, userID := args[0], args[1]
 		mu.Lock()
 		defer mu.Unlock()
-		if g, ok := groups[groupID]; ok {
-			delete(g.Members, userID)
+		applyGroupOp(msg, groupID, func(g *Group) {
+			removeMember(g, userID)
 			fmt.Printf("[sync] %s left group %s\n", userID, groupID)
+		})
+		return Response{"ok", "synced"}
+
+	case "sync_add_moderator", "sync_remove_moderator":
+		if len(args) < 2 {
+			return Response{"error", msg.Cmd + ": need groupID, userID"}
+		}
+		groupID, userID := args[0], args[1]
+		mu.Lock()
+		defer mu.Unlock()
+		applyGroupOp(msg, groupID, func(g *Group) {
+			if msg.Cmd == "sync_remove_moderator" {
+				dropModerator(g, userID)
+				fmt.Printf("[sync] %s no longer moderates group %s\n", userID, groupID)
+			} else if !isModerator(g, userID) {
+				g.Moderators = append(g.Moderators, userID)
+				fmt.Printf("[sync] %s now moderates group %s\n", userID, groupID)
+			}
+			go SaveState()
+		})
+		return Response{"ok", "synced"}
+
+	case "sync_set_mirrors":
+		if len(args) < 1 {
+			return Response{"error", "sync_set_mirrors: need groupID"}
 		}
+		mu.Lock()
+		defer mu.Unlock()
+		applyGroupOp(msg, args[0], func(g *Group) {
+			g.Mirrors = append([]string(nil), args[1:]...)
+			if len(g.Mirrors) == 0 {
+				g.Mirrors = nil
+			}
+			fmt.Printf("[sync] mirrors for group %s set to %v\n", args[0], g.Mirrors)
+			go SaveState()
+		})
 		return Response{"ok", "synced"}
 
 	case "sync_add_seeder":
@@ -138,15 +360,183 @@ func applySync(cmd string, args []string) Response {
 		defer mu.Unlock()
 		if f, ok := files[fileKey]; ok {
 			f.Owners[userID] = true
+			fileInfoCache.Invalidate(fileKey)
 			fmt.Printf("[sync] %s added as seeder for %s/%s\n", userID, groupID, fileName)
 		}
 		return Response{"ok", "synced"}
 
+	case "sync_increment_download_count":
+		if len(args) < 2 {
+			return Response{"error", "sync_increment_download_count: need groupID, fileName"}
+		}
+		fileKey := args[0] + ":" + args[1]
+		mu.Lock()
+		defer mu.Unlock()
+		if f, ok := files[fileKey]; ok {
+			f.DownloadCount++
+			fmt.Printf("[sync] %s download count now %d\n", fileKey, f.DownloadCount)
+		}
+		return Response{"ok", "synced"}
+
+	case "sync_log_download":
+		// args: groupID, fileName, userID, timestamp (RFC3339Nano), peerAddr
+		if len(args) < 5 {
+			return Response{"error", "sync_log_download: need groupID, fileName, userID, timestamp, peerAddr"}
+		}
+		ts, err := time.Parse(time.RFC3339Nano, args[3])
+		if err != nil {
+			return Response{"error", "sync_log_download: bad timestamp"}
+		}
+		fileKey := args[0] + ":" + args[1]
+		mu.Lock()
+		defer mu.Unlock()
+		if f, ok := files[fileKey]; ok {
+			f.DownloadLog = append(f.DownloadLog, DownloadEvent{UserID: args[2], Timestamp: ts, PeerAddr: args[4]})
+			recordActivity(args[0], ActivityDownload, args[2], ts)
+			fmt.Printf("[sync] logged download of %s by %s\n", fileKey, args[2])
+		}
+		return Response{"ok", "synced"}
+
+	case "sync_patch_file":
+		return applyPatchSync(args)
+
+	case "sync_put_file":
+		return applyPutSync(args)
+
+	case "sync_batch_upload":
+		return applyBatchSync(args)
+
+	case "sync_reserve_slot":
+		return applyReserveSync(args)
+
+	case "sync_cancel_reservation":
+		return applyCancelReservationSync(args)
+
 	default:
 		return Response{"error", "unknown sync command"}
 	}
 }
 
+// groupGapWait is how long group ops that arrived ahead of a missing
+// version are held before the group is pulled from a peer.
+var groupGapWait = 5 * time.Second
+
+// heldGroupOp is a group op waiting for the versions before it.
+type heldGroupOp struct {
+	msg Message
+	op  func(g *Group)
+}
+
+// heldGroupOps holds, by group ID and in version order, ops that arrived
+// before the op they follow. Broadcasts run concurrently, so v3 can
+// overtake v2. Guarded by mu.
+var heldGroupOps = make(map[string][]heldGroupOp)
+
+// applyGroupOp runs op on groupID once every earlier version of it has been
+// applied. A group op is stamped with the version it moves the group to:
+// the next version is applied, older ones are dropped by checkVersion, and
+// later ones are held until the ops before them arrive. Group ops only add
+// or remove set entries, so on a same-version conflict each tracker applies
+// the other's op and both end up with the same group. Unversioned ops, from
+// older trackers, are applied as they come. Caller must hold mu.
+func applyGroupOp(msg Message, groupID string, op func(g *Group)) {
+	g, ok := groups[groupID]
+	if msg.Version != 0 && (!ok || msg.Version > g.Version+1) {
+		holdGroupOp(groupID, msg, op)
+		return
+	}
+	if ok && runGroupOp(g, msg, op) {
+		releaseGroupOps(groupID)
+	}
+}
+
+// runGroupOp applies op to g if msg passes the version check and reports
+// whether g moved to a new version. Caller must hold mu.
+func runGroupOp(g *Group, msg Message, op func(g *Group)) bool {
+	switch checkVersion("group", g.GroupID, g.Version, groupHash(g), msg) {
+	case syncApply:
+		op(g)
+		if msg.Version > g.Version {
+			g.Version = msg.Version
+			return true
+		}
+	case syncConflict:
+		op(g)
+	}
+	return false
+}
+
+// holdGroupOp queues op until releaseGroupOps finds it next in line. The
+// first op held for a group starts a fillGroupGap timer. Caller must hold mu.
+func holdGroupOp(groupID string, msg Message, op func(g *Group)) {
+	held := heldGroupOps[groupID]
+	if len(held) == 0 {
+		time.AfterFunc(groupGapWait, func() { fillGroupGap(groupID) })
+	}
+	fmt.Printf("[sync] holding %s for group %s at v%d until the versions before it arrive\n", msg.Cmd, groupID, msg.Version)
+	i := sort.Search(len(held), func(i int) bool { return held[i].msg.Version > msg.Version })
+	heldGroupOps[groupID] = append(held[:i:i], append([]heldGroupOp{{msg, op}}, held[i:]...)...)
+}
+
+// releaseGroupOps applies the held ops of groupID that are now next in
+// line, and drops those it has moved past. Caller must hold mu.
+func releaseGroupOps(groupID string) {
+	for {
+		held := heldGroupOps[groupID]
+		g, ok := groups[groupID]
+		if !ok || len(held) == 0 || held[0].msg.Version > g.Version+1 {
+			return
+		}
+		if len(held) == 1 {
+			delete(heldGroupOps, groupID)
+		} else {
+			heldGroupOps[groupID] = held[1:]
+		}
+		runGroupOp(g, held[0].msg, held[0].op)
+	}
+}
+
+// fillGroupGap runs groupGapWait after an op was first held for groupID. If
+// ops are still waiting, the group is pulled from a peer, which brings in
+// the versions they wait for if any peer has them. What is still held after
+// that follows ops no tracker could provide, and is applied in order rather
+// than held forever.
+func fillGroupGap(groupID string) {
+	mu.RLock()
+	waiting := len(heldGroupOps[groupID]) > 0
+	mu.RUnlock()
+	if !waiting {
+		return
+	}
+	for _, addr := range syncPeers() {
+		if pullStateFrom(addr) == nil {
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	releaseGroupOps(groupID)
+	held := heldGroupOps[groupID]
+	delete(heldGroupOps, groupID)
+	if len(held) == 0 {
+		return
+	}
+	g, ok := groups[groupID]
+	if !ok {
+		fmt.Printf("[sync] dropping %d ops for unknown group %s\n", len(held), groupID)
+		return
+	}
+	fmt.Printf("[sync] WARNING: group %s never got v%d; applying %d later ops\n", groupID, g.Version+1, len(held))
+	for _, h := range held {
+		h.op(g)
+		if h.msg.Version > g.Version {
+			g.Version = h.msg.Version
+		}
+	}
+	go SaveState()
+}
+
 // ── Rejoin Sync ───────────────────────────────────────────────────────────────
 
 // SyncSnapshot is the full state snapshot exchanged during tracker rejoin.
@@ -164,62 +554,91 @@ func pullStateFromPeers() {
 	// Give a moment for the TCP listener to be ready before dialling peers
 	time.Sleep(500 * time.Millisecond)
 
-	for _, addr := range peerAddrs {
-		conn, err := net.DialTimeout("tcp", addr, 1*time.Second)
-		if err != nil {
-			continue // peer is also down, try next
-		}
-
-		conn.SetDeadline(time.Now().Add(5 * time.Second))
-		if err := common.Send(conn, Message{Cmd: "sync_pull"}); err != nil {
-			conn.Close()
-			continue
+	for _, addr := range syncPeers() {
+		if pullStateFrom(addr) == nil {
+			return // one successful pull is enough
 		}
+	}
+	fmt.Println("[rejoin] no live peers found, starting with local state only")
+}
 
-		var resp Response
-		if err := common.Recv(conn, &resp); err != nil || resp.Status != "ok" {
-			conn.Close()
-			continue
-		}
-		conn.Close()
+// pullStateFrom requests a full state snapshot from the tracker at addr
+// and merges it into local state.
+func pullStateFrom(addr string) error {
+	conn, err := dialPeerTracker(addr, 1*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
 
-		// Unmarshal snapshot from resp.Data (JSON-encoded)
-		raw, err := json.Marshal(resp.Data)
-		if err != nil {
-			continue
-		}
-		var snap SyncSnapshot
-		if err := json.Unmarshal(raw, &snap); err != nil {
-			continue
-		}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := common.Send(conn, Message{Cmd: "sync_pull"}); err != nil {
+		return err
+	}
+	var resp Response
+	if err := common.Recv(conn, &resp); err != nil {
+		return err
+	}
+	if resp.Status != "ok" {
+		return fmt.Errorf("sync_pull: %v", resp.Data)
+	}
 
-		mergeState(snap)
-		fmt.Printf("[rejoin] merged state from %s (%d users, %d groups, %d files)\n",
-			addr, len(snap.Users), len(snap.Groups), len(snap.Files))
-		return // one successful pull is enough
+	// Unmarshal snapshot from resp.Data (JSON-encoded)
+	raw, err := json.Marshal(resp.Data)
+	if err != nil {
+		return err
 	}
-	fmt.Println("[rejoin] no live peers found, starting with local state only")
+	var snap SyncSnapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		return err
+	}
+
+	mergeState(snap)
+	fmt.Printf("[rejoin] merged state from %s (%d users, %d groups, %d files)\n",
+		addr, len(snap.Users), len(snap.Groups), len(snap.Files))
+	return nil
 }
 
-// mergeState adds entries from snap that are not already present locally.
-// It never overwrites existing data (last-writer-wins via broadcast handles conflicts).
+// mergeState adds entries from snap that are missing locally and replaces
+// entries for which the peer holds a higher version. Equal versions with
+// different contents are a conflict: it is logged and the entry with the
+// greater content hash wins, so every tracker settles on the same one.
 func mergeState(snap SyncSnapshot) {
 	mu.Lock()
 	defer mu.Unlock()
 
 	for id, u := range snap.Users {
-		if _, exists := users[id]; !exists {
+		local, exists := users[id]
+		if !exists || preferIncoming("user", id, local.Version, userHash(local), u.Version, userHash(u)) {
+			// Login state isn't versioned; keep ours when replacing an existing user
+			if exists {
+				u.LoggedIn, u.Addr = local.LoggedIn, local.Addr
+			}
 			users[id] = u
 		}
 	}
 	for id, g := range snap.Groups {
-		if _, exists := groups[id]; !exists {
+		local, exists := groups[id]
+		if !exists || preferIncoming("group", id, local.Version, groupHash(local), g.Version, groupHash(g)) {
 			groups[id] = g
 		}
 	}
 	for key, f := range snap.Files {
-		if _, exists := files[key]; !exists {
-			files[key] = f
+		if local, exists := files[key]; !exists || preferIncoming("file", key, local.Version, fileEntryHash(local), f.Version, fileEntryHash(f)) {
+			putFile(key, f)
 		}
 	}
 }
+
+// preferIncoming reports whether a snapshot entry should replace the local one.
+func preferIncoming(kind, id string, localVersion uint64, localHash string, version uint64, hash string) bool {
+	if version != localVersion {
+		return version > localVersion
+	}
+	if hash == localHash {
+		return false
+	}
+	fmt.Printf("[sync] WARNING: conflicting %s %s at v%d, keeping the entry with hash %.8s\n",
+		kind, id, version, max(hash, localHash))
+	return hash > localHash
+}
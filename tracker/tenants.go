@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"p2p/common"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// tenantEnv names the tenant a tenant tracker process serves.
+const tenantEnv = "TRACKER_TENANT"
+
+// tenantInfoFile is the one-line tracker_info.txt written into a tenant's
+// data directory, giving its tracker process a loopback address.
+const tenantInfoFile = "tenant_tracker_info.txt"
+
+// Tenant is one --tenants entry: a logical tracker cluster reached on the
+// shared port by TLS server name (SNI), with its own certificate and state.
+//
+// The tracker's users, groups and files are package-level and shared by
+// every handler and background job, so each tenant runs as its own
+// tracker process in DataDir, listening on loopback. The SNI listener
+// terminates TLS with the tenant's certificate, stamps the request's
+// Tenant and passes the connection through.
+type Tenant struct {
+	Host     string
+	CertPath string
+	KeyPath  string
+	DataDir  string
+
+	cert    tls.Certificate
+	backend string // the tenant tracker's loopback address
+	cmd     *exec.Cmd
+}
+
+var (
+	// tenants maps lower-case server names to tenants; empty unless --tenants is given.
+	tenants = make(map[string]*Tenant)
+	// tenantName is set in a tenant's own tracker process, which only
+	// answers requests the SNI listener stamped with it.
+	tenantName = os.Getenv(tenantEnv)
+)
+
+// parseTenantsFlag removes --tenants from args.
+func parseTenantsFlag(args []string) (string, []string, error) {
+	path := ""
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--tenants" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("--tenants requires a file path")
+			}
+			i++
+			value = args[i]
+		}
+		path = value
+	}
+	return path, rest, nil
+}
+
+// loadTenants reads a tenants file and loads each tenant's certificate.
+// Each line is
+//
+//	hostname cert.pem key.pem [dataDir]
+//
+// and blank lines and lines starting with # are skipped. The data
+// directory defaults to tenants/<hostname>.
+func loadTenants(path string) (map[string]*Tenant, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	res := make(map[string]*Tenant)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: want hostname, cert, key and an optional data directory", lineNum)
+		}
+		t := &Tenant{Host: strings.ToLower(fields[0]), CertPath: fields[1], KeyPath: fields[2]}
+		t.DataDir = filepath.Join("tenants", t.Host)
+		if len(fields) == 4 {
+			t.DataDir = fields[3]
+		}
+		if _, dup := res[t.Host]; dup {
+			return nil, fmt.Errorf("line %d: %s listed twice", lineNum, t.Host)
+		}
+		if t.cert, err = tls.LoadX509KeyPair(t.CertPath, t.KeyPath); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		res[t.Host] = t
+	}
+	return res, scanner.Err()
+}
+
+// tenantTLSConfig picks each connection's certificate by server name:
+// a tenant's own, or def (if any) for every other name.
+func tenantTLSConfig(def *tls.Certificate, ts map[string]*Tenant) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if t, ok := ts[strings.ToLower(hello.ServerName)]; ok {
+				return &t.cert, nil
+			}
+			if def == nil {
+				return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+			}
+			return def, nil
+		},
+	}
+}
+
+// startTenant runs t's tracker process in its data directory on a free
+// loopback port.
+func startTenant(t *Tenant) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(t.DataDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	t.backend = ln.Addr().String()
+	ln.Close()
+	if err := os.WriteFile(filepath.Join(dir, tenantInfoFile), []byte(t.backend+"\n"), 0644); err != nil {
+		return err
+	}
+
+	t.cmd = exec.Command(exe, tenantInfoFile, "1")
+	t.cmd.Dir = dir
+	t.cmd.Env = append(os.Environ(), tenantEnv+"="+t.Host)
+	t.cmd.Stdout, t.cmd.Stderr = os.Stdout, os.Stderr
+	return t.cmd.Start()
+}
+
+// stopTenants asks every tenant tracker to save its state and exit.
+func stopTenants() {
+	for _, t := range tenants {
+		if t.cmd == nil || t.cmd.Process == nil {
+			continue
+		}
+		t.cmd.Process.Signal(syscall.SIGTERM)
+		done := make(chan struct{})
+		go func() { t.cmd.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.cmd.Process.Kill()
+		}
+	}
+}
+
+// connTenant returns the server name a TLS client asked for, lower-cased,
+// or "" on a plain connection.
+func connTenant(conn net.Conn) string {
+	if tc, ok := conn.(*tls.Conn); ok {
+		return strings.ToLower(tc.ConnectionState().ServerName)
+	}
+	return ""
+}
+
+// proxiedRemote returns the address a tenant's tracker serves a connection
+// as: the client's, which the proxying tracker stamped on its first
+// request, rather than the proxy's loopback address, which would pass the
+// admin commands' isLoopback check and share every per-address limit.
+// Other trackers, and connections not from loopback, keep remote.
+func proxiedRemote(msg Message, remote net.Addr) net.Addr {
+	if tenantName == "" || msg.ClientAddr == "" || !isLoopback(remote) {
+		return remote
+	}
+	addr, err := net.ResolveTCPAddr("tcp", msg.ClientAddr)
+	if err != nil {
+		return &net.TCPAddr{IP: net.IPv4zero} // never loopback
+	}
+	return addr
+}
+
+// proxyTenant forwards msg and the rest of conn to a tenant's tracker.
+// The tracker learns the client's address from msg, see proxiedRemote.
+func proxyTenant(conn net.Conn, msg Message, backend string) {
+	upstream, err := net.DialTimeout("tcp", backend, 5*time.Second)
+	if err != nil {
+		common.Send(conn, Response{"error", "tenant tracker unavailable"})
+		return
+	}
+	defer upstream.Close()
+	msg.ClientAddr = conn.RemoteAddr().String()
+	if err := common.Send(upstream, msg); err != nil {
+		return
+	}
+	// Responses, and any further multiplexed requests, pass straight through
+	go func() {
+		io.Copy(upstream, conn)
+		if tc, ok := upstream.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		}
+	}()
+	io.Copy(conn, upstream)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTenantCert writes a self-signed certificate for host and returns
+// the certificate and key paths.
+func writeTenantCert(t *testing.T, host string) (string, string) {
+	t.Helper()
+	certPEM, keyPEM, err := selfSignedCert(host + ":9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := host+".crt", host+".key"
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+// startTenantBackend stands in for a tenant's tracker process, answering
+// every request with its name and the request's Tenant.
+func startTenantBackend(t *testing.T, name string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				var msg Message
+				if common.Recv(c, &msg) == nil {
+					common.Send(c, Response{"ok", map[string]interface{}{"backend": name, "tenant": msg.Tenant}})
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// useTenants installs tenants for a.example and b.example, each served by
+// a stand-in backend.
+func useTenants(t *testing.T) {
+	t.Helper()
+	var lines []string
+	for _, host := range []string{"a.example", "b.example"} {
+		cert, key := writeTenantCert(t, host)
+		lines = append(lines, strings.Join([]string{host, cert, key}, " "))
+	}
+	os.WriteFile("tenants.txt", []byte(strings.Join(lines, "\n")), 0644)
+	ts, err := loadTenants("tenants.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts["a.example"].backend = startTenantBackend(t, "A")
+	ts["b.example"].backend = startTenantBackend(t, "B")
+
+	saved := tenants
+	tenants = ts
+	t.Cleanup(func() { tenants = saved })
+}
+
+// TestTenants_SNIRouting dials the shared port with different server
+// names and checks each gets its tenant's certificate and tenant's state,
+// and that a name no tenant claims is served by this tracker.
+func TestTenants_SNIRouting(t *testing.T) {
+	t.Chdir(t.TempDir())
+	resetGroupState(t, "alice")
+	useTenants(t)
+	certPEM, keyPEM, err := selfSignedCert("tracker.example:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tenantTLSConfig(&def, tenants))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go acceptConns(ln)
+
+	ask := func(serverName string) Response {
+		t.Helper()
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("dial %s: %v", serverName, err)
+		}
+		defer conn.Close()
+		if err := conn.ConnectionState().PeerCertificates[0].VerifyHostname(serverName); err != nil {
+			t.Errorf("%s got the wrong certificate: %v", serverName, err)
+		}
+		// A client can't pick its tenant by setting the field itself
+		if err := common.Send(conn, Message{Cmd: "list_groups", Tenant: "b.example"}); err != nil {
+			t.Fatal(err)
+		}
+		var resp Response
+		if err := common.Recv(conn, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for host, backend := range map[string]string{"a.example": "A", "B.example": "B"} {
+		data, _ := ask(host).Data.(map[string]interface{})
+		if data["backend"] != backend || data["tenant"] != strings.ToLower(host) {
+			t.Errorf("%s routed to %v", host, data)
+		}
+	}
+	resp := ask("tracker.example")
+	if list, _ := resp.Data.([]interface{}); resp.Status != "ok" || len(list) != 1 {
+		t.Errorf("default tenant answered %+v, want this tracker's one group", resp)
+	}
+}
+
+// TestTenantTracker_OnlyItsTenant checks a tenant's tracker process turns
+// away requests not stamped with its name.
+func TestTenantTracker_OnlyItsTenant(t *testing.T) {
+	resetGroupState(t, "alice")
+	saved := tenantName
+	tenantName = "a.example"
+	t.Cleanup(func() { tenantName = saved })
+	addr := startTestTracker(t)
+
+	send := func(tenant string) Response {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		common.Send(conn, Message{Cmd: "list_groups", Tenant: tenant})
+		var resp Response
+		if err := common.Recv(conn, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := send(""); resp.Status != "error" {
+		t.Errorf("unstamped request answered: %+v", resp)
+	}
+	if resp := send("b.example"); resp.Status != "error" {
+		t.Errorf("other tenant's request answered: %+v", resp)
+	}
+	if resp := send("a.example"); resp.Status != "ok" {
+		t.Errorf("own tenant's request: %+v", resp)
+	}
+
+	// Multiplexed requests are for the tenant of the connection's first
+	mux := func(tenant string) Response {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		common.Send(conn, Message{Cmd: "list_groups", Tenant: tenant, Mux: true})
+		var resp Response
+		if err := common.Recv(conn, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if err := common.NewMux(conn).Call(Message{Cmd: "list_groups", Tenant: "a.example"}, &resp, 2*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := mux("b.example"); resp.Status != "error" {
+		t.Errorf("other tenant's multiplexed request answered: %+v", resp)
+	}
+	if resp := mux("a.example"); resp.Status != "ok" {
+		t.Errorf("own tenant's multiplexed request: %+v", resp)
+	}
+}
+
+// remoteConn is a connection that reports addr as its client's address.
+type remoteConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr { return c.addr }
+
+// TestProxyTenant_RemoteClientNotLocal proxies a remote client's requests
+// to a tenant's tracker, which must not take them for local ones: neither
+// the first request nor a multiplexed one claiming a loopback address may
+// read the audit log.
+func TestProxyTenant_RemoteClientNotLocal(t *testing.T) {
+	useTestAuditLog(t, "")
+	saved := tenantName
+	tenantName = "a.example"
+	t.Cleanup(func() { tenantName = saved })
+	backend := startTestTracker(t)
+
+	proxy := func(addr string, first Message) net.Conn {
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		remote, _ := net.ResolveTCPAddr("tcp", addr)
+		first.Tenant = "a.example"
+		go func() {
+			defer server.Close()
+			proxyTenant(remoteConn{server, remote}, first, backend)
+		}()
+		return client
+	}
+	recv := func(conn net.Conn) Response {
+		var resp Response
+		if err := common.Recv(conn, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := recv(proxy("127.0.0.1:4000", Message{Cmd: "get_audit_log"})); resp.Status != "ok" {
+		t.Errorf("local client refused: %+v", resp)
+	}
+	if resp := recv(proxy("203.0.113.5:4000", Message{Cmd: "get_audit_log", ClientAddr: "127.0.0.1:1"})); resp.Status != "error" {
+		t.Errorf("remote client read the audit log: %+v", resp)
+	}
+
+	conn := proxy("203.0.113.5:4000", Message{Cmd: "list_commands", Mux: true})
+	if resp := recv(conn); resp.Status != "ok" {
+		t.Fatalf("list_commands: %+v", resp)
+	}
+	var resp Response
+	msg := Message{Cmd: "get_audit_log", Tenant: "a.example", ClientAddr: "127.0.0.1:1"}
+	if err := common.NewMux(conn).Call(msg, &resp, 2*time.Second); err != nil || resp.Status != "error" {
+		t.Errorf("multiplexed get_audit_log from a remote client: %+v, %v", resp, err)
+	}
+}
+
+func TestLoadTenants(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cert, key := writeTenantCert(t, "a.example")
+	os.WriteFile("tenants.txt", []byte("# tenants\n\nA.example "+cert+" "+key+"\nb.example "+cert+" "+key+" /srv/b\n"), 0644)
+	ts, err := loadTenants("tenants.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 2 || ts["a.example"].DataDir != filepath.Join("tenants", "a.example") || ts["b.example"].DataDir != "/srv/b" {
+		t.Errorf("tenants = %+v", ts)
+	}
+
+	for _, bad := range []string{
+		"a.example " + cert,
+		"a.example " + cert + " " + key + " dir extra",
+		"a.example missing.crt missing.key",
+		"a.example " + cert + " " + key + "\nA.EXAMPLE " + cert + " " + key,
+	} {
+		os.WriteFile("tenants.txt", []byte(bad), 0644)
+		if _, err := loadTenants("tenants.txt"); err == nil {
+			t.Errorf("loadTenants accepted %q", bad)
+		}
+	}
+
+	path, rest, err := parseTenantsFlag([]string{"--tenants=t.txt", "tracker_info.txt", "1"})
+	if err != nil || path != "t.txt" || len(rest) != 2 {
+		t.Errorf("parseTenantsFlag = %q, %v, %v", path, rest, err)
+	}
+}
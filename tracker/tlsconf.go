@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// autoCert is the --tls-cert value that asks for a self-signed certificate.
+const autoCert = "auto"
+
+// parseTLSFlags removes --tls-cert and --tls-key from args. Both must be
+// given, unless the certificate is "auto".
+func parseTLSFlags(args []string) (certPath, keyPath string, rest []string, err error) {
+	rest = []string{}
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--tls-cert" && name != "--tls-key" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return "", "", nil, fmt.Errorf("%s requires a file path", name)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "--tls-cert" {
+			certPath = value
+		} else {
+			keyPath = value
+		}
+	}
+	if certPath != autoCert && (certPath == "") != (keyPath == "") {
+		return "", "", nil, fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	return certPath, keyPath, rest, nil
+}
+
+// loadTrackerCert loads the certificate pair, or for "auto" reuses or
+// creates a self-signed one for address so its fingerprint survives restarts.
+func loadTrackerCert(certPath, keyPath, address string) (tls.Certificate, error) {
+	if certPath != autoCert {
+		return tls.LoadX509KeyPair(certPath, keyPath)
+	}
+	base := "tracker_" + strings.NewReplacer(":", "_", ".", "_").Replace(address)
+	certPath, keyPath = base+".crt", base+".key"
+	if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		return cert, nil
+	}
+
+	certPEM, keyPEM, err := selfSignedCert(address)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+	fmt.Printf("Generated self-signed certificate %s\n", certPath)
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// selfSignedCert creates a PEM certificate and key valid for address's
+// host, localhost and 127.0.0.1.
+func selfSignedCert(address string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "p2p tracker " + address},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(5, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// updateTrackerInfo replaces the lineNum-th entry (counting as the tracker
+// does, skipping blanks and comments) of configFile with entry.
+func updateTrackerInfo(configFile string, lineNum int, entry string) error {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var out []string
+	n := 0
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			n++
+			if n == lineNum {
+				line = entry
+			}
+		}
+		out = append(out, line)
+	}
+	if n < lineNum {
+		return fmt.Errorf("%s has no entry %d", configFile, lineNum)
+	}
+
+	tmp := configFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(out, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, configFile)
+}
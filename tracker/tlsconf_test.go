@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"p2p/common"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTLSTracker serves handleConn over TLS with a self-signed certificate
+// and returns the address and certificate fingerprint.
+func startTLSTracker(t *testing.T) (string, string) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	cert, err := loadTrackerCert(autoCert, "", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := common.CertificateFingerprint(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go acceptConns(ln)
+	return ln.Addr().String(), fp
+}
+
+// TestTLS_PinnedRoundTrip sends a request over TLS to a tracker whose
+// certificate is pinned by fingerprint.
+func TestTLS_PinnedRoundTrip(t *testing.T) {
+	addr, fp := startTLSTracker(t)
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(users, "tls_user")
+		mu.Unlock()
+	})
+	conn, err := dialTracker("tls://"+addr+" sha256:"+fp, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := common.Send(conn, Message{Cmd: "create_user", Args: []string{"tls_user", "pw"}}); err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	if err := common.Recv(conn, &resp); err != nil {
+		t.Fatalf("recv over TLS: %v", err)
+	}
+	if resp.Status != "ok" {
+		t.Fatalf("create_user over TLS: %+v", resp)
+	}
+}
+
+func TestTLS_WrongPinRejected(t *testing.T) {
+	addr, _ := startTLSTracker(t)
+	conn, err := dialTracker("tls://"+addr+" sha256:"+strings.Repeat("0", 64), time.Second)
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected the handshake to fail with a wrong fingerprint")
+	}
+}
+
+// TestTLS_UnpinnedSelfSignedRejected checks a plain tls:// entry still
+// verifies the chain, which a self-signed certificate fails.
+func TestTLS_UnpinnedSelfSignedRejected(t *testing.T) {
+	addr, _ := startTLSTracker(t)
+	conn, err := dialTracker("tls://"+addr, time.Second)
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected a self-signed certificate to fail verification without a pin")
+	}
+}
+
+func TestLoadTrackerCert_AutoReused(t *testing.T) {
+	t.Chdir(t.TempDir())
+	first, err := loadTrackerCert(autoCert, "", "127.0.0.1:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := loadTrackerCert(autoCert, "", "127.0.0.1:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first.Certificate, second.Certificate) {
+		t.Fatal("restarting with --tls-cert auto changed the certificate")
+	}
+}
+
+func TestParseTLSFlags(t *testing.T) {
+	cert, key, rest, err := parseTLSFlags([]string{"tracker_info.txt", "--tls-cert", "a.crt", "--tls-key=a.key", "1"})
+	if err != nil || cert != "a.crt" || key != "a.key" || !reflect.DeepEqual(rest, []string{"tracker_info.txt", "1"}) {
+		t.Fatalf("got %q %q %v %v", cert, key, rest, err)
+	}
+	if _, _, _, err := parseTLSFlags([]string{"--tls-cert", "a.crt"}); err == nil {
+		t.Fatal("expected an error for a certificate without a key")
+	}
+	if cert, _, _, err := parseTLSFlags([]string{"--tls-cert", "auto"}); err != nil || cert != autoCert {
+		t.Fatalf("auto: %q %v", cert, err)
+	}
+}
+
+func TestUpdateTrackerInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracker_info.txt")
+	os.WriteFile(path, []byte("# trackers\n127.0.0.1:9000\n\n127.0.0.1:9001\n"), 0644)
+	if err := updateTrackerInfo(path, 2, "tls://127.0.0.1:9001 sha256:ab"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	want := "# trackers\n127.0.0.1:9000\n\ntls://127.0.0.1:9001 sha256:ab\n"
+	if string(data) != want {
+		t.Fatalf("got %q, want %q", data, want)
+	}
+	if err := updateTrackerInfo(path, 3, "x"); err == nil {
+		t.Fatal("expected an error for a missing entry")
+	}
+}
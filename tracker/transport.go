@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"p2p/common"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MessageTransport carries client requests to the tracker and its
+// responses back, whatever the connection underneath.
+type MessageTransport interface {
+	Recv(msg *Message) error
+	Send(resp Response) error
+	RemoteAddr() net.Addr
+}
+
+// TCPTransport is the tracker's own protocol: length-prefixed frames on a
+// TCP (or TLS) connection. Responses are JSON unless hello agreed on
+// another format; requests are read in any.
+type TCPTransport struct {
+	conn   net.Conn
+	format common.WireFormat
+}
+
+func (t TCPTransport) Recv(msg *Message) error  { return common.Recv(t.conn, msg) }
+func (t TCPTransport) Send(resp Response) error { return common.SendFormat(t.conn, t.format, resp) }
+func (t TCPTransport) RemoteAddr() net.Addr     { return t.conn.RemoteAddr() }
+
+// WSTransport carries one JSON Message or Response per WebSocket text
+// message, for browser clients.
+type WSTransport struct {
+	conn *websocket.Conn
+}
+
+func (t WSTransport) Recv(msg *Message) error  { return t.conn.ReadJSON(msg) }
+func (t WSTransport) Send(resp Response) error { return t.conn.WriteJSON(resp) }
+func (t WSTransport) RemoteAddr() net.Addr     { return t.conn.RemoteAddr() }
+
+var wsUpgrader = websocket.Upgrader{CheckOrigin: wsOriginAllowed}
+
+// handleWS serves /ws: browser clients send the same Messages as TCP
+// clients and get the same Responses, one request after another on a
+// connection kept open until they close it. Streaming and multiplexing
+// are TCP only, and requests always go to this tracker's own state rather
+// than being routed by tenant.
+func handleWS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || !trackerACL.Allowed(net.ParseIP(host)) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	// Served as part of trackerConns, like TCP connections, so shutdown
+	// waits for the request in flight and stops waiting for the next one
+	g := trackerConns.Load()
+	if g.draining.Err() != nil {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	g.wg.Add(1)
+	defer g.wg.Done()
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has answered with the error
+	}
+	defer conn.Close()
+	defer context.AfterFunc(g.closing, func() { conn.Close() })()
+	defer context.AfterFunc(g.draining, func() { conn.SetReadDeadline(time.Now()) })()
+	serveTransport(WSTransport{conn})
+}
+
+// serveTransport answers requests from t until it fails or is closed.
+func serveTransport(t MessageTransport) {
+	for {
+		var msg Message
+		if err := t.Recv(&msg); err != nil {
+			return
+		}
+		msg.Tenant = tenantName
+		msg.Stream, msg.Mux = false, false
+		resp := Response{"error", "unauthorized"}
+		if !syncUnauthorized(msg, false) {
+			resp = answer(msg, t.RemoteAddr())
+		}
+		if err := t.Send(resp); err != nil {
+			return
+		}
+	}
+}
+
+// wsOriginAllowed accepts WebSocket handshakes from pages on the tracker's
+// own host or an origin allowed by TRACKER_CORS_ORIGINS, so other sites
+// can't use a visitor's browser to reach it.
+func wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // not a browser
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return true
+	}
+	allowed := corsOrigins()
+	return slices.Contains(allowed, "*") || slices.Contains(allowed, origin)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWS serves handleWS on a test HTTP server and connects to it.
+func dialWS(t *testing.T, header http.Header) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(handleWS))
+	t.Cleanup(srv.Close)
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+// TestWSTransport_Session creates and logs in a user over a WebSocket, and
+// another over TCP, and checks both got the same answers and sessions.
+func TestWSTransport_Session(t *testing.T) {
+	t.Chdir(t.TempDir())
+	mu.Lock()
+	users = make(map[string]*User)
+	mu.Unlock()
+	tcpAddr := startTestTracker(t)
+	ws, _, err := dialWS(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsCmd := func(cmd string, args ...string) Response {
+		t.Helper()
+		if err := ws.WriteJSON(Message{Cmd: cmd, Args: args}); err != nil {
+			t.Fatal(err)
+		}
+		var resp Response
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	steps := []struct {
+		cmd  string
+		args []string
+	}{
+		{"create_user", []string{"pass123"}},
+		{"create_user", []string{"again"}},
+		{"login", []string{"wrong", "127.0.0.1:7000"}},
+		{"login", []string{"pass123", "127.0.0.1:7000"}},
+		{"update_address", []string{"127.0.0.1:7001"}},
+	}
+	for _, s := range steps {
+		overTCP := sendCmd(t, tcpAddr, s.cmd, append([]string{"tcp-user"}, s.args...)...)
+		overWS := wsCmd(s.cmd, append([]string{"ws-user"}, s.args...)...)
+		if !reflect.DeepEqual(overWS, overTCP) {
+			t.Errorf("%s %v: over WebSocket %+v, over TCP %+v", s.cmd, s.args, overWS, overTCP)
+		}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	tcpUser, wsUser := *users["tcp-user"], *users["ws-user"]
+	wsUser.UserID = tcpUser.UserID
+	if !wsUser.LoggedIn || wsUser.Addr != "127.0.0.1:7001" || !reflect.DeepEqual(wsUser, tcpUser) {
+		t.Errorf("session over WebSocket %+v, over TCP %+v", wsUser, tcpUser)
+	}
+}
+
+func TestWSTransport_Origin(t *testing.T) {
+	t.Setenv("TRACKER_CORS_ORIGINS", "https://app.example")
+	if _, resp, err := dialWS(t, http.Header{"Origin": {"https://evil.example"}}); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("handshake from another origin: %v", err)
+	}
+	if _, _, err := dialWS(t, http.Header{"Origin": {"https://app.example"}}); err != nil {
+		t.Errorf("handshake from an allowed origin: %v", err)
+	}
+}
+
+// TestHealthServer_TLS checks that with the tracker's TLS config /ws is
+// served as wss, and a plain ws handshake gets nowhere.
+func TestHealthServer_TLS(t *testing.T) {
+	t.Chdir(t.TempDir())
+	certPEM, keyPEM, err := selfSignedCert("127.0.0.1:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newHealthServer("", nil, tenantTLSConfig(&cert, nil))
+	go srv.ServeTLS(ln, "", "")
+	t.Cleanup(func() { srv.Close() })
+	addr := ln.Addr().String()
+
+	if _, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil); err == nil {
+		t.Error("plain ws handshake accepted")
+	}
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	ws, _, err := dialer.Dial("wss://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatalf("wss: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.WriteJSON(Message{Cmd: "list_commands"}); err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	if err := ws.ReadJSON(&resp); err != nil || resp.Status != "ok" {
+		t.Errorf("list_commands over wss = %+v, %v", resp, err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"p2p/common"
+)
+
+// The client release the tracker announces, read from the environment on
+// each request so it can change without a restart:
+//
+//	LATEST_CLIENT_VERSION    the newest version, e.g. v1.4.0
+//	LATEST_CLIENT_CHANGELOG  a URL describing the release
+//	LATEST_CLIENT_GROUP,
+//	LATEST_CLIENT_FILE       where the binary is shared, for auto_update
+//	LATEST_CLIENT_SHA256     the binary's SHA-256, which auto_update checks
+//	                         before installing it
+func latestClientRelease() (version, changelog, groupID, fileName, fileHash string) {
+	return os.Getenv("LATEST_CLIENT_VERSION"), os.Getenv("LATEST_CLIENT_CHANGELOG"),
+		os.Getenv("LATEST_CLIENT_GROUP"), os.Getenv("LATEST_CLIENT_FILE"), os.Getenv("LATEST_CLIENT_SHA256")
+}
+
+// clientVersion tells a client whether a newer release than its version
+// is announced, and where to download it.
+// args: [version]
+func clientVersion(args []string) Response {
+	current, err := common.ParseVersion(args[0])
+	if err != nil {
+		return Response{"error", err.Error()}
+	}
+	latestStr, changelog, groupID, fileName, fileHash := latestClientRelease()
+	if latestStr == "" {
+		return Response{"ok", map[string]interface{}{
+			"update_available": false,
+			"message":          "up to date (no client version announced)",
+		}}
+	}
+	latest, err := common.ParseVersion(latestStr)
+	if err != nil {
+		return Response{"error", "tracker misconfigured: LATEST_CLIENT_VERSION: " + err.Error()}
+	}
+
+	if current.Compare(latest) >= 0 {
+		return Response{"ok", map[string]interface{}{
+			"update_available": false,
+			"latest":           latest.String(),
+			"message":          "up to date",
+		}}
+	}
+	msg := fmt.Sprintf("update available: %s", latest)
+	if changelog != "" {
+		msg += " (changelog: " + changelog + ")"
+	}
+	return Response{"ok", map[string]interface{}{
+		"update_available": true,
+		"latest":           latest.String(),
+		"changelog":        changelog,
+		"group_id":         groupID,
+		"file_name":        fileName,
+		"file_hash":        fileHash,
+		"message":          msg,
+	}}
+}
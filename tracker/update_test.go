@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClientVersion(t *testing.T) {
+	t.Setenv("LATEST_CLIENT_VERSION", "")
+	if resp := clientVersion([]string{"v1.0.0"}); resp.Status != "ok" || resp.Data.(map[string]interface{})["update_available"] != false {
+		t.Errorf("nothing announced: %+v", resp)
+	}
+
+	t.Setenv("LATEST_CLIENT_VERSION", "v1.4.0")
+	t.Setenv("LATEST_CLIENT_CHANGELOG", "https://example.com/changes/v1.4.0")
+	t.Setenv("LATEST_CLIENT_GROUP", "releases")
+	t.Setenv("LATEST_CLIENT_FILE", "client_bin-v1.4.0")
+	t.Setenv("LATEST_CLIENT_SHA256", "abc123")
+
+	for _, v := range []string{"v1.4.0", "1.4.0", "v1.4.1", "v2.0.0-rc.1"} {
+		data := clientVersion([]string{v}).Data.(map[string]interface{})
+		if data["update_available"] != false || data["message"] != "up to date" {
+			t.Errorf("%s: %v", v, data)
+		}
+	}
+	for _, v := range []string{"v1.3.9", "v1.4.0-rc.2", "v0.0.0-dev"} {
+		data := clientVersion([]string{v}).Data.(map[string]interface{})
+		msg, _ := data["message"].(string)
+		if data["update_available"] != true || !strings.HasPrefix(msg, "update available: v1.4.0") ||
+			!strings.Contains(msg, "https://example.com/changes/v1.4.0") {
+			t.Errorf("%s: %v", v, data)
+		}
+		if data["group_id"] != "releases" || data["file_name"] != "client_bin-v1.4.0" || data["file_hash"] != "abc123" {
+			t.Errorf("%s: download location = %v/%v, hash %v", v, data["group_id"], data["file_name"], data["file_hash"])
+		}
+	}
+
+	if resp := clientVersion([]string{"latest"}); resp.Status != "error" {
+		t.Errorf("bad client version: %+v", resp)
+	}
+	t.Setenv("LATEST_CLIENT_VERSION", "soon")
+	if resp := clientVersion([]string{"v1.0.0"}); resp.Status != "error" {
+		t.Errorf("bad announced version: %+v", resp)
+	}
+}
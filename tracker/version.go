@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// syncVerdict says what to do with an inbound versioned sync message.
+type syncVerdict int
+
+const (
+	syncApply     syncVerdict = iota // incoming version is newer (or unversioned)
+	syncStale                        // incoming version is older; discard
+	syncDuplicate                    // same version and content; already applied
+	syncConflict                     // same version, different content
+)
+
+// checkVersion compares an inbound message against the local entity's version and content hash.
+// Messages without a version come from older trackers and are always applied.
+func checkVersion(kind, id string, localVersion uint64, localHash string, msg Message) syncVerdict {
+	switch {
+	case msg.Version == 0 || msg.Version > localVersion:
+		return syncApply
+	case msg.Version < localVersion:
+		fmt.Printf("[sync] discarding stale %s for %s %s (v%d < local v%d)\n", msg.Cmd, kind, id, msg.Version, localVersion)
+		return syncStale
+	case msg.Hash == localHash:
+		return syncDuplicate
+	default:
+		fmt.Printf("[sync] WARNING: conflicting writes to %s %s at v%d\n", kind, id, localVersion)
+		return syncConflict
+	}
+}
+
+// groupSync builds the sync message for a write to g, stamped with its
+// current version and hash. Call it with mu held, before g can change again.
+func groupSync(g *Group, cmd string, args []string) Message {
+	return Message{Cmd: cmd, Args: args, Version: g.Version, Hash: groupHash(g)}
+}
+
+// userHash hashes the replicated fields of a user. Login state is
+// per-tracker and not part of it.
+func userHash(u *User) string {
+	return contentHash(struct {
+		UserID   string
+		Password string
+	}{u.UserID, u.Password})
+}
+
+// fileEntryHash hashes the replicated fields of a file entry. Times, the
+// download log and Unavailable are kept by each tracker and left out, and
+// so are chunk Deduplicated flags, which depend on what else it holds.
+func fileEntryHash(f *File) string {
+	chunks := make([]string, len(f.Chunks))
+	for i, c := range f.Chunks {
+		chunks[i] = c.Hash
+	}
+	return contentHash(struct {
+		Uploader, FileHash  string
+		FileSize, ChunkSize int64
+		Chunks              []string
+		Owners              map[string]bool
+		IsReference         bool
+		Status              string
+	}{f.Uploader, f.FileHash, f.FileSize, f.ChunkSize, chunks, f.Owners, f.IsReference, f.Status})
+}
+
+// groupHash hashes a group's contents, ignoring its version.
+func groupHash(g *Group) string {
+	c := *g
+	c.Version = 0
+	c.PendingHistory = nil
+	return contentHash(c)
+}
+
+// contentHash returns the SHA256 hex of v's JSON encoding.
+// Map keys are sorted by encoding/json, so equal contents hash equally.
+func contentHash(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
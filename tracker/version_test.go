@@ -0,0 +1,365 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newVersionedGroup returns g1 owned by alice at the given version.
+func newVersionedGroup(version uint64) *Group {
+	return &Group{
+		GroupID: "g1",
+		Owner:   "alice",
+		Members: map[string]bool{"alice": true},
+		Pending: map[string]bool{},
+		Version: version,
+	}
+}
+
+// installGroup makes g the only group in the global state, with no ops held.
+func installGroup(t *testing.T, g *Group) {
+	t.Helper()
+	mu.Lock()
+	groups = map[string]*Group{g.GroupID: g}
+	heldGroupOps = make(map[string][]heldGroupOp)
+	mu.Unlock()
+}
+
+// TestConcurrentGroupWrites simulates two trackers that both start from g1 at
+// v1 and accept a different join request at the same time. Each one then
+// receives the other's sync at the same version with a different hash. The
+// conflict must be detected and both trackers must end up with the same group.
+func TestConcurrentGroupWrites(t *testing.T) {
+	// Tracker B's side of the race, computed on its own copy of the group
+	trackerB := newVersionedGroup(1)
+	trackerB.Pending["carol"] = true
+	trackerB.Version++
+	fromB := groupSync(trackerB, "sync_join_group", []string{"g1", "carol"})
+
+	// Tracker A (this process) handles bob's join locally
+	installGroup(t, newVersionedGroup(1))
+	if resp := joinGroup([]string{"g1", "bob"}); resp.Status != "ok" {
+		t.Fatalf("join_group: %+v", resp)
+	}
+	mu.RLock()
+	trackerA := *groups["g1"]
+	trackerA.Pending = map[string]bool{"bob": true}
+	mu.RUnlock()
+	if trackerA.Version != fromB.Version {
+		t.Fatalf("both writes should land on v%d, tracker A is at v%d", fromB.Version, trackerA.Version)
+	}
+	fromA := groupSync(&trackerA, "sync_join_group", []string{"g1", "bob"})
+	if fromA.Hash == fromB.Hash {
+		t.Fatal("different writes must hash differently")
+	}
+
+	if verdict := checkVersion("group", "g1", trackerA.Version, fromA.Hash, fromB); verdict != syncConflict {
+		t.Fatalf("verdict = %v, want conflict", verdict)
+	}
+
+	// Deliver each tracker's write to the other
+	applySync(fromB)
+	mu.RLock()
+	gotA := *groups["g1"]
+	mu.RUnlock()
+
+	installGroup(t, trackerB)
+	applySync(fromA)
+	mu.RLock()
+	gotB := *groups["g1"]
+	mu.RUnlock()
+
+	want := map[string]bool{"bob": true, "carol": true}
+	if !reflect.DeepEqual(gotA.Pending, want) || !reflect.DeepEqual(gotB.Pending, want) {
+		t.Errorf("trackers diverged: A=%v B=%v, want %v", gotA.Pending, gotB.Pending, want)
+	}
+	if groupHash(&gotA) != groupHash(&gotB) || gotA.Version != gotB.Version {
+		t.Errorf("trackers disagree: A v%d, B v%d", gotA.Version, gotB.Version)
+	}
+}
+
+// TestApplySync_DiscardsStale verifies an update older than the local version is dropped.
+func TestApplySync_DiscardsStale(t *testing.T) {
+	installGroup(t, newVersionedGroup(5))
+
+	applySync(Message{Cmd: "sync_join_group", Args: []string{"g1", "mallory"}, Version: 4, Hash: "x"})
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if groups["g1"].Pending["mallory"] {
+		t.Error("stale update must not be applied")
+	}
+	if groups["g1"].Version != 5 {
+		t.Errorf("version = %d, want 5", groups["g1"].Version)
+	}
+}
+
+// TestApplySync_OutOfOrder delivers a writer's v3 before its v2, as
+// concurrent broadcasts can. v3 must wait for v2, and both must be applied.
+func TestApplySync_OutOfOrder(t *testing.T) {
+	writer := newVersionedGroup(1)
+	var msgs []Message
+	for _, userID := range []string{"bob", "carol"} {
+		writer.Pending[userID] = true
+		writer.Version++
+		msgs = append(msgs, groupSync(writer, "sync_join_group", []string{"g1", userID}))
+	}
+	installGroup(t, newVersionedGroup(1))
+
+	applySync(msgs[1])
+	mu.RLock()
+	early := groups["g1"].Pending["carol"]
+	mu.RUnlock()
+	if early {
+		t.Error("v3 applied before v2")
+	}
+
+	applySync(msgs[0])
+	mu.RLock()
+	defer mu.RUnlock()
+	g := groups["g1"]
+	if g.Version != 3 || groupHash(g) != groupHash(writer) {
+		t.Errorf("got pending=%v at v%d, want %v at v3", g.Pending, g.Version, writer.Pending)
+	}
+	if n := len(heldGroupOps["g1"]); n != 0 {
+		t.Errorf("%d ops still held", n)
+	}
+}
+
+// TestApplySync_GapNeverFilled checks an op held for a version no tracker
+// can provide is applied after groupGapWait instead of being lost.
+func TestApplySync_GapNeverFilled(t *testing.T) {
+	saved := syncPeers()
+	setSyncPeers(nil)
+	groupGapWait = 10 * time.Millisecond
+	t.Cleanup(func() {
+		setSyncPeers(saved)
+		groupGapWait = 5 * time.Second
+	})
+	installGroup(t, newVersionedGroup(2))
+
+	applySync(Message{Cmd: "sync_join_group", Args: []string{"g1", "bob"}, Version: 4, Hash: "x"})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.RLock()
+		pending, version := groups["g1"].Pending["bob"], groups["g1"].Version
+		mu.RUnlock()
+		if pending && version == 4 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got pending=%v version=%d", pending, version)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestApplySync_DuplicateIsNoop verifies a re-delivered update doesn't change anything.
+func TestApplySync_DuplicateIsNoop(t *testing.T) {
+	g := newVersionedGroup(3)
+	g.Pending["bob"] = true
+	installGroup(t, g)
+	msg := groupSync(g, "sync_leave_group", []string{"g1", "alice"})
+
+	applySync(msg)
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if !groups["g1"].Members["alice"] {
+		t.Error("duplicate (same version and hash) must not be re-applied")
+	}
+}
+
+// TestApplySync_UnversionedStillApplies verifies messages from trackers that
+// don't send versions keep working.
+func TestApplySync_UnversionedStillApplies(t *testing.T) {
+	installGroup(t, newVersionedGroup(7))
+
+	applySync(Message{Cmd: "sync_join_group", Args: []string{"g1", "bob"}})
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if !groups["g1"].Pending["bob"] || groups["g1"].Version != 7 {
+		t.Errorf("got pending=%v version=%d", groups["g1"].Pending, groups["g1"].Version)
+	}
+}
+
+// TestCreateUserConflict verifies two trackers creating the same user at once
+// settle on the same password whichever order the syncs arrive in.
+func TestCreateUserConflict(t *testing.T) {
+	a := &User{UserID: "dave", Password: "pw-a", Version: 1}
+	b := &User{UserID: "dave", Password: "pw-b", Version: 1}
+	msgA := Message{Cmd: "sync_create_user", Args: []string{"dave", "pw-a"}, Version: 1, Hash: userHash(a)}
+	msgB := Message{Cmd: "sync_create_user", Args: []string{"dave", "pw-b"}, Version: 1, Hash: userHash(b)}
+
+	mu.Lock()
+	users = map[string]*User{"dave": {UserID: "dave", Password: "pw-a", Version: 1}}
+	mu.Unlock()
+	applySync(msgB)
+	mu.RLock()
+	onA := users["dave"].Password
+	mu.RUnlock()
+
+	mu.Lock()
+	users = map[string]*User{"dave": {UserID: "dave", Password: "pw-b", Version: 1}}
+	mu.Unlock()
+	applySync(msgA)
+	mu.RLock()
+	onB := users["dave"].Password
+	mu.RUnlock()
+
+	if onA != onB {
+		t.Errorf("trackers diverged: %q vs %q", onA, onB)
+	}
+}
+
+// TestMergeState_PrefersHigherVersion verifies rejoin replaces older local
+// entries and keeps newer ones, instead of never overwriting.
+func TestMergeState_PrefersHigherVersion(t *testing.T) {
+	older := newVersionedGroup(1)
+	newer := newVersionedGroup(3)
+	newer.Members["bob"] = true
+
+	mu.Lock()
+	users = map[string]*User{"alice": {UserID: "alice", Password: "old", Version: 2, LoggedIn: true, Addr: "127.0.0.1:5000"}}
+	groups = map[string]*Group{"g1": older}
+	replaceFiles(map[string]*File{})
+	mu.Unlock()
+
+	mergeState(SyncSnapshot{
+		Users:  map[string]*User{"alice": {UserID: "alice", Password: "new", Version: 1}},
+		Groups: map[string]*Group{"g1": newer},
+	})
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if users["alice"].Password != "old" {
+		t.Error("older snapshot user must not overwrite local")
+	}
+	if !groups["g1"].Members["bob"] || groups["g1"].Version != 3 {
+		t.Error("newer snapshot group must replace local")
+	}
+}
+
+// TestAcceptJoinRace delivers a join and the accept for it to this tracker
+// from two goroutines at once, as happens when the accepting tracker's
+// broadcast overtakes the joining tracker's. Whichever lands first, bob must
+// end up a member and not pending again.
+func TestAcceptJoinRace(t *testing.T) {
+	// Unversioned, as from older trackers, so the version check can't order them
+	join := Message{Cmd: "sync_join_group", Args: []string{"g1", "bob"}}
+	accept := Message{Cmd: "sync_accept_request", Args: []string{"g1", "bob"}}
+
+	for round := 0; round < 200; round++ {
+		installGroup(t, newVersionedGroup(1))
+		msgs := []Message{accept, join}
+		if round%2 == 1 {
+			msgs[0], msgs[1] = join, accept
+		}
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for _, msg := range msgs {
+			wg.Add(1)
+			go func(m Message) {
+				defer wg.Done()
+				<-start
+				applySync(m)
+			}(msg)
+		}
+		close(start)
+		wg.Wait()
+
+		mu.RLock()
+		g := groups["g1"]
+		member, pending := g.Members["bob"], g.Pending["bob"]
+		mu.RUnlock()
+		if !member || pending {
+			t.Fatalf("round %d: member=%v pending=%v, want member and not pending", round, member, pending)
+		}
+	}
+}
+
+// TestRecentlyAccepted_Window verifies a late join is only ignored within
+// acceptCommitWindow of the accept.
+func TestRecentlyAccepted_Window(t *testing.T) {
+	g := newVersionedGroup(1)
+	g.Pending["bob"] = true
+	accepted := time.Now()
+	commitAccept(g, "bob", accepted)
+
+	if g.Pending["bob"] || !g.Members["bob"] {
+		t.Fatalf("accept not applied: %+v", g)
+	}
+	if !recentlyAccepted(g, "bob", accepted.Add(acceptCommitWindow)) {
+		t.Error("join at the end of the window should be ignored")
+	}
+	if recentlyAccepted(g, "bob", accepted.Add(acceptCommitWindow+time.Second)) {
+		t.Error("join after the window should be allowed")
+	}
+	if recentlyAccepted(g, "carol", accepted) {
+		t.Error("carol was never accepted")
+	}
+
+	// Old commits are dropped when the next one is made
+	commitAccept(g, "carol", accepted.Add(2*acceptCommitWindow))
+	if _, ok := g.PendingHistory["bob"]; ok {
+		t.Error("expired commit for bob was kept")
+	}
+}
+
+// TestGroupHash_IgnoresPendingHistory verifies the per-tracker commit times
+// don't make otherwise identical groups look conflicting.
+func TestGroupHash_IgnoresPendingHistory(t *testing.T) {
+	a, b := newVersionedGroup(2), newVersionedGroup(2)
+	commitAccept(a, "bob", time.Now())
+	commitAccept(b, "bob", time.Now().Add(time.Second))
+	if groupHash(a) != groupHash(b) {
+		t.Error("commit times changed the group hash")
+	}
+}
+
+// TestLeaveThenRejoin checks a member who is accepted, leaves and asks to
+// rejoin within acceptCommitWindow is pending again, here and on the
+// trackers the syncs reach.
+func TestLeaveThenRejoin(t *testing.T) {
+	installGroup(t, newVersionedGroup(1))
+	remote := newVersionedGroup(1)
+	replay := func(msg Message) {
+		mu.Lock()
+		groups["g1"], remote = remote, groups["g1"]
+		mu.Unlock()
+		applySync(msg)
+		mu.Lock()
+		groups["g1"], remote = remote, groups["g1"]
+		mu.Unlock()
+	}
+
+	for _, step := range []struct {
+		cmd string
+		run func([]string) Response
+	}{
+		{"sync_join_group", joinGroup},
+		{"sync_accept_request", func([]string) Response { return acceptRequest([]string{"g1", "alice", "bob"}) }},
+		{"sync_leave_group", leaveGroup},
+		{"sync_join_group", joinGroup},
+	} {
+		if resp := step.run([]string{"g1", "bob"}); resp.Status != "ok" {
+			t.Fatalf("%s: %+v", step.cmd, resp)
+		}
+		mu.RLock()
+		msg := groupSync(groups["g1"], step.cmd, []string{"g1", "bob"})
+		mu.RUnlock()
+		replay(msg)
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for name, g := range map[string]*Group{"local": groups["g1"], "remote": remote} {
+		if !g.Pending["bob"] || g.Members["bob"] {
+			t.Errorf("%s: pending=%v members=%v, want bob pending again", name, g.Pending, g.Members)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"p2p/common"
+	"reflect"
+	"testing"
+)
+
+// sameOverMsgPack checks v decodes from MsgPack into a value of type T
+// just as it does from JSON.
+func sameOverMsgPack[T any](t *testing.T, name string, v any) {
+	t.Helper()
+	var decoded [2]T
+	for i, f := range []common.WireFormat{common.WireJSON, common.WireMsgPack} {
+		data, err := common.MarshalFrame(f, v)
+		if err == nil {
+			err = common.UnmarshalFrame(data, &decoded[i])
+		}
+		if err != nil {
+			t.Fatalf("%s in %s: %v", name, f, err)
+		}
+	}
+	if !reflect.DeepEqual(decoded[0], decoded[1]) {
+		t.Errorf("%s: MsgPack decoded %+v, JSON %+v", name, decoded[1], decoded[0])
+	}
+}
+
+// TestMsgPack_TrackerMessages sends requests and the responses handlers
+// give for them through MsgPack, as a client decodes them.
+func TestMsgPack_TrackerMessages(t *testing.T) {
+	resetGroupState(t, "alice", "bob")
+	seedUsers("alice", "bob")
+	seedFiles(t, "a.txt", "b.txt")
+	if resp := updateFileChunks([]string{"hash-a.txt", "alice", "4", layoutJSON(t, 10, 4), "g1"}); resp.Status != "ok" {
+		t.Fatalf("update_file_chunks: %+v", resp)
+	}
+
+	for _, msg := range []Message{
+		{Cmd: "upload_file", Args: []string{"a.txt", "g1", "alice", "10"}, Mux: true},
+		{Cmd: "sync_patch_file", Args: []string{"g1:a.txt", "{}"}, Version: 3, Hash: "h"},
+		{Cmd: "list_files", Args: []string{"g1"}, Stream: true},
+	} {
+		sameOverMsgPack[Message](t, msg.Cmd, msg)
+	}
+	for name, resp := range map[string]Response{
+		"get_file_info":  getFileInfo([]string{"g1", "a.txt", "alice"}),
+		"get_group_info": getGroupInfo([]string{"g1"}),
+		"list_files":     listFiles([]string{"g1"}),
+		"list_groups":    listGroups(nil),
+		"list_commands":  listCommands(nil),
+		"sync_pull":      syncPull(),
+		"error":          {"error", "file not found"},
+	} {
+		if resp.Status == "" {
+			t.Fatalf("%s gave no response", name)
+		}
+		sameOverMsgPack[Response](t, name, resp)
+	}
+	sameOverMsgPack[SyncSnapshot](t, "snapshot", syncPull().Data)
+}